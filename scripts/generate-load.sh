@@ -3,6 +3,10 @@
 # LOAD GENERATOR SCRIPT
 # =============================================================================
 # Generates sample traffic for testing observability.
+#
+# When the order service answers 503 (starting, maintenance, overloaded,
+# database down), the generator backs off for the Retry-After it returned
+# instead of hammering a degraded service.
 # =============================================================================
 
 echo "=== Load Generator ==="
@@ -19,6 +23,10 @@ USER_URL="http://localhost:8004"
 # Counter
 count=0
 
+# Headers of the last order creation, to honour Retry-After
+HEADERS_FILE=$(mktemp)
+trap 'rm -f "$HEADERS_FILE"' EXIT
+
 while true; do
     count=$((count + 1))
     echo "Request batch #$count"
@@ -39,10 +47,19 @@ while true; do
             "customer_name": "Test Customer",
             "customer_email": "test@example.com",
             "items": [{"sku": "SKU-LAPTOP-001", "name": "Laptop", "quantity": 1, "unit_price": 1000}]
-        }' > /dev/null &
+        }' -D "$HEADERS_FILE" -o /dev/null &
     
     # Wait for requests to complete
     wait
+
+    # Back off while the order service is degraded
+    if head -1 "$HEADERS_FILE" | grep -q " 503"; then
+        retry_after=$(grep -i '^Retry-After:' "$HEADERS_FILE" | tr -dc '0-9')
+        reason=$(grep -i '^X-Degradation-Reason:' "$HEADERS_FILE" | cut -d' ' -f2 | tr -d '\r')
+        echo "Order service degraded (${reason:-unknown}), backing off ${retry_after:-5}s"
+        sleep "${retry_after:-5}"
+        continue
+    fi
     
     # Random delay between 0.5 and 2 seconds
     sleep $(echo "scale=2; 0.5 + $RANDOM/32767 * 1.5" | bc)
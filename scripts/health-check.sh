@@ -35,7 +35,7 @@ check_service() {
 
 # Check all services
 echo "Microservices:"
-check_service "Order Service" "http://localhost:8001/health"
+check_service "Order Service" "http://localhost:9001/health"
 check_service "Inventory Service" "http://localhost:8002/health"
 check_service "Payment Service" "http://localhost:8003/health"
 check_service "User Service" "http://localhost:8004/health"
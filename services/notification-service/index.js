@@ -380,7 +380,7 @@ async function connectRabbitMQ() {
     rabbitChannel.consume(queueName, async (msg) => {
       if (msg) {
         try {
-          const event = JSON.parse(msg.content.toString());
+          const event = parseOrderEvent(JSON.parse(msg.content.toString()));
           await handleOrderEvent(event);
           rabbitChannel.ack(msg);
         } catch (err) {
@@ -405,6 +405,19 @@ async function connectRabbitMQ() {
   }
 }
 
+/**
+ * Unwrap an order event published as a CloudEvent (structured mode),
+ * falling back to the plain JSON events of EVENT_FORMAT=json
+ * @param {object} message - Parsed message body
+ * @returns {object} Order event with event and order_id
+ */
+function parseOrderEvent(message) {
+  if (message.specversion && message.data) {
+    return { ...message.data, event: message.type, order_id: message.subject || message.data.order_id };
+  }
+  return message;
+}
+
 /**
  * Handle an order event from RabbitMQ
  * @param {object} event - Order event
@@ -0,0 +1,183 @@
+// =============================================================================
+// ADDRESS BOOK
+// =============================================================================
+// Instead of inlining shipping_address, a create order request can name
+// one of the customer's saved addresses:
+//
+//   {"customer_id": "...", "shipping_address_id": "home", "items": [...]}
+//
+// The address is read from the user service's address book
+// (GET /api/v1/users/:customer_id/addresses/:address_id) and snapshotted
+// into the order's shipping_address at creation time, so later edits of the
+// address book never move an order that is already on its way. The ID is
+// kept next to it (shipping_address_id on the order) to trace the snapshot
+// back to its source.
+//
+// Lookups are cached in Redis for ADDRESS_CACHE_TTL. A saved address that
+// doesn't exist rejects the order (422); if the user service can't be
+// reached within ADDRESS_LOOKUP_TIMEOUT and the address isn't cached, the
+// order is refused with 503, since it can't be shipped without an address.
+// shipping_address and shipping_address_id can't be combined.
+//
+// METRICS:
+// - address_lookups_total{result}   cache_hit, fetched, not_found, error
+// =============================================================================
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// SavedAddress is an address of the user service's address book
+// (camelCase, like its Jackson serialization)
+type SavedAddress struct {
+	ID            string `json:"id"`
+	Label         string `json:"label,omitempty"`
+	RecipientName string `json:"recipientName,omitempty"`
+	Line1         string `json:"line1"`
+	Line2         string `json:"line2,omitempty"`
+	PostalCode    string `json:"postalCode,omitempty"`
+	City          string `json:"city"`
+	Region        string `json:"region,omitempty"`
+	Country       string `json:"country"`
+}
+
+// String formats the address on one line, country last (see geo.go)
+func (s SavedAddress) String() string {
+	city := strings.TrimSpace(s.PostalCode + " " + s.City)
+	var parts []string
+	for _, part := range []string{s.RecipientName, s.Line1, s.Line2, city, s.Region, s.Country} {
+		if part = strings.TrimSpace(part); part != "" {
+			parts = append(parts, part)
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// errUnknownAddress is returned for addresses the address book doesn't have
+var errUnknownAddress = errors.New("unknown saved address")
+
+var (
+	// addressCacheTTL is how long looked up addresses are cached in Redis
+	addressCacheTTL = 10 * time.Minute
+
+	// addressLookupTimeout bounds the lookup of one address
+	addressLookupTimeout = 2 * time.Second
+
+	// Counter: Saved address lookups by result
+	addressLookupsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "address_lookups_total",
+			Help: "Total number of saved address lookups in the user service's address book, by result",
+		},
+		[]string{"result"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(addressLookupsTotal)
+}
+
+// lookupAddress returns a saved address of a customer, from the cache if
+// possible. It returns errUnknownAddress if the customer has no such
+// address.
+func (a *App) lookupAddress(ctx context.Context, customerID, addressID string) (SavedAddress, error) {
+	key := cacheKeyPrefix + "address:" + customerID + ":" + addressID
+	if data, err := a.redisClient.Get(ctx, key).Bytes(); err == nil {
+		var addr SavedAddress
+		if json.Unmarshal(data, &addr) == nil {
+			addressLookupsTotal.WithLabelValues("cache_hit").Inc()
+			return addr, nil
+		}
+	} else if !errors.Is(err, redis.Nil) {
+		logDebugContext(ctx, "Address cache unavailable", map[string]interface{}{"error": err.Error()})
+	}
+
+	lookupCtx, cancel := context.WithTimeout(ctx, addressLookupTimeout)
+	defer cancel()
+	addr, err := a.fetchAddress(lookupCtx, customerID, addressID)
+	switch {
+	case errors.Is(err, errUnknownAddress):
+		addressLookupsTotal.WithLabelValues("not_found").Inc()
+		return SavedAddress{}, err
+	case err != nil:
+		addressLookupsTotal.WithLabelValues("error").Inc()
+		return SavedAddress{}, err
+	}
+	addressLookupsTotal.WithLabelValues("fetched").Inc()
+
+	if data, err := json.Marshal(addr); err == nil {
+		a.redisClient.Set(ctx, key, data, addressCacheTTL)
+	}
+	return addr, nil
+}
+
+// fetchAddress reads a saved address from the user service
+func (a *App) fetchAddress(ctx context.Context, customerID, addressID string) (SavedAddress, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet,
+		a.userServiceURL+"/api/v1/users/"+url.PathEscape(customerID)+"/addresses/"+url.PathEscape(addressID), nil)
+	if err != nil {
+		return SavedAddress{}, err
+	}
+
+	resp, err := a.httpClient.Do(req)
+	if err != nil {
+		return SavedAddress{}, fmt.Errorf("user service unreachable: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return SavedAddress{}, errUnknownAddress
+	default:
+		return SavedAddress{}, fmt.Errorf("user service returned status %d", resp.StatusCode)
+	}
+
+	var addr SavedAddress
+	if err := json.NewDecoder(resp.Body).Decode(&addr); err != nil {
+		return SavedAddress{}, fmt.Errorf("invalid user service response: %w", err)
+	}
+	if addr.String() == "" {
+		return SavedAddress{}, fmt.Errorf("user service returned an empty address")
+	}
+	return addr, nil
+}
+
+// resolveShippingAddress snapshots the saved address of an order request
+// into its shipping_address. It returns the HTTP status and untranslated
+// error if the order can't be placed.
+func (a *App) resolveShippingAddress(ctx context.Context, req *CreateOrderRequest) (int, string) {
+	if req.AddressID == "" {
+		return 0, ""
+	}
+	if req.ShippingAddress != "" {
+		return http.StatusBadRequest, "Use either shipping_address or shipping_address_id"
+	}
+
+	addr, err := a.lookupAddress(ctx, req.CustomerID, req.AddressID)
+	if errors.Is(err, errUnknownAddress) {
+		return http.StatusUnprocessableEntity, "Saved address not found"
+	}
+	if err != nil {
+		logWarnContext(ctx, "Saved address lookup failed", map[string]interface{}{
+			"customer_id": req.CustomerID,
+			"address_id":  req.AddressID,
+			"error":       err.Error(),
+		})
+		return http.StatusServiceUnavailable, "Address book is unavailable, please retry later"
+	}
+	req.ShippingAddress = addr.String()
+	return 0, ""
+}
@@ -0,0 +1,278 @@
+// =============================================================================
+// ADMIN API
+// =============================================================================
+// Operational endpoints under /admin, so routine tasks don't require
+// kubectl exec plus psql/redis-cli.
+//
+// ENDPOINTS:
+// - /admin/maintenance            Maintenance mode (see maintenance.go)
+// - GET  /admin/pool              Database and Redis connection pool stats
+// - POST /admin/cache/flush       Delete the service's Redis cache keys
+// - GET  /admin/queues            Depth and consumer count of watched queues
+// - GET  /admin/dead-letters      Retrying and dead-lettered events (see deadletters.go)
+// - GET  /admin/circuit-breakers  State of downstream circuit breakers (see breaker.go)
+// - GET  /admin/rate-limits       Rate limit consumption per client (see ratelimit.go)
+// - POST /admin/drain             Fail readiness so the LB stops routing here
+// - POST /admin/undrain           Resume receiving traffic
+// - POST /admin/migrations        Re-run database migrations
+// - GET  /admin/config            Configuration, secrets redacted (see config.go)
+// - GET  /admin/selftest          In-process latency of the hot paths
+// - /admin/chaos                  Fault injection rules (see chaos.go)
+// - /admin/synthetic-errors       Standing error rate (see syntheticerrors.go)
+// - /admin/latency-profiles       Baseline latency per route (see latencyprofiles.go)
+// - /admin/payload-logging        Sanitized body logging for debugging (see payloadlog.go)
+// - /admin/log-level              Log levels, changed at runtime (see logging.go)
+// - /admin/heartbeat              Dead man's switch heartbeat (see deadman.go)
+// - GET /admin/deprecations       Deprecated routes and their usage (see deprecations.go)
+// - POST /admin/seed              Seed demo data, dev profile only (see seed.go)
+// - /admin/scenarios              Scripted incident timelines (see scenarios.go)
+// - /admin/outages                Simulated dependency outages (see outages.go)
+// - /admin/slow-dependencies      Simulated dependency latency (see slowdeps.go)
+// - /admin/recording              Request recording for replay (see recorder.go)
+// - POST /admin/reset             Wipe order data for a new session (see reset.go)
+// - /admin/stress                 CPU and memory stress runs (see stress.go)
+// - /admin/jobs                   Scheduled jobs (see jobs.go)
+// - /admin/receipts               Order receipt previews (see receipts.go)
+// - GET /admin/reconciliation     Latest payment reconciliation (see reconcile.go)
+// - /admin/deliveries             Failed outbound deliveries (see deliveries.go)
+// - /admin/archives               Order archives in object storage (see archive.go)
+// - POST /admin/projections/rebuild  Rebuild orders from events (see eventstore.go)
+// - /admin/reviews                Orders flagged as likely duplicates (see duplicates.go)
+// - POST /admin/orders/:id/cancel  Cancel past the cancellation window (see cancelpolicy.go)
+// - POST /admin/orders/recalculate  Bulk price recalculation (see repricing.go)
+// - /admin/outbox                 Events waiting to be published (see outbox.go)
+// - POST /admin/events/replay     Replay order events through the outbox (see eventreplay.go)
+//
+// The same token also guards two routes outside /admin on the internal
+// port (see internal.go):
+// - /debug/pprof/*                Go profiling
+// - POST /quitquitquit            Pre-stop drain hook (see lifecycle.go)
+//
+// SECURITY:
+// Every admin request must carry the ADMIN_TOKEN, either as
+// "Authorization: Bearer <token>" or in the X-Admin-Token header.
+// If ADMIN_TOKEN is not set, the admin API is disabled entirely.
+// =============================================================================
+
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// cacheKeyPrefix namespaces every Redis key owned by this service.
+// Redis is shared with other services, so we never FLUSHDB.
+const cacheKeyPrefix = "order-service:"
+
+// stateKeyPrefix namespaces the Redis keys holding state rather than
+// cached copies (duplicate fingerprints), which a cache flush must keep
+const stateKeyPrefix = "order-service-state:"
+
+var (
+	// adminToken is the shared secret required by the admin API
+	adminToken string
+
+	// watchedQueues are the RabbitMQ queues reported by /admin/queues
+	watchedQueues []string
+
+	// draining makes the readiness probe fail while set
+	draining atomic.Bool
+
+	// circuitBreakers lists the breakers reported by /admin/circuit-breakers
+	circuitBreakers []breakerStateReporter
+)
+
+// breakerStateReporter is implemented by anything that can report
+// a circuit breaker state to the admin API.
+type breakerStateReporter interface {
+	Name() string
+	State() string
+}
+
+// breakerDetailsReporter is implemented by breakers that also report
+// their counts
+type breakerDetailsReporter interface {
+	Details() gin.H
+}
+
+// adminAuthMiddleware rejects requests without a valid admin token
+func adminAuthMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if adminToken == "" {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+				"error": "Admin API is disabled (ADMIN_TOKEN not set)",
+			})
+			return
+		}
+
+		token := c.GetHeader("X-Admin-Token")
+		if auth := c.GetHeader("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+			token = strings.TrimPrefix(auth, "Bearer ")
+		}
+
+		// Constant-time compare so the token can't be guessed byte by byte
+		if subtle.ConstantTimeCompare([]byte(token), []byte(adminToken)) != 1 {
+			logWarn("Rejected admin request", map[string]interface{}{
+				"path":      c.Request.URL.Path,
+				"client_ip": c.ClientIP(),
+			})
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// =============================================================================
+// ADMIN HANDLERS
+// =============================================================================
+
+// getPoolStats returns database and Redis connection pool statistics
+func (a *App) getPoolStats(c *gin.Context) {
+	dbStats := a.db.Stats()
+	redisStats := a.redisClient.PoolStats()
+
+	c.JSON(http.StatusOK, gin.H{
+		"database": gin.H{
+			"max_open_connections": dbStats.MaxOpenConnections,
+			"open_connections":     dbStats.OpenConnections,
+			"in_use":               dbStats.InUse,
+			"idle":                 dbStats.Idle,
+			"wait_count":           dbStats.WaitCount,
+			"wait_duration_ms":     dbStats.WaitDuration.Milliseconds(),
+			"max_idle_closed":      dbStats.MaxIdleClosed,
+			"max_lifetime_closed":  dbStats.MaxLifetimeClosed,
+		},
+		"redis": gin.H{
+			"hits":        redisStats.Hits,
+			"misses":      redisStats.Misses,
+			"timeouts":    redisStats.Timeouts,
+			"total_conns": redisStats.TotalConns,
+			"idle_conns":  redisStats.IdleConns,
+			"stale_conns": redisStats.StaleConns,
+		},
+	})
+}
+
+// flushCache deletes all Redis keys owned by this service
+func (a *App) flushCache(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 30*time.Second)
+	defer cancel()
+
+	deleted, err := a.deleteCacheKeys(ctx)
+	if err != nil {
+		logErrorContext(c.Request.Context(), "Failed to scan cache keys", map[string]interface{}{
+			"error": err.Error(),
+		})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Cache flush failed", "deleted": deleted})
+		return
+	}
+
+	logInfoContext(c.Request.Context(), "Cache flushed", map[string]interface{}{
+		"deleted": deleted,
+	})
+
+	c.JSON(http.StatusOK, gin.H{"message": "Cache flushed", "deleted": deleted})
+}
+
+// deleteCacheKeys deletes every Redis key with the service prefix and
+// returns how many were deleted
+func (a *App) deleteCacheKeys(ctx context.Context) (int64, error) {
+	var deleted int64
+	iter := a.redisClient.Scan(ctx, 0, cacheKeyPrefix+"*", 500).Iterator()
+	for iter.Next(ctx) {
+		n, err := a.redisClient.Del(ctx, iter.Val()).Result()
+		if err != nil {
+			logErrorContext(ctx, "Failed to delete cache key", map[string]interface{}{
+				"key":   iter.Val(),
+				"error": err.Error(),
+			})
+			continue
+		}
+		deleted += n
+	}
+	return deleted, iter.Err()
+}
+
+// getQueueDepths reports message and consumer counts of watched queues
+func (a *App) getQueueDepths(c *gin.Context) {
+	if !a.rabbitConnected() {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "RabbitMQ is not connected"})
+		return
+	}
+	if err := outages.check("rabbitmq"); err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+		return
+	}
+
+	queues := make([]gin.H, 0, len(watchedQueues))
+	for _, name := range watchedQueues {
+		q, err := a.inspectQueue(name)
+		if err != nil {
+			queues = append(queues, gin.H{"name": name, "error": err.Error()})
+			continue
+		}
+		queues = append(queues, gin.H{
+			"name":      q.Name,
+			"messages":  q.Messages,
+			"consumers": q.Consumers,
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{"queues": queues})
+}
+
+// getCircuitBreakers reports the state of every registered circuit breaker
+func getCircuitBreakers(c *gin.Context) {
+	breakers := make([]gin.H, 0, len(circuitBreakers))
+	for _, b := range circuitBreakers {
+		breaker := gin.H{"name": b.Name(), "state": b.State()}
+		if d, ok := b.(breakerDetailsReporter); ok {
+			for k, v := range d.Details() {
+				breaker[k] = v
+			}
+		}
+		breakers = append(breakers, breaker)
+	}
+	c.JSON(http.StatusOK, gin.H{"circuit_breakers": breakers})
+}
+
+// drain makes the readiness probe fail so the load balancer stops
+// sending new traffic to this instance
+func drain(c *gin.Context) {
+	draining.Store(true)
+	logWarnContext(c.Request.Context(), "Instance draining", nil)
+	c.JSON(http.StatusOK, gin.H{"message": "Instance draining", "draining": true})
+}
+
+// undrain makes the instance ready to receive traffic again
+func undrain(c *gin.Context) {
+	draining.Store(false)
+	logInfoContext(c.Request.Context(), "Instance undrained", nil)
+	c.JSON(http.StatusOK, gin.H{"message": "Instance accepting traffic", "draining": false})
+}
+
+// rerunMigrations runs the (idempotent) database migrations again
+func (a *App) rerunMigrations(c *gin.Context) {
+	start := time.Now()
+	if err := a.runMigrations(); err != nil {
+		logErrorContext(c.Request.Context(), "Failed to re-run migrations", map[string]interface{}{
+			"error": err.Error(),
+		})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":     "Migrations completed",
+		"duration_ms": time.Since(start).Milliseconds(),
+	})
+}
@@ -0,0 +1,150 @@
+// =============================================================================
+// GRAFANA ANNOTATIONS
+// =============================================================================
+// Operational events are posted to the Grafana HTTP API as annotations, so
+// they show up on the lab's dashboards next to the graphs they explain:
+//
+// - deploy        The service finished starting (version and profile)
+// - config        Runtime configuration changed through the admin API
+// - scenario      An incident scenario started, stopped or completed
+// - maintenance   Maintenance mode was switched on or off
+// - outage        A simulated dependency outage started or ended
+// - slowdown      A simulated dependency slowdown was set
+// - reset         The demo environment was reset
+//
+// Set GRAFANA_URL (e.g. http://grafana:3000) and GRAFANA_API_TOKEN (a
+// service account token with the annotations:write permission) to enable
+// it. Every annotation is tagged with the service name, the event kind and
+// the GRAFANA_ANNOTATION_TAGS.
+//
+// Annotations are sent by a background worker: a slow or unreachable
+// Grafana never delays the request that triggered the event. Events are
+// dropped (and counted) when the queue is full.
+// =============================================================================
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// grafanaAnnotation is the body of POST /api/annotations
+type grafanaAnnotation struct {
+	Time int64    `json:"time"`
+	Tags []string `json:"tags"`
+	Text string   `json:"text"`
+}
+
+var (
+	// grafanaURL is the Grafana base URL; empty disables annotations
+	grafanaURL string
+
+	// grafanaToken authenticates against the Grafana HTTP API
+	grafanaToken string
+
+	// grafanaTags are added to every annotation
+	grafanaTags []string
+
+	// annotationQueue buffers annotations for the background worker
+	annotationQueue = make(chan grafanaAnnotation, 100)
+
+	// annotationClient is separate from httpClient so simulated outages
+	// and slow downstream services don't affect annotations
+	annotationClient = &http.Client{Timeout: 5 * time.Second}
+
+	// Counter: Annotations posted to Grafana, by kind and result
+	grafanaAnnotationsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "grafana_annotations_total",
+			Help: "Total number of annotations posted to Grafana",
+		},
+		[]string{"kind", "result"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(grafanaAnnotationsTotal)
+}
+
+// annotate queues an annotation of the given kind, without blocking
+func annotate(kind, format string, args ...interface{}) {
+	if grafanaURL == "" {
+		return
+	}
+
+	tags := append([]string{"order-service", kind}, grafanaTags...)
+	a := grafanaAnnotation{
+		Time: time.Now().UnixMilli(),
+		Tags: tags,
+		Text: fmt.Sprintf(format, args...),
+	}
+
+	select {
+	case annotationQueue <- a:
+	default:
+		grafanaAnnotationsTotal.WithLabelValues(kind, "dropped").Inc()
+	}
+}
+
+// startAnnotationWorker posts queued annotations until shutdown
+func startAnnotationWorker() {
+	if grafanaURL == "" {
+		return
+	}
+
+	go func() {
+		for {
+			select {
+			case <-backgroundCtx.Done():
+				return
+			case a := <-annotationQueue:
+				kind := a.Tags[1]
+				if err := postAnnotation(a); err != nil {
+					grafanaAnnotationsTotal.WithLabelValues(kind, "error").Inc()
+					logWarn("Failed to post Grafana annotation", map[string]interface{}{
+						"kind":  kind,
+						"error": err.Error(),
+					})
+					continue
+				}
+				grafanaAnnotationsTotal.WithLabelValues(kind, "success").Inc()
+			}
+		}
+	}()
+}
+
+// postAnnotation sends one annotation to the Grafana HTTP API
+func postAnnotation(a grafanaAnnotation) error {
+	body, err := json.Marshal(a)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(backgroundCtx, http.MethodPost,
+		strings.TrimSuffix(grafanaURL, "/")+"/api/annotations", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if grafanaToken != "" {
+		req.Header.Set("Authorization", "Bearer "+grafanaToken)
+	}
+
+	resp, err := annotationClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("grafana returned status %d", resp.StatusCode)
+	}
+	return nil
+}
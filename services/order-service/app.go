@@ -0,0 +1,104 @@
+// =============================================================================
+// APPLICATION
+// =============================================================================
+// App holds the service's dependencies: the PostgreSQL pool, the Redis
+// client, the RabbitMQ connection and channel, and the URLs and HTTP
+// client used to call the other services. Handlers, jobs and workers are
+// methods on *App and reach the dependencies through it rather than
+// through package-level connections:
+//
+//   app := NewApp(AppDeps{DB: db, Redis: redisClient, PaymentURL: config.PaymentURL})
+//   router.GET("/orders/:id", app.getOrder)
+//
+// main builds the App from the config and lets connectDependencies open
+// the connections, with retries, once the listeners are up.
+//
+// Process-wide state that isn't a dependency (metrics, chaos rules and
+// simulated outages, the event publish queue, settings applied from the
+// config) is still package-level.
+// =============================================================================
+
+package main
+
+import (
+	"database/sql"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// App is the order service with its dependencies
+type App struct {
+	// Database connection pool
+	db *sql.DB
+
+	// Redis client
+	redisClient *redis.Client
+
+	// RabbitMQ connection and publishing channel, replaced when the
+	// connection is re-established (see rabbitmq.go)
+	rabbitMu      sync.RWMutex
+	rabbitConn    *amqp.Connection
+	rabbitChannel *amqp.Channel
+
+	// Service URLs for inter-service communication
+	inventoryServiceURL    string
+	paymentServiceURL      string
+	userServiceURL         string
+	notificationServiceURL string
+
+	// HTTP client for inter-service communication
+	httpClient *http.Client
+}
+
+// AppDeps are the dependencies an App is built from. Connections left nil
+// are opened by connectDependencies.
+type AppDeps struct {
+	DB            *sql.DB
+	Redis         *redis.Client
+	RabbitConn    *amqp.Connection
+	RabbitChannel *amqp.Channel
+
+	InventoryURL    string
+	PaymentURL      string
+	UserURL         string
+	NotificationURL string
+
+	// HTTPClient defaults to a client with HTTPTimeout (10s if unset) that
+	// honours simulated outages of the other services and sends calls
+	// through their circuit breakers
+	HTTPClient  *http.Client
+	HTTPTimeout time.Duration
+}
+
+// NewApp builds an App from its dependencies
+func NewApp(deps AppDeps) *App {
+	a := &App{
+		db:                     deps.DB,
+		redisClient:            deps.Redis,
+		rabbitConn:             deps.RabbitConn,
+		rabbitChannel:          deps.RabbitChannel,
+		inventoryServiceURL:    deps.InventoryURL,
+		paymentServiceURL:      deps.PaymentURL,
+		userServiceURL:         deps.UserURL,
+		notificationServiceURL: deps.NotificationURL,
+		httpClient:             deps.HTTPClient,
+	}
+	if a.httpClient == nil {
+		timeout := deps.HTTPTimeout
+		if timeout <= 0 {
+			timeout = 10 * time.Second
+		}
+		var transport http.RoundTripper = outageTransport{app: a, next: http.DefaultTransport}
+		transport = breakerTransport{app: a, next: transport}
+		transport = retryTransport{app: a, next: transport}
+		a.httpClient = &http.Client{
+			Timeout:   timeout,
+			Transport: newTracingTransport(transport),
+		}
+	}
+	return a
+}
@@ -0,0 +1,522 @@
+// =============================================================================
+// ORDER ARCHIVES (S3 / MINIO)
+// =============================================================================
+// Archives orders, with their items, to an S3-compatible bucket for
+// downstream analytics and for offloading old data before it is deleted
+// from the database. Every run writes its objects under its own prefix:
+//
+//   {ARCHIVE_S3_PREFIX}/orders/{run_id}/part-00000.ndjson.gz
+//   {ARCHIVE_S3_PREFIX}/orders/{run_id}/part-00001.ndjson.gz
+//   {ARCHIVE_S3_PREFIX}/orders/{run_id}/manifest.json
+//
+// Parts hold up to ARCHIVE_PART_ROWS orders as NDJSON, compressed with
+// gzip or zstd (ARCHIVE_COMPRESSION). The manifest is uploaded last, so a
+// prefix without a manifest is an incomplete run that readers must ignore.
+// It lists every part with its row count, size and SHA-256, the filter
+// and the schema version.
+//
+// Only NDJSON is written: there is no Parquet encoder in this build, so
+// requests for parquet are rejected. Convert downstream if needed.
+//
+// SCHEDULE:
+// With ARCHIVE_SCHEDULE set (cron, UTC), the order-archive job archives
+// the previous day's orders. Runs can also be started from the admin API,
+// filtered like exports (status, from, to) or over every order.
+//
+// Only one archive runs at a time per instance; runs are recorded in
+// archive_runs.
+//
+// ENDPOINTS:
+// - POST /admin/archives  Start an archive run (202 Accepted)
+// - GET  /admin/archives  Recent archive runs
+// =============================================================================
+
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"order-service/objstore"
+
+	"github.com/gin-gonic/gin"
+	"github.com/klauspost/compress/zstd"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// archiveSchemaVersion is bumped whenever the archived record changes shape
+const archiveSchemaVersion = 1
+
+var (
+	// archiveStore is the bucket archives are written to (nil when disabled)
+	archiveStore *objstore.Client
+
+	// archivePrefix is prepended to every object key
+	archivePrefix = "order-service"
+
+	// archivePartRows is the maximum number of orders per object
+	archivePartRows = 50000
+
+	// archiveCompression is the default compression (gzip or zstd)
+	archiveCompression = "gzip"
+
+	// archiveRunning prevents two archive runs from overlapping
+	archiveRunning atomic.Bool
+
+	// Counter: Archived orders
+	archivedOrdersTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "archive_orders_total",
+			Help: "Total number of orders written to archive objects",
+		},
+	)
+
+	// Counter: Archive objects uploaded
+	archiveObjectsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "archive_objects_total",
+			Help: "Total number of archive object uploads, by result",
+		},
+		[]string{"result"},
+	)
+
+	// Counter: Bytes uploaded to the archive bucket
+	archiveBytesTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "archive_bytes_total",
+			Help: "Total number of compressed bytes uploaded to the archive bucket",
+		},
+	)
+
+	// Counter: Archive runs, by trigger and result
+	archiveRunsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "archive_runs_total",
+			Help: "Total number of archive runs finished",
+		},
+		[]string{"trigger", "result"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(archivedOrdersTotal)
+	prometheus.MustRegister(archiveObjectsTotal)
+	prometheus.MustRegister(archiveBytesTotal)
+	prometheus.MustRegister(archiveRunsTotal)
+}
+
+// ArchiveRun is the JSON view of an archive run
+type ArchiveRun struct {
+	ID          string     `json:"id"`
+	Trigger     string     `json:"trigger"`
+	Status      string     `json:"status"`
+	Filter      ExportSpec `json:"filter"`
+	Compression string     `json:"compression"`
+	Bucket      string     `json:"bucket"`
+	Prefix      string     `json:"prefix"`
+	Objects     int        `json:"objects"`
+	Rows        int64      `json:"rows"`
+	Bytes       int64      `json:"bytes"`
+	Error       string     `json:"error,omitempty"`
+	StartedAt   time.Time  `json:"started_at"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+}
+
+// ArchiveObject is a part listed in a manifest
+type ArchiveObject struct {
+	Key    string `json:"key"`
+	Rows   int64  `json:"rows"`
+	Bytes  int64  `json:"bytes"`
+	SHA256 string `json:"sha256"`
+}
+
+// ArchiveManifest describes a completed archive run
+type ArchiveManifest struct {
+	RunID         string          `json:"run_id"`
+	SchemaVersion int             `json:"schema_version"`
+	Format        string          `json:"format"`
+	Compression   string          `json:"compression"`
+	Filter        ExportSpec      `json:"filter"`
+	Rows          int64           `json:"rows"`
+	Objects       []ArchiveObject `json:"objects"`
+	CreatedAt     time.Time       `json:"created_at"`
+}
+
+// archiveEnabled reports whether an archive bucket is configured
+func archiveEnabled() bool {
+	return archiveStore != nil
+}
+
+// archivePart buffers one compressed NDJSON part
+type archivePart struct {
+	buf  bytes.Buffer
+	enc  *json.Encoder
+	w    io.WriteCloser
+	rows int64
+}
+
+// newArchivePart starts an empty part with the given compression
+func newArchivePart(compression string) (*archivePart, error) {
+	p := &archivePart{}
+	switch compression {
+	case "gzip":
+		p.w = gzip.NewWriter(&p.buf)
+	case "zstd":
+		w, err := zstd.NewWriter(&p.buf)
+		if err != nil {
+			return nil, err
+		}
+		p.w = w
+	default:
+		return nil, fmt.Errorf("unsupported compression %q", compression)
+	}
+	p.enc = json.NewEncoder(p.w)
+	return p, nil
+}
+
+// archiveExtension is the file extension of a part
+func archiveExtension(compression string) string {
+	if compression == "zstd" {
+		return ".ndjson.zst"
+	}
+	return ".ndjson.gz"
+}
+
+// runArchive archives the orders selected by the run's filter, recording
+// progress in archive_runs
+func (a *App) runArchive(ctx context.Context, run *ArchiveRun) error {
+	start := time.Now()
+	logInfoContext(ctx, "Archive run started", map[string]interface{}{
+		"archive_id":  run.ID,
+		"trigger":     run.Trigger,
+		"compression": run.Compression,
+		"prefix":      run.Prefix,
+	})
+
+	manifest, err := a.writeArchive(ctx, run)
+
+	result := "completed"
+	var errMsg sql.NullString
+	if err != nil {
+		result = "failed"
+		errMsg = sql.NullString{String: err.Error(), Valid: true}
+	}
+	archiveRunsTotal.WithLabelValues(run.Trigger, result).Inc()
+
+	// The run's context may be gone by now, the outcome must still be recorded
+	a.db.Exec(`
+		UPDATE archive_runs
+		SET status = $1, objects = $2, rows = $3, bytes = $4, error = $5, completed_at = NOW()
+		WHERE id = $6
+	`, result, run.Objects, run.Rows, run.Bytes, errMsg, run.ID)
+
+	if err != nil {
+		logErrorContext(ctx, "Archive run failed", map[string]interface{}{
+			"archive_id": run.ID,
+			"objects":    run.Objects,
+			"error":      err.Error(),
+		})
+		return err
+	}
+
+	logInfoContext(ctx, "Archive run completed", map[string]interface{}{
+		"archive_id":  run.ID,
+		"objects":     len(manifest.Objects),
+		"rows":        manifest.Rows,
+		"bytes":       run.Bytes,
+		"duration_ms": time.Since(start).Milliseconds(),
+	})
+	return nil
+}
+
+// writeArchive streams the selected orders into parts, uploads them and
+// finally uploads the manifest
+func (a *App) writeArchive(ctx context.Context, run *ArchiveRun) (*ArchiveManifest, error) {
+	query := `
+		SELECT o.id, o.customer_id, o.customer_name, o.customer_email, o.status,
+		       o.total_amount, o.currency, COALESCE(o.shipping_address, ''), COALESCE(o.notes, ''),
+		       o.created_at, o.updated_at,
+		       COALESCE((
+		           SELECT json_agg(json_build_object(
+		               'id', i.id, 'order_id', i.order_id, 'sku', i.sku, 'name', i.name,
+		               'quantity', i.quantity, 'unit_price', i.unit_price, 'total_price', i.total_price
+		           ) ORDER BY i.created_at)
+		           FROM order_items i WHERE i.order_id = o.id
+		       ), '[]')
+		FROM orders o WHERE 1=1`
+	var args []interface{}
+	if run.Filter.Status != "" {
+		args = append(args, run.Filter.Status)
+		query += fmt.Sprintf(" AND o.status = $%d", len(args))
+	}
+	if run.Filter.From != nil {
+		args = append(args, *run.Filter.From)
+		query += fmt.Sprintf(" AND o.created_at >= $%d", len(args))
+	}
+	if run.Filter.To != nil {
+		args = append(args, *run.Filter.To)
+		query += fmt.Sprintf(" AND o.created_at < $%d", len(args))
+	}
+	query += " ORDER BY o.created_at, o.id"
+
+	rows, err := a.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	manifest := &ArchiveManifest{
+		RunID:         run.ID,
+		SchemaVersion: archiveSchemaVersion,
+		Format:        "ndjson",
+		Compression:   run.Compression,
+		Filter:        run.Filter,
+		Objects:       []ArchiveObject{},
+	}
+
+	var part *archivePart
+	flush := func() error {
+		if part == nil || part.rows == 0 {
+			return nil
+		}
+		if err := part.w.Close(); err != nil {
+			return err
+		}
+		key := fmt.Sprintf("%s/part-%05d%s", run.Prefix, len(manifest.Objects), archiveExtension(run.Compression))
+		data := part.buf.Bytes()
+		if err := archiveStore.PutObject(ctx, key, data, objstore.PutOptions{
+			ContentType:     "application/x-ndjson",
+			ContentEncoding: run.Compression,
+		}); err != nil {
+			archiveObjectsTotal.WithLabelValues("failure").Inc()
+			return err
+		}
+		archiveObjectsTotal.WithLabelValues("success").Inc()
+		archiveBytesTotal.Add(float64(len(data)))
+		archivedOrdersTotal.Add(float64(part.rows))
+
+		sum := sha256.Sum256(data)
+		manifest.Objects = append(manifest.Objects, ArchiveObject{
+			Key:    key,
+			Rows:   part.rows,
+			Bytes:  int64(len(data)),
+			SHA256: hex.EncodeToString(sum[:]),
+		})
+		manifest.Rows += part.rows
+		run.Objects = len(manifest.Objects)
+		run.Rows = manifest.Rows
+		run.Bytes += int64(len(data))
+		part = nil
+
+		a.db.Exec(`UPDATE archive_runs SET objects = $1, rows = $2, bytes = $3 WHERE id = $4`,
+			run.Objects, run.Rows, run.Bytes, run.ID)
+		return nil
+	}
+
+	for rows.Next() {
+		var o Order
+		var items []byte
+		if err := rows.Scan(
+			&o.ID, &o.CustomerID, &o.CustomerName, &o.CustomerEmail,
+			&o.Status, &o.TotalAmount, &o.Currency,
+			&o.ShippingAddress, &o.Notes, &o.CreatedAt, &o.UpdatedAt, &items,
+		); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(items, &o.Items); err != nil {
+			return nil, fmt.Errorf("failed to decode items of order %s: %w", o.ID, err)
+		}
+
+		if part == nil {
+			if part, err = newArchivePart(run.Compression); err != nil {
+				return nil, err
+			}
+		}
+		if err := part.enc.Encode(o); err != nil {
+			return nil, err
+		}
+		part.rows++
+		if part.rows >= int64(archivePartRows) {
+			if err := flush(); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if err := flush(); err != nil {
+		return nil, err
+	}
+
+	// The manifest goes last: its presence marks the run as complete
+	manifest.CreatedAt = time.Now().UTC()
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := archiveStore.PutObject(ctx, run.Prefix+"/manifest.json", data, objstore.PutOptions{
+		ContentType: "application/json",
+	}); err != nil {
+		archiveObjectsTotal.WithLabelValues("failure").Inc()
+		return nil, err
+	}
+	archiveObjectsTotal.WithLabelValues("success").Inc()
+	return manifest, nil
+}
+
+// startArchive records a new run and claims the per-instance run slot.
+// The caller must run it and release the slot.
+func (a *App) startArchive(ctx context.Context, trigger string, filter ExportSpec, compression string) (*ArchiveRun, error) {
+	if !archiveRunning.CompareAndSwap(false, true) {
+		return nil, errArchiveRunning
+	}
+
+	run := &ArchiveRun{
+		Trigger:     trigger,
+		Status:      "running",
+		Filter:      filter,
+		Compression: compression,
+		Bucket:      archiveStore.Bucket(),
+	}
+	spec, _ := json.Marshal(filter)
+	err := a.db.QueryRowContext(ctx, `
+		INSERT INTO archive_runs (trigger, filter, compression, bucket)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, started_at
+	`, trigger, string(spec), compression, run.Bucket).Scan(&run.ID, &run.StartedAt)
+	if err != nil {
+		archiveRunning.Store(false)
+		return nil, err
+	}
+
+	run.Prefix = strings.Trim(archivePrefix+"/orders/"+run.ID, "/")
+	a.db.ExecContext(ctx, `UPDATE archive_runs SET prefix = $1 WHERE id = $2`, run.Prefix, run.ID)
+	return run, nil
+}
+
+// errArchiveRunning is returned while another archive run is in progress
+var errArchiveRunning = errors.New("an archive run is already in progress")
+
+// archiveYesterday is the order-archive job: it archives the orders
+// created on the previous day (UTC)
+func (a *App) archiveYesterday(ctx context.Context) error {
+	to := time.Now().UTC().Truncate(24 * time.Hour)
+	from := to.AddDate(0, 0, -1)
+
+	run, err := a.startArchive(ctx, "schedule", ExportSpec{From: &from, To: &to}, archiveCompression)
+	if err != nil {
+		return err
+	}
+	defer archiveRunning.Store(false)
+	return a.runArchive(ctx, run)
+}
+
+// =============================================================================
+// ARCHIVE ADMIN HANDLERS
+// =============================================================================
+
+// createArchive starts an archive run in the background
+func (a *App) createArchive(c *gin.Context) {
+	if !archiveEnabled() {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Archiving is not configured (ARCHIVE_S3_BUCKET)"})
+		return
+	}
+
+	var req struct {
+		ExportSpec
+		Compression string `json:"compression"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if req.Format == "" {
+		req.Format = "ndjson"
+	}
+	switch req.Format {
+	case "ndjson":
+	case "parquet":
+		c.JSON(http.StatusBadRequest, gin.H{"error": "parquet is not supported in this build, use ndjson"})
+		return
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format must be ndjson"})
+		return
+	}
+	if req.Compression == "" {
+		req.Compression = archiveCompression
+	}
+	if req.Compression != "gzip" && req.Compression != "zstd" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "compression must be gzip or zstd"})
+		return
+	}
+	if req.Status != "" && !validOrderStatuses[req.Status] {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid status"})
+		return
+	}
+
+	filter := ExportSpec{Status: req.Status, From: req.From, To: req.To}
+	run, err := a.startArchive(c.Request.Context(), "manual", filter, req.Compression)
+	if errors.Is(err, errArchiveRunning) {
+		c.JSON(http.StatusConflict, gin.H{"error": "An archive run is already in progress"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	go func() {
+		defer archiveRunning.Store(false)
+		a.runArchive(backgroundCtx, run)
+	}()
+
+	c.JSON(http.StatusAccepted, run)
+}
+
+// listArchives returns the most recent archive runs
+func (a *App) listArchives(c *gin.Context) {
+	rows, err := a.db.QueryContext(c.Request.Context(), `
+		SELECT id, trigger, status, filter, compression, bucket, COALESCE(prefix, ''),
+		       objects, rows, bytes, COALESCE(error, ''), started_at, completed_at
+		FROM archive_runs
+		ORDER BY started_at DESC
+		LIMIT 50
+	`)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	defer rows.Close()
+
+	runs := []ArchiveRun{}
+	for rows.Next() {
+		var r ArchiveRun
+		var filter []byte
+		if err := rows.Scan(&r.ID, &r.Trigger, &r.Status, &filter, &r.Compression, &r.Bucket,
+			&r.Prefix, &r.Objects, &r.Rows, &r.Bytes, &r.Error, &r.StartedAt, &r.CompletedAt); err != nil {
+			continue
+		}
+		json.Unmarshal(filter, &r.Filter)
+		runs = append(runs, r)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"enabled": archiveEnabled(),
+		"running": archiveRunning.Load(),
+		"runs":    runs,
+	})
+}
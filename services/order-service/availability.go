@@ -0,0 +1,308 @@
+// =============================================================================
+// STOCK AVAILABILITY
+// =============================================================================
+// Checkout UIs show whether the items in a cart are in stock. Instead of
+// calling the inventory service themselves, they ask the order service,
+// which they call anyway:
+//
+//   GET /api/v1/orders/availability?skus=SKU-001,SKU-002
+//
+//   {"items": [
+//     {"sku": "SKU-001", "found": true, "name": "...", "available": 12,
+//      "in_stock": true, "as_of": "...", "cache": "hit"},
+//     {"sku": "SKU-002", "found": false, "as_of": "...", "cache": "stale"}],
+//    "stale": true}
+//
+// At most maxAvailabilitySKUs SKUs per request; duplicates are answered
+// once. available is the stock not reserved for other orders.
+//
+// Every SKU is cached in Redis, with stale-while-revalidate semantics:
+//
+//   Age of the entry             Answer                           cache
+//   < AVAILABILITY_CACHE_TTL     cached entry                     hit
+//   < + AVAILABILITY_STALE_TTL   cached entry, refreshed in the   stale
+//                                background
+//   older, or not cached         fetched from the inventory       miss
+//                                service
+//
+// Unknown SKUs are cached too. A background refresh is claimed with a
+// Redis lock, so replicas serving the same stale SKU fetch it once. A SKU
+// that is not cached and can't be fetched within AVAILABILITY_TIMEOUT
+// (inventory service down or slow) gets "error" instead of a stock level;
+// when no SKU could be answered at all, the request fails with 503.
+//
+// The response itself may be cached by clients for a few seconds (see
+// cachecontrol.go), as stock levels are only ever approximate here: the
+// reservation at order time is what counts.
+//
+// METRICS:
+// - availability_lookups_total{result}          hit, stale, miss, error
+// - availability_revalidations_total{result}    success, error
+// =============================================================================
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/v8"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// maxAvailabilitySKUs bounds the SKUs of one availability request
+const maxAvailabilitySKUs = 50
+
+var (
+	// availabilityFreshTTL is how long cached stock levels are served as is
+	availabilityFreshTTL = 15 * time.Second
+
+	// availabilityStaleTTL is how much longer they are served while being
+	// refreshed
+	availabilityStaleTTL = 5 * time.Minute
+
+	// availabilityTimeout bounds the inventory calls of a request, and of
+	// a background refresh
+	availabilityTimeout = time.Second
+
+	// Counter: Availability lookups by result
+	availabilityLookupsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "availability_lookups_total",
+			Help: "Total number of SKU availability lookups, by result",
+		},
+		[]string{"result"},
+	)
+
+	// Counter: Background refreshes of stale availability
+	availabilityRevalidationsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "availability_revalidations_total",
+			Help: "Total number of background refreshes of stale SKU availability, by result",
+		},
+		[]string{"result"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(availabilityLookupsTotal)
+	prometheus.MustRegister(availabilityRevalidationsTotal)
+}
+
+// setAvailabilityCache validates and applies the availability cache
+// settings
+func setAvailabilityCache(freshTTL, staleTTL, timeout time.Duration) error {
+	if freshTTL <= 0 {
+		return fmt.Errorf("AVAILABILITY_CACHE_TTL must be positive, got %s", freshTTL)
+	}
+	if staleTTL < 0 {
+		return fmt.Errorf("AVAILABILITY_STALE_TTL must not be negative, got %s", staleTTL)
+	}
+	if timeout <= 0 {
+		return fmt.Errorf("AVAILABILITY_TIMEOUT must be positive, got %s", timeout)
+	}
+	availabilityFreshTTL = freshTTL
+	availabilityStaleTTL = staleTTL
+	availabilityTimeout = timeout
+	return nil
+}
+
+// availabilityEntry is the cached stock level of a SKU
+type availabilityEntry struct {
+	Found     bool      `json:"found"`
+	Name      string    `json:"name,omitempty"`
+	Available int       `json:"available"`
+	FetchedAt time.Time `json:"fetched_at"`
+}
+
+// SKUAvailability is the stock level of a SKU in an availability response
+type SKUAvailability struct {
+	SKU       string     `json:"sku"`
+	Found     bool       `json:"found"`
+	Name      string     `json:"name,omitempty"`
+	Available int        `json:"available"`
+	InStock   bool       `json:"in_stock"`
+	AsOf      *time.Time `json:"as_of,omitempty"`
+	Cache     string     `json:"cache"`
+	Error     string     `json:"error,omitempty"`
+}
+
+// availabilityKey is the Redis key of the cached stock level of a SKU
+func availabilityKey(sku string) string {
+	return cacheKeyPrefix + "availability:" + sku
+}
+
+// getAvailability serves GET /api/v1/orders/availability
+func (a *App) getAvailability(c *gin.Context) {
+	var skus []string
+	seen := map[string]bool{}
+	for _, sku := range strings.Split(c.Query("skus"), ",") {
+		if sku = strings.TrimSpace(sku); sku != "" && !seen[sku] {
+			seen[sku] = true
+			skus = append(skus, sku)
+		}
+	}
+	if len(skus) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": tr(c, "skus is required")})
+		return
+	}
+	if len(skus) > maxAvailabilitySKUs {
+		c.JSON(http.StatusBadRequest, gin.H{"error": tr(c, "At most %d SKUs per request", maxAvailabilitySKUs)})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), availabilityTimeout)
+	defer cancel()
+
+	items := make([]SKUAvailability, len(skus))
+	var wg sync.WaitGroup
+	for i, sku := range skus {
+		wg.Add(1)
+		go func(i int, sku string) {
+			defer wg.Done()
+			items[i] = a.lookupAvailability(ctx, sku)
+		}(i, sku)
+	}
+	wg.Wait()
+
+	stale, answered := false, 0
+	for _, item := range items {
+		stale = stale || item.Cache == "stale"
+		if item.Error == "" {
+			answered++
+		}
+	}
+	if answered == 0 {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": tr(c, "Inventory service is unavailable, please retry later")})
+		return
+	}
+	writeJSON(c, http.StatusOK, gin.H{"items": items, "stale": stale})
+}
+
+// lookupAvailability returns the stock level of a SKU, from the cache if
+// possible
+func (a *App) lookupAvailability(ctx context.Context, sku string) SKUAvailability {
+	key := availabilityKey(sku)
+	if data, err := a.redisClient.Get(ctx, key).Bytes(); err == nil {
+		var entry availabilityEntry
+		if json.Unmarshal(data, &entry) == nil {
+			if time.Since(entry.FetchedAt) < availabilityFreshTTL {
+				availabilityLookupsTotal.WithLabelValues("hit").Inc()
+				return entry.response(sku, "hit")
+			}
+			availabilityLookupsTotal.WithLabelValues("stale").Inc()
+			a.revalidateAvailability(ctx, sku)
+			return entry.response(sku, "stale")
+		}
+	} else if !errors.Is(err, redis.Nil) {
+		logDebugContext(ctx, "Availability cache unavailable", map[string]interface{}{"sku": sku, "error": err.Error()})
+	}
+
+	entry, err := a.refreshAvailability(ctx, sku)
+	if err != nil {
+		availabilityLookupsTotal.WithLabelValues("error").Inc()
+		logWarnContext(ctx, "SKU availability lookup failed", map[string]interface{}{
+			"sku":   sku,
+			"error": err.Error(),
+		})
+		return SKUAvailability{SKU: sku, Cache: "miss", Error: "inventory service unavailable"}
+	}
+	availabilityLookupsTotal.WithLabelValues("miss").Inc()
+	return entry.response(sku, "miss")
+}
+
+// revalidateAvailability refreshes a stale SKU in the background, unless
+// another request (or replica) already does
+func (a *App) revalidateAvailability(ctx context.Context, sku string) {
+	claimed, err := a.redisClient.SetNX(ctx, availabilityKey(sku)+":refresh", 1, availabilityTimeout).Result()
+	if err != nil || !claimed {
+		return
+	}
+
+	go func() {
+		ctx, cancel := context.WithTimeout(backgroundCtx, availabilityTimeout)
+		defer cancel()
+		if _, err := a.refreshAvailability(ctx, sku); err != nil {
+			availabilityRevalidationsTotal.WithLabelValues("error").Inc()
+			logDebug("Availability refresh failed, serving stale stock level", map[string]interface{}{
+				"sku":   sku,
+				"error": err.Error(),
+			})
+			return
+		}
+		availabilityRevalidationsTotal.WithLabelValues("success").Inc()
+	}()
+}
+
+// refreshAvailability fetches the stock level of a SKU and caches it
+func (a *App) refreshAvailability(ctx context.Context, sku string) (availabilityEntry, error) {
+	entry, err := a.fetchAvailability(ctx, sku)
+	if err != nil {
+		return availabilityEntry{}, err
+	}
+	if data, err := json.Marshal(entry); err == nil {
+		a.redisClient.Set(ctx, availabilityKey(sku), data, availabilityFreshTTL+availabilityStaleTTL)
+	}
+	return entry, nil
+}
+
+// fetchAvailability reads the stock level of a SKU from the inventory
+// service
+func (a *App) fetchAvailability(ctx context.Context, sku string) (availabilityEntry, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet,
+		a.inventoryServiceURL+"/api/v1/inventory/"+url.PathEscape(sku), nil)
+	if err != nil {
+		return availabilityEntry{}, err
+	}
+
+	resp, err := a.httpClient.Do(req)
+	if err != nil {
+		return availabilityEntry{}, fmt.Errorf("inventory service unreachable: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return availabilityEntry{Found: false, FetchedAt: time.Now().UTC()}, nil
+	default:
+		return availabilityEntry{}, fmt.Errorf("inventory service returned status %d", resp.StatusCode)
+	}
+
+	var item struct {
+		Name     string `json:"name"`
+		Quantity int    `json:"quantity"`
+		Reserved int    `json:"reserved"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&item); err != nil {
+		return availabilityEntry{}, fmt.Errorf("invalid inventory service response: %w", err)
+	}
+
+	available := item.Quantity - item.Reserved
+	if available < 0 {
+		available = 0
+	}
+	return availabilityEntry{Found: true, Name: item.Name, Available: available, FetchedAt: time.Now().UTC()}, nil
+}
+
+// response returns the entry as the availability of sku
+func (e availabilityEntry) response(sku, cache string) SKUAvailability {
+	asOf := e.FetchedAt
+	return SKUAvailability{
+		SKU:       sku,
+		Found:     e.Found,
+		Name:      e.Name,
+		Available: e.Available,
+		InStock:   e.Found && e.Available > 0,
+		AsOf:      &asOf,
+		Cache:     cache,
+	}
+}
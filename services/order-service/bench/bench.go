@@ -0,0 +1,229 @@
+// =============================================================================
+// BENCHMARK AND LOAD-PROFILE HARNESS
+// =============================================================================
+// Reproducible benchmarks for the order service's hot paths, so releases
+// can be compared against each other:
+//
+// - serialize  Encoding an order list (in process, reports allocations)
+// - create     POST /api/v1/orders against a running service
+// - list       GET /api/v1/orders against a running service
+//
+// HTTP benchmarks run a fixed load profile (concurrency x duration) with
+// payloads generated from a fixed seed, so two runs against the same build
+// send the same requests. Results are written as a Report that can be
+// stored and compared against a later run with Compare.
+//
+// Run it with cmd/bench:
+//
+//   go run ./cmd/bench -target http://localhost:8001 -out v1.1.json
+//   go run ./cmd/bench -target http://localhost:8001 -baseline v1.1.json
+// =============================================================================
+
+package bench
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"runtime"
+	"sort"
+	"sync"
+	"time"
+
+	"order-service/jsonenc"
+)
+
+// Options configures the HTTP load profile
+type Options struct {
+	// BaseURL of the order service public API, e.g. http://localhost:8001
+	BaseURL string
+	// Concurrency is the number of parallel clients
+	Concurrency int
+	// Duration is how long each HTTP benchmark runs
+	Duration time.Duration
+	// Seed makes the generated request payloads reproducible
+	Seed int64
+}
+
+// Result is the outcome of one benchmark
+type Result struct {
+	Name        string  `json:"name"`
+	Iterations  int     `json:"iterations,omitempty"`
+	Errors      int     `json:"errors,omitempty"`
+	NsPerOp     int64   `json:"ns_per_op"`
+	AllocsPerOp int64   `json:"allocs_per_op,omitempty"`
+	BytesPerOp  int64   `json:"bytes_per_op,omitempty"`
+	P50Ms       float64 `json:"p50_ms,omitempty"`
+	P95Ms       float64 `json:"p95_ms,omitempty"`
+	P99Ms       float64 `json:"p99_ms,omitempty"`
+	Throughput  float64 `json:"throughput_per_sec,omitempty"`
+}
+
+// Report is a set of results together with what they were measured on
+type Report struct {
+	Version     string    `json:"version,omitempty"`
+	GoVersion   string    `json:"go_version"`
+	JSON        string    `json:"json_encoder"`
+	Target      string    `json:"target,omitempty"`
+	Concurrency int       `json:"concurrency,omitempty"`
+	Duration    string    `json:"duration,omitempty"`
+	Seed        int64     `json:"seed"`
+	Timestamp   time.Time `json:"timestamp"`
+	Results     []Result  `json:"results"`
+}
+
+// NewReport starts a report for the given options
+func NewReport(opts Options) *Report {
+	return &Report{
+		GoVersion:   runtime.Version(),
+		JSON:        jsonenc.Implementation,
+		Target:      opts.BaseURL,
+		Concurrency: opts.Concurrency,
+		Duration:    opts.Duration.String(),
+		Seed:        opts.Seed,
+		Timestamp:   time.Now().UTC(),
+	}
+}
+
+// Serialize benchmarks encoding a list of the given number of orders
+func Serialize(orders int) Result {
+	r := jsonenc.MeasureWrite(orders)
+	return Result{
+		Name:        fmt.Sprintf("serialize/%d", orders),
+		NsPerOp:     r.NsPerOp,
+		AllocsPerOp: r.AllocsPerOp,
+		BytesPerOp:  r.BytesPerOp,
+	}
+}
+
+// CreateOrder runs the load profile against POST /api/v1/orders
+func CreateOrder(opts Options) Result {
+	return loadProfile("create", opts, func(client *http.Client, rng *rand.Rand) error {
+		body, _ := json.Marshal(orderPayload(rng))
+		resp, err := client.Post(opts.BaseURL+"/api/v1/orders", "application/json", bytes.NewReader(body))
+		return checkResponse(resp, err, http.StatusCreated)
+	})
+}
+
+// ListOrders runs the load profile against GET /api/v1/orders
+func ListOrders(opts Options) Result {
+	return loadProfile("list", opts, func(client *http.Client, rng *rand.Rand) error {
+		url := fmt.Sprintf("%s/api/v1/orders?page=%d&per_page=50", opts.BaseURL, 1+rng.Intn(5))
+		resp, err := client.Get(url)
+		return checkResponse(resp, err, http.StatusOK)
+	})
+}
+
+// ServiceVersion reads the version reported by the service's /health
+// endpoint on the given base URL
+func ServiceVersion(baseURL string) string {
+	resp, err := http.Get(baseURL + "/health")
+	if err != nil {
+		return ""
+	}
+	defer resp.Body.Close()
+
+	var health struct {
+		Version string `json:"version"`
+	}
+	json.NewDecoder(resp.Body).Decode(&health)
+	return health.Version
+}
+
+// loadProfile calls fn from opts.Concurrency clients for opts.Duration and
+// summarizes the observed latencies
+func loadProfile(name string, opts Options, fn func(*http.Client, *rand.Rand) error) Result {
+	client := &http.Client{Timeout: 30 * time.Second}
+
+	var mu sync.Mutex
+	var latencies []time.Duration
+	errors := 0
+
+	start := time.Now()
+	deadline := start.Add(opts.Duration)
+
+	var wg sync.WaitGroup
+	for i := 0; i < opts.Concurrency; i++ {
+		// Each client has its own seeded generator, so the request
+		// sequence doesn't depend on goroutine scheduling
+		rng := rand.New(rand.NewSource(opts.Seed + int64(i)))
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var local []time.Duration
+			localErrors := 0
+			for time.Now().Before(deadline) {
+				t := time.Now()
+				if err := fn(client, rng); err != nil {
+					localErrors++
+					continue
+				}
+				local = append(local, time.Since(t))
+			}
+			mu.Lock()
+			latencies = append(latencies, local...)
+			errors += localErrors
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	result := Result{Name: name, Iterations: len(latencies), Errors: errors}
+	if len(latencies) == 0 {
+		return result
+	}
+
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	var total time.Duration
+	for _, l := range latencies {
+		total += l
+	}
+	result.NsPerOp = int64(total) / int64(len(latencies))
+	result.P50Ms = percentileMs(latencies, 50)
+	result.P95Ms = percentileMs(latencies, 95)
+	result.P99Ms = percentileMs(latencies, 99)
+	result.Throughput = float64(len(latencies)) / elapsed.Seconds()
+	return result
+}
+
+// percentileMs returns the p-th percentile of sorted latencies in milliseconds
+func percentileMs(sorted []time.Duration, p int) float64 {
+	return float64(sorted[(len(sorted)-1)*p/100]) / float64(time.Millisecond)
+}
+
+// checkResponse drains the response and fails on an unexpected status
+func checkResponse(resp *http.Response, err error, want int) error {
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode != want {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// orderPayload generates a create order request
+func orderPayload(rng *rand.Rand) map[string]interface{} {
+	items := make([]map[string]interface{}, 1+rng.Intn(4))
+	for i := range items {
+		items[i] = map[string]interface{}{
+			"sku":        fmt.Sprintf("BENCH-%03d", rng.Intn(100)),
+			"name":       "Benchmark item",
+			"quantity":   1 + rng.Intn(5),
+			"unit_price": float64(100+rng.Intn(9900)) / 100,
+		}
+	}
+	return map[string]interface{}{
+		"customer_id":    fmt.Sprintf("00000000-0000-4000-8000-%012d", rng.Intn(1000)),
+		"customer_name":  "Bench Customer",
+		"customer_email": "bench@example.com",
+		"notes":          "generated by order-service bench",
+		"items":          items,
+	}
+}
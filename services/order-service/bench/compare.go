@@ -0,0 +1,59 @@
+package bench
+
+import "fmt"
+
+// Regression is a benchmark that got slower or allocates more than its
+// baseline by more than the tolerance
+type Regression struct {
+	Name     string  `json:"name"`
+	Metric   string  `json:"metric"`
+	Baseline float64 `json:"baseline"`
+	Current  float64 `json:"current"`
+	Change   float64 `json:"change"`
+}
+
+func (r Regression) String() string {
+	return fmt.Sprintf("%s %s: %.2f -> %.2f (%+.1f%%)", r.Name, r.Metric, r.Baseline, r.Current, r.Change*100)
+}
+
+// Compare returns the results of current that regressed against baseline.
+// tolerance is the accepted relative increase, e.g. 0.1 for 10%.
+func Compare(baseline, current *Report, tolerance float64) []Regression {
+	previous := map[string]Result{}
+	for _, r := range baseline.Results {
+		previous[r.Name] = r
+	}
+
+	var regressions []Regression
+	for _, cur := range current.Results {
+		base, ok := previous[cur.Name]
+		if !ok {
+			continue
+		}
+
+		metrics := []struct {
+			name          string
+			base, current float64
+		}{
+			{"ns_per_op", float64(base.NsPerOp), float64(cur.NsPerOp)},
+			{"allocs_per_op", float64(base.AllocsPerOp), float64(cur.AllocsPerOp)},
+			{"p99_ms", base.P99Ms, cur.P99Ms},
+		}
+		for _, m := range metrics {
+			if m.base <= 0 {
+				continue
+			}
+			change := (m.current - m.base) / m.base
+			if change > tolerance {
+				regressions = append(regressions, Regression{
+					Name:     cur.Name,
+					Metric:   m.name,
+					Baseline: m.base,
+					Current:  m.current,
+					Change:   change,
+				})
+			}
+		}
+	}
+	return regressions
+}
@@ -0,0 +1,144 @@
+// =============================================================================
+// FULFILLMENT BOARD
+// =============================================================================
+// GET /api/v1/orders/board returns the order pipeline as a Kanban board:
+// one column per status, in pipeline order, with the number of orders in
+// it and its most recent orders as cards:
+//
+//   GET /api/v1/orders/board?limit=5
+//
+//   {"total": 1284, "limit": 5, "columns": [
+//     {"status": "preorder", "count": 12, "orders": [...]},
+//     {"status": "pending", "count": 40, "orders": [
+//       {"id": "...", "order_number": "ORD-000123", "customer_name": "...",
+//        "customer_tier": "gold", "total_amount": 59.9, "currency": "EUR",
+//        "created_at": "...", "updated_at": "..."}, ...]},
+//     ...]}
+//
+// limit is the cards per column (default 10, at most 50). Every status has
+// a column, empty ones included, so the board's layout doesn't shift.
+//
+// The counts and cards come from one query: a grouped query counts the
+// orders of every status (an index-only scan of idx_orders_status_created),
+// and a LATERAL subquery per counted status (WHERE status = s ORDER BY
+// created_at DESC LIMIT limit) reads only that column's newest orders from
+// the same index instead of ranking every order. A status without orders
+// has no row and keeps its empty column. The query is plain SQL that
+// PostgreSQL and MySQL (8.0.14 and later for LATERAL) run alike.
+// =============================================================================
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// boardStatuses are the columns of the board, in pipeline order
+var boardStatuses = []string{"preorder", "pending", "processing", "shipped", "delivered", "cancelled"}
+
+const (
+	defaultBoardLimit = 10
+	maxBoardLimit     = 50
+)
+
+// BoardCard is an order on the board
+type BoardCard struct {
+	ID           string    `json:"id"`
+	Number       string    `json:"order_number,omitempty"`
+	CustomerName string    `json:"customer_name"`
+	CustomerTier string    `json:"customer_tier,omitempty"`
+	TotalAmount  float64   `json:"total_amount"`
+	Currency     string    `json:"currency"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+// BoardColumn is the orders in one status
+type BoardColumn struct {
+	Status string      `json:"status"`
+	Count  int64       `json:"count"`
+	Orders []BoardCard `json:"orders"`
+}
+
+// getOrderBoard serves GET /api/v1/orders/board
+func (a *App) getOrderBoard(c *gin.Context) {
+	limit := defaultBoardLimit
+	if l := c.Query("limit"); l != "" {
+		n, err := strconv.Atoi(l)
+		if err != nil || n <= 0 || n > maxBoardLimit {
+			c.JSON(http.StatusBadRequest, gin.H{"error": tr(c, "limit must be between 1 and %d", maxBoardLimit)})
+			return
+		}
+		limit = n
+	}
+
+	ctx := c.Request.Context()
+	columns := make(map[string]*BoardColumn, len(boardStatuses))
+	for _, status := range boardStatuses {
+		columns[status] = &BoardColumn{Status: status, Orders: []BoardCard{}}
+	}
+
+	args := make([]interface{}, 0, len(boardStatuses)+1)
+	for _, status := range boardStatuses {
+		args = append(args, status)
+	}
+	args = append(args, limit)
+
+	rows, err := a.db.QueryContext(dbOperation(ctx, "order_board"), `
+		SELECT s.status, s.status_count, o.id, COALESCE(o.order_number, ''), o.customer_name,
+		       COALESCE(o.customer_tier, ''), o.total_amount, o.currency, o.created_at, o.updated_at
+		FROM (
+			SELECT status, COUNT(*) AS status_count
+			FROM orders
+			WHERE status IN (`+placeholders(len(boardStatuses))+`)
+			GROUP BY status
+		) s
+		CROSS JOIN LATERAL (
+			SELECT * FROM orders
+			WHERE status = s.status
+			ORDER BY created_at DESC
+			LIMIT `+fmt.Sprintf("$%d", len(args))+`
+		) o
+		ORDER BY s.status, o.created_at DESC
+	`, args...)
+	if err != nil {
+		logErrorContext(ctx, "Failed to load order board", map[string]interface{}{"error": err.Error()})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": tr(c, "Database error")})
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var status string
+		var count int64
+		var card BoardCard
+		if err := rows.Scan(&status, &count, &card.ID, &card.Number, &card.CustomerName,
+			&card.CustomerTier, &card.TotalAmount, &card.Currency, &card.CreatedAt, &card.UpdatedAt); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": tr(c, "Database error")})
+			return
+		}
+		columns[status].Count = count
+		columns[status].Orders = append(columns[status].Orders, card)
+	}
+	if err := rows.Err(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": tr(c, "Database error")})
+		return
+	}
+
+	board := make([]BoardColumn, 0, len(boardStatuses))
+	var total int64
+	for _, status := range boardStatuses {
+		board = append(board, *columns[status])
+		total += columns[status].Count
+	}
+	writeJSON(c, http.StatusOK, gin.H{
+		"total":   total,
+		"limit":   limit,
+		"columns": board,
+	})
+}
@@ -0,0 +1,334 @@
+// =============================================================================
+// CIRCUIT BREAKERS
+// =============================================================================
+// Calls to the other services (inventory, payment, user, notification) go
+// through a circuit breaker per service, so a service that is down costs
+// an immediate error instead of a timeout on every request, and gets room
+// to recover:
+//
+//   closed --(too many failures)--> open --(CIRCUIT_BREAKER_OPEN_TIMEOUT)-->
+//   half-open --(probes succeed)--> closed
+//       |
+//       +--(a probe fails)--> open
+//
+// - closed: calls go through. The breaker trips after
+//   CIRCUIT_BREAKER_CONSECUTIVE_FAILURES failures in a row (default 5), or
+//   when at least CIRCUIT_BREAKER_MIN_REQUESTS calls (default 20) were made
+//   in the current CIRCUIT_BREAKER_INTERVAL (default 60s) and
+//   CIRCUIT_BREAKER_FAILURE_RATIO of them failed (default 0.5).
+// - open: calls fail at once with errCircuitOpen for
+//   CIRCUIT_BREAKER_OPEN_TIMEOUT (default 30s).
+// - half-open: up to CIRCUIT_BREAKER_HALF_OPEN_REQUESTS calls (default 3)
+//   probe the service; once that many succeeded the breaker closes, the
+//   first failure opens it again. Further calls fail like when open.
+//
+// Transport errors and 5xx responses are failures; 4xx responses are the
+// caller's problem and count as successes. Calls cancelled by the caller
+// don't count. Simulated outages (see outages.go) trip the breakers like
+// real ones. CIRCUIT_BREAKER_ENABLED=false lets every call through.
+//
+// The breakers are listed with their counts under GET
+// /admin/circuit-breakers (see admin.go).
+//
+// METRICS:
+// - circuit_breaker_state{dependency}   0 closed, 1 half-open, 2 open
+// - circuit_breaker_transitions_total{dependency,from,to}
+// - circuit_breaker_rejected_total{dependency}   Calls failed by an open
+//                                                breaker
+// =============================================================================
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Breaker states
+const (
+	breakerClosed   = "closed"
+	breakerHalfOpen = "half-open"
+	breakerOpen     = "open"
+)
+
+// breakerStateValues are the circuit_breaker_state values of the states
+var breakerStateValues = map[string]float64{breakerClosed: 0, breakerHalfOpen: 1, breakerOpen: 2}
+
+// errCircuitOpen is returned for calls an open breaker rejects
+var errCircuitOpen = errors.New("circuit breaker is open")
+
+// breakerSettings are the thresholds of a breaker
+type breakerSettings struct {
+	ConsecutiveFailures int
+	FailureRatio        float64
+	MinRequests         int
+	Interval            time.Duration
+	OpenTimeout         time.Duration
+	HalfOpenRequests    int
+}
+
+// serviceBreakers are the breakers of the downstream services, by service
+// name (see serviceForHost). Empty while breakers are disabled.
+var serviceBreakers = map[string]*circuitBreaker{}
+
+var (
+	// Gauge: Breaker state
+	circuitBreakerState = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "circuit_breaker_state",
+			Help: "State of the circuit breaker of a downstream service: 0 closed, 1 half-open, 2 open",
+		},
+		[]string{"dependency"},
+	)
+
+	// Counter: Breaker state changes
+	circuitBreakerTransitionsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "circuit_breaker_transitions_total",
+			Help: "Total number of circuit breaker state changes",
+		},
+		[]string{"dependency", "from", "to"},
+	)
+
+	// Counter: Calls rejected by a breaker
+	circuitBreakerRejectedTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "circuit_breaker_rejected_total",
+			Help: "Total number of downstream calls failed at once because the circuit breaker was open",
+		},
+		[]string{"dependency"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(circuitBreakerState)
+	prometheus.MustRegister(circuitBreakerTransitionsTotal)
+	prometheus.MustRegister(circuitBreakerRejectedTotal)
+}
+
+// setCircuitBreakers validates the breaker settings and creates a breaker
+// per downstream service
+func setCircuitBreakers(enabled bool, settings breakerSettings) error {
+	switch {
+	case settings.ConsecutiveFailures <= 0:
+		return fmt.Errorf("CIRCUIT_BREAKER_CONSECUTIVE_FAILURES must be positive, got %d", settings.ConsecutiveFailures)
+	case settings.FailureRatio <= 0 || settings.FailureRatio > 1:
+		return fmt.Errorf("CIRCUIT_BREAKER_FAILURE_RATIO must be in (0, 1], got %g", settings.FailureRatio)
+	case settings.MinRequests <= 0:
+		return fmt.Errorf("CIRCUIT_BREAKER_MIN_REQUESTS must be positive, got %d", settings.MinRequests)
+	case settings.Interval <= 0 || settings.OpenTimeout <= 0:
+		return fmt.Errorf("CIRCUIT_BREAKER_INTERVAL and CIRCUIT_BREAKER_OPEN_TIMEOUT must be positive")
+	case settings.HalfOpenRequests <= 0:
+		return fmt.Errorf("CIRCUIT_BREAKER_HALF_OPEN_REQUESTS must be positive, got %d", settings.HalfOpenRequests)
+	}
+	if !enabled {
+		return nil
+	}
+
+	for _, name := range []string{"inventory", "payment", "user", "notification"} {
+		b := newCircuitBreaker(name, settings)
+		serviceBreakers[name] = b
+		circuitBreakers = append(circuitBreakers, b)
+	}
+	return nil
+}
+
+// circuitBreaker is the breaker of one downstream service
+type circuitBreaker struct {
+	name     string
+	settings breakerSettings
+
+	mu    sync.Mutex
+	state string
+	// generation changes with every state change and window reset, so
+	// results of calls started before are ignored
+	generation  uint64
+	expiry      time.Time
+	requests    int
+	failures    int
+	consecutive int // consecutive failures when closed, successes when half-open
+	changedAt   time.Time
+}
+
+func newCircuitBreaker(name string, settings breakerSettings) *circuitBreaker {
+	b := &circuitBreaker{name: name, settings: settings, state: breakerClosed, changedAt: time.Now()}
+	b.resetWindow(time.Now())
+	circuitBreakerState.WithLabelValues(name).Set(breakerStateValues[breakerClosed])
+	return b
+}
+
+// Name implements breakerStateReporter
+func (b *circuitBreaker) Name() string { return b.name }
+
+// State implements breakerStateReporter
+func (b *circuitBreaker) State() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.advance(time.Now())
+	return b.state
+}
+
+// Details implements breakerDetailsReporter
+func (b *circuitBreaker) Details() gin.H {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.advance(time.Now())
+	details := gin.H{
+		"requests":             b.requests,
+		"failures":             b.failures,
+		"consecutive_failures": 0,
+		"since":                b.changedAt.UTC(),
+	}
+	switch b.state {
+	case breakerClosed:
+		details["consecutive_failures"] = b.consecutive
+	case breakerOpen:
+		details["retry_at"] = b.expiry.UTC()
+	}
+	return details
+}
+
+// allow reports whether a call may go through, and the generation its
+// result belongs to
+func (b *circuitBreaker) allow() (uint64, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.advance(time.Now())
+
+	switch {
+	case b.state == breakerOpen:
+		return 0, errCircuitOpen
+	case b.state == breakerHalfOpen && b.requests >= b.settings.HalfOpenRequests:
+		return 0, errCircuitOpen
+	}
+	b.requests++
+	return b.generation, nil
+}
+
+// done records the result of a call allowed in generation
+func (b *circuitBreaker) done(generation uint64, success bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	b.advance(now)
+	if generation != b.generation {
+		return
+	}
+
+	if success {
+		switch b.state {
+		case breakerClosed:
+			b.consecutive = 0
+		case breakerHalfOpen:
+			b.consecutive++
+			if b.consecutive >= b.settings.HalfOpenRequests {
+				b.setState(breakerClosed, now)
+			}
+		}
+		return
+	}
+
+	b.failures++
+	switch b.state {
+	case breakerClosed:
+		b.consecutive++
+		if b.consecutive >= b.settings.ConsecutiveFailures ||
+			(b.requests >= b.settings.MinRequests &&
+				float64(b.failures)/float64(b.requests) >= b.settings.FailureRatio) {
+			b.setState(breakerOpen, now)
+		}
+	case breakerHalfOpen:
+		b.setState(breakerOpen, now)
+	}
+}
+
+// cancel forgets a call allowed in generation that was cancelled by its
+// caller, freeing its half-open probe slot
+func (b *circuitBreaker) cancel(generation uint64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.advance(time.Now())
+	if generation == b.generation && b.requests > 0 {
+		b.requests--
+	}
+}
+
+// advance moves an open breaker to half-open once its timeout passed, and
+// starts a new counting window of a closed breaker. The caller holds mu.
+func (b *circuitBreaker) advance(now time.Time) {
+	switch b.state {
+	case breakerClosed:
+		if now.After(b.expiry) {
+			b.resetWindow(now)
+		}
+	case breakerOpen:
+		if now.After(b.expiry) {
+			b.setState(breakerHalfOpen, now)
+		}
+	}
+}
+
+// setState changes the state and starts a new generation. The caller
+// holds mu.
+func (b *circuitBreaker) setState(state string, now time.Time) {
+	from := b.state
+	b.state = state
+	b.changedAt = now
+	b.resetWindow(now)
+	if state == breakerOpen {
+		b.expiry = now.Add(b.settings.OpenTimeout)
+	}
+
+	circuitBreakerState.WithLabelValues(b.name).Set(breakerStateValues[state])
+	circuitBreakerTransitionsTotal.WithLabelValues(b.name, from, state).Inc()
+	fields := map[string]interface{}{"dependency": b.name, "from": from, "to": state}
+	if state == breakerOpen {
+		logWarn("Circuit breaker opened", fields)
+	} else {
+		logInfo("Circuit breaker state changed", fields)
+	}
+}
+
+// resetWindow clears the counts. The caller holds mu.
+func (b *circuitBreaker) resetWindow(now time.Time) {
+	b.generation++
+	b.requests, b.failures, b.consecutive = 0, 0, 0
+	b.expiry = time.Time{}
+	if b.state == breakerClosed {
+		b.expiry = now.Add(b.settings.Interval)
+	}
+}
+
+// breakerTransport sends requests to a downstream service through its
+// circuit breaker
+type breakerTransport struct {
+	app  *App
+	next http.RoundTripper
+}
+
+func (t breakerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	b := serviceBreakers[t.app.serviceForHost(req.URL.Host)]
+	if b == nil {
+		return t.next.RoundTrip(req)
+	}
+
+	generation, err := b.allow()
+	if err != nil {
+		circuitBreakerRejectedTotal.WithLabelValues(b.name).Inc()
+		return nil, fmt.Errorf("%s: %w", b.name, err)
+	}
+	resp, err := t.next.RoundTrip(req)
+	if err != nil && req.Context().Err() != nil {
+		// Cancelled by the caller, says nothing about the service
+		b.cancel(generation)
+		return resp, err
+	}
+	b.done(generation, err == nil && resp.StatusCode < 500)
+	return resp, err
+}
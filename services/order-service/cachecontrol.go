@@ -0,0 +1,112 @@
+// =============================================================================
+// CACHE-CONTROL POLICIES
+// =============================================================================
+// Without Cache-Control, reverse proxies and browsers guess how long a
+// response may be reused, and the lab's proxies guessed differently. Every
+// GET and HEAD response of the public API now carries an explicit policy:
+//
+//   GET /api/v1/orders/stats           public, max-age=30
+//   GET /api/v1/orders/availability    public, max-age=5
+//   GET /api/v1/reports/daily          public, max-age=300
+//   GET /api/v1/orders/:id             private, no-cache
+//   everything else (CACHE_CONTROL_DEFAULT)   no-store
+//
+// Single orders are private (they hold personal data) and no-cache rather
+// than no-store, so clients keep their copy and revalidate it with the
+// ETag (see etag.go). Error responses are always no-store, so a cache
+// never serves an outage after it's over. Writes get no policy.
+//
+// CACHE_CONTROL_POLICIES overrides or adds policies, as
+// "METHOD /route=policy" entries separated by semicolons (policies contain
+// commas):
+//
+//   GET /api/v1/orders/stats=public, max-age=60;GET /api/v1/orders=private, max-age=5
+// =============================================================================
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	// cachePolicies are the Cache-Control values by "METHOD /route"
+	cachePolicies = map[string]string{
+		"GET /api/v1/orders/stats":        "public, max-age=30",
+		"GET /api/v1/orders/availability": "public, max-age=5",
+		"GET /api/v1/reports/daily":       "public, max-age=300",
+		"GET /api/v1/orders/:id":          "private, no-cache",
+	}
+
+	// defaultCachePolicy applies to reads without a policy of their own
+	defaultCachePolicy = "no-store"
+)
+
+// setCachePolicies applies the default policy and the CACHE_CONTROL_POLICIES
+// overrides
+func setCachePolicies(defaultPolicy, spec string) error {
+	if strings.TrimSpace(defaultPolicy) == "" {
+		return fmt.Errorf("CACHE_CONTROL_DEFAULT must not be empty")
+	}
+	policies := make(map[string]string, len(cachePolicies))
+	for route, policy := range cachePolicies {
+		policies[route] = policy
+	}
+	for _, entry := range strings.Split(spec, ";") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		target, policy, ok := strings.Cut(entry, "=")
+		method, route, ok2 := strings.Cut(strings.TrimSpace(target), " ")
+		if !ok || !ok2 || strings.TrimSpace(policy) == "" {
+			return fmt.Errorf("invalid CACHE_CONTROL_POLICIES entry %q, expected METHOD /route=policy", entry)
+		}
+		policies[strings.ToUpper(method)+" "+strings.TrimSpace(route)] = strings.TrimSpace(policy)
+	}
+	cachePolicies = policies
+	defaultCachePolicy = strings.TrimSpace(defaultPolicy)
+	return nil
+}
+
+// cacheControlWriter replaces the policy of error responses
+type cacheControlWriter struct {
+	gin.ResponseWriter
+}
+
+// WriteHeader makes error responses uncacheable
+func (w *cacheControlWriter) WriteHeader(code int) {
+	if code >= http.StatusBadRequest {
+		w.Header().Set("Cache-Control", "no-store")
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+// cacheControlMiddleware sets the Cache-Control policy of API reads
+func cacheControlMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		method := c.Request.Method
+		if method != http.MethodGet && method != http.MethodHead {
+			c.Next()
+			return
+		}
+
+		// HEAD shares the policy of GET unless it has its own
+		policy, ok := cachePolicies[method+" "+c.FullPath()]
+		if !ok {
+			policy, ok = cachePolicies[http.MethodGet+" "+c.FullPath()]
+		}
+		if !ok {
+			policy = defaultCachePolicy
+		}
+		c.Header("Cache-Control", policy)
+
+		w := &cacheControlWriter{ResponseWriter: c.Writer}
+		c.Writer = w
+		c.Next()
+		c.Writer = w.ResponseWriter
+	}
+}
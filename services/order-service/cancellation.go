@@ -0,0 +1,114 @@
+// =============================================================================
+// CANCELLED REQUESTS
+// =============================================================================
+// When a client disconnects before its response is written, net/http
+// cancels the request's context. Handlers pass that context on to every
+// database statement (QueryContext, ExecContext, BeginTx) and downstream
+// call (NewRequestWithContext), so abandoned work stops at once: PostgreSQL
+// cancels the running statement, and HTTP calls to the other services are
+// closed (and neither retried nor counted by their circuit breaker, see
+// httpretry.go and breaker.go).
+//
+// The handler then typically answers with a 500 ("Database error") that
+// nobody reads. Counted as such, a burst of impatient clients would look
+// like an outage in the error-rate SLOs, which are based on 5xx responses.
+// Such requests are recorded as 499 Client Closed Request instead (the
+// status nginx uses for them) in the request log, http_requests_total and
+// the request's trace span, whatever the handler wrote. Error logs of a
+// cancelled request are written at warn level, and its failed statements
+// count as cancelled rather than failed.
+//
+// Requests whose client is already gone before they are routed aren't run
+// at all, and neither are those whose client gives up while they wait for
+// a concurrency slot (see concurrency.go).
+//
+// Deadlines (context.DeadlineExceeded) are not cancellations: a timeout is
+// the service's failure and still counts as an error.
+//
+// METRICS:
+// - http_requests_cancelled_total{method,endpoint}   Requests abandoned by
+//                                                    their client
+// - db_query_cancelled_total{operation}              Statements cancelled
+//                                                    with their request
+// =============================================================================
+
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// statusClientClosedRequest is the status recorded for requests abandoned
+// by their client
+const statusClientClosedRequest = 499
+
+var (
+	// Counter: Requests abandoned by their client
+	httpRequestsCancelledTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "http_requests_cancelled_total",
+			Help: "Total number of HTTP requests cancelled because the client disconnected",
+		},
+		[]string{"method", "endpoint"},
+	)
+
+	// Counter: Statements cancelled with their request
+	dbQueryCancelledTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "db_query_cancelled_total",
+			Help: "Total number of PostgreSQL statements cancelled because their context was, by operation",
+		},
+		[]string{"operation"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(httpRequestsCancelledTotal)
+	prometheus.MustRegister(dbQueryCancelledTotal)
+}
+
+// cancelled reports whether ctx was cancelled, rather than timed out
+func cancelled(ctx context.Context) bool {
+	return errors.Is(ctx.Err(), context.Canceled)
+}
+
+// responseStatus is the status a request is recorded with: the status
+// written, or statusClientClosedRequest if the client went away
+func responseStatus(c *gin.Context) int {
+	if cancelled(c.Request.Context()) {
+		return statusClientClosedRequest
+	}
+	return c.Writer.Status()
+}
+
+// cancellationMiddleware skips requests whose client is already gone, and
+// counts the requests abandoned while being handled
+func cancellationMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		if !cancelled(c.Request.Context()) {
+			c.Next()
+		} else {
+			c.Abort()
+		}
+		if !cancelled(c.Request.Context()) {
+			return
+		}
+
+		endpoint := c.FullPath()
+		if endpoint == "" {
+			endpoint = c.Request.URL.Path
+		}
+		httpRequestsCancelledTotal.WithLabelValues(c.Request.Method, endpoint).Inc()
+		logInfoContext(c.Request.Context(), "Request cancelled, client disconnected", map[string]interface{}{
+			"method":     c.Request.Method,
+			"route":      endpoint,
+			"elapsed_ms": time.Since(start).Milliseconds(),
+		})
+	}
+}
@@ -0,0 +1,190 @@
+// =============================================================================
+// CANCELLATION POLICY
+// =============================================================================
+// Whether an order can still be cancelled is decided by a policy instead of
+// a fixed status check:
+//
+//   CANCEL_BLOCKED_STATUSES  Orders in these statuses are never cancelled
+//                            (default shipped,delivered)
+//   CANCEL_WINDOW            Orders older than this can't be cancelled by
+//                            customers (default 0, no limit)
+//   CANCEL_ADMIN_OVERRIDE    Operators may cancel orders past the window
+//                            through the admin API (default true)
+//
+// The override only lifts the window: a shipped order can't be cancelled
+// by anyone, it has to be returned. The policy is evaluated atomically with
+// the cancellation in both persistence modes (in the UPDATE in crud mode,
+// while deciding the event in eventsourced mode).
+//
+// Refused cancellations answer 400 with the reason ("status" or
+// "window_expired") next to the error.
+//
+// ENDPOINTS:
+// - DELETE /api/v1/orders/:id            Cancel within the policy
+// - POST   /admin/orders/:id/cancel      Cancel with the admin override
+//
+// METRICS:
+// - order_cancellations_denied_total{reason}  Refused cancellations
+// =============================================================================
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Reasons a cancellation is refused
+const (
+	cancelDeniedStatus = "status"
+	cancelDeniedWindow = "window_expired"
+)
+
+// cancelPolicy decides which orders can be cancelled
+type cancelPolicy struct {
+	blockedStatuses []string
+	window          time.Duration
+	adminOverride   bool
+}
+
+var (
+	// orderCancelPolicy is the policy applied by cancelOrder
+	orderCancelPolicy = cancelPolicy{
+		blockedStatuses: []string{"shipped", "delivered"},
+		adminOverride:   true,
+	}
+
+	// Counter: Refused cancellations by reason
+	cancellationsDeniedTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "order_cancellations_denied_total",
+			Help: "Total number of order cancellations refused by the cancellation policy, by reason",
+		},
+		[]string{"reason"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(cancellationsDeniedTotal)
+}
+
+// setCancelPolicy validates and applies the cancellation policy settings
+func setCancelPolicy(blockedStatuses []string, window time.Duration, adminOverride bool) error {
+	for _, status := range blockedStatuses {
+		if !validOrderStatuses[status] {
+			return fmt.Errorf("invalid CANCEL_BLOCKED_STATUSES entry %q", status)
+		}
+	}
+	if window < 0 {
+		return fmt.Errorf("invalid CANCEL_WINDOW %v, must not be negative", window)
+	}
+	orderCancelPolicy = cancelPolicy{
+		blockedStatuses: append([]string{}, blockedStatuses...),
+		window:          window,
+		adminOverride:   adminOverride,
+	}
+	return nil
+}
+
+// evaluate returns why the order can't be cancelled, or "" if it can
+func (p cancelPolicy) evaluate(o *Order, now time.Time, override bool) string {
+	for _, status := range p.blockedStatuses {
+		if o.Status == status {
+			return cancelDeniedStatus
+		}
+	}
+	if p.window > 0 && !override && now.Sub(o.CreatedAt) > p.window {
+		return cancelDeniedWindow
+	}
+	return ""
+}
+
+// cancelOrderByPolicy cancels an order if the policy allows it, storing
+// order.cancelled in the outbox with it. It returns whether the order
+// exists and, if it wasn't cancelled, why.
+func (a *App) cancelOrderByPolicy(ctx context.Context, id string, override bool) (found bool, denied string, err error) {
+	policy := orderCancelPolicy
+
+	events := []orderEvent{newOrderEvent("order.cancelled", id)}
+	if eventSourced() {
+		cancelled, err := a.appendOrderEvent(withOutboxEvents(ctx, events...), id, eventOrderCancelled, func(o *Order) (interface{}, bool) {
+			if denied = policy.evaluate(o, time.Now(), override); denied != "" {
+				return nil, false
+			}
+			return orderStatusData{From: o.Status, To: "cancelled"}, true
+		})
+		return cancelled || denied != "", denied, err
+	}
+
+	window := policy.window
+	if override {
+		window = 0
+	}
+	cancelled, err := a.execOrderWrite(ctx, "cancel_order", events, `
+		UPDATE orders SET status = 'cancelled', updated_at = NOW()
+		WHERE id = $1 AND status <> ALL($2)
+		  AND ($3::bigint = 0 OR created_at > NOW() - $3::bigint * INTERVAL '1 millisecond')
+	`, id, pq.Array(policy.blockedStatuses), window.Milliseconds())
+	if err != nil {
+		return false, "", err
+	}
+	if cancelled {
+		return true, "", nil
+	}
+
+	// Nothing cancelled: find out whether the order is missing or refused
+	o, err := a.fetchOrder(ctx, id)
+	if err == sql.ErrNoRows {
+		return false, "", nil
+	}
+	if err != nil {
+		return false, "", err
+	}
+	if denied = policy.evaluate(o, time.Now(), override); denied == "" {
+		// Changed between the UPDATE and the read, e.g. shipped meanwhile
+		denied = cancelDeniedStatus
+	}
+	return true, denied, nil
+}
+
+// adminCancelOrder cancels an order with the admin override of the
+// cancellation window
+func (a *App) adminCancelOrder(c *gin.Context) {
+	if !orderCancelPolicy.adminOverride {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Admin override is disabled (CANCEL_ADMIN_OVERRIDE=false)"})
+		return
+	}
+	id := c.Param("id")
+
+	found, denied, err := a.cancelOrderByPolicy(c.Request.Context(), id, true)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if !found {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Order not found"})
+		return
+	}
+	if denied != "" {
+		cancellationsDeniedTotal.WithLabelValues(denied).Inc()
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":  "Order in a blocked status (" + strings.Join(orderCancelPolicy.blockedStatuses, ", ") + ") can't be cancelled",
+			"reason": denied,
+		})
+		return
+	}
+
+	logWarnContext(c.Request.Context(), "Order cancelled with admin override", map[string]interface{}{
+		"order_id":  id,
+		"client_ip": c.ClientIP(),
+	})
+	c.JSON(http.StatusOK, gin.H{"order_id": id, "message": "Order cancelled"})
+}
@@ -0,0 +1,251 @@
+// =============================================================================
+// SKU ENRICHMENT
+// =============================================================================
+// Order items carry a client-supplied name and unit_price. With
+// SKU_ENRICHMENT enabled, createOrder looks every SKU up in the inventory
+// service (GET /api/v1/inventory/:sku) instead of trusting them:
+//
+//   Mode      Name                      Price deviation   Unknown SKU
+//   off       as submitted              not checked       accepted
+//   warn      replaced by the catalog   warning returned  warning returned
+//   enforce   replaced by the catalog   422               422
+//
+// A price deviates when it differs from the catalog price by more than
+// SKU_PRICE_TOLERANCE of it (0.05 = 5%). The inventory service doesn't
+// expose prices today; when an item has no price (or unit_price) field,
+// only its name is enriched and the price is taken as submitted.
+//
+// Lookups are cached in Redis for SKU_CACHE_TTL (unknown SKUs too, so a
+// bad SKU doesn't hit the inventory service on every order). If the
+// inventory service is unreachable or slower than SKU_LOOKUP_TIMEOUT,
+// orders are accepted as submitted: enrichment must never take order
+// intake down with it.
+//
+// METRICS:
+// - sku_lookups_total{result}              cache_hit, fetched, not_found, error
+// - sku_price_deviations_total{action}     warned, rejected
+// =============================================================================
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// skuEnrichmentModes are the accepted values of SKU_ENRICHMENT
+var skuEnrichmentModes = []string{"off", "warn", "enforce"}
+
+var (
+	// skuEnrichment is how order items are checked against the catalog
+	skuEnrichment = "off"
+
+	// skuPriceTolerance is the accepted relative price deviation
+	skuPriceTolerance = 0.05
+
+	// skuCacheTTL is how long lookups are cached in Redis
+	skuCacheTTL = 5 * time.Minute
+
+	// skuLookupTimeout bounds the lookups of one order
+	skuLookupTimeout = 500 * time.Millisecond
+
+	// Counter: SKU lookups by result
+	skuLookupsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "sku_lookups_total",
+			Help: "Total number of SKU catalog lookups, by result",
+		},
+		[]string{"result"},
+	)
+
+	// Counter: Items whose unit price deviated from the catalog, by action
+	skuPriceDeviationsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "sku_price_deviations_total",
+			Help: "Total number of order items priced outside the catalog tolerance, by action taken",
+		},
+		[]string{"action"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(skuLookupsTotal)
+	prometheus.MustRegister(skuPriceDeviationsTotal)
+}
+
+// setSKUEnrichment validates and applies the SKU enrichment settings
+func setSKUEnrichment(mode string, tolerance float64) error {
+	valid := false
+	for _, m := range skuEnrichmentModes {
+		valid = valid || m == mode
+	}
+	if !valid {
+		return fmt.Errorf("invalid SKU_ENRICHMENT %q, expected one of %v", mode, skuEnrichmentModes)
+	}
+	if tolerance < 0 {
+		return fmt.Errorf("invalid SKU_PRICE_TOLERANCE %v, must not be negative", tolerance)
+	}
+	skuEnrichment = mode
+	skuPriceTolerance = tolerance
+	return nil
+}
+
+// skuInfo is the cached catalog entry of a SKU
+type skuInfo struct {
+	Found bool     `json:"found"`
+	Name  string   `json:"name,omitempty"`
+	Price *float64 `json:"price,omitempty"`
+}
+
+// ItemWarning is a catalog discrepancy of an order item
+type ItemWarning struct {
+	SKU          string   `json:"sku"`
+	Issue        string   `json:"issue"`
+	UnitPrice    float64  `json:"unit_price,omitempty"`
+	CatalogPrice *float64 `json:"catalog_price,omitempty"`
+}
+
+// errUnknownSKU is returned by fetchSKU for SKUs the inventory service
+// doesn't know
+var errUnknownSKU = errors.New("unknown SKU")
+
+// lookupSKU returns the catalog entry of a SKU, from the cache if possible
+func (a *App) lookupSKU(ctx context.Context, sku string) (skuInfo, error) {
+	key := cacheKeyPrefix + "sku:" + sku
+	if data, err := a.redisClient.Get(ctx, key).Bytes(); err == nil {
+		var info skuInfo
+		if json.Unmarshal(data, &info) == nil {
+			skuLookupsTotal.WithLabelValues("cache_hit").Inc()
+			return info, nil
+		}
+	} else if !errors.Is(err, redis.Nil) {
+		logDebugContext(ctx, "SKU cache unavailable", map[string]interface{}{"sku": sku, "error": err.Error()})
+	}
+
+	info, err := a.fetchSKU(ctx, sku)
+	switch {
+	case errors.Is(err, errUnknownSKU):
+		skuLookupsTotal.WithLabelValues("not_found").Inc()
+	case err != nil:
+		skuLookupsTotal.WithLabelValues("error").Inc()
+		return skuInfo{}, err
+	default:
+		skuLookupsTotal.WithLabelValues("fetched").Inc()
+	}
+
+	if data, err := json.Marshal(info); err == nil {
+		a.redisClient.Set(ctx, key, data, skuCacheTTL)
+	}
+	return info, nil
+}
+
+// fetchSKU reads a SKU from the inventory service
+func (a *App) fetchSKU(ctx context.Context, sku string) (skuInfo, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet,
+		a.inventoryServiceURL+"/api/v1/inventory/"+url.PathEscape(sku), nil)
+	if err != nil {
+		return skuInfo{}, err
+	}
+
+	resp, err := a.httpClient.Do(req)
+	if err != nil {
+		return skuInfo{}, fmt.Errorf("inventory service unreachable: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return skuInfo{Found: false}, errUnknownSKU
+	default:
+		return skuInfo{}, fmt.Errorf("inventory service returned status %d", resp.StatusCode)
+	}
+
+	var item struct {
+		Name      string         `json:"name"`
+		Price     *paymentAmount `json:"price"`
+		UnitPrice *paymentAmount `json:"unit_price"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&item); err != nil {
+		return skuInfo{}, fmt.Errorf("invalid inventory service response: %w", err)
+	}
+
+	info := skuInfo{Found: true, Name: item.Name}
+	for _, p := range []*paymentAmount{item.Price, item.UnitPrice} {
+		if p != nil {
+			price := float64(*p)
+			info.Price = &price
+			break
+		}
+	}
+	return info, nil
+}
+
+// enrichOrderItems replaces item names with their catalog names and
+// checks unit prices against the catalog. It returns the discrepancies
+// found and whether they should reject the order.
+func (a *App) enrichOrderItems(ctx context.Context, items []OrderItemRequest) (warnings []ItemWarning, reject bool) {
+	if skuEnrichment == "off" {
+		return nil, false
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, skuLookupTimeout)
+	defer cancel()
+
+	catalog := make(map[string]skuInfo, len(items))
+	for i := range items {
+		item := &items[i]
+		info, seen := catalog[item.SKU]
+		if !seen {
+			var err error
+			info, err = a.lookupSKU(ctx, item.SKU)
+			if err != nil {
+				// Fail open, see above
+				logWarnContext(ctx, "SKU lookup failed, accepting item as submitted", map[string]interface{}{
+					"sku":   item.SKU,
+					"error": err.Error(),
+				})
+				continue
+			}
+			catalog[item.SKU] = info
+		}
+
+		if !info.Found {
+			warnings = append(warnings, ItemWarning{SKU: item.SKU, Issue: "unknown_sku"})
+			continue
+		}
+		if info.Name != "" {
+			item.Name = info.Name
+		}
+		if info.Price != nil && math.Abs(item.UnitPrice-*info.Price) > *info.Price*skuPriceTolerance {
+			warnings = append(warnings, ItemWarning{
+				SKU:          item.SKU,
+				Issue:        "price_deviation",
+				UnitPrice:    item.UnitPrice,
+				CatalogPrice: info.Price,
+			})
+		}
+	}
+
+	reject = len(warnings) > 0 && skuEnrichment == "enforce"
+	for _, w := range warnings {
+		if w.Issue != "price_deviation" {
+			continue
+		}
+		if reject {
+			skuPriceDeviationsTotal.WithLabelValues("rejected").Inc()
+		} else {
+			skuPriceDeviationsTotal.WithLabelValues("warned").Inc()
+		}
+	}
+	return warnings, reject
+}
@@ -0,0 +1,321 @@
+// =============================================================================
+// CHAOS INJECTION
+// =============================================================================
+// /admin/chaos injects faults into the public API so the lab can show
+// alerts firing and SLOs burning without breaking real infrastructure.
+//
+// A rule targets a route (or "*" for all routes) and can:
+// - add latency       latency_ms, plus up to latency_jitter_ms at random
+// - return errors     error_rate (0-1) of requests get error_status
+// - drop responses    drop_rate (0-1) of connections are closed without
+//                     any response, like a crashed upstream
+//
+// Every rule has a TTL, so a forgotten experiment cleans itself up.
+// Injected errors carry an X-Chaos-Injected header and every injection is
+// counted in chaos_injections_total, so they're never mistaken for real
+// failures. Rules can only be created when CHAOS_ENABLED is true.
+//
+// ENDPOINTS:
+// - GET    /admin/chaos       List active rules
+// - POST   /admin/chaos       Add a rule
+// - DELETE /admin/chaos/:id   Remove a rule
+// - DELETE /admin/chaos       Remove all rules
+// =============================================================================
+
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// chaosHeader marks responses produced by fault injection
+const chaosHeader = "X-Chaos-Injected"
+
+// maxChaosTTL is the longest a chaos rule may stay active
+const maxChaosTTL = 24 * time.Hour
+
+// ChaosRule is a fault injected into matching requests
+type ChaosRule struct {
+	ID              int       `json:"id"`
+	Method          string    `json:"method"`
+	Route           string    `json:"route"`
+	LatencyMs       int       `json:"latency_ms,omitempty"`
+	LatencyJitterMs int       `json:"latency_jitter_ms,omitempty"`
+	ErrorRate       float64   `json:"error_rate,omitempty"`
+	ErrorStatus     int       `json:"error_status,omitempty"`
+	DropRate        float64   `json:"drop_rate,omitempty"`
+	CreatedAt       time.Time `json:"created_at"`
+	ExpiresAt       time.Time `json:"expires_at"`
+}
+
+// matches reports whether the rule applies to a request
+func (r *ChaosRule) matches(method, route string, now time.Time) bool {
+	return now.Before(r.ExpiresAt) &&
+		(r.Method == "*" || r.Method == method) &&
+		(r.Route == "*" || r.Route == route)
+}
+
+// chaosState holds the active chaos rules
+type chaosState struct {
+	mu     sync.RWMutex
+	rules  map[int]*ChaosRule
+	nextID int
+}
+
+var (
+	// chaosEnabled allows chaos rules to be created
+	chaosEnabled bool
+
+	// chaos holds the process-wide chaos rules
+	chaos = &chaosState{rules: map[int]*ChaosRule{}}
+
+	// Counter: Faults injected, by route and type
+	chaosInjectionsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "chaos_injections_total",
+			Help: "Total number of faults injected into requests",
+		},
+		[]string{"endpoint", "type"},
+	)
+
+	// Gauge: Active chaos rules
+	chaosRulesActive = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "chaos_rules_active",
+			Help: "Number of active chaos rules",
+		},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(chaosInjectionsTotal)
+	prometheus.MustRegister(chaosRulesActive)
+}
+
+// add stores a rule and returns it with its ID
+func (s *chaosState) add(rule ChaosRule) ChaosRule {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.nextID++
+	rule.ID = s.nextID
+	s.rules[rule.ID] = &rule
+	s.pruneLocked(time.Now())
+	return rule
+}
+
+// remove deletes a rule, reporting whether it existed
+func (s *chaosState) remove(id int) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, ok := s.rules[id]
+	delete(s.rules, id)
+	s.pruneLocked(time.Now())
+	return ok
+}
+
+// clear deletes every rule and returns how many there were
+func (s *chaosState) clear() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n := len(s.rules)
+	s.rules = map[int]*ChaosRule{}
+	chaosRulesActive.Set(0)
+	return n
+}
+
+// list returns the active rules ordered by ID
+func (s *chaosState) list() []ChaosRule {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.pruneLocked(time.Now())
+	rules := make([]ChaosRule, 0, len(s.rules))
+	for _, r := range s.rules {
+		rules = append(rules, *r)
+	}
+	sort.Slice(rules, func(i, j int) bool { return rules[i].ID < rules[j].ID })
+	return rules
+}
+
+// match returns the active rules that apply to a request
+func (s *chaosState) match(method, route string) []ChaosRule {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if len(s.rules) == 0 {
+		return nil
+	}
+
+	now := time.Now()
+	var matched []ChaosRule
+	for _, r := range s.rules {
+		if r.matches(method, route, now) {
+			matched = append(matched, *r)
+		}
+	}
+	return matched
+}
+
+// pruneLocked removes expired rules. s.mu must be held for writing.
+func (s *chaosState) pruneLocked(now time.Time) {
+	for id, r := range s.rules {
+		if !now.Before(r.ExpiresAt) {
+			delete(s.rules, id)
+		}
+	}
+	chaosRulesActive.Set(float64(len(s.rules)))
+}
+
+// chaosMiddleware applies matching chaos rules to API requests
+func chaosMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		route := c.FullPath()
+		rules := chaos.match(c.Request.Method, route)
+		if len(rules) == 0 {
+			c.Next()
+			return
+		}
+
+		for _, r := range rules {
+			if r.LatencyMs > 0 || r.LatencyJitterMs > 0 {
+				delay := time.Duration(r.LatencyMs) * time.Millisecond
+				if r.LatencyJitterMs > 0 {
+					delay += time.Duration(rand.Intn(r.LatencyJitterMs)) * time.Millisecond
+				}
+				chaosInjectionsTotal.WithLabelValues(route, "latency").Inc()
+				select {
+				case <-time.After(delay):
+				case <-c.Request.Context().Done():
+					return
+				}
+			}
+
+			if r.DropRate > 0 && rand.Float64() < r.DropRate {
+				chaosInjectionsTotal.WithLabelValues(route, "drop").Inc()
+				// Aborts the connection without writing a response
+				panic(http.ErrAbortHandler)
+			}
+
+			if r.ErrorRate > 0 && rand.Float64() < r.ErrorRate {
+				chaosInjectionsTotal.WithLabelValues(route, "error").Inc()
+				c.Header(chaosHeader, fmt.Sprintf("error; rule=%d", r.ID))
+				c.AbortWithStatusJSON(r.ErrorStatus, gin.H{
+					"error": "Injected failure (chaos rule)",
+				})
+				return
+			}
+		}
+
+		c.Next()
+	}
+}
+
+// =============================================================================
+// CHAOS ADMIN HANDLERS
+// =============================================================================
+
+// listChaosRules returns the active chaos rules
+func listChaosRules(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"enabled": chaosEnabled, "rules": chaos.list()})
+}
+
+// addChaosRule validates and activates a chaos rule
+func addChaosRule(c *gin.Context) {
+	if !chaosEnabled {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Chaos features are disabled (CHAOS_ENABLED=false)"})
+		return
+	}
+
+	var req struct {
+		Method          string  `json:"method"`
+		Route           string  `json:"route" binding:"required"`
+		LatencyMs       int     `json:"latency_ms" binding:"min=0"`
+		LatencyJitterMs int     `json:"latency_jitter_ms" binding:"min=0"`
+		ErrorRate       float64 `json:"error_rate" binding:"min=0,max=1"`
+		ErrorStatus     int     `json:"error_status"`
+		DropRate        float64 `json:"drop_rate" binding:"min=0,max=1"`
+		TTLSeconds      int     `json:"ttl_seconds" binding:"min=0"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if req.LatencyMs == 0 && req.LatencyJitterMs == 0 && req.ErrorRate == 0 && req.DropRate == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Rule injects nothing: set latency_ms, error_rate or drop_rate"})
+		return
+	}
+	if req.Method == "" {
+		req.Method = "*"
+	}
+	if req.ErrorStatus == 0 {
+		req.ErrorStatus = http.StatusInternalServerError
+	}
+	if req.ErrorStatus < 400 || req.ErrorStatus > 599 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "error_status must be between 400 and 599"})
+		return
+	}
+
+	ttl := 5 * time.Minute
+	if req.TTLSeconds > 0 {
+		ttl = time.Duration(req.TTLSeconds) * time.Second
+	}
+	if ttl > maxChaosTTL {
+		ttl = maxChaosTTL
+	}
+
+	now := time.Now().UTC()
+	rule := chaos.add(ChaosRule{
+		Method:          req.Method,
+		Route:           req.Route,
+		LatencyMs:       req.LatencyMs,
+		LatencyJitterMs: req.LatencyJitterMs,
+		ErrorRate:       req.ErrorRate,
+		ErrorStatus:     req.ErrorStatus,
+		DropRate:        req.DropRate,
+		CreatedAt:       now,
+		ExpiresAt:       now.Add(ttl),
+	})
+
+	logWarnContext(c.Request.Context(), "Chaos rule added", map[string]interface{}{
+		"rule_id":    rule.ID,
+		"method":     rule.Method,
+		"route":      rule.Route,
+		"latency_ms": rule.LatencyMs,
+		"error_rate": rule.ErrorRate,
+		"drop_rate":  rule.DropRate,
+		"expires_at": rule.ExpiresAt,
+	})
+
+	c.JSON(http.StatusCreated, rule)
+}
+
+// deleteChaosRule removes a single chaos rule
+func deleteChaosRule(c *gin.Context) {
+	var id int
+	if _, err := fmt.Sscanf(c.Param("id"), "%d", &id); err != nil || !chaos.remove(id) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Chaos rule not found"})
+		return
+	}
+
+	logInfoContext(c.Request.Context(), "Chaos rule removed", map[string]interface{}{"rule_id": id})
+	c.JSON(http.StatusOK, gin.H{"message": "Chaos rule removed", "id": id})
+}
+
+// clearChaosRules removes every chaos rule
+func clearChaosRules(c *gin.Context) {
+	removed := chaos.clear()
+	logInfoContext(c.Request.Context(), "Chaos rules cleared", map[string]interface{}{"removed": removed})
+	c.JSON(http.StatusOK, gin.H{"message": "Chaos rules cleared", "removed": removed})
+}
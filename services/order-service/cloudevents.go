@@ -0,0 +1,175 @@
+// =============================================================================
+// CLOUDEVENTS
+// =============================================================================
+// Events are published as CloudEvents 1.0 (structured mode, JSON), so other
+// teams can consume them with the CloudEvents SDKs, routers and schema
+// tools instead of parsing our own envelope:
+//
+//   {
+//     "specversion": "1.0",
+//     "id": "5b0c...",
+//     "source": "/order-service",
+//     "type": "order.status.shipped",
+//     "subject": "<order id>",
+//     "time": "2025-03-14T09:26:53Z",
+//     "datacontenttype": "application/json",
+//     "data": {"event": "order.status.shipped", "order_id": "...",
+//              "timestamp": "...", "order": {...}}
+//   }
+//
+// - type is the routing key; source is EVENT_SOURCE
+// - subject is the order ID, for events about one order
+// - data is the event as it was built (the flat JSON consumers of the json
+//   format get), plus the full order with its items as "order" for order.*
+//   events. Events written to the outbox carry the order as the write that
+//   produced them left it (snapshotted in the same transaction, see
+//   outbox.go), however late they are relayed. Other events get the order
+//   as it is when they are published. Orders that no longer exist are left
+//   out.
+// - id is the event's event_id, so an event published again (after a nack
+//   or from the outbox) keeps its ID and consumers can deduplicate. Events
+//   built without one get a hash of their routing key and body.
+//
+// The AMQP message has content type application/cloudevents+json, and the
+// id, type and time in its message-id, type and timestamp properties.
+// cloudevents is the default EVENT_FORMAT; json and protobuf (see
+// eventformat.go) remain available per routing key.
+// =============================================================================
+
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+const (
+	eventFormatCloudEvents = "cloudevents"
+
+	// cloudEventsContentType is the content type of structured mode events
+	cloudEventsContentType = "application/cloudevents+json"
+)
+
+// eventSource is the CloudEvents source of published events
+var eventSource = "/order-service"
+
+// cloudEvent is a CloudEvents 1.0 envelope
+type cloudEvent struct {
+	SpecVersion     string          `json:"specversion"`
+	ID              string          `json:"id"`
+	Source          string          `json:"source"`
+	Type            string          `json:"type"`
+	Subject         string          `json:"subject,omitempty"`
+	Time            time.Time       `json:"time"`
+	DataContentType string          `json:"datacontenttype"`
+	Data            json.RawMessage `json:"data"`
+}
+
+// orderEventBody is the body of the events newOrderEvent builds
+type orderEventBody struct {
+	Event     string `json:"event"`
+	EventID   string `json:"event_id"`
+	OrderID   string `json:"order_id"`
+	Timestamp string `json:"timestamp"`
+}
+
+// setEventSource validates and applies EVENT_SOURCE
+func setEventSource(source string) error {
+	if source == "" || strings.ContainsAny(source, " \t\n") {
+		return fmt.Errorf("EVENT_SOURCE must be a non-empty URI reference, got %q", source)
+	}
+	eventSource = source
+	return nil
+}
+
+// buildCloudEvent wraps an event in its CloudEvents envelope
+func (a *App) buildCloudEvent(ctx context.Context, event orderEvent) (cloudEvent, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(event.Body, &fields); err != nil {
+		return cloudEvent{}, err
+	}
+	var meta struct {
+		EventID   string `json:"event_id"`
+		Timestamp string `json:"timestamp"`
+	}
+	json.Unmarshal(event.Body, &meta)
+	timestamp, err := time.Parse(time.RFC3339, meta.Timestamp)
+	if err != nil {
+		timestamp = time.Now().Truncate(time.Second)
+	}
+
+	ce := cloudEvent{
+		SpecVersion:     "1.0",
+		ID:              meta.EventID,
+		Source:          eventSource,
+		Type:            event.RoutingKey,
+		Subject:         event.OrderID,
+		Time:            timestamp.UTC(),
+		DataContentType: "application/json",
+		Data:            event.Body,
+	}
+	if ce.ID == "" {
+		sum := sha256.Sum256(append([]byte(event.RoutingKey+"\n"), event.Body...))
+		ce.ID = hex.EncodeToString(sum[:16])
+	}
+
+	// Order events carry the full order
+	if carriesOrder(event) {
+		if order, err := a.eventOrder(ctx, event); err == nil {
+			if fields["order"], err = json.Marshal(order); err != nil {
+				return cloudEvent{}, err
+			}
+			data, err := json.Marshal(fields)
+			if err != nil {
+				return cloudEvent{}, err
+			}
+			ce.Data = data
+		}
+	}
+	return ce, nil
+}
+
+// carriesOrder reports whether an event is about an order, and carries it
+func carriesOrder(event orderEvent) bool {
+	return event.OrderID != "" && strings.HasPrefix(event.RoutingKey, "order.")
+}
+
+// eventOrder returns the order of an event: its snapshot if it has one,
+// the order as it is now otherwise
+func (a *App) eventOrder(ctx context.Context, event orderEvent) (*Order, error) {
+	if len(event.Order) == 0 {
+		return a.fetchOrder(ctx, event.OrderID)
+	}
+	var order Order
+	if err := json.Unmarshal(event.Order, &order); err != nil {
+		return nil, err
+	}
+	return &order, nil
+}
+
+// encodeCloudEvent builds the AMQP message of an event as a structured
+// mode CloudEvent
+func (a *App) encodeCloudEvent(ctx context.Context, event orderEvent) (amqp.Publishing, error) {
+	ce, err := a.buildCloudEvent(ctx, event)
+	if err != nil {
+		return amqp.Publishing{}, err
+	}
+	body, err := json.Marshal(ce)
+	if err != nil {
+		return amqp.Publishing{}, err
+	}
+	return amqp.Publishing{
+		ContentType: cloudEventsContentType,
+		MessageId:   ce.ID,
+		Type:        ce.Type,
+		Timestamp:   ce.Time,
+		Body:        body,
+	}, nil
+}
@@ -0,0 +1,99 @@
+// =============================================================================
+// ORDER SERVICE BENCHMARKS
+// =============================================================================
+// Runs the benchmarks in package bench and writes a JSON report. Given a
+// baseline report, it exits with status 1 if anything regressed.
+//
+//   go run ./cmd/bench -out baseline.json
+//   go run ./cmd/bench -baseline baseline.json -tolerance 0.15
+//
+// HTTP benchmarks create real orders; point -target at a lab instance,
+// never at shared data.
+// =============================================================================
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"order-service/bench"
+)
+
+func main() {
+	target := flag.String("target", "http://localhost:8001", "Public API base URL")
+	internal := flag.String("internal", "http://localhost:9001", "Internal base URL, used to read the service version")
+	only := flag.String("only", "serialize,create,list", "Comma-separated benchmarks to run")
+	concurrency := flag.Int("concurrency", 8, "Parallel clients for HTTP benchmarks")
+	duration := flag.Duration("duration", 10*time.Second, "Duration of each HTTP benchmark")
+	seed := flag.Int64("seed", 1, "Seed for generated request payloads")
+	out := flag.String("out", "", "Write the JSON report to this file (default stdout)")
+	baseline := flag.String("baseline", "", "Compare against this report and fail on regressions")
+	tolerance := flag.Float64("tolerance", 0.1, "Accepted relative regression, e.g. 0.1 for 10%")
+	flag.Parse()
+
+	opts := bench.Options{
+		BaseURL:     strings.TrimRight(*target, "/"),
+		Concurrency: *concurrency,
+		Duration:    *duration,
+		Seed:        *seed,
+	}
+	report := bench.NewReport(opts)
+	report.Version = bench.ServiceVersion(strings.TrimRight(*internal, "/"))
+
+	for _, name := range strings.Split(*only, ",") {
+		var result bench.Result
+		switch strings.TrimSpace(name) {
+		case "serialize":
+			for _, n := range []int{20, 1000} {
+				report.Results = append(report.Results, bench.Serialize(n))
+			}
+			continue
+		case "create":
+			result = bench.CreateOrder(opts)
+		case "list":
+			result = bench.ListOrders(opts)
+		default:
+			fmt.Fprintf(os.Stderr, "unknown benchmark %q\n", name)
+			os.Exit(2)
+		}
+		report.Results = append(report.Results, result)
+	}
+
+	data, _ := json.MarshalIndent(report, "", "  ")
+	if *out != "" {
+		if err := os.WriteFile(*out, data, 0o644); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to write report: %v\n", err)
+			os.Exit(2)
+		}
+	} else {
+		fmt.Println(string(data))
+	}
+
+	if *baseline == "" {
+		return
+	}
+
+	raw, err := os.ReadFile(*baseline)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to read baseline: %v\n", err)
+		os.Exit(2)
+	}
+	var base bench.Report
+	if err := json.Unmarshal(raw, &base); err != nil {
+		fmt.Fprintf(os.Stderr, "invalid baseline: %v\n", err)
+		os.Exit(2)
+	}
+
+	regressions := bench.Compare(&base, report, *tolerance)
+	for _, r := range regressions {
+		fmt.Fprintln(os.Stderr, "REGRESSION", r)
+	}
+	if len(regressions) > 0 {
+		os.Exit(1)
+	}
+}
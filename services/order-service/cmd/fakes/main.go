@@ -0,0 +1,115 @@
+// =============================================================================
+// FAKE DOWNSTREAM SERVICES
+// =============================================================================
+// Serves in-memory fakes of the inventory, payment, user and notification
+// services (see package fakes) on the ports the order service expects by
+// default, so it can run locally with only Postgres, Redis and RabbitMQ.
+//
+//   go run ./cmd/fakes
+//   go run ./cmd/fakes -latency 150ms -jitter 50ms -failure-rate 0.05
+//   go run ./cmd/fakes -stock SKU-0001=500,CANARY-001=1000000
+//
+// Behavior can also be changed per fake while it runs:
+//
+//   curl -X PUT localhost:8003/_fake/behavior -d '{"failure_rate": 0.5}'
+// =============================================================================
+
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"order-service/fakes"
+)
+
+func main() {
+	inventoryAddr := flag.String("inventory", ":8002", "Inventory service listen address")
+	paymentAddr := flag.String("payment", ":8003", "Payment service listen address")
+	userAddr := flag.String("user", ":8004", "User service listen address")
+	notificationAddr := flag.String("notification", ":8005", "Notification service listen address")
+	latency := flag.Duration("latency", 0, "Latency added to every API request")
+	jitter := flag.Duration("jitter", 0, "Up to this much extra random latency per request")
+	failureRate := flag.Float64("failure-rate", 0, "Share of API requests (0-1) that fail")
+	failureStatus := flag.Int("failure-status", http.StatusServiceUnavailable, "Status code of failed requests")
+	stock := flag.String("stock", "SKU-0001=1000,CANARY-001=1000000", "Initial inventory as comma-separated sku=quantity pairs")
+	flag.Parse()
+
+	if *failureRate < 0 || *failureRate > 1 {
+		fmt.Fprintln(os.Stderr, "-failure-rate must be between 0 and 1")
+		os.Exit(2)
+	}
+
+	inventory := fakes.NewInventory()
+	for _, pair := range strings.Split(*stock, ",") {
+		if pair == "" {
+			continue
+		}
+		sku, qty, ok := strings.Cut(pair, "=")
+		n, err := strconv.Atoi(qty)
+		if !ok || sku == "" || err != nil {
+			fmt.Fprintf(os.Stderr, "invalid -stock entry %q, expected sku=quantity\n", pair)
+			os.Exit(2)
+		}
+		inventory.SetStock(sku, n)
+	}
+
+	behavior := fakes.Behavior{
+		Latency:       *latency,
+		Jitter:        *jitter,
+		FailureRate:   *failureRate,
+		FailureStatus: *failureStatus,
+	}
+	services := []struct {
+		name    string
+		addr    string
+		handler interface {
+			http.Handler
+			SetBehavior(fakes.Behavior)
+		}
+	}{
+		{"inventory", *inventoryAddr, inventory},
+		{"payment", *paymentAddr, fakes.NewPayment()},
+		{"user", *userAddr, fakes.NewUser()},
+		{"notification", *notificationAddr, fakes.NewNotification()},
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	var wg sync.WaitGroup
+	servers := make([]*http.Server, 0, len(services))
+	for _, svc := range services {
+		svc.handler.SetBehavior(behavior)
+		srv := &http.Server{Addr: svc.addr, Handler: svc.handler, ReadHeaderTimeout: 5 * time.Second}
+		servers = append(servers, srv)
+
+		wg.Add(1)
+		go func(name string) {
+			defer wg.Done()
+			log.Printf("Fake %s service listening on %s", name, srv.Addr)
+			if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				log.Printf("Fake %s service failed: %v", name, err)
+				stop()
+			}
+		}(svc.name)
+	}
+
+	<-ctx.Done()
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for _, srv := range servers {
+		srv.Shutdown(shutdownCtx)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,42 @@
+// =============================================================================
+// JSON ENCODING BENCHMARK
+// =============================================================================
+// Compares allocations of a plain json.Marshal with the pooled jsonenc
+// encoder for order lists of increasing size.
+//
+//   go run ./cmd/jsonbench
+//   go run -tags segmentio ./cmd/jsonbench
+// =============================================================================
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"order-service/jsonenc"
+)
+
+func main() {
+	sizes := flag.String("sizes", "20,100,1000", "Comma-separated order list sizes to benchmark")
+	flag.Parse()
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "encoder\torders\tns/op\tallocs/op\tB/op\t")
+
+	for _, s := range strings.Split(*sizes, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(s))
+		if err != nil || n <= 0 {
+			fmt.Fprintf(os.Stderr, "invalid size %q\n", s)
+			os.Exit(2)
+		}
+		for _, r := range jsonenc.Compare(n) {
+			fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%d\t\n", r.Name, r.Orders, r.NsPerOp, r.AllocsPerOp, r.BytesPerOp)
+		}
+	}
+	w.Flush()
+}
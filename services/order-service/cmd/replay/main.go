@@ -0,0 +1,81 @@
+// =============================================================================
+// REQUEST REPLAY
+// =============================================================================
+// Replays requests recorded by the order service (see package recording)
+// against a running instance, at the original pace or faster.
+//
+//   go run ./cmd/replay -file requests.ndjson -target http://localhost:8001
+//   go run ./cmd/replay -redis redis://localhost:6379/0 -speed 10
+//
+// Replayed requests create real orders; point -target at a lab instance,
+// never at shared data.
+// =============================================================================
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+
+	"github.com/go-redis/redis/v8"
+
+	"order-service/recording"
+)
+
+func main() {
+	target := flag.String("target", "http://localhost:8001", "Public API base URL to replay against")
+	file := flag.String("file", "", "Read records from this newline-delimited JSON file")
+	redisURL := flag.String("redis", "", "Read records from the Redis stream at this URL instead")
+	stream := flag.String("stream", "order-service:recordings", "Redis stream holding the records")
+	limit := flag.Int64("limit", 100000, "Maximum number of records to read from Redis")
+	speed := flag.Float64("speed", 1, "Pace relative to the recording, e.g. 4 for 4x faster; 0 sends as fast as possible")
+	maxInFlight := flag.Int("max-in-flight", 64, "Maximum concurrent requests")
+	flag.Parse()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	var records []recording.Record
+	var err error
+	switch {
+	case *file != "":
+		records, err = recording.ReadFile(*file)
+	case *redisURL != "":
+		opts, perr := redis.ParseURL(*redisURL)
+		if perr != nil {
+			fmt.Fprintf(os.Stderr, "invalid Redis URL: %v\n", perr)
+			os.Exit(2)
+		}
+		client := redis.NewClient(opts)
+		defer client.Close()
+		records, err = recording.ReadStream(ctx, client, *stream, *limit)
+	default:
+		fmt.Fprintln(os.Stderr, "either -file or -redis is required")
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to read records: %v\n", err)
+		os.Exit(2)
+	}
+	if len(records) == 0 {
+		fmt.Fprintln(os.Stderr, "no records to replay")
+		return
+	}
+
+	fmt.Fprintf(os.Stderr, "replaying %d requests at %gx against %s\n", len(records), *speed, *target)
+	result := recording.Replay(ctx, records, recording.Options{
+		BaseURL:     *target,
+		Speed:       *speed,
+		MaxInFlight: *maxInFlight,
+	})
+
+	data, _ := json.MarshalIndent(result, "", "  ")
+	fmt.Println(string(data))
+	if result.Errors > 0 {
+		os.Exit(1)
+	}
+}
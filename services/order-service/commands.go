@@ -0,0 +1,463 @@
+// =============================================================================
+// INBOUND COMMANDS
+// =============================================================================
+// Other services can drive order changes asynchronously by publishing
+// commands to the orders exchange instead of calling the HTTP API:
+//
+//   orders.command.create   Body: a create order request (as POST /api/v1/orders)
+//   orders.command.cancel   Body: {"order_id": "..."}
+//
+// With COMMANDS_ENABLED, worker processes consume them from COMMAND_QUEUE,
+// which is declared durable and bound to orders.command.*. Commands go
+// through the same validation, limits and policies as HTTP requests
+// (binding rules, request limits, catalog check, duplicate detection,
+// cancellation policy).
+//
+// IDEMPOTENCY:
+// A command's AMQP message_id is its idempotency key. The order write of a
+// command records the key in processed_commands, in the write's own
+// transaction (see saveToOutboxTx), so an order is never written twice for
+// one message_id: not for a redelivery, and not for two deliveries running
+// at once, as the second one's insert hits the primary key and rolls its
+// write back. The reply is stored with the key once the command is done.
+// A delivery whose key is already recorded is not executed again, it gets
+// the stored reply. Keys are deleted after COMMAND_IDEMPOTENCY_TTL by the
+// command-cleanup job. Commands without a message_id are executed every
+// time.
+//
+// REPLIES:
+// If a command has a reply_to, a reply is published to that queue through
+// the default exchange, with the command's correlation_id:
+//
+//   {"command": "create", "status": "accepted", "order_id": "..."}
+//   {"command": "create", "status": "rejected", "error": "Validation failed",
+//    "violations": [...]}
+//
+// status is accepted, rejected (the command is invalid or refused by a
+// policy, retrying won't help) or failed (the service couldn't execute it).
+// A failed command is requeued once; if it fails again it is rejected
+// without requeueing, so it goes to the queue's dead letter exchange if
+// one is configured.
+//
+// METRICS:
+// - order_commands_total{command,result}     accepted, rejected, failed,
+//                                            duplicate
+// - order_command_duration_seconds{command}
+// =============================================================================
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+	"github.com/prometheus/client_golang/prometheus"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// commandRoutingPrefix prefixes the routing keys of commands
+const commandRoutingPrefix = "orders.command."
+
+// commandConsumerTag identifies the command consumer on its channel
+const commandConsumerTag = "order-service-commands"
+
+// Results of a command
+const (
+	commandAccepted = "accepted"
+	commandRejected = "rejected"
+	commandFailed   = "failed"
+)
+
+var (
+	// commandIdempotencyTTL is how long executed commands are remembered
+	commandIdempotencyTTL = 24 * time.Hour
+
+	// Counter: Commands consumed, by command and result
+	orderCommandsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "order_commands_total",
+			Help: "Total number of order commands consumed from RabbitMQ, by command and result",
+		},
+		[]string{"command", "result"},
+	)
+
+	// Histogram: Command execution time
+	orderCommandDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "order_command_duration_seconds",
+			Help:    "Time taken to execute an order command",
+			Buckets: prometheus.DefBuckets,
+		},
+		[]string{"command"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(orderCommandsTotal)
+	prometheus.MustRegister(orderCommandDuration)
+}
+
+// CommandReply is the reply to a command
+type CommandReply struct {
+	Command     string        `json:"command"`
+	Status      string        `json:"status"`
+	OrderID     string        `json:"order_id,omitempty"`
+	OrderNumber string        `json:"order_number,omitempty"`
+	Error       string        `json:"error,omitempty"`
+	Reason      string        `json:"reason,omitempty"`
+	Violations  []Violation   `json:"violations,omitempty"`
+	Warnings    []ItemWarning `json:"warnings,omitempty"`
+	DuplicateOf string        `json:"duplicate_of,omitempty"`
+}
+
+// cancelCommand is the body of orders.command.cancel
+type cancelCommand struct {
+	OrderID string `json:"order_id" binding:"required"`
+}
+
+// startCommandConsumer declares the command queue and consumes it until
+// shutdown
+func (a *App) startCommandConsumer(queue string, prefetch int) error {
+	err := a.startConsumer(&rabbitConsumer{
+		name: "command-consumer",
+		tag:  commandConsumerTag,
+		subscribe: func(conn *amqp.Connection) (*amqp.Channel, <-chan amqp.Delivery, error) {
+			return subscribeCommandQueue(conn, queue, prefetch)
+		},
+		handle: func(ch *amqp.Channel, d amqp.Delivery) {
+			a.handleCommand(context.Background(), ch, d)
+		},
+	})
+	if err != nil {
+		return err
+	}
+	log.Printf("Consuming order commands from %s", queue)
+	return nil
+}
+
+// subscribeCommandQueue opens a channel, declares and binds the command
+// queue and consumes it
+func subscribeCommandQueue(conn *amqp.Connection, queue string, prefetch int) (*amqp.Channel, <-chan amqp.Delivery, error) {
+	ch, err := conn.Channel()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open command channel: %w", err)
+	}
+
+	if _, err := ch.QueueDeclare(queue, true, false, false, false, nil); err != nil {
+		ch.Close()
+		return nil, nil, fmt.Errorf("failed to declare command queue: %w", err)
+	}
+	if err := ch.QueueBind(queue, commandRoutingPrefix+"*", "orders", false, nil); err != nil {
+		ch.Close()
+		return nil, nil, fmt.Errorf("failed to bind command queue: %w", err)
+	}
+	if err := ch.Qos(prefetch, 0, false); err != nil {
+		ch.Close()
+		return nil, nil, fmt.Errorf("failed to set command prefetch: %w", err)
+	}
+	deliveries, err := ch.Consume(queue, commandConsumerTag, false, false, false, false, nil)
+	if err != nil {
+		ch.Close()
+		return nil, nil, fmt.Errorf("failed to consume command queue: %w", err)
+	}
+	return ch, deliveries, nil
+}
+
+// handleCommand executes, acknowledges and answers one command delivery
+func (a *App) handleCommand(ctx context.Context, ch *amqp.Channel, d amqp.Delivery) {
+	start := time.Now()
+	command := strings.TrimPrefix(d.RoutingKey, commandRoutingPrefix)
+	if command != "create" && command != "cancel" {
+		// Keeps the metric labels bounded
+		command = "unknown"
+	}
+
+	if d.MessageId != "" {
+		if a.skipProcessedCommand(ctx, ch, d, command) {
+			return
+		}
+		ctx = withCommandID(ctx, d.MessageId, command)
+	}
+
+	reply := a.executeCommand(ctx, command, d.Body)
+	orderCommandDuration.WithLabelValues(command).Observe(time.Since(start).Seconds())
+
+	if reply.Status == commandFailed {
+		// A concurrent delivery of the same command may have won the key
+		if d.MessageId != "" && a.skipProcessedCommand(ctx, ch, d, command) {
+			return
+		}
+		orderCommandsTotal.WithLabelValues(command, reply.Status).Inc()
+		if !d.Redelivered {
+			logWarnContext(ctx, "Order command failed, requeueing", map[string]interface{}{
+				"command":    command,
+				"message_id": d.MessageId,
+				"error":      reply.Error,
+			})
+			d.Nack(false, true)
+			return
+		}
+		logErrorContext(ctx, "Order command failed again, giving up", map[string]interface{}{
+			"command":    command,
+			"message_id": d.MessageId,
+			"error":      reply.Error,
+		})
+		a.replyCommand(ctx, ch, d, reply)
+		d.Nack(false, false)
+		return
+	}
+
+	orderCommandsTotal.WithLabelValues(command, reply.Status).Inc()
+	if d.MessageId != "" {
+		a.storeCommandReply(ctx, d.MessageId, command, reply)
+	}
+	a.replyCommand(ctx, ch, d, reply)
+	d.Ack(false)
+}
+
+// commandIDKey carries the message ID and name of the command an order
+// write executes
+type commandIDKey struct{}
+
+// commandID is the idempotency key of a command being executed
+type commandID struct {
+	messageID string
+	command   string
+}
+
+// withCommandID returns a context whose order write records the command
+// as executed
+func withCommandID(ctx context.Context, messageID, command string) context.Context {
+	return context.WithValue(ctx, commandIDKey{}, commandID{messageID: messageID, command: command})
+}
+
+// recordCommandTx records the command of ctx, if any, as executed by tx.
+// It fails if the command was executed before, rolling tx back.
+func recordCommandTx(ctx context.Context, tx *sql.Tx) error {
+	id, ok := ctx.Value(commandIDKey{}).(commandID)
+	if !ok {
+		return nil
+	}
+	_, err := tx.ExecContext(dbOperation(ctx, "record_command"), `
+		INSERT INTO processed_commands (message_id, command) VALUES ($1, $2)
+	`, id.messageID, id.command)
+	if err != nil {
+		return fmt.Errorf("failed to record command %s: %w", id.messageID, err)
+	}
+	return nil
+}
+
+// skipProcessedCommand acknowledges a delivery whose command was executed
+// before, answering it with the stored reply, and reports whether it did
+func (a *App) skipProcessedCommand(ctx context.Context, ch *amqp.Channel, d amqp.Delivery, command string) bool {
+	var stored sql.NullString
+	err := a.db.QueryRowContext(dbOperation(ctx, "find_command"),
+		`SELECT reply FROM processed_commands WHERE message_id = $1`, d.MessageId).Scan(&stored)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false
+	}
+	if err != nil {
+		// Executing is still safe: the order write checks the key again
+		logWarnContext(ctx, "Failed to look up order command", map[string]interface{}{
+			"message_id": d.MessageId,
+			"error":      err.Error(),
+		})
+		return false
+	}
+
+	orderCommandsTotal.WithLabelValues(command, "duplicate").Inc()
+	logInfoContext(ctx, "Duplicate order command skipped", map[string]interface{}{
+		"command":    command,
+		"message_id": d.MessageId,
+	})
+	var reply CommandReply
+	if stored.Valid && json.Unmarshal([]byte(stored.String), &reply) == nil {
+		a.replyCommand(ctx, ch, d, reply)
+	}
+	d.Ack(false)
+	return true
+}
+
+// storeCommandReply keeps the reply of an executed command for its
+// redeliveries. Commands that wrote nothing (rejected ones) are recorded
+// here.
+func (a *App) storeCommandReply(ctx context.Context, messageID, command string, reply CommandReply) {
+	data, err := json.Marshal(reply)
+	if err != nil {
+		return
+	}
+	result, err := a.db.ExecContext(dbOperation(ctx, "store_command_reply"),
+		`UPDATE processed_commands SET reply = $2 WHERE message_id = $1`, messageID, string(data))
+	if err == nil {
+		if n, _ := result.RowsAffected(); n == 0 {
+			_, err = a.db.ExecContext(dbOperation(ctx, "store_command_reply"), `
+				INSERT INTO processed_commands (message_id, command, reply) VALUES ($1, $2, $3)
+			`, messageID, command, string(data))
+		}
+	}
+	if err != nil {
+		logWarnContext(ctx, "Failed to store order command reply", map[string]interface{}{
+			"message_id": messageID,
+			"error":      err.Error(),
+		})
+	}
+}
+
+// cleanupProcessedCommands deletes the idempotency keys of commands
+// executed more than COMMAND_IDEMPOTENCY_TTL ago
+func (a *App) cleanupProcessedCommands(ctx context.Context) error {
+	result, err := a.db.ExecContext(ctx, `DELETE FROM processed_commands WHERE processed_at < $1`,
+		time.Now().Add(-commandIdempotencyTTL))
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n > 0 {
+		logInfoContext(ctx, "Deleted expired command idempotency keys", map[string]interface{}{"deleted": n})
+	}
+	return nil
+}
+
+// executeCommand validates and runs a command
+func (a *App) executeCommand(ctx context.Context, command string, body []byte) CommandReply {
+	reply := CommandReply{Command: command}
+	if int64(len(body)) > requestLimits.MaxBodyBytes {
+		return rejectCommand(reply, []Violation{{
+			Field:   "body",
+			Rule:    "max_body_bytes",
+			Limit:   requestLimits.MaxBodyBytes,
+			Message: fmt.Sprintf("request body must be at most %d bytes", requestLimits.MaxBodyBytes),
+		}})
+	}
+
+	switch command {
+	case "create":
+		var req CreateOrderRequest
+		if violations := decodeCommand(body, &req); violations != nil {
+			return rejectCommand(reply, violations)
+		}
+		if violations := requestLimits.checkCreateOrder(req); len(violations) > 0 {
+			return rejectCommand(reply, violations)
+		}
+
+		out := a.placeOrder(ctx, req, false)
+		reply.OrderID = out.id
+		reply.OrderNumber = out.number
+		reply.Warnings = out.warnings
+		reply.DuplicateOf = out.dup.duplicateOf
+		switch {
+		case out.err == "":
+			reply.Status = commandAccepted
+		case out.status >= http.StatusInternalServerError:
+			reply.Status, reply.Error = commandFailed, out.err
+		default:
+			reply.Status, reply.Error = commandRejected, out.err
+		}
+		return reply
+
+	case "cancel":
+		var req cancelCommand
+		if violations := decodeCommand(body, &req); violations != nil {
+			return rejectCommand(reply, violations)
+		}
+		reply.OrderID = req.OrderID
+		if !uuidPattern.MatchString(req.OrderID) {
+			reply.Status, reply.Error = commandRejected, "Invalid order ID"
+			return reply
+		}
+
+		found, denied, err := a.cancelOrderByPolicy(ctx, req.OrderID, false)
+		switch {
+		case err != nil:
+			reply.Status, reply.Error = commandFailed, "Database error"
+		case !found:
+			reply.Status, reply.Error = commandRejected, "Order not found"
+		case denied != "":
+			cancellationsDeniedTotal.WithLabelValues(denied).Inc()
+			reply.Status, reply.Error, reply.Reason = commandRejected, "Order cannot be cancelled", denied
+		default:
+			logInfoContext(ctx, "Order cancelled by command", map[string]interface{}{"order_id": req.OrderID})
+			reply.Status = commandAccepted
+		}
+		return reply
+
+	default:
+		reply.Status, reply.Error = commandRejected, "Unknown command"
+		return reply
+	}
+}
+
+// decodeCommand reads a command body into obj and applies its binding
+// rules, returning the violations if it is invalid
+func decodeCommand(body []byte, obj interface{}) []Violation {
+	if err := json.Unmarshal(body, obj); err != nil {
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &typeErr) {
+			return []Violation{{
+				Field:   typeErr.Field,
+				Rule:    "type",
+				Message: fmt.Sprintf("%s must be a %s", typeErr.Field, typeErr.Type),
+			}}
+		}
+		return []Violation{{Field: "body", Rule: "json", Message: "request body must be valid JSON"}}
+	}
+
+	err := binding.Validator.ValidateStruct(obj)
+	var fieldErrs validator.ValidationErrors
+	switch {
+	case err == nil:
+		return nil
+	case errors.As(err, &fieldErrs):
+		return fieldViolations(fieldErrs)
+	default:
+		return []Violation{{Field: "body", Rule: "invalid", Message: err.Error()}}
+	}
+}
+
+// rejectCommand turns a reply into a validation failure and counts it
+func rejectCommand(reply CommandReply, violations []Violation) CommandReply {
+	countViolations("command."+reply.Command, violations)
+	reply.Status = commandRejected
+	reply.Error = "Validation failed"
+	reply.Violations = violations
+	return reply
+}
+
+// replyCommand publishes the reply to a command that asked for one
+func (a *App) replyCommand(ctx context.Context, ch *amqp.Channel, d amqp.Delivery, reply CommandReply) {
+	if d.ReplyTo == "" {
+		return
+	}
+	body, err := json.Marshal(reply)
+	if err != nil {
+		return
+	}
+
+	err = ch.PublishWithContext(ctx,
+		"",        // Default exchange, routes by queue name
+		d.ReplyTo, // Routing key
+		false,     // Mandatory
+		false,     // Immediate
+		amqp.Publishing{
+			ContentType:   "application/json",
+			CorrelationId: d.CorrelationId,
+			Timestamp:     time.Now(),
+			Body:          body,
+		},
+	)
+	if err != nil {
+		logErrorContext(ctx, "Failed to reply to order command", map[string]interface{}{
+			"reply_to":       d.ReplyTo,
+			"correlation_id": d.CorrelationId,
+			"error":          err.Error(),
+		})
+	}
+}
@@ -0,0 +1,211 @@
+// =============================================================================
+// RESPONSE COMPRESSION
+// =============================================================================
+// Order lists and exports are large, repetitive JSON that compresses ~10x.
+// This middleware compresses responses with gzip (or zstd, when enabled and
+// preferred by the client) if:
+// - The client sent a matching Accept-Encoding header
+// - The Content-Type is in the compressible list
+// - The body is at least COMPRESSION_MIN_SIZE bytes
+//
+// HOW IT WORKS:
+// The first COMPRESSION_MIN_SIZE bytes are buffered. Once the buffer fills
+// up (or the handler finishes) we know enough to decide whether to compress,
+// and only then are headers and body sent to the client.
+// =============================================================================
+
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/klauspost/compress/zstd"
+)
+
+// compressibleContentTypes lists the media types worth compressing
+var compressibleContentTypes = []string{
+	"application/json",
+	"application/x-ndjson",
+	"text/",
+}
+
+// compressionOptions configures the compression middleware
+type compressionOptions struct {
+	minSize    int
+	gzipLevel  int
+	zstdEnable bool
+}
+
+// compressionMiddleware compresses eligible responses
+func compressionMiddleware(opts compressionOptions) gin.HandlerFunc {
+	gzipPool := sync.Pool{New: func() interface{} {
+		w, err := gzip.NewWriterLevel(io.Discard, opts.gzipLevel)
+		if err != nil {
+			// Invalid level: fall back to the default compression level
+			return gzip.NewWriter(io.Discard)
+		}
+		return w
+	}}
+
+	return func(c *gin.Context) {
+		encoding := negotiateEncoding(c.GetHeader("Accept-Encoding"), opts.zstdEnable)
+		if encoding == "" || c.Request.Method == http.MethodHead {
+			c.Next()
+			return
+		}
+
+		cw := &compressWriter{
+			ResponseWriter: c.Writer,
+			encoding:       encoding,
+			minSize:        opts.minSize,
+			gzipPool:       &gzipPool,
+		}
+		c.Writer = cw
+		c.Header("Vary", "Accept-Encoding")
+
+		defer cw.finish()
+		c.Next()
+	}
+}
+
+// negotiateEncoding picks zstd or gzip based on the Accept-Encoding header
+func negotiateEncoding(acceptEncoding string, zstdEnabled bool) string {
+	accepted := map[string]bool{}
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		name, params, _ := strings.Cut(part, ";")
+		// "gzip;q=0" explicitly refuses an encoding
+		if q, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if weight, err := strconv.ParseFloat(q, 64); err == nil && weight == 0 {
+				continue
+			}
+		}
+		accepted[strings.ToLower(strings.TrimSpace(name))] = true
+	}
+
+	if zstdEnabled && accepted["zstd"] {
+		return "zstd"
+	}
+	if accepted["gzip"] {
+		return "gzip"
+	}
+	return ""
+}
+
+// isCompressible reports whether a Content-Type is worth compressing
+func isCompressible(contentType string) bool {
+	for _, t := range compressibleContentTypes {
+		if strings.HasPrefix(contentType, t) {
+			return true
+		}
+	}
+	return false
+}
+
+// compressWriter buffers the start of a response until it can decide
+// whether to compress it
+type compressWriter struct {
+	gin.ResponseWriter
+
+	encoding string
+	minSize  int
+	gzipPool *sync.Pool
+
+	buf     bytes.Buffer
+	decided bool
+	encoder io.WriteCloser
+}
+
+// Write buffers data until the compression decision can be made
+func (w *compressWriter) Write(data []byte) (int, error) {
+	if w.decided {
+		if w.encoder != nil {
+			return w.encoder.Write(data)
+		}
+		return w.ResponseWriter.Write(data)
+	}
+
+	w.buf.Write(data)
+	if w.buf.Len() >= w.minSize {
+		if err := w.decide(true); err != nil {
+			return 0, err
+		}
+	}
+	return len(data), nil
+}
+
+// WriteString implements gin.ResponseWriter
+func (w *compressWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// Flush forces the compression decision so streamed data reaches the client
+func (w *compressWriter) Flush() {
+	if !w.decided {
+		w.decide(w.buf.Len() >= w.minSize)
+	}
+	if f, ok := w.encoder.(interface{ Flush() error }); ok {
+		f.Flush()
+	}
+	w.ResponseWriter.Flush()
+}
+
+// decide sets the response headers and writes out the buffered data
+func (w *compressWriter) decide(largeEnough bool) error {
+	w.decided = true
+
+	header := w.Header()
+	status := w.Status()
+	compress := largeEnough &&
+		header.Get("Content-Encoding") == "" &&
+		isCompressible(header.Get("Content-Type")) &&
+		status != http.StatusNoContent && status != http.StatusNotModified
+
+	if compress {
+		header.Set("Content-Encoding", w.encoding)
+		header.Del("Content-Length")
+
+		switch w.encoding {
+		case "zstd":
+			enc, err := zstd.NewWriter(w.ResponseWriter)
+			if err != nil {
+				return err
+			}
+			w.encoder = enc
+		default:
+			gz := w.gzipPool.Get().(*gzip.Writer)
+			gz.Reset(w.ResponseWriter)
+			w.encoder = gz
+		}
+		_, err := w.encoder.Write(w.buf.Bytes())
+		return err
+	}
+
+	if w.buf.Len() == 0 {
+		return nil
+	}
+	_, err := w.ResponseWriter.Write(w.buf.Bytes())
+	return err
+}
+
+// finish writes small responses uncompressed and closes the encoder
+func (w *compressWriter) finish() {
+	if !w.decided {
+		w.decide(false)
+	}
+	if w.encoder == nil {
+		return
+	}
+
+	w.encoder.Close()
+	if gz, ok := w.encoder.(*gzip.Writer); ok {
+		gz.Reset(io.Discard)
+		w.gzipPool.Put(gz)
+	}
+}
@@ -0,0 +1,267 @@
+// =============================================================================
+// PAYMENT AND INVENTORY EVENT CONSUMERS
+// =============================================================================
+// Orders move on by themselves when the other services report back. With
+// EVENT_CONSUMERS_ENABLED, worker processes consume EVENT_CONSUMER_QUEUE
+// (default order-service.events), which is declared durable and bound to:
+//
+//   Exchange    Routing key          Transition
+//   payments    payment.completed    pending           -> processing
+//   payments    payment.failed       pending           -> cancelled
+//   inventory   inventory.reserved   preorder          -> pending
+//
+// Both exchanges are declared (durable topic) so the bindings exist before
+// the producers do. Event bodies are JSON with at least the order ID:
+//
+//   {"event": "payment.failed", "order_id": "...", "reason": "card_declined"}
+//
+// A transition only applies to an order in its from status, so redelivered
+// or late events (a payment completing for a cancelled order) are ignored
+// rather than applied twice. Applied transitions publish the same events
+// as the HTTP API (order.status.*, order.cancelled) through the outbox.
+//
+// An event that can't be applied because of a database error is requeued
+// once; if it fails again, or can't be decoded at all, it is rejected
+// without requeueing, so it goes to the queue's dead letter exchange if
+// one is configured (see deadletters.go). The trace context of the message
+// headers is continued.
+//
+// METRICS:
+// - order_events_consumed_total{routing_key,result}   applied, ignored,
+//                                                     invalid, failed
+// - order_event_consume_duration_seconds{routing_key}
+// - order_event_consumer_in_flight                    Events being handled
+// =============================================================================
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+const eventConsumerTag = "order-service-events"
+
+// Results of a consumed event
+const (
+	consumeApplied = "applied"
+	consumeIgnored = "ignored"
+	consumeInvalid = "invalid"
+	consumeFailed  = "failed"
+)
+
+// eventTransition is the status change an inbound event causes
+type eventTransition struct {
+	exchange string
+	from     string
+	to       string
+}
+
+// eventTransitions maps the consumed routing keys to their transitions
+var eventTransitions = map[string]eventTransition{
+	"payment.completed":  {exchange: "payments", from: "pending", to: "processing"},
+	"payment.failed":     {exchange: "payments", from: "pending", to: "cancelled"},
+	"inventory.reserved": {exchange: "inventory", from: "preorder", to: "pending"},
+}
+
+var (
+	// Counter: Consumed events by result
+	orderEventsConsumedTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "order_events_consumed_total",
+			Help: "Total number of payment and inventory events consumed, by result",
+		},
+		[]string{"routing_key", "result"},
+	)
+
+	// Histogram: Time to handle a consumed event
+	orderEventConsumeDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "order_event_consume_duration_seconds",
+			Help:    "Time to handle a consumed payment or inventory event",
+			Buckets: prometheus.DefBuckets,
+		},
+		[]string{"routing_key"},
+	)
+
+	// Gauge: Events being handled
+	orderEventConsumerInFlight = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "order_event_consumer_in_flight",
+			Help: "Payment and inventory events currently being handled",
+		},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(orderEventsConsumedTotal)
+	prometheus.MustRegister(orderEventConsumeDuration)
+	prometheus.MustRegister(orderEventConsumerInFlight)
+}
+
+// inboundEvent is the part of a payment or inventory event the order
+// service reads
+type inboundEvent struct {
+	Event   string `json:"event"`
+	OrderID string `json:"order_id"`
+	Reason  string `json:"reason"`
+}
+
+// startEventConsumer declares and binds the event queue and consumes it
+// until shutdown, re-subscribing after reconnections
+func (a *App) startEventConsumer(queue string, prefetch int) error {
+	err := a.startConsumer(&rabbitConsumer{
+		name: "event-consumer",
+		tag:  eventConsumerTag,
+		subscribe: func(conn *amqp.Connection) (*amqp.Channel, <-chan amqp.Delivery, error) {
+			return subscribeEventQueue(conn, queue, prefetch)
+		},
+		handle: func(_ *amqp.Channel, d amqp.Delivery) {
+			a.handleInboundEvent(context.Background(), d)
+		},
+	})
+	if err != nil {
+		return err
+	}
+	log.Printf("Consuming payment and inventory events from %s", queue)
+	return nil
+}
+
+// subscribeEventQueue opens a channel, declares and binds the event queue
+// and consumes it
+func subscribeEventQueue(conn *amqp.Connection, queue string, prefetch int) (*amqp.Channel, <-chan amqp.Delivery, error) {
+	ch, err := conn.Channel()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open event consumer channel: %w", err)
+	}
+
+	if _, err := ch.QueueDeclare(queue, true, false, false, false, nil); err != nil {
+		ch.Close()
+		return nil, nil, fmt.Errorf("failed to declare event queue: %w", err)
+	}
+	for routingKey, t := range eventTransitions {
+		if err := ch.ExchangeDeclare(t.exchange, "topic", true, false, false, false, nil); err != nil {
+			ch.Close()
+			return nil, nil, fmt.Errorf("failed to declare %s exchange: %w", t.exchange, err)
+		}
+		if err := ch.QueueBind(queue, routingKey, t.exchange, false, nil); err != nil {
+			ch.Close()
+			return nil, nil, fmt.Errorf("failed to bind event queue to %s: %w", routingKey, err)
+		}
+	}
+	if err := ch.Qos(prefetch, 0, false); err != nil {
+		ch.Close()
+		return nil, nil, fmt.Errorf("failed to set event prefetch: %w", err)
+	}
+	deliveries, err := ch.Consume(queue, eventConsumerTag, false, false, false, false, nil)
+	if err != nil {
+		ch.Close()
+		return nil, nil, fmt.Errorf("failed to consume event queue: %w", err)
+	}
+	return ch, deliveries, nil
+}
+
+// handleInboundEvent applies and acknowledges one event delivery
+func (a *App) handleInboundEvent(ctx context.Context, d amqp.Delivery) {
+	start := time.Now()
+	orderEventConsumerInFlight.Inc()
+	defer orderEventConsumerInFlight.Dec()
+
+	routingKey := d.RoutingKey
+	if _, ok := eventTransitions[routingKey]; !ok {
+		// Keeps the metric labels bounded
+		routingKey = "unknown"
+	}
+
+	if sc, ok := extractTraceContext(func(key string) string {
+		value, _ := d.Headers[key].(string)
+		return value
+	}); ok {
+		ctx = contextWithRemoteSpan(ctx, sc)
+	}
+	ctx, span := startSpan(ctx, routingKey+" process", spanKindConsumer)
+	span.SetAttr("messaging.system", "rabbitmq")
+	span.SetAttr("messaging.source.name", d.Exchange)
+	span.SetAttr("messaging.rabbitmq.destination.routing_key", d.RoutingKey)
+
+	result, event, err := a.applyInboundEvent(ctx, routingKey, d.Body)
+	span.SetAttr("order.id", event.OrderID)
+	span.SetAttr("messaging.operation.result", result)
+	if err != nil {
+		span.SetError(err.Error())
+	}
+	span.End()
+	orderEventConsumeDuration.WithLabelValues(routingKey).Observe(time.Since(start).Seconds())
+	orderEventsConsumedTotal.WithLabelValues(routingKey, result).Inc()
+
+	fields := map[string]interface{}{
+		"routing_key": d.RoutingKey,
+		"order_id":    event.OrderID,
+	}
+	switch result {
+	case consumeApplied:
+		fields["reason"] = event.Reason
+		logInfoContext(ctx, "Order updated from inbound event", fields)
+	case consumeIgnored:
+		logDebugContext(ctx, "Inbound event ignored, order not in a matching status", fields)
+	case consumeInvalid:
+		fields["error"] = err.Error()
+		logWarnContext(ctx, "Invalid inbound event rejected", fields)
+		d.Nack(false, false)
+		return
+	case consumeFailed:
+		fields["error"] = err.Error()
+		if !d.Redelivered {
+			logWarnContext(ctx, "Inbound event failed, requeueing", fields)
+			d.Nack(false, true)
+			return
+		}
+		logErrorContext(ctx, "Inbound event failed again, giving up", fields)
+		d.Nack(false, false)
+		return
+	}
+	d.Ack(false)
+}
+
+// applyInboundEvent applies the transition of an event to its order
+func (a *App) applyInboundEvent(ctx context.Context, routingKey string, body []byte) (string, inboundEvent, error) {
+	var event inboundEvent
+	t, ok := eventTransitions[routingKey]
+	if !ok {
+		return consumeInvalid, event, fmt.Errorf("unexpected routing key")
+	}
+	if err := json.Unmarshal(body, &event); err != nil {
+		return consumeInvalid, event, fmt.Errorf("invalid event body: %w", err)
+	}
+	if !uuidPattern.MatchString(event.OrderID) {
+		return consumeInvalid, event, fmt.Errorf("invalid order ID %q", event.OrderID)
+	}
+
+	events := []orderEvent{newOrderEvent("order.status."+t.to, event.OrderID)}
+	if t.to == "cancelled" {
+		events = []orderEvent{newOrderEvent("order.cancelled", event.OrderID)}
+	}
+
+	var applied bool
+	var err error
+	if eventSourced() {
+		applied, err = a.changeOrderStatus(withOutboxEvents(ctx, events...), event.OrderID, t.to, t.from)
+	} else {
+		applied, err = a.execOrderWrite(ctx, "apply_inbound_event", events, `
+			UPDATE orders SET status = $1, updated_at = NOW() WHERE id = $2 AND status = $3
+		`, t.to, event.OrderID, t.from)
+	}
+	switch {
+	case err != nil:
+		return consumeFailed, event, err
+	case !applied:
+		return consumeIgnored, event, nil
+	}
+	return consumeApplied, event, nil
+}
@@ -0,0 +1,112 @@
+// =============================================================================
+// CURRENCIES
+// =============================================================================
+// Amounts are rounded to the minor unit of their order's currency instead
+// of to cents: JPY and KRW have no decimals, KWD, BHD and other dinars have
+// three. The currency metadata (name, symbol, decimals) lives in
+// currencies/currencies.json, embedded in the binary; currencies missing
+// from it get ISO 4217's usual two decimals and their code as symbol.
+//
+//   DEFAULT_CURRENCY   Currency new orders are placed in (default USD);
+//                      imported orders keep their own
+//
+// Rounding is half away from zero, per line: each item total is rounded,
+// and the order total is the sum of the rounded lines plus the rounded gift
+// wrap fee, so the lines of a receipt always add up to its total. Receipts,
+// CSV exports and the payment reconciliation use the same decimals.
+// Amount columns hold up to three decimals.
+// =============================================================================
+
+package main
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+//go:embed currencies/currencies.json
+var currencyFiles embed.FS
+
+// currency is the metadata of an ISO 4217 currency
+type currency struct {
+	Name     string `json:"name"`
+	Symbol   string `json:"symbol"`
+	Decimals int    `json:"decimals"`
+}
+
+var (
+	// currencies are the known currencies by code
+	currencies = map[string]currency{}
+
+	// defaultCurrency is the currency new orders are placed in
+	defaultCurrency = "USD"
+)
+
+func init() {
+	data, err := currencyFiles.ReadFile("currencies/currencies.json")
+	if err != nil {
+		panic(err)
+	}
+	if err := json.Unmarshal(data, &currencies); err != nil {
+		panic(fmt.Sprintf("invalid currency table: %v", err))
+	}
+}
+
+// setDefaultCurrency validates and applies DEFAULT_CURRENCY
+func setDefaultCurrency(code string) error {
+	code = strings.ToUpper(strings.TrimSpace(code))
+	if _, ok := currencies[code]; !ok {
+		codes := make([]string, 0, len(currencies))
+		for c := range currencies {
+			codes = append(codes, c)
+		}
+		sort.Strings(codes)
+		return fmt.Errorf("unknown DEFAULT_CURRENCY %q (available: %s)", code, strings.Join(codes, ", "))
+	}
+	defaultCurrency = code
+	return nil
+}
+
+// currencyDecimals returns the number of decimals of a currency's minor
+// unit
+func currencyDecimals(code string) int {
+	if c, ok := currencies[code]; ok {
+		return c.Decimals
+	}
+	return 2
+}
+
+// currencySymbol returns the symbol of a currency, or "" if it has none
+// known
+func currencySymbol(code string) string {
+	return currencies[code].Symbol
+}
+
+// roundMoney rounds an amount to the minor unit of a currency, half away
+// from zero
+func roundMoney(amount float64, code string) float64 {
+	scale := math.Pow10(currencyDecimals(code))
+	// Going through the decimal representation first makes 1.005 round
+	// to 1.01 rather than to the 1.00 its binary value would give
+	shifted, err := strconv.ParseFloat(strconv.FormatFloat(amount*scale, 'f', 6, 64), 64)
+	if err != nil {
+		shifted = amount * scale
+	}
+	return math.Round(shifted) / scale
+}
+
+// formatMoney formats an amount with its currency's decimals, without a
+// symbol or separators (CSV exports, comparisons)
+func formatMoney(amount float64, code string) string {
+	return strconv.FormatFloat(roundMoney(amount, code), 'f', currencyDecimals(code), 64)
+}
+
+// lineTotal is the rounded total of an order line
+func lineTotal(quantity int, unitPrice float64, code string) float64 {
+	return roundMoney(float64(quantity)*unitPrice, code)
+}
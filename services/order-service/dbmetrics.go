@@ -0,0 +1,245 @@
+// =============================================================================
+// DATABASE QUERY METRICS
+// =============================================================================
+// Every statement sent to PostgreSQL is timed by the connection wrapper
+// below, so DB performance shows up in Grafana without instrumenting each
+// call site. Statements are labelled with an operation:
+//
+// - the name given with dbOperation(ctx, "list_orders") for the order
+//   API's hot paths
+// - otherwise the statement's verb and first table, e.g. select_orders,
+//   insert_order_events, update_outbox; DDL and other statements get their
+//   verb alone (create, alter, do)
+//
+// Durations run until PostgreSQL answers, not until all rows are read.
+// Statements of transactions are measured one by one, plus their commit
+// and rollback. Simulated outages (see outages.go) count as errors;
+// statements cancelled with their request don't (see cancellation.go).
+//
+// METRICS:
+// - db_query_duration_seconds{operation}   Statement latency
+// - db_query_errors_total{operation}       Failed statements
+// - go_sql_*{db_name="orders"}             Connection pool stats (open,
+//                                          in use, idle, wait count and
+//                                          duration, closed connections)
+// =============================================================================
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+)
+
+// dbOperationCacheSize bounds the cache of derived operation names
+const dbOperationCacheSize = 1000
+
+var (
+	// Histogram: Statement latency by operation
+	dbQueryDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "db_query_duration_seconds",
+			Help:    "Latency of PostgreSQL statements, by operation",
+			Buckets: []float64{0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5},
+		},
+		[]string{"operation"},
+	)
+
+	// Counter: Failed statements by operation
+	dbQueryErrorsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "db_query_errors_total",
+			Help: "Total number of failed PostgreSQL statements, by operation",
+		},
+		[]string{"operation"},
+	)
+
+	// dbOperations caches the operation names derived from statements
+	dbOperations      sync.Map
+	dbOperationsCount atomic.Int64
+
+	// dbTablePattern finds the verb and first table of a statement, after
+	// its common table expressions
+	dbTablePattern = regexp.MustCompile(`(?is)^(?:with\b.*?\)\s*)?(insert into|update|delete from|select\b.*?\bfrom)\s+(?:only\s+)?([a-z_][a-z0-9_.]*)`)
+)
+
+func init() {
+	prometheus.MustRegister(dbQueryDuration)
+	prometheus.MustRegister(dbQueryErrorsTotal)
+}
+
+// registerDBStats exports the connection pool stats of the database
+func registerDBStats(db *sql.DB) {
+	err := prometheus.Register(collectors.NewDBStatsCollector(db, "orders"))
+	var registered prometheus.AlreadyRegisteredError
+	if err != nil && !errors.As(err, &registered) {
+		logWarn("Failed to register database pool metrics", map[string]interface{}{"error": err.Error()})
+	}
+}
+
+// dbOperationKey stores the operation name of statements in a context
+type dbOperationKey struct{}
+
+// dbOperation returns a context whose statements are labelled with the
+// given operation
+func dbOperation(ctx context.Context, operation string) context.Context {
+	return context.WithValue(ctx, dbOperationKey{}, operation)
+}
+
+// statementOperation returns the operation label of a statement
+func statementOperation(ctx context.Context, query string) string {
+	if operation, ok := ctx.Value(dbOperationKey{}).(string); ok {
+		return operation
+	}
+	if operation, ok := dbOperations.Load(query); ok {
+		return operation.(string)
+	}
+
+	statement := strings.Join(strings.Fields(query), " ")
+	operation, _, _ := strings.Cut(statement, " ")
+	operation = strings.ToLower(operation)
+	if m := dbTablePattern.FindStringSubmatch(statement); m != nil {
+		verb, _, _ := strings.Cut(m[1], " ")
+		table := m[2][strings.LastIndex(m[2], ".")+1:]
+		operation = strings.ToLower(verb + "_" + table)
+	}
+
+	// Dynamic statements (filters, IN lists) stop being cached at the
+	// limit; their names are cheap to derive again
+	if dbOperationsCount.Load() < dbOperationCacheSize {
+		if _, loaded := dbOperations.LoadOrStore(query, operation); !loaded {
+			dbOperationsCount.Add(1)
+		}
+	}
+	return operation
+}
+
+// observeStatement records the latency and outcome of a statement run
+// with ctx
+func observeStatement(ctx context.Context, operation string, start time.Time, err error) {
+	if errors.Is(err, driver.ErrSkip) {
+		return
+	}
+	dbQueryDuration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+	switch {
+	case err == nil:
+	case cancelled(ctx):
+		dbQueryCancelledTotal.WithLabelValues(operation).Inc()
+	default:
+		dbQueryErrorsTotal.WithLabelValues(operation).Inc()
+	}
+}
+
+// metricsConnector opens database connections whose statements are
+// measured
+type metricsConnector struct {
+	driver.Connector
+}
+
+func (c metricsConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.Connector.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &metricsConn{conn}, nil
+}
+
+// metricsConn measures the statements and transactions of a connection
+type metricsConn struct {
+	driver.Conn
+}
+
+func (c *metricsConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	if p, ok := c.Conn.(driver.ConnPrepareContext); ok {
+		return p.PrepareContext(ctx, query)
+	}
+	return c.Conn.Prepare(query)
+}
+
+func (c *metricsConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	start := time.Now()
+	var tx driver.Tx
+	var err error
+	if b, ok := c.Conn.(driver.ConnBeginTx); ok {
+		tx, err = b.BeginTx(ctx, opts)
+	} else {
+		tx, err = c.Conn.Begin()
+	}
+	observeStatement(ctx, "begin", start, err)
+	if err != nil {
+		return nil, err
+	}
+	return metricsTx{tx}, nil
+}
+
+func (c *metricsConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	q, ok := c.Conn.(driver.QueryerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	start := time.Now()
+	rows, err := q.QueryContext(ctx, query, args)
+	observeStatement(ctx, statementOperation(ctx, query), start, err)
+	return rows, err
+}
+
+func (c *metricsConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	e, ok := c.Conn.(driver.ExecerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	start := time.Now()
+	result, err := e.ExecContext(ctx, query, args)
+	observeStatement(ctx, statementOperation(ctx, query), start, err)
+	return result, err
+}
+
+func (c *metricsConn) Ping(ctx context.Context) error {
+	if p, ok := c.Conn.(driver.Pinger); ok {
+		return p.Ping(ctx)
+	}
+	return nil
+}
+
+func (c *metricsConn) ResetSession(ctx context.Context) error {
+	if r, ok := c.Conn.(driver.SessionResetter); ok {
+		return r.ResetSession(ctx)
+	}
+	return nil
+}
+
+func (c *metricsConn) IsValid() bool {
+	if v, ok := c.Conn.(driver.Validator); ok {
+		return v.IsValid()
+	}
+	return true
+}
+
+// metricsTx measures the commit or rollback of a transaction
+type metricsTx struct {
+	driver.Tx
+}
+
+func (t metricsTx) Commit() error {
+	start := time.Now()
+	err := t.Tx.Commit()
+	observeStatement(context.Background(), "commit", start, err)
+	return err
+}
+
+func (t metricsTx) Rollback() error {
+	start := time.Now()
+	err := t.Tx.Rollback()
+	observeStatement(context.Background(), "rollback", start, err)
+	return err
+}
@@ -0,0 +1,370 @@
+// =============================================================================
+// DEAD LETTERS AND DELAYED RETRIES
+// =============================================================================
+// Consumers of order events that nack a message without requeueing it get
+// it back after RETRY_DELAY (default 10s), up to RETRY_MAX_ATTEMPTS times
+// (default 3), and then find it parked in a dead-letter queue instead of
+// losing it. For every queue in DEAD_LETTER_QUEUES (default
+// notification-service-orders) the order service sets up:
+//
+//   <queue> --nack--> orders.dlx --<queue>--> orders.dlx.router
+//                                                 |
+//        +----------- retries left ---------------+---- exhausted ----+
+//        v                                                            v
+//   <queue>.retry --(RETRY_DELAY, dead-lettered back)--> <queue>   <queue>.dlq
+//
+// - orders.dlx           Direct exchange receiving the rejected messages,
+//                        routed by the name of the queue that rejected them
+// - orders.dlx.router    Consumed by the order service, which counts the
+//                        attempts in the x-retry-count header and moves the
+//                        message on (workers only)
+// - <queue>.retry        Holds a message for RETRY_DELAY, then dead-letters
+//                        it back to <queue> through the default exchange,
+//                        so other consumers of the event don't see it again
+// - <queue>.dlq          Messages that ran out of attempts
+//
+// The consumer queues are declared by their consumers, so their dead-letter
+// settings are applied as a RabbitMQ policy (order-service-dlx-<queue>)
+// through the management API. Without RABBITMQ_MANAGEMENT_URL, consumers
+// must declare their queue with the x-dead-letter-exchange and
+// x-dead-letter-routing-key arguments themselves. A policy replaces any
+// other policy on the queue with a lower priority.
+//
+// Changing RETRY_DELAY requires deleting the existing retry queues, since
+// RabbitMQ refuses to redeclare a queue with a different TTL.
+//
+// ENDPOINTS:
+// - GET /admin/dead-letters   Retrying and dead-lettered messages per queue
+//
+// METRICS:
+// - dead_letter_messages{queue}           Messages in <queue>.dlq
+// - dead_letter_retrying_messages{queue}  Messages waiting in <queue>.retry
+// - event_retries_total{queue}            Messages sent for another attempt
+// - events_dead_lettered_total{queue}     Messages parked in <queue>.dlq
+// =============================================================================
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+const (
+	deadLetterExchange    = "orders.dlx"
+	deadLetterRouterQueue = "orders.dlx.router"
+	deadLetterConsumerTag = "order-service-dlx-router"
+
+	// retryCountHeader counts the attempts a message has been retried
+	retryCountHeader = "x-retry-count"
+)
+
+var (
+	// deadLetterQueues are the consumer queues with delayed retries
+	deadLetterQueues []string
+
+	// retryDelay is how long a rejected message waits before its next attempt
+	retryDelay = 10 * time.Second
+
+	// retryMaxAttempts is how often a message is retried before it is parked
+	retryMaxAttempts = 3
+
+	// Gauge: Messages in the dead-letter queues
+	deadLetterMessages = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "dead_letter_messages",
+			Help: "Messages parked in the dead-letter queue of a consumer queue",
+		},
+		[]string{"queue"},
+	)
+
+	// Gauge: Messages waiting for their next attempt
+	deadLetterRetryingMessages = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "dead_letter_retrying_messages",
+			Help: "Rejected messages waiting in the retry queue of a consumer queue",
+		},
+		[]string{"queue"},
+	)
+
+	// Counter: Messages sent for another attempt
+	eventRetriesTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "event_retries_total",
+			Help: "Total number of rejected messages scheduled for another attempt",
+		},
+		[]string{"queue"},
+	)
+
+	// Counter: Messages that ran out of attempts
+	eventsDeadLetteredTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "events_dead_lettered_total",
+			Help: "Total number of rejected messages moved to a dead-letter queue",
+		},
+		[]string{"queue"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(deadLetterMessages)
+	prometheus.MustRegister(deadLetterRetryingMessages)
+	prometheus.MustRegister(eventRetriesTotal)
+	prometheus.MustRegister(eventsDeadLetteredTotal)
+}
+
+func retryQueueName(queue string) string      { return queue + ".retry" }
+func deadLetterQueueName(queue string) string { return queue + ".dlq" }
+
+// setDeadLettering validates and applies DEAD_LETTER_QUEUES, RETRY_DELAY
+// and RETRY_MAX_ATTEMPTS
+func setDeadLettering(queues []string, delay time.Duration, maxAttempts int) error {
+	if delay <= 0 {
+		return fmt.Errorf("RETRY_DELAY must be positive, got %s", delay)
+	}
+	if maxAttempts < 0 {
+		return fmt.Errorf("RETRY_MAX_ATTEMPTS must not be negative, got %d", maxAttempts)
+	}
+	deadLetterQueues = queues
+	retryDelay = delay
+	retryMaxAttempts = maxAttempts
+	return nil
+}
+
+// startDeadLettering declares the retry and dead-letter topology, applies
+// the dead-letter policies and routes rejected messages until shutdown
+func (a *App) startDeadLettering(ctx context.Context) error {
+	if len(deadLetterQueues) == 0 {
+		return nil
+	}
+	err := a.startConsumer(&rabbitConsumer{
+		name:      "dead-letter-router",
+		tag:       deadLetterConsumerTag,
+		subscribe: subscribeDeadLetters,
+		handle:    a.routeDeadLetter,
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, queue := range deadLetterQueues {
+		if queueManagementURL == nil {
+			logWarn("No RABBITMQ_MANAGEMENT_URL to apply the dead-letter policy, the consumer must declare it", map[string]interface{}{
+				"queue":                     queue,
+				"x-dead-letter-exchange":    deadLetterExchange,
+				"x-dead-letter-routing-key": queue,
+			})
+			continue
+		}
+		if err := applyDeadLetterPolicy(ctx, queue); err != nil {
+			logWarn("Failed to apply dead-letter policy", map[string]interface{}{
+				"queue": queue,
+				"error": err.Error(),
+			})
+		}
+	}
+
+	log.Printf("Routing dead letters of %d queue(s) (retry delay %s, %d attempts)",
+		len(deadLetterQueues), retryDelay, retryMaxAttempts)
+	return nil
+}
+
+// subscribeDeadLetters opens a channel in confirm mode, declares the
+// dead-letter topology and consumes the router queue
+func subscribeDeadLetters(conn *amqp.Connection) (*amqp.Channel, <-chan amqp.Delivery, error) {
+	ch, err := conn.Channel()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open dead-letter channel: %w", err)
+	}
+	if err := declareDeadLetterTopology(ch); err != nil {
+		ch.Close()
+		return nil, nil, err
+	}
+	if err := ch.Confirm(false); err != nil {
+		ch.Close()
+		return nil, nil, fmt.Errorf("failed to enable publisher confirms: %w", err)
+	}
+	if err := ch.Qos(10, 0, false); err != nil {
+		ch.Close()
+		return nil, nil, fmt.Errorf("failed to set dead-letter prefetch: %w", err)
+	}
+	deliveries, err := ch.Consume(deadLetterRouterQueue, deadLetterConsumerTag, false, false, false, false, nil)
+	if err != nil {
+		ch.Close()
+		return nil, nil, fmt.Errorf("failed to consume %s: %w", deadLetterRouterQueue, err)
+	}
+	return ch, deliveries, nil
+}
+
+// declareDeadLetterTopology declares the dead-letter exchange, the router
+// queue and the retry and dead-letter queues of every consumer queue
+func declareDeadLetterTopology(ch *amqp.Channel) error {
+	if err := ch.ExchangeDeclare(deadLetterExchange, "direct", true, false, false, false, nil); err != nil {
+		return fmt.Errorf("failed to declare %s: %w", deadLetterExchange, err)
+	}
+	if _, err := ch.QueueDeclare(deadLetterRouterQueue, true, false, false, false, nil); err != nil {
+		return fmt.Errorf("failed to declare %s: %w", deadLetterRouterQueue, err)
+	}
+
+	for _, queue := range deadLetterQueues {
+		if err := ch.QueueBind(deadLetterRouterQueue, queue, deadLetterExchange, false, nil); err != nil {
+			return fmt.Errorf("failed to bind %s for %s: %w", deadLetterRouterQueue, queue, err)
+		}
+		_, err := ch.QueueDeclare(retryQueueName(queue), true, false, false, false, amqp.Table{
+			"x-message-ttl":             retryDelay.Milliseconds(),
+			"x-dead-letter-exchange":    "",
+			"x-dead-letter-routing-key": queue,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to declare %s (delete it after changing RETRY_DELAY): %w", retryQueueName(queue), err)
+		}
+		if _, err := ch.QueueDeclare(deadLetterQueueName(queue), true, false, false, false, nil); err != nil {
+			return fmt.Errorf("failed to declare %s: %w", deadLetterQueueName(queue), err)
+		}
+	}
+	return nil
+}
+
+// applyDeadLetterPolicy points a consumer queue's rejected messages at the
+// dead-letter exchange
+func applyDeadLetterPolicy(ctx context.Context, queue string) error {
+	path := "/api/policies/" + url.PathEscape(queueVhost) + "/" + url.PathEscape("order-service-dlx-"+queue)
+	return managementRequest(ctx, http.MethodPut, path, map[string]interface{}{
+		"pattern":  "^" + regexp.QuoteMeta(queue) + "$",
+		"apply-to": "queues",
+		"priority": 0,
+		"definition": map[string]interface{}{
+			"dead-letter-exchange":    deadLetterExchange,
+			"dead-letter-routing-key": queue,
+		},
+	}, nil)
+}
+
+// routeDeadLetter sends a rejected message to its retry queue, or to its
+// dead-letter queue once it has no attempts left
+func (a *App) routeDeadLetter(ch *amqp.Channel, d amqp.Delivery) {
+	queue := d.RoutingKey
+	attempts := retryCount(d.Headers)
+
+	target := retryQueueName(queue)
+	if attempts >= retryMaxAttempts {
+		target = deadLetterQueueName(queue)
+	}
+
+	headers := amqp.Table{}
+	for k, v := range d.Headers {
+		headers[k] = v
+	}
+	headers[retryCountHeader] = int64(attempts + 1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), publishConfirmTimeout)
+	defer cancel()
+	confirm, err := ch.PublishWithDeferredConfirmWithContext(ctx, "", target, false, false, amqp.Publishing{
+		Headers:         headers,
+		ContentType:     d.ContentType,
+		ContentEncoding: d.ContentEncoding,
+		DeliveryMode:    amqp.Persistent,
+		Priority:        d.Priority,
+		CorrelationId:   d.CorrelationId,
+		MessageId:       d.MessageId,
+		Timestamp:       d.Timestamp,
+		Type:            d.Type,
+		AppId:           d.AppId,
+		Body:            d.Body,
+	})
+	if err == nil {
+		var acked bool
+		if acked, err = confirm.WaitContext(ctx); err == nil && !acked {
+			err = fmt.Errorf("nacked by RabbitMQ")
+		}
+	}
+	if err != nil {
+		logWarn("Failed to route dead letter, requeueing it", map[string]interface{}{
+			"queue":  queue,
+			"target": target,
+			"error":  err.Error(),
+		})
+		d.Nack(false, true)
+		return
+	}
+	d.Ack(false)
+
+	if target == deadLetterQueueName(queue) {
+		eventsDeadLetteredTotal.WithLabelValues(queue).Inc()
+		logWarn("Event dead-lettered after running out of retries", map[string]interface{}{
+			"queue":    queue,
+			"attempts": attempts,
+			"type":     d.Type,
+		})
+		return
+	}
+	eventRetriesTotal.WithLabelValues(queue).Inc()
+}
+
+// retryCount reads the x-retry-count header of a message
+func retryCount(headers amqp.Table) int {
+	switch n := headers[retryCountHeader].(type) {
+	case int64:
+		return int(n)
+	case int32:
+		return int(n)
+	case int:
+		return n
+	}
+	return 0
+}
+
+// pollDeadLetters exports the depths of the retry and dead-letter queues
+func (a *App) pollDeadLetters() {
+	for _, queue := range deadLetterQueues {
+		if q, err := a.inspectQueue(deadLetterQueueName(queue)); err == nil {
+			deadLetterMessages.WithLabelValues(queue).Set(float64(q.Messages))
+		}
+		if q, err := a.inspectQueue(retryQueueName(queue)); err == nil {
+			deadLetterRetryingMessages.WithLabelValues(queue).Set(float64(q.Messages))
+		}
+	}
+}
+
+// getDeadLetters reports the retrying and dead-lettered messages per queue
+func (a *App) getDeadLetters(c *gin.Context) {
+	if err := outages.check("rabbitmq"); err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+		return
+	}
+
+	queues := make([]gin.H, 0, len(deadLetterQueues))
+	for _, queue := range deadLetterQueues {
+		entry := gin.H{
+			"queue":       queue,
+			"retry_queue": retryQueueName(queue),
+			"dlq":         deadLetterQueueName(queue),
+		}
+		if q, err := a.inspectQueue(retryQueueName(queue)); err == nil {
+			entry["retrying"] = q.Messages
+		} else {
+			entry["error"] = err.Error()
+		}
+		if q, err := a.inspectQueue(deadLetterQueueName(queue)); err == nil {
+			entry["dead_lettered"] = q.Messages
+		} else {
+			entry["error"] = err.Error()
+		}
+		queues = append(queues, entry)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"queues":       queues,
+		"retry_delay":  retryDelay.String(),
+		"max_attempts": retryMaxAttempts,
+	})
+}
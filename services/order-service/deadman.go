@@ -0,0 +1,189 @@
+// =============================================================================
+// DEAD MAN'S SWITCH
+// =============================================================================
+// A background ticker stamps order_service_heartbeat_timestamp every
+// DEADMAN_INTERVAL (default 15s), in every service mode. Alerts on it fire
+// when the service stops reporting, whatever the reason: crashed, hung,
+// not scraped or lost between Prometheus and Alertmanager:
+//
+//   time() - order_service_heartbeat_timestamp > 60      # stale
+//   absent(order_service_heartbeat_timestamp)            # gone
+//
+// Both can be rehearsed without breaking anything by pausing the switch:
+//
+//   GET /admin/heartbeat   State of the switch
+//   PUT /admin/heartbeat   {"paused": true, "mode": "stale",
+//                           "duration_seconds": 600}
+//                          {"paused": false} resumes right away
+//
+// - stale    The timestamp stops advancing (default)
+// - absent   The series disappears from /metrics
+//
+// Without duration_seconds the switch stays paused until resumed.
+// Not to be confused with the synthetic order heartbeat (heartbeat.go).
+//
+// METRICS:
+// - order_service_heartbeat_timestamp   Unix time of the last beat
+// =============================================================================
+
+package main
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	deadmanModeStale  = "stale"
+	deadmanModeAbsent = "absent"
+)
+
+// deadman is the state of the dead man's switch
+var deadman = &deadmanSwitch{}
+
+// deadmanHeartbeatDesc describes order_service_heartbeat_timestamp
+var deadmanHeartbeatDesc = prometheus.NewDesc(
+	"order_service_heartbeat_timestamp",
+	"Unix time of the last dead man's switch heartbeat",
+	nil, nil,
+)
+
+func init() {
+	prometheus.MustRegister(deadman)
+}
+
+// deadmanSwitch beats on a ticker unless paused
+type deadmanSwitch struct {
+	mu          sync.Mutex
+	interval    time.Duration
+	lastBeat    time.Time
+	paused      bool
+	mode        string
+	pausedUntil time.Time
+}
+
+// Describe implements prometheus.Collector
+func (d *deadmanSwitch) Describe(ch chan<- *prometheus.Desc) {
+	ch <- deadmanHeartbeatDesc
+}
+
+// Collect implements prometheus.Collector. The series is left out before
+// the first beat and while paused in absent mode.
+func (d *deadmanSwitch) Collect(ch chan<- prometheus.Metric) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.expire()
+	if d.lastBeat.IsZero() || (d.paused && d.mode == deadmanModeAbsent) {
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(deadmanHeartbeatDesc, prometheus.GaugeValue,
+		float64(d.lastBeat.UnixNano())/1e9)
+}
+
+// expire ends a pause whose duration is over. The caller holds d.mu.
+func (d *deadmanSwitch) expire() {
+	if d.paused && !d.pausedUntil.IsZero() && !time.Now().Before(d.pausedUntil) {
+		d.paused = false
+		d.pausedUntil = time.Time{}
+		d.lastBeat = time.Now()
+		logInfo("Dead man's switch resumed", nil)
+	}
+}
+
+// beat stamps the heartbeat unless paused
+func (d *deadmanSwitch) beat() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.expire()
+	if !d.paused {
+		d.lastBeat = time.Now()
+	}
+}
+
+// startDeadmanSwitch starts the heartbeat ticker
+func startDeadmanSwitch(interval time.Duration) {
+	deadman.mu.Lock()
+	deadman.interval = interval
+	deadman.mu.Unlock()
+	deadman.beat()
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-backgroundCtx.Done():
+				return
+			case <-ticker.C:
+				deadman.beat()
+			}
+		}
+	}()
+}
+
+// getDeadmanSwitch returns the state of the dead man's switch
+func getDeadmanSwitch(c *gin.Context) {
+	deadman.mu.Lock()
+	defer deadman.mu.Unlock()
+	deadman.expire()
+
+	resp := gin.H{
+		"paused":   deadman.paused,
+		"interval": deadman.interval.String(),
+	}
+	if !deadman.lastBeat.IsZero() {
+		resp["last_beat"] = deadman.lastBeat
+	}
+	if deadman.paused {
+		resp["mode"] = deadman.mode
+		if !deadman.pausedUntil.IsZero() {
+			resp["paused_until"] = deadman.pausedUntil
+		}
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// setDeadmanSwitch pauses or resumes the dead man's switch
+func setDeadmanSwitch(c *gin.Context) {
+	var req struct {
+		Paused          bool   `json:"paused"`
+		Mode            string `json:"mode" binding:"omitempty,oneof=stale absent"`
+		DurationSeconds int    `json:"duration_seconds" binding:"min=0"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Mode == "" {
+		req.Mode = deadmanModeStale
+	}
+
+	deadman.mu.Lock()
+	deadman.paused = req.Paused
+	deadman.mode = req.Mode
+	deadman.pausedUntil = time.Time{}
+	if req.Paused && req.DurationSeconds > 0 {
+		deadman.pausedUntil = time.Now().Add(time.Duration(req.DurationSeconds) * time.Second)
+	}
+	if !req.Paused {
+		deadman.lastBeat = time.Now()
+	}
+	deadman.mu.Unlock()
+
+	if req.Paused {
+		logWarnContext(c.Request.Context(), "Dead man's switch paused", map[string]interface{}{
+			"mode":             req.Mode,
+			"duration_seconds": req.DurationSeconds,
+			"client_ip":        c.ClientIP(),
+		})
+		annotate("config", "Dead man's switch paused (%s)", req.Mode)
+	} else {
+		logInfoContext(c.Request.Context(), "Dead man's switch resumed", map[string]interface{}{"client_ip": c.ClientIP()})
+		annotate("config", "Dead man's switch resumed")
+	}
+	getDeadmanSwitch(c)
+}
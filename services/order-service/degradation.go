@@ -0,0 +1,232 @@
+// =============================================================================
+// DEGRADED RESPONSES
+// =============================================================================
+// Every 503 the API returns because the service is degraded, rather than
+// broken, tells clients when to come back and why, in the same way:
+//
+//   HTTP/1.1 503 Service Unavailable
+//   Retry-After: 5
+//   X-Degradation-Reason: database_unavailable
+//
+//   {"error": "...", "code": "database_unavailable", "retry_after": 5}
+//
+//   Code                   Cause                              Retry-After
+//   starting               Dependencies still connecting      5s
+//   maintenance            Maintenance mode (maintenance.go)  MAINTENANCE_RETRY_AFTER
+//   overloaded             Load shedding (loadshed.go)        LOAD_SHED_RETRY_AFTER
+//   concurrency_limit      Route group at its limit           1s
+//                          (concurrency.go)
+//   database_unavailable   Database probe failing             DEGRADED_RETRY_AFTER
+//
+// Well-behaved clients (and scripts/generate-load.sh) wait Retry-After
+// seconds before retrying, and can tell an outage from an overload by the
+// code without parsing the message.
+//
+// DATABASE PROBE:
+// The database is pinged every DATABASE_PROBE_INTERVAL (default 2s). Once
+// DATABASE_PROBE_FAILURES pings in a row (default 3) have failed, API
+// requests are answered with database_unavailable straight away instead of
+// waiting on a connection and failing with a 500. The first successful
+// ping lets them through again. A single lost ping (a network blip, a
+// failover hiccup) doesn't turn the API away.
+//
+// A ping that times out waiting for a free connection of a saturated pool
+// (every connection in use, DB_MAX_OPEN_CONNS) says the service is busy,
+// not that the database is down: it is counted as pool_wait and doesn't
+// count towards the failures in a row, so load doesn't take the API
+// offline.
+//
+// METRICS:
+// - degraded_responses_total{code}          503s returned while degraded
+// - database_available                      1 unless the database is
+//                                           considered down
+// - database_probe_failures_total{reason}   Failed pings; reason: error,
+//                                           pool_wait
+// =============================================================================
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Degradation codes
+const (
+	degradedStarting            = "starting"
+	degradedMaintenance         = "maintenance"
+	degradedOverloaded          = "overloaded"
+	degradedConcurrencyLimit    = "concurrency_limit"
+	degradedDatabaseUnavailable = "database_unavailable"
+)
+
+var (
+	// degradedRetryAfter is the Retry-After of database_unavailable
+	degradedRetryAfter = 5 * time.Second
+
+	// databaseProbeFailures is the number of failed pings in a row after
+	// which the database is considered down
+	databaseProbeFailures = 3
+
+	// databaseDown is set while the database is considered down
+	databaseDown atomic.Bool
+
+	// Counter: Degraded responses
+	degradedResponsesTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "degraded_responses_total",
+			Help: "Total number of 503 responses returned because the service was degraded, by degradation code",
+		},
+		[]string{"code"},
+	)
+
+	// Gauge: Database probe result
+	databaseAvailable = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "database_available",
+			Help: "Whether the database is considered available (1) or down after consecutive failed probes (0)",
+		},
+	)
+
+	// Counter: Failed database probes
+	databaseProbeFailuresTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "database_probe_failures_total",
+			Help: "Total number of failed database probes, by reason (error, pool_wait)",
+		},
+		[]string{"reason"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(degradedResponsesTotal)
+	prometheus.MustRegister(databaseAvailable)
+	prometheus.MustRegister(databaseProbeFailuresTotal)
+	databaseAvailable.Set(1)
+}
+
+// setDegradedRetryAfter validates and applies DEGRADED_RETRY_AFTER and
+// DATABASE_PROBE_FAILURES, and checks DATABASE_PROBE_INTERVAL
+func setDegradedRetryAfter(retryAfter, probeInterval time.Duration, probeFailures int) error {
+	if retryAfter <= 0 {
+		return fmt.Errorf("DEGRADED_RETRY_AFTER must be positive, got %s", retryAfter)
+	}
+	if probeInterval <= 0 {
+		return fmt.Errorf("DATABASE_PROBE_INTERVAL must be positive, got %s", probeInterval)
+	}
+	if probeFailures < 1 {
+		return fmt.Errorf("DATABASE_PROBE_FAILURES must be at least 1, got %d", probeFailures)
+	}
+	degradedRetryAfter = retryAfter
+	databaseProbeFailures = probeFailures
+	return nil
+}
+
+// respondDegraded answers a request with 503, its Retry-After and its
+// degradation code. body holds the error message and any other fields.
+func respondDegraded(c *gin.Context, code string, retryAfter time.Duration, body gin.H) {
+	seconds := int(math.Ceil(retryAfter.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	degradedResponsesTotal.WithLabelValues(code).Inc()
+	c.Header("Retry-After", strconv.Itoa(seconds))
+	c.Header("X-Degradation-Reason", code)
+	body["code"] = code
+	body["retry_after"] = seconds
+	c.AbortWithStatusJSON(http.StatusServiceUnavailable, body)
+}
+
+// startDatabaseProbe pings the database every interval until background
+// work is stopped
+func (a *App) startDatabaseProbe(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		failures := 0
+		for {
+			select {
+			case <-backgroundCtx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			reason, err := a.probeDatabase(interval)
+			if backgroundCtx.Err() != nil {
+				return
+			}
+
+			switch {
+			case err == nil:
+				failures = 0
+				if databaseDown.Swap(false) {
+					databaseAvailable.Set(1)
+					logInfo("Database available again", nil)
+				}
+			case reason == "pool_wait":
+				databaseProbeFailuresTotal.WithLabelValues(reason).Inc()
+				logDebug("Database probe timed out waiting for a connection, pool saturated", map[string]interface{}{
+					"error": err.Error(),
+				})
+			default:
+				databaseProbeFailuresTotal.WithLabelValues(reason).Inc()
+				failures++
+				if failures < databaseProbeFailures {
+					logDebug("Database probe failed", map[string]interface{}{
+						"failures": failures,
+						"error":    err.Error(),
+					})
+				} else if !databaseDown.Swap(true) {
+					databaseAvailable.Set(0)
+					logWarn("Database unavailable, rejecting API requests", map[string]interface{}{
+						"failures": failures,
+						"error":    err.Error(),
+					})
+				}
+			}
+		}
+	}()
+}
+
+// probeDatabase pings the database. A failure's reason is pool_wait when
+// the ping timed out waiting for a connection of a saturated pool, error
+// otherwise.
+func (a *App) probeDatabase(timeout time.Duration) (string, error) {
+	ctx, cancel := context.WithTimeout(backgroundCtx, timeout)
+	defer cancel()
+
+	before := a.db.Stats()
+	err := a.db.PingContext(ctx)
+	if err == nil {
+		return "", nil
+	}
+	after := a.db.Stats()
+	if errors.Is(err, context.DeadlineExceeded) && after.WaitCount > before.WaitCount &&
+		after.MaxOpenConnections > 0 && after.InUse >= after.MaxOpenConnections {
+		return "pool_wait", err
+	}
+	return "error", err
+}
+
+// degradationMiddleware rejects API requests while the database is down
+func degradationMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if databaseDown.Load() {
+			respondDegraded(c, degradedDatabaseUnavailable, degradedRetryAfter, gin.H{
+				"error": tr(c, "Database is unavailable, please retry later"),
+			})
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,310 @@
+// =============================================================================
+// DELIVERY RETRY QUEUE
+// =============================================================================
+// Outbound deliveries (notifications today, webhooks or anything else that
+// POSTs JSON to another system tomorrow) that fail are stored in
+// outbound_deliveries and retried by the delivery-retry job:
+//
+//   pending --(POST succeeds)--> delivered
+//      |
+//      +--(fails DELIVERY_MAX_ATTEMPTS times)--> dead
+//
+// Retries back off exponentially from DELIVERY_RETRY_BASE, doubling after
+// every attempt up to DELIVERY_RETRY_MAX, with up to 20% jitter so a
+// recovering endpoint isn't hit by every delivery at once. Dead deliveries
+// stay in the table until an operator retries them from the admin API.
+// Rows are locked with SKIP LOCKED, so several workers can retry at once.
+//
+// ENDPOINTS:
+// - GET  /admin/deliveries?status=dead  Queued deliveries (pending or dead)
+// - POST /admin/deliveries/:id/retry    Retry a delivery now
+// =============================================================================
+
+package main
+
+import (
+	"context"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// deliveryBatchSize is the number of due deliveries retried per run
+const deliveryBatchSize = 100
+
+var (
+	// deliveryMaxAttempts is the number of attempts before a delivery is dead
+	deliveryMaxAttempts = 8
+
+	// deliveryRetryBase is the delay before the first retry
+	deliveryRetryBase = 30 * time.Second
+
+	// deliveryRetryMax caps the delay between retries
+	deliveryRetryMax = time.Hour
+
+	// Counter: Delivery attempts, by kind and result
+	deliveryAttemptsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "outbound_delivery_attempts_total",
+			Help: "Total number of outbound delivery attempts, by result (queued, delivered, failed, dead)",
+		},
+		[]string{"kind", "result"},
+	)
+
+	// Gauge: Queued deliveries by status
+	deliveriesQueued = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "outbound_deliveries",
+			Help: "Outbound deliveries waiting for a retry (pending) or given up on (dead)",
+		},
+		[]string{"status"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(deliveryAttemptsTotal)
+	prometheus.MustRegister(deliveriesQueued)
+}
+
+// OutboundDelivery is a queued outbound delivery
+type OutboundDelivery struct {
+	ID            int64     `json:"id"`
+	Kind          string    `json:"kind"`
+	Target        string    `json:"target"`
+	Status        string    `json:"status"`
+	Attempts      int       `json:"attempts"`
+	NextAttemptAt time.Time `json:"next_attempt_at"`
+	LastError     string    `json:"last_error,omitempty"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+// deliveryBackoff returns the delay before the next attempt after attempts
+// failed attempts
+func deliveryBackoff(attempts int) time.Duration {
+	delay := deliveryRetryBase
+	for i := 1; i < attempts && delay < deliveryRetryMax; i++ {
+		delay *= 2
+	}
+	if delay > deliveryRetryMax {
+		delay = deliveryRetryMax
+	}
+	return delay + time.Duration(rand.Int63n(int64(delay)/5+1))
+}
+
+// queueDelivery stores a delivery whose first attempt failed
+func (a *App) queueDelivery(ctx context.Context, kind, target string, payload []byte, sendErr error) error {
+	_, err := a.db.ExecContext(ctx, `
+		INSERT INTO outbound_deliveries (kind, target, payload, attempts, next_attempt_at, last_error)
+		VALUES ($1, $2, $3, 1, $4, $5)
+	`, kind, target, string(payload), time.Now().Add(deliveryBackoff(1)), sendErr.Error())
+	if err != nil {
+		return err
+	}
+
+	deliveryAttemptsTotal.WithLabelValues(kind, "queued").Inc()
+	logWarnContext(ctx, "Outbound delivery failed, queued for retry", map[string]interface{}{
+		"kind":   kind,
+		"target": target,
+		"error":  sendErr.Error(),
+	})
+	return nil
+}
+
+// retryDeliveries is the delivery-retry job: it retries the deliveries
+// that are due
+func (a *App) retryDeliveries(ctx context.Context) error {
+	tx, err := a.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, `
+		SELECT id, kind, target, payload, attempts
+		FROM outbound_deliveries
+		WHERE status = 'pending' AND next_attempt_at <= NOW()
+		ORDER BY next_attempt_at
+		LIMIT $1
+		FOR UPDATE SKIP LOCKED
+	`, deliveryBatchSize)
+	if err != nil {
+		return err
+	}
+
+	type dueDelivery struct {
+		id       int64
+		kind     string
+		target   string
+		payload  string
+		attempts int
+	}
+	var due []dueDelivery
+	for rows.Next() {
+		var d dueDelivery
+		if err := rows.Scan(&d.id, &d.kind, &d.target, &d.payload, &d.attempts); err != nil {
+			rows.Close()
+			return err
+		}
+		due = append(due, d)
+	}
+	rows.Close()
+
+	delivered, dead := 0, 0
+	for _, d := range due {
+		attempts := d.attempts + 1
+		sendErr := a.postDelivery(ctx, d.target, []byte(d.payload))
+
+		switch {
+		case sendErr == nil:
+			_, err = tx.ExecContext(ctx, `
+				UPDATE outbound_deliveries
+				SET status = 'delivered', attempts = $1, last_error = NULL, updated_at = NOW()
+				WHERE id = $2
+			`, attempts, d.id)
+			deliveryAttemptsTotal.WithLabelValues(d.kind, "delivered").Inc()
+			delivered++
+		case attempts >= deliveryMaxAttempts:
+			_, err = tx.ExecContext(ctx, `
+				UPDATE outbound_deliveries
+				SET status = 'dead', attempts = $1, last_error = $2, updated_at = NOW()
+				WHERE id = $3
+			`, attempts, sendErr.Error(), d.id)
+			deliveryAttemptsTotal.WithLabelValues(d.kind, "dead").Inc()
+			logErrorContext(ctx, "Outbound delivery dead-lettered", map[string]interface{}{
+				"delivery_id": d.id,
+				"kind":        d.kind,
+				"target":      d.target,
+				"attempts":    attempts,
+				"error":       sendErr.Error(),
+			})
+			dead++
+		default:
+			_, err = tx.ExecContext(ctx, `
+				UPDATE outbound_deliveries
+				SET attempts = $1, next_attempt_at = $2, last_error = $3, updated_at = NOW()
+				WHERE id = $4
+			`, attempts, time.Now().Add(deliveryBackoff(attempts)), sendErr.Error(), d.id)
+			deliveryAttemptsTotal.WithLabelValues(d.kind, "failed").Inc()
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	if len(due) > 0 {
+		logInfoContext(ctx, "Retried outbound deliveries", map[string]interface{}{
+			"due":       len(due),
+			"delivered": delivered,
+			"dead":      dead,
+		})
+	}
+	a.updateDeliveryGauges(ctx)
+	return nil
+}
+
+// updateDeliveryGauges sets outbound_deliveries from the table
+func (a *App) updateDeliveryGauges(ctx context.Context) {
+	counts := map[string]float64{"pending": 0, "dead": 0}
+	rows, err := a.db.QueryContext(ctx, `
+		SELECT status, COUNT(*) FROM outbound_deliveries
+		WHERE status IN ('pending', 'dead') GROUP BY status
+	`)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var status string
+		var count float64
+		if rows.Scan(&status, &count) == nil {
+			counts[status] = count
+		}
+	}
+	for status, count := range counts {
+		deliveriesQueued.WithLabelValues(status).Set(count)
+	}
+}
+
+// =============================================================================
+// DELIVERY ADMIN HANDLERS
+// =============================================================================
+
+// listDeliveries returns queued deliveries, dead ones by default
+func (a *App) listDeliveries(c *gin.Context) {
+	status := c.DefaultQuery("status", "dead")
+	if status != "pending" && status != "dead" && status != "delivered" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "status must be pending, dead or delivered"})
+		return
+	}
+	limit := 100
+	if l, err := strconv.Atoi(c.Query("limit")); err == nil && l > 0 && l <= 1000 {
+		limit = l
+	}
+
+	rows, err := a.db.QueryContext(c.Request.Context(), `
+		SELECT id, kind, target, status, attempts, next_attempt_at,
+		       COALESCE(last_error, ''), created_at, updated_at
+		FROM outbound_deliveries
+		WHERE status = $1
+		ORDER BY updated_at DESC
+		LIMIT $2
+	`, status, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	defer rows.Close()
+
+	deliveries := []OutboundDelivery{}
+	for rows.Next() {
+		var d OutboundDelivery
+		if err := rows.Scan(&d.ID, &d.Kind, &d.Target, &d.Status, &d.Attempts,
+			&d.NextAttemptAt, &d.LastError, &d.CreatedAt, &d.UpdatedAt); err != nil {
+			continue
+		}
+		deliveries = append(deliveries, d)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":       status,
+		"max_attempts": deliveryMaxAttempts,
+		"deliveries":   deliveries,
+	})
+}
+
+// retryDelivery makes a pending or dead delivery due right away, with a
+// fresh set of attempts
+func (a *App) retryDelivery(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid delivery ID"})
+		return
+	}
+
+	result, err := a.db.ExecContext(c.Request.Context(), `
+		UPDATE outbound_deliveries
+		SET status = 'pending', attempts = 0, next_attempt_at = NOW(), updated_at = NOW()
+		WHERE id = $1 AND status IN ('pending', 'dead')
+	`, id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Delivery not found or already delivered"})
+		return
+	}
+
+	logInfoContext(c.Request.Context(), "Outbound delivery requeued", map[string]interface{}{"delivery_id": id})
+	c.JSON(http.StatusAccepted, gin.H{"message": "Delivery queued for retry", "id": id})
+}
@@ -0,0 +1,243 @@
+// =============================================================================
+// ROUTE DEPRECATIONS
+// =============================================================================
+// Routes are retired in steps: first marked deprecated while clients
+// migrate, then removed after their sunset date. DEPRECATED_ROUTES marks
+// routes, one entry per route:
+//
+//   GET /api/v1/orders/search;since=2026-09-01;sunset=2027-03-01;successor=/api/v2/orders/search
+//
+// since, sunset and successor are optional. Responses of deprecated routes
+// tell the client, so migrations don't depend on reading changelogs:
+//
+// - Deprecation: @<unix time of since> (RFC 9745), "@0" without since
+// - Sunset: <HTTP date> (RFC 8594)
+// - Link: <successor>; rel="successor-version"
+// - Warning: 299 - "Deprecated API ..."
+// - JSON object bodies get "meta": {"deprecation": {...}} with the same
+//   information
+//
+// Requests to deprecated routes are counted, so the migration progress
+// (deprecated vs successor traffic) can be followed in Grafana. Routes
+// keep working after their sunset date; removing them is a code change.
+//
+// ENDPOINTS:
+// - GET /admin/deprecations   Deprecated routes and their requests since
+//                              startup
+//
+// METRICS:
+// - deprecated_endpoint_requests_total{method,endpoint}
+// =============================================================================
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// RouteDeprecation describes a deprecated route
+type RouteDeprecation struct {
+	Method    string     `json:"method"`
+	Route     string     `json:"route"`
+	Since     *time.Time `json:"since,omitempty"`
+	Sunset    *time.Time `json:"sunset,omitempty"`
+	Successor string     `json:"successor,omitempty"`
+
+	// requests counts the calls since startup
+	requests atomic.Int64
+}
+
+// message is the human-readable notice of a deprecation
+func (d *RouteDeprecation) message() string {
+	msg := fmt.Sprintf("Deprecated API: %s %s", d.Method, d.Route)
+	if d.Sunset != nil {
+		msg += " will be removed after " + d.Sunset.Format("2006-01-02")
+	}
+	if d.Successor != "" {
+		msg += ", use " + d.Successor
+	}
+	return msg
+}
+
+var (
+	// deprecatedRoutes are the deprecated routes by "METHOD /route"
+	deprecatedRoutes = map[string]*RouteDeprecation{}
+
+	// Counter: Requests to deprecated routes
+	deprecatedRequestsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "deprecated_endpoint_requests_total",
+			Help: "Total number of requests to deprecated API routes",
+		},
+		[]string{"method", "endpoint"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(deprecatedRequestsTotal)
+}
+
+// setDeprecatedRoutes parses and applies the DEPRECATED_ROUTES entries
+func setDeprecatedRoutes(entries []string) error {
+	routes := map[string]*RouteDeprecation{}
+	for _, entry := range entries {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		parts := strings.Split(entry, ";")
+		method, route, ok := strings.Cut(strings.TrimSpace(parts[0]), " ")
+		if !ok || !strings.HasPrefix(route, "/") {
+			return fmt.Errorf("invalid DEPRECATED_ROUTES entry %q, expected METHOD /route;key=value...", entry)
+		}
+		d := &RouteDeprecation{Method: strings.ToUpper(method), Route: strings.TrimSpace(route)}
+
+		for _, attr := range parts[1:] {
+			key, value, _ := strings.Cut(strings.TrimSpace(attr), "=")
+			switch key {
+			case "since", "sunset":
+				t, err := time.Parse("2006-01-02", value)
+				if err != nil {
+					return fmt.Errorf("invalid %s date in DEPRECATED_ROUTES entry %q: %w", key, entry, err)
+				}
+				if key == "since" {
+					d.Since = &t
+				} else {
+					d.Sunset = &t
+				}
+			case "successor":
+				d.Successor = value
+			default:
+				return fmt.Errorf("unknown attribute %q in DEPRECATED_ROUTES entry %q", key, entry)
+			}
+		}
+		routes[d.Method+" "+d.Route] = d
+	}
+	deprecatedRoutes = routes
+	return nil
+}
+
+// deprecationWriter holds back a response body so the deprecation notice
+// can be added to it
+type deprecationWriter struct {
+	gin.ResponseWriter
+	buf bytes.Buffer
+}
+
+// Write buffers the body until the handler is done
+func (w *deprecationWriter) Write(data []byte) (int, error) {
+	return w.buf.Write(data)
+}
+
+// WriteString implements gin.ResponseWriter
+func (w *deprecationWriter) WriteString(s string) (int, error) {
+	return w.buf.WriteString(s)
+}
+
+// deprecationMiddleware marks the responses of deprecated routes
+func deprecationMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		route := c.FullPath()
+		d, ok := deprecatedRoutes[c.Request.Method+" "+route]
+		if !ok {
+			c.Next()
+			return
+		}
+
+		deprecatedRequestsTotal.WithLabelValues(c.Request.Method, route).Inc()
+		d.requests.Add(1)
+		logDebug("Deprecated route called", map[string]interface{}{
+			"method":     c.Request.Method,
+			"route":      route,
+			"client_ip":  c.ClientIP(),
+			"user_agent": c.Request.UserAgent(),
+		})
+
+		if d.Since != nil {
+			c.Header("Deprecation", fmt.Sprintf("@%d", d.Since.Unix()))
+		} else {
+			c.Header("Deprecation", "@0")
+		}
+		if d.Sunset != nil {
+			c.Header("Sunset", d.Sunset.UTC().Format(http.TimeFormat))
+		}
+		if d.Successor != "" {
+			c.Writer.Header().Add("Link", "<"+d.Successor+">; rel=\"successor-version\"")
+		}
+		c.Header("Warning", fmt.Sprintf("299 - %q", d.message()))
+
+		w := &deprecationWriter{ResponseWriter: c.Writer}
+		c.Writer = w
+		c.Next()
+		c.Writer = w.ResponseWriter
+
+		body := w.buf.Bytes()
+		if strings.HasPrefix(c.Writer.Header().Get("Content-Type"), "application/json") {
+			body = withDeprecationMeta(body, d)
+			c.Writer.Header().Del("Content-Length")
+		}
+		c.Writer.Write(body)
+	}
+}
+
+// withDeprecationMeta adds the deprecation to the meta of a JSON object
+// body. Other bodies are returned unchanged.
+func withDeprecationMeta(body []byte, d *RouteDeprecation) []byte {
+	var obj map[string]json.RawMessage
+	if err := json.Unmarshal(body, &obj); err != nil || obj == nil {
+		return body
+	}
+
+	meta := map[string]interface{}{}
+	if raw, ok := obj["meta"]; ok && json.Unmarshal(raw, &meta) != nil {
+		// Not an object, leave the response alone
+		return body
+	}
+	meta["deprecation"] = gin.H{
+		"deprecated": true,
+		"since":      d.Since,
+		"sunset":     d.Sunset,
+		"successor":  d.Successor,
+		"message":    d.message(),
+	}
+
+	data, err := json.Marshal(meta)
+	if err != nil {
+		return body
+	}
+	obj["meta"] = data
+	out, err := json.Marshal(obj)
+	if err != nil {
+		return body
+	}
+	return out
+}
+
+// listDeprecations returns the deprecated routes with their request counts
+func listDeprecations(c *gin.Context) {
+	type deprecationUsage struct {
+		*RouteDeprecation
+		Requests int64 `json:"requests"`
+	}
+
+	usage := make([]deprecationUsage, 0, len(deprecatedRoutes))
+	for _, d := range deprecatedRoutes {
+		usage = append(usage, deprecationUsage{RouteDeprecation: d, Requests: d.requests.Load()})
+	}
+	sort.Slice(usage, func(i, j int) bool {
+		if usage[i].Route != usage[j].Route {
+			return usage[i].Route < usage[j].Route
+		}
+		return usage[i].Method < usage[j].Method
+	})
+	c.JSON(http.StatusOK, gin.H{"routes": usage})
+}
@@ -0,0 +1,291 @@
+// =============================================================================
+// DUPLICATE ORDER DETECTION
+// =============================================================================
+// A double-clicked "Place order" button or a client retrying after a
+// timeout submits the same order twice. An order is a likely duplicate
+// when the same customer submitted the same items (SKU, quantity and unit
+// price) for the same total within DUPLICATE_WINDOW; what happens then
+// depends on DUPLICATE_DETECTION:
+//
+//   off      Nothing is checked
+//   flag     The order is created and put up for review (default)
+//   reject   The order is refused with 409 and the ID of the earlier one
+//
+// Fingerprints of recent orders are kept in Redis for DUPLICATE_WINDOW;
+// the first submission claims its fingerprint before it is written, so
+// concurrent twins are caught too. They are state, not cache, and are
+// kept by POST /admin/cache/flush. Without Redis, orders are not checked.
+// Canary orders are never checked: they are identical by design.
+//
+// ENDPOINTS:
+// - GET  /admin/reviews                    Orders flagged for review (open
+//                                          by default, ?status=resolved)
+// - POST /admin/reviews/:order_id/resolve  Close a review {"note": "..."}
+//
+// METRICS:
+// - duplicates_detected_total{action}  flagged, rejected
+// =============================================================================
+
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// duplicateDetectionModes are the accepted values of DUPLICATE_DETECTION
+var duplicateDetectionModes = []string{"off", "flag", "reject"}
+
+// duplicatePending marks a fingerprint whose order is still being written
+const duplicatePending = "pending"
+
+var (
+	// duplicateDetection is what happens to likely duplicates
+	duplicateDetection = "flag"
+
+	// duplicateWindow is how long a fingerprint is remembered
+	duplicateWindow = 2 * time.Minute
+
+	// Counter: Likely duplicate orders, by action taken
+	duplicatesDetectedTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "duplicates_detected_total",
+			Help: "Total number of likely duplicate order submissions, by action taken (flagged, rejected)",
+		},
+		[]string{"action"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(duplicatesDetectedTotal)
+}
+
+// setDuplicateDetection validates and applies the duplicate detection settings
+func setDuplicateDetection(mode string, window time.Duration) error {
+	valid := false
+	for _, m := range duplicateDetectionModes {
+		valid = valid || m == mode
+	}
+	if !valid {
+		return fmt.Errorf("invalid DUPLICATE_DETECTION %q, expected one of %v", mode, duplicateDetectionModes)
+	}
+	if window <= 0 {
+		return fmt.Errorf("invalid DUPLICATE_WINDOW %v, must be positive", window)
+	}
+	duplicateDetection = mode
+	duplicateWindow = window
+	return nil
+}
+
+// orderFingerprint identifies an order by customer, items and total,
+// independent of the order of the items
+func orderFingerprint(req CreateOrderRequest, total float64) string {
+	items := make([]string, len(req.Items))
+	for i, item := range req.Items {
+		items[i] = fmt.Sprintf("%s|%d|%.2f", item.SKU, item.Quantity, item.UnitPrice)
+	}
+	sort.Strings(items)
+
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\n%.2f\n%s", req.CustomerID, total, strings.Join(items, "\n"))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// duplicateCheck is the outcome of checking an order for an earlier twin
+type duplicateCheck struct {
+	// key is the Redis key of the fingerprint, "" if nothing was checked
+	key string
+
+	// claimed is set if this order claimed the fingerprint
+	claimed bool
+
+	// duplicate is set for likely duplicates, duplicateOf is the earlier
+	// order if it has been written already
+	duplicate   bool
+	duplicateOf string
+}
+
+// checkDuplicate looks for a recent order with the same fingerprint and
+// claims the fingerprint if there is none
+func (a *App) checkDuplicate(ctx context.Context, canary bool, req CreateOrderRequest, total float64) duplicateCheck {
+	if duplicateDetection == "off" || canary {
+		return duplicateCheck{}
+	}
+
+	check := duplicateCheck{key: stateKeyPrefix + "order-fingerprint:" + orderFingerprint(req, total)}
+	claimed, err := a.redisClient.SetNX(ctx, check.key, duplicatePending, duplicateWindow).Result()
+	if err != nil {
+		logDebugContext(ctx, "Duplicate check skipped, Redis unavailable", map[string]interface{}{"error": err.Error()})
+		return duplicateCheck{}
+	}
+	if claimed {
+		check.claimed = true
+		return check
+	}
+
+	check.duplicate = true
+	if earlier, err := a.redisClient.Get(ctx, check.key).Result(); err == nil && earlier != duplicatePending {
+		check.duplicateOf = earlier
+	}
+	if duplicateDetection == "reject" {
+		duplicatesDetectedTotal.WithLabelValues("rejected").Inc()
+	} else {
+		duplicatesDetectedTotal.WithLabelValues("flagged").Inc()
+	}
+	logWarnContext(ctx, "Likely duplicate order", map[string]interface{}{
+		"customer_id":  req.CustomerID,
+		"duplicate_of": check.duplicateOf,
+		"action":       duplicateDetection,
+	})
+	return check
+}
+
+// rejectsDuplicate reports whether the order must be refused
+func (check duplicateCheck) rejectsDuplicate() bool {
+	return check.duplicate && duplicateDetection == "reject"
+}
+
+// recordOrderFingerprint completes a duplicate check once the order has
+// been created: a claimed fingerprint now points at the order, and a
+// flagged duplicate is put up for review
+func (a *App) recordOrderFingerprint(ctx context.Context, check duplicateCheck, orderID string) {
+	if check.claimed {
+		a.redisClient.Set(ctx, check.key, orderID, duplicateWindow)
+	}
+	if !check.duplicate {
+		return
+	}
+
+	var duplicateOf interface{}
+	if uuidPattern.MatchString(check.duplicateOf) {
+		duplicateOf = check.duplicateOf
+	}
+	_, err := a.db.ExecContext(ctx, `
+		INSERT INTO order_reviews (order_id, reason, duplicate_of)
+		VALUES ($1, 'possible_duplicate', $2)
+	`, orderID, duplicateOf)
+	if err != nil {
+		logErrorContext(ctx, "Failed to flag order for review", map[string]interface{}{
+			"order_id": orderID,
+			"error":    err.Error(),
+		})
+	}
+}
+
+// releaseOrderFingerprint gives up a claimed fingerprint after the order
+// could not be created, so a retry isn't taken for a duplicate
+func (a *App) releaseOrderFingerprint(ctx context.Context, check duplicateCheck) {
+	if check.claimed {
+		a.redisClient.Del(ctx, check.key)
+	}
+}
+
+// =============================================================================
+// REVIEW ADMIN HANDLERS
+// =============================================================================
+
+// OrderReview is an order flagged for a human to look at
+type OrderReview struct {
+	ID             int64      `json:"id"`
+	OrderID        string     `json:"order_id"`
+	Reason         string     `json:"reason"`
+	DuplicateOf    string     `json:"duplicate_of,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
+	ResolvedAt     *time.Time `json:"resolved_at,omitempty"`
+	ResolutionNote string     `json:"resolution_note,omitempty"`
+}
+
+// listReviews returns flagged orders, open ones by default
+func (a *App) listReviews(c *gin.Context) {
+	status := c.DefaultQuery("status", "open")
+	if status != "open" && status != "resolved" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "status must be open or resolved"})
+		return
+	}
+	limit := 100
+	if l, err := strconv.Atoi(c.Query("limit")); err == nil && l > 0 && l <= 1000 {
+		limit = l
+	}
+
+	rows, err := a.db.QueryContext(c.Request.Context(), `
+		SELECT id, order_id, reason, COALESCE(duplicate_of::text, ''), created_at,
+		       resolved_at, COALESCE(resolution_note, '')
+		FROM order_reviews
+		WHERE (resolved_at IS NULL) = ($1 = 'open')
+		ORDER BY created_at DESC
+		LIMIT $2
+	`, status, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	defer rows.Close()
+
+	reviews := []OrderReview{}
+	for rows.Next() {
+		var r OrderReview
+		var resolvedAt sql.NullTime
+		if err := rows.Scan(&r.ID, &r.OrderID, &r.Reason, &r.DuplicateOf, &r.CreatedAt,
+			&resolvedAt, &r.ResolutionNote); err != nil {
+			continue
+		}
+		if resolvedAt.Valid {
+			r.ResolvedAt = &resolvedAt.Time
+		}
+		reviews = append(reviews, r)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  status,
+		"reviews": reviews,
+	})
+}
+
+// resolveReview closes the open reviews of an order
+func (a *App) resolveReview(c *gin.Context) {
+	orderID := c.Param("order_id")
+	if !uuidPattern.MatchString(orderID) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid order ID"})
+		return
+	}
+	var req struct {
+		Note string `json:"note"`
+	}
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	result, err := a.db.ExecContext(c.Request.Context(), `
+		UPDATE order_reviews
+		SET resolved_at = NOW(), resolution_note = NULLIF($2, '')
+		WHERE order_id = $1 AND resolved_at IS NULL
+	`, orderID, req.Note)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No open review for this order"})
+		return
+	}
+
+	logInfoContext(c.Request.Context(), "Order review resolved", map[string]interface{}{
+		"order_id": orderID,
+		"note":     req.Note,
+	})
+	c.JSON(http.StatusOK, gin.H{"order_id": orderID, "message": "Review resolved"})
+}
@@ -0,0 +1,314 @@
+// =============================================================================
+// EVENT SERIALIZATION
+// =============================================================================
+// Events are published as CloudEvents by default (see cloudevents.go).
+// Plain JSON (the event without an envelope) and protobuf (schemas and Go
+// types in eventspb/, compact) are alternatives for consumers that want
+// them:
+//
+//   EVENT_FORMAT=protobuf                        Every event as protobuf
+//   EVENT_FORMAT_ROUTES=order.daily_summary:json,order.status.*:protobuf
+//                                                Per routing key pattern
+//
+// Route patterns use the topic exchange syntax ("*" is one word, "#" any
+// number of words), so the format follows the queues consumers bind. An
+// exact routing key wins over a pattern, and longer patterns over shorter
+// ones.
+//
+// Messages carry their encoding in the AMQP content type
+// (application/cloudevents+json, application/json or
+// application/x-protobuf) and, for protobuf, the full message name in the
+// type property, so a consumer can handle all of them while it migrates:
+//
+//   Routing key             Message
+//   order.created           orders.events.v1.OrderCreated
+//   order.status.<status>   orders.events.v1.OrderStatusChanged
+//   order.cancelled         orders.events.v1.OrderCancelled
+//   order.daily_summary     orders.events.v1.DailySummary
+//   other order.* events    orders.events.v1.OrderEvent
+//
+// The typed messages carry the order number, amounts and (for
+// order.created) the items, read from the order when the event is
+// published, so protobuf consumers get a versioned contract instead of
+// looking the order up.
+//
+// Events are stored as JSON everywhere (publish buffer, outbox) and only
+// encoded when published, so changing the format applies to events already
+// waiting in the outbox. An event that fails to encode is published as
+// JSON rather than held back. Lifecycle events (service.*, see
+// lifecycleevents.go) have no protobuf schema and are published as JSON
+// in place of protobuf.
+//
+// event_encoded_bytes compares the message sizes of both formats.
+// =============================================================================
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"order-service/eventspb"
+
+	"github.com/prometheus/client_golang/prometheus"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const (
+	eventFormatJSON     = "json"
+	eventFormatProtobuf = "protobuf"
+)
+
+var (
+	// eventFormat is the format of events no route pattern matches
+	eventFormat = eventFormatCloudEvents
+
+	// eventFormatRoutes are the route patterns with their own format,
+	// most specific first
+	eventFormatRoutes []eventFormatRoute
+
+	// Histogram: Size of published events, by format
+	eventEncodedBytes = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "event_encoded_bytes",
+			Help:    "Size of published event bodies in bytes, by format",
+			Buckets: prometheus.ExponentialBuckets(16, 2, 8),
+		},
+		[]string{"format"},
+	)
+
+	// Counter: Events that failed to encode and were published as JSON
+	eventEncodeFailuresTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "event_encode_failures_total",
+			Help: "Total number of events that could not be encoded in their configured format",
+		},
+		[]string{"format"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(eventEncodedBytes)
+	prometheus.MustRegister(eventEncodeFailuresTotal)
+}
+
+// eventFormatRoute is a routing key pattern with its format
+type eventFormatRoute struct {
+	pattern string
+	format  string
+}
+
+// setEventFormats validates and applies EVENT_FORMAT and EVENT_FORMAT_ROUTES
+func setEventFormats(format string, routes map[string]string) error {
+	valid := func(f string) bool {
+		return f == eventFormatCloudEvents || f == eventFormatJSON || f == eventFormatProtobuf
+	}
+	if !valid(format) {
+		return fmt.Errorf("unknown EVENT_FORMAT %q (expected cloudevents, json or protobuf)", format)
+	}
+
+	parsed := make([]eventFormatRoute, 0, len(routes))
+	for pattern, f := range routes {
+		if !valid(f) {
+			return fmt.Errorf("unknown format %q for route %q in EVENT_FORMAT_ROUTES", f, pattern)
+		}
+		parsed = append(parsed, eventFormatRoute{pattern: pattern, format: f})
+	}
+	// Exact keys first, then the longest patterns
+	sort.Slice(parsed, func(i, j int) bool {
+		ei := !strings.ContainsAny(parsed[i].pattern, "*#")
+		ej := !strings.ContainsAny(parsed[j].pattern, "*#")
+		if ei != ej {
+			return ei
+		}
+		return len(parsed[i].pattern) > len(parsed[j].pattern)
+	})
+
+	eventFormat = format
+	eventFormatRoutes = parsed
+	return nil
+}
+
+// eventFormatFor returns the format events with a routing key are published in
+func eventFormatFor(routingKey string) string {
+	for _, route := range eventFormatRoutes {
+		if matchRoutingKey(strings.Split(route.pattern, "."), strings.Split(routingKey, ".")) {
+			return route.format
+		}
+	}
+	return eventFormat
+}
+
+// matchRoutingKey matches the words of a routing key against the words of
+// a topic pattern
+func matchRoutingKey(pattern, key []string) bool {
+	if len(pattern) == 0 {
+		return len(key) == 0
+	}
+	switch pattern[0] {
+	case "#":
+		for i := 0; i <= len(key); i++ {
+			if matchRoutingKey(pattern[1:], key[i:]) {
+				return true
+			}
+		}
+		return false
+	case "*":
+		return len(key) > 0 && matchRoutingKey(pattern[1:], key[1:])
+	default:
+		return len(key) > 0 && pattern[0] == key[0] && matchRoutingKey(pattern[1:], key[1:])
+	}
+}
+
+// encodeEvent builds the AMQP message of an event in its configured format
+func (a *App) encodeEvent(ctx context.Context, event orderEvent) amqp.Publishing {
+	format := eventFormatFor(event.RoutingKey)
+	if format == eventFormatCloudEvents {
+		msg, err := a.encodeCloudEvent(ctx, event)
+		if err == nil {
+			eventEncodedBytes.WithLabelValues(format).Observe(float64(len(msg.Body)))
+			return msg
+		}
+		eventEncodeFailuresTotal.WithLabelValues(format).Inc()
+		logWarn("Failed to encode event as CloudEvent, publishing JSON", map[string]interface{}{
+			"routing_key": event.RoutingKey,
+			"error":       err.Error(),
+		})
+	}
+	if format == eventFormatProtobuf && !isLifecycleEvent(event.RoutingKey) {
+		msg, err := a.eventProto(ctx, event)
+		if err == nil {
+			var body []byte
+			if body, err = proto.Marshal(msg); err == nil {
+				eventEncodedBytes.WithLabelValues(format).Observe(float64(len(body)))
+				return amqp.Publishing{
+					ContentType: "application/x-protobuf",
+					Type:        string(msg.ProtoReflect().Descriptor().FullName()),
+					Body:        body,
+				}
+			}
+		}
+		eventEncodeFailuresTotal.WithLabelValues(format).Inc()
+		logWarn("Failed to encode event as protobuf, publishing JSON", map[string]interface{}{
+			"routing_key": event.RoutingKey,
+			"error":       err.Error(),
+		})
+	}
+
+	eventEncodedBytes.WithLabelValues(eventFormatJSON).Observe(float64(len(event.Body)))
+	return amqp.Publishing{
+		ContentType: "application/json",
+		Body:        event.Body,
+	}
+}
+
+// eventProto converts an event's JSON body to its protobuf message. Order
+// events get their typed message, filled from the order as it is when the
+// event is published; an order that no longer exists leaves those fields
+// empty.
+func (a *App) eventProto(ctx context.Context, event orderEvent) (proto.Message, error) {
+	if event.RoutingKey == "order.daily_summary" {
+		var s struct {
+			Event     string    `json:"event"`
+			Timestamp time.Time `json:"timestamp"`
+			DailySummary
+		}
+		if err := json.Unmarshal(event.Body, &s); err != nil {
+			return nil, err
+		}
+		return &eventspb.DailySummary{
+			Event:            s.Event,
+			Timestamp:        timestamppb.New(s.Timestamp),
+			Date:             s.Date,
+			Timezone:         s.Timezone,
+			PeriodStart:      timestamppb.New(s.PeriodStart),
+			PeriodEnd:        timestamppb.New(s.PeriodEnd),
+			OrderCount:       s.OrderCount,
+			CancelledCount:   s.CancelledCount,
+			CancellationRate: s.CancellationRate,
+			Revenue:          s.Revenue,
+			AvgBasket:        s.AvgBasket,
+			ComputedAt:       timestamppb.New(s.ComputedAt),
+		}, nil
+	}
+
+	var e struct {
+		Event     string    `json:"event"`
+		EventID   string    `json:"event_id"`
+		OrderID   string    `json:"order_id"`
+		Timestamp time.Time `json:"timestamp"`
+	}
+	if err := json.Unmarshal(event.Body, &e); err != nil {
+		return nil, err
+	}
+
+	order := &Order{}
+	if carriesOrder(event) && event.RoutingKey != "order.updated" {
+		if o, err := a.eventOrder(ctx, event); err == nil {
+			order = o
+		}
+	}
+
+	switch {
+	case event.RoutingKey == "order.created":
+		items := make([]*eventspb.OrderItem, 0, len(order.Items))
+		for _, item := range order.Items {
+			items = append(items, &eventspb.OrderItem{
+				Sku:        item.SKU,
+				Name:       item.Name,
+				Quantity:   int32(item.Quantity),
+				UnitPrice:  item.UnitPrice,
+				TotalPrice: item.TotalPrice,
+			})
+		}
+		msg := &eventspb.OrderCreated{
+			Event:        e.Event,
+			EventId:      e.EventID,
+			OrderId:      e.OrderID,
+			Timestamp:    timestamppb.New(e.Timestamp),
+			OrderNumber:  order.Number,
+			CustomerId:   order.CustomerID,
+			CustomerTier: order.CustomerTier,
+			Status:       order.Status,
+			TotalAmount:  order.TotalAmount,
+			Currency:     order.Currency,
+			Items:        items,
+		}
+		if !order.CreatedAt.IsZero() {
+			msg.CreatedAt = timestamppb.New(order.CreatedAt)
+		}
+		return msg, nil
+	case strings.HasPrefix(event.RoutingKey, "order.status."):
+		return &eventspb.OrderStatusChanged{
+			Event:       e.Event,
+			EventId:     e.EventID,
+			OrderId:     e.OrderID,
+			Timestamp:   timestamppb.New(e.Timestamp),
+			OrderNumber: order.Number,
+			Status:      strings.TrimPrefix(event.RoutingKey, "order.status."),
+		}, nil
+	case event.RoutingKey == "order.cancelled":
+		return &eventspb.OrderCancelled{
+			Event:       e.Event,
+			EventId:     e.EventID,
+			OrderId:     e.OrderID,
+			Timestamp:   timestamppb.New(e.Timestamp),
+			OrderNumber: order.Number,
+			CustomerId:  order.CustomerID,
+			TotalAmount: order.TotalAmount,
+			Currency:    order.Currency,
+		}, nil
+	}
+	return &eventspb.OrderEvent{
+		Event:     e.Event,
+		EventId:   e.EventID,
+		OrderId:   e.OrderID,
+		Timestamp: timestamppb.New(e.Timestamp),
+	}, nil
+}
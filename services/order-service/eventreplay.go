@@ -0,0 +1,305 @@
+// =============================================================================
+// EVENT REPLAY
+// =============================================================================
+// A downstream service that lost messages (a purged queue, a consumer bug
+// that acked without processing) can get the order events again:
+//
+//   POST /admin/events/replay
+//   {"from": "2025-03-14T09:00:00Z", "to": "2025-03-14T10:00:00Z"}
+//   {"order_ids": ["5b0c...", "..."]}
+//   {"from": "...", "to": "...", "routing_keys": ["order.status.*"], "dry_run": true}
+//
+// A range selects the events created in [from, to), or for orders without
+// outbox events, the orders created or updated in it. order_ids (up to
+// maxReplayOrders) selects the events of those orders; both together
+// select the events of those orders in the range. routing_keys filters
+// with the topic exchange syntax ("*" one word, "#" any number of words).
+//
+// Replayed events are added to the outbox as pending (see outbox.go) and
+// go out with the next relay poll, in their original order, on the same
+// routing keys as before. They come from:
+//
+//   outbox   Published events still in the outbox (OUTBOX_RETENTION),
+//            republished as they were, with their event_id, so consumers
+//            that did get them can deduplicate
+//   orders   Orders whose events were already cleaned up: order.created,
+//            plus order.status.<status> or order.cancelled for their
+//            current status, with event IDs derived from the order so a
+//            second replay can be deduplicated too
+//
+// dry_run counts what a replay would publish without adding anything. A
+// replay is capped at maxReplayEvents events; narrow the range beyond it.
+//
+// METRICS:
+// - events_replayed_total{source}   Events added to the outbox by replays
+// =============================================================================
+
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// maxReplayEvents caps the events of one replay
+	maxReplayEvents = 10000
+
+	// maxReplayOrders caps the order IDs of one replay
+	maxReplayOrders = 500
+)
+
+var (
+	// Counter: Replayed events
+	eventsReplayedTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "events_replayed_total",
+			Help: "Total number of order events added to the outbox by replays, by source",
+		},
+		[]string{"source"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(eventsReplayedTotal)
+}
+
+// replayRequest is the body of POST /admin/events/replay
+type replayRequest struct {
+	From        *time.Time `json:"from"`
+	To          *time.Time `json:"to"`
+	OrderIDs    []string   `json:"order_ids"`
+	RoutingKeys []string   `json:"routing_keys"`
+	DryRun      bool       `json:"dry_run"`
+}
+
+// replayEvents serves POST /admin/events/replay
+func (a *App) replayEvents(c *gin.Context) {
+	var req replayRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body: " + err.Error()})
+		return
+	}
+	if (req.From == nil) != (req.To == nil) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "from and to must be given together"})
+		return
+	}
+	if req.From == nil && len(req.OrderIDs) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Give a time range (from, to), order_ids or both"})
+		return
+	}
+	if req.From != nil && !req.From.Before(*req.To) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "from must be before to"})
+		return
+	}
+	if len(req.OrderIDs) > maxReplayOrders {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("At most %d order IDs per replay", maxReplayOrders)})
+		return
+	}
+	for _, id := range req.OrderIDs {
+		if !uuidPattern.MatchString(id) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid order ID " + id})
+			return
+		}
+	}
+
+	ctx := c.Request.Context()
+	fromOutbox, err := a.replayOutboxEvents(ctx, req)
+	if err != nil {
+		logErrorContext(ctx, "Failed to read events to replay", map[string]interface{}{"error": err.Error()})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	fromOrders, err := a.replayOrderEvents(ctx, req)
+	if err != nil {
+		logErrorContext(ctx, "Failed to read orders to replay", map[string]interface{}{"error": err.Error()})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if len(fromOutbox)+len(fromOrders) > maxReplayEvents {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{
+			"error": fmt.Sprintf("The replay selects more than %d events, narrow it down", maxReplayEvents),
+		})
+		return
+	}
+
+	counts := gin.H{"outbox": len(fromOutbox), "orders": len(fromOrders)}
+	if req.DryRun {
+		c.JSON(http.StatusOK, gin.H{"dry_run": true, "events": len(fromOutbox) + len(fromOrders), "sources": counts})
+		return
+	}
+
+	events := append(fromOutbox, fromOrders...)
+	err = retryTx(ctx, func() error {
+		tx, err := a.db.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+		if err := saveToOutboxTx(ctx, tx, events...); err != nil {
+			return err
+		}
+		return tx.Commit()
+	})
+	if err != nil {
+		logErrorContext(ctx, "Failed to replay events", map[string]interface{}{"error": err.Error()})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	wakeOutboxRelay()
+	eventsReplayedTotal.WithLabelValues("outbox").Add(float64(len(fromOutbox)))
+	eventsReplayedTotal.WithLabelValues("orders").Add(float64(len(fromOrders)))
+
+	fields := map[string]interface{}{
+		"events":      len(events),
+		"from_outbox": len(fromOutbox),
+		"from_orders": len(fromOrders),
+		"orders":      len(req.OrderIDs),
+		"client_ip":   c.ClientIP(),
+	}
+	if req.From != nil {
+		fields["from"] = req.From.Format(time.RFC3339)
+		fields["to"] = req.To.Format(time.RFC3339)
+	}
+	logInfoContext(ctx, "Order events replayed", fields)
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"message": "Events added to the outbox",
+		"events":  len(events),
+		"sources": counts,
+	})
+}
+
+// replayFilter builds the conditions of a replay on the time columns and
+// order ID column given, with their arguments numbered from $1
+func replayFilter(req replayRequest, timeCond func(from, to string) string, orderColumn string) (string, []interface{}) {
+	var conds []string
+	var args []interface{}
+	if req.From != nil {
+		args = append(args, *req.From, *req.To)
+		conds = append(conds, timeCond("$1", "$2"))
+	}
+	if len(req.OrderIDs) > 0 {
+		placeholders := make([]string, len(req.OrderIDs))
+		for i, id := range req.OrderIDs {
+			args = append(args, id)
+			placeholders[i] = fmt.Sprintf("$%d", len(args))
+		}
+		conds = append(conds, orderColumn+" IN ("+strings.Join(placeholders, ", ")+")")
+	}
+	return strings.Join(conds, " AND "), args
+}
+
+// replayMatches reports whether a routing key passes the routing_keys
+// filter of a replay
+func replayMatches(req replayRequest, routingKey string) bool {
+	if len(req.RoutingKeys) == 0 {
+		return true
+	}
+	for _, pattern := range req.RoutingKeys {
+		if matchRoutingKey(strings.Split(pattern, "."), strings.Split(routingKey, ".")) {
+			return true
+		}
+	}
+	return false
+}
+
+// replayOutboxEvents returns the published outbox events a replay selects
+func (a *App) replayOutboxEvents(ctx context.Context, req replayRequest) ([]orderEvent, error) {
+	where, args := replayFilter(req, func(from, to string) string {
+		return "created_at >= " + from + " AND created_at < " + to
+	}, "order_id")
+	rows, err := a.db.QueryContext(ctx, `
+		SELECT routing_key, COALESCE(order_id::text, ''), payload
+		FROM outbox_events
+		WHERE status = 'published' AND `+where+`
+		ORDER BY id
+		LIMIT `+fmt.Sprint(maxReplayEvents+1), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []orderEvent
+	for rows.Next() {
+		var event orderEvent
+		var payload string
+		if err := rows.Scan(&event.RoutingKey, &event.OrderID, &payload); err != nil {
+			return nil, err
+		}
+		if !replayMatches(req, event.RoutingKey) {
+			continue
+		}
+		event.Body = []byte(payload)
+		events = append(events, event)
+	}
+	return events, rows.Err()
+}
+
+// replayOrderEvents rebuilds the events of the orders a replay selects
+// that have no events left in the outbox
+func (a *App) replayOrderEvents(ctx context.Context, req replayRequest) ([]orderEvent, error) {
+	where, args := replayFilter(req, func(from, to string) string {
+		return "((o.created_at >= " + from + " AND o.created_at < " + to + ")" +
+			" OR (o.updated_at >= " + from + " AND o.updated_at < " + to + "))"
+	}, "o.id")
+	rows, err := a.db.QueryContext(ctx, `
+		SELECT o.id, o.status, o.created_at, o.updated_at
+		FROM orders o
+		WHERE `+where+`
+		  AND NOT EXISTS (SELECT 1 FROM outbox_events e WHERE e.order_id = o.id)
+		ORDER BY o.created_at
+		LIMIT `+fmt.Sprint(maxReplayEvents+1), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []orderEvent
+	for rows.Next() {
+		var id, status string
+		var createdAt, updatedAt time.Time
+		if err := rows.Scan(&id, &status, &createdAt, &updatedAt); err != nil {
+			return nil, err
+		}
+		if replayMatches(req, "order.created") {
+			events = append(events, replayedOrderEvent("order.created", id, createdAt))
+		}
+		key := ""
+		switch status {
+		case "pending", "preorder":
+		case "cancelled":
+			key = "order.cancelled"
+		default:
+			key = "order.status." + status
+		}
+		if key != "" && replayMatches(req, key) {
+			events = append(events, replayedOrderEvent(key, id, updatedAt))
+		}
+	}
+	return events, rows.Err()
+}
+
+// replayedOrderEvent builds an event rebuilt from an order. Its event ID
+// is derived from the order and routing key, so replaying it again gives
+// the same ID.
+func replayedOrderEvent(routingKey, orderID string, at time.Time) orderEvent {
+	sum := sha256.Sum256([]byte("replay\n" + orderID + "\n" + routingKey))
+	id := hex.EncodeToString(sum[:16])
+	body, _ := json.Marshal(orderEventBody{
+		Event:     routingKey,
+		EventID:   id[:8] + "-" + id[8:12] + "-" + id[12:16] + "-" + id[16:20] + "-" + id[20:],
+		OrderID:   orderID,
+		Timestamp: at.UTC().Format(time.RFC3339),
+	})
+	return orderEvent{RoutingKey: routingKey, OrderID: orderID, Body: body, Tier: cachedOrderTier(orderID)}
+}
@@ -0,0 +1,22 @@
+// =============================================================================
+// ORDER EVENT SCHEMAS
+// =============================================================================
+// Protobuf schemas of the events the order service publishes, and the Go
+// types generated from them. Go consumers import this package to decode
+// protobuf-encoded events; other languages generate their own types from
+// order_events.proto.
+//
+//   var event eventspb.OrderCreated
+//   if delivery.ContentType == "application/x-protobuf" &&
+//       delivery.Type == "orders.events.v1.OrderCreated" {
+//       err := proto.Unmarshal(delivery.Body, &event)
+//   }
+//
+// Fields are only ever added, never renumbered or removed, so consumers
+// built against an older schema keep working. Regenerate after editing the
+// .proto with protoc and protoc-gen-go v1.31.0 (see go:generate below).
+// =============================================================================
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative order_events.proto
+
+package eventspb
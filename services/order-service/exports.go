@@ -0,0 +1,519 @@
+// =============================================================================
+// ASYNCHRONOUS EXPORT JOBS
+// =============================================================================
+// Exporting millions of orders inside a request handler ties up a
+// connection and a goroutine for minutes. Exports are jobs instead:
+//
+//   POST /api/v1/exports                 Create a job (202 Accepted)
+//   GET  /api/v1/exports/:id             Job status and progress
+//   GET  /api/v1/exports/:id/download    The finished file
+//
+// HOW IT WORKS:
+// - Jobs live in the export_jobs table, so every instance sees them
+// - EXPORT_WORKERS workers claim pending jobs with SKIP LOCKED and stream
+//   matching orders (csv or ndjson) into a file under EXPORT_DIR,
+//   updating rows_written as they go
+// - A job interrupted by shutdown goes back to pending; a job whose
+//   worker died is requeued once its progress is 5 minutes stale
+// - Files are deleted EXPORT_RETENTION after the job completes
+// - CSV exports with a "locale" (e.g. "de") get translated headers and
+//   statuses, the locale's number and date formats and its delimiter, for
+//   opening in a spreadsheet (see locale.go)
+//
+// With several instances, EXPORT_DIR must be a shared volume so any
+// instance can serve the download.
+// =============================================================================
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// exportProgressEvery is how many rows are written between progress updates
+	exportProgressEvery = 10000
+
+	// exportStaleAfter is how long a running job may go without progress
+	// before it is considered abandoned and requeued
+	exportStaleAfter = 5 * time.Minute
+)
+
+var (
+	// exportDir is where export files are written
+	exportDir = os.TempDir()
+
+	// exportRetention is how long finished export files are kept
+	exportRetention = 24 * time.Hour
+
+	// exportWake wakes an idle worker when a job is created
+	exportWake = make(chan struct{}, 1)
+
+	// exportWG tracks running export workers
+	exportWG sync.WaitGroup
+
+	// Counter: Export jobs finished, by result
+	exportJobsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "export_jobs_total",
+			Help: "Total number of export jobs finished",
+		},
+		[]string{"format", "result"},
+	)
+
+	// Counter: Orders written to export files
+	exportRowsTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "export_rows_total",
+			Help: "Total number of orders written to export files",
+		},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(exportJobsTotal)
+	prometheus.MustRegister(exportRowsTotal)
+}
+
+// ExportJob is the JSON view of an export job
+type ExportJob struct {
+	ID          string     `json:"id"`
+	Status      string     `json:"status"`
+	Format      string     `json:"format"`
+	Filter      ExportSpec `json:"filter"`
+	RowsWritten int64      `json:"rows_written"`
+	SizeBytes   int64      `json:"size_bytes,omitempty"`
+	Error       string     `json:"error,omitempty"`
+	DownloadURL string     `json:"download_url,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	StartedAt   *time.Time `json:"started_at,omitempty"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+
+	filePath string
+}
+
+// ExportSpec selects the orders to export
+type ExportSpec struct {
+	Format string     `json:"format,omitempty"`
+	Locale string     `json:"locale,omitempty"`
+	Status string     `json:"status,omitempty"`
+	From   *time.Time `json:"from,omitempty"`
+	To     *time.Time `json:"to,omitempty"`
+}
+
+// startExportWorkers starts the export workers and registers their
+// shutdown hook
+func (a *App) startExportWorkers(workers int, pollInterval time.Duration) {
+	if err := os.MkdirAll(exportDir, 0o755); err != nil {
+		logError("Export directory is not writable, exports will fail", map[string]interface{}{
+			"dir":   exportDir,
+			"error": err.Error(),
+		})
+	}
+
+	for i := 0; i < workers; i++ {
+		exportWG.Add(1)
+		go func() {
+			defer exportWG.Done()
+			ticker := time.NewTicker(pollInterval)
+			defer ticker.Stop()
+
+			for {
+				// Keep going while there is work, then wait
+				for a.runNextExport(backgroundCtx) {
+				}
+
+				select {
+				case <-backgroundCtx.Done():
+					return
+				case <-exportWake:
+				case <-ticker.C:
+					a.cleanupExports(backgroundCtx)
+				}
+			}
+		}()
+	}
+
+	onShutdown(phaseJobs, "export-workers", func(ctx context.Context) error {
+		done := make(chan struct{})
+		go func() {
+			exportWG.Wait()
+			close(done)
+		}()
+		select {
+		case <-done:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	})
+}
+
+// runNextExport claims and runs one pending job. It returns false when
+// there was nothing to do.
+func (a *App) runNextExport(ctx context.Context) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+
+	var job ExportJob
+	var spec []byte
+	err := a.db.QueryRowContext(ctx, `
+		UPDATE export_jobs
+		SET status = 'running', started_at = NOW(), updated_at = NOW(), rows_written = 0
+		WHERE id = (
+			SELECT id FROM export_jobs
+			WHERE status = 'pending'
+			   OR (status = 'running' AND updated_at < NOW() - make_interval(secs => $1))
+			ORDER BY created_at
+			LIMIT 1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id, format, filter
+	`, exportStaleAfter.Seconds()).Scan(&job.ID, &job.Format, &spec)
+	if err == sql.ErrNoRows {
+		return false
+	}
+	if err != nil {
+		logErrorContext(ctx, "Failed to claim export job", map[string]interface{}{
+			"error": err.Error(),
+		})
+		return false
+	}
+	json.Unmarshal(spec, &job.Filter)
+
+	start := time.Now()
+	logInfoContext(ctx, "Export job started", map[string]interface{}{
+		"export_id": job.ID,
+		"format":    job.Format,
+	})
+
+	path := filepath.Join(exportDir, job.ID+"."+job.Format)
+	rows, size, err := a.writeExport(ctx, job, path)
+
+	switch {
+	case err != nil && ctx.Err() != nil:
+		// Shutting down: let another instance (or the next start) redo it
+		os.Remove(path)
+		a.db.Exec(`UPDATE export_jobs SET status = 'pending', updated_at = NOW() WHERE id = $1`, job.ID)
+		logWarnContext(ctx, "Export job interrupted, requeued", map[string]interface{}{
+			"export_id": job.ID,
+		})
+	case err != nil:
+		os.Remove(path)
+		exportJobsTotal.WithLabelValues(job.Format, "failed").Inc()
+		a.db.Exec(`
+			UPDATE export_jobs SET status = 'failed', error = $1, completed_at = NOW(), updated_at = NOW()
+			WHERE id = $2
+		`, err.Error(), job.ID)
+		logErrorContext(ctx, "Export job failed", map[string]interface{}{
+			"export_id": job.ID,
+			"error":     err.Error(),
+		})
+	default:
+		exportJobsTotal.WithLabelValues(job.Format, "completed").Inc()
+		a.db.Exec(`
+			UPDATE export_jobs
+			SET status = 'completed', rows_written = $1, size_bytes = $2, file_path = $3,
+			    completed_at = NOW(), expires_at = NOW() + make_interval(secs => $4), updated_at = NOW()
+			WHERE id = $5
+		`, rows, size, path, exportRetention.Seconds(), job.ID)
+		logInfoContext(ctx, "Export job completed", map[string]interface{}{
+			"export_id":   job.ID,
+			"rows":        rows,
+			"size_bytes":  size,
+			"duration_ms": time.Since(start).Milliseconds(),
+		})
+	}
+	return true
+}
+
+// writeExport streams the orders selected by the job into a file and
+// returns the number of rows and bytes written
+func (a *App) writeExport(ctx context.Context, job ExportJob, path string) (int64, int64, error) {
+	query := `
+		SELECT id, customer_id, customer_name, customer_email, status,
+		       total_amount, currency, COALESCE(shipping_address, ''), COALESCE(notes, ''),
+		       created_at, updated_at
+		FROM orders WHERE 1=1`
+	var args []interface{}
+	if job.Filter.Status != "" {
+		args = append(args, job.Filter.Status)
+		query += fmt.Sprintf(" AND status = $%d", len(args))
+	}
+	if job.Filter.From != nil {
+		args = append(args, *job.Filter.From)
+		query += fmt.Sprintf(" AND created_at >= $%d", len(args))
+	}
+	if job.Filter.To != nil {
+		args = append(args, *job.Filter.To)
+		query += fmt.Sprintf(" AND created_at < $%d", len(args))
+	}
+	query += " ORDER BY created_at"
+
+	file, err := os.Create(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer file.Close()
+
+	rows, err := a.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer rows.Close()
+
+	buf := bufio.NewWriterSize(file, 256*1024)
+	var csvWriter *csv.Writer
+	var jsonEncoder *json.Encoder
+	var loc *locale
+	if job.Format == "csv" {
+		csvWriter = csv.NewWriter(buf)
+		if loc = lookupLocale(job.Filter.Locale); loc != nil {
+			csvWriter.Comma = []rune(loc.CSVDelimiter)[0]
+			csvWriter.Write([]string{
+				loc.T("Order ID"), loc.T("Customer ID"), loc.T("Customer name"), loc.T("Customer email"),
+				loc.T("Status"), loc.T("Total amount"), loc.T("Currency"), loc.T("Shipping address"),
+				loc.T("Notes"), loc.T("Created"), loc.T("Updated"),
+			})
+		} else {
+			csvWriter.Write([]string{
+				"id", "customer_id", "customer_name", "customer_email", "status",
+				"total_amount", "currency", "shipping_address", "notes", "created_at", "updated_at",
+			})
+		}
+	} else {
+		jsonEncoder = json.NewEncoder(buf)
+	}
+
+	var written int64
+	for rows.Next() {
+		var o Order
+		if err := rows.Scan(
+			&o.ID, &o.CustomerID, &o.CustomerName, &o.CustomerEmail,
+			&o.Status, &o.TotalAmount, &o.Currency,
+			&o.ShippingAddress, &o.Notes, &o.CreatedAt, &o.UpdatedAt,
+		); err != nil {
+			return written, 0, err
+		}
+
+		switch {
+		case loc != nil:
+			err = csvWriter.Write([]string{
+				o.ID, o.CustomerID, o.CustomerName, o.CustomerEmail, loc.T(o.Status),
+				loc.Number(roundMoney(o.TotalAmount, o.Currency), currencyDecimals(o.Currency)), o.Currency,
+				o.ShippingAddress, o.Notes,
+				loc.FormatDateTime(o.CreatedAt), loc.FormatDateTime(o.UpdatedAt),
+			})
+		case csvWriter != nil:
+			err = csvWriter.Write([]string{
+				o.ID, o.CustomerID, o.CustomerName, o.CustomerEmail, o.Status,
+				formatMoney(o.TotalAmount, o.Currency), o.Currency,
+				o.ShippingAddress, o.Notes,
+				o.CreatedAt.Format(time.RFC3339), o.UpdatedAt.Format(time.RFC3339),
+			})
+		default:
+			err = jsonEncoder.Encode(o)
+		}
+		if err != nil {
+			return written, 0, err
+		}
+
+		written++
+		if written%exportProgressEvery == 0 {
+			exportRowsTotal.Add(exportProgressEvery)
+			a.db.Exec(`UPDATE export_jobs SET rows_written = $1, updated_at = NOW() WHERE id = $2`, written, job.ID)
+		}
+	}
+	exportRowsTotal.Add(float64(written % exportProgressEvery))
+	if err := rows.Err(); err != nil {
+		return written, 0, err
+	}
+
+	if csvWriter != nil {
+		csvWriter.Flush()
+		if err := csvWriter.Error(); err != nil {
+			return written, 0, err
+		}
+	}
+	if err := buf.Flush(); err != nil {
+		return written, 0, err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		return written, 0, err
+	}
+	return written, info.Size(), nil
+}
+
+// cleanupExports deletes the files of expired export jobs
+func (a *App) cleanupExports(ctx context.Context) {
+	rows, err := a.db.QueryContext(ctx, `
+		UPDATE export_jobs SET status = 'expired', file_path = NULL, updated_at = NOW()
+		WHERE status = 'completed' AND expires_at < NOW()
+		RETURNING file_path
+	`)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var path sql.NullString
+		if rows.Scan(&path) == nil && path.Valid {
+			os.Remove(path.String)
+		}
+	}
+}
+
+// =============================================================================
+// EXPORT HANDLERS
+// =============================================================================
+
+// createExport queues an export job
+func (a *App) createExport(c *gin.Context) {
+	var req ExportSpec
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if req.Format == "" {
+		req.Format = "ndjson"
+	}
+	if req.Format != "csv" && req.Format != "ndjson" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": tr(c, "format must be csv or ndjson")})
+		return
+	}
+	if req.Status != "" && !validOrderStatuses[req.Status] {
+		c.JSON(http.StatusBadRequest, gin.H{"error": tr(c, "Invalid status")})
+		return
+	}
+	if req.Locale != "" && lookupLocale(req.Locale) == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": tr(c, "Unknown locale %q", req.Locale)})
+		return
+	}
+
+	filter, _ := json.Marshal(ExportSpec{Locale: req.Locale, Status: req.Status, From: req.From, To: req.To})
+
+	var job ExportJob
+	err := a.db.QueryRowContext(c.Request.Context(), `
+		INSERT INTO export_jobs (format, filter) VALUES ($1, $2)
+		RETURNING id, status, created_at
+	`, req.Format, string(filter)).Scan(&job.ID, &job.Status, &job.CreatedAt)
+	if err != nil {
+		logErrorContext(c.Request.Context(), "Failed to create export job", map[string]interface{}{
+			"error": err.Error(),
+		})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": tr(c, "Database error")})
+		return
+	}
+	job.Format = req.Format
+	json.Unmarshal(filter, &job.Filter)
+
+	// Wake an idle worker, if any
+	select {
+	case exportWake <- struct{}{}:
+	default:
+	}
+
+	logInfoContext(c.Request.Context(), "Export job created", map[string]interface{}{
+		"export_id": job.ID,
+		"format":    job.Format,
+	})
+
+	c.Header("Location", "/api/v1/exports/"+job.ID)
+	c.JSON(http.StatusAccepted, job)
+}
+
+// getExport reports the status of an export job
+func (a *App) getExport(c *gin.Context) {
+	job, err := a.loadExportJob(c.Request.Context(), c.Param("id"))
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": tr(c, "Export not found")})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": tr(c, "Database error")})
+		return
+	}
+	c.JSON(http.StatusOK, job)
+}
+
+// downloadExport serves the file of a completed export job
+func (a *App) downloadExport(c *gin.Context) {
+	job, err := a.loadExportJob(c.Request.Context(), c.Param("id"))
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": tr(c, "Export not found")})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": tr(c, "Database error")})
+		return
+	}
+
+	switch job.Status {
+	case "completed":
+	case "expired":
+		c.JSON(http.StatusGone, gin.H{"error": tr(c, "Export has expired")})
+		return
+	default:
+		c.JSON(http.StatusConflict, gin.H{"error": tr(c, "Export is not ready"), "status": job.Status})
+		return
+	}
+
+	if _, err := os.Stat(job.filePath); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": tr(c, "Export file is not available on this instance")})
+		return
+	}
+
+	if job.Format == "csv" {
+		c.Header("Content-Type", "text/csv")
+	} else {
+		c.Header("Content-Type", "application/x-ndjson")
+	}
+	c.FileAttachment(job.filePath, "orders-"+job.ID+"."+job.Format)
+}
+
+// loadExportJob reads an export job by ID
+func (a *App) loadExportJob(ctx context.Context, id string) (*ExportJob, error) {
+	var job ExportJob
+	var filter []byte
+	var size sql.NullInt64
+	var errMsg, path sql.NullString
+	err := a.db.QueryRowContext(ctx, `
+		SELECT id, status, format, filter, rows_written, size_bytes, error, file_path,
+		       created_at, started_at, completed_at, expires_at
+		FROM export_jobs WHERE id::text = $1
+	`, id).Scan(
+		&job.ID, &job.Status, &job.Format, &filter, &job.RowsWritten, &size, &errMsg, &path,
+		&job.CreatedAt, &job.StartedAt, &job.CompletedAt, &job.ExpiresAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	json.Unmarshal(filter, &job.Filter)
+	job.SizeBytes = size.Int64
+	job.Error = errMsg.String
+	job.filePath = path.String
+	if job.Status == "completed" {
+		job.DownloadURL = "/api/v1/exports/" + job.ID + "/download"
+	}
+	return &job, nil
+}
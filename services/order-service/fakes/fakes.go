@@ -0,0 +1,269 @@
+// =============================================================================
+// FAKE DOWNSTREAM SERVICES
+// =============================================================================
+// In-process fakes of the inventory, payment, user and notification HTTP
+// APIs, following the same contracts as the real services (paths, status
+// codes and JSON field names, e.g. payment amounts as decimal strings and
+// users in camelCase). They keep their state in memory, so they need no
+// database, broker or cache.
+//
+// IN TESTS:
+//
+//   payments := fakes.NewPayment()
+//   payments.AddPayment(fakes.Payment{OrderID: id, Amount: "42.00", Status: "completed"})
+//   srv := httptest.NewServer(payments)
+//   defer srv.Close()
+//   app := NewApp(AppDeps{PaymentURL: srv.URL})
+//
+// Every fake records the requests it served (Requests) for assertions.
+//
+// STANDALONE: cmd/fakes serves all four on the ports the order service
+// expects by default, for running it locally without the full stack.
+//
+// BEHAVIOR:
+// Latency, jitter and a failure rate can be set per fake, from code with
+// SetBehavior or over HTTP:
+//
+//   GET  /_fake/behavior    Current behavior
+//   PUT  /_fake/behavior    {"latency_ms": 200, "jitter_ms": 50,
+//                            "failure_rate": 0.1, "failure_status": 503}
+//   GET  /_fake/requests    Requests served so far
+//   POST /_fake/reset       Clear state, requests and behavior
+//
+// Health endpoints (/health, /ready) are never delayed or failed, so
+// orchestration keeps working while the API misbehaves.
+// =============================================================================
+
+package fakes
+
+import (
+	"bytes"
+	crand "crypto/rand"
+	"encoding/json"
+	"io"
+	"math/rand"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Behavior controls how a fake answers API requests
+type Behavior struct {
+	// Latency is added to every API request, plus up to Jitter more
+	Latency time.Duration
+	Jitter  time.Duration
+
+	// FailureRate is the share of API requests (0-1) answered with
+	// FailureStatus (503 if unset) instead of being served
+	FailureRate   float64
+	FailureStatus int
+}
+
+// behaviorJSON is the wire form of Behavior, with durations in milliseconds
+type behaviorJSON struct {
+	LatencyMs     int64   `json:"latency_ms"`
+	JitterMs      int64   `json:"jitter_ms"`
+	FailureRate   float64 `json:"failure_rate"`
+	FailureStatus int     `json:"failure_status,omitempty"`
+}
+
+// Request is a request served by a fake
+type Request struct {
+	Time   time.Time `json:"time"`
+	Method string    `json:"method"`
+	Path   string    `json:"path"`
+	Body   string    `json:"body,omitempty"`
+	Status int       `json:"status"`
+}
+
+// base is the behavior and request log shared by every fake
+type base struct {
+	name    string
+	version string
+	api     func(w http.ResponseWriter, r *http.Request)
+	reset   func()
+
+	mu       sync.Mutex
+	behavior Behavior
+	requests []Request
+}
+
+// SetBehavior changes how the fake answers from now on
+func (b *base) SetBehavior(behavior Behavior) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.behavior = behavior
+}
+
+// Behavior returns the current behavior
+func (b *base) Behavior() Behavior {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.behavior
+}
+
+// Requests returns the API requests served so far, oldest first
+func (b *base) Requests() []Request {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]Request(nil), b.requests...)
+}
+
+// Reset clears the fake's state, request log and behavior
+func (b *base) Reset() {
+	b.mu.Lock()
+	b.behavior = Behavior{}
+	b.requests = nil
+	b.mu.Unlock()
+	b.reset()
+}
+
+// ServeHTTP serves the health, control and API endpoints
+func (b *base) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.URL.Path == "/health":
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ok", "service": b.name, "version": b.version})
+		return
+	case r.URL.Path == "/ready":
+		writeJSON(w, http.StatusOK, map[string]interface{}{"status": "ready", "checks": map[string]bool{}})
+		return
+	case strings.HasPrefix(r.URL.Path, "/_fake/"):
+		b.control(w, r)
+		return
+	}
+
+	var body []byte
+	if r.Body != nil {
+		body, _ = readBody(r)
+	}
+	rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+	defer func() {
+		b.mu.Lock()
+		b.requests = append(b.requests, Request{
+			Time:   time.Now().UTC(),
+			Method: r.Method,
+			Path:   r.URL.RequestURI(),
+			Body:   string(body),
+			Status: rec.status,
+		})
+		b.mu.Unlock()
+	}()
+
+	behavior := b.Behavior()
+	delay := behavior.Latency
+	if behavior.Jitter > 0 {
+		delay += time.Duration(rand.Int63n(int64(behavior.Jitter)))
+	}
+	if delay > 0 {
+		select {
+		case <-time.After(delay):
+		case <-r.Context().Done():
+			return
+		}
+	}
+	if behavior.FailureRate > 0 && rand.Float64() < behavior.FailureRate {
+		status := behavior.FailureStatus
+		if status == 0 {
+			status = http.StatusServiceUnavailable
+		}
+		writeJSON(rec, status, map[string]string{"error": "simulated failure", "service": b.name})
+		return
+	}
+
+	b.api(rec, r)
+}
+
+// control serves the /_fake endpoints
+func (b *base) control(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.URL.Path == "/_fake/behavior" && r.Method == http.MethodGet:
+		cur := b.Behavior()
+		writeJSON(w, http.StatusOK, behaviorJSON{
+			LatencyMs:     cur.Latency.Milliseconds(),
+			JitterMs:      cur.Jitter.Milliseconds(),
+			FailureRate:   cur.FailureRate,
+			FailureStatus: cur.FailureStatus,
+		})
+	case r.URL.Path == "/_fake/behavior" && r.Method == http.MethodPut:
+		var req behaviorJSON
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		if req.FailureRate < 0 || req.FailureRate > 1 {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "failure_rate must be between 0 and 1"})
+			return
+		}
+		b.SetBehavior(Behavior{
+			Latency:       time.Duration(req.LatencyMs) * time.Millisecond,
+			Jitter:        time.Duration(req.JitterMs) * time.Millisecond,
+			FailureRate:   req.FailureRate,
+			FailureStatus: req.FailureStatus,
+		})
+		writeJSON(w, http.StatusOK, req)
+	case r.URL.Path == "/_fake/requests" && r.Method == http.MethodGet:
+		writeJSON(w, http.StatusOK, b.Requests())
+	case r.URL.Path == "/_fake/reset" && r.Method == http.MethodPost:
+		b.Reset()
+		writeJSON(w, http.StatusOK, map[string]string{"message": "reset"})
+	default:
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "not found"})
+	}
+}
+
+// statusRecorder remembers the status code written to a response
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// readBody reads a request body and puts it back for the handler
+func readBody(r *http.Request) ([]byte, error) {
+	body, err := io.ReadAll(r.Body)
+	r.Body.Close()
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	return body, err
+}
+
+// writeJSON writes a JSON response
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// pathParam returns the part of path after prefix, or "" if path doesn't
+// start with it or has more segments
+func pathParam(path, prefix string) string {
+	if !strings.HasPrefix(path, prefix) {
+		return ""
+	}
+	rest := strings.TrimPrefix(path, prefix)
+	if strings.Contains(rest, "/") {
+		return ""
+	}
+	return rest
+}
+
+// newID returns a random UUID (version 4)
+func newID() string {
+	b := make([]byte, 16)
+	crand.Read(b)
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	const hex = "0123456789abcdef"
+	out := make([]byte, 0, 36)
+	for i, c := range b {
+		if i == 4 || i == 6 || i == 8 || i == 10 {
+			out = append(out, '-')
+		}
+		out = append(out, hex[c>>4], hex[c&0x0f])
+	}
+	return string(out)
+}
@@ -0,0 +1,248 @@
+package fakes
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// InventoryItem is a stock item as returned by the inventory service
+type InventoryItem struct {
+	ID                string    `json:"id"`
+	SKU               string    `json:"sku"`
+	Name              string    `json:"name"`
+	Quantity          int       `json:"quantity"`
+	Reserved          int       `json:"reserved"`
+	Warehouse         string    `json:"warehouse"`
+	LowStockThreshold int       `json:"low_stock_threshold"`
+	CreatedAt         time.Time `json:"created_at"`
+	UpdatedAt         time.Time `json:"updated_at"`
+}
+
+// Reservation is a stock reservation as returned by the inventory service
+type Reservation struct {
+	ReservationID string     `json:"reservation_id"`
+	SKU           string     `json:"sku"`
+	Quantity      int        `json:"quantity"`
+	CreatedAt     time.Time  `json:"created_at"`
+	ExpiresAt     *time.Time `json:"expires_at"`
+}
+
+// InventoryService fakes the inventory service:
+//
+//	GET  /api/v1/inventory           Items, paginated (page, per_page)
+//	GET  /api/v1/inventory/{sku}     One item
+//	POST /api/v1/inventory/reserve   {"sku", "quantity", "order_id"}
+//	POST /api/v1/inventory/release   {"sku", "quantity", "order_id"}
+//	POST /api/v1/inventory/adjust    {"sku", "delta", "reason"}
+//	GET  /api/v1/inventory/alerts    Items at or below their threshold
+//
+// Errors use the real service's {"error", "message"} shape; failed
+// reservations are 400s, as in the real service.
+type InventoryService struct {
+	base
+
+	mu    sync.Mutex
+	items map[string]*InventoryItem
+}
+
+// NewInventory returns a fake inventory service without any stock
+func NewInventory() *InventoryService {
+	f := &InventoryService{items: map[string]*InventoryItem{}}
+	f.base = base{name: "inventory-service", version: "fake", api: f.serve, reset: f.clear}
+	return f
+}
+
+// SetStock creates or replaces an item with the given quantity and no
+// reservations
+func (f *InventoryService) SetStock(sku string, quantity int) {
+	now := time.Now().UTC()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.items[sku] = &InventoryItem{
+		ID:                newID(),
+		SKU:               sku,
+		Name:              "Fake " + sku,
+		Quantity:          quantity,
+		Warehouse:         "fake-warehouse",
+		LowStockThreshold: 10,
+		CreatedAt:         now,
+		UpdatedAt:         now,
+	}
+}
+
+// Item returns an item by SKU
+func (f *InventoryService) Item(sku string) (InventoryItem, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	item, ok := f.items[sku]
+	if !ok {
+		return InventoryItem{}, false
+	}
+	return *item, true
+}
+
+func (f *InventoryService) clear() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.items = map[string]*InventoryItem{}
+}
+
+func (f *InventoryService) serve(w http.ResponseWriter, r *http.Request) {
+	path := r.URL.Path
+	switch {
+	case path == "/api/v1/inventory" && r.Method == http.MethodGet:
+		f.list(w, r)
+	case path == "/api/v1/inventory/alerts" && r.Method == http.MethodGet:
+		f.alerts(w)
+	case (path == "/api/v1/inventory/reserve" || path == "/api/v1/inventory/release") && r.Method == http.MethodPost:
+		f.reserveOrRelease(w, r, path == "/api/v1/inventory/reserve")
+	case path == "/api/v1/inventory/adjust" && r.Method == http.MethodPost:
+		f.adjust(w, r)
+	case pathParam(path, "/api/v1/inventory/") != "" && r.Method == http.MethodGet:
+		item, ok := f.Item(pathParam(path, "/api/v1/inventory/"))
+		if !ok {
+			inventoryError(w, http.StatusNotFound, "NOT_FOUND", "Item not found")
+			return
+		}
+		writeJSON(w, http.StatusOK, item)
+	default:
+		inventoryError(w, http.StatusNotFound, "NOT_FOUND", "Route not found")
+	}
+}
+
+func (f *InventoryService) list(w http.ResponseWriter, r *http.Request) {
+	page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+	if page < 1 {
+		page = 1
+	}
+	perPage, _ := strconv.Atoi(r.URL.Query().Get("per_page"))
+	if perPage < 1 || perPage > 100 {
+		perPage = 20
+	}
+
+	f.mu.Lock()
+	items := make([]InventoryItem, 0, len(f.items))
+	for _, item := range f.items {
+		items = append(items, *item)
+	}
+	f.mu.Unlock()
+	sort.Slice(items, func(i, j int) bool { return items[i].SKU < items[j].SKU })
+
+	total := len(items)
+	start := (page - 1) * perPage
+	if start > total {
+		start = total
+	}
+	end := start + perPage
+	if end > total {
+		end = total
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"items":    items[start:end],
+		"total":    total,
+		"page":     page,
+		"per_page": perPage,
+	})
+}
+
+func (f *InventoryService) alerts(w http.ResponseWriter) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	alerts := []map[string]interface{}{}
+	for _, item := range f.items {
+		if available := item.Quantity - item.Reserved; available <= item.LowStockThreshold {
+			alerts = append(alerts, map[string]interface{}{
+				"sku":       item.SKU,
+				"name":      item.Name,
+				"available": available,
+				"threshold": item.LowStockThreshold,
+				"warehouse": item.Warehouse,
+			})
+		}
+	}
+	writeJSON(w, http.StatusOK, alerts)
+}
+
+func (f *InventoryService) reserveOrRelease(w http.ResponseWriter, r *http.Request, reserve bool) {
+	var req struct {
+		SKU      string `json:"sku"`
+		Quantity int    `json:"quantity"`
+		OrderID  string `json:"order_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.SKU == "" || req.Quantity <= 0 {
+		inventoryError(w, http.StatusBadRequest, "BAD_REQUEST", "sku and a positive quantity are required")
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	item, ok := f.items[req.SKU]
+	if !ok {
+		inventoryError(w, http.StatusBadRequest, "BAD_REQUEST", fmt.Sprintf("Item %s not found", req.SKU))
+		return
+	}
+
+	now := time.Now().UTC()
+	if !reserve {
+		item.Reserved -= req.Quantity
+		if item.Reserved < 0 {
+			item.Reserved = 0
+		}
+		item.UpdatedAt = now
+		writeJSON(w, http.StatusOK, map[string]interface{}{"sku": req.SKU, "released": req.Quantity})
+		return
+	}
+
+	if available := item.Quantity - item.Reserved; available < req.Quantity {
+		inventoryError(w, http.StatusBadRequest, "BAD_REQUEST",
+			fmt.Sprintf("Insufficient stock for %s: %d available, %d requested", req.SKU, available, req.Quantity))
+		return
+	}
+	item.Reserved += req.Quantity
+	item.UpdatedAt = now
+	expires := now.Add(15 * time.Minute)
+	writeJSON(w, http.StatusOK, Reservation{
+		ReservationID: newID(),
+		SKU:           req.SKU,
+		Quantity:      req.Quantity,
+		CreatedAt:     now,
+		ExpiresAt:     &expires,
+	})
+}
+
+func (f *InventoryService) adjust(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		SKU    string `json:"sku"`
+		Delta  int    `json:"delta"`
+		Reason string `json:"reason"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.SKU == "" {
+		inventoryError(w, http.StatusBadRequest, "BAD_REQUEST", "sku is required")
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	item, ok := f.items[req.SKU]
+	if !ok {
+		inventoryError(w, http.StatusNotFound, "NOT_FOUND", fmt.Sprintf("Item %s not found", req.SKU))
+		return
+	}
+	if item.Quantity+req.Delta < item.Reserved {
+		inventoryError(w, http.StatusBadRequest, "BAD_REQUEST", "Adjustment would leave less stock than is reserved")
+		return
+	}
+	item.Quantity += req.Delta
+	item.UpdatedAt = time.Now().UTC()
+	writeJSON(w, http.StatusOK, *item)
+}
+
+// inventoryError writes an error in the inventory service's format
+func inventoryError(w http.ResponseWriter, status int, code, message string) {
+	writeJSON(w, status, map[string]string{"error": code, "message": message})
+}
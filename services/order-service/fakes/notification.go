@@ -0,0 +1,84 @@
+package fakes
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// SentNotification is a notification as returned by the notification service
+type SentNotification struct {
+	ID        string `json:"id"`
+	Type      string `json:"type"`
+	Recipient string `json:"recipient"`
+	Subject   string `json:"subject,omitempty"`
+	Body      string `json:"body"`
+	OrderID   string `json:"order_id,omitempty"`
+	Status    string `json:"status"`
+	SentAt    string `json:"sent_at"`
+}
+
+// NotificationService fakes the notification service:
+//
+//	POST /api/v1/notifications/send   {"type", "recipient", "body", ...} (201)
+//	GET  /api/v1/notifications        The 50 most recent, newest first
+//
+// Nothing is delivered; Sent returns what would have been.
+type NotificationService struct {
+	base
+
+	mu   sync.Mutex
+	sent []SentNotification
+}
+
+// NewNotification returns a fake notification service
+func NewNotification() *NotificationService {
+	f := &NotificationService{}
+	f.base = base{name: "notification-service", version: "fake", api: f.serve, reset: f.clear}
+	return f
+}
+
+// Sent returns the notifications sent so far, oldest first
+func (f *NotificationService) Sent() []SentNotification {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]SentNotification(nil), f.sent...)
+}
+
+func (f *NotificationService) clear() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.sent = nil
+}
+
+func (f *NotificationService) serve(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.URL.Path == "/api/v1/notifications/send" && r.Method == http.MethodPost:
+		var n SentNotification
+		if err := json.NewDecoder(r.Body).Decode(&n); err != nil || n.Type == "" || n.Recipient == "" || n.Body == "" {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "Missing required fields: type, recipient, body"})
+			return
+		}
+		now := time.Now().UTC()
+		n.ID = fmt.Sprintf("notif-%d-%s", now.UnixMilli(), newID()[:9])
+		n.Status = "sent"
+		n.SentAt = now.Format("2006-01-02T15:04:05.000Z")
+
+		f.mu.Lock()
+		f.sent = append(f.sent, n)
+		f.mu.Unlock()
+		writeJSON(w, http.StatusCreated, n)
+	case r.URL.Path == "/api/v1/notifications" && r.Method == http.MethodGet:
+		f.mu.Lock()
+		recent := []SentNotification{}
+		for i := len(f.sent) - 1; i >= 0 && len(recent) < 50; i-- {
+			recent = append(recent, f.sent[i])
+		}
+		f.mu.Unlock()
+		writeJSON(w, http.StatusOK, recent)
+	default:
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "Not found"})
+	}
+}
@@ -0,0 +1,202 @@
+package fakes
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DeclinedCard is the card number the fake payment service declines
+const DeclinedCard = "4000000000000002"
+
+// Payment is a payment as returned by the payment service. Amounts are
+// decimal strings, like the real service's Decimal serialization.
+type Payment struct {
+	ID               string    `json:"id"`
+	OrderID          string    `json:"order_id"`
+	Amount           string    `json:"amount"`
+	Currency         string    `json:"currency"`
+	Status           string    `json:"status"`
+	PaymentMethod    string    `json:"payment_method"`
+	GatewayReference *string   `json:"gateway_reference"`
+	ErrorMessage     *string   `json:"error_message"`
+	CreatedAt        time.Time `json:"created_at"`
+	UpdatedAt        time.Time `json:"updated_at"`
+}
+
+// PaymentService fakes the payment service:
+//
+//	POST /api/v1/payments                    Process a payment (201)
+//	GET  /api/v1/payments/{id}               A payment
+//	GET  /api/v1/payments/order/{order_id}   An order's payments, newest first
+//	POST /api/v1/payments/{id}/refund        Refund a completed payment
+//
+// Payments succeed unless paid with DeclinedCard; repeating a payment for
+// an order that already has a completed one returns the existing payment,
+// like the real service's idempotency check.
+type PaymentService struct {
+	base
+
+	mu       sync.Mutex
+	payments []Payment
+}
+
+// NewPayment returns an empty fake payment service
+func NewPayment() *PaymentService {
+	f := &PaymentService{}
+	f.base = base{name: "payment-service", version: "fake", api: f.serve, reset: f.clear}
+	return f
+}
+
+// AddPayment stores a payment, filling in the ID, currency, method and
+// timestamps if they are empty
+func (f *PaymentService) AddPayment(p Payment) Payment {
+	now := time.Now().UTC()
+	if p.ID == "" {
+		p.ID = newID()
+	}
+	if p.Currency == "" {
+		p.Currency = "USD"
+	}
+	if p.PaymentMethod == "" {
+		p.PaymentMethod = "card"
+	}
+	if p.Status == "" {
+		p.Status = "completed"
+	}
+	if p.CreatedAt.IsZero() {
+		p.CreatedAt = now
+	}
+	if p.UpdatedAt.IsZero() {
+		p.UpdatedAt = p.CreatedAt
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.payments = append(f.payments, p)
+	return p
+}
+
+// Payments returns every stored payment
+func (f *PaymentService) Payments() []Payment {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]Payment(nil), f.payments...)
+}
+
+func (f *PaymentService) clear() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.payments = nil
+}
+
+func (f *PaymentService) serve(w http.ResponseWriter, r *http.Request) {
+	path := r.URL.Path
+	switch {
+	case path == "/api/v1/payments" && r.Method == http.MethodPost:
+		f.process(w, r)
+	case strings.HasSuffix(path, "/refund") && r.Method == http.MethodPost:
+		f.refund(w, pathParam(strings.TrimSuffix(path, "/refund"), "/api/v1/payments/"))
+	case pathParam(path, "/api/v1/payments/order/") != "" && r.Method == http.MethodGet:
+		orderID := pathParam(path, "/api/v1/payments/order/")
+		f.mu.Lock()
+		payments := []Payment{}
+		for i := len(f.payments) - 1; i >= 0; i-- {
+			if f.payments[i].OrderID == orderID {
+				payments = append(payments, f.payments[i])
+			}
+		}
+		f.mu.Unlock()
+		writeJSON(w, http.StatusOK, payments)
+	case pathParam(path, "/api/v1/payments/") != "" && r.Method == http.MethodGet:
+		if p, ok := f.find(pathParam(path, "/api/v1/payments/")); ok {
+			writeJSON(w, http.StatusOK, p)
+		} else {
+			writeJSON(w, http.StatusNotFound, map[string]string{"detail": "Payment not found"})
+		}
+	default:
+		writeJSON(w, http.StatusNotFound, map[string]string{"detail": "Not Found"})
+	}
+}
+
+func (f *PaymentService) process(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		OrderID       string      `json:"order_id"`
+		Amount        json.Number `json:"amount"`
+		Currency      string      `json:"currency"`
+		PaymentMethod string      `json:"payment_method"`
+		CardNumber    string      `json:"card_number"`
+	}
+	dec := json.NewDecoder(r.Body)
+	dec.UseNumber()
+	if err := dec.Decode(&req); err != nil || req.OrderID == "" || req.PaymentMethod == "" {
+		writeJSON(w, http.StatusUnprocessableEntity, map[string]string{"detail": "order_id, amount and payment_method are required"})
+		return
+	}
+	amount, err := strconv.ParseFloat(req.Amount.String(), 64)
+	if err != nil || amount <= 0 {
+		writeJSON(w, http.StatusUnprocessableEntity, map[string]string{"detail": "amount must be greater than 0"})
+		return
+	}
+
+	f.mu.Lock()
+	for _, p := range f.payments {
+		if p.OrderID == req.OrderID && p.Status == "completed" {
+			f.mu.Unlock()
+			writeJSON(w, http.StatusCreated, p)
+			return
+		}
+	}
+	f.mu.Unlock()
+
+	p := Payment{
+		OrderID:       req.OrderID,
+		Amount:        strconv.FormatFloat(amount, 'f', 2, 64),
+		Currency:      req.Currency,
+		PaymentMethod: req.PaymentMethod,
+		Status:        "completed",
+	}
+	if req.CardNumber == DeclinedCard {
+		msg := "Card declined"
+		p.Status = "failed"
+		p.ErrorMessage = &msg
+	} else {
+		ref := "fake_" + newID()[:8]
+		p.GatewayReference = &ref
+	}
+	writeJSON(w, http.StatusCreated, f.AddPayment(p))
+}
+
+func (f *PaymentService) refund(w http.ResponseWriter, id string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i := range f.payments {
+		p := &f.payments[i]
+		if p.ID != id {
+			continue
+		}
+		if p.Status != "completed" {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"detail": "Only completed payments can be refunded"})
+			return
+		}
+		p.Status = "refunded"
+		p.UpdatedAt = time.Now().UTC()
+		writeJSON(w, http.StatusOK, *p)
+		return
+	}
+	writeJSON(w, http.StatusNotFound, map[string]string{"detail": "Payment not found"})
+}
+
+func (f *PaymentService) find(id string) (Payment, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, p := range f.payments {
+		if p.ID == id {
+			return p, true
+		}
+	}
+	return Payment{}, false
+}
@@ -0,0 +1,137 @@
+package fakes
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// User is a user as returned by the user service (camelCase, like its
+// Jackson serialization)
+type User struct {
+	ID            string     `json:"id"`
+	Email         string     `json:"email"`
+	Name          string     `json:"name"`
+	Role          string     `json:"role"`
+	IsActive      bool       `json:"isActive"`
+	EmailVerified bool       `json:"emailVerified"`
+	CreatedAt     time.Time  `json:"createdAt"`
+	UpdatedAt     time.Time  `json:"updatedAt"`
+	LastLoginAt   *time.Time `json:"lastLoginAt"`
+}
+
+// Address is a saved address of a user's address book
+type Address struct {
+	ID            string `json:"id"`
+	Label         string `json:"label,omitempty"`
+	RecipientName string `json:"recipientName,omitempty"`
+	Line1         string `json:"line1"`
+	Line2         string `json:"line2,omitempty"`
+	PostalCode    string `json:"postalCode,omitempty"`
+	City          string `json:"city"`
+	Region        string `json:"region,omitempty"`
+	Country       string `json:"country"`
+}
+
+// UserService fakes the user service's lookups:
+//
+//	GET /api/v1/users        Every user
+//	GET /api/v1/users/{id}   One user, or an empty 404
+//	GET /api/v1/users/{id}/addresses/{address_id}
+//	                         One saved address, or an empty 404
+//
+// Registration and login aren't faked; add users with AddUser and their
+// addresses with AddAddress.
+type UserService struct {
+	base
+
+	mu        sync.Mutex
+	users     map[string]User
+	addresses map[string]map[string]Address
+}
+
+// NewUser returns a fake user service without any users
+func NewUser() *UserService {
+	f := &UserService{users: map[string]User{}, addresses: map[string]map[string]Address{}}
+	f.base = base{name: "user-service", version: "fake", api: f.serve, reset: f.clear}
+	return f
+}
+
+// AddUser stores a user, filling in the ID, role and timestamps if they
+// are empty
+func (f *UserService) AddUser(u User) User {
+	if u.ID == "" {
+		u.ID = newID()
+	}
+	if u.Role == "" {
+		u.Role = "user"
+	}
+	if u.CreatedAt.IsZero() {
+		u.CreatedAt = time.Now().UTC()
+	}
+	if u.UpdatedAt.IsZero() {
+		u.UpdatedAt = u.CreatedAt
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.users[u.ID] = u
+	return u
+}
+
+// AddAddress stores a saved address of a user, filling in the ID if it is
+// empty. The user doesn't need to exist.
+func (f *UserService) AddAddress(userID string, addr Address) Address {
+	if addr.ID == "" {
+		addr.ID = newID()
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.addresses[userID] == nil {
+		f.addresses[userID] = map[string]Address{}
+	}
+	f.addresses[userID][addr.ID] = addr
+	return addr
+}
+
+func (f *UserService) clear() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.users = map[string]User{}
+	f.addresses = map[string]map[string]Address{}
+}
+
+func (f *UserService) serve(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if r.URL.Path == "/api/v1/users" {
+		users := make([]User, 0, len(f.users))
+		for _, u := range f.users {
+			users = append(users, u)
+		}
+		sort.Slice(users, func(i, j int) bool { return users[i].CreatedAt.Before(users[j].CreatedAt) })
+		writeJSON(w, http.StatusOK, users)
+		return
+	}
+	if userID, rest, ok := strings.Cut(strings.TrimPrefix(r.URL.Path, "/api/v1/users/"), "/"); ok {
+		if addr, ok := f.addresses[userID][pathParam("/"+rest, "/addresses/")]; ok {
+			writeJSON(w, http.StatusOK, addr)
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if u, ok := f.users[pathParam(r.URL.Path, "/api/v1/users/")]; ok {
+		writeJSON(w, http.StatusOK, u)
+		return
+	}
+	w.WriteHeader(http.StatusNotFound)
+}
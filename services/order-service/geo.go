@@ -0,0 +1,140 @@
+// =============================================================================
+// ORDER GEOGRAPHY
+// =============================================================================
+// The lab's geomap panel shows where orders come from. Shipping addresses
+// are free text, so only the country is derived from them: the last
+// comma-separated part of the address ("12 Market Street, Berlin, Germany")
+// is matched against country names, common aliases and ISO 3166 codes.
+// Postal codes in that part are ignored ("Lisbon, 1100-148 Portugal").
+// Two-letter parts are read as ISO codes, so "Austin, CA" counts for
+// Canada - addresses should end with the country.
+//
+// Orders whose country can't be derived are counted as "unknown". The
+// label is always an ISO 3166-1 alpha-2 code, which the geomap panel
+// resolves with its "countries" lookup.
+//
+// METRICS:
+// - orders_created_total{country}   Orders created (see main.go)
+// - orders_revenue_total{country}   Sum of the totals of created orders
+// =============================================================================
+
+package main
+
+import (
+	"strings"
+	"unicode"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// unknownCountry is the country label of orders without a known country
+const unknownCountry = "unknown"
+
+// countryNames maps ISO 3166-1 alpha-2 codes to the names and aliases
+// accepted in addresses, in addition to the alpha-2 and alpha-3 codes
+var countryNames = map[string][]string{
+	"AR": {"argentina", "arg"},
+	"AT": {"austria", "österreich", "osterreich", "aut"},
+	"AU": {"australia", "aus"},
+	"BE": {"belgium", "belgique", "belgië", "belgie", "bel"},
+	"BR": {"brazil", "brasil", "bra"},
+	"CA": {"canada", "can"},
+	"CH": {"switzerland", "schweiz", "suisse", "svizzera", "che"},
+	"CL": {"chile", "chl"},
+	"CN": {"china", "chn"},
+	"CO": {"colombia", "col"},
+	"CZ": {"czechia", "czech republic", "cze"},
+	"DE": {"germany", "deutschland", "deu"},
+	"DK": {"denmark", "danmark", "dnk"},
+	"EG": {"egypt", "egy"},
+	"ES": {"spain", "españa", "espana", "esp"},
+	"FI": {"finland", "suomi", "fin"},
+	"FR": {"france", "fra"},
+	"GB": {"united kingdom", "uk", "great britain", "england", "scotland", "wales", "gbr"},
+	"GH": {"ghana", "gha"},
+	"GR": {"greece", "grc"},
+	"HU": {"hungary", "hun"},
+	"ID": {"indonesia", "idn"},
+	"IE": {"ireland", "irl"},
+	"IL": {"israel", "isr"},
+	"IN": {"india", "ind"},
+	"IT": {"italy", "italia", "ita"},
+	"JP": {"japan", "jpn"},
+	"KE": {"kenya", "ken"},
+	"KR": {"south korea", "korea", "republic of korea", "kor"},
+	"MX": {"mexico", "méxico", "mex"},
+	"MY": {"malaysia", "mys"},
+	"NG": {"nigeria", "nga"},
+	"NL": {"netherlands", "the netherlands", "holland", "nederland", "nld"},
+	"NO": {"norway", "norge", "nor"},
+	"NZ": {"new zealand", "nzl"},
+	"PH": {"philippines", "phl"},
+	"PL": {"poland", "polska", "pol"},
+	"PT": {"portugal", "prt"},
+	"RO": {"romania", "rou"},
+	"SE": {"sweden", "sverige", "swe"},
+	"SG": {"singapore", "sgp"},
+	"TH": {"thailand", "tha"},
+	"TR": {"turkey", "türkiye", "turkiye", "tur"},
+	"UA": {"ukraine", "ukr"},
+	"US": {"united states", "united states of america", "usa", "us", "america"},
+	"VN": {"vietnam", "viet nam", "vnm"},
+	"ZA": {"south africa", "zaf"},
+}
+
+var (
+	// countryLookup maps normalized names and codes to alpha-2 codes
+	countryLookup = map[string]string{}
+
+	// Counter: Revenue of created orders per country
+	ordersRevenueTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "orders_revenue_total",
+			Help: "Sum of the total amounts of created orders, by shipping country (ISO 3166-1 alpha-2)",
+		},
+		[]string{"country"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(ordersRevenueTotal)
+
+	for code, names := range countryNames {
+		countryLookup[strings.ToLower(code)] = code
+		for _, name := range names {
+			countryLookup[name] = code
+		}
+	}
+}
+
+// normalizeCountry lowercases a part of an address and drops postal codes
+// and punctuation
+func normalizeCountry(s string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(s) {
+		switch {
+		case unicode.IsLetter(r):
+			b.WriteRune(r)
+		case unicode.IsSpace(r):
+			b.WriteRune(' ')
+		}
+	}
+	return strings.Join(strings.Fields(b.String()), " ")
+}
+
+// countryFromAddress returns the ISO 3166-1 alpha-2 code of the country
+// an address ends with, or unknownCountry
+func countryFromAddress(address string) string {
+	parts := strings.Split(address, ",")
+	if code, ok := countryLookup[normalizeCountry(parts[len(parts)-1])]; ok {
+		return code
+	}
+	return unknownCountry
+}
+
+// recordOrderGeography counts a created order for its shipping country
+func recordOrderGeography(shippingAddress string, total float64) {
+	country := countryFromAddress(shippingAddress)
+	ordersCreatedTotal.WithLabelValues(country).Inc()
+	ordersRevenueTotal.WithLabelValues(country).Add(total)
+}
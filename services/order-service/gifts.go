@@ -0,0 +1,145 @@
+// =============================================================================
+// GIFT OPTIONS
+// =============================================================================
+// Orders can be sent as gifts. The "gift" object of a create order request
+// holds the options:
+//
+//   "gift": {"wrap": true, "message": "Happy birthday!", "hide_prices": true}
+//
+// - wrap         Gift wrap the order, adding GIFT_WRAP_FEE to the total
+//                (once per order, shown as wrap_fee on the order)
+// - message      Printed on a card, at most 300 characters
+// - hide_prices  Receipts list the items without prices or totals
+//
+// The options are stored with the order (orders.gift_* columns, or the
+// OrderCreated event in eventsourced mode) and returned as "gift" by
+// GET /api/v1/orders/:id. Orders with gift options also publish
+// order.gift.requested, carrying the options for the packing station.
+// Protobuf consumers of it get the generic OrderEvent (see eventformat.go).
+//
+// METRICS:
+// - gift_orders_total{option}       wrap, message, hide_prices
+// - gift_wrap_fees_total            Wrap fees charged
+// =============================================================================
+
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// giftMessageMaxLength is the longest gift message, in characters
+const giftMessageMaxLength = 300
+
+// GiftOptions are the gift options of an order
+type GiftOptions struct {
+	Wrap       bool    `json:"wrap"`
+	Message    string  `json:"message,omitempty"`
+	HidePrices bool    `json:"hide_prices"`
+	WrapFee    float64 `json:"wrap_fee,omitempty"`
+}
+
+var (
+	// giftWrapFee is charged for gift wrapping an order
+	giftWrapFee = 4.99
+
+	// Counter: Orders with gift options, by option
+	giftOrdersTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gift_orders_total",
+			Help: "Total number of orders created with gift options, by option (wrap, message, hide_prices)",
+		},
+		[]string{"option"},
+	)
+
+	// Counter: Gift wrap fees charged
+	giftWrapFeesTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "gift_wrap_fees_total",
+			Help: "Total gift wrap fees charged on created orders",
+		},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(giftOrdersTotal)
+	prometheus.MustRegister(giftWrapFeesTotal)
+}
+
+// setGiftWrapFee validates and applies GIFT_WRAP_FEE
+func setGiftWrapFee(fee float64) error {
+	if fee < 0 {
+		return fmt.Errorf("invalid GIFT_WRAP_FEE %v, must not be negative", fee)
+	}
+	giftWrapFee = fee
+	return nil
+}
+
+// normalizeGift prepares requested gift options for storage: options
+// that ask for nothing are dropped, and the wrap fee is the current one
+// whatever the client sent
+func normalizeGift(g *GiftOptions) *GiftOptions {
+	if g == nil {
+		return nil
+	}
+	gift := GiftOptions{Wrap: g.Wrap, Message: strings.TrimSpace(g.Message), HidePrices: g.HidePrices}
+	if !gift.Wrap && gift.Message == "" && !gift.HidePrices {
+		return nil
+	}
+	if gift.Wrap {
+		gift.WrapFee = giftWrapFee
+	}
+	return &gift
+}
+
+// giftColumns returns the values of the orders.gift_* columns
+func giftColumns(g *GiftOptions) (wrap bool, message string, hidePrices bool, fee float64) {
+	if g == nil {
+		return false, "", false, 0
+	}
+	return g.Wrap, g.Message, g.HidePrices, g.WrapFee
+}
+
+// giftFromColumns builds the gift options from the orders.gift_* columns,
+// nil for orders without any
+func giftFromColumns(wrap bool, message sql.NullString, hidePrices bool, fee float64) *GiftOptions {
+	if !wrap && message.String == "" && !hidePrices {
+		return nil
+	}
+	return &GiftOptions{Wrap: wrap, Message: message.String, HidePrices: hidePrices, WrapFee: fee}
+}
+
+// recordGift counts the gift options of a created order and tells the
+// packing station about them
+func (a *App) recordGift(orderID string, g *GiftOptions) {
+	if g == nil {
+		return
+	}
+	if g.Wrap {
+		giftOrdersTotal.WithLabelValues("wrap").Inc()
+		giftWrapFeesTotal.Add(g.WrapFee)
+	}
+	if g.Message != "" {
+		giftOrdersTotal.WithLabelValues("message").Inc()
+	}
+	if g.HidePrices {
+		giftOrdersTotal.WithLabelValues("hide_prices").Inc()
+	}
+
+	body, err := json.Marshal(struct {
+		Event     string       `json:"event"`
+		OrderID   string       `json:"order_id"`
+		Timestamp string       `json:"timestamp"`
+		Gift      *GiftOptions `json:"gift"`
+	}{"order.gift.requested", orderID, time.Now().Format(time.RFC3339), g})
+	if err != nil {
+		return
+	}
+	a.enqueueEvent(orderEvent{RoutingKey: "order.gift.requested", OrderID: orderID, Body: body, Tier: cachedOrderTier(orderID)})
+}
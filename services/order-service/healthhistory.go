@@ -0,0 +1,225 @@
+// =============================================================================
+// READINESS CHECK HISTORY
+// =============================================================================
+// A readiness probe only sees the moment it runs, so a dependency that
+// flaps between probes, or fails one probe in ten, is hard to spot. Every
+// /ready check of a dependency (database, redis, rabbitmq) is kept in a
+// ring buffer of the last HEALTH_HISTORY_SIZE results (default 100):
+//
+//   GET /health/history                       All dependencies
+//   GET /health/history?dependency=redis      One dependency
+//   GET /health/history?limit=20              The newest 20 results each
+//
+//   {"size": 100, "dependencies": {"redis": {
+//      "samples": 100, "failures": 7, "transitions": 12,
+//      "avg_latency_ms": 0.8, "max_latency_ms": 41.2,
+//      "last_change": "2025-03-14T09:26:53Z",
+//      "results": [{"time": "...", "healthy": false, "latency_ms": 2000.4,
+//                   "error": "context deadline exceeded"}, ...]}}}
+//
+// Results are newest first. transitions counts the changes between healthy
+// and unhealthy within the buffer: many transitions and few failures is
+// the signature of a flapping dependency. The history is per instance and
+// starts empty, with the first probe after startup.
+//
+// METRICS:
+// - readiness_check_duration_seconds{dependency}   Latency of each check
+// - readiness_check_transitions_total{dependency}  Changes between healthy
+//                                                  and unhealthy
+// =============================================================================
+
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// healthHistorySize is the number of results kept per dependency
+var healthHistorySize = 100
+
+var (
+	// Histogram: Latency of readiness checks
+	readinessCheckDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "readiness_check_duration_seconds",
+			Help:    "Latency of the readiness check of a dependency",
+			Buckets: []float64{0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5},
+		},
+		[]string{"dependency"},
+	)
+
+	// Counter: Changes of a dependency between healthy and unhealthy
+	readinessCheckTransitionsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "readiness_check_transitions_total",
+			Help: "Total number of changes of a dependency's readiness check between healthy and unhealthy",
+		},
+		[]string{"dependency"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(readinessCheckDuration)
+	prometheus.MustRegister(readinessCheckTransitionsTotal)
+}
+
+// healthResult is one readiness check of a dependency
+type healthResult struct {
+	Time      time.Time `json:"time"`
+	Healthy   bool      `json:"healthy"`
+	LatencyMS float64   `json:"latency_ms"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// healthRing holds the latest results of one dependency
+type healthRing struct {
+	results    []healthResult
+	next       int
+	full       bool
+	lastChange time.Time
+}
+
+var (
+	healthHistoryMu sync.Mutex
+	healthHistory   = map[string]*healthRing{}
+)
+
+// setHealthHistorySize validates and applies HEALTH_HISTORY_SIZE
+func setHealthHistorySize(size int) error {
+	if size <= 0 {
+		return fmt.Errorf("HEALTH_HISTORY_SIZE must be positive, got %d", size)
+	}
+	healthHistorySize = size
+	return nil
+}
+
+// recordHealth adds a readiness check result to the history of dependency
+func recordHealth(dependency string, start time.Time, err error) {
+	latency := time.Since(start)
+	readinessCheckDuration.WithLabelValues(dependency).Observe(latency.Seconds())
+
+	result := healthResult{
+		Time:      start.UTC(),
+		Healthy:   err == nil,
+		LatencyMS: math.Round(float64(latency.Microseconds())/100) / 10,
+	}
+	if err != nil {
+		result.Error = err.Error()
+	}
+
+	healthHistoryMu.Lock()
+	defer healthHistoryMu.Unlock()
+	ring := healthHistory[dependency]
+	if ring == nil {
+		ring = &healthRing{results: make([]healthResult, healthHistorySize)}
+		healthHistory[dependency] = ring
+	}
+	if prev, ok := ring.latest(); ok && prev.Healthy != result.Healthy {
+		ring.lastChange = result.Time
+		readinessCheckTransitionsTotal.WithLabelValues(dependency).Inc()
+	}
+	ring.results[ring.next] = result
+	ring.next = (ring.next + 1) % len(ring.results)
+	if ring.next == 0 {
+		ring.full = true
+	}
+}
+
+// latest returns the newest result of the ring
+func (r *healthRing) latest() (healthResult, bool) {
+	if !r.full && r.next == 0 {
+		return healthResult{}, false
+	}
+	return r.results[(r.next-1+len(r.results))%len(r.results)], true
+}
+
+// newestFirst returns the results of the ring, newest first
+func (r *healthRing) newestFirst() []healthResult {
+	n := r.next
+	if r.full {
+		n = len(r.results)
+	}
+	out := make([]healthResult, 0, n)
+	for i := 1; i <= n; i++ {
+		out = append(out, r.results[(r.next-i+len(r.results))%len(r.results)])
+	}
+	return out
+}
+
+// getHealthHistory serves GET /health/history
+func getHealthHistory(c *gin.Context) {
+	limit := healthHistorySize
+	if l := c.Query("limit"); l != "" {
+		n, err := strconv.Atoi(l)
+		if err != nil || n <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be a positive integer"})
+			return
+		}
+		limit = n
+	}
+	only := c.Query("dependency")
+
+	healthHistoryMu.Lock()
+	defer healthHistoryMu.Unlock()
+
+	if only != "" && healthHistory[only] == nil {
+		names := make([]string, 0, len(healthHistory))
+		for name := range healthHistory {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		c.JSON(http.StatusNotFound, gin.H{"error": "no history for dependency " + only, "dependencies": names})
+		return
+	}
+
+	dependencies := gin.H{}
+	for name, ring := range healthHistory {
+		if only != "" && name != only {
+			continue
+		}
+		results := ring.newestFirst()
+		failures, transitions := 0, 0
+		var total, max float64
+		for i, r := range results {
+			if !r.Healthy {
+				failures++
+			}
+			if i > 0 && r.Healthy != results[i-1].Healthy {
+				transitions++
+			}
+			total += r.LatencyMS
+			if r.LatencyMS > max {
+				max = r.LatencyMS
+			}
+		}
+		summary := gin.H{
+			"samples":        len(results),
+			"failures":       failures,
+			"transitions":    transitions,
+			"avg_latency_ms": math.Round(total/float64(len(results))*10) / 10,
+			"max_latency_ms": max,
+		}
+		if !ring.lastChange.IsZero() {
+			summary["last_change"] = ring.lastChange
+		}
+		if len(results) > limit {
+			results = results[:limit]
+		}
+		summary["results"] = results
+		dependencies[name] = summary
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"size":         healthHistorySize,
+		"dependencies": dependencies,
+	})
+}
@@ -0,0 +1,269 @@
+// =============================================================================
+// HTTP CLIENT RETRIES
+// =============================================================================
+// Calls to the other services (inventory, payment, user, notification) are
+// retried when they fail in a way a second attempt may fix:
+// - a transport error (connection refused or reset, timeout of the attempt)
+// - 429 Too Many Requests, 502 Bad Gateway, 503 Service Unavailable or
+//   504 Gateway Timeout
+//
+// Other statuses are answers and are returned as they are. Calls rejected
+// by an open circuit breaker (see breaker.go) or cancelled by the caller
+// are not retried.
+//
+// Only idempotent methods (GET, HEAD, OPTIONS, PUT, DELETE) and requests
+// carrying an Idempotency-Key are retried, as a POST that timed out may
+// have been applied. HTTP_RETRY_IDEMPOTENT_ONLY=false retries every
+// method. Requests whose body can't be replayed are never retried.
+//
+// Attempts are spaced with exponential backoff and full jitter: before
+// retry n, a random delay in [0, HTTP_RETRY_BASE_DELAY * 2^(n-1)], capped
+// at HTTP_RETRY_MAX_DELAY. A Retry-After within HTTP_RETRY_MAX_DELAY is
+// waited instead. HTTP_RETRY_MAX_ATTEMPTS counts the first attempt (1
+// disables retries). HTTP_CLIENT_TIMEOUT still bounds the whole call.
+//
+// RETRY BUDGET:
+// Retries multiply the load on a service that is already struggling. Per
+// service, retries are allowed up to HTTP_RETRY_BUDGET of the calls of the
+// last retryBudgetWindow, plus retryBudgetMinimum so a quiet service can
+// still be retried. Once the budget is used up, failures are returned
+// straight away.
+//
+// METRICS:
+// - http_client_retries_total{dependency,reason}        reason: error, 429,
+//                                                       502, 503, 504
+// - http_client_retry_budget_exhausted_total{dependency}
+// =============================================================================
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// retryBudgetWindow is the window the retry budget is counted over
+	retryBudgetWindow = 10 * time.Second
+
+	// retryBudgetMinimum is the retries allowed per window regardless of
+	// the number of calls
+	retryBudgetMinimum = 10
+)
+
+// retrySettings are the retry policy of inter-service calls
+type retrySettings struct {
+	MaxAttempts    int
+	BaseDelay      time.Duration
+	MaxDelay       time.Duration
+	Budget         float64
+	IdempotentOnly bool
+}
+
+var (
+	// httpRetry is the retry policy of inter-service calls
+	httpRetry = retrySettings{
+		MaxAttempts:    3,
+		BaseDelay:      100 * time.Millisecond,
+		MaxDelay:       2 * time.Second,
+		Budget:         0.2,
+		IdempotentOnly: true,
+	}
+
+	// retryBudgets are the retry budgets by service
+	retryBudgets   = map[string]*retryBudget{}
+	retryBudgetsMu sync.Mutex
+
+	// Counter: Retried inter-service calls
+	httpClientRetriesTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "http_client_retries_total",
+			Help: "Total number of retried inter-service HTTP calls, by dependency and reason",
+		},
+		[]string{"dependency", "reason"},
+	)
+
+	// Counter: Retries skipped because the budget was used up
+	httpClientRetryBudgetExhaustedTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "http_client_retry_budget_exhausted_total",
+			Help: "Total number of failed inter-service HTTP calls not retried because the retry budget was used up",
+		},
+		[]string{"dependency"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(httpClientRetriesTotal)
+	prometheus.MustRegister(httpClientRetryBudgetExhaustedTotal)
+}
+
+// setHTTPRetry validates and applies the retry policy
+func setHTTPRetry(settings retrySettings) error {
+	switch {
+	case settings.MaxAttempts < 1:
+		return fmt.Errorf("HTTP_RETRY_MAX_ATTEMPTS must be at least 1, got %d", settings.MaxAttempts)
+	case settings.BaseDelay <= 0 || settings.MaxDelay < settings.BaseDelay:
+		return fmt.Errorf("HTTP_RETRY_BASE_DELAY must be positive and at most HTTP_RETRY_MAX_DELAY")
+	case settings.Budget < 0:
+		return fmt.Errorf("HTTP_RETRY_BUDGET must not be negative, got %g", settings.Budget)
+	}
+	httpRetry = settings
+	return nil
+}
+
+// retryBudget counts the calls and retries of a service in the current
+// window
+type retryBudget struct {
+	mu      sync.Mutex
+	start   time.Time
+	calls   int
+	retries int
+}
+
+// budgetFor returns the retry budget of a service
+func budgetFor(service string) *retryBudget {
+	retryBudgetsMu.Lock()
+	defer retryBudgetsMu.Unlock()
+	b, ok := retryBudgets[service]
+	if !ok {
+		b = &retryBudget{start: time.Now()}
+		retryBudgets[service] = b
+	}
+	return b
+}
+
+// record counts a call, or with retry set a retry, and reports whether
+// the retry is within the budget
+func (b *retryBudget) record(retry bool) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if now := time.Now(); now.Sub(b.start) >= retryBudgetWindow {
+		b.start, b.calls, b.retries = now, 0, 0
+	}
+	if !retry {
+		b.calls++
+		return true
+	}
+	if float64(b.retries) >= float64(retryBudgetMinimum)+httpRetry.Budget*float64(b.calls) {
+		return false
+	}
+	b.retries++
+	return true
+}
+
+// retryTransport retries failed inter-service calls
+type retryTransport struct {
+	app  *App
+	next http.RoundTripper
+}
+
+func (t retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	service := t.app.serviceForHost(req.URL.Host)
+	if service == "" || httpRetry.MaxAttempts == 1 || !retryable(req) {
+		return t.next.RoundTrip(req)
+	}
+	budget := budgetFor(service)
+	budget.record(false)
+
+	for attempt := 1; ; attempt++ {
+		if attempt > 1 && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req = req.Clone(req.Context())
+			req.Body = body
+		}
+
+		resp, err := t.next.RoundTrip(req)
+		reason := retryReason(resp, err)
+		if reason == "" || attempt >= httpRetry.MaxAttempts || req.Context().Err() != nil {
+			return resp, err
+		}
+		if !budget.record(true) {
+			httpClientRetryBudgetExhaustedTotal.WithLabelValues(service).Inc()
+			return resp, err
+		}
+
+		delay := retryBackoff(attempt, resp)
+		if resp != nil {
+			// Drain the body so the connection can be reused
+			io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+			resp.Body.Close()
+		}
+		httpClientRetriesTotal.WithLabelValues(service, reason).Inc()
+		logDebugContext(req.Context(), "Retrying inter-service call", map[string]interface{}{
+			"dependency": service,
+			"method":     req.Method,
+			"attempt":    attempt + 1,
+			"reason":     reason,
+			"delay_ms":   delay.Milliseconds(),
+		})
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// retryable reports whether the policy allows retrying a request
+func retryable(req *http.Request) bool {
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		return false
+	}
+	if !httpRetry.IdempotentOnly || req.Header.Get("Idempotency-Key") != "" {
+		return true
+	}
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// retryReason returns why an attempt should be retried, or "" if it
+// shouldn't
+func retryReason(resp *http.Response, err error) string {
+	if err != nil {
+		if errors.Is(err, errCircuitOpen) {
+			return ""
+		}
+		return "error"
+	}
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return strconv.Itoa(resp.StatusCode)
+	}
+	return ""
+}
+
+// retryBackoff returns the wait before the retry following attempt
+func retryBackoff(attempt int, resp *http.Response) time.Duration {
+	if resp != nil {
+		if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && seconds >= 0 {
+			if after := time.Duration(seconds) * time.Second; after <= httpRetry.MaxDelay {
+				return after
+			}
+		}
+	}
+	backoff := httpRetry.MaxDelay
+	if shift := attempt - 1; shift < 30 {
+		if d := httpRetry.BaseDelay << shift; d < backoff {
+			backoff = d
+		}
+	}
+	return time.Duration(rand.Int63n(int64(backoff) + 1))
+}
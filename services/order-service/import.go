@@ -0,0 +1,442 @@
+// =============================================================================
+// BULK ORDER IMPORT
+// =============================================================================
+// POST /api/v1/orders/import loads existing orders into the lab quickly,
+// with the storage backend's bulk insert (PostgreSQL COPY, multi-row
+// INSERTs on MySQL) instead of one INSERT per row.
+//
+// FORMATS (chosen by ?format= or the Content-Type):
+// - csv     text/csv, header row required, one order per row
+// - ndjson  application/x-ndjson, one order per line, may include "items"
+//
+// Fields: id, customer_id, customer_name, customer_email, status,
+// total_amount, currency, shipping_address, notes, created_at, items.
+// Only customer_id, customer_name and customer_email are required.
+//
+// HOW IT WORKS:
+// - Records are validated as they are read; invalid ones are skipped and
+//   reported with their line number
+// - Valid records are copied in batches (?batch_size=, default
+//   IMPORT_BATCH_SIZE), each batch in its own transaction
+// - A failed batch is reported and the import moves on to the next one
+// - The response is a report of every batch plus overall totals
+//
+// Imported orders don't publish order events: they are history, not new
+// orders. updated_at is set to the import time so the stats rollups pick
+// them up on their next refresh.
+// =============================================================================
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// maxImportErrors caps the number of per-record errors in the report
+const maxImportErrors = 100
+
+// maxImportLineBytes is the longest NDJSON line accepted
+const maxImportLineBytes = 1 << 20
+
+// uuidPattern matches the textual form of a UUID
+var uuidPattern = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+// importBatchSize is the default number of orders copied per transaction
+var importBatchSize = 1000
+
+// Counter: Orders loaded through the bulk import
+var ordersImportedTotal = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "orders_imported_total",
+		Help: "Total number of orders processed by the bulk import",
+	},
+	[]string{"result"},
+)
+
+func init() {
+	prometheus.MustRegister(ordersImportedTotal)
+}
+
+// importRecord is one order read from the import body
+type importRecord struct {
+	ID              string             `json:"id"`
+	CustomerID      string             `json:"customer_id"`
+	CustomerName    string             `json:"customer_name"`
+	CustomerEmail   string             `json:"customer_email"`
+	Status          string             `json:"status"`
+	TotalAmount     *float64           `json:"total_amount"`
+	Currency        string             `json:"currency"`
+	ShippingAddress string             `json:"shipping_address"`
+	Notes           string             `json:"notes"`
+	CreatedAt       *time.Time         `json:"created_at"`
+	Items           []OrderItemRequest `json:"items"`
+
+	line int
+}
+
+// ImportError describes a record that could not be imported
+type ImportError struct {
+	Line  int    `json:"line,omitempty"`
+	Batch int    `json:"batch,omitempty"`
+	Error string `json:"error"`
+}
+
+// ImportBatch reports the outcome of one COPY batch
+type ImportBatch struct {
+	Batch      int    `json:"batch"`
+	Orders     int    `json:"orders"`
+	Items      int    `json:"items"`
+	DurationMs int64  `json:"duration_ms"`
+	Error      string `json:"error,omitempty"`
+}
+
+// ImportReport is the response of an import
+type ImportReport struct {
+	Format     string        `json:"format"`
+	Read       int           `json:"read"`
+	Imported   int           `json:"imported"`
+	Invalid    int           `json:"invalid"`
+	Failed     int           `json:"failed"`
+	Batches    []ImportBatch `json:"batches"`
+	Errors     []ImportError `json:"errors,omitempty"`
+	DurationMs int64         `json:"duration_ms"`
+}
+
+// addError records an error, keeping at most maxImportErrors of them
+func (r *ImportReport) addError(e ImportError) {
+	if len(r.Errors) < maxImportErrors {
+		r.Errors = append(r.Errors, e)
+	}
+}
+
+// importOrders bulk-loads orders from a CSV or NDJSON body
+func (a *App) importOrders(c *gin.Context) {
+	start := time.Now()
+
+	format := c.Query("format")
+	if format == "" {
+		format = importFormat(c.ContentType())
+	}
+	if format != "csv" && format != "ndjson" {
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{
+			"error": tr(c, "Unsupported import format, use text/csv or application/x-ndjson"),
+		})
+		return
+	}
+
+	batchSize := importBatchSize
+	if bs, err := strconv.Atoi(c.Query("batch_size")); err == nil && bs > 0 && bs <= 50000 {
+		batchSize = bs
+	}
+
+	logInfoContext(c.Request.Context(), "Starting order import", map[string]interface{}{
+		"format":     format,
+		"batch_size": batchSize,
+	})
+
+	report := &ImportReport{Format: format, Batches: []ImportBatch{}}
+	batch := make([]importRecord, 0, batchSize)
+
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		result := a.copyOrderBatch(c.Request.Context(), len(report.Batches)+1, batch)
+		report.Batches = append(report.Batches, result)
+		if result.Error != "" {
+			report.Failed += len(batch)
+			report.addError(ImportError{Batch: result.Batch, Error: result.Error})
+		} else {
+			report.Imported += result.Orders
+		}
+		batch = batch[:0]
+	}
+
+	next := readNDJSONRecords
+	if format == "csv" {
+		next = readCSVRecords
+	}
+	err := next(c.Request.Body, func(rec importRecord, recErr error) {
+		report.Read++
+		if recErr == nil {
+			recErr = rec.normalize()
+		}
+		if recErr != nil {
+			report.Invalid++
+			report.addError(ImportError{Line: rec.line, Error: recErr.Error()})
+			return
+		}
+		batch = append(batch, rec)
+		if len(batch) >= batchSize {
+			flush()
+		}
+	})
+	flush()
+
+	ordersImportedTotal.WithLabelValues("imported").Add(float64(report.Imported))
+	ordersImportedTotal.WithLabelValues("invalid").Add(float64(report.Invalid))
+	ordersImportedTotal.WithLabelValues("failed").Add(float64(report.Failed))
+	report.DurationMs = time.Since(start).Milliseconds()
+
+	fields := map[string]interface{}{
+		"format":      format,
+		"read":        report.Read,
+		"imported":    report.Imported,
+		"invalid":     report.Invalid,
+		"failed":      report.Failed,
+		"batches":     len(report.Batches),
+		"duration_ms": report.DurationMs,
+	}
+	if err != nil {
+		// The body could not be read to the end; report what was imported
+		fields["error"] = err.Error()
+		logErrorContext(c.Request.Context(), "Order import aborted", fields)
+		report.addError(ImportError{Error: err.Error()})
+		c.JSON(http.StatusBadRequest, report)
+		return
+	}
+	logInfoContext(c.Request.Context(), "Order import completed", fields)
+
+	status := http.StatusOK
+	if report.Imported == 0 && report.Read > 0 {
+		status = http.StatusUnprocessableEntity
+	}
+	c.JSON(status, report)
+}
+
+// importFormat maps a Content-Type to an import format
+func importFormat(contentType string) string {
+	switch contentType {
+	case "text/csv", "application/csv":
+		return "csv"
+	case "application/x-ndjson", "application/ndjson", "application/jsonl":
+		return "ndjson"
+	}
+	return ""
+}
+
+// readNDJSONRecords decodes one order per line, skipping blank lines
+func readNDJSONRecords(r io.Reader, fn func(importRecord, error)) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxImportLineBytes)
+
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+
+		var rec importRecord
+		err := json.Unmarshal([]byte(text), &rec)
+		rec.line = line
+		fn(rec, err)
+	}
+	return scanner.Err()
+}
+
+// readCSVRecords reads one order per row, mapping columns by header name
+func readCSVRecords(r io.Reader, fn func(importRecord, error)) error {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return fmt.Errorf("failed to read CSV header: %w", err)
+	}
+	columns := map[string]int{}
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+
+	for {
+		row, err := reader.Read()
+		if err == io.EOF {
+			return nil
+		}
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			fn(importRecord{line: parseErr.Line}, err)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		line, _ := reader.FieldPos(0)
+
+		get := func(name string) string {
+			if i, ok := columns[name]; ok && i < len(row) {
+				return strings.TrimSpace(row[i])
+			}
+			return ""
+		}
+
+		rec := importRecord{
+			ID:              get("id"),
+			CustomerID:      get("customer_id"),
+			CustomerName:    get("customer_name"),
+			CustomerEmail:   get("customer_email"),
+			Status:          get("status"),
+			Currency:        get("currency"),
+			ShippingAddress: get("shipping_address"),
+			Notes:           get("notes"),
+			line:            line,
+		}
+		if v := get("total_amount"); v != "" {
+			amount, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				fn(rec, fmt.Errorf("invalid total_amount %q", v))
+				continue
+			}
+			rec.TotalAmount = &amount
+		}
+		if v := get("created_at"); v != "" {
+			createdAt, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				fn(rec, fmt.Errorf("invalid created_at %q (expected RFC 3339)", v))
+				continue
+			}
+			rec.CreatedAt = &createdAt
+		}
+		fn(rec, nil)
+	}
+}
+
+// normalize validates a record and fills in defaults
+func (r *importRecord) normalize() error {
+	// Ids are generated here rather than by the column default because
+	// the order items reference them in the same bulk insert
+	if r.ID == "" {
+		r.ID = uuid.NewString()
+	} else if !uuidPattern.MatchString(r.ID) {
+		return fmt.Errorf("invalid id %q", r.ID)
+	}
+	if !uuidPattern.MatchString(r.CustomerID) {
+		return fmt.Errorf("invalid customer_id %q", r.CustomerID)
+	}
+	if r.CustomerName == "" || r.CustomerEmail == "" {
+		return errors.New("customer_name and customer_email are required")
+	}
+
+	if r.Status == "" {
+		r.Status = "pending"
+	}
+	if !validOrderStatuses[r.Status] {
+		return fmt.Errorf("invalid status %q", r.Status)
+	}
+	r.Currency = strings.ToUpper(r.Currency)
+	if r.Currency == "" {
+		r.Currency = defaultCurrency
+	}
+	if len(r.Currency) != 3 {
+		return fmt.Errorf("invalid currency %q", r.Currency)
+	}
+	if r.CreatedAt == nil {
+		now := time.Now().UTC()
+		r.CreatedAt = &now
+	}
+
+	var itemsTotal float64
+	for _, item := range r.Items {
+		if item.SKU == "" || item.Name == "" || item.Quantity < 1 || item.UnitPrice < 0 {
+			return errors.New("items need a sku, name, quantity >= 1 and unit_price >= 0")
+		}
+		itemsTotal += lineTotal(item.Quantity, item.UnitPrice, r.Currency)
+	}
+	if r.TotalAmount == nil {
+		r.TotalAmount = &itemsTotal
+	}
+	*r.TotalAmount = roundMoney(*r.TotalAmount, r.Currency)
+	return nil
+}
+
+// copyOrderBatch copies a batch of orders and their items in one transaction
+func (a *App) copyOrderBatch(ctx context.Context, number int, batch []importRecord) ImportBatch {
+	start := time.Now()
+	result := ImportBatch{Batch: number}
+
+	items, err := a.copyOrders(ctx, batch)
+	result.DurationMs = time.Since(start).Milliseconds()
+	if err != nil {
+		result.Error = err.Error()
+		logWarnContext(ctx, "Order import batch failed", map[string]interface{}{
+			"batch":  number,
+			"orders": len(batch),
+			"error":  err.Error(),
+		})
+		return result
+	}
+
+	result.Orders = len(batch)
+	result.Items = items
+	logInfoContext(ctx, "Order import batch copied", map[string]interface{}{
+		"batch":       number,
+		"orders":      result.Orders,
+		"items":       result.Items,
+		"duration_ms": result.DurationMs,
+	})
+	return result
+}
+
+// copyOrders runs the COPY statements for a batch and returns the number
+// of items copied
+func (a *App) copyOrders(ctx context.Context, batch []importRecord) (int, error) {
+	tx, err := a.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC()
+	orders := make([][]interface{}, 0, len(batch))
+	var items [][]interface{}
+	for _, r := range batch {
+		orders = append(orders, []interface{}{
+			r.ID, r.CustomerID, r.CustomerName, r.CustomerEmail, r.Status,
+			*r.TotalAmount, r.Currency, r.ShippingAddress, r.Notes, *r.CreatedAt, now,
+		})
+		for _, item := range r.Items {
+			items = append(items, []interface{}{
+				r.ID, item.SKU, item.Name, item.Quantity, item.UnitPrice,
+				lineTotal(item.Quantity, item.UnitPrice, r.Currency), *r.CreatedAt,
+			})
+		}
+	}
+
+	err = storage.CopyRows(ctx, tx, "orders", []string{
+		"id", "customer_id", "customer_name", "customer_email", "status",
+		"total_amount", "currency", "shipping_address", "notes", "created_at", "updated_at",
+	}, orders)
+	if err != nil {
+		return 0, fmt.Errorf("failed to copy orders: %w", err)
+	}
+
+	err = storage.CopyRows(ctx, tx, "order_items", []string{
+		"order_id", "sku", "name", "quantity", "unit_price", "total_price", "created_at",
+	}, items)
+	if err != nil {
+		return 0, fmt.Errorf("failed to copy order items: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return len(items), nil
+}
@@ -0,0 +1,158 @@
+// =============================================================================
+// INTERNAL LISTENER
+// =============================================================================
+// Operational endpoints are served on a dedicated internal port, separate
+// from the public API port, so an ingress routing the public port can never
+// expose them by accident.
+//
+// INTERNAL PORT (INTERNAL_PORT, default 9001):
+// - /health, /ready    Health checks
+// - /health/topology   Probe of every dependency (see topology.go)
+// - /health/history    Recent readiness checks (see healthhistory.go)
+// - /startup, /live    Kubernetes startup and liveness probes
+// - /quitquitquit      Pre-stop drain hook (POST, token protected)
+// - /metrics           Prometheus metrics
+// - /debug/pprof/*     Go profiling (token protected)
+// - /admin/*           Admin API (token protected)
+//
+// The internal port is published by docker-compose, so everything that
+// changes the instance or exposes its internals (profiles, command line)
+// needs the admin token; only the read-only probes and metrics don't.
+//
+// Setting INTERNAL_PORT to the same value as PORT serves everything on a
+// single listener, which is handy for local development.
+// =============================================================================
+
+package main
+
+import (
+	"net/http/pprof"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// newRouter creates a Gin engine with the shared middleware stack. The
+// client IP only comes from X-Forwarded-For when a trusted proxy sent it
+// (RATE_LIMIT_TRUSTED_PROXIES, see ratelimit.go); gin trusts every peer
+// otherwise.
+func (a *App) newRouter() *gin.Engine {
+	router := gin.New()
+	if err := router.SetTrustedProxies(trustedProxyCIDRs); err != nil {
+		logError("Failed to set trusted proxies", map[string]interface{}{"error": err.Error()})
+	}
+	router.Use(tracingMiddleware())
+	router.Use(a.recoveryMiddleware())
+	router.Use(loggingMiddleware())
+	router.Use(metricsMiddleware())
+	router.Use(cancellationMiddleware())
+	router.Use(concurrencyMiddleware())
+	return router
+}
+
+// registerInternalRoutes adds health, metrics, pprof and admin routes
+func (a *App) registerInternalRoutes(router *gin.Engine) {
+	// Health check endpoints (available while dependencies are connecting)
+	router.GET("/health", healthCheck)
+	router.GET("/ready", a.readinessCheck)
+	router.GET("/health/topology", a.topologyHealth) // Dependency map (see topology.go)
+	router.GET("/health/history", getHealthHistory)  // Readiness check history (see healthhistory.go)
+
+	// Kubernetes lifecycle endpoints (see lifecycle.go)
+	router.GET("/startup", startupProbe)
+	router.GET("/live", livenessProbe)
+	router.POST("/quitquitquit", adminAuthMiddleware(), quitQuitQuit)
+
+	// Prometheus metrics endpoint, in OpenMetrics format when the scraper
+	// accepts it so latency histograms carry trace exemplars (see tracing.go)
+	router.GET("/metrics", gin.WrapH(promhttp.InstrumentMetricHandler(
+		prometheus.DefaultRegisterer,
+		promhttp.HandlerFor(prometheus.DefaultGatherer, promhttp.HandlerOpts{EnableOpenMetrics: true}),
+	)))
+
+	// Go profiling endpoints (token protected)
+	debug := router.Group("/debug/pprof")
+	debug.Use(adminAuthMiddleware())
+	{
+		debug.GET("/", gin.WrapF(pprof.Index))
+		debug.GET("/cmdline", gin.WrapF(pprof.Cmdline))
+		debug.GET("/profile", gin.WrapF(pprof.Profile))
+		debug.GET("/symbol", gin.WrapF(pprof.Symbol))
+		debug.POST("/symbol", gin.WrapF(pprof.Symbol))
+		debug.GET("/trace", gin.WrapF(pprof.Trace))
+		debug.GET("/:profile", gin.WrapF(pprof.Index)) // heap, goroutine, block, ...
+	}
+
+	// Admin endpoints (token protected)
+	admin := router.Group("/admin")
+	admin.Use(adminAuthMiddleware())
+	admin.Use(startupGateMiddleware())
+	{
+		admin.GET("/maintenance", getMaintenance)                            // GET /admin/maintenance
+		admin.PUT("/maintenance", setMaintenance)                            // PUT /admin/maintenance
+		admin.GET("/pool", a.getPoolStats)                                   // GET /admin/pool
+		admin.POST("/cache/flush", a.flushCache)                             // POST /admin/cache/flush
+		admin.GET("/queues", a.getQueueDepths)                               // GET /admin/queues
+		admin.GET("/dead-letters", a.getDeadLetters)                         // GET /admin/dead-letters
+		admin.GET("/circuit-breakers", getCircuitBreakers)                   // GET /admin/circuit-breakers
+		admin.GET("/rate-limits", getRateLimits)                             // GET /admin/rate-limits
+		admin.POST("/drain", drain)                                          // POST /admin/drain
+		admin.POST("/undrain", undrain)                                      // POST /admin/undrain
+		admin.POST("/migrations", a.rerunMigrations)                         // POST /admin/migrations
+		admin.GET("/config", getConfig)                                      // GET /admin/config
+		admin.GET("/selftest", a.latencySelfTest)                            // GET /admin/selftest
+		admin.GET("/chaos", listChaosRules)                                  // GET /admin/chaos
+		admin.POST("/chaos", addChaosRule)                                   // POST /admin/chaos
+		admin.DELETE("/chaos", clearChaosRules)                              // DELETE /admin/chaos
+		admin.DELETE("/chaos/:id", deleteChaosRule)                          // DELETE /admin/chaos/:id
+		admin.GET("/synthetic-errors", getSyntheticErrors)                   // GET /admin/synthetic-errors
+		admin.PUT("/synthetic-errors", setSyntheticErrors)                   // PUT /admin/synthetic-errors
+		admin.GET("/latency-profiles", listLatencyProfiles)                  // GET /admin/latency-profiles
+		admin.PUT("/latency-profiles", setLatencyProfiles)                   // PUT /admin/latency-profiles
+		admin.DELETE("/latency-profiles", clearLatencyProfiles)              // DELETE /admin/latency-profiles
+		admin.GET("/payload-logging", getPayloadLogging)                     // GET /admin/payload-logging
+		admin.PUT("/payload-logging", setPayloadLogging)                     // PUT /admin/payload-logging
+		admin.GET("/log-level", getLogLevel)                                 // GET /admin/log-level
+		admin.PUT("/log-level", setLogLevels)                                // PUT /admin/log-level
+		admin.GET("/heartbeat", getDeadmanSwitch)                            // GET /admin/heartbeat
+		admin.PUT("/heartbeat", setDeadmanSwitch)                            // PUT /admin/heartbeat
+		admin.GET("/deprecations", listDeprecations)                         // GET /admin/deprecations
+		admin.POST("/seed", a.seedDemoDataHandler)                           // POST /admin/seed
+		admin.GET("/scenarios", listScenarios)                               // GET /admin/scenarios
+		admin.POST("/scenarios/:name/start", startScenario)                  // POST /admin/scenarios/:name/start
+		admin.POST("/scenarios/stop", stopScenario)                          // POST /admin/scenarios/stop
+		admin.GET("/outages", listOutages)                                   // GET /admin/outages
+		admin.PUT("/outages/:dependency", setOutage)                         // PUT /admin/outages/:dependency
+		admin.DELETE("/outages", clearOutages)                               // DELETE /admin/outages
+		admin.GET("/slow-dependencies", listSlowDependencies)                // GET /admin/slow-dependencies
+		admin.PUT("/slow-dependencies/:dependency", setSlowDependency)       // PUT /admin/slow-dependencies/:dependency
+		admin.DELETE("/slow-dependencies/:dependency", deleteSlowDependency) // DELETE /admin/slow-dependencies/:dependency
+		admin.DELETE("/slow-dependencies", clearSlowDependencies)            // DELETE /admin/slow-dependencies
+		admin.GET("/recording", getRecording)                                // GET /admin/recording
+		admin.PUT("/recording", setRecording)                                // PUT /admin/recording
+		admin.POST("/reset", a.resetDemoEnvironment)                         // POST /admin/reset
+		admin.GET("/stress", listStress)                                     // GET /admin/stress
+		admin.POST("/stress/cpu", startCPUStress)                            // POST /admin/stress/cpu
+		admin.POST("/stress/memory", startMemoryStress)                      // POST /admin/stress/memory
+		admin.DELETE("/stress", stopStress)                                  // DELETE /admin/stress
+		admin.GET("/jobs", listJobs)                                         // GET /admin/jobs
+		admin.POST("/jobs/:name/run", triggerJob)                            // POST /admin/jobs/:name/run
+		admin.GET("/receipts/:id/preview", a.previewReceipt)                 // GET /admin/receipts/:id/preview
+		admin.GET("/reconciliation", a.getReconciliation)                    // GET /admin/reconciliation
+		admin.GET("/deliveries", a.listDeliveries)                           // GET /admin/deliveries
+		admin.POST("/deliveries/:id/retry", a.retryDelivery)                 // POST /admin/deliveries/:id/retry
+		admin.GET("/archives", a.listArchives)                               // GET /admin/archives
+		admin.POST("/archives", a.createArchive)                             // POST /admin/archives
+		admin.POST("/projections/rebuild", a.rebuildProjectionsHandler)      // POST /admin/projections/rebuild
+		admin.GET("/reviews", a.listReviews)                                 // GET /admin/reviews
+		admin.POST("/reviews/:order_id/resolve", a.resolveReview)            // POST /admin/reviews/:order_id/resolve
+		admin.POST("/orders/:id/cancel", a.adminCancelOrder)                 // POST /admin/orders/:id/cancel
+		admin.POST("/orders/recalculate", a.recalculatePrices)               // POST /admin/orders/recalculate
+		admin.GET("/outbox", a.listOutbox)                                   // GET /admin/outbox
+		admin.GET("/outbox/:id", a.getOutboxEvent)                           // GET /admin/outbox/:id
+		admin.POST("/outbox/:id/retry", a.retryOutboxEvent)                  // POST /admin/outbox/:id/retry
+		admin.POST("/outbox/:id/discard", a.discardOutboxEvent)              // POST /admin/outbox/:id/discard
+		admin.POST("/events/replay", a.replayEvents)                         // POST /admin/events/replay
+	}
+}
@@ -0,0 +1,324 @@
+// =============================================================================
+// SCHEDULED JOBS
+// =============================================================================
+// An in-process cron scheduler for periodic maintenance work (rollups,
+// expiry, archival, ...). Features register a job with registerJob before
+// startScheduler is called:
+//
+//   registerJob("stats-rollup", "@every 1m", "Refresh order stats rollups",
+//       time.Minute, refreshStatsRollups)
+//
+// Schedules use cron syntax or descriptors (see cronspec.go), in UTC.
+//
+// GUARANTEES:
+// - A job never overlaps with itself: if the previous run is still going
+//   when the next one is due, the new run is skipped (and counted)
+// - Every run gets a context with the job's timeout, cancelled on shutdown
+// - Shutdown waits for running jobs in the jobs phase (see shutdown.go)
+//
+// Every instance runs every job, so jobs must be safe to run concurrently
+// on several instances (e.g. lock rows or use FOR UPDATE SKIP LOCKED).
+//
+// ENDPOINTS:
+// - GET  /admin/jobs            List jobs, schedules and last results
+// - POST /admin/jobs/:name/run  Run a job now
+// =============================================================================
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// jobsLog is the logger of the job scheduler
+var jobsLog = newLogger("jobs")
+
+// scheduledJob is a registered periodic job
+type scheduledJob struct {
+	name        string
+	spec        string
+	description string
+	timeout     time.Duration
+	fn          func(ctx context.Context) error
+	schedule    schedule
+
+	running atomic.Bool
+
+	mu          sync.Mutex
+	nextRun     time.Time
+	lastRun     time.Time
+	lastSuccess time.Time
+	lastError   string
+	lastTook    time.Duration
+	runs        int64
+	failures    int64
+}
+
+// JobStatus is the JSON view of a scheduled job
+type JobStatus struct {
+	Name        string     `json:"name"`
+	Schedule    string     `json:"schedule"`
+	Description string     `json:"description"`
+	Running     bool       `json:"running"`
+	NextRun     time.Time  `json:"next_run"`
+	LastRun     *time.Time `json:"last_run,omitempty"`
+	LastSuccess *time.Time `json:"last_success,omitempty"`
+	LastError   string     `json:"last_error,omitempty"`
+	LastTookMs  int64      `json:"last_took_ms"`
+	Runs        int64      `json:"runs"`
+	Failures    int64      `json:"failures"`
+}
+
+var (
+	// jobsMu guards jobs
+	jobsMu sync.Mutex
+
+	// jobs are the registered jobs by name
+	jobs = map[string]*scheduledJob{}
+
+	// jobsWG tracks running jobs for shutdown
+	jobsWG sync.WaitGroup
+
+	// Counter: Job runs, by job and result
+	cronJobRunsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "cron_job_runs_total",
+			Help: "Total number of scheduled job runs, by result (success, failure, skipped)",
+		},
+		[]string{"job", "result"},
+	)
+
+	// Histogram: Job run duration
+	cronJobDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "cron_job_duration_seconds",
+			Help:    "Duration of scheduled job runs",
+			Buckets: []float64{.01, .05, .1, .5, 1, 5, 10, 30, 60, 300, 900},
+		},
+		[]string{"job"},
+	)
+
+	// Gauge: Last successful run of each job
+	cronJobLastSuccess = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "cron_job_last_success_timestamp_seconds",
+			Help: "Unix time of the last successful run of a scheduled job",
+		},
+		[]string{"job"},
+	)
+
+	// Gauge: Whether each job is running
+	cronJobRunning = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "cron_job_running",
+			Help: "Whether a scheduled job is running (1) or not (0)",
+		},
+		[]string{"job"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(cronJobRunsTotal)
+	prometheus.MustRegister(cronJobDuration)
+	prometheus.MustRegister(cronJobLastSuccess)
+	prometheus.MustRegister(cronJobRunning)
+}
+
+// registerJob adds a job to the scheduler. It fails on an invalid
+// schedule or a duplicate name.
+func registerJob(name, spec, description string, timeout time.Duration, fn func(ctx context.Context) error) error {
+	sched, err := parseSchedule(spec)
+	if err != nil {
+		return fmt.Errorf("job %s: %w", name, err)
+	}
+	next := sched.next(time.Now())
+	if next.IsZero() {
+		return fmt.Errorf("job %s: schedule %q never fires", name, spec)
+	}
+
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+
+	if _, exists := jobs[name]; exists {
+		return fmt.Errorf("job %s is already registered", name)
+	}
+	jobs[name] = &scheduledJob{
+		name:        name,
+		spec:        spec,
+		description: description,
+		timeout:     timeout,
+		fn:          fn,
+		schedule:    sched,
+		nextRun:     next,
+	}
+	cronJobRunning.WithLabelValues(name).Set(0)
+	return nil
+}
+
+// startScheduler runs due jobs until shutdown and registers the shutdown
+// hook that waits for running jobs
+func startScheduler() {
+	go func() {
+		for {
+			wait := time.Minute
+			now := time.Now()
+
+			jobsMu.Lock()
+			for _, job := range jobs {
+				job.mu.Lock()
+				if !now.Before(job.nextRun) {
+					job.nextRun = job.schedule.next(now)
+					go runJob(job, "schedule")
+				}
+				if d := time.Until(job.nextRun); d < wait {
+					wait = d
+				}
+				job.mu.Unlock()
+			}
+			jobsMu.Unlock()
+
+			select {
+			case <-backgroundCtx.Done():
+				return
+			case <-time.After(wait):
+			}
+		}
+	}()
+
+	onShutdown(phaseJobs, "scheduled-jobs", func(ctx context.Context) error {
+		done := make(chan struct{})
+		go func() {
+			jobsWG.Wait()
+			close(done)
+		}()
+		select {
+		case <-done:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	})
+
+	jobsLog.Info("Job scheduler started", "jobs", len(jobs))
+}
+
+// runJob runs a job unless it is already running, reporting whether it ran
+func runJob(job *scheduledJob, trigger string) bool {
+	if !job.running.CompareAndSwap(false, true) {
+		cronJobRunsTotal.WithLabelValues(job.name, "skipped").Inc()
+		jobsLog.Warn("Job still running, skipping this run", "job", job.name, "trigger", trigger)
+		return false
+	}
+	jobsWG.Add(1)
+	defer jobsWG.Done()
+	defer job.running.Store(false)
+
+	cronJobRunning.WithLabelValues(job.name).Set(1)
+	defer cronJobRunning.WithLabelValues(job.name).Set(0)
+
+	ctx, cancel := context.WithTimeout(backgroundCtx, job.timeout)
+	defer cancel()
+
+	start := time.Now()
+	err := job.fn(ctx)
+	took := time.Since(start)
+	cronJobDuration.WithLabelValues(job.name).Observe(took.Seconds())
+
+	job.mu.Lock()
+	job.runs++
+	job.lastRun = start.UTC()
+	job.lastTook = took
+	if err != nil {
+		job.failures++
+		job.lastError = err.Error()
+	} else {
+		job.lastSuccess = time.Now().UTC()
+		job.lastError = ""
+	}
+	job.mu.Unlock()
+
+	if err != nil {
+		cronJobRunsTotal.WithLabelValues(job.name, "failure").Inc()
+		jobsLog.Error("Job failed", "job", job.name, "trigger", trigger, "duration_ms", took.Milliseconds(), "error", err.Error())
+		return true
+	}
+
+	cronJobRunsTotal.WithLabelValues(job.name, "success").Inc()
+	cronJobLastSuccess.WithLabelValues(job.name).SetToCurrentTime()
+	jobsLog.Debug("Job finished", "job", job.name, "trigger", trigger, "duration_ms", took.Milliseconds())
+	return true
+}
+
+// status returns the JSON view of a job
+func (j *scheduledJob) status() JobStatus {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	s := JobStatus{
+		Name:        j.name,
+		Schedule:    j.spec,
+		Description: j.description,
+		Running:     j.running.Load(),
+		NextRun:     j.nextRun.UTC(),
+		LastError:   j.lastError,
+		LastTookMs:  j.lastTook.Milliseconds(),
+		Runs:        j.runs,
+		Failures:    j.failures,
+	}
+	if !j.lastRun.IsZero() {
+		t := j.lastRun
+		s.LastRun = &t
+	}
+	if !j.lastSuccess.IsZero() {
+		t := j.lastSuccess
+		s.LastSuccess = &t
+	}
+	return s
+}
+
+// =============================================================================
+// JOB ADMIN HANDLERS
+// =============================================================================
+
+// listJobs returns every registered job
+func listJobs(c *gin.Context) {
+	jobsMu.Lock()
+	list := make([]JobStatus, 0, len(jobs))
+	for _, job := range jobs {
+		list = append(list, job.status())
+	}
+	jobsMu.Unlock()
+
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	c.JSON(http.StatusOK, gin.H{"jobs": list})
+}
+
+// triggerJob starts a job right away
+func triggerJob(c *gin.Context) {
+	jobsMu.Lock()
+	job, ok := jobs[c.Param("name")]
+	jobsMu.Unlock()
+
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Job not found"})
+		return
+	}
+	if job.running.Load() {
+		c.JSON(http.StatusConflict, gin.H{"error": "Job is already running"})
+		return
+	}
+
+	go runJob(job, "manual")
+
+	jobsLog.InfoContext(c.Request.Context(), "Job triggered manually", "job", job.name)
+	c.JSON(http.StatusAccepted, gin.H{"message": "Job started", "job": job.name})
+}
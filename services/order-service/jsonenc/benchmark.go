@@ -0,0 +1,139 @@
+package jsonenc
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"runtime"
+	"time"
+)
+
+// measureDuration is how long measure repeats an encoder
+const measureDuration = time.Second
+
+// benchItem and benchOrder mirror the shape of the order list response
+type benchItem struct {
+	ID         string  `json:"id"`
+	OrderID    string  `json:"order_id"`
+	SKU        string  `json:"sku"`
+	Name       string  `json:"name"`
+	Quantity   int     `json:"quantity"`
+	UnitPrice  float64 `json:"unit_price"`
+	TotalPrice float64 `json:"total_price"`
+}
+
+type benchOrder struct {
+	ID              string      `json:"id"`
+	CustomerID      string      `json:"customer_id"`
+	CustomerName    string      `json:"customer_name"`
+	CustomerEmail   string      `json:"customer_email"`
+	Status          string      `json:"status"`
+	TotalAmount     float64     `json:"total_amount"`
+	Currency        string      `json:"currency"`
+	ShippingAddress string      `json:"shipping_address,omitempty"`
+	Items           []benchItem `json:"items,omitempty"`
+	CreatedAt       time.Time   `json:"created_at"`
+	UpdatedAt       time.Time   `json:"updated_at"`
+}
+
+// BenchmarkResult is the outcome of one encoder benchmark
+type BenchmarkResult struct {
+	Name        string `json:"name"`
+	Orders      int    `json:"orders"`
+	NsPerOp     int64  `json:"ns_per_op"`
+	AllocsPerOp int64  `json:"allocs_per_op"`
+	BytesPerOp  int64  `json:"bytes_per_op"`
+}
+
+// benchOrders builds a list response with n orders of three items each
+func benchOrders(n int) map[string]interface{} {
+	now := time.Now().UTC()
+	orders := make([]benchOrder, n)
+	for i := range orders {
+		id := fmt.Sprintf("00000000-0000-0000-0000-%012d", i)
+		o := benchOrder{
+			ID:              id,
+			CustomerID:      "11111111-1111-1111-1111-111111111111",
+			CustomerName:    "Jane Doe",
+			CustomerEmail:   "jane.doe@example.com",
+			Status:          "processing",
+			Currency:        "USD",
+			ShippingAddress: "1 Main Street, Springfield",
+			CreatedAt:       now,
+			UpdatedAt:       now,
+		}
+		for j := 0; j < 3; j++ {
+			item := benchItem{
+				ID:        fmt.Sprintf("%s-%d", id, j),
+				OrderID:   id,
+				SKU:       fmt.Sprintf("SKU-%04d", j),
+				Name:      "Widget",
+				Quantity:  j + 1,
+				UnitPrice: 19.99,
+			}
+			item.TotalPrice = float64(item.Quantity) * item.UnitPrice
+			o.TotalAmount += item.TotalPrice
+			o.Items = append(o.Items, item)
+		}
+		orders[i] = o
+	}
+	return map[string]interface{}{"orders": orders, "total": n, "page": 1, "per_page": n}
+}
+
+// Compare benchmarks a plain json.Marshal against Write for a list of
+// the given number of orders, reporting time and allocations per encode.
+func Compare(orders int) []BenchmarkResult {
+	payload := benchOrders(orders)
+
+	return []BenchmarkResult{
+		// What gin's c.JSON does: marshal into a fresh slice, then write it
+		measure("json.Marshal", orders, func(w io.Writer) error {
+			data, err := json.Marshal(payload)
+			if err != nil {
+				return err
+			}
+			_, err = w.Write(data)
+			return err
+		}),
+		MeasureWrite(orders),
+	}
+}
+
+// MeasureWrite benchmarks Write alone for a list of the given number of
+// orders
+func MeasureWrite(orders int) BenchmarkResult {
+	payload := benchOrders(orders)
+	return measure("jsonenc.Write ("+Implementation+")", orders, func(w io.Writer) error {
+		return Write(w, payload)
+	})
+}
+
+// measure runs one encoder writing to io.Discard for measureDuration and
+// reports its time and allocations per encode, like a testing benchmark
+// with ReportAllocs. The go test benchmarks are in jsonenc_test.go.
+func measure(name string, orders int, fn func(w io.Writer) error) BenchmarkResult {
+	result := BenchmarkResult{Name: name, Orders: orders}
+	// Warms up the buffer pool, and the encoder's caches
+	if err := fn(io.Discard); err != nil {
+		return result
+	}
+
+	runtime.GC()
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	start := time.Now()
+	n := int64(0)
+	for time.Since(start) < measureDuration {
+		if err := fn(io.Discard); err != nil {
+			return result
+		}
+		n++
+	}
+	elapsed := time.Since(start)
+	runtime.ReadMemStats(&after)
+
+	result.NsPerOp = elapsed.Nanoseconds() / n
+	result.AllocsPerOp = int64(after.Mallocs-before.Mallocs) / n
+	result.BytesPerOp = int64(after.TotalAlloc-before.TotalAlloc) / n
+	return result
+}
@@ -0,0 +1,17 @@
+//go:build segmentio
+
+package jsonenc
+
+import (
+	"bytes"
+
+	"github.com/segmentio/encoding/json"
+)
+
+// Implementation names the JSON library compiled in
+const Implementation = "segmentio/encoding"
+
+// encode appends the JSON encoding of v to buf
+func encode(buf *bytes.Buffer, v interface{}) error {
+	return json.NewEncoder(buf).Encode(v)
+}
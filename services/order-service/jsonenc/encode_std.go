@@ -0,0 +1,16 @@
+//go:build !segmentio
+
+package jsonenc
+
+import (
+	"bytes"
+	"encoding/json"
+)
+
+// Implementation names the JSON library compiled in
+const Implementation = "encoding/json"
+
+// encode appends the JSON encoding of v to buf
+func encode(buf *bytes.Buffer, v interface{}) error {
+	return json.NewEncoder(buf).Encode(v)
+}
@@ -0,0 +1,54 @@
+// =============================================================================
+// JSON ENCODING
+// =============================================================================
+// Order lists are the largest responses the service produces, and encoding
+// them through gin's c.JSON allocates a fresh buffer on every request.
+// This package encodes into pooled buffers instead and writes the result
+// in a single call.
+//
+// BUILD TAGS:
+// - default            encoding/json with pooled buffers
+// - segmentio          github.com/segmentio/encoding/json (drop-in, faster,
+//                      fewer allocations for large structs)
+//
+//   go build -tags segmentio .
+//
+// The active implementation is reported by Implementation and shown on
+// /health. Compare the allocations of both with:
+//
+//   go test -bench . -benchmem ./jsonenc [-tags segmentio]
+//   go run [-tags segmentio] ./cmd/jsonbench
+// =============================================================================
+
+package jsonenc
+
+import (
+	"bytes"
+	"io"
+	"sync"
+)
+
+// maxPooledBuffer is the largest buffer returned to the pool; bigger ones
+// (a huge export, say) are left for the GC so the pool doesn't pin memory
+const maxPooledBuffer = 1 << 20
+
+var bufferPool = sync.Pool{New: func() interface{} {
+	return new(bytes.Buffer)
+}}
+
+// Write encodes v as JSON and writes it to w in a single call
+func Write(w io.Writer, v interface{}) error {
+	buf := bufferPool.Get().(*bytes.Buffer)
+	buf.Reset()
+	defer func() {
+		if buf.Cap() <= maxPooledBuffer {
+			bufferPool.Put(buf)
+		}
+	}()
+
+	if err := encode(buf, v); err != nil {
+		return err
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
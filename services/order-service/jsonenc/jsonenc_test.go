@@ -0,0 +1,46 @@
+package jsonenc
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"testing"
+)
+
+// benchSizes are the order list sizes benchmarked
+var benchSizes = []int{20, 100, 1000}
+
+// BenchmarkMarshal is what gin's c.JSON does: marshal into a fresh slice,
+// then write it
+func BenchmarkMarshal(b *testing.B) {
+	for _, n := range benchSizes {
+		payload := benchOrders(n)
+		b.Run(fmt.Sprintf("orders=%d", n), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				data, err := json.Marshal(payload)
+				if err != nil {
+					b.Fatal(err)
+				}
+				io.Discard.Write(data)
+			}
+		})
+	}
+}
+
+// BenchmarkWrite encodes with Write, and the implementation compiled in
+//
+//	go test -bench . -benchmem ./jsonenc [-tags segmentio]
+func BenchmarkWrite(b *testing.B) {
+	for _, n := range benchSizes {
+		payload := benchOrders(n)
+		b.Run(fmt.Sprintf("orders=%d", n), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if err := Write(io.Discard, payload); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,88 @@
+// =============================================================================
+// BUSINESS KPIS
+// =============================================================================
+// The business dashboard is built from Prometheus alone, without querying
+// Postgres: every order created through the API (not imports, not canary
+// orders) is recorded in the metrics below, in the currency it was placed
+// in.
+//
+// Revenue is counted in the currency's minor unit (cents, yen, fils; see
+// currency.go), so the counter stays exact however many orders it sums:
+//
+//   sum by (currency) (increase(revenue_total[1d])) / 100   # USD, EUR
+//
+// Cancellations and repricing don't reduce it: it is the value of the
+// orders placed. The average order value is the summary's sum over its
+// count, in the currency's major unit:
+//
+//   sum by (currency) (rate(average_order_value_sum[1h]))
+//     / sum by (currency) (rate(average_order_value_count[1h]))
+//
+// METRICS:
+// - revenue_total{currency}                    Order totals in minor units
+// - average_order_value{currency,quantile}     Order totals (summary)
+// - items_per_order                            Units per order (histogram)
+// =============================================================================
+
+package main
+
+import (
+	"math"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	// Counter: Revenue of created orders in the currency's minor unit
+	revenueTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "revenue_total",
+			Help: "Sum of the totals of created orders in the currency's minor unit (e.g. cents), by currency",
+		},
+		[]string{"currency"},
+	)
+
+	// Summary: Order totals in the currency's major unit
+	averageOrderValue = prometheus.NewSummaryVec(
+		prometheus.SummaryOpts{
+			Name:       "average_order_value",
+			Help:       "Totals of created orders in the currency's major unit, by currency",
+			Objectives: map[float64]float64{0.5: 0.05, 0.9: 0.01, 0.99: 0.001},
+			MaxAge:     time.Hour,
+		},
+		[]string{"currency"},
+	)
+
+	// Histogram: Units (sum of the item quantities) per order
+	itemsPerOrder = prometheus.NewHistogram(
+		prometheus.HistogramOpts{
+			Name:    "items_per_order",
+			Help:    "Number of units (sum of the item quantities) of created orders",
+			Buckets: []float64{1, 2, 3, 5, 8, 13, 21, 34, 55, 100},
+		},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(revenueTotal)
+	prometheus.MustRegister(averageOrderValue)
+	prometheus.MustRegister(itemsPerOrder)
+}
+
+// minorUnits converts an amount to the minor unit of its currency
+func minorUnits(amount float64, code string) float64 {
+	return math.Round(roundMoney(amount, code) * math.Pow10(currencyDecimals(code)))
+}
+
+// recordOrderKPIs records a created order in the business metrics
+func recordOrderKPIs(code string, total float64, items []OrderItemRequest) {
+	revenueTotal.WithLabelValues(code).Add(minorUnits(total, code))
+	averageOrderValue.WithLabelValues(code).Observe(roundMoney(total, code))
+
+	units := 0
+	for _, item := range items {
+		units += item.Quantity
+	}
+	itemsPerOrder.Observe(float64(units))
+}
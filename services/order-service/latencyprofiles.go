@@ -0,0 +1,283 @@
+// =============================================================================
+// LATENCY PROFILES
+// =============================================================================
+// A real service's routes don't share one latency: a lookup by ID is fast
+// and tight, a list is slower with a long tail, a create waits on its
+// writes. Latency profiles give each route a baseline delay drawn from a
+// distribution, so the lab's histograms have distinct, realistic shapes for
+// practising percentile analysis (p50 vs p99, heatmaps, Apdex).
+//
+// - normal     mean latency_ms, standard deviation jitter_ms (symmetric,
+//              p99 close to the median)
+// - lognormal  median latency_ms, shape sigma (right-skewed, the long
+//              tail grows quickly with sigma: 0.3 is tight, 1.0 is wild)
+//
+// Delays are capped at maxProfileDelay. Unlike chaos rules, profiles have
+// no TTL: they are the service's standing behaviour, set at startup with
+// LATENCY_PROFILES and changed at runtime through the admin API. Like all
+// fault injection they only take effect when CHAOS_ENABLED is true.
+//
+// LATENCY_PROFILES lists "METHOD /route=distribution:latency_ms:param"
+// entries separated by semicolons, where param is jitter_ms for normal and
+// sigma for lognormal:
+//
+//   GET /api/v1/orders/:id=normal:15:3;GET /api/v1/orders=lognormal:40:0.8
+//
+// ENDPOINTS:
+// - GET    /admin/latency-profiles   Active profiles
+// - PUT    /admin/latency-profiles   Replace every profile
+// - DELETE /admin/latency-profiles   Remove every profile
+//
+// METRICS:
+// - latency_profile_delay_seconds{method,endpoint}  Delay added per request
+// =============================================================================
+
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// maxProfileDelay caps the delay drawn from a profile, so a wide lognormal
+// tail can't hold a request forever
+const maxProfileDelay = 30 * time.Second
+
+// profileDistributions are the supported latency distributions
+var profileDistributions = map[string]bool{"normal": true, "lognormal": true}
+
+// LatencyProfile is the baseline latency of a route
+type LatencyProfile struct {
+	Method       string  `json:"method"`
+	Route        string  `json:"route"`
+	Distribution string  `json:"distribution"`
+	LatencyMs    float64 `json:"latency_ms"`
+	JitterMs     float64 `json:"jitter_ms,omitempty"`
+	Sigma        float64 `json:"sigma,omitempty"`
+}
+
+// validate checks a profile's distribution and parameters
+func (p *LatencyProfile) validate() error {
+	if p.Method == "" || !strings.HasPrefix(p.Route, "/") {
+		return fmt.Errorf("profile needs a method and a route, got %q %q", p.Method, p.Route)
+	}
+	if !profileDistributions[p.Distribution] {
+		return fmt.Errorf("invalid distribution %q for %s %s, expected normal or lognormal", p.Distribution, p.Method, p.Route)
+	}
+	if p.LatencyMs <= 0 || p.JitterMs < 0 || p.Sigma < 0 {
+		return fmt.Errorf("invalid parameters for %s %s, latency_ms must be positive and jitter_ms and sigma not negative", p.Method, p.Route)
+	}
+	return nil
+}
+
+// sample draws one delay from the distribution
+func (p *LatencyProfile) sample() time.Duration {
+	var ms float64
+	switch p.Distribution {
+	case "normal":
+		ms = p.LatencyMs + rand.NormFloat64()*p.JitterMs
+	case "lognormal":
+		// The median of exp(N(mu, sigma)) is exp(mu)
+		ms = p.LatencyMs * math.Exp(rand.NormFloat64()*p.Sigma)
+	}
+	delay := time.Duration(math.Max(ms, 0) * float64(time.Millisecond))
+	if delay > maxProfileDelay {
+		delay = maxProfileDelay
+	}
+	return delay
+}
+
+// parseLatencyProfiles parses the LATENCY_PROFILES format
+func parseLatencyProfiles(spec string) ([]LatencyProfile, error) {
+	var profiles []LatencyProfile
+	for _, entry := range strings.Split(spec, ";") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		target, dist, ok := strings.Cut(entry, "=")
+		method, route, ok2 := strings.Cut(strings.TrimSpace(target), " ")
+		fields := strings.Split(dist, ":")
+		if !ok || !ok2 || len(fields) != 3 {
+			return nil, fmt.Errorf("invalid latency profile %q, expected METHOD /route=distribution:latency_ms:param", entry)
+		}
+
+		latency, err := strconv.ParseFloat(fields[1], 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid latency_ms in %q: %w", entry, err)
+		}
+		param, err := strconv.ParseFloat(fields[2], 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid parameter in %q: %w", entry, err)
+		}
+
+		p := LatencyProfile{
+			Method:       strings.ToUpper(method),
+			Route:        strings.TrimSpace(route),
+			Distribution: fields[0],
+			LatencyMs:    latency,
+		}
+		if p.Distribution == "lognormal" {
+			p.Sigma = param
+		} else {
+			p.JitterMs = param
+		}
+		if err := p.validate(); err != nil {
+			return nil, err
+		}
+		profiles = append(profiles, p)
+	}
+	return profiles, nil
+}
+
+// latencyProfileState holds the profiles by "METHOD /route"
+type latencyProfileState struct {
+	mu       sync.RWMutex
+	profiles map[string]*LatencyProfile
+}
+
+var (
+	// latencyProfiles holds the process-wide latency profiles
+	latencyProfiles = &latencyProfileState{profiles: map[string]*LatencyProfile{}}
+
+	// Histogram: Baseline delay added to requests
+	latencyProfileDelay = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "latency_profile_delay_seconds",
+			Help:    "Baseline delay added to API requests by latency profiles",
+			Buckets: []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10},
+		},
+		[]string{"method", "endpoint"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(latencyProfileDelay)
+}
+
+// set replaces every profile
+func (s *latencyProfileState) set(profiles []LatencyProfile) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.profiles = make(map[string]*LatencyProfile, len(profiles))
+	for i := range profiles {
+		p := profiles[i]
+		s.profiles[p.Method+" "+p.Route] = &p
+	}
+}
+
+// list returns the profiles ordered by route and method
+func (s *latencyProfileState) list() []LatencyProfile {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	profiles := make([]LatencyProfile, 0, len(s.profiles))
+	for _, p := range s.profiles {
+		profiles = append(profiles, *p)
+	}
+	sort.Slice(profiles, func(i, j int) bool {
+		if profiles[i].Route != profiles[j].Route {
+			return profiles[i].Route < profiles[j].Route
+		}
+		return profiles[i].Method < profiles[j].Method
+	})
+	return profiles
+}
+
+// get returns the profile of a route, if any
+func (s *latencyProfileState) get(method, route string) (LatencyProfile, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	p, ok := s.profiles[method+" "+route]
+	if !ok {
+		return LatencyProfile{}, false
+	}
+	return *p, true
+}
+
+// latencyProfileMiddleware delays requests by their route's profile
+func latencyProfileMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !chaosEnabled {
+			c.Next()
+			return
+		}
+		route := c.FullPath()
+		p, ok := latencyProfiles.get(c.Request.Method, route)
+		if !ok {
+			c.Next()
+			return
+		}
+
+		delay := p.sample()
+		latencyProfileDelay.WithLabelValues(c.Request.Method, route).Observe(delay.Seconds())
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-c.Request.Context().Done():
+			return
+		}
+		c.Next()
+	}
+}
+
+// =============================================================================
+// LATENCY PROFILE ADMIN HANDLERS
+// =============================================================================
+
+// listLatencyProfiles returns the active profiles
+func listLatencyProfiles(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"enabled":  chaosEnabled,
+		"profiles": latencyProfiles.list(),
+	})
+}
+
+// setLatencyProfiles replaces every profile
+func setLatencyProfiles(c *gin.Context) {
+	if !chaosEnabled {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Chaos features are disabled (CHAOS_ENABLED=false)"})
+		return
+	}
+
+	var req struct {
+		Profiles []LatencyProfile `json:"profiles" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	for i := range req.Profiles {
+		req.Profiles[i].Method = strings.ToUpper(req.Profiles[i].Method)
+		if err := req.Profiles[i].validate(); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	latencyProfiles.set(req.Profiles)
+	logWarnContext(c.Request.Context(), "Latency profiles changed", map[string]interface{}{"profiles": req.Profiles})
+	annotate("config", "Latency profiles set for %d routes", len(req.Profiles))
+
+	listLatencyProfiles(c)
+}
+
+// clearLatencyProfiles removes every profile
+func clearLatencyProfiles(c *gin.Context) {
+	removed := len(latencyProfiles.list())
+	latencyProfiles.set(nil)
+	logInfoContext(c.Request.Context(), "Latency profiles cleared", map[string]interface{}{"removed": removed})
+	c.JSON(http.StatusOK, gin.H{"message": "Latency profiles cleared", "removed": removed})
+}
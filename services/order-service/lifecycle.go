@@ -0,0 +1,65 @@
+// =============================================================================
+// KUBERNETES LIFECYCLE ENDPOINTS
+// =============================================================================
+// Each probe answers a different question, so they must not share logic:
+//
+// - /startup        Has the service finished connecting its dependencies?
+//                   Kubernetes holds off liveness checks until this passes.
+// - /live           Is the process responsive? Never checks dependencies,
+//                   so a database outage doesn't restart every pod.
+// - /ready          Should this instance receive traffic right now?
+// - /quitquitquit   Called from a preStop hook: fails readiness and waits
+//                   PRESTOP_DRAIN_DELAY so load balancers stop routing here
+//                   before SIGTERM arrives. This is what prevents 502 spikes
+//                   during rolling deploys. It takes a POST with the admin
+//                   token, so the hook is an exec rather than an httpGet:
+//
+//   lifecycle:
+//     preStop:
+//       exec:
+//         command: ["sh", "-c", "wget -qO- --post-data= --header \"Authorization: Bearer $ADMIN_TOKEN\" http://localhost:9001/quitquitquit"]
+// =============================================================================
+
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// preStopDrainDelay is how long /quitquitquit waits before returning
+var preStopDrainDelay = 10 * time.Second
+
+// startupProbe reports whether startup has completed
+func startupProbe(c *gin.Context) {
+	if !startupComplete.Load() {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"status": "starting"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "started"})
+}
+
+// livenessProbe reports that the process is alive and serving requests
+func livenessProbe(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"status": "alive"})
+}
+
+// quitQuitQuit starts draining and blocks until load balancers have had
+// time to notice that the instance is no longer ready
+func quitQuitQuit(c *gin.Context) {
+	alreadyDraining := draining.Swap(true)
+
+	logWarnContext(c.Request.Context(), "Pre-stop drain requested", map[string]interface{}{
+		"delay_seconds":    preStopDrainDelay.Seconds(),
+		"already_draining": alreadyDraining,
+	})
+
+	select {
+	case <-time.After(preStopDrainDelay):
+	case <-c.Request.Context().Done():
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Drained, ready for SIGTERM", "draining": true})
+}
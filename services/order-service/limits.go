@@ -0,0 +1,248 @@
+// =============================================================================
+// REQUEST LIMITS
+// =============================================================================
+// Order writes are capped so a single request can't bloat the database or
+// tie up a connection:
+//
+//   MAX_REQUEST_BODY_BYTES  Body of POST/PUT /api/v1/orders (413 above it)
+//   MAX_ORDER_ITEMS         Items per order
+//   MAX_ITEM_QUANTITY       Quantity per item
+//   MAX_NOTES_LENGTH        Characters of notes
+//   MAX_ADDRESS_LENGTH      Characters of shipping_address
+//   MAX_NAME_LENGTH         Characters of customer_name and item names
+//
+// Violations, including the binding rules of the request structs (required
+// fields, email format, min quantity), are answered with every problem at
+// once, using the JSON field names:
+//
+//   {"error": "Validation failed", "violations": [
+//     {"field": "items", "rule": "max_items", "limit": 100, "message": "..."},
+//     {"field": "items[2].quantity", "rule": "min", "limit": 1, "message": "..."}]}
+//
+// The bulk import (import.go) validates its records separately.
+//
+// METRICS:
+// - order_validation_failures_total{endpoint,field,rule}  Item indexes are
+//   dropped from the field label (items[].quantity)
+// =============================================================================
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// orderLimits are the caps on order write requests
+type orderLimits struct {
+	MaxBodyBytes  int64
+	MaxItems      int
+	MaxQuantity   int
+	MaxNotes      int
+	MaxAddress    int
+	MaxNameLength int
+}
+
+var (
+	// requestLimits are the limits applied to order writes
+	requestLimits = orderLimits{
+		MaxBodyBytes:  1 << 20,
+		MaxItems:      100,
+		MaxQuantity:   1000,
+		MaxNotes:      1000,
+		MaxAddress:    500,
+		MaxNameLength: 200,
+	}
+
+	// Counter: Rejected order writes by field and rule
+	validationFailuresTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "order_validation_failures_total",
+			Help: "Total number of validation failures of order write requests, by endpoint, field and rule",
+		},
+		[]string{"endpoint", "field", "rule"},
+	)
+)
+
+// fieldIndexPattern matches the item indexes of field paths
+var fieldIndexPattern = regexp.MustCompile(`\[\d+\]`)
+
+func init() {
+	prometheus.MustRegister(validationFailuresTotal)
+
+	// Report binding errors with JSON field names, as clients send them
+	if v, ok := binding.Validator.Engine().(*validator.Validate); ok {
+		v.RegisterTagNameFunc(func(f reflect.StructField) string {
+			name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+			if name == "-" {
+				return ""
+			}
+			return name
+		})
+	}
+}
+
+// setOrderLimits validates and applies the request limits
+func setOrderLimits(limits orderLimits) error {
+	if limits.MaxBodyBytes <= 0 || limits.MaxItems <= 0 || limits.MaxQuantity <= 0 ||
+		limits.MaxNotes <= 0 || limits.MaxAddress <= 0 || limits.MaxNameLength <= 0 {
+		return fmt.Errorf("request limits must be positive: %+v", limits)
+	}
+	requestLimits = limits
+	return nil
+}
+
+// Violation is one problem with a request
+type Violation struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
+	Limit   int64  `json:"limit,omitempty"`
+	Message string `json:"message"`
+}
+
+// maxLength reports a violation if value is longer than limit characters
+func maxLength(field, value string, limit int) []Violation {
+	if utf8.RuneCountInString(value) <= limit {
+		return nil
+	}
+	return []Violation{{
+		Field:   field,
+		Rule:    "max_length",
+		Limit:   int64(limit),
+		Message: fmt.Sprintf("%s must be at most %d characters", field, limit),
+	}}
+}
+
+// checkOrderDetails checks the free-text fields shared by creates and updates
+func (l orderLimits) checkOrderDetails(shippingAddress, notes string) []Violation {
+	violations := maxLength("shipping_address", shippingAddress, l.MaxAddress)
+	return append(violations, maxLength("notes", notes, l.MaxNotes)...)
+}
+
+// checkCreateOrder checks a create order request against the limits
+func (l orderLimits) checkCreateOrder(req CreateOrderRequest) []Violation {
+	violations := l.checkOrderDetails(req.ShippingAddress, req.Notes)
+	violations = append(violations, maxLength("customer_name", req.CustomerName, l.MaxNameLength)...)
+	if req.Gift != nil {
+		violations = append(violations, maxLength("gift.message", req.Gift.Message, giftMessageMaxLength)...)
+	}
+
+	if len(req.Items) > l.MaxItems {
+		violations = append(violations, Violation{
+			Field:   "items",
+			Rule:    "max_items",
+			Limit:   int64(l.MaxItems),
+			Message: fmt.Sprintf("orders can have at most %d items", l.MaxItems),
+		})
+		// Don't report every line of an oversized order
+		return violations
+	}
+	for i, item := range req.Items {
+		field := "items[" + strconv.Itoa(i) + "]"
+		if item.Quantity > l.MaxQuantity {
+			violations = append(violations, Violation{
+				Field:   field + ".quantity",
+				Rule:    "max_quantity",
+				Limit:   int64(l.MaxQuantity),
+				Message: fmt.Sprintf("quantity must be at most %d", l.MaxQuantity),
+			})
+		}
+		violations = append(violations, maxLength(field+".name", item.Name, l.MaxNameLength)...)
+	}
+	return violations
+}
+
+// bindOrderJSON reads a request body of at most MAX_REQUEST_BODY_BYTES
+// into obj, returning the violations if it can't be bound
+func bindOrderJSON(c *gin.Context, obj interface{}) (status int, violations []Violation) {
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, requestLimits.MaxBodyBytes)
+	err := c.ShouldBindJSON(obj)
+	if err == nil {
+		return 0, nil
+	}
+
+	var tooLarge *http.MaxBytesError
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	var fieldErrs validator.ValidationErrors
+	switch {
+	case errors.As(err, &tooLarge):
+		return http.StatusRequestEntityTooLarge, []Violation{{
+			Field:   "body",
+			Rule:    "max_body_bytes",
+			Limit:   tooLarge.Limit,
+			Message: fmt.Sprintf("request body must be at most %d bytes", tooLarge.Limit),
+		}}
+	case errors.As(err, &fieldErrs):
+		return http.StatusBadRequest, fieldViolations(fieldErrs)
+	case errors.As(err, &typeErr):
+		return http.StatusBadRequest, []Violation{{
+			Field:   typeErr.Field,
+			Rule:    "type",
+			Message: fmt.Sprintf("%s must be a %s", typeErr.Field, typeErr.Type),
+		}}
+	case errors.As(err, &syntaxErr), errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return http.StatusBadRequest, []Violation{{Field: "body", Rule: "json", Message: "request body must be valid JSON"}}
+	default:
+		return http.StatusBadRequest, []Violation{{Field: "body", Rule: "invalid", Message: err.Error()}}
+	}
+}
+
+// fieldViolations converts the errors of the binding rules to violations
+func fieldViolations(fieldErrs validator.ValidationErrors) []Violation {
+	violations := make([]Violation, 0, len(fieldErrs))
+	for _, fe := range fieldErrs {
+		// Namespace is Struct.field.sub, the struct name isn't sent
+		_, field, _ := strings.Cut(fe.Namespace(), ".")
+		v := Violation{Field: field, Rule: fe.Tag(), Message: field + " is invalid"}
+		if limit, err := strconv.ParseInt(fe.Param(), 10, 64); err == nil {
+			v.Limit = limit
+		}
+		switch fe.Tag() {
+		case "required":
+			v.Message = field + " is required"
+		case "email":
+			v.Message = field + " must be a valid email address"
+		case "min":
+			v.Message = fmt.Sprintf("%s must be at least %s", field, fe.Param())
+		case "max":
+			v.Message = fmt.Sprintf("%s must be at most %s", field, fe.Param())
+		}
+		violations = append(violations, v)
+	}
+	return violations
+}
+
+// countViolations counts violations in order_validation_failures_total
+func countViolations(endpoint string, violations []Violation) {
+	for _, v := range violations {
+		field := fieldIndexPattern.ReplaceAllString(v.Field, "[]")
+		validationFailuresTotal.WithLabelValues(endpoint, field, v.Rule).Inc()
+	}
+}
+
+// rejectInvalid answers a request with its violations and counts them
+func rejectInvalid(c *gin.Context, endpoint string, status int, violations []Violation) {
+	countViolations(endpoint, violations)
+	logWarnContext(c.Request.Context(), "Invalid order request", map[string]interface{}{
+		"endpoint":   endpoint,
+		"violations": violations,
+	})
+	c.JSON(status, gin.H{
+		"error":      tr(c, "Validation failed"),
+		"violations": violations,
+	})
+}
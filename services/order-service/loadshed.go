@@ -0,0 +1,207 @@
+// =============================================================================
+// LOAD SHEDDING
+// =============================================================================
+// When the service is saturated, it is better to quickly reject requests
+// that can wait than to let everything slow down together. The shedder
+// watches two signals:
+// - In-flight API requests   (LOAD_SHED_MAX_IN_FLIGHT)
+// - Rolling p99 latency      (LOAD_SHED_P99_THRESHOLD)
+//
+// While either is over its threshold, low-priority requests (listing and
+// exporting orders) get 503 + Retry-After. Order creation is critical and
+// is never shed; everything else is normal priority and is shed only when
+// both signals are over their thresholds.
+//
+// Every decision is visible in metrics for the lab's saturation dashboards.
+// =============================================================================
+
+package main
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Request priorities
+const (
+	priorityLow      = "low"
+	priorityNormal   = "normal"
+	priorityCritical = "critical"
+)
+
+// routePriorities overrides the default (normal) priority of a route,
+// keyed by "METHOD /route/pattern"
+var routePriorities = map[string]string{
+	"POST /api/v1/orders": priorityCritical,
+	"GET /api/v1/orders":  priorityLow,
+
+	"POST /api/v1/orders/import":       priorityLow,
+	"GET /api/v1/exports/:id/download": priorityLow,
+}
+
+// loadShedOptions configures the shedder thresholds
+type loadShedOptions struct {
+	enabled       bool
+	maxInFlight   int64
+	p99Threshold  time.Duration
+	retryAfter    time.Duration
+	latencySample int
+}
+
+var (
+	// apiInFlight counts API requests currently being processed
+	apiInFlight atomic.Int64
+
+	// Gauge: API requests in flight
+	httpInFlightRequests = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "http_in_flight_requests",
+			Help: "Number of API requests currently being processed",
+		},
+	)
+
+	// Gauge: Rolling p99 latency used for shedding decisions
+	loadShedP99Seconds = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "load_shed_p99_seconds",
+			Help: "Rolling p99 API latency observed by the load shedder",
+		},
+	)
+
+	// Counter: Requests rejected by the load shedder
+	loadShedRejectedTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "load_shed_rejected_requests_total",
+			Help: "Total number of requests rejected by load shedding",
+		},
+		[]string{"priority", "reason"},
+	)
+
+	// Counter: Requests admitted, by priority
+	loadShedAdmittedTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "load_shed_admitted_requests_total",
+			Help: "Total number of requests admitted by the load shedder",
+		},
+		[]string{"priority"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(httpInFlightRequests)
+	prometheus.MustRegister(loadShedP99Seconds)
+	prometheus.MustRegister(loadShedRejectedTotal)
+	prometheus.MustRegister(loadShedAdmittedTotal)
+}
+
+// latencyWindow keeps the most recent request latencies and a cached p99
+type latencyWindow struct {
+	mu       sync.Mutex
+	samples  []time.Duration
+	next     int
+	full     bool
+	p99      time.Duration
+	computed time.Time
+}
+
+func newLatencyWindow(size int) *latencyWindow {
+	return &latencyWindow{samples: make([]time.Duration, size)}
+}
+
+// observe records a request latency
+func (w *latencyWindow) observe(d time.Duration) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.samples[w.next] = d
+	w.next = (w.next + 1) % len(w.samples)
+	if w.next == 0 {
+		w.full = true
+	}
+}
+
+// percentile99 returns the p99 of the window, recomputed at most once a second
+func (w *latencyWindow) percentile99() time.Duration {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if time.Since(w.computed) < time.Second {
+		return w.p99
+	}
+
+	n := w.next
+	if w.full {
+		n = len(w.samples)
+	}
+	if n == 0 {
+		return 0
+	}
+
+	sorted := make([]time.Duration, n)
+	copy(sorted, w.samples[:n])
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	w.p99 = sorted[(n*99)/100]
+	w.computed = time.Now()
+	loadShedP99Seconds.Set(w.p99.Seconds())
+	return w.p99
+}
+
+// routePriority returns the shedding priority of a request
+func routePriority(method, path string) string {
+	if p, ok := routePriorities[method+" "+path]; ok {
+		return p
+	}
+	return priorityNormal
+}
+
+// loadShedMiddleware rejects low-priority requests while overloaded
+func loadShedMiddleware(opts loadShedOptions) gin.HandlerFunc {
+	window := newLatencyWindow(opts.latencySample)
+
+	return func(c *gin.Context) {
+		inFlight := apiInFlight.Add(1)
+		httpInFlightRequests.Set(float64(inFlight))
+		defer func() {
+			httpInFlightRequests.Set(float64(apiInFlight.Add(-1)))
+		}()
+
+		priority := routePriority(c.Request.Method, c.FullPath())
+
+		if opts.enabled && priority != priorityCritical {
+			overInFlight := inFlight > opts.maxInFlight
+			overLatency := window.percentile99() > opts.p99Threshold
+
+			shed := false
+			reason := ""
+			switch {
+			case priority == priorityLow && overInFlight:
+				shed, reason = true, "in_flight"
+			case priority == priorityLow && overLatency:
+				shed, reason = true, "latency"
+			case priority == priorityNormal && overInFlight && overLatency:
+				shed, reason = true, "in_flight_and_latency"
+			}
+
+			if shed {
+				loadShedRejectedTotal.WithLabelValues(priority, reason).Inc()
+				respondDegraded(c, degradedOverloaded, opts.retryAfter, gin.H{
+					"error":  tr(c, "Service is overloaded, please retry later"),
+					"reason": reason,
+				})
+				return
+			}
+		}
+
+		loadShedAdmittedTotal.WithLabelValues(priority).Inc()
+
+		start := time.Now()
+		c.Next()
+		window.observe(time.Since(start))
+	}
+}
@@ -0,0 +1,231 @@
+// =============================================================================
+// LOCALIZATION
+// =============================================================================
+// Error messages, receipts and CSV exports can be localized. The message
+// catalogs live in locales/*.json, embedded in the binary; each holds the
+// locale's number, currency and date formats and its translations, keyed
+// by the English message (so a missing translation falls back to English):
+//
+//   {
+//     "decimal": ",", "group": ".", "currency_format": "{amount} {symbol}",
+//     "date": "02.01.2006", "datetime": "02.01.2006 15:04 MST",
+//     "csv_delimiter": ";",
+//     "messages": {"Order not found": "Bestellung nicht gefunden"}
+//   }
+//
+// WHERE THE LOCALE COMES FROM:
+// - API responses: the Accept-Language header (q-values honoured, "de-AT"
+//   falls back to "de"), otherwise DEFAULT_LOCALE. Responses carry a
+//   Content-Language header.
+// - Receipts: DEFAULT_LOCALE; previews take ?locale= or Accept-Language
+// - CSV exports: the export's "locale" field. Without one, exports keep
+//   their machine-readable format (RFC 3339 dates, "." decimals).
+//
+// Admin endpoints are for operators and stay in English.
+// =============================================================================
+
+package main
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+//go:embed locales/*.json
+var localeFiles embed.FS
+
+// locale is a parsed message catalog
+type locale struct {
+	Tag            string            `json:"-"`
+	Name           string            `json:"name"`
+	Decimal        string            `json:"decimal"`
+	Group          string            `json:"group"`
+	CurrencyFormat string            `json:"currency_format"`
+	Date           string            `json:"date"`
+	DateTime       string            `json:"datetime"`
+	CSVDelimiter   string            `json:"csv_delimiter"`
+	Messages       map[string]string `json:"messages"`
+}
+
+var (
+	// locales are the available catalogs by tag
+	locales = map[string]*locale{}
+
+	// defaultLocale is used when the client asks for no available locale
+	defaultLocale *locale
+
+	// Counter: API requests by negotiated locale
+	localeRequestsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "http_requests_by_locale_total",
+			Help: "Total number of API requests by the locale negotiated from Accept-Language",
+		},
+		[]string{"locale"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(localeRequestsTotal)
+
+	files, _ := localeFiles.ReadDir("locales")
+	for _, f := range files {
+		data, err := localeFiles.ReadFile("locales/" + f.Name())
+		if err != nil {
+			panic(err)
+		}
+		l := &locale{}
+		if err := json.Unmarshal(data, l); err != nil {
+			panic(fmt.Sprintf("invalid message catalog %s: %v", f.Name(), err))
+		}
+		l.Tag = strings.TrimSuffix(f.Name(), path.Ext(f.Name()))
+		locales[l.Tag] = l
+	}
+	defaultLocale = locales["en"]
+}
+
+// setDefaultLocale validates and applies DEFAULT_LOCALE
+func setDefaultLocale(tag string) error {
+	l := lookupLocale(tag)
+	if l == nil {
+		return fmt.Errorf("unknown DEFAULT_LOCALE %q (available: %s)", tag, strings.Join(localeTags(), ", "))
+	}
+	defaultLocale = l
+	return nil
+}
+
+// localeTags lists the available locales
+func localeTags() []string {
+	tags := make([]string, 0, len(locales))
+	for tag := range locales {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+	return tags
+}
+
+// lookupLocale finds the catalog for a language tag, falling back from a
+// regional tag ("de-AT") to its language ("de")
+func lookupLocale(tag string) *locale {
+	tag = strings.ToLower(strings.TrimSpace(tag))
+	if l, ok := locales[tag]; ok {
+		return l
+	}
+	if i := strings.IndexAny(tag, "-_"); i > 0 {
+		return locales[tag[:i]]
+	}
+	return nil
+}
+
+// negotiateLocale picks the preferred available locale from an
+// Accept-Language header
+func negotiateLocale(header string) *locale {
+	best, bestQ := defaultLocale, 0.0
+	for _, part := range strings.Split(header, ",") {
+		tag, q := part, 1.0
+		if i := strings.Index(part, ";"); i >= 0 {
+			tag = part[:i]
+			param := strings.TrimSpace(part[i+1:])
+			if strings.HasPrefix(param, "q=") {
+				if v, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					q = v
+				}
+			}
+		}
+		if l := lookupLocale(tag); l != nil && q > bestQ {
+			best, bestQ = l, q
+		}
+	}
+	return best
+}
+
+// localeMiddleware negotiates the locale of an API request
+func localeMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		l := negotiateLocale(c.GetHeader("Accept-Language"))
+		c.Set("locale", l)
+		c.Header("Content-Language", l.Tag)
+		localeRequestsTotal.WithLabelValues(l.Tag).Inc()
+		c.Next()
+	}
+}
+
+// requestLocale returns the locale negotiated for a request
+func requestLocale(c *gin.Context) *locale {
+	if l, ok := c.Get("locale"); ok {
+		return l.(*locale)
+	}
+	return defaultLocale
+}
+
+// tr translates a message into the request's locale
+func tr(c *gin.Context, msg string, args ...interface{}) string {
+	return requestLocale(c).T(msg, args...)
+}
+
+// T translates a message, formatting it with args if any
+func (l *locale) T(msg string, args ...interface{}) string {
+	if translated, ok := l.Messages[msg]; ok {
+		msg = translated
+	}
+	if len(args) > 0 {
+		return fmt.Sprintf(msg, args...)
+	}
+	return msg
+}
+
+// Number formats a number with the locale's separators
+func (l *locale) Number(value float64, decimals int) string {
+	s := strconv.FormatFloat(value, 'f', decimals, 64)
+	sign := ""
+	if strings.HasPrefix(s, "-") {
+		sign, s = "-", s[1:]
+	}
+	intPart, fraction := s, ""
+	if i := strings.IndexByte(s, '.'); i >= 0 {
+		intPart, fraction = s[:i], s[i+1:]
+	}
+
+	var b strings.Builder
+	b.WriteString(sign)
+	for i, digit := range intPart {
+		if i > 0 && (len(intPart)-i)%3 == 0 {
+			b.WriteString(l.Group)
+		}
+		b.WriteRune(digit)
+	}
+	if fraction != "" {
+		b.WriteString(l.Decimal)
+		b.WriteString(fraction)
+	}
+	return b.String()
+}
+
+// Money formats an amount in a currency, with the currency's decimals (see
+// currency.go)
+func (l *locale) Money(amount float64, currency string) string {
+	amount, decimals := roundMoney(amount, currency), currencyDecimals(currency)
+	symbol := currencySymbol(currency)
+	if symbol == "" {
+		return l.Number(amount, decimals) + " " + currency
+	}
+	return strings.NewReplacer("{amount}", l.Number(amount, decimals), "{symbol}", symbol).Replace(l.CurrencyFormat)
+}
+
+// FormatDate formats a date in the locale's format
+func (l *locale) FormatDate(t time.Time) string {
+	return t.Format(l.Date)
+}
+
+// FormatDateTime formats a timestamp in the locale's format
+func (l *locale) FormatDateTime(t time.Time) string {
+	return t.Format(l.DateTime)
+}
@@ -0,0 +1,461 @@
+// =============================================================================
+// LOGGING
+// =============================================================================
+// Logs are written through log/slog as one JSON object per line, in the
+// shape Promtail and the Loki dashboards expect:
+//
+//   {"timestamp": "2026-01-02T15:04:05.123Z", "level": "INFO",
+//    "service": "order-service", "logger": "jobs", "message": "Job failed",
+//    "request_id": "...", "order_id": "...", "trace_id": "...", "span_id": "...",
+//    "fields": {"job": "rollup", "error": "..."}}
+//
+// - logger      The component that logged the entry (see newLogger),
+//               omitted for the service's general helpers
+// - request_id  The request being handled: its X-Request-ID header, or a
+//               generated ID returned in X-Request-ID
+// - order_id    The order of /orders/:id requests
+// - trace_id    The trace of the request or job (see tracing.go), which
+//   span_id     Grafana links to Tempo
+// - fields      Everything else logged with the entry
+//
+// The contextual fields come from the context passed to the *Context
+// helpers (logInfoContext, ...) or slog's InfoContext & co., and are also
+// promoted from the fields when logged explicitly. The standard library's
+// log package is routed through the same handler at ERROR, so the
+// log.Fatalf of a failed startup shows at any LOG_LEVEL.
+//
+// Components get their own logger, e.g. newLogger("jobs"), whose level can
+// be set apart from the rest:
+//
+//   LOG_LEVEL    Minimum level: debug, info, warn or error (default info)
+//   LOG_LEVELS   Per-component levels, "jobs=debug,publisher=warn"
+//   LOG_OUTPUT   stdout, file or both (see logoutput.go)
+//
+// Both can be changed at runtime, e.g. to turn on DEBUG during an incident:
+//
+//   GET /admin/log-level   Current levels
+//   PUT /admin/log-level   {"level": "debug", "levels": {"jobs": "warn"},
+//                           "ttl_seconds": 900}
+//
+// Omitted fields keep their current value. With ttl_seconds the levels
+// drop back to what they were before once it expires.
+// =============================================================================
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// serviceName is the service field of every log entry
+const serviceName = "order-service"
+
+// contextLogKeys are the fields logged at the top level rather than under
+// "fields"
+var contextLogKeys = map[string]bool{"request_id": true, "order_id": true, "trace_id": true, "span_id": true}
+
+var (
+	// logLevel is the minimum level of components without a level of
+	// their own
+	logLevel = new(slog.LevelVar)
+
+	// componentLogLevels are the levels set with LOG_LEVELS
+	componentLogLevels atomic.Pointer[map[string]slog.Level]
+
+	// logWriteMu keeps concurrent entries from interleaving
+	logWriteMu sync.Mutex
+
+	// appLogger backs the logDebug, logInfo, logWarn and logError helpers
+	appLogger = newLogger("")
+)
+
+func init() {
+	componentLogLevels.Store(&map[string]slog.Level{})
+	// Route the standard library logger through the JSON handler
+	slog.SetLogLoggerLevel(slog.LevelError)
+	slog.SetDefault(appLogger)
+}
+
+// logContextKey stores contextual log fields in a context
+type logContextKey struct{}
+
+// withLogFields returns a context whose log entries carry the given
+// contextual fields (request_id, order_id)
+func withLogFields(ctx context.Context, attrs ...slog.Attr) context.Context {
+	if parent, ok := ctx.Value(logContextKey{}).([]slog.Attr); ok {
+		attrs = append(append([]slog.Attr{}, parent...), attrs...)
+	}
+	return context.WithValue(ctx, logContextKey{}, attrs)
+}
+
+// newLogger returns the logger of a component
+func newLogger(component string) *slog.Logger {
+	return slog.New(&logHandler{component: component})
+}
+
+// parseLogLevel parses a level name (debug, info, warn or error)
+func parseLogLevel(name string) (slog.Level, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(strings.TrimSpace(name))); err != nil {
+		return 0, fmt.Errorf("unknown log level %q", name)
+	}
+	return level, nil
+}
+
+// setLogLevel changes the minimum log level (debug, info, warn, error)
+func setLogLevel(name string) error {
+	level, err := parseLogLevel(name)
+	if err != nil {
+		return err
+	}
+	logLevel.Set(level)
+	return nil
+}
+
+// setComponentLogLevels validates and applies LOG_LEVELS
+func setComponentLogLevels(spec string) error {
+	levels := map[string]slog.Level{}
+	for _, entry := range strings.Split(spec, ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		component, name, ok := strings.Cut(entry, "=")
+		if !ok {
+			return fmt.Errorf("invalid LOG_LEVELS entry %q, expected component=level", entry)
+		}
+		level, err := parseLogLevel(name)
+		if err != nil {
+			return fmt.Errorf("invalid LOG_LEVELS entry %q: %w", entry, err)
+		}
+		levels[strings.TrimSpace(component)] = level
+	}
+	componentLogLevels.Store(&levels)
+	return nil
+}
+
+// logLevelName formats a level the way LOG_LEVEL spells it
+func logLevelName(level slog.Level) string {
+	return strings.ToLower(level.String())
+}
+
+// logLevelOverride is a temporary level change made through the admin API
+var logLevelOverride struct {
+	mu         sync.Mutex
+	timer      *time.Timer
+	generation int // identifies the timer, see restoreLogLevels
+	expiresAt  time.Time
+	level      slog.Level            // level to restore
+	levels     map[string]slog.Level // component levels to restore
+}
+
+// getLogLevel returns the current log levels
+func getLogLevel(c *gin.Context) {
+	levels := map[string]string{}
+	for component, level := range *componentLogLevels.Load() {
+		levels[component] = logLevelName(level)
+	}
+	resp := gin.H{"level": logLevelName(logLevel.Level()), "levels": levels}
+
+	logLevelOverride.mu.Lock()
+	if logLevelOverride.timer != nil {
+		resp["expires_at"] = logLevelOverride.expiresAt
+	}
+	logLevelOverride.mu.Unlock()
+	c.JSON(http.StatusOK, resp)
+}
+
+// setLogLevels changes the log levels, for ttl_seconds if given
+func setLogLevels(c *gin.Context) {
+	var req struct {
+		Level      string            `json:"level"`
+		Levels     map[string]string `json:"levels"`
+		TTLSeconds int               `json:"ttl_seconds" binding:"min=0"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	level := logLevel.Level()
+	if req.Level != "" {
+		var err error
+		if level, err = parseLogLevel(req.Level); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	levels := *componentLogLevels.Load()
+	if req.Levels != nil {
+		levels = map[string]slog.Level{}
+		for component, name := range req.Levels {
+			parsed, err := parseLogLevel(name)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("component %q: %v", component, err)})
+				return
+			}
+			levels[component] = parsed
+		}
+	}
+
+	logLevelOverride.mu.Lock()
+	if logLevelOverride.timer != nil {
+		// A new change replaces the pending one, but still restores the
+		// levels from before the first
+		logLevelOverride.timer.Stop()
+		logLevelOverride.timer = nil
+	} else {
+		logLevelOverride.level = logLevel.Level()
+		logLevelOverride.levels = *componentLogLevels.Load()
+	}
+	logLevel.Set(level)
+	componentLogLevels.Store(&levels)
+	logLevelOverride.generation++
+	if req.TTLSeconds > 0 {
+		ttl := time.Duration(req.TTLSeconds) * time.Second
+		generation := logLevelOverride.generation
+		logLevelOverride.expiresAt = time.Now().Add(ttl)
+		logLevelOverride.timer = time.AfterFunc(ttl, func() { restoreLogLevels(generation) })
+	}
+	logLevelOverride.mu.Unlock()
+
+	summary := describeLogLevels(level, levels)
+	logWarnContext(c.Request.Context(), "Log level changed", map[string]interface{}{
+		"levels":      summary,
+		"ttl_seconds": req.TTLSeconds,
+		"client_ip":   c.ClientIP(),
+	})
+	annotate("config", "Log level set to %s", summary)
+	getLogLevel(c)
+}
+
+// restoreLogLevels ends a temporary level change, unless it has been
+// replaced since
+func restoreLogLevels(generation int) {
+	logLevelOverride.mu.Lock()
+	if logLevelOverride.timer == nil || logLevelOverride.generation != generation {
+		logLevelOverride.mu.Unlock()
+		return
+	}
+	logLevelOverride.timer = nil
+	level, levels := logLevelOverride.level, logLevelOverride.levels
+	logLevel.Set(level)
+	componentLogLevels.Store(&levels)
+	logLevelOverride.mu.Unlock()
+
+	summary := describeLogLevels(level, levels)
+	logWarn("Log level restored", map[string]interface{}{"levels": summary})
+	annotate("config", "Log level restored to %s", summary)
+}
+
+// describeLogLevels formats levels like LOG_LEVEL and LOG_LEVELS
+// ("debug; jobs=warn")
+func describeLogLevels(level slog.Level, levels map[string]slog.Level) string {
+	components := make([]string, 0, len(levels))
+	for component, l := range levels {
+		components = append(components, component+"="+logLevelName(l))
+	}
+	sort.Strings(components)
+	if len(components) == 0 {
+		return logLevelName(level)
+	}
+	return logLevelName(level) + "; " + strings.Join(components, ",")
+}
+
+// fieldAttrs converts the fields of the log helpers to attributes
+func fieldAttrs(fields map[string]interface{}) []slog.Attr {
+	attrs := make([]slog.Attr, 0, len(fields))
+	for key, value := range fields {
+		attrs = append(attrs, slog.Any(key, value))
+	}
+	return attrs
+}
+
+func logDebug(message string, fields map[string]interface{}) {
+	appLogger.LogAttrs(context.Background(), slog.LevelDebug, message, fieldAttrs(fields)...)
+}
+
+func logInfo(message string, fields map[string]interface{}) {
+	appLogger.LogAttrs(context.Background(), slog.LevelInfo, message, fieldAttrs(fields)...)
+}
+
+func logWarn(message string, fields map[string]interface{}) {
+	appLogger.LogAttrs(context.Background(), slog.LevelWarn, message, fieldAttrs(fields)...)
+}
+
+func logError(message string, fields map[string]interface{}) {
+	appLogger.LogAttrs(context.Background(), slog.LevelError, message, fieldAttrs(fields)...)
+}
+
+func logDebugContext(ctx context.Context, message string, fields map[string]interface{}) {
+	appLogger.LogAttrs(ctx, slog.LevelDebug, message, fieldAttrs(fields)...)
+}
+
+func logInfoContext(ctx context.Context, message string, fields map[string]interface{}) {
+	appLogger.LogAttrs(ctx, slog.LevelInfo, message, fieldAttrs(fields)...)
+}
+
+func logWarnContext(ctx context.Context, message string, fields map[string]interface{}) {
+	appLogger.LogAttrs(ctx, slog.LevelWarn, message, fieldAttrs(fields)...)
+}
+
+func logErrorContext(ctx context.Context, message string, fields map[string]interface{}) {
+	level := slog.LevelError
+	if cancelled(ctx) {
+		// Failures of abandoned requests are expected (see cancellation.go)
+		level = slog.LevelWarn
+	}
+	appLogger.LogAttrs(ctx, level, message, fieldAttrs(fields)...)
+}
+
+// logEntry is the JSON shape of a log line
+type logEntry struct {
+	Timestamp string                 `json:"timestamp"`
+	Level     string                 `json:"level"`
+	Service   string                 `json:"service"`
+	Logger    string                 `json:"logger,omitempty"`
+	Message   string                 `json:"message"`
+	RequestID string                 `json:"request_id,omitempty"`
+	OrderID   string                 `json:"order_id,omitempty"`
+	TraceID   string                 `json:"trace_id,omitempty"`
+	SpanID    string                 `json:"span_id,omitempty"`
+	Fields    map[string]interface{} `json:"fields,omitempty"`
+}
+
+// logHandler is the slog handler writing log entries to logOutput
+type logHandler struct {
+	component string
+	attrs     []slog.Attr // added with WithAttrs, already qualified by their group
+	groups    []string
+}
+
+// Enabled checks the component's level, or LOG_LEVEL
+func (h *logHandler) Enabled(_ context.Context, level slog.Level) bool {
+	if min, ok := (*componentLogLevels.Load())[h.component]; ok && h.component != "" {
+		return level >= min
+	}
+	return level >= logLevel.Level()
+}
+
+func (h *logHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	out := *h
+	out.attrs = append(append([]slog.Attr{}, h.attrs...), h.qualify(attrs)...)
+	return &out
+}
+
+func (h *logHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	out := *h
+	out.groups = append(append([]string{}, h.groups...), name)
+	return &out
+}
+
+// qualify nests attributes in the handler's open groups
+func (h *logHandler) qualify(attrs []slog.Attr) []slog.Attr {
+	for i := len(h.groups) - 1; i >= 0; i-- {
+		args := make([]any, len(attrs))
+		for j, a := range attrs {
+			args[j] = a
+		}
+		attrs = []slog.Attr{slog.Group(h.groups[i], args...)}
+	}
+	return attrs
+}
+
+func (h *logHandler) Handle(ctx context.Context, r slog.Record) error {
+	entry := logEntry{
+		Timestamp: r.Time.UTC().Format(time.RFC3339Nano),
+		Level:     r.Level.String(),
+		Service:   serviceName,
+		Logger:    h.component,
+		Message:   r.Message,
+	}
+	if r.Time.IsZero() {
+		entry.Timestamp = time.Now().UTC().Format(time.RFC3339Nano)
+	}
+
+	attrs := append([]slog.Attr{}, h.attrs...)
+	var recordAttrs []slog.Attr
+	r.Attrs(func(a slog.Attr) bool {
+		recordAttrs = append(recordAttrs, a)
+		return true
+	})
+	attrs = append(attrs, h.qualify(recordAttrs)...)
+
+	if ctxAttrs, ok := ctx.Value(logContextKey{}).([]slog.Attr); ok {
+		attrs = append(ctxAttrs, attrs...)
+	}
+	if sc, ok := propagatedSpan(ctx); ok {
+		entry.TraceID = fmt.Sprintf("%x", sc.traceID)
+		entry.SpanID = fmt.Sprintf("%x", sc.spanID)
+	}
+
+	for _, a := range attrs {
+		a.Value = a.Value.Resolve()
+		if a.Equal(slog.Attr{}) {
+			continue
+		}
+		if contextLogKeys[a.Key] {
+			value := fmt.Sprint(a.Value.Any())
+			switch a.Key {
+			case "request_id":
+				entry.RequestID = value
+			case "order_id":
+				entry.OrderID = value
+			case "trace_id":
+				entry.TraceID = value
+			case "span_id":
+				entry.SpanID = value
+			}
+			continue
+		}
+		if entry.Fields == nil {
+			entry.Fields = map[string]interface{}{}
+		}
+		entry.Fields[a.Key] = logValue(a.Value)
+	}
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	logWriteMu.Lock()
+	defer logWriteMu.Unlock()
+	_, err = logOutput.Write(append(line, '\n'))
+	return err
+}
+
+// logValue converts an attribute value for JSON encoding
+func logValue(v slog.Value) interface{} {
+	switch v.Kind() {
+	case slog.KindGroup:
+		group := map[string]interface{}{}
+		for _, a := range v.Group() {
+			group[a.Key] = logValue(a.Value.Resolve())
+		}
+		return group
+	case slog.KindDuration:
+		return v.Duration().String()
+	case slog.KindTime:
+		return v.Time().Format(time.RFC3339Nano)
+	case slog.KindAny:
+		if err, ok := v.Any().(error); ok {
+			return err.Error()
+		}
+		return v.Any()
+	default:
+		return v.Any()
+	}
+}
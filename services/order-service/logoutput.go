@@ -0,0 +1,63 @@
+// =============================================================================
+// LOG OUTPUT TARGETS
+// =============================================================================
+// In docker-compose, Promtail collects logs from stdout. Bare-metal lab
+// deployments often have no log collector, so logs can also be written to
+// a file that is rotated by size and age (using lumberjack).
+//
+// LOG_OUTPUT:
+// - stdout  Write to stdout only (default)
+// - file    Write to LOG_FILE only
+// - both    Write to stdout and LOG_FILE
+// =============================================================================
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+// logOutput is where structured and standard log entries are written
+var logOutput io.Writer = os.Stdout
+
+// logFile is the rotating log file, if file output is enabled
+var logFile *lumberjack.Logger
+
+// setupLogOutput configures the log destination from the config
+func setupLogOutput(config *Config) error {
+	switch config.LogOutput {
+	case "stdout":
+		logOutput = os.Stdout
+		return nil
+	case "file", "both":
+	default:
+		return fmt.Errorf("unknown LOG_OUTPUT %q (expected stdout, file or both)", config.LogOutput)
+	}
+
+	logFile = &lumberjack.Logger{
+		Filename:   config.LogFile,
+		MaxSize:    config.LogMaxSizeMB,
+		MaxAge:     config.LogMaxAgeDays,
+		MaxBackups: config.LogMaxBackups,
+		Compress:   config.LogCompress,
+	}
+
+	if config.LogOutput == "both" {
+		logOutput = io.MultiWriter(os.Stdout, logFile)
+	} else {
+		logOutput = logFile
+	}
+
+	return nil
+}
+
+// closeLogOutput flushes and closes the log file, if any
+func closeLogOutput() {
+	if logFile != nil {
+		logFile.Close()
+	}
+}
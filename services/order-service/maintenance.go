@@ -0,0 +1,258 @@
+// =============================================================================
+// MAINTENANCE MODE
+// =============================================================================
+// Maintenance mode lets an operator take the write path offline for planned
+// database work without stopping the service.
+//
+// HOW IT WORKS:
+// - Write requests (POST/PUT/PATCH/DELETE) get 503 + Retry-After
+// - Read requests keep being served unless allow_reads is turned off
+// - Enabling maintenance waits for in-flight writes to drain, so nothing
+//   is still talking to the database when the DBA starts working. The wait
+//   is drain_timeout_seconds (default 30s), clamped to 2m so a request
+//   can't hold an admin connection open indefinitely
+//
+// ADMIN ENDPOINTS (token protected, see internal.go):
+//   GET /admin/maintenance   Current state
+//   PUT /admin/maintenance   {"enabled": true, "allow_reads": false,
+//                             "reason": "...", "drain_timeout_seconds": 60}
+// =============================================================================
+
+package main
+
+import (
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// defaultMaintenanceDrainTimeout is how long enabling maintenance waits
+	// for in-flight writes by default
+	defaultMaintenanceDrainTimeout = 30 * time.Second
+
+	// maxMaintenanceDrainTimeout caps drain_timeout_seconds
+	maxMaintenanceDrainTimeout = 2 * time.Minute
+)
+
+// maintenanceState holds the current maintenance mode settings.
+// It is read on every API request, so access goes through a RWMutex.
+type maintenanceState struct {
+	mu         sync.RWMutex
+	enabled    bool
+	allowReads bool
+	reason     string
+	since      time.Time
+	retryAfter time.Duration
+}
+
+var (
+	// maintenance is the process-wide maintenance mode switch
+	maintenance = &maintenanceState{allowReads: true, retryAfter: 5 * time.Minute}
+
+	// inFlightWrites counts write requests currently being processed
+	inFlightWrites atomic.Int64
+
+	// Gauge: 1 while maintenance mode is enabled
+	maintenanceModeGauge = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "maintenance_mode",
+			Help: "Whether maintenance mode is enabled (1) or not (0)",
+		},
+	)
+
+	// Counter: Requests rejected because of maintenance mode
+	maintenanceRejectedTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "maintenance_rejected_requests_total",
+			Help: "Total number of requests rejected while in maintenance mode",
+		},
+		[]string{"method"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(maintenanceModeGauge)
+	prometheus.MustRegister(maintenanceRejectedTotal)
+}
+
+// MaintenanceStatus is the JSON view of the maintenance state
+type MaintenanceStatus struct {
+	Enabled           bool       `json:"enabled"`
+	AllowReads        bool       `json:"allow_reads"`
+	Reason            string     `json:"reason,omitempty"`
+	Since             *time.Time `json:"since,omitempty"`
+	RetryAfterSeconds int        `json:"retry_after_seconds"`
+	InFlightWrites    int64      `json:"in_flight_writes"`
+}
+
+// status returns a snapshot of the maintenance state
+func (m *maintenanceState) status() MaintenanceStatus {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	s := MaintenanceStatus{
+		Enabled:           m.enabled,
+		AllowReads:        m.allowReads,
+		Reason:            m.reason,
+		RetryAfterSeconds: int(m.retryAfter.Seconds()),
+		InFlightWrites:    inFlightWrites.Load(),
+	}
+	if m.enabled {
+		since := m.since
+		s.Since = &since
+	}
+	return s
+}
+
+// set enables or disables maintenance mode
+func (m *maintenanceState) set(enabled, allowReads bool, reason string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if enabled && !m.enabled {
+		m.since = time.Now().UTC()
+	}
+	m.enabled = enabled
+	m.allowReads = allowReads
+	m.reason = reason
+
+	if enabled {
+		maintenanceModeGauge.Set(1)
+	} else {
+		maintenanceModeGauge.Set(0)
+	}
+}
+
+// rejects reports whether a request with the given method must be rejected
+func (m *maintenanceState) rejects(method string) (bool, time.Duration) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if !m.enabled {
+		return false, 0
+	}
+	if isWriteMethod(method) || !m.allowReads {
+		return true, m.retryAfter
+	}
+	return false, 0
+}
+
+// isWriteMethod reports whether an HTTP method modifies state
+func isWriteMethod(method string) bool {
+	switch method {
+	case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// waitForDrain blocks until there are no in-flight writes or the timeout
+// expires. It returns true if all writes finished.
+func waitForDrain(timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for inFlightWrites.Load() > 0 {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	return true
+}
+
+// maintenanceMiddleware rejects requests while maintenance mode is enabled
+// and tracks in-flight writes so they can be drained.
+func maintenanceMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		write := isWriteMethod(c.Request.Method)
+
+		// Count the write before checking the switch, so a request racing
+		// with "enable" is either rejected or waited for by the drain.
+		if write {
+			inFlightWrites.Add(1)
+			defer inFlightWrites.Add(-1)
+		}
+
+		if reject, retryAfter := maintenance.rejects(c.Request.Method); reject {
+			maintenanceRejectedTotal.WithLabelValues(c.Request.Method).Inc()
+			respondDegraded(c, degradedMaintenance, retryAfter, gin.H{
+				"error":  tr(c, "Service is in maintenance mode"),
+				"reason": maintenance.status().Reason,
+			})
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// =============================================================================
+// MAINTENANCE ADMIN HANDLERS
+// =============================================================================
+
+// getMaintenance returns the current maintenance mode state
+func getMaintenance(c *gin.Context) {
+	c.JSON(http.StatusOK, maintenance.status())
+}
+
+// setMaintenance enables or disables maintenance mode
+func setMaintenance(c *gin.Context) {
+	var req struct {
+		Enabled             bool   `json:"enabled"`
+		AllowReads          *bool  `json:"allow_reads"`
+		Reason              string `json:"reason"`
+		DrainTimeoutSeconds int    `json:"drain_timeout_seconds"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.DrainTimeoutSeconds < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "drain_timeout_seconds must not be negative"})
+		return
+	}
+
+	allowReads := maintenance.status().AllowReads
+	if req.AllowReads != nil {
+		allowReads = *req.AllowReads
+	}
+	maintenance.set(req.Enabled, allowReads, req.Reason)
+
+	logWarnContext(c.Request.Context(), "Maintenance mode changed", map[string]interface{}{
+		"enabled":     req.Enabled,
+		"allow_reads": allowReads,
+		"reason":      req.Reason,
+	})
+	if req.Enabled {
+		annotate("maintenance", "Maintenance mode on (reads allowed: %t): %s", allowReads, req.Reason)
+	} else {
+		annotate("maintenance", "Maintenance mode off")
+	}
+
+	drained := true
+	if req.Enabled {
+		timeout := defaultMaintenanceDrainTimeout
+		if req.DrainTimeoutSeconds > 0 {
+			timeout = time.Duration(req.DrainTimeoutSeconds) * time.Second
+		}
+		if timeout > maxMaintenanceDrainTimeout {
+			timeout = maxMaintenanceDrainTimeout
+		}
+		drained = waitForDrain(timeout)
+		if !drained {
+			logWarnContext(c.Request.Context(), "In-flight writes did not drain before timeout", map[string]interface{}{
+				"in_flight_writes": inFlightWrites.Load(),
+				"timeout_seconds":  timeout.Seconds(),
+			})
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"maintenance": maintenance.status(),
+		"drained":     drained,
+	})
+}
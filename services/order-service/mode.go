@@ -0,0 +1,72 @@
+// =============================================================================
+// PROCESS MODE
+// =============================================================================
+// The same binary can run the whole service or only part of it, so queue
+// processing can be scaled and monitored separately from the API pods.
+// SERVICE_MODE selects what a process runs:
+//
+//   Mode     Public API  Outbox relay  Export workers  Scheduled jobs
+//   all      yes         yes           yes             yes
+//   api      yes         -             -               -
+//   worker   -           yes           yes             yes
+//
+// Every mode connects to the dependencies, publishes events and serves the
+// internal endpoints (health, metrics, pprof, admin) on INTERNAL_PORT, so
+// workers are probed and scraped like API pods. The mode is reported by
+// /health and the service_mode metric.
+//
+// An api deployment needs at least one worker (or all) process, otherwise
+// the outbox is never relayed and exports stay pending.
+// =============================================================================
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	modeAll    = "all"
+	modeAPI    = "api"
+	modeWorker = "worker"
+)
+
+// serviceMode is the mode this process runs in
+var serviceMode = modeAll
+
+// Gauge: Mode of this process
+var serviceModeInfo = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "service_mode",
+		Help: "Mode this process runs in (all, api or worker), always 1",
+	},
+	[]string{"mode"},
+)
+
+func init() {
+	prometheus.MustRegister(serviceModeInfo)
+}
+
+// setServiceMode validates and applies SERVICE_MODE
+func setServiceMode(mode string) error {
+	switch mode {
+	case modeAll, modeAPI, modeWorker:
+	default:
+		return fmt.Errorf("unknown SERVICE_MODE %q (expected all, api or worker)", mode)
+	}
+	serviceMode = mode
+	serviceModeInfo.WithLabelValues(mode).Set(1)
+	return nil
+}
+
+// servesAPI reports whether this process serves the public API
+func servesAPI() bool {
+	return serviceMode != modeWorker
+}
+
+// runsWorkers reports whether this process runs background processing
+func runsWorkers() bool {
+	return serviceMode != modeAPI
+}
@@ -0,0 +1,83 @@
+// =============================================================================
+// NOTIFICATION SERVICE CLIENT
+// =============================================================================
+// Helpers for sending notifications through the notification service's
+// POST /api/v1/notifications/send endpoint.
+//
+// sendNotification makes a single attempt. notifyWithRetry hands failed
+// notifications to the delivery retry queue (see deliveries.go), so a
+// notification service outage delays notifications instead of losing them.
+// =============================================================================
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// Notification is the request body accepted by the notification service
+type Notification struct {
+	Type      string `json:"type"`
+	Recipient string `json:"recipient"`
+	Subject   string `json:"subject,omitempty"`
+	Body      string `json:"body"`
+	OrderID   string `json:"order_id,omitempty"`
+}
+
+// notificationURL is the notification service's send endpoint
+func (a *App) notificationURL() string {
+	return a.notificationServiceURL + "/api/v1/notifications/send"
+}
+
+// sendNotification submits a notification to the notification service
+func (a *App) sendNotification(ctx context.Context, n Notification) error {
+	payload, err := json.Marshal(n)
+	if err != nil {
+		return fmt.Errorf("failed to encode notification: %w", err)
+	}
+	return a.postDelivery(ctx, a.notificationURL(), payload)
+}
+
+// notifyWithRetry submits a notification, queueing it for retry if the
+// attempt fails. It only returns an error if the notification could
+// neither be sent nor queued.
+func (a *App) notifyWithRetry(ctx context.Context, n Notification) (queued bool, err error) {
+	payload, err := json.Marshal(n)
+	if err != nil {
+		return false, fmt.Errorf("failed to encode notification: %w", err)
+	}
+
+	sendErr := a.postDelivery(ctx, a.notificationURL(), payload)
+	if sendErr == nil {
+		return false, nil
+	}
+	if err := a.queueDelivery(ctx, "notification", a.notificationURL(), payload, sendErr); err != nil {
+		return false, fmt.Errorf("%v (and failed to queue retry: %w)", sendErr, err)
+	}
+	return true, nil
+}
+
+// postDelivery POSTs a JSON payload to an outbound endpoint, treating any
+// non-2xx response as a failure
+func (a *App) postDelivery(ctx context.Context, url string, payload []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(payload))
+	if err != nil {
+		return fmt.Errorf("failed to build request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := a.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("%s unreachable: %w", req.URL.Host, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("%s returned status %d", req.URL.Host, resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,189 @@
+// =============================================================================
+// S3-COMPATIBLE OBJECT STORE CLIENT
+// =============================================================================
+// A deliberately small client for S3, MinIO and other S3-compatible stores:
+// it only uploads objects, which is all the archive export needs, so the
+// service doesn't pull in a full SDK. Requests are signed with AWS
+// Signature Version 4 using the standard library.
+//
+// Path-style addressing (http://minio:9000/bucket/key) is the default
+// because MinIO and most self-hosted stores expect it; set PathStyle to
+// false for virtual-hosted buckets on AWS (https://bucket.s3.amazonaws.com/key).
+//
+//   client, _ := objstore.New(objstore.Config{
+//       Endpoint: "http://minio:9000", Region: "us-east-1", Bucket: "archive",
+//       AccessKey: "...", SecretKey: "...", PathStyle: true,
+//   })
+//   err := client.PutObject(ctx, "orders/part-00000.ndjson.gz", data, objstore.PutOptions{
+//       ContentType: "application/x-ndjson", ContentEncoding: "gzip",
+//   })
+// =============================================================================
+
+package objstore
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Config locates a bucket and the credentials to write to it
+type Config struct {
+	Endpoint  string
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+	PathStyle bool
+}
+
+// PutOptions are the optional headers of an uploaded object
+type PutOptions struct {
+	ContentType     string
+	ContentEncoding string
+}
+
+// Client uploads objects to one bucket
+type Client struct {
+	cfg      Config
+	endpoint *url.URL
+	http     *http.Client
+}
+
+// New validates the configuration and returns a client
+func New(cfg Config) (*Client, error) {
+	if cfg.Bucket == "" {
+		return nil, fmt.Errorf("objstore: bucket is required")
+	}
+	if cfg.Region == "" {
+		cfg.Region = "us-east-1"
+	}
+	endpoint, err := url.Parse(cfg.Endpoint)
+	if err != nil || endpoint.Scheme == "" || endpoint.Host == "" {
+		return nil, fmt.Errorf("objstore: invalid endpoint %q", cfg.Endpoint)
+	}
+	return &Client{
+		cfg:      cfg,
+		endpoint: endpoint,
+		http:     &http.Client{Timeout: 5 * time.Minute},
+	}, nil
+}
+
+// Bucket returns the bucket the client writes to
+func (c *Client) Bucket() string {
+	return c.cfg.Bucket
+}
+
+// PutObject uploads data as the object key, replacing any existing object
+func (c *Client) PutObject(ctx context.Context, key string, data []byte, opts PutOptions) error {
+	u := *c.endpoint
+	if c.cfg.PathStyle {
+		u.Path = "/" + c.cfg.Bucket + "/" + strings.TrimLeft(key, "/")
+	} else {
+		u.Host = c.cfg.Bucket + "." + u.Host
+		u.Path = "/" + strings.TrimLeft(key, "/")
+	}
+	u.RawPath = escapePath(u.Path)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, u.String(), bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.ContentLength = int64(len(data))
+	if opts.ContentType != "" {
+		req.Header.Set("Content-Type", opts.ContentType)
+	}
+	if opts.ContentEncoding != "" {
+		req.Header.Set("Content-Encoding", opts.ContentEncoding)
+	}
+	c.sign(req, data, time.Now().UTC())
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("objstore: put %s: %w", key, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("objstore: put %s returned status %d: %s", key, resp.StatusCode, msg)
+	}
+	io.Copy(io.Discard, resp.Body)
+	return nil
+}
+
+// sign adds the AWS Signature Version 4 headers to req
+func (c *Client) sign(req *http.Request, payload []byte, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+	payloadHash := sha256Hex(payload)
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	const signedHeaders = "host;x-amz-content-sha256;x-amz-date"
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		"host:" + req.URL.Host + "\n" +
+			"x-amz-content-sha256:" + payloadHash + "\n" +
+			"x-amz-date:" + amzDate + "\n",
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := day + "/" + c.cfg.Region + "/s3/aws4_request"
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		sha256Hex([]byte(canonicalRequest)),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+c.cfg.SecretKey), day)
+	key = hmacSHA256(key, c.cfg.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		c.cfg.AccessKey, scope, signedHeaders, signature))
+}
+
+// escapePath percent-encodes a path the way SigV4 expects: everything but
+// unreserved characters and the "/" separators
+func escapePath(path string) string {
+	var b strings.Builder
+	for i := 0; i < len(path); i++ {
+		ch := path[i]
+		switch {
+		case ch >= 'A' && ch <= 'Z', ch >= 'a' && ch <= 'z', ch >= '0' && ch <= '9',
+			ch == '-', ch == '_', ch == '.', ch == '~', ch == '/':
+			b.WriteByte(ch)
+		default:
+			fmt.Fprintf(&b, "%%%02X", ch)
+		}
+	}
+	return b.String()
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,139 @@
+// =============================================================================
+// ORDER NUMBERS
+// =============================================================================
+// Support staff can't read UUIDs to customers over the phone, so every
+// order also gets a short sequential order number, e.g. ORD-00001234:
+//
+//   ORDER_NUMBER_PREFIX   Prefix of this deployment's numbers, a letter
+//                         and up to 9 more letters or digits (default ORD)
+//   ORDER_NUMBER_DIGITS   Zero-padded width of the counter (default 8);
+//                         numbers outgrowing it just get longer
+//
+// Numbers come from the order_number_seq Postgres sequence, allocated as
+// the orders row is inserted (by default, so API orders, imports and seeded
+// data all get one), and are unique across replicas. In event-sourced mode
+// the number is part of the order.created event, so rebuilt projections
+// keep it. A rolled back insert leaves a gap in the sequence; numbers are
+// never reused. Orders from before the column existed are numbered in
+// creation order on the first start, and a demo reset restarts the counter.
+//
+// The number is returned next to the ID (order_number), and orders can be
+// looked up by it:
+//
+//   GET /api/v1/orders?order_number=ORD-1234    (or just 1234)
+//   GET /api/v1/orders/search?q=ORD-00001234
+// =============================================================================
+
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	// orderNumberPrefix and orderNumberDigits format new order numbers
+	orderNumberPrefix = "ORD"
+	orderNumberDigits = 8
+
+	// orderNumberPrefixPattern keeps prefixes safe to inline in SQL
+	orderNumberPrefixPattern = regexp.MustCompile(`^[A-Z][A-Z0-9]{0,9}$`)
+
+	// orderNumberInputPattern matches the order numbers users type
+	orderNumberInputPattern = regexp.MustCompile(`^(?:([A-Z][A-Z0-9]{0,9}?)[- ]?)?(\d{1,18})$`)
+)
+
+// setOrderNumberFormat validates and applies ORDER_NUMBER_PREFIX and
+// ORDER_NUMBER_DIGITS
+func setOrderNumberFormat(prefix string, digits int) error {
+	prefix = strings.ToUpper(strings.TrimSpace(prefix))
+	if !orderNumberPrefixPattern.MatchString(prefix) {
+		return fmt.Errorf("invalid ORDER_NUMBER_PREFIX %q: expected a letter and up to 9 more letters or digits", prefix)
+	}
+	if digits < 1 || digits > 18 {
+		return fmt.Errorf("invalid ORDER_NUMBER_DIGITS %d: expected 1 to 18", digits)
+	}
+	orderNumberPrefix, orderNumberDigits = prefix, digits
+	return nil
+}
+
+// formatOrderNumber formats the counter of an order number
+func formatOrderNumber(prefix string, n int64) string {
+	return fmt.Sprintf("%s-%0*d", prefix, orderNumberDigits, n)
+}
+
+// normalizeOrderNumber turns an order number as typed by a user
+// ("ord-1234", "1234") into its stored form, or "" if it isn't one
+func normalizeOrderNumber(input string) string {
+	m := orderNumberInputPattern.FindStringSubmatch(strings.ToUpper(strings.TrimSpace(input)))
+	if m == nil {
+		return ""
+	}
+	n, err := strconv.ParseInt(m[2], 10, 64)
+	if err != nil {
+		return ""
+	}
+	prefix := m[1]
+	if prefix == "" {
+		prefix = orderNumberPrefix
+	}
+	return formatOrderNumber(prefix, n)
+}
+
+// migrateOrderNumbers adds the order_number column, numbers existing
+// orders and makes new rows allocate theirs
+func (a *App) migrateOrderNumbers() error {
+	_, err := a.db.Exec(`CREATE SEQUENCE IF NOT EXISTS order_number_seq`)
+	if err != nil {
+		return fmt.Errorf("failed to create order_number_seq: %w", err)
+	}
+
+	_, err = a.db.Exec(`
+		CREATE OR REPLACE FUNCTION next_order_number(prefix TEXT, digits INT) RETURNS TEXT AS $$
+			SELECT prefix || '-' || lpad(n::text, GREATEST(digits, length(n::text)), '0')
+			FROM (SELECT nextval('order_number_seq') AS n) seq
+		$$ LANGUAGE sql VOLATILE
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create next_order_number function: %w", err)
+	}
+
+	_, err = a.db.Exec(`ALTER TABLE orders ADD COLUMN IF NOT EXISTS order_number VARCHAR(32)`)
+	if err != nil {
+		return fmt.Errorf("failed to add orders.order_number: %w", err)
+	}
+
+	// Owned by the column, so a reset's TRUNCATE ... RESTART IDENTITY
+	// restarts the counter
+	_, err = a.db.Exec(`ALTER SEQUENCE order_number_seq OWNED BY orders.order_number`)
+	if err != nil {
+		return fmt.Errorf("failed to attach order_number_seq: %w", err)
+	}
+
+	_, err = a.db.Exec(`
+		UPDATE orders o SET order_number = next_order_number($1, $2)
+		FROM (SELECT id FROM orders WHERE order_number IS NULL ORDER BY created_at, id) pending
+		WHERE o.id = pending.id
+	`, orderNumberPrefix, orderNumberDigits)
+	if err != nil {
+		return fmt.Errorf("failed to number existing orders: %w", err)
+	}
+
+	_, err = a.db.Exec(`CREATE UNIQUE INDEX IF NOT EXISTS idx_orders_order_number ON orders(order_number)`)
+	if err != nil {
+		return fmt.Errorf("failed to create order_number index: %w", err)
+	}
+
+	// The prefix is validated, so it can be inlined in the default
+	_, err = a.db.Exec(fmt.Sprintf(`
+		ALTER TABLE orders
+			ALTER COLUMN order_number SET DEFAULT next_order_number('%s', %d),
+			ALTER COLUMN order_number SET NOT NULL
+	`, orderNumberPrefix, orderNumberDigits))
+	if err != nil {
+		return fmt.Errorf("failed to set order_number default: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,356 @@
+// =============================================================================
+// ORDERS API CLIENT
+// =============================================================================
+// A typed Go client for the order service's public API (/api/v1/orders),
+// so other services and lab tools don't hand-roll HTTP calls and JSON
+// shapes. It has no dependencies beyond the standard library.
+//
+//   client, _ := ordersclient.New(ordersclient.Config{BaseURL: "http://order-service:8001"})
+//   created, err := client.CreateOrder(ctx, ordersclient.CreateOrderRequest{...})
+//   order, err := client.GetOrder(ctx, created.ID)
+//
+// Errors from the service come back as *APIError with the status code,
+// message, validation violations and Retry-After; IsNotFound covers the
+// common check.
+//
+// RETRIES (MaxRetries, default 2, with exponential backoff from
+// RetryBackoff, default 200ms, or the Retry-After the service sent):
+// - Reads, updates, status changes and cancellations are idempotent and
+//   are retried on network errors, 429, 502, 503 and 504.
+// - Creates are retried on 429 and 503 only: the service answers those
+//   before an order is written (startup, maintenance, load shedding,
+//   concurrency limits), whereas a network error may hide an order that
+//   was created.
+//
+// INSTRUMENTATION: Hooks.OnRequest sees every attempt before it is sent
+// (to inject trace context or auth headers), Hooks.OnResponse gets the
+// operation, attempt, status and duration afterwards (for metrics and
+// logs).
+// =============================================================================
+
+package ordersclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config configures a Client
+type Config struct {
+	// BaseURL of the order service public API, e.g. http://order-service:8001
+	BaseURL string
+
+	// HTTPClient defaults to a client with a 10s timeout
+	HTTPClient *http.Client
+
+	// MaxRetries is the number of retries after the first attempt
+	// (0 = default of 2, negative = no retries)
+	MaxRetries int
+	// RetryBackoff is the wait before the first retry, doubled for every
+	// further one (default 200ms)
+	RetryBackoff time.Duration
+	// MaxRetryWait caps the wait between attempts, including waits asked
+	// for with Retry-After (default 5s)
+	MaxRetryWait time.Duration
+
+	// UserAgent identifies the calling service
+	UserAgent string
+
+	Hooks Hooks
+}
+
+// Hooks instrument the requests of a Client
+type Hooks struct {
+	// OnRequest is called before every attempt
+	OnRequest func(req *http.Request)
+	// OnResponse is called after every attempt
+	OnResponse func(info RequestInfo)
+}
+
+// RequestInfo describes one attempt of an API call
+type RequestInfo struct {
+	// Operation is the client method, e.g. "CreateOrder"
+	Operation string
+	Method    string
+	Path      string
+	// Attempt counts from 1
+	Attempt int
+	// StatusCode is 0 when no response was received
+	StatusCode int
+	Duration   time.Duration
+	Err        error
+}
+
+// Client calls the orders API
+type Client struct {
+	baseURL *url.URL
+	cfg     Config
+	http    *http.Client
+}
+
+// New validates the configuration and returns a client
+func New(cfg Config) (*Client, error) {
+	base, err := url.Parse(strings.TrimSuffix(cfg.BaseURL, "/"))
+	if err != nil || base.Scheme == "" || base.Host == "" {
+		return nil, fmt.Errorf("ordersclient: invalid base URL %q", cfg.BaseURL)
+	}
+	if cfg.MaxRetries == 0 {
+		cfg.MaxRetries = 2
+	}
+	if cfg.RetryBackoff <= 0 {
+		cfg.RetryBackoff = 200 * time.Millisecond
+	}
+	if cfg.MaxRetryWait <= 0 {
+		cfg.MaxRetryWait = 5 * time.Second
+	}
+	if cfg.UserAgent == "" {
+		cfg.UserAgent = "ordersclient"
+	}
+	httpClient := cfg.HTTPClient
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: 10 * time.Second}
+	}
+	return &Client{baseURL: base, cfg: cfg, http: httpClient}, nil
+}
+
+// IsNotFound reports whether err is a 404 from the service
+func IsNotFound(err error) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound
+}
+
+// CreateOrder places an order
+func (c *Client) CreateOrder(ctx context.Context, req CreateOrderRequest) (*CreateOrderResponse, error) {
+	var resp CreateOrderResponse
+	err := c.call(ctx, call{
+		operation: "CreateOrder",
+		method:    http.MethodPost,
+		path:      "/api/v1/orders",
+		body:      req,
+		out:       &resp,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// GetOrder returns an order with its items
+func (c *Client) GetOrder(ctx context.Context, id string) (*Order, error) {
+	var order Order
+	err := c.call(ctx, call{
+		operation:  "GetOrder",
+		method:     http.MethodGet,
+		path:       "/api/v1/orders/" + id,
+		idempotent: true,
+		out:        &order,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &order, nil
+}
+
+// ListOrders returns a page of orders, without their items
+func (c *Client) ListOrders(ctx context.Context, opts ListOptions) (*OrderList, error) {
+	query := url.Values{}
+	set := func(key, value string) {
+		if value != "" {
+			query.Set(key, value)
+		}
+	}
+	if opts.Page > 0 {
+		set("page", strconv.Itoa(opts.Page))
+	}
+	if opts.PerPage > 0 {
+		set("per_page", strconv.Itoa(opts.PerPage))
+	}
+	set("customer_id", opts.CustomerID)
+	set("tier", opts.Tier)
+	set("order_number", opts.OrderNumber)
+	set("serial", opts.Serial)
+	set("lot", opts.Lot)
+	set("created_after", opts.CreatedAfter)
+	set("created_before", opts.CreatedBefore)
+	set("tz", opts.Timezone)
+
+	var list OrderList
+	err := c.call(ctx, call{
+		operation:  "ListOrders",
+		method:     http.MethodGet,
+		path:       "/api/v1/orders",
+		query:      query,
+		idempotent: true,
+		out:        &list,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &list, nil
+}
+
+// UpdateOrder replaces the shipping address and notes of an order
+func (c *Client) UpdateOrder(ctx context.Context, id string, req UpdateOrderRequest) error {
+	return c.call(ctx, call{
+		operation:  "UpdateOrder",
+		method:     http.MethodPut,
+		path:       "/api/v1/orders/" + id,
+		body:       req,
+		idempotent: true,
+	})
+}
+
+// UpdateOrderStatus moves an order to a new status
+func (c *Client) UpdateOrderStatus(ctx context.Context, id, status string) error {
+	return c.call(ctx, call{
+		operation:  "UpdateOrderStatus",
+		method:     http.MethodPost,
+		path:       "/api/v1/orders/" + id + "/status",
+		body:       map[string]string{"status": status},
+		idempotent: true,
+	})
+}
+
+// CancelOrder cancels an order. A denied cancellation is an *APIError
+// whose Reason says why (e.g. the cancellation window has passed).
+func (c *Client) CancelOrder(ctx context.Context, id string) error {
+	return c.call(ctx, call{
+		operation:  "CancelOrder",
+		method:     http.MethodDelete,
+		path:       "/api/v1/orders/" + id,
+		idempotent: true,
+	})
+}
+
+// call is one API call
+type call struct {
+	operation  string
+	method     string
+	path       string
+	query      url.Values
+	body       interface{}
+	idempotent bool
+	out        interface{}
+}
+
+// call runs an API call with retries and decodes its response into out
+func (c *Client) call(ctx context.Context, cl call) error {
+	var body []byte
+	if cl.body != nil {
+		var err error
+		if body, err = json.Marshal(cl.body); err != nil {
+			return fmt.Errorf("ordersclient: %s: encoding request: %w", cl.operation, err)
+		}
+	}
+	u := *c.baseURL
+	u.Path += cl.path
+	u.RawQuery = cl.query.Encode()
+
+	backoff := c.cfg.RetryBackoff
+	for attempt := 1; ; attempt++ {
+		err := c.attempt(ctx, cl, u.String(), body, attempt)
+		if err == nil || attempt > c.cfg.MaxRetries || ctx.Err() != nil || !retryable(err, cl.idempotent) {
+			return err
+		}
+
+		wait := backoff
+		var apiErr *APIError
+		if errors.As(err, &apiErr) && apiErr.RetryAfter > 0 {
+			wait = apiErr.RetryAfter
+		}
+		if wait > c.cfg.MaxRetryWait {
+			wait = c.cfg.MaxRetryWait
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(wait):
+		}
+		backoff *= 2
+	}
+}
+
+// attempt sends a request once and decodes its response
+func (c *Client) attempt(ctx context.Context, cl call, target string, body []byte, n int) (err error) {
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, cl.method, target, reader)
+	if err != nil {
+		return fmt.Errorf("ordersclient: %s: %w", cl.operation, err)
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("User-Agent", c.cfg.UserAgent)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.cfg.Hooks.OnRequest != nil {
+		c.cfg.Hooks.OnRequest(req)
+	}
+
+	start := time.Now()
+	status := 0
+	if c.cfg.Hooks.OnResponse != nil {
+		defer func() {
+			c.cfg.Hooks.OnResponse(RequestInfo{
+				Operation:  cl.operation,
+				Method:     cl.method,
+				Path:       cl.path,
+				Attempt:    n,
+				StatusCode: status,
+				Duration:   time.Since(start),
+				Err:        err,
+			})
+		}()
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("ordersclient: %s: %w", cl.operation, err)
+	}
+	defer resp.Body.Close()
+	status = resp.StatusCode
+
+	if resp.StatusCode >= 300 {
+		apiErr := &APIError{Operation: cl.operation, StatusCode: resp.StatusCode}
+		json.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(apiErr)
+		if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && seconds > 0 {
+			apiErr.RetryAfter = time.Duration(seconds) * time.Second
+		}
+		return apiErr
+	}
+	if cl.out == nil {
+		io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(cl.out); err != nil {
+		return fmt.Errorf("ordersclient: %s: decoding response: %w", cl.operation, err)
+	}
+	return nil
+}
+
+// retryable reports whether a failed attempt may be retried
+func retryable(err error, idempotent bool) bool {
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		// No response: the request may or may not have been processed
+		return idempotent
+	}
+	switch apiErr.StatusCode {
+	case http.StatusTooManyRequests, http.StatusServiceUnavailable:
+		return true
+	case http.StatusBadGateway, http.StatusGatewayTimeout:
+		return idempotent
+	}
+	return false
+}
@@ -0,0 +1,166 @@
+package ordersclient
+
+import (
+	"fmt"
+	"time"
+)
+
+// Order is an order as returned by the API
+type Order struct {
+	ID              string       `json:"id"`
+	Number          string       `json:"order_number,omitempty"`
+	CustomerID      string       `json:"customer_id"`
+	CustomerName    string       `json:"customer_name"`
+	CustomerEmail   string       `json:"customer_email"`
+	CustomerTier    string       `json:"customer_tier,omitempty"`
+	Status          string       `json:"status"`
+	TotalAmount     float64      `json:"total_amount"`
+	Currency        string       `json:"currency"`
+	ShippingAddress string       `json:"shipping_address,omitempty"`
+	AddressID       string       `json:"shipping_address_id,omitempty"`
+	Notes           string       `json:"notes,omitempty"`
+	Items           []OrderItem  `json:"items,omitempty"`
+	CreatedAt       time.Time    `json:"created_at"`
+	UpdatedAt       time.Time    `json:"updated_at"`
+	Version         int          `json:"version,omitempty"`
+	ReleaseDate     string       `json:"release_date,omitempty"`
+	Gift            *GiftOptions `json:"gift,omitempty"`
+}
+
+// OrderItem is a line of an order
+type OrderItem struct {
+	ID         string  `json:"id"`
+	OrderID    string  `json:"order_id"`
+	SKU        string  `json:"sku"`
+	Name       string  `json:"name"`
+	Quantity   int     `json:"quantity"`
+	UnitPrice  float64 `json:"unit_price"`
+	TotalPrice float64 `json:"total_price"`
+}
+
+// GiftOptions are the gift wrapping options of an order
+type GiftOptions struct {
+	Wrap       bool    `json:"wrap"`
+	Message    string  `json:"message,omitempty"`
+	HidePrices bool    `json:"hide_prices"`
+	WrapFee    float64 `json:"wrap_fee,omitempty"`
+}
+
+// CreateOrderRequest is the body of CreateOrder
+type CreateOrderRequest struct {
+	CustomerID      string             `json:"customer_id"`
+	CustomerName    string             `json:"customer_name"`
+	CustomerEmail   string             `json:"customer_email"`
+	CustomerTier    string             `json:"customer_tier,omitempty"`
+	ShippingAddress string             `json:"shipping_address,omitempty"`
+	AddressID       string             `json:"shipping_address_id,omitempty"`
+	Notes           string             `json:"notes,omitempty"`
+	Items           []OrderItemRequest `json:"items"`
+	Gift            *GiftOptions       `json:"gift,omitempty"`
+}
+
+// OrderItemRequest is an item of a CreateOrderRequest
+type OrderItemRequest struct {
+	SKU       string  `json:"sku"`
+	Name      string  `json:"name"`
+	Quantity  int     `json:"quantity"`
+	UnitPrice float64 `json:"unit_price"`
+
+	// ReleaseDate (YYYY-MM-DD) in the future makes the order a pre-order
+	ReleaseDate string `json:"release_date,omitempty"`
+}
+
+// CreateOrderResponse is the result of CreateOrder
+type CreateOrderResponse struct {
+	ID           string        `json:"id"`
+	Number       string        `json:"order_number"`
+	Status       string        `json:"status"`
+	CustomerTier string        `json:"customer_tier"`
+	Total        float64       `json:"total"`
+	Message      string        `json:"message"`
+	ReleaseDate  string        `json:"release_date,omitempty"`
+	Warnings     []ItemWarning `json:"warnings,omitempty"`
+	Review       *Review       `json:"review,omitempty"`
+
+	// Timing is the phase breakdown in milliseconds, returned for requests
+	// with an X-Debug-Timing header while the service allows it
+	Timing map[string]float64 `json:"timing,omitempty"`
+}
+
+// ItemWarning flags an item that differs from the inventory catalog
+type ItemWarning struct {
+	SKU          string   `json:"sku"`
+	Issue        string   `json:"issue"`
+	UnitPrice    float64  `json:"unit_price,omitempty"`
+	CatalogPrice *float64 `json:"catalog_price,omitempty"`
+}
+
+// Review is set when an order was accepted but flagged for review
+type Review struct {
+	Reason      string `json:"reason"`
+	DuplicateOf string `json:"duplicate_of,omitempty"`
+}
+
+// UpdateOrderRequest is the body of UpdateOrder
+type UpdateOrderRequest struct {
+	ShippingAddress string `json:"shipping_address"`
+	Notes           string `json:"notes"`
+}
+
+// ListOptions filters and pages ListOrders. Zero values are left out.
+type ListOptions struct {
+	Page        int
+	PerPage     int
+	CustomerID  string
+	Tier        string
+	OrderNumber string
+	Serial      string
+	Lot         string
+
+	// CreatedAfter and CreatedBefore are dates (YYYY-MM-DD) or RFC 3339
+	// times, interpreted in Timezone (an IANA name) when set
+	CreatedAfter  string
+	CreatedBefore string
+	Timezone      string
+}
+
+// OrderList is a page of orders
+type OrderList struct {
+	Orders        []Order    `json:"orders"`
+	Total         int        `json:"total"`
+	Page          int        `json:"page"`
+	PerPage       int        `json:"per_page"`
+	Timezone      string     `json:"timezone"`
+	CreatedAfter  *time.Time `json:"created_after,omitempty"`
+	CreatedBefore *time.Time `json:"created_before,omitempty"`
+}
+
+// Violation is a validation rule a request broke
+type Violation struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
+	Limit   int64  `json:"limit,omitempty"`
+	Message string `json:"message"`
+}
+
+// APIError is a response with an error status
+type APIError struct {
+	// Operation is the client method, e.g. "CreateOrder"
+	Operation  string
+	StatusCode int
+
+	Message     string      `json:"error"`
+	Reason      string      `json:"reason,omitempty"`
+	Violations  []Violation `json:"violations,omitempty"`
+	DuplicateOf string      `json:"duplicate_of,omitempty"`
+
+	// RetryAfter is the delay the service asked for, if any
+	RetryAfter time.Duration
+}
+
+func (e *APIError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("ordersclient: %s returned status %d", e.Operation, e.StatusCode)
+	}
+	return fmt.Sprintf("ordersclient: %s returned status %d: %s", e.Operation, e.StatusCode, e.Message)
+}
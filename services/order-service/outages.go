@@ -0,0 +1,398 @@
+// =============================================================================
+// SIMULATED DEPENDENCY OUTAGES
+// =============================================================================
+// Switches that make the service behave as if a dependency were down,
+// without touching the real dependency, so failure-mode dashboards and
+// runbooks can be exercised safely in a shared lab.
+//
+// The failure is injected at the client, so every code path sees the same
+// error it would see during a real outage:
+// - postgres      New queries, transactions and pings fail (connector wrapper)
+// - redis         Every command fails (go-redis hook)
+// - rabbitmq      Publishing fails and the readiness check reports it down
+// - payment, inventory, user, notification
+//                 Requests to the service URL fail (HTTP transport wrapper)
+//
+// The same wrappers add the artificial latency of slowdowns (see
+// slowdeps.go).
+//
+// Like chaos rules, an outage has a TTL (default 5m) so a forgotten switch
+// turns itself off. Outages can only be started when CHAOS_ENABLED is true
+// and are reported by the simulated_outage gauge.
+//
+// ENDPOINTS:
+// - GET    /admin/outages              List dependencies and active outages
+// - PUT    /admin/outages/:dependency  {"down": true, "ttl_seconds": 300}
+// - DELETE /admin/outages              End every simulated outage
+// =============================================================================
+
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/v8"
+	"github.com/lib/pq"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// simulatedDependencies are the dependencies an outage can be simulated for
+var simulatedDependencies = []string{"postgres", "redis", "rabbitmq", "payment", "inventory", "user", "notification"}
+
+// errSimulatedOutage is returned by a dependency while its outage is simulated
+type errSimulatedOutage string
+
+func (e errSimulatedOutage) Error() string {
+	return fmt.Sprintf("%s is unavailable (simulated outage)", string(e))
+}
+
+// outageState holds the end time of each active simulated outage
+type outageState struct {
+	mu    sync.RWMutex
+	until map[string]time.Time
+}
+
+var (
+	// outages holds the process-wide simulated outages
+	outages = &outageState{until: map[string]time.Time{}}
+
+	// Gauge: 1 while an outage of the dependency is simulated
+	simulatedOutage = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "simulated_outage",
+			Help: "Whether an outage of the dependency is being simulated (1) or not (0)",
+		},
+		[]string{"dependency"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(simulatedOutage)
+	for _, dep := range simulatedDependencies {
+		simulatedOutage.WithLabelValues(dep).Set(0)
+	}
+}
+
+// start simulates an outage of a dependency until the given time
+func (s *outageState) start(dependency string, until time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.until[dependency] = until
+	simulatedOutage.WithLabelValues(dependency).Set(1)
+}
+
+// end stops simulating an outage, reporting whether one was active
+func (s *outageState) end(dependency string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	until, ok := s.until[dependency]
+	delete(s.until, dependency)
+	simulatedOutage.WithLabelValues(dependency).Set(0)
+	return ok && time.Now().Before(until)
+}
+
+// clear ends every simulated outage and returns how many were active
+func (s *outageState) clear() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n := 0
+	now := time.Now()
+	for dep, until := range s.until {
+		if now.Before(until) {
+			n++
+		}
+		simulatedOutage.WithLabelValues(dep).Set(0)
+	}
+	s.until = map[string]time.Time{}
+	return n
+}
+
+// check returns an error if an outage of the dependency is simulated
+func (s *outageState) check(dependency string) error {
+	s.mu.RLock()
+	until, ok := s.until[dependency]
+	s.mu.RUnlock()
+
+	if !ok {
+		return nil
+	}
+	if time.Now().Before(until) {
+		return errSimulatedOutage(dependency)
+	}
+
+	// Expired: drop it so the gauge goes back to 0
+	s.mu.Lock()
+	if u, ok := s.until[dependency]; ok && !time.Now().Before(u) {
+		delete(s.until, dependency)
+		simulatedOutage.WithLabelValues(dependency).Set(0)
+	}
+	s.mu.Unlock()
+	return nil
+}
+
+// active returns the end time of each active outage
+func (s *outageState) active() map[string]time.Time {
+	active := map[string]time.Time{}
+	for _, dep := range simulatedDependencies {
+		if s.check(dep) != nil {
+			s.mu.RLock()
+			active[dep] = s.until[dep]
+			s.mu.RUnlock()
+		}
+	}
+	return active
+}
+
+// =============================================================================
+// POSTGRES
+// =============================================================================
+
+// outageConnector opens database connections that fail while a postgres
+// outage is simulated
+type outageConnector struct {
+	driver.Connector
+}
+
+// newOutageConnector wraps the PostgreSQL connector for the given DSN
+func newOutageConnector(dsn string) (driver.Connector, error) {
+	connector, err := pq.NewConnector(dsn)
+	if err != nil {
+		return nil, err
+	}
+	return outageConnector{connector}, nil
+}
+
+func (c outageConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	if err := dependencyFault(ctx, "postgres"); err != nil {
+		return nil, err
+	}
+	conn, err := c.Connector.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &outageConn{conn}, nil
+}
+
+// outageConn forwards to the real connection unless a postgres outage is
+// simulated. Already pooled connections fail too, like after a failover.
+type outageConn struct {
+	driver.Conn
+}
+
+func (c *outageConn) Prepare(query string) (driver.Stmt, error) {
+	if err := dependencyFault(context.Background(), "postgres"); err != nil {
+		return nil, err
+	}
+	return c.Conn.Prepare(query)
+}
+
+func (c *outageConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	if err := dependencyFault(ctx, "postgres"); err != nil {
+		return nil, err
+	}
+	if p, ok := c.Conn.(driver.ConnPrepareContext); ok {
+		return p.PrepareContext(ctx, query)
+	}
+	return c.Conn.Prepare(query)
+}
+
+func (c *outageConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if err := dependencyFault(ctx, "postgres"); err != nil {
+		return nil, err
+	}
+	if b, ok := c.Conn.(driver.ConnBeginTx); ok {
+		return b.BeginTx(ctx, opts)
+	}
+	return c.Conn.Begin()
+}
+
+func (c *outageConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	if err := dependencyFault(ctx, "postgres"); err != nil {
+		return nil, err
+	}
+	if q, ok := c.Conn.(driver.QueryerContext); ok {
+		return q.QueryContext(ctx, query, args)
+	}
+	return nil, driver.ErrSkip
+}
+
+func (c *outageConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	if err := dependencyFault(ctx, "postgres"); err != nil {
+		return nil, err
+	}
+	if e, ok := c.Conn.(driver.ExecerContext); ok {
+		return e.ExecContext(ctx, query, args)
+	}
+	return nil, driver.ErrSkip
+}
+
+func (c *outageConn) Ping(ctx context.Context) error {
+	if err := dependencyFault(ctx, "postgres"); err != nil {
+		return err
+	}
+	if p, ok := c.Conn.(driver.Pinger); ok {
+		return p.Ping(ctx)
+	}
+	return nil
+}
+
+func (c *outageConn) ResetSession(ctx context.Context) error {
+	if r, ok := c.Conn.(driver.SessionResetter); ok {
+		return r.ResetSession(ctx)
+	}
+	return nil
+}
+
+func (c *outageConn) IsValid() bool {
+	if v, ok := c.Conn.(driver.Validator); ok {
+		return v.IsValid()
+	}
+	return true
+}
+
+// =============================================================================
+// REDIS
+// =============================================================================
+
+// outageHook fails Redis commands while a redis outage is simulated
+type outageHook struct{}
+
+func (outageHook) BeforeProcess(ctx context.Context, cmd redis.Cmder) (context.Context, error) {
+	return ctx, dependencyFault(ctx, "redis")
+}
+
+func (outageHook) AfterProcess(ctx context.Context, cmd redis.Cmder) error {
+	return nil
+}
+
+func (outageHook) BeforeProcessPipeline(ctx context.Context, cmds []redis.Cmder) (context.Context, error) {
+	return ctx, dependencyFault(ctx, "redis")
+}
+
+func (outageHook) AfterProcessPipeline(ctx context.Context, cmds []redis.Cmder) error {
+	return nil
+}
+
+// =============================================================================
+// HTTP SERVICES
+// =============================================================================
+
+// outageTransport fails requests to a downstream service while its outage
+// is simulated
+type outageTransport struct {
+	app  *App
+	next http.RoundTripper
+}
+
+func (t outageTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if dep := t.app.serviceForHost(req.URL.Host); dep != "" {
+		if err := dependencyFault(req.Context(), dep); err != nil {
+			return nil, err
+		}
+	}
+	return t.next.RoundTrip(req)
+}
+
+// serviceForHost maps a request host to the downstream service name
+func (a *App) serviceForHost(host string) string {
+	services := map[string]string{
+		"payment":      a.paymentServiceURL,
+		"inventory":    a.inventoryServiceURL,
+		"user":         a.userServiceURL,
+		"notification": a.notificationServiceURL,
+	}
+	for name, base := range services {
+		if u, err := url.Parse(base); err == nil && u.Host == host {
+			return name
+		}
+	}
+	return ""
+}
+
+// =============================================================================
+// OUTAGE ADMIN HANDLERS
+// =============================================================================
+
+// listOutages returns the dependencies and the active simulated outages
+func listOutages(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"enabled":      chaosEnabled,
+		"dependencies": simulatedDependencies,
+		"active":       outages.active(),
+	})
+}
+
+// setOutage starts or ends a simulated outage of one dependency
+func setOutage(c *gin.Context) {
+	dep := c.Param("dependency")
+	if !isSimulatedDependency(dep) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Unknown dependency", "dependencies": simulatedDependencies})
+		return
+	}
+
+	var req struct {
+		Down       *bool `json:"down" binding:"required"`
+		TTLSeconds int   `json:"ttl_seconds" binding:"min=0"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if !*req.Down {
+		ended := outages.end(dep)
+		logInfoContext(c.Request.Context(), "Simulated outage ended", map[string]interface{}{"dependency": dep})
+		annotate("outage", "Simulated %s outage ended", dep)
+		c.JSON(http.StatusOK, gin.H{"dependency": dep, "down": false, "was_down": ended})
+		return
+	}
+
+	if !chaosEnabled {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Chaos features are disabled (CHAOS_ENABLED=false)"})
+		return
+	}
+
+	ttl := 5 * time.Minute
+	if req.TTLSeconds > 0 {
+		ttl = time.Duration(req.TTLSeconds) * time.Second
+	}
+	if ttl > maxChaosTTL {
+		ttl = maxChaosTTL
+	}
+	until := time.Now().UTC().Add(ttl)
+	outages.start(dep, until)
+
+	logWarnContext(c.Request.Context(), "Simulated outage started", map[string]interface{}{
+		"dependency": dep,
+		"until":      until,
+	})
+	annotate("outage", "Simulated %s outage started for %s", dep, ttl)
+	c.JSON(http.StatusOK, gin.H{"dependency": dep, "down": true, "until": until})
+}
+
+// clearOutages ends every simulated outage
+func clearOutages(c *gin.Context) {
+	ended := outages.clear()
+	logInfoContext(c.Request.Context(), "Simulated outages cleared", map[string]interface{}{"ended": ended})
+	c.JSON(http.StatusOK, gin.H{"message": "Simulated outages cleared", "ended": ended})
+}
+
+// isSimulatedDependency reports whether an outage can be simulated for dep
+func isSimulatedDependency(dep string) bool {
+	for _, d := range simulatedDependencies {
+		if d == dep {
+			return true
+		}
+	}
+	return false
+}
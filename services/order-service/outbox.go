@@ -0,0 +1,593 @@
+// =============================================================================
+// EVENT OUTBOX
+// =============================================================================
+// The outbox_events table makes order writes and their events atomic. The
+// order API's writes (create, update, status change, cancellation) store
+// their events in the outbox in the same transaction as the order, so an
+// order never exists without its event or the other way round. Events that
+// could not be published right away (publish buffer full, broker
+// unavailable, shutdown in progress) end up there too.
+//
+// A background relay publishes pending rows and marks them as published.
+// It polls every OUTBOX_POLL_INTERVAL and is woken right after a write of
+// this instance commits, so events still go out within milliseconds. In
+// api mode the relay runs on the worker instances, and events wait for
+// their next poll.
+//
+//   pending --(published)--> published --(OUTBOX_RETENTION)--> deleted
+//      |
+//      +--(refused OUTBOX_MAX_ATTEMPTS times)--> failed
+//      +--(discarded by an operator)--> discarded
+//
+// An attempt that fails puts the event off for a backoff (5s, doubling up
+// to 5m, in next_attempt_at), so waking the relay on every write can't
+// spend its attempts faster than that. Only failures of the event itself
+// count as attempts: while the broker or its channel is unavailable the
+// relay stops the batch and hands the events back untouched, so an outage
+// of any length doesn't fail them.
+//
+// Failed events stay in the table until an operator retries or discards
+// them, so one event the broker keeps refusing doesn't get retried forever.
+// The outbox-cleanup job deletes published events after OUTBOX_RETENTION
+// (default 24h).
+//
+// The relay claims a batch in a short transaction (FOR UPDATE SKIP LOCKED,
+// then claimed_until set to outboxClaimLease from now) and commits before
+// publishing, so no row lock or connection is held while it waits for the
+// broker's confirms. Each event is then marked published (or its attempt
+// counted) on its own. Other relays skip claimed rows until the claim
+// expires.
+//
+// Publishing is at least once: the events of a relay that dies, or can't
+// mark them, between publishing and marking are published again once
+// their claim expires.
+//
+// ENDPOINTS:
+// - GET  /admin/outbox?status=failed  Outbox events (pending by default;
+//                                     failed, published or discarded),
+//                                     optionally ?routing_key= and ?order_id=
+// - GET  /admin/outbox/:id            One event, with its payload
+// - POST /admin/outbox/:id/retry      Make a pending or failed event pending
+//                                     again with a fresh set of attempts,
+//                                     due at the next poll
+// - POST /admin/outbox/:id/discard    Give up on a pending or failed event
+//
+// METRICS:
+// - outbox_events_failed_total  Events that exhausted their attempts
+// =============================================================================
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// outboxLog is the logger of the outbox relay
+var outboxLog = newLogger("outbox")
+
+// outboxBatchSize is the number of pending events relayed per poll
+const outboxBatchSize = 100
+
+// outboxClaimLease is how long a relay has to publish the events it
+// claimed before another relay may claim them again
+const outboxClaimLease = 2 * time.Minute
+
+const (
+	// outboxRetryBase is the delay before an event that failed to publish
+	// is tried again
+	outboxRetryBase = 5 * time.Second
+
+	// outboxRetryMax caps the delay between attempts
+	outboxRetryMax = 5 * time.Minute
+)
+
+// outboxStatuses are the statuses of outbox events
+var outboxStatuses = map[string]bool{"pending": true, "failed": true, "published": true, "discarded": true}
+
+var (
+	// outboxMaxAttempts is the number of publish attempts before an event
+	// is marked failed
+	outboxMaxAttempts = 10
+
+	// outboxRetention is how long published events are kept
+	outboxRetention = 24 * time.Hour
+
+	// outboxWake wakes the relay when a write stored events
+	outboxWake = make(chan struct{}, 1)
+
+	// Counter: Outbox events that exhausted their attempts
+	outboxEventsFailedTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "outbox_events_failed_total",
+			Help: "Total number of outbox events marked failed after exhausting their publish attempts",
+		},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(outboxEventsFailedTotal)
+}
+
+// saveToOutbox stores an event for the outbox relay to publish later
+func (a *App) saveToOutbox(event orderEvent) {
+	if a.db == nil {
+		outboxLog.Error("Dropping order event, database not available", "routing_key", event.RoutingKey, "order_id", event.OrderID)
+		return
+	}
+
+	_, err := a.db.Exec(`
+		INSERT INTO outbox_events (routing_key, order_id, payload)
+		VALUES ($1, $2, $3)
+	`, event.RoutingKey, sql.NullString{String: event.OrderID, Valid: event.OrderID != ""}, string(event.Body))
+	if err != nil {
+		outboxLog.Error("Failed to save event to outbox", "routing_key", event.RoutingKey, "order_id", event.OrderID, "error", err.Error())
+	}
+}
+
+// outboxEventsKey carries the events an order write stores in the outbox
+type outboxEventsKey struct{}
+
+// withOutboxEvents returns a context whose event-sourced order write (see
+// eventstore.go) stores the given events in the outbox, in its transaction
+func withOutboxEvents(ctx context.Context, events ...orderEvent) context.Context {
+	return context.WithValue(ctx, outboxEventsKey{}, events)
+}
+
+// contextOutboxEvents returns the events given with withOutboxEvents
+func contextOutboxEvents(ctx context.Context) []orderEvent {
+	events, _ := ctx.Value(outboxEventsKey{}).([]orderEvent)
+	return events
+}
+
+// saveToOutboxTx stores events in the outbox as part of tx, with a
+// snapshot of their order as tx left it. The caller wakes the relay once
+// tx committed. Every order write goes through here, so it also records
+// the command the write executes, if any (see commands.go).
+func saveToOutboxTx(ctx context.Context, tx *sql.Tx, events ...orderEvent) error {
+	if err := recordCommandTx(ctx, tx); err != nil {
+		return err
+	}
+	snapshots := map[string]sql.NullString{}
+	for _, event := range events {
+		snapshot, ok := snapshots[event.OrderID]
+		if !ok && carriesOrder(event) {
+			order, err := loadOrder(ctx, tx, event.OrderID)
+			if err != nil && err != sql.ErrNoRows {
+				return fmt.Errorf("failed to snapshot order %s: %w", event.OrderID, err)
+			}
+			if order != nil {
+				data, err := json.Marshal(order)
+				if err != nil {
+					return err
+				}
+				snapshot = sql.NullString{String: string(data), Valid: true}
+			}
+			snapshots[event.OrderID] = snapshot
+		}
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO outbox_events (routing_key, order_id, payload, order_snapshot)
+			VALUES ($1, $2, $3, $4)
+		`, event.RoutingKey, sql.NullString{String: event.OrderID, Valid: event.OrderID != ""}, string(event.Body), snapshot)
+		if err != nil {
+			return fmt.Errorf("failed to save %s event to outbox: %w", event.RoutingKey, err)
+		}
+	}
+	return nil
+}
+
+// wakeOutboxRelay makes the relay poll now rather than at its next tick
+func wakeOutboxRelay() {
+	select {
+	case outboxWake <- struct{}{}:
+	default:
+	}
+}
+
+// execOrderWrite runs a write of an order, labelled with operation (see
+// dbmetrics.go), and if it matched a row stores the events in the outbox
+// in the same transaction. The transaction is retried if the backend
+// allows it (see storage.go).
+func (a *App) execOrderWrite(ctx context.Context, operation string, events []orderEvent, query string, args ...interface{}) (applied bool, err error) {
+	err = retryTx(ctx, func() error {
+		var err error
+		applied, err = a.execOrderWriteTx(ctx, operation, events, query, args...)
+		return err
+	})
+	return applied, err
+}
+
+// execOrderWriteTx runs one attempt of execOrderWrite
+func (a *App) execOrderWriteTx(ctx context.Context, operation string, events []orderEvent, query string, args ...interface{}) (bool, error) {
+	tx, err := a.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(dbOperation(ctx, operation), query, args...)
+	if err != nil {
+		return false, err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return false, nil
+	}
+	if err := saveToOutboxTx(ctx, tx, events...); err != nil {
+		return false, err
+	}
+	if err := tx.Commit(); err != nil {
+		return false, err
+	}
+	wakeOutboxRelay()
+	return true, nil
+}
+
+// cleanupOutbox deletes published events older than OUTBOX_RETENTION
+func (a *App) cleanupOutbox(ctx context.Context) error {
+	result, err := a.db.ExecContext(ctx, `
+		DELETE FROM outbox_events
+		WHERE status = 'published' AND published_at < $1
+	`, time.Now().Add(-outboxRetention))
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n > 0 {
+		outboxLog.InfoContext(ctx, "Deleted published outbox events", "deleted", n, "retention", outboxRetention.String())
+	}
+	return nil
+}
+
+// startOutboxRelay publishes pending outbox events every interval until
+// background work is stopped
+func (a *App) startOutboxRelay(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-backgroundCtx.Done():
+				return
+			case <-ticker.C:
+			case <-outboxWake:
+			}
+			if err := a.relayOutbox(backgroundCtx); err != nil {
+				outboxLog.Error("Outbox relay failed", "error", err.Error())
+			}
+		}
+	}()
+}
+
+// outboxEvent is an outbox row claimed by the relay
+type outboxEvent struct {
+	id       int64
+	attempts int
+	event    orderEvent
+}
+
+// relayOutbox publishes one batch of pending outbox events. While the
+// broker is unavailable the batch stops and its claims are released
+// without counting an attempt: the events aren't at fault.
+func (a *App) relayOutbox(ctx context.Context) error {
+	claimed, err := a.claimOutbox(ctx)
+	if err != nil || len(claimed) == 0 {
+		return err
+	}
+
+	published := 0
+	for i, p := range claimed {
+		if err := a.publishEvent(ctx, p.event); err != nil {
+			if errors.Is(err, errBrokerUnavailable) || ctx.Err() != nil {
+				outboxLog.WarnContext(ctx, "Broker unavailable, outbox relay paused", "pending", len(claimed)-i, "error", err.Error())
+				return a.releaseOutboxClaims(ctx, claimed[i:])
+			}
+
+			// The row is claimed, so its attempts are current
+			status := "pending"
+			if p.attempts+1 >= outboxMaxAttempts {
+				status = "failed"
+			}
+			if _, updateErr := a.db.ExecContext(ctx, `
+				UPDATE outbox_events
+				SET attempts = attempts + 1, last_error = $1, status = $3, claimed_until = NULL,
+				    next_attempt_at = $4
+				WHERE id = $2 AND status = 'pending'
+			`, err.Error(), p.id, status, time.Now().UTC().Add(outboxBackoff(p.attempts+1))); updateErr == nil && status == "failed" {
+				outboxEventsFailedTotal.Inc()
+				outboxLog.ErrorContext(ctx, "Outbox event failed, giving up", "outbox_id", p.id, "routing_key", p.event.RoutingKey, "order_id", p.event.OrderID, "error", err.Error())
+			}
+			continue
+		}
+
+		if _, err := a.db.ExecContext(ctx, `
+			UPDATE outbox_events
+			SET status = 'published', attempts = attempts + 1, published_at = $2, claimed_until = NULL
+			WHERE id = $1 AND status = 'pending'
+		`, p.id, time.Now().UTC()); err != nil {
+			// Published anyway; the claim expires and the event is
+			// published again
+			return err
+		}
+		published++
+	}
+
+	if published > 0 {
+		outboxLog.InfoContext(ctx, "Relayed outbox events", "published", published, "pending", len(claimed)-published)
+	}
+	return nil
+}
+
+// outboxBackoff returns the delay before the next attempt after attempts
+// failed attempts
+func outboxBackoff(attempts int) time.Duration {
+	delay := outboxRetryBase
+	for i := 1; i < attempts && delay < outboxRetryMax; i++ {
+		delay *= 2
+	}
+	if delay > outboxRetryMax {
+		delay = outboxRetryMax
+	}
+	return delay
+}
+
+// releaseOutboxClaims hands claimed events back to the next poll as they
+// were, attempts untouched
+func (a *App) releaseOutboxClaims(ctx context.Context, events []outboxEvent) error {
+	args := make([]interface{}, len(events))
+	for i, p := range events {
+		args[i] = p.id
+	}
+	// Released even when the relay is stopping, so the events don't stay
+	// claimed until the lease runs out
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
+	defer cancel()
+	_, err := a.db.ExecContext(ctx, `
+		UPDATE outbox_events SET claimed_until = NULL
+		WHERE id IN (`+placeholders(len(events))+`) AND status = 'pending'
+	`, args...)
+	return err
+}
+
+// claimOutbox claims a batch of pending events for outboxClaimLease and
+// returns them. Rows are locked with SKIP LOCKED only while claiming, so
+// several instances can relay at once without holding a transaction open
+// while they publish.
+func (a *App) claimOutbox(ctx context.Context) ([]outboxEvent, error) {
+	tx, err := a.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC()
+	rows, err := tx.QueryContext(ctx, `
+		SELECT id, routing_key, order_id, payload, order_snapshot, attempts
+		FROM outbox_events
+		WHERE status = 'pending' AND (claimed_until IS NULL OR claimed_until < $2)
+		  AND (next_attempt_at IS NULL OR next_attempt_at <= $2)
+		ORDER BY id
+		LIMIT $1
+		FOR UPDATE SKIP LOCKED
+	`, outboxBatchSize, now)
+	if err != nil {
+		return nil, err
+	}
+
+	var claimed []outboxEvent
+	for rows.Next() {
+		var p outboxEvent
+		var orderID, snapshot sql.NullString
+		var payload string
+		if err := rows.Scan(&p.id, &p.event.RoutingKey, &orderID, &payload, &snapshot, &p.attempts); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		p.event.OrderID = orderID.String
+		p.event.Body = []byte(payload)
+		if snapshot.Valid {
+			p.event.Order = json.RawMessage(snapshot.String)
+		}
+		claimed = append(claimed, p)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(claimed) == 0 {
+		return nil, nil
+	}
+
+	args := []interface{}{now.Add(outboxClaimLease)}
+	placeholders := make([]string, len(claimed))
+	for i, p := range claimed {
+		args = append(args, p.id)
+		placeholders[i] = fmt.Sprintf("$%d", i+2)
+	}
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE outbox_events SET claimed_until = $1
+		WHERE id IN (`+strings.Join(placeholders, ", ")+`)
+	`, args...); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return claimed, nil
+}
+
+// =============================================================================
+// OUTBOX ADMIN HANDLERS
+// =============================================================================
+
+// OutboxEvent is a row of the outbox
+type OutboxEvent struct {
+	ID          int64      `json:"id"`
+	RoutingKey  string     `json:"routing_key"`
+	OrderID     string     `json:"order_id,omitempty"`
+	Status      string     `json:"status"`
+	Attempts    int        `json:"attempts"`
+	LastError   string     `json:"last_error,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	PublishedAt *time.Time `json:"published_at,omitempty"`
+	NextAttempt *time.Time `json:"next_attempt_at,omitempty"`
+	Payload     string     `json:"payload,omitempty"`
+}
+
+// outboxColumns are the columns scanned by scanOutboxEvent
+const outboxColumns = `id, routing_key, COALESCE(order_id::text, ''), status, attempts,
+	COALESCE(last_error, ''), created_at, published_at, next_attempt_at`
+
+// scanOutboxEvent reads the outboxColumns of a row, plus any extra columns
+func scanOutboxEvent(scan func(dest ...interface{}) error, extra ...interface{}) (OutboxEvent, error) {
+	var e OutboxEvent
+	var publishedAt, nextAttempt sql.NullTime
+	dest := append([]interface{}{&e.ID, &e.RoutingKey, &e.OrderID, &e.Status, &e.Attempts,
+		&e.LastError, &e.CreatedAt, &publishedAt, &nextAttempt}, extra...)
+	if err := scan(dest...); err != nil {
+		return e, err
+	}
+	if publishedAt.Valid {
+		e.PublishedAt = &publishedAt.Time
+	}
+	if nextAttempt.Valid && e.Status == "pending" {
+		e.NextAttempt = &nextAttempt.Time
+	}
+	return e, nil
+}
+
+// listOutbox returns outbox events, pending ones by default
+func (a *App) listOutbox(c *gin.Context) {
+	status := c.DefaultQuery("status", "pending")
+	if !outboxStatuses[status] {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "status must be pending, failed, published or discarded"})
+		return
+	}
+	orderID := c.Query("order_id")
+	if orderID != "" && !uuidPattern.MatchString(orderID) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid order ID"})
+		return
+	}
+	limit := 100
+	if l, err := strconv.Atoi(c.Query("limit")); err == nil && l > 0 && l <= 1000 {
+		limit = l
+	}
+
+	ctx := c.Request.Context()
+	rows, err := a.db.QueryContext(ctx, `
+		SELECT `+outboxColumns+`
+		FROM outbox_events
+		WHERE status = $1
+		  AND ($2 = '' OR routing_key = $2)
+		  AND ($3 = '' OR order_id::text = $3)
+		ORDER BY id DESC
+		LIMIT $4
+	`, status, c.Query("routing_key"), orderID, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	defer rows.Close()
+
+	events := []OutboxEvent{}
+	for rows.Next() {
+		e, err := scanOutboxEvent(rows.Scan)
+		if err != nil {
+			continue
+		}
+		events = append(events, e)
+	}
+	rows.Close()
+
+	counts := map[string]int64{}
+	if countRows, err := a.db.QueryContext(ctx, `SELECT status, COUNT(*) FROM outbox_events GROUP BY status`); err == nil {
+		for countRows.Next() {
+			var s string
+			var n int64
+			if countRows.Scan(&s, &n) == nil {
+				counts[s] = n
+			}
+		}
+		countRows.Close()
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":       status,
+		"counts":       counts,
+		"max_attempts": outboxMaxAttempts,
+		"events":       events,
+	})
+}
+
+// getOutboxEvent returns one outbox event with its payload
+func (a *App) getOutboxEvent(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid outbox event ID"})
+		return
+	}
+
+	row := a.db.QueryRowContext(c.Request.Context(), `
+		SELECT `+outboxColumns+`, payload FROM outbox_events WHERE id = $1
+	`, id)
+	var payload string
+	e, err := scanOutboxEvent(row.Scan, &payload)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Outbox event not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	e.Payload = payload
+	c.JSON(http.StatusOK, e)
+}
+
+// retryOutboxEvent makes a pending or failed event due on the next relay
+// poll, with a fresh set of attempts
+func (a *App) retryOutboxEvent(c *gin.Context) {
+	a.changeOutboxEvent(c, `
+		UPDATE outbox_events SET status = 'pending', attempts = 0, claimed_until = NULL, next_attempt_at = NULL
+		WHERE id = $1 AND status IN ('pending', 'failed')
+	`, "Outbox event requeued", http.StatusAccepted)
+}
+
+// discardOutboxEvent gives up on a pending or failed event
+func (a *App) discardOutboxEvent(c *gin.Context) {
+	a.changeOutboxEvent(c, `
+		UPDATE outbox_events SET status = 'discarded'
+		WHERE id = $1 AND status IN ('pending', 'failed')
+	`, "Outbox event discarded", http.StatusOK)
+}
+
+// changeOutboxEvent runs an update of a pending or failed outbox event
+func (a *App) changeOutboxEvent(c *gin.Context, query, message string, status int) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid outbox event ID"})
+		return
+	}
+
+	result, err := a.db.ExecContext(c.Request.Context(), query, id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Outbox event not found or already published or discarded"})
+		return
+	}
+
+	outboxLog.InfoContext(c.Request.Context(), message, "outbox_id", id, "client_ip", c.ClientIP())
+	c.JSON(status, gin.H{"message": message, "id": id})
+}
@@ -0,0 +1,89 @@
+// =============================================================================
+// PARTIAL RESPONSES
+// =============================================================================
+// GET /api/v1/orders/:id needs the order row; its items are a soft
+// dependency. The items query runs with its own budget,
+// SOFT_DEPENDENCY_TIMEOUT (default 250ms), and when it is over budget or
+// fails, the order is returned without them instead of failing or waiting:
+//
+//   {"id": "...", "status": "shipped", ..., "partial": true,
+//    "warnings": [{"component": "items", "message": "..."}]}
+//
+// Partial responses get Cache-Control: no-store and no ETag, so neither a
+// cache nor a client's conditional request keeps the incomplete copy. A
+// slow items query thus costs at most its budget, keeping the endpoint's
+// tail latency bounded while the database is struggling.
+//
+// METRICS:
+// - partial_responses_total{route,component}   Responses missing a
+//                                              component
+// =============================================================================
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// softDependencyTimeout is the budget of a soft dependency call
+var softDependencyTimeout = 250 * time.Millisecond
+
+var (
+	// Counter: Responses missing a component
+	partialResponsesTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "partial_responses_total",
+			Help: "Total number of responses returned without a component that was over its budget or failed",
+		},
+		[]string{"route", "component"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(partialResponsesTotal)
+}
+
+// partialWarning tells a client which part of a response is missing
+type partialWarning struct {
+	Component string `json:"component"`
+	Message   string `json:"message"`
+}
+
+// setSoftDependencyTimeout validates and applies SOFT_DEPENDENCY_TIMEOUT
+func setSoftDependencyTimeout(timeout time.Duration) error {
+	if timeout <= 0 {
+		return fmt.Errorf("SOFT_DEPENDENCY_TIMEOUT must be positive, got %s", timeout)
+	}
+	softDependencyTimeout = timeout
+	return nil
+}
+
+// softDependency runs fn, the loading of one component of a response,
+// within the soft dependency budget. It returns nil if fn succeeded and
+// otherwise the warning to return in place of the component.
+func softDependency(ctx context.Context, route, component string, fn func(ctx context.Context) error) *partialWarning {
+	callCtx, cancel := context.WithTimeout(ctx, softDependencyTimeout)
+	defer cancel()
+
+	err := fn(callCtx)
+	if err == nil {
+		return nil
+	}
+	partialResponsesTotal.WithLabelValues(route, component).Inc()
+
+	message := component + " could not be loaded"
+	if errors.Is(err, context.DeadlineExceeded) || callCtx.Err() == context.DeadlineExceeded {
+		message = fmt.Sprintf("%s took longer than %s", component, softDependencyTimeout)
+	}
+	logWarnContext(ctx, "Returning partial response", map[string]interface{}{
+		"route":     route,
+		"component": component,
+		"error":     err.Error(),
+	})
+	return &partialWarning{Component: component, Message: message}
+}
@@ -0,0 +1,319 @@
+// =============================================================================
+// PAYLOAD LOGGING
+// =============================================================================
+// When an integration misbehaves, the request and response bodies are
+// usually what's missing from the logs. Payload logging is an opt-in debug
+// mode that logs them, one "Payload" line per request, for selected API
+// routes:
+//
+// - PAYLOAD_LOGGING            Start with payload logging on (default false)
+// - PAYLOAD_LOG_ROUTES         "METHOD /route" entries, e.g.
+//                              "POST /api/v1/orders" (empty = every API route)
+// - PAYLOAD_LOG_SAMPLE_RATE    Share (0-1) of matching requests logged
+// - PAYLOAD_LOG_MAX_BYTES      Bodies are cut after this many bytes
+//
+// Bodies are sanitized before they are logged: personal data in JSON
+// (names, emails, addresses, phone and card numbers, passwords) is masked,
+// keeping just enough to recognize a value ("j***@example.com"), and
+// bodies that aren't JSON are left out. Masked bodies longer than
+// PAYLOAD_LOG_MAX_BYTES are cut and logged as text with "truncated": true.
+// Bodies over 64 KiB are never logged, since they can't be masked without
+// holding them in memory.
+//
+// It can be switched at runtime with PUT /admin/payload-logging, optionally
+// with a ttl_seconds after which it switches itself off again, so a
+// forgotten debug session doesn't keep filling the logs.
+//
+// ENDPOINTS:
+// - GET /admin/payload-logging   Current settings
+// - PUT /admin/payload-logging   {"enabled": true, "routes": [...],
+//                                 "sample_rate": 0.1, "ttl_seconds": 600}
+//
+// METRICS:
+// - payloads_logged_total{method,endpoint}
+// =============================================================================
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"math/rand"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// maskedPayloadFields are the JSON fields masked in logged payloads
+var maskedPayloadFields = map[string]bool{
+	"customer_name":    true,
+	"customer_email":   true,
+	"shipping_address": true,
+	"email":            true,
+	"phone":            true,
+	"address":          true,
+	"card_number":      true,
+	"password":         true,
+	"token":            true,
+}
+
+// payloadCaptureLimit is the largest body captured for masking
+const payloadCaptureLimit = 64 << 10
+
+// payloadLogSettings is the current payload logging configuration
+type payloadLogSettings struct {
+	mu         sync.RWMutex
+	enabled    bool
+	routes     map[string]bool
+	sampleRate float64
+	maxBytes   int
+	expiresAt  time.Time
+}
+
+var (
+	// payloadLog is the process-wide payload logging setting
+	payloadLog = &payloadLogSettings{sampleRate: 1, maxBytes: 2048}
+
+	// Counter: Requests whose payloads were logged
+	payloadsLoggedTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "payloads_logged_total",
+			Help: "Total number of requests whose request and response bodies were logged",
+		},
+		[]string{"method", "endpoint"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(payloadsLoggedTotal)
+}
+
+// set replaces the settings. A zero ttl keeps logging on until switched off.
+func (s *payloadLogSettings) set(enabled bool, routes []string, sampleRate float64, maxBytes int, ttl time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.enabled = enabled
+	s.routes = map[string]bool{}
+	for _, r := range routes {
+		if r = strings.TrimSpace(r); r != "" {
+			s.routes[r] = true
+		}
+	}
+	s.sampleRate = sampleRate
+	if maxBytes > 0 {
+		s.maxBytes = maxBytes
+	}
+	s.expiresAt = time.Time{}
+	if enabled && ttl > 0 {
+		s.expiresAt = time.Now().Add(ttl)
+	}
+}
+
+// sample decides whether a request's payloads are logged, returning the
+// size cap if they are
+func (s *payloadLogSettings) sample(method, route string) (maxBytes int, ok bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if !s.enabled || (!s.expiresAt.IsZero() && time.Now().After(s.expiresAt)) {
+		return 0, false
+	}
+	if len(s.routes) > 0 && !s.routes[method+" "+route] {
+		return 0, false
+	}
+	return s.maxBytes, rand.Float64() < s.sampleRate
+}
+
+// maskValue hides most of a personal value, keeping the first character
+// and the domain of email addresses
+func maskValue(v interface{}) interface{} {
+	s, ok := v.(string)
+	if !ok || s == "" {
+		return "***"
+	}
+	_, first := utf8.DecodeRuneInString(s)
+	if at := strings.LastIndex(s, "@"); at >= first {
+		return s[:first] + "***" + s[at:]
+	}
+	return s[:first] + "***"
+}
+
+// maskPayloadValue masks the personal fields of a decoded JSON value
+func maskPayloadValue(v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		for k, val := range t {
+			if maskedPayloadFields[strings.ToLower(k)] {
+				t[k] = maskValue(val)
+				continue
+			}
+			t[k] = maskPayloadValue(val)
+		}
+	case []interface{}:
+		for i := range t {
+			t[i] = maskPayloadValue(t[i])
+		}
+	}
+	return v
+}
+
+// sanitizePayload prepares a captured body for the log. total is the
+// size of the whole body, of which body holds at most payloadCaptureLimit
+// bytes. It returns nil for empty bodies and whether the body was cut.
+func sanitizePayload(body []byte, total, maxBytes int) (interface{}, bool) {
+	switch {
+	case total == 0:
+		return nil, false
+	case total > len(body):
+		return "[body over 64 KiB omitted]", true
+	}
+
+	var v interface{}
+	if err := json.Unmarshal(body, &v); err != nil {
+		return "[non-JSON body omitted]", false
+	}
+	masked := maskPayloadValue(v)
+	if out, err := json.Marshal(masked); err == nil && len(out) > maxBytes {
+		return string(out[:maxBytes]), true
+	}
+	return masked, false
+}
+
+// payloadCaptureWriter keeps the start of a response body for the log
+type payloadCaptureWriter struct {
+	gin.ResponseWriter
+
+	buf   bytes.Buffer
+	total int
+}
+
+// Write passes data on, keeping up to payloadCaptureLimit bytes of it
+func (w *payloadCaptureWriter) Write(data []byte) (int, error) {
+	if room := payloadCaptureLimit - w.buf.Len(); room > 0 {
+		if len(data) < room {
+			room = len(data)
+		}
+		w.buf.Write(data[:room])
+	}
+	w.total += len(data)
+	return w.ResponseWriter.Write(data)
+}
+
+// WriteString implements gin.ResponseWriter
+func (w *payloadCaptureWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// payloadLogMiddleware logs the sanitized bodies of sampled requests
+func payloadLogMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		route := c.FullPath()
+		maxBytes, ok := payloadLog.sample(c.Request.Method, route)
+		if !ok {
+			c.Next()
+			return
+		}
+		start := time.Now()
+
+		// Read up to the capture limit and put the body back for the handler
+		var reqBody []byte
+		reqTotal := 0
+		if c.Request.Body != nil {
+			buf, err := io.ReadAll(io.LimitReader(c.Request.Body, payloadCaptureLimit+1))
+			if err == nil {
+				c.Request.Body = io.NopCloser(io.MultiReader(bytes.NewReader(buf), c.Request.Body))
+				reqTotal = len(buf)
+				if len(buf) > payloadCaptureLimit {
+					buf = buf[:payloadCaptureLimit]
+				}
+				reqBody = buf
+			}
+		}
+
+		w := &payloadCaptureWriter{ResponseWriter: c.Writer}
+		c.Writer = w
+		c.Next()
+		c.Writer = w.ResponseWriter
+
+		fields := map[string]interface{}{
+			"method":      c.Request.Method,
+			"route":       route,
+			"status":      w.Status(),
+			"duration_ms": time.Since(start).Milliseconds(),
+		}
+		if body, truncated := sanitizePayload(reqBody, reqTotal, maxBytes); body != nil {
+			fields["request_body"] = body
+			fields["request_truncated"] = truncated
+		}
+		if body, truncated := sanitizePayload(w.buf.Bytes(), w.total, maxBytes); body != nil {
+			fields["response_body"] = body
+			fields["response_truncated"] = truncated
+		}
+		payloadsLoggedTotal.WithLabelValues(c.Request.Method, route).Inc()
+		logInfo("Payload", fields)
+	}
+}
+
+// =============================================================================
+// PAYLOAD LOGGING ADMIN HANDLERS
+// =============================================================================
+
+// getPayloadLogging returns the payload logging settings
+func getPayloadLogging(c *gin.Context) {
+	payloadLog.mu.RLock()
+	defer payloadLog.mu.RUnlock()
+
+	routes := make([]string, 0, len(payloadLog.routes))
+	for r := range payloadLog.routes {
+		routes = append(routes, r)
+	}
+	resp := gin.H{
+		"enabled":     payloadLog.enabled,
+		"routes":      routes,
+		"sample_rate": payloadLog.sampleRate,
+		"max_bytes":   payloadLog.maxBytes,
+	}
+	if !payloadLog.expiresAt.IsZero() {
+		resp["expires_at"] = payloadLog.expiresAt
+		resp["enabled"] = payloadLog.enabled && time.Now().Before(payloadLog.expiresAt)
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// setPayloadLogging switches payload logging on or off
+func setPayloadLogging(c *gin.Context) {
+	payloadLog.mu.RLock()
+	current := payloadLog.sampleRate
+	payloadLog.mu.RUnlock()
+
+	var req struct {
+		Enabled    bool     `json:"enabled"`
+		Routes     []string `json:"routes"`
+		SampleRate *float64 `json:"sample_rate" binding:"omitempty,min=0,max=1"`
+		MaxBytes   int      `json:"max_bytes" binding:"min=0"`
+		TTLSeconds int      `json:"ttl_seconds" binding:"min=0"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.SampleRate == nil {
+		req.SampleRate = &current
+	}
+
+	payloadLog.set(req.Enabled, req.Routes, *req.SampleRate, req.MaxBytes, time.Duration(req.TTLSeconds)*time.Second)
+	logWarnContext(c.Request.Context(), "Payload logging changed", map[string]interface{}{
+		"enabled":     req.Enabled,
+		"routes":      req.Routes,
+		"sample_rate": *req.SampleRate,
+		"ttl_seconds": req.TTLSeconds,
+	})
+	getPayloadLogging(c)
+}
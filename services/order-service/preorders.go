@@ -0,0 +1,243 @@
+// =============================================================================
+// PRE-ORDERS
+// =============================================================================
+// Items can be ordered before they are available: an item with a
+// release_date (YYYY-MM-DD, UTC) in the future makes its order a pre-order.
+// Pre-orders are created in the "preorder" status instead of "pending",
+// with the order's release_date set to the latest release date of its
+// items, since the order ships complete.
+//
+//   preorder --(release date reached, preorder-release job)--> pending
+//
+// The preorder-release job runs every PREORDER_RELEASE_INTERVAL and moves
+// due pre-orders to pending, where they continue like any other order.
+// Rows are locked with SKIP LOCKED in crud mode and released with an
+// expected-status check in eventsourced mode, so several instances can run
+// it at once. Operators can still move a pre-order by hand through
+// POST /api/v1/orders/:id/status.
+//
+// EVENTS:
+// - order.preorder.created    Next to order.created, for pre-orders
+// - order.preorder.released   When the job releases a pre-order, next to
+//                             order.status.pending
+//
+// METRICS:
+// - preorders_created_total
+// - preorders_released_total
+// - preorders_waiting                 Pre-orders not yet released
+// - preorder_release_lag_seconds      Time between the release date and the
+//                                     release of an order
+// =============================================================================
+
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// preorderReleaseBatch is the number of due pre-orders released per run
+const preorderReleaseBatch = 500
+
+var (
+	// Counter: Pre-orders created
+	preordersCreatedTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "preorders_created_total",
+			Help: "Total number of orders created as pre-orders",
+		},
+	)
+
+	// Counter: Pre-orders released
+	preordersReleasedTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "preorders_released_total",
+			Help: "Total number of pre-orders released to pending on their release date",
+		},
+	)
+
+	// Gauge: Pre-orders waiting for their release date
+	preordersWaiting = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "preorders_waiting",
+			Help: "Number of pre-orders waiting for their release date",
+		},
+	)
+
+	// Histogram: Delay between release date and release
+	preorderReleaseLag = prometheus.NewHistogram(
+		prometheus.HistogramOpts{
+			Name:    "preorder_release_lag_seconds",
+			Help:    "Time between the start of a pre-order's release date (UTC) and its release",
+			Buckets: []float64{60, 300, 900, 1800, 3600, 4 * 3600, 12 * 3600, 24 * 3600, 3 * 24 * 3600},
+		},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(preordersCreatedTotal)
+	prometheus.MustRegister(preordersReleasedTotal)
+	prometheus.MustRegister(preordersWaiting)
+	prometheus.MustRegister(preorderReleaseLag)
+}
+
+// orderReleaseDate returns the latest release date of the items that are
+// not available yet, or "" if every item is available on day
+func orderReleaseDate(items []OrderItemRequest, day time.Time) string {
+	today := day.UTC().Format("2006-01-02")
+	latest := ""
+	for _, item := range items {
+		// Dates are validated YYYY-MM-DD, so they compare as strings
+		if item.ReleaseDate > today && item.ReleaseDate > latest {
+			latest = item.ReleaseDate
+		}
+	}
+	return latest
+}
+
+// initialOrderStatus is the status a new order is created in
+func initialOrderStatus(releaseDate string) string {
+	if releaseDate != "" {
+		return "preorder"
+	}
+	return "pending"
+}
+
+// releasePreorders moves the pre-orders whose release date has come to
+// pending
+func (a *App) releasePreorders(ctx context.Context) error {
+	var released []string
+	var releaseDates []time.Time
+	var err error
+	if eventSourced() {
+		released, releaseDates, err = a.releasePreorderStreams(ctx)
+	} else {
+		released, releaseDates, err = a.releasePreorderRows(ctx)
+	}
+
+	now := time.Now()
+	for i := range released {
+		preordersReleasedTotal.Inc()
+		preorderReleaseLag.Observe(now.Sub(releaseDates[i]).Seconds())
+	}
+	if len(released) > 0 {
+		logInfoContext(ctx, "Pre-orders released", map[string]interface{}{
+			"released": len(released),
+		})
+	}
+	if err != nil {
+		return err
+	}
+
+	var waiting int
+	if err := a.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM orders WHERE status = 'preorder'`).Scan(&waiting); err != nil {
+		return err
+	}
+	preordersWaiting.Set(float64(waiting))
+	return nil
+}
+
+// releasePreorderRows releases due pre-orders in crud mode
+func (a *App) releasePreorderRows(ctx context.Context) ([]string, []time.Time, error) {
+	tx, err := a.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, `
+		UPDATE orders SET status = 'pending', updated_at = NOW()
+		WHERE id IN (
+			SELECT id FROM orders
+			WHERE status = 'preorder' AND release_date <= $2
+			ORDER BY release_date
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id, release_date
+	`, preorderReleaseBatch, time.Now().UTC().Format("2006-01-02"))
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	var ids []string
+	var dates []time.Time
+	for rows.Next() {
+		var id string
+		var date time.Time
+		if err := rows.Scan(&id, &date); err != nil {
+			return nil, nil, err
+		}
+		ids = append(ids, id)
+		dates = append(dates, date)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	for _, id := range ids {
+		if err := saveToOutboxTx(ctx, tx, preorderReleasedEvents(id)...); err != nil {
+			return nil, nil, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, nil, err
+	}
+	if len(ids) > 0 {
+		wakeOutboxRelay()
+	}
+	return ids, dates, nil
+}
+
+// preorderReleasedEvents are the events of a released pre-order
+func preorderReleasedEvents(id string) []orderEvent {
+	return []orderEvent{newOrderEvent("order.preorder.released", id), newOrderEvent("order.status.pending", id)}
+}
+
+// releasePreorderStreams releases due pre-orders in eventsourced mode. An
+// order released by another instance in the meantime is skipped.
+func (a *App) releasePreorderStreams(ctx context.Context) ([]string, []time.Time, error) {
+	rows, err := a.db.QueryContext(ctx, `
+		SELECT id, release_date FROM orders
+		WHERE status = 'preorder' AND release_date <= $2
+		ORDER BY release_date
+		LIMIT $1
+	`, preorderReleaseBatch, time.Now().UTC().Format("2006-01-02"))
+	if err != nil {
+		return nil, nil, err
+	}
+	var due []string
+	var dueDates []time.Time
+	for rows.Next() {
+		var id string
+		var date time.Time
+		if err := rows.Scan(&id, &date); err != nil {
+			rows.Close()
+			return nil, nil, err
+		}
+		due = append(due, id)
+		dueDates = append(dueDates, date)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	var ids []string
+	var dates []time.Time
+	for i, id := range due {
+		ok, err := a.changeOrderStatus(withOutboxEvents(ctx, preorderReleasedEvents(id)...), id, "pending", "preorder")
+		if err != nil {
+			return ids, dates, err
+		}
+		if ok {
+			ids = append(ids, id)
+			dates = append(dates, dueDates[i])
+		}
+	}
+	return ids, dates, nil
+}
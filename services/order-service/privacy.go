@@ -0,0 +1,637 @@
+// =============================================================================
+// CUSTOMER DATA REQUESTS (GDPR)
+// =============================================================================
+// Customers can ask for a copy of their data (right of access) or for it to
+// be erased (right to erasure). Both run asynchronously as data requests:
+//
+//   GET    /api/v1/customers/:id/data-export    Queue an export (202 Accepted)
+//   DELETE /api/v1/customers/:id/data           Queue an erasure (202 Accepted)
+//   GET    /api/v1/customers/:id/data-requests  The customer's data requests
+//   GET    /api/v1/customers/:id/data-requests/:request_id
+//                                               Status of one request
+//   GET    /api/v1/customers/:id/data-requests/:request_id/download
+//                                               The finished export
+//
+// EXPORT:
+// A JSON document with the customer's orders and items, the event streams
+// of those orders (see eventstore.go), the stored timezone and the
+// customer's earlier data requests. It is written to EXPORT_DIR and deleted
+// after EXPORT_RETENTION, like order exports (see exports.go). Asking again
+// while an export is queued returns the queued one.
+//
+// ERASURE:
+// Personal data is anonymized in one transaction, keeping everything that
+// financial figures are built from (amounts, items, statuses, dates):
+// - orders            Name and email replaced with placeholders, shipping
+//                     address, notes and gift message removed
+// - order_events and  The same fields in every event and snapshot of the
+//   order_snapshots   customer's orders
+// - customer_timezones  Deleted
+// - outbound_deliveries Notifications about the orders deleted
+// - Earlier exports   Files deleted, requests marked expired
+// Order items, the stats rollups and daily summaries hold no personal data
+// and are untouched. The search mirror (see search.go) is refreshed right
+// after, and a customer.data_erased event tells other services to follow.
+//
+// NOT COVERED: bulk order exports expire on their own after
+// EXPORT_RETENTION, and object storage archives (see archive.go) are
+// immutable and must be expired by the bucket's lifecycle rules. Order logs
+// carry the customer ID only, never the name or email.
+//
+// AUDIT:
+// Every request stays in privacy_requests with who asked (X-Requested-By
+// header, otherwise the client IP), the reason given, when it ran and how
+// many orders it touched, and is logged with "audit": true. The audit
+// record holds the customer ID only, so it survives the erasure it records.
+// =============================================================================
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	dataRequestExport  = "export"
+	dataRequestErasure = "erasure"
+
+	// Placeholders for erased personal data
+	erasedName  = "[erased]"
+	erasedEmail = "erased@example.invalid"
+)
+
+var (
+	// privacyWake wakes the data request worker when a request is queued
+	privacyWake = make(chan struct{}, 1)
+
+	// privacyWG tracks the running data request worker
+	privacyWG sync.WaitGroup
+
+	// Counter: Data requests finished, by kind and result
+	privacyRequestsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "privacy_requests_total",
+			Help: "Total number of customer data requests finished",
+		},
+		[]string{"kind", "result"},
+	)
+
+	// Counter: Orders anonymized by erasures
+	privacyOrdersErasedTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "privacy_orders_erased_total",
+			Help: "Total number of orders anonymized by customer data erasures",
+		},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(privacyRequestsTotal)
+	prometheus.MustRegister(privacyOrdersErasedTotal)
+}
+
+// DataRequest is the JSON view of a customer data request
+type DataRequest struct {
+	ID             string     `json:"id"`
+	CustomerID     string     `json:"customer_id"`
+	Kind           string     `json:"kind"`
+	Status         string     `json:"status"`
+	RequestedBy    string     `json:"requested_by"`
+	Reason         string     `json:"reason,omitempty"`
+	OrdersAffected int64      `json:"orders_affected"`
+	Error          string     `json:"error,omitempty"`
+	DownloadURL    string     `json:"download_url,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
+	StartedAt      *time.Time `json:"started_at,omitempty"`
+	CompletedAt    *time.Time `json:"completed_at,omitempty"`
+	ExpiresAt      *time.Time `json:"expires_at,omitempty"`
+
+	filePath string
+}
+
+// CustomerDataExport is the document produced by a data export
+type CustomerDataExport struct {
+	CustomerID   string        `json:"customer_id"`
+	GeneratedAt  time.Time     `json:"generated_at"`
+	Timezone     string        `json:"timezone,omitempty"`
+	Orders       []Order       `json:"orders"`
+	OrderEvents  []StoredEvent `json:"order_events"`
+	DataRequests []DataRequest `json:"data_requests"`
+}
+
+// startPrivacyWorker starts the data request worker and registers its
+// shutdown hook
+func (a *App) startPrivacyWorker(pollInterval time.Duration) {
+	privacyWG.Add(1)
+	go func() {
+		defer privacyWG.Done()
+		ticker := time.NewTicker(pollInterval)
+		defer ticker.Stop()
+
+		for {
+			for a.runNextDataRequest(backgroundCtx) {
+			}
+
+			select {
+			case <-backgroundCtx.Done():
+				return
+			case <-privacyWake:
+			case <-ticker.C:
+				a.cleanupDataExports(backgroundCtx)
+			}
+		}
+	}()
+
+	onShutdown(phaseJobs, "privacy-worker", func(ctx context.Context) error {
+		done := make(chan struct{})
+		go func() {
+			privacyWG.Wait()
+			close(done)
+		}()
+		select {
+		case <-done:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	})
+}
+
+// runNextDataRequest claims and runs one queued data request. It returns
+// false when there was nothing to do.
+func (a *App) runNextDataRequest(ctx context.Context) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+
+	var req DataRequest
+	err := a.db.QueryRowContext(ctx, `
+		UPDATE privacy_requests
+		SET status = 'running', started_at = NOW()
+		WHERE id = (
+			SELECT id FROM privacy_requests
+			WHERE status = 'pending'
+			   OR (status = 'running' AND started_at < NOW() - make_interval(secs => $1))
+			ORDER BY created_at
+			LIMIT 1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id, customer_id, kind
+	`, exportStaleAfter.Seconds()).Scan(&req.ID, &req.CustomerID, &req.Kind)
+	if err == sql.ErrNoRows {
+		return false
+	}
+	if err != nil {
+		logErrorContext(ctx, "Failed to claim data request", map[string]interface{}{
+			"error": err.Error(),
+		})
+		return false
+	}
+
+	var affected int64
+	var path string
+	if req.Kind == dataRequestErasure {
+		affected, err = a.eraseCustomerData(ctx, req.CustomerID)
+	} else {
+		path = filepath.Join(exportDir, "customer-data-"+req.ID+".json")
+		affected, err = a.writeCustomerDataExport(ctx, req.CustomerID, path)
+	}
+
+	switch {
+	case err != nil && ctx.Err() != nil:
+		os.Remove(path)
+		a.db.Exec(`UPDATE privacy_requests SET status = 'pending' WHERE id = $1`, req.ID)
+	case err != nil:
+		os.Remove(path)
+		privacyRequestsTotal.WithLabelValues(req.Kind, "failed").Inc()
+		a.db.Exec(`
+			UPDATE privacy_requests SET status = 'failed', error = $1, completed_at = NOW()
+			WHERE id = $2
+		`, err.Error(), req.ID)
+		logErrorContext(ctx, "Customer data request failed", map[string]interface{}{
+			"audit":           true,
+			"data_request_id": req.ID,
+			"customer_id":     req.CustomerID,
+			"kind":            req.Kind,
+			"error":           err.Error(),
+		})
+	default:
+		privacyRequestsTotal.WithLabelValues(req.Kind, "completed").Inc()
+		var expires interface{}
+		if path != "" {
+			expires = time.Now().Add(exportRetention)
+		}
+		a.db.Exec(`
+			UPDATE privacy_requests
+			SET status = 'completed', orders_affected = $1, file_path = NULLIF($2, ''),
+			    expires_at = $3, completed_at = NOW()
+			WHERE id = $4
+		`, affected, path, expires, req.ID)
+		logInfoContext(ctx, "Customer data request completed", map[string]interface{}{
+			"audit":           true,
+			"data_request_id": req.ID,
+			"customer_id":     req.CustomerID,
+			"kind":            req.Kind,
+			"orders_affected": affected,
+		})
+	}
+	return true
+}
+
+// eraseCustomerData anonymizes a customer's personal data and returns the
+// number of orders it touched
+func (a *App) eraseCustomerData(ctx context.Context, customerID string) (int64, error) {
+	tx, err := a.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, `
+		UPDATE orders
+		SET customer_name = $2, customer_email = $3, shipping_address = NULL, notes = NULL,
+		    gift_message = NULL, shipping_address_id = NULL, updated_at = NOW()
+		WHERE customer_id = $1
+	`, customerID, erasedName, erasedEmail)
+	if err != nil {
+		return 0, fmt.Errorf("failed to anonymize orders: %w", err)
+	}
+	orders, _ := result.RowsAffected()
+
+	// jsonb_set with create_missing = false only replaces fields the event
+	// or snapshot actually has
+	for _, table := range []struct{ name, column string }{
+		{"order_events", "data"},
+		{"order_snapshots", "state"},
+	} {
+		_, err := tx.ExecContext(ctx, fmt.Sprintf(`
+			UPDATE %[1]s
+			SET %[2]s = jsonb_set(jsonb_set(%[2]s - 'shipping_address' - 'shipping_address_id' - 'notes' #- '{gift,message}',
+				'{customer_name}', to_jsonb($2::text), false),
+				'{customer_email}', to_jsonb($3::text), false)
+			WHERE order_id IN (SELECT id FROM orders WHERE customer_id = $1)
+		`, table.name, table.column), customerID, erasedName, erasedEmail)
+		if err != nil {
+			return 0, fmt.Errorf("failed to anonymize %s: %w", table.name, err)
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+		DELETE FROM customer_timezones WHERE customer_id = $1
+	`, customerID); err != nil {
+		return 0, fmt.Errorf("failed to delete timezone: %w", err)
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+		DELETE FROM outbound_deliveries d
+		USING orders o
+		WHERE o.customer_id = $1 AND strpos(d.payload, o.id::text) > 0
+	`, customerID); err != nil {
+		return 0, fmt.Errorf("failed to delete deliveries: %w", err)
+	}
+
+	// Earlier exports hold the data that is being erased
+	rows, err := tx.QueryContext(ctx, `
+		UPDATE privacy_requests SET file_path = NULL, status = 'expired'
+		WHERE customer_id = $1 AND kind = 'export' AND status = 'completed'
+		RETURNING file_path
+	`, customerID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to expire exports: %w", err)
+	}
+	var files []string
+	for rows.Next() {
+		var path sql.NullString
+		if rows.Scan(&path) == nil && path.Valid {
+			files = append(files, path.String)
+		}
+	}
+	rows.Close()
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	for _, path := range files {
+		os.Remove(path)
+	}
+	privacyOrdersErasedTotal.Add(float64(orders))
+
+	// Don't wait for the next search-index run to drop the old fields
+	if searchEnabled() {
+		if err := a.indexOrders(ctx); err != nil {
+			logWarnContext(ctx, "Failed to refresh search index after erasure", map[string]interface{}{
+				"customer_id": customerID,
+				"error":       err.Error(),
+			})
+		}
+	}
+
+	body, _ := json.Marshal(map[string]interface{}{
+		"event":       "customer.data_erased",
+		"customer_id": customerID,
+		"orders":      orders,
+		"timestamp":   time.Now().Format(time.RFC3339),
+	})
+	a.enqueueEvent(orderEvent{RoutingKey: "customer.data_erased", Body: body})
+
+	return orders, nil
+}
+
+// writeCustomerDataExport writes everything stored about a customer to a
+// JSON file and returns the number of orders in it
+func (a *App) writeCustomerDataExport(ctx context.Context, customerID, path string) (int64, error) {
+	export := CustomerDataExport{
+		CustomerID:   customerID,
+		GeneratedAt:  time.Now().UTC(),
+		Orders:       []Order{},
+		OrderEvents:  []StoredEvent{},
+		DataRequests: []DataRequest{},
+	}
+
+	var ids []string
+	rows, err := a.db.QueryContext(ctx, `
+		SELECT id FROM orders WHERE customer_id = $1 ORDER BY created_at
+	`, customerID)
+	if err != nil {
+		return 0, err
+	}
+	for rows.Next() {
+		var id string
+		if rows.Scan(&id) == nil {
+			ids = append(ids, id)
+		}
+	}
+	rows.Close()
+
+	for _, id := range ids {
+		o, err := a.fetchOrder(ctx, id)
+		if err == sql.ErrNoRows {
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+		export.Orders = append(export.Orders, *o)
+	}
+
+	rows, err = a.db.QueryContext(ctx, `
+		SELECT e.order_id, e.version, e.type, e.data, e.created_at
+		FROM order_events e JOIN orders o ON o.id = e.order_id
+		WHERE o.customer_id = $1
+		ORDER BY e.order_id, e.version
+	`, customerID)
+	if err != nil {
+		return 0, err
+	}
+	for rows.Next() {
+		var e StoredEvent
+		if err := rows.Scan(&e.OrderID, &e.Version, &e.Type, &e.Data, &e.CreatedAt); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		export.OrderEvents = append(export.OrderEvents, e)
+	}
+	rows.Close()
+
+	a.db.QueryRowContext(ctx, `
+		SELECT timezone FROM customer_timezones WHERE customer_id = $1
+	`, customerID).Scan(&export.Timezone)
+
+	if export.DataRequests, err = a.listDataRequests(ctx, customerID); err != nil {
+		return 0, err
+	}
+
+	data, err := json.MarshalIndent(export, "", "  ")
+	if err != nil {
+		return 0, err
+	}
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		return 0, err
+	}
+	return int64(len(export.Orders)), nil
+}
+
+// cleanupDataExports deletes the files of expired data exports
+func (a *App) cleanupDataExports(ctx context.Context) {
+	rows, err := a.db.QueryContext(ctx, `
+		UPDATE privacy_requests SET status = 'expired', file_path = NULL
+		WHERE status = 'completed' AND expires_at < NOW()
+		RETURNING file_path
+	`)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var path sql.NullString
+		if rows.Scan(&path) == nil && path.Valid {
+			os.Remove(path.String)
+		}
+	}
+}
+
+// =============================================================================
+// DATA REQUEST HANDLERS
+// =============================================================================
+
+// requestCustomerDataExport queues an export of a customer's data
+func (a *App) requestCustomerDataExport(c *gin.Context) {
+	a.queueDataRequest(c, dataRequestExport)
+}
+
+// requestCustomerDataErasure queues an erasure of a customer's data
+func (a *App) requestCustomerDataErasure(c *gin.Context) {
+	a.queueDataRequest(c, dataRequestErasure)
+}
+
+// queueDataRequest records a data request and wakes the worker. A request
+// of the same kind still waiting for the customer is returned instead of
+// queueing another.
+func (a *App) queueDataRequest(c *gin.Context, kind string) {
+	customerID := c.Param("id")
+	if !uuidPattern.MatchString(customerID) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": tr(c, "Invalid customer ID")})
+		return
+	}
+	requestedBy := c.GetHeader("X-Requested-By")
+	if requestedBy == "" {
+		requestedBy = c.ClientIP()
+	}
+	ctx := c.Request.Context()
+
+	var id string
+	err := a.db.QueryRowContext(ctx, `
+		SELECT id FROM privacy_requests
+		WHERE customer_id = $1 AND kind = $2 AND status IN ('pending', 'running')
+		ORDER BY created_at LIMIT 1
+	`, customerID, kind).Scan(&id)
+	if err == sql.ErrNoRows {
+		err = a.db.QueryRowContext(ctx, `
+			INSERT INTO privacy_requests (customer_id, kind, requested_by, reason)
+			VALUES ($1, $2, $3, NULLIF($4, ''))
+			RETURNING id
+		`, customerID, kind, requestedBy, c.Query("reason")).Scan(&id)
+		if err == nil {
+			logInfoContext(c.Request.Context(), "Customer data request queued", map[string]interface{}{
+				"audit":           true,
+				"data_request_id": id,
+				"customer_id":     customerID,
+				"kind":            kind,
+				"requested_by":    requestedBy,
+			})
+		}
+	}
+	if err != nil {
+		logErrorContext(c.Request.Context(), "Failed to queue data request", map[string]interface{}{
+			"customer_id": customerID,
+			"kind":        kind,
+			"error":       err.Error(),
+		})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": tr(c, "Database error")})
+		return
+	}
+
+	select {
+	case privacyWake <- struct{}{}:
+	default:
+	}
+
+	req, err := a.loadDataRequest(ctx, customerID, id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": tr(c, "Database error")})
+		return
+	}
+	c.Header("Location", "/api/v1/customers/"+customerID+"/data-requests/"+id)
+	c.JSON(http.StatusAccepted, req)
+}
+
+// getCustomerDataRequests lists a customer's data requests
+func (a *App) getCustomerDataRequests(c *gin.Context) {
+	customerID := c.Param("id")
+	if !uuidPattern.MatchString(customerID) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": tr(c, "Invalid customer ID")})
+		return
+	}
+	requests, err := a.listDataRequests(c.Request.Context(), customerID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": tr(c, "Database error")})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"customer_id": customerID, "requests": requests})
+}
+
+// getCustomerDataRequest reports the status of a data request
+func (a *App) getCustomerDataRequest(c *gin.Context) {
+	req, err := a.loadDataRequest(c.Request.Context(), c.Param("id"), c.Param("request_id"))
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": tr(c, "Data request not found")})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": tr(c, "Database error")})
+		return
+	}
+	c.JSON(http.StatusOK, req)
+}
+
+// downloadCustomerDataExport serves the file of a completed data export
+func (a *App) downloadCustomerDataExport(c *gin.Context) {
+	req, err := a.loadDataRequest(c.Request.Context(), c.Param("id"), c.Param("request_id"))
+	if err == sql.ErrNoRows || (err == nil && req.Kind != dataRequestExport) {
+		c.JSON(http.StatusNotFound, gin.H{"error": tr(c, "Data request not found")})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": tr(c, "Database error")})
+		return
+	}
+
+	switch req.Status {
+	case "completed":
+	case "expired":
+		c.JSON(http.StatusGone, gin.H{"error": tr(c, "Export has expired")})
+		return
+	default:
+		c.JSON(http.StatusConflict, gin.H{"error": tr(c, "Export is not ready"), "status": req.Status})
+		return
+	}
+
+	if _, err := os.Stat(req.filePath); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": tr(c, "Export file is not available on this instance")})
+		return
+	}
+	c.Header("Content-Type", "application/json")
+	c.FileAttachment(req.filePath, "customer-"+req.CustomerID+".json")
+}
+
+// dataRequestColumns are the columns scanned by scanDataRequest
+const dataRequestColumns = `
+	id, customer_id, kind, status, requested_by, reason, orders_affected, error, file_path,
+	created_at, started_at, completed_at, expires_at`
+
+// rowScanner is a *sql.Row or *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanDataRequest scans a row of dataRequestColumns
+func scanDataRequest(row rowScanner) (*DataRequest, error) {
+	var req DataRequest
+	var reason, errMsg, path sql.NullString
+	err := row.Scan(&req.ID, &req.CustomerID, &req.Kind, &req.Status, &req.RequestedBy,
+		&reason, &req.OrdersAffected, &errMsg, &path,
+		&req.CreatedAt, &req.StartedAt, &req.CompletedAt, &req.ExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+	req.Reason = reason.String
+	req.Error = errMsg.String
+	req.filePath = path.String
+	if req.Kind == dataRequestExport && req.Status == "completed" {
+		req.DownloadURL = "/api/v1/customers/" + req.CustomerID + "/data-requests/" + req.ID + "/download"
+	}
+	return &req, nil
+}
+
+// loadDataRequest reads one of a customer's data requests
+func (a *App) loadDataRequest(ctx context.Context, customerID, id string) (*DataRequest, error) {
+	return scanDataRequest(a.db.QueryRowContext(ctx, `
+		SELECT `+dataRequestColumns+`
+		FROM privacy_requests WHERE id::text = $1 AND customer_id::text = $2
+	`, id, customerID))
+}
+
+// listDataRequests reads a customer's data requests, newest first
+func (a *App) listDataRequests(ctx context.Context, customerID string) ([]DataRequest, error) {
+	rows, err := a.db.QueryContext(ctx, `
+		SELECT `+dataRequestColumns+`
+		FROM privacy_requests WHERE customer_id = $1
+		ORDER BY created_at DESC
+	`, customerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	requests := []DataRequest{}
+	for rows.Next() {
+		req, err := scanDataRequest(rows)
+		if err != nil {
+			return nil, err
+		}
+		requests = append(requests, *req)
+	}
+	return requests, rows.Err()
+}
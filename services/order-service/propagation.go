@@ -0,0 +1,247 @@
+//go:build !otel
+
+// =============================================================================
+// TRACE CONTEXT PROPAGATION
+// =============================================================================
+// Carries traces across service boundaries. Incoming requests continue the
+// trace of their caller, and every call made with the shared HTTP client
+// (inventory, payment, user and notification service) tells the callee
+// which span it belongs to. Event publishes carry the same headers in their
+// AMQP message headers.
+//
+//   OTEL_PROPAGATORS   Header formats, comma separated (default
+//                      "tracecontext,b3multi"):
+//                      - tracecontext   W3C traceparent and tracestate
+//                      - b3             Zipkin single "b3" header
+//                      - b3multi        Zipkin X-B3-TraceId, X-B3-SpanId,
+//                                       X-B3-Sampled
+//                      - none           No propagation
+//
+// Every listed format is written; on extraction the first one present wins,
+// in the order listed. The caller's sampling decision is kept, so a trace
+// is either complete or missing from every service. With tracing disabled
+// (no OTEL_EXPORTER_OTLP_ENDPOINT) the incoming context is passed on as is,
+// so the services around this one still end up in the same trace.
+// =============================================================================
+
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+const (
+	propagatorTraceContext = "tracecontext"
+	propagatorB3           = "b3"
+	propagatorB3Multi      = "b3multi"
+)
+
+// propagators are the header formats trace context is read from and
+// written in
+var propagators = []string{propagatorTraceContext, propagatorB3Multi}
+
+// remoteSpanKey stores the span context of the caller in a context
+type remoteSpanKey struct{}
+
+// setPropagators validates and applies OTEL_PROPAGATORS
+func setPropagators(list string) error {
+	var names []string
+	for _, name := range strings.Split(list, ",") {
+		switch name = strings.TrimSpace(strings.ToLower(name)); name {
+		case "", "none":
+		case propagatorTraceContext, propagatorB3, propagatorB3Multi:
+			names = append(names, name)
+		default:
+			return fmt.Errorf("unknown OTEL_PROPAGATORS entry %q (expected tracecontext, b3, b3multi or none)", name)
+		}
+	}
+	propagators = names
+	return nil
+}
+
+// contextWithRemoteSpan returns a context continuing the trace of a caller
+func contextWithRemoteSpan(ctx context.Context, sc spanContext) context.Context {
+	return context.WithValue(ctx, remoteSpanKey{}, sc)
+}
+
+// remoteSpanFromContext returns the caller's span context, if any
+func remoteSpanFromContext(ctx context.Context) (spanContext, bool) {
+	sc, ok := ctx.Value(remoteSpanKey{}).(spanContext)
+	return sc, ok
+}
+
+// propagatedSpan returns the span context to hand on to the services called
+// with a context: its current span, or the caller's span while this
+// service records none
+func propagatedSpan(ctx context.Context) (spanContext, bool) {
+	if span := spanFromContext(ctx); span != nil {
+		return span.sc, true
+	}
+	return remoteSpanFromContext(ctx)
+}
+
+// extractTraceContext reads the caller's span context from headers
+func extractTraceContext(get func(key string) string) (spanContext, bool) {
+	for _, name := range propagators {
+		var sc spanContext
+		var ok bool
+		switch name {
+		case propagatorTraceContext:
+			if sc, ok = parseTraceparent(get("traceparent")); ok {
+				sc.state = get("tracestate")
+			}
+		case propagatorB3:
+			sc, ok = parseB3(get("b3"))
+		case propagatorB3Multi:
+			sc, ok = parseB3Multi(get)
+		}
+		if ok {
+			return sc, true
+		}
+	}
+	return spanContext{}, false
+}
+
+// injectTraceContext writes the span context of a context to headers
+func injectTraceContext(ctx context.Context, set func(key, value string)) {
+	sc, ok := propagatedSpan(ctx)
+	if !ok {
+		return
+	}
+	traceID, spanID := hex.EncodeToString(sc.traceID[:]), hex.EncodeToString(sc.spanID[:])
+	for _, name := range propagators {
+		switch name {
+		case propagatorTraceContext:
+			flags := "00"
+			if sc.sampled {
+				flags = "01"
+			}
+			set("traceparent", "00-"+traceID+"-"+spanID+"-"+flags)
+			if sc.state != "" {
+				set("tracestate", sc.state)
+			}
+		case propagatorB3:
+			value := traceID + "-" + spanID
+			if !sc.deferred {
+				value += "-" + b3Sampled(sc.sampled)
+			}
+			set("b3", value)
+		case propagatorB3Multi:
+			set("X-B3-TraceId", traceID)
+			set("X-B3-SpanId", spanID)
+			if !sc.deferred {
+				set("X-B3-Sampled", b3Sampled(sc.sampled))
+			}
+		}
+	}
+}
+
+// parseTraceparent parses a W3C traceparent header
+// (version-traceid-parentid-flags). Later versions are read as far as
+// version 00 goes, as the specification asks.
+func parseTraceparent(value string) (spanContext, bool) {
+	var sc spanContext
+	value = strings.TrimSpace(value)
+	if len(value) < 55 || value[2] != '-' || value[35] != '-' || value[52] != '-' {
+		return sc, false
+	}
+	version := value[:2]
+	if version == "ff" || (version == "00" && len(value) != 55) || (len(value) > 55 && value[55] != '-') {
+		return sc, false
+	}
+	if _, err := hex.DecodeString(version); err != nil {
+		return sc, false
+	}
+	flags, err := hex.DecodeString(value[53:55])
+	if err != nil || !decodeID(sc.traceID[:], value[3:35]) || !decodeID(sc.spanID[:], value[36:52]) {
+		return sc, false
+	}
+	sc.sampled = flags[0]&1 == 1
+	return sc, true
+}
+
+// parseB3 parses a Zipkin single b3 header
+// (traceid-spanid[-sampled[-parentspanid]]). A bare sampling decision
+// ("0" or "1") carries no context to continue.
+func parseB3(value string) (spanContext, bool) {
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	if len(parts) < 2 || len(parts) > 4 {
+		return spanContext{}, false
+	}
+	sampled := ""
+	if len(parts) > 2 {
+		sampled = parts[2]
+	}
+	return b3SpanContext(parts[0], parts[1], sampled, "")
+}
+
+// parseB3Multi parses the Zipkin X-B3-* headers
+func parseB3Multi(get func(key string) string) (spanContext, bool) {
+	return b3SpanContext(get("X-B3-TraceId"), get("X-B3-SpanId"), get("X-B3-Sampled"), get("X-B3-Flags"))
+}
+
+// b3SpanContext builds a span context from B3 fields. 64-bit trace IDs are
+// left-padded to 128 bits; a missing sampling decision is deferred to this
+// service.
+func b3SpanContext(traceID, spanID, sampled, flags string) (spanContext, bool) {
+	var sc spanContext
+	traceID, spanID = strings.TrimSpace(traceID), strings.TrimSpace(spanID)
+	if len(traceID) == 16 {
+		traceID = strings.Repeat("0", 16) + traceID
+	}
+	if !decodeID(sc.traceID[:], traceID) || !decodeID(sc.spanID[:], spanID) {
+		return sc, false
+	}
+	switch strings.ToLower(strings.TrimSpace(sampled)) {
+	case "1", "d", "true":
+		sc.sampled = true
+	case "0", "false":
+	default:
+		sc.deferred = true
+	}
+	if strings.TrimSpace(flags) == "1" {
+		sc.sampled, sc.deferred = true, false
+	}
+	return sc, true
+}
+
+// decodeID decodes a hex trace or span ID into id. All-zero IDs are
+// invalid.
+func decodeID(id []byte, value string) bool {
+	if len(value) != 2*len(id) {
+		return false
+	}
+	if _, err := hex.Decode(id, []byte(value)); err != nil {
+		return false
+	}
+	for _, b := range id {
+		if b != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// b3Sampled formats a sampling decision for B3
+func b3Sampled(sampled bool) string {
+	if sampled {
+		return "1"
+	}
+	return "0"
+}
+
+// injectRequest returns a copy of an outgoing request carrying the trace
+// context of its context, or the request itself if there is none
+func injectRequest(req *http.Request) *http.Request {
+	if _, ok := propagatedSpan(req.Context()); !ok || len(propagators) == 0 {
+		return req
+	}
+	// A RoundTripper must not modify the request it was given
+	out := req.Clone(req.Context())
+	injectTraceContext(out.Context(), out.Header.Set)
+	return out
+}
@@ -0,0 +1,385 @@
+// =============================================================================
+// ASYNC EVENT PUBLISHING
+// =============================================================================
+// Publishing to RabbitMQ used to happen inside the request handler, so broker
+// latency showed up directly in createOrder's p99. Events now go through a
+// bounded worker pool:
+//
+//   handler --> buffered channel --> N publisher workers --> RabbitMQ
+//                     |
+//                     +-- (buffer full) --> outbox table --> outbox relay
+//
+// - publishOrderEvent never blocks the request path
+// - The order API's writes bypass the buffer: their events are stored in
+//   the outbox in the write's transaction (see outbox.go)
+// - When the buffer is full, events overflow to the outbox table
+//   (see outbox.go) instead of being dropped
+// - The buffer length is exposed as event_publish_queue_depth
+// - On shutdown the buffer is flushed; anything left goes to the outbox
+// - Events are encoded as CloudEvents, JSON or protobuf when published (see
+//   eventformat.go and cloudevents.go)
+// - Events of gold and platinum orders use a priority lane that workers
+//   drain first, and are published with a higher AMQP priority (see tiers.go)
+//
+// PUBLISHER CONFIRMS:
+// The channel is in confirm mode, and a worker only counts an event as
+// published once RabbitMQ acknowledged it. A nacked event is published
+// again up to PUBLISH_NACK_RETRIES times (default 2); an event that is
+// still nacked, fails to send or isn't confirmed within
+// PUBLISH_CONFIRM_TIMEOUT (default 5s) goes to the outbox. Each worker
+// waits for its confirm, so EVENT_PUBLISH_WORKERS also bounds the events
+// in flight.
+//
+// METRICS:
+// - rabbitmq_events_published_total{routing_key}           Confirmed events
+// - rabbitmq_publish_failures_total{routing_key,reason}     Failed attempts:
+//     unavailable (no channel or simulated outage), error (send failed),
+//     nack, timeout (no confirm in time)
+// - rabbitmq_publish_duration_seconds{routing_key}         Time from publish
+//     to confirm, retries included
+// =============================================================================
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// publisherLog is the logger of the event publisher
+var publisherLog = newLogger("publisher")
+
+// orderEvent is a message waiting to be published to the orders exchange
+type orderEvent struct {
+	RoutingKey string
+	OrderID    string // empty for events not about a single order
+	Body       []byte
+
+	// Tier is the customer tier of the order, "" until resolved
+	Tier string
+
+	// Order is the order as it was when the event was written to the
+	// outbox (see cloudevents.go), nil for events not written there
+	Order json.RawMessage
+
+	// queuedAt is when the event entered the publish buffer
+	queuedAt time.Time
+}
+
+var (
+	// eventQueue feeds the publisher workers, eventPriorityQueue holds
+	// the events of gold and platinum orders, which are published first
+	eventQueue         chan orderEvent
+	eventPriorityQueue chan orderEvent
+
+	// eventQueueMu guards eventQueue against sends after it is closed
+	eventQueueMu     sync.RWMutex
+	eventQueueClosed bool
+
+	// publisherWG tracks running publisher workers
+	publisherWG sync.WaitGroup
+
+	// Gauge: Events waiting in the publish buffer
+	eventPublishQueueDepth = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "event_publish_queue_depth",
+			Help: "Number of events waiting in the in-memory publish buffer",
+		},
+	)
+
+	// publishConfirmTimeout bounds the wait for a publisher confirm
+	publishConfirmTimeout = 5 * time.Second
+
+	// publishNackRetries is how often a nacked event is published again
+	publishNackRetries = 2
+
+	// Counter: Events confirmed by RabbitMQ
+	rabbitEventsPublishedTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "rabbitmq_events_published_total",
+			Help: "Total number of order events confirmed by RabbitMQ, by routing key",
+		},
+		[]string{"routing_key"},
+	)
+
+	// Counter: Failed publish attempts
+	rabbitPublishFailuresTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "rabbitmq_publish_failures_total",
+			Help: "Total number of failed order event publish attempts, by routing key and reason",
+		},
+		[]string{"routing_key", "reason"},
+	)
+
+	// Histogram: Time from publish to confirm
+	rabbitPublishDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "rabbitmq_publish_duration_seconds",
+			Help:    "Time to publish an order event until RabbitMQ confirmed or finally rejected it, by routing key",
+			Buckets: []float64{0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5},
+		},
+		[]string{"routing_key"},
+	)
+
+	// Counter: Events that overflowed to the outbox
+	eventPublishOverflowTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "event_publish_overflow_total",
+			Help: "Total number of events written to the outbox because the publish buffer was full",
+		},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(eventPublishQueueDepth)
+	prometheus.MustRegister(eventPublishOverflowTotal)
+	prometheus.MustRegister(rabbitEventsPublishedTotal)
+	prometheus.MustRegister(rabbitPublishFailuresTotal)
+	prometheus.MustRegister(rabbitPublishDuration)
+}
+
+// startEventPublishers starts the worker pool and registers its shutdown hook
+func (a *App) startEventPublishers(workers, bufferSize int) {
+	eventQueue = make(chan orderEvent, bufferSize)
+	eventPriorityQueue = make(chan orderEvent, bufferSize)
+
+	for i := 0; i < workers; i++ {
+		publisherWG.Add(1)
+		go func() {
+			defer publisherWG.Done()
+			for {
+				event, ok := nextQueuedEvent()
+				if !ok {
+					return
+				}
+				eventPublishQueueDepth.Set(float64(len(eventQueue) + len(eventPriorityQueue)))
+				if err := a.publishEvent(context.Background(), event); err != nil {
+					publisherLog.Error("Failed to publish order event, saving to outbox", "routing_key", event.RoutingKey, "order_id", event.OrderID, "error", err.Error())
+					a.saveToOutbox(event)
+				}
+			}
+		}()
+	}
+
+	onShutdown(phasePublishers, "event-publishers", a.stopEventPublishers)
+}
+
+// nextQueuedEvent returns the next buffered event, preferring the priority
+// lane. It returns false once both lanes are closed and empty.
+func nextQueuedEvent() (orderEvent, bool) {
+	select {
+	case event, ok := <-eventPriorityQueue:
+		if ok {
+			return event, true
+		}
+	default:
+	}
+
+	select {
+	case event, ok := <-eventPriorityQueue:
+		if ok {
+			return event, true
+		}
+		event, ok = <-eventQueue
+		return event, ok
+	case event, ok := <-eventQueue:
+		if ok {
+			return event, true
+		}
+		event, ok = <-eventPriorityQueue
+		return event, ok
+	}
+}
+
+// stopEventPublishers closes the buffer and waits for workers to drain it.
+// Events still buffered when ctx expires are moved to the outbox.
+func (a *App) stopEventPublishers(ctx context.Context) error {
+	eventQueueMu.Lock()
+	eventQueueClosed = true
+	close(eventQueue)
+	close(eventPriorityQueue)
+	eventQueueMu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		publisherWG.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		// Workers are still busy; persist what is left so nothing is lost
+		remaining := 0
+		for event, ok := nextQueuedEvent(); ok; event, ok = nextQueuedEvent() {
+			a.saveToOutbox(event)
+			remaining++
+		}
+		return fmt.Errorf("publish buffer not drained in time, %d events moved to outbox", remaining)
+	}
+}
+
+// newOrderEvent builds the event of something that happened to an order
+func newOrderEvent(eventType, orderID string) orderEvent {
+	body, _ := json.Marshal(orderEventBody{
+		Event:     eventType,
+		EventID:   uuid.NewString(),
+		OrderID:   orderID,
+		Timestamp: time.Now().Format(time.RFC3339),
+	})
+	return orderEvent{RoutingKey: eventType, OrderID: orderID, Body: []byte(body), Tier: cachedOrderTier(orderID)}
+}
+
+// publishOrderEvent queues an event for the orders exchange without
+// blocking. Writes of the order API store their events in the outbox
+// instead (see outbox.go).
+func (a *App) publishOrderEvent(eventType, orderID string) {
+	a.enqueueEvent(newOrderEvent(eventType, orderID))
+}
+
+// enqueueEvent hands an event to the workers, or to the outbox when the
+// buffer is full or the publishers are not running
+func (a *App) enqueueEvent(event orderEvent) {
+	eventQueueMu.RLock()
+	defer eventQueueMu.RUnlock()
+
+	if eventQueue != nil && !eventQueueClosed {
+		queue := eventQueue
+		if tierPriority(event.Tier) > 0 {
+			queue = eventPriorityQueue
+		}
+		event.queuedAt = time.Now()
+		select {
+		case queue <- event:
+			eventPublishQueueDepth.Set(float64(len(eventQueue) + len(eventPriorityQueue)))
+			return
+		default:
+		}
+	}
+
+	eventPublishOverflowTotal.Inc()
+	a.saveToOutbox(event)
+}
+
+// errBrokerUnavailable is returned by publishEvent when there is no
+// channel to publish on, as opposed to the event being refused
+var errBrokerUnavailable = errors.New("RabbitMQ channel not available")
+
+// publishEvent publishes an event to the orders exchange
+func (a *App) publishEvent(ctx context.Context, event orderEvent) error {
+	if a.publishChannel() == nil {
+		rabbitPublishFailuresTotal.WithLabelValues(event.RoutingKey, "unavailable").Inc()
+		return errBrokerUnavailable
+	}
+	if err := dependencyFault(ctx, "rabbitmq"); err != nil {
+		rabbitPublishFailuresTotal.WithLabelValues(event.RoutingKey, "unavailable").Inc()
+		return fmt.Errorf("%w: %v", errBrokerUnavailable, err)
+	}
+
+	// Events from the outbox and about orders created elsewhere don't
+	// know their tier yet
+	if event.Tier == "" && event.OrderID != "" {
+		event.Tier = a.orderTier(ctx, event.OrderID)
+	}
+	msg := a.encodeEvent(ctx, event)
+	if event.Tier != "" {
+		msg.Priority = tierPriority(event.Tier)
+		msg.Headers = amqp.Table{"customer_tier": event.Tier}
+	}
+
+	ctx, span := startSpan(ctx, "orders publish", spanKindProducer)
+	span.SetAttr("messaging.system", "rabbitmq")
+	span.SetAttr("messaging.destination.name", "orders")
+	span.SetAttr("messaging.rabbitmq.destination.routing_key", event.RoutingKey)
+	span.SetAttr("order.id", event.OrderID)
+	injectTraceContext(ctx, func(key, value string) {
+		if msg.Headers == nil {
+			msg.Headers = amqp.Table{}
+		}
+		msg.Headers[key] = value
+	})
+	err := a.publishConfirmed(ctx, event.RoutingKey, msg)
+	if err != nil {
+		span.SetError(err.Error())
+	}
+	span.End()
+	if err == nil && !event.queuedAt.IsZero() {
+		tier := event.Tier
+		if tier == "" {
+			tier = tierStandard
+		}
+		eventPublishDelay.WithLabelValues(tier).Observe(time.Since(event.queuedAt).Seconds())
+	}
+	return err
+}
+
+// publishConfirmed publishes a message to the orders exchange and waits for
+// RabbitMQ to confirm it, publishing it again when it is nacked
+func (a *App) publishConfirmed(ctx context.Context, routingKey string, msg amqp.Publishing) error {
+	start := time.Now()
+	defer func() {
+		rabbitPublishDuration.WithLabelValues(routingKey).Observe(time.Since(start).Seconds())
+	}()
+
+	for attempt := 0; ; attempt++ {
+		reason, err := a.publishOnce(ctx, routingKey, msg)
+		if err == nil {
+			rabbitEventsPublishedTotal.WithLabelValues(routingKey).Inc()
+			return nil
+		}
+		rabbitPublishFailuresTotal.WithLabelValues(routingKey, reason).Inc()
+		if reason != "nack" || attempt >= publishNackRetries {
+			return err
+		}
+
+		publisherLog.Warn("Order event nacked, publishing again", "routing_key", routingKey, "attempt", attempt+1)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Duration(attempt+1) * 100 * time.Millisecond):
+		}
+	}
+}
+
+// publishOnce publishes a message and waits for its confirm. Failures come
+// with their reason for rabbitmq_publish_failures_total.
+func (a *App) publishOnce(ctx context.Context, routingKey string, msg amqp.Publishing) (string, error) {
+	confirm, err := a.publishChannel().PublishWithDeferredConfirmWithContext(
+		ctx,
+		"orders",   // Exchange
+		routingKey, // Routing key
+		false,      // Mandatory
+		false,      // Immediate
+		msg,
+	)
+	if errors.Is(err, amqp.ErrClosed) {
+		return "unavailable", fmt.Errorf("%w: %v", errBrokerUnavailable, err)
+	}
+	if err != nil {
+		return "error", err
+	}
+	if confirm == nil {
+		// Channel not in confirm mode (e.g. one handed in through AppDeps)
+		return "", nil
+	}
+
+	waitCtx, cancel := context.WithTimeout(ctx, publishConfirmTimeout)
+	defer cancel()
+	acked, err := confirm.WaitContext(waitCtx)
+	if err != nil {
+		return "timeout", fmt.Errorf("no publisher confirm within %s: %w", publishConfirmTimeout, err)
+	}
+	if !acked {
+		return "nack", fmt.Errorf("order event nacked by RabbitMQ")
+	}
+	return "", nil
+}
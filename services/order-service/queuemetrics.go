@@ -0,0 +1,385 @@
+// =============================================================================
+// QUEUE DEPTH AND CONSUMER LAG
+// =============================================================================
+// Every QUEUE_METRICS_INTERVAL (default 15s, 0 disables) the queues
+// downstream of the orders exchange are polled, so a growing backlog shows
+// up in Grafana before the consumers fall over:
+//
+// - With RABBITMQ_MANAGEMENT_URL set (e.g. http://rabbitmq:15672), every
+//   queue bound to the orders exchange is found through the management
+//   API and reported in full. The API credentials are taken from the
+//   management URL, or else from RABBITMQ_URL, whose vhost is used too.
+// - Without it, the WATCHED_QUEUES are inspected with passive declares,
+//   which only tell ready messages and consumers.
+//
+// Polling runs in all and worker mode. Queues that disappear (or get
+// unbound) stop being reported. Simulated RabbitMQ outages fail the poll.
+//
+// METRICS:
+// - queue_messages_ready{queue}          Messages waiting for a consumer
+// - queue_messages_unacked{queue}        Messages delivered, not yet acked
+// - queue_consumers{queue}               Consumers attached to the queue
+// - queue_publish_rate{queue}            Messages published per second
+// - queue_deliver_rate{queue}            Messages delivered per second
+// - queue_consumer_lag_seconds{queue}    Time to work off the ready
+//                                        messages at the current deliver
+//                                        rate; missing while a backlog
+//                                        isn't consumed at all
+// - queue_poll_errors_total              Failed polls
+//
+// Everything but the ready messages and consumers needs the management API.
+// =============================================================================
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+var (
+	// queueManagementURL is the RabbitMQ management API (nil = passive
+	// declares of the watched queues)
+	queueManagementURL *url.URL
+
+	// queueVhost is the vhost of the orders exchange
+	queueVhost = "/"
+
+	queueManagementClient = &http.Client{Timeout: 5 * time.Second}
+
+	// reportedQueues are the queues of the last successful poll
+	reportedQueues = map[string]bool{}
+
+	// queuePollFailing keeps a failing poll from logging every interval
+	queuePollFailing bool
+
+	// Gauge: Ready messages per queue
+	queueMessagesReady = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "queue_messages_ready",
+			Help: "Messages waiting for a consumer in queues of the orders exchange",
+		},
+		[]string{"queue"},
+	)
+
+	// Gauge: Unacknowledged messages per queue
+	queueMessagesUnacked = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "queue_messages_unacked",
+			Help: "Messages delivered but not yet acknowledged in queues of the orders exchange",
+		},
+		[]string{"queue"},
+	)
+
+	// Gauge: Consumers per queue
+	queueConsumers = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "queue_consumers",
+			Help: "Consumers attached to queues of the orders exchange",
+		},
+		[]string{"queue"},
+	)
+
+	// Gauge: Publish rate per queue
+	queuePublishRate = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "queue_publish_rate",
+			Help: "Messages per second published to queues of the orders exchange",
+		},
+		[]string{"queue"},
+	)
+
+	// Gauge: Deliver rate per queue
+	queueDeliverRate = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "queue_deliver_rate",
+			Help: "Messages per second delivered from queues of the orders exchange",
+		},
+		[]string{"queue"},
+	)
+
+	// Gauge: Estimated consumer lag per queue
+	queueConsumerLag = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "queue_consumer_lag_seconds",
+			Help: "Time to work off the ready messages of a queue at its current deliver rate",
+		},
+		[]string{"queue"},
+	)
+
+	// Counter: Failed queue polls
+	queuePollErrorsTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "queue_poll_errors_total",
+			Help: "Total number of failed queue depth polls",
+		},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(queueMessagesReady)
+	prometheus.MustRegister(queueMessagesUnacked)
+	prometheus.MustRegister(queueConsumers)
+	prometheus.MustRegister(queuePublishRate)
+	prometheus.MustRegister(queueDeliverRate)
+	prometheus.MustRegister(queueConsumerLag)
+	prometheus.MustRegister(queuePollErrorsTotal)
+}
+
+// queueStats is the state of one queue. Detailed stats come from the
+// management API only.
+type queueStats struct {
+	Name        string
+	Ready       int
+	Consumers   int
+	Detailed    bool
+	Unacked     int
+	PublishRate float64
+	DeliverRate float64
+}
+
+// setQueueMetrics validates and applies RABBITMQ_MANAGEMENT_URL, taking
+// missing credentials and the vhost from RABBITMQ_URL
+func setQueueMetrics(managementURL, amqpURL string) error {
+	if managementURL == "" {
+		queueManagementURL = nil
+		return nil
+	}
+	mgmt, err := url.Parse(managementURL)
+	if err != nil || (mgmt.Scheme != "http" && mgmt.Scheme != "https") || mgmt.Host == "" {
+		return fmt.Errorf("invalid RABBITMQ_MANAGEMENT_URL %q: expected an http(s) URL", redactURL(managementURL))
+	}
+	if broker, err := url.Parse(amqpURL); err == nil {
+		if mgmt.User == nil {
+			mgmt.User = broker.User
+		}
+		if vhost := strings.TrimPrefix(broker.Path, "/"); vhost != "" {
+			queueVhost = vhost
+		}
+	}
+	mgmt.Path = strings.TrimSuffix(mgmt.Path, "/")
+	queueManagementURL = mgmt
+	return nil
+}
+
+// startQueueMetrics polls the queue stats every interval until background
+// work is stopped
+func (a *App) startQueueMetrics(interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			a.pollQueueMetrics(backgroundCtx)
+			select {
+			case <-backgroundCtx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// pollQueueMetrics exports the current queue stats
+func (a *App) pollQueueMetrics(ctx context.Context) {
+	stats, err := a.queueStats(ctx)
+	if err != nil {
+		if ctx.Err() != nil {
+			return
+		}
+		queuePollErrorsTotal.Inc()
+		if !queuePollFailing {
+			logWarn("Failed to poll queue depths", map[string]interface{}{"error": err.Error()})
+		}
+		queuePollFailing = true
+		return
+	}
+	if queuePollFailing {
+		logInfo("Polling queue depths again", nil)
+	}
+	queuePollFailing = false
+
+	seen := make(map[string]bool, len(stats))
+	for _, q := range stats {
+		seen[q.Name] = true
+		queueMessagesReady.WithLabelValues(q.Name).Set(float64(q.Ready))
+		queueConsumers.WithLabelValues(q.Name).Set(float64(q.Consumers))
+		if !q.Detailed {
+			continue
+		}
+		queueMessagesUnacked.WithLabelValues(q.Name).Set(float64(q.Unacked))
+		queuePublishRate.WithLabelValues(q.Name).Set(q.PublishRate)
+		queueDeliverRate.WithLabelValues(q.Name).Set(q.DeliverRate)
+		switch {
+		case q.Ready == 0:
+			queueConsumerLag.WithLabelValues(q.Name).Set(0)
+		case q.DeliverRate > 0:
+			queueConsumerLag.WithLabelValues(q.Name).Set(float64(q.Ready) / q.DeliverRate)
+		default:
+			queueConsumerLag.DeleteLabelValues(q.Name)
+		}
+	}
+
+	for name := range reportedQueues {
+		if seen[name] {
+			continue
+		}
+		for _, vec := range []*prometheus.GaugeVec{queueMessagesReady, queueMessagesUnacked,
+			queueConsumers, queuePublishRate, queueDeliverRate, queueConsumerLag} {
+			vec.DeleteLabelValues(name)
+		}
+	}
+	reportedQueues = seen
+
+	// Retry and dead-letter queues hang off orders.dlx (see deadletters.go)
+	a.pollDeadLetters()
+}
+
+// queueStats reads the stats of the queues downstream of the orders
+// exchange
+func (a *App) queueStats(ctx context.Context) ([]queueStats, error) {
+	if err := outages.check("rabbitmq"); err != nil {
+		return nil, err
+	}
+	if queueManagementURL != nil {
+		return managementQueueStats(ctx)
+	}
+
+	stats := make([]queueStats, 0, len(watchedQueues))
+	for _, name := range watchedQueues {
+		q, err := a.inspectQueue(name)
+		if err != nil {
+			return nil, fmt.Errorf("queue %s: %w", name, err)
+		}
+		stats = append(stats, queueStats{Name: q.Name, Ready: q.Messages, Consumers: q.Consumers})
+	}
+	return stats, nil
+}
+
+// inspectQueue reads a queue's ready messages and consumers with a
+// passive declare. A failed passive declare closes the channel, so every
+// queue gets its own short-lived channel.
+func (a *App) inspectQueue(name string) (amqp.Queue, error) {
+	if !a.rabbitConnected() {
+		return amqp.Queue{}, fmt.Errorf("RabbitMQ is not connected")
+	}
+	ch, err := a.rabbitConnection().Channel()
+	if err != nil {
+		return amqp.Queue{}, fmt.Errorf("failed to open RabbitMQ channel: %w", err)
+	}
+	defer ch.Close()
+	return ch.QueueDeclarePassive(name, true, false, false, false, nil)
+}
+
+// managementQueueStats reads the queues bound to the orders exchange from
+// the management API
+func managementQueueStats(ctx context.Context) ([]queueStats, error) {
+	vhost := url.PathEscape(queueVhost)
+
+	var bindings []struct {
+		Destination     string `json:"destination"`
+		DestinationType string `json:"destination_type"`
+	}
+	if err := managementGet(ctx, "/api/exchanges/"+vhost+"/orders/bindings/source", &bindings); err != nil {
+		return nil, err
+	}
+	bound := make(map[string]bool, len(bindings))
+	for _, b := range bindings {
+		if b.DestinationType == "queue" {
+			bound[b.Destination] = true
+		}
+	}
+
+	var queues []struct {
+		Name                   string `json:"name"`
+		MessagesReady          int    `json:"messages_ready"`
+		MessagesUnacknowledged int    `json:"messages_unacknowledged"`
+		Consumers              int    `json:"consumers"`
+		MessageStats           struct {
+			PublishDetails struct {
+				Rate float64 `json:"rate"`
+			} `json:"publish_details"`
+			DeliverGetDetails struct {
+				Rate float64 `json:"rate"`
+			} `json:"deliver_get_details"`
+		} `json:"message_stats"`
+	}
+	if err := managementGet(ctx, "/api/queues/"+vhost, &queues); err != nil {
+		return nil, err
+	}
+
+	stats := make([]queueStats, 0, len(bound))
+	for _, q := range queues {
+		if !bound[q.Name] {
+			continue
+		}
+		stats = append(stats, queueStats{
+			Name:        q.Name,
+			Ready:       q.MessagesReady,
+			Consumers:   q.Consumers,
+			Detailed:    true,
+			Unacked:     q.MessagesUnacknowledged,
+			PublishRate: q.MessageStats.PublishDetails.Rate,
+			DeliverRate: q.MessageStats.DeliverGetDetails.Rate,
+		})
+	}
+	return stats, nil
+}
+
+// managementGet decodes a GET of the management API into v
+func managementGet(ctx context.Context, path string, v interface{}) error {
+	return managementRequest(ctx, http.MethodGet, path, nil, v)
+}
+
+// managementRequest sends a request with an optional JSON body to the
+// management API and decodes the response into v unless v is nil
+func managementRequest(ctx context.Context, method, path string, body, v interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+	u := *queueManagementURL
+	u.User = nil
+	req, err := http.NewRequestWithContext(ctx, method, u.String()+path, reader)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if user := queueManagementURL.User; user != nil {
+		password, _ := user.Password()
+		req.SetBasicAuth(user.Username(), password)
+	}
+
+	resp, err := queueManagementClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("management API returned %s for %s %s", resp.Status, method, path)
+	}
+	if v == nil {
+		io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
@@ -0,0 +1,404 @@
+// =============================================================================
+// RABBITMQ CONNECTION RECOVERY
+// =============================================================================
+// A restarting broker used to leave the connection and publishing channel
+// dead until the service itself was restarted. Once connected, a watcher
+// now follows NotifyClose on both:
+//
+// - Connection lost   Redial with exponential backoff (500ms doubling up to
+//                     RABBITMQ_RECONNECT_MAX_BACKOFF, default 30s) until it
+//                     succeeds or the service shuts down, then open a new
+//                     publishing channel and re-declare the orders exchange.
+// - Channel closed    A channel exception (e.g. publishing to a deleted
+//                     exchange) closes only the channel, so a new one is
+//                     opened on the live connection.
+//
+// The new connection and channel replace the old ones under rabbitMu, so
+// publishers pick them up on their next attempt. Events published while
+// disconnected fail over to the outbox as before (see outbox.go) and are
+// relayed once the channel is back. /ready reports RabbitMQ unhealthy for
+// as long as the connection is down.
+//
+// Consumers (order events, commands, the dead-letter router) own their
+// channels, which die with the connection. They are registered with
+// startConsumer and re-subscribed once the connection is re-established:
+// the channel is reopened, the queues and bindings re-declared and the
+// queue consumed again. A consumer channel closed on its own (e.g. a
+// channel exception) is re-subscribed on the live connection after
+// consumerResubscribeDelay. Unacknowledged deliveries of the lost channel
+// are redelivered by the broker.
+//
+// METRICS:
+// - rabbitmq_connected                    1 while connected to RabbitMQ
+// - rabbitmq_reconnects_total{scope}      Recoveries of the connection or
+//                                         the publishing channel
+// - rabbitmq_consumer_up{consumer}        1 while the consumer is
+//                                         subscribed to its queue
+// =============================================================================
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// consumerResubscribeDelay is the wait before re-subscribing a consumer
+// whose channel closed while the connection stayed up
+const consumerResubscribeDelay = 2 * time.Second
+
+var (
+	// rabbitReconnectMaxBackoff caps the wait between reconnection attempts
+	rabbitReconnectMaxBackoff = 30 * time.Second
+
+	// rabbitConsumers are the consumers re-subscribed after a reconnect
+	rabbitConsumers   []*rabbitConsumer
+	rabbitConsumersMu sync.Mutex
+
+	// Gauge: Connection state
+	rabbitConnectionUp = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "rabbitmq_connected",
+			Help: "Whether the order service is connected to RabbitMQ (1) or not (0)",
+		},
+	)
+
+	// Counter: Recovered connections and channels
+	rabbitReconnectsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "rabbitmq_reconnects_total",
+			Help: "Total number of times the RabbitMQ connection or publishing channel was re-established",
+		},
+		[]string{"scope"},
+	)
+
+	// Gauge: Consumer subscription state
+	rabbitConsumerUp = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "rabbitmq_consumer_up",
+			Help: "Whether a RabbitMQ consumer of the order service is subscribed to its queue (1) or not (0)",
+		},
+		[]string{"consumer"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(rabbitConnectionUp)
+	prometheus.MustRegister(rabbitReconnectsTotal)
+	prometheus.MustRegister(rabbitConsumerUp)
+}
+
+// rabbitConnection returns the current RabbitMQ connection (nil before
+// the first connect)
+func (a *App) rabbitConnection() *amqp.Connection {
+	a.rabbitMu.RLock()
+	defer a.rabbitMu.RUnlock()
+	return a.rabbitConn
+}
+
+// rabbitConnected reports whether the RabbitMQ connection is open
+func (a *App) rabbitConnected() bool {
+	conn := a.rabbitConnection()
+	return conn != nil && !conn.IsClosed()
+}
+
+// publishChannel returns the current publishing channel
+func (a *App) publishChannel() *amqp.Channel {
+	a.rabbitMu.RLock()
+	defer a.rabbitMu.RUnlock()
+	return a.rabbitChannel
+}
+
+// setRabbit replaces the RabbitMQ connection and publishing channel
+func (a *App) setRabbit(conn *amqp.Connection, ch *amqp.Channel) {
+	a.rabbitMu.Lock()
+	a.rabbitConn = conn
+	a.rabbitChannel = ch
+	a.rabbitMu.Unlock()
+	rabbitConnectionUp.Set(1)
+}
+
+// dialRabbitMQ connects to RabbitMQ and opens the publishing channel
+func dialRabbitMQ(url string) (*amqp.Connection, *amqp.Channel, error) {
+	conn, err := amqp.Dial(url)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to connect to RabbitMQ: %w", err)
+	}
+	ch, err := openPublishChannel(conn)
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	return conn, ch, nil
+}
+
+// openPublishChannel opens a channel in confirm mode and declares the
+// orders exchange on it
+func openPublishChannel(conn *amqp.Connection) (*amqp.Channel, error) {
+	ch, err := conn.Channel()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open RabbitMQ channel: %w", err)
+	}
+
+	// Publisher confirms, so events only count as published once the
+	// broker has them (see publisher.go)
+	if err := ch.Confirm(false); err != nil {
+		ch.Close()
+		return nil, fmt.Errorf("failed to enable publisher confirms: %w", err)
+	}
+
+	// Declare exchange for order events
+	err = ch.ExchangeDeclare(
+		"orders", // Exchange name
+		"topic",  // Exchange type
+		true,     // Durable
+		false,    // Auto-deleted
+		false,    // Internal
+		false,    // No-wait
+		nil,      // Arguments
+	)
+	if err != nil {
+		ch.Close()
+		return nil, fmt.Errorf("failed to declare RabbitMQ exchange: %w", err)
+	}
+	return ch, nil
+}
+
+// watchRabbitMQ re-establishes the connection and publishing channel
+// whenever they close, until the service shuts down
+func (a *App) watchRabbitMQ(url string, conn *amqp.Connection, ch *amqp.Channel) {
+	for {
+		connClosed := conn.NotifyClose(make(chan *amqp.Error, 1))
+		chanClosed := ch.NotifyClose(make(chan *amqp.Error, 1))
+
+		var reason *amqp.Error
+		select {
+		case reason = <-connClosed:
+		case reason = <-chanClosed:
+		}
+		// Closed by the shutdown hook rather than by the broker
+		if backgroundCtx.Err() != nil {
+			rabbitConnectionUp.Set(0)
+			return
+		}
+		cause := "closed"
+		if reason != nil {
+			cause = reason.Error()
+		}
+
+		if !conn.IsClosed() {
+			logWarn("RabbitMQ channel closed, reopening", map[string]interface{}{"error": cause})
+			newCh, err := openPublishChannel(conn)
+			if err == nil {
+				ch = newCh
+				a.setRabbit(conn, ch)
+				rabbitReconnectsTotal.WithLabelValues("channel").Inc()
+				logInfo("RabbitMQ publishing channel reopened", nil)
+				continue
+			}
+			// The connection went down in the meantime
+			conn.Close()
+		}
+
+		rabbitConnectionUp.Set(0)
+		logWarn("RabbitMQ connection lost, reconnecting", map[string]interface{}{"error": cause})
+		lost := time.Now()
+		var ok bool
+		if conn, ch, ok = reconnectRabbitMQ(url); !ok {
+			return
+		}
+		a.setRabbit(conn, ch)
+		rabbitReconnectsTotal.WithLabelValues("connection").Inc()
+		wakeOutboxRelay()
+		resubscribeConsumers(conn)
+		logInfo("RabbitMQ connection re-established", map[string]interface{}{
+			"downtime_ms": time.Since(lost).Milliseconds(),
+		})
+		annotate("rabbitmq", "RabbitMQ connection re-established after %s", time.Since(lost).Round(time.Second))
+	}
+}
+
+// reconnectRabbitMQ dials RabbitMQ with exponential backoff. It gives up
+// only when the service shuts down.
+func reconnectRabbitMQ(url string) (*amqp.Connection, *amqp.Channel, bool) {
+	backoff := 500 * time.Millisecond
+	for attempt := 1; ; attempt++ {
+		select {
+		case <-backgroundCtx.Done():
+			return nil, nil, false
+		case <-time.After(backoff):
+		}
+
+		conn, ch, err := dialRabbitMQ(url)
+		if err == nil {
+			return conn, ch, true
+		}
+
+		backoff *= 2
+		if backoff > rabbitReconnectMaxBackoff {
+			backoff = rabbitReconnectMaxBackoff
+		}
+		logWarn("RabbitMQ reconnection failed", map[string]interface{}{
+			"attempt":  attempt,
+			"retry_in": backoff.String(),
+			"error":    err.Error(),
+		})
+	}
+}
+
+// rabbitConsumer is a queue consumer that survives reconnections
+type rabbitConsumer struct {
+	name string
+	tag  string
+
+	// subscribe opens a channel on conn, declares what the consumer needs
+	// and starts consuming with tag
+	subscribe func(conn *amqp.Connection) (*amqp.Channel, <-chan amqp.Delivery, error)
+
+	// handle processes one delivery received on ch
+	handle func(ch *amqp.Channel, d amqp.Delivery)
+
+	mu       sync.Mutex
+	ch       *amqp.Channel
+	stopping bool
+	wg       sync.WaitGroup
+}
+
+// startConsumer subscribes a consumer on the current connection, keeps it
+// subscribed across reconnections and stops it on shutdown
+func (a *App) startConsumer(c *rabbitConsumer) error {
+	if !a.rabbitConnected() {
+		return fmt.Errorf("RabbitMQ not connected")
+	}
+	rabbitConsumerUp.WithLabelValues(c.name).Set(0)
+	if err := c.start(a.rabbitConnection()); err != nil {
+		return err
+	}
+
+	rabbitConsumersMu.Lock()
+	rabbitConsumers = append(rabbitConsumers, c)
+	rabbitConsumersMu.Unlock()
+
+	onShutdown(phaseConsumers, c.name, c.stop)
+	return nil
+}
+
+// resubscribeConsumers subscribes the consumers again on a re-established
+// connection
+func resubscribeConsumers(conn *amqp.Connection) {
+	rabbitConsumersMu.Lock()
+	consumers := append([]*rabbitConsumer(nil), rabbitConsumers...)
+	rabbitConsumersMu.Unlock()
+
+	for _, c := range consumers {
+		if err := c.start(conn); err != nil {
+			logError("Failed to re-subscribe RabbitMQ consumer", map[string]interface{}{
+				"consumer": c.name,
+				"error":    err.Error(),
+			})
+			continue
+		}
+		logInfo("RabbitMQ consumer re-subscribed", map[string]interface{}{"consumer": c.name})
+	}
+}
+
+// start subscribes the consumer on conn, unless it is subscribed already
+// or stopping
+func (c *rabbitConsumer) start(conn *amqp.Connection) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.stopping || (c.ch != nil && !c.ch.IsClosed()) {
+		return nil
+	}
+
+	ch, deliveries, err := c.subscribe(conn)
+	if err != nil {
+		return err
+	}
+	c.ch = ch
+	rabbitConsumerUp.WithLabelValues(c.name).Set(1)
+
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		// Deliveries in flight finish even while shutting down
+		for d := range deliveries {
+			c.handle(ch, d)
+		}
+		c.unsubscribed(conn)
+	}()
+	return nil
+}
+
+// unsubscribed records that the deliveries of the consumer ended, and
+// re-subscribes it if only its channel was closed. After a lost
+// connection, watchRabbitMQ re-subscribes it once reconnected.
+func (c *rabbitConsumer) unsubscribed(conn *amqp.Connection) {
+	rabbitConsumerUp.WithLabelValues(c.name).Set(0)
+	c.mu.Lock()
+	stopping := c.stopping
+	c.mu.Unlock()
+	if stopping || backgroundCtx.Err() != nil {
+		return
+	}
+
+	logWarn("RabbitMQ consumer stopped receiving deliveries", map[string]interface{}{"consumer": c.name})
+	if conn.IsClosed() {
+		return
+	}
+	go func() {
+		select {
+		case <-backgroundCtx.Done():
+			return
+		case <-time.After(consumerResubscribeDelay):
+		}
+		if conn.IsClosed() {
+			return
+		}
+		if err := c.start(conn); err != nil {
+			logError("Failed to re-subscribe RabbitMQ consumer", map[string]interface{}{
+				"consumer": c.name,
+				"error":    err.Error(),
+			})
+			return
+		}
+		logInfo("RabbitMQ consumer re-subscribed", map[string]interface{}{"consumer": c.name})
+	}()
+}
+
+// stop cancels the consumer and waits for the deliveries in flight
+func (c *rabbitConsumer) stop(ctx context.Context) error {
+	c.mu.Lock()
+	c.stopping = true
+	ch := c.ch
+	c.mu.Unlock()
+	if ch == nil {
+		return nil
+	}
+
+	// Stops deliveries; the loop ends after the current one
+	if !ch.IsClosed() {
+		if err := ch.Cancel(c.tag, false); err != nil {
+			return err
+		}
+	}
+	done := make(chan struct{})
+	go func() {
+		c.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		if ch.IsClosed() {
+			return nil
+		}
+		return ch.Close()
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
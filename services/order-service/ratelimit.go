@@ -0,0 +1,326 @@
+// =============================================================================
+// PER-CLIENT RATE LIMITING
+// =============================================================================
+// RATE_LIMIT caps the API requests a client makes per RATE_LIMIT_WINDOW
+// (default 1m); 0, the default, turns rate limiting off. Clients are told
+// where they stand on every response, with the headers of the IETF
+// RateLimit fields draft:
+//
+//   RateLimit-Limit: 100        Requests allowed per window
+//   RateLimit-Remaining: 42     Requests left in the current window
+//   RateLimit-Reset: 17         Seconds until the window resets
+//   RateLimit-Policy: 100;w=60  The limit and the window in seconds
+//
+// A request over the limit gets 429 with a Retry-After of the seconds
+// until the reset. Clients are identified by their IP address. A request
+// arriving from one of RATE_LIMIT_TRUSTED_PROXIES (CIDRs, e.g.
+// 10.0.0.0/8,172.16.0.0/12) is identified by RATE_LIMIT_CLIENT_HEADER
+// (default X-Client-ID) instead, when the proxy set it; a header sent
+// straight to the service is ignored, so a client can't reset its limit by
+// changing it. The same goes for X-Forwarded-For: the routers only take
+// the client IP from it when a trusted proxy sent it (see internal.go),
+// so by default the client IP is the peer address.
+//
+// Windows are fixed (they start when a client's first request arrives) and
+// counted per instance, so behind a load balancer a client gets up to
+// RATE_LIMIT on each replica. Clients idle for 10 windows are forgotten,
+// and at most RATE_LIMIT_MAX_CLIENTS (default 10000) are tracked: new
+// clients over that share one limit, reported as client "(other)", until
+// idle ones are forgotten.
+//
+// ADMIN ENDPOINT (see internal.go):
+//   GET /admin/rate-limits                 Consumption of every client,
+//                                          busiest first
+//   GET /admin/rate-limits?client=billing  One client
+//
+//   {"enabled": true, "limit": 100, "window": "1m0s", "clients": [
+//     {"client": "billing", "used": 58, "remaining": 42, "reset_seconds": 17,
+//      "total": 1204, "limited": 12, "last_limited": "...", "last_seen": "..."}]}
+//
+// METRICS:
+// - rate_limited_requests_total{group}   Requests answered with 429, by
+//                                        route group (see concurrency.go)
+// - rate_limit_clients                   Clients being tracked
+// - rate_limit_overflow_requests_total   Requests counted against "(other)"
+//                                        because the client table was full
+// =============================================================================
+
+package main
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// rateLimitIdleWindows is how many windows a client stays tracked without
+// requests
+const rateLimitIdleWindows = 10
+
+// rateLimitOverflowClient is the client new clients count against while
+// rateLimitMaxClients are tracked
+const rateLimitOverflowClient = "(other)"
+
+var (
+	// rateLimit is the requests a client may make per window, 0 when
+	// rate limiting is off
+	rateLimit int
+
+	// rateLimitWindow is the length of a window
+	rateLimitWindow = time.Minute
+
+	// rateLimitClientHeader identifies the client of a request sent by a
+	// trusted proxy
+	rateLimitClientHeader = "X-Client-ID"
+
+	// rateLimitTrustedProxies are the networks whose requests may name
+	// their client in rateLimitClientHeader or X-Forwarded-For
+	rateLimitTrustedProxies []*net.IPNet
+
+	// trustedProxyCIDRs are rateLimitTrustedProxies as configured, for
+	// gin's SetTrustedProxies
+	trustedProxyCIDRs []string
+
+	// rateLimitMaxClients caps the clients tracked at once
+	rateLimitMaxClients = 10000
+
+	rateLimitMu      sync.Mutex
+	rateLimitClients = map[string]*rateLimitClient{}
+	rateLimitPruned  time.Time
+
+	// Counter: Rate limited requests
+	rateLimitedRequestsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "rate_limited_requests_total",
+			Help: "Total number of API requests rejected because their client was over its rate limit, by route group",
+		},
+		[]string{"group"},
+	)
+
+	// Gauge: Tracked clients
+	rateLimitClientsGauge = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "rate_limit_clients",
+			Help: "Number of clients whose request rate is being tracked",
+		},
+	)
+
+	// Counter: Requests of untracked clients
+	rateLimitOverflowRequestsTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "rate_limit_overflow_requests_total",
+			Help: "Total number of API requests counted against the shared overflow client because RATE_LIMIT_MAX_CLIENTS were tracked",
+		},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(rateLimitedRequestsTotal)
+	prometheus.MustRegister(rateLimitClientsGauge)
+	prometheus.MustRegister(rateLimitOverflowRequestsTotal)
+}
+
+// rateLimitClient is the consumption of one client
+type rateLimitClient struct {
+	windowStart time.Time
+	used        int
+	total       int64
+	limited     int64
+	lastLimited time.Time
+	lastSeen    time.Time
+}
+
+// setRateLimit validates and applies RATE_LIMIT, RATE_LIMIT_WINDOW,
+// RATE_LIMIT_CLIENT_HEADER, RATE_LIMIT_TRUSTED_PROXIES and
+// RATE_LIMIT_MAX_CLIENTS
+func setRateLimit(limit int, window time.Duration, clientHeader string, trustedProxies []string, maxClients int) error {
+	if limit < 0 {
+		return fmt.Errorf("RATE_LIMIT must not be negative, got %d", limit)
+	}
+	if window < time.Second {
+		return fmt.Errorf("RATE_LIMIT_WINDOW must be at least 1s, got %s", window)
+	}
+	if maxClients < 1 {
+		return fmt.Errorf("RATE_LIMIT_MAX_CLIENTS must be at least 1, got %d", maxClients)
+	}
+	proxies := make([]*net.IPNet, 0, len(trustedProxies))
+	for _, cidr := range trustedProxies {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return fmt.Errorf("invalid CIDR %q in RATE_LIMIT_TRUSTED_PROXIES: %w", cidr, err)
+		}
+		proxies = append(proxies, network)
+	}
+	rateLimit = limit
+	rateLimitWindow = window
+	rateLimitClientHeader = clientHeader
+	rateLimitTrustedProxies = proxies
+	trustedProxyCIDRs = trustedProxies
+	rateLimitMaxClients = maxClients
+	return nil
+}
+
+// rateLimitClientOf returns the client a request counts against: the
+// client header when a trusted proxy set it, the client IP otherwise
+func rateLimitClientOf(c *gin.Context) string {
+	if rateLimitClientHeader != "" && fromTrustedProxy(c.RemoteIP()) {
+		if client := c.GetHeader(rateLimitClientHeader); client != "" {
+			return client
+		}
+	}
+	return c.ClientIP()
+}
+
+// fromTrustedProxy reports whether a peer address is a trusted proxy
+func fromTrustedProxy(remoteIP string) bool {
+	ip := net.ParseIP(remoteIP)
+	if ip == nil {
+		return false
+	}
+	for _, network := range rateLimitTrustedProxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// takeRateLimit counts a request of client and returns whether it is
+// within the limit, the requests left and the time until the reset
+func takeRateLimit(client string, now time.Time) (bool, int, time.Duration) {
+	rateLimitMu.Lock()
+	defer rateLimitMu.Unlock()
+
+	if now.Sub(rateLimitPruned) >= rateLimitWindow {
+		pruneRateLimitClients(now)
+	}
+
+	rc := rateLimitClients[client]
+	if rc == nil && len(rateLimitClients) >= rateLimitMaxClients {
+		pruneRateLimitClients(now)
+		if len(rateLimitClients) >= rateLimitMaxClients {
+			rateLimitOverflowRequestsTotal.Inc()
+			client = rateLimitOverflowClient
+			rc = rateLimitClients[client]
+		}
+	}
+	if rc == nil {
+		rc = &rateLimitClient{windowStart: now}
+		rateLimitClients[client] = rc
+		rateLimitClientsGauge.Set(float64(len(rateLimitClients)))
+	}
+	if now.Sub(rc.windowStart) >= rateLimitWindow {
+		rc.windowStart = now
+		rc.used = 0
+	}
+	rc.total++
+	rc.lastSeen = now
+	reset := rc.windowStart.Add(rateLimitWindow).Sub(now)
+
+	if rc.used >= rateLimit {
+		rc.limited++
+		rc.lastLimited = now
+		return false, 0, reset
+	}
+	rc.used++
+	return true, rateLimit - rc.used, reset
+}
+
+// pruneRateLimitClients forgets idle clients. The caller holds rateLimitMu.
+func pruneRateLimitClients(now time.Time) {
+	for client, rc := range rateLimitClients {
+		if now.Sub(rc.lastSeen) > rateLimitIdleWindows*rateLimitWindow {
+			delete(rateLimitClients, client)
+		}
+	}
+	rateLimitPruned = now
+	rateLimitClientsGauge.Set(float64(len(rateLimitClients)))
+}
+
+// rateLimitMiddleware counts API requests per client, sets the RateLimit
+// headers and rejects requests over the limit
+func rateLimitMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if rateLimit == 0 {
+			c.Next()
+			return
+		}
+
+		allowed, remaining, reset := takeRateLimit(rateLimitClientOf(c), time.Now())
+		resetSeconds := strconv.Itoa(int(math.Ceil(reset.Seconds())))
+		c.Header("RateLimit-Limit", strconv.Itoa(rateLimit))
+		c.Header("RateLimit-Remaining", strconv.Itoa(remaining))
+		c.Header("RateLimit-Reset", resetSeconds)
+		c.Header("RateLimit-Policy", fmt.Sprintf("%d;w=%d", rateLimit, int(rateLimitWindow.Seconds())))
+
+		if !allowed {
+			rateLimitedRequestsTotal.WithLabelValues(routeGroup(c.Request.Method, c.FullPath())).Inc()
+			c.Header("Retry-After", resetSeconds)
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{
+				"error": tr(c, "Rate limit exceeded, please retry later"),
+			})
+			return
+		}
+		c.Next()
+	}
+}
+
+// getRateLimits serves GET /admin/rate-limits
+func getRateLimits(c *gin.Context) {
+	only := c.Query("client")
+	now := time.Now()
+
+	rateLimitMu.Lock()
+	clients := make([]gin.H, 0, len(rateLimitClients))
+	for client, rc := range rateLimitClients {
+		if only != "" && client != only {
+			continue
+		}
+		used, reset := rc.used, rc.windowStart.Add(rateLimitWindow).Sub(now)
+		if reset <= 0 {
+			used, reset = 0, 0
+		}
+		entry := gin.H{
+			"client":        client,
+			"used":          used,
+			"remaining":     rateLimit - used,
+			"reset_seconds": int(math.Ceil(reset.Seconds())),
+			"total":         rc.total,
+			"limited":       rc.limited,
+			"last_seen":     rc.lastSeen.UTC(),
+		}
+		if !rc.lastLimited.IsZero() {
+			entry["last_limited"] = rc.lastLimited.UTC()
+		}
+		clients = append(clients, entry)
+	}
+	rateLimitMu.Unlock()
+
+	if only != "" && len(clients) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "no requests from client " + only})
+		return
+	}
+	sort.Slice(clients, func(i, j int) bool {
+		ui, uj := clients[i]["used"].(int), clients[j]["used"].(int)
+		if ui != uj {
+			return ui > uj
+		}
+		return clients[i]["client"].(string) < clients[j]["client"].(string)
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"enabled": rateLimit > 0,
+		"limit":   rateLimit,
+		"window":  rateLimitWindow.String(),
+		"header":  rateLimitClientHeader,
+		"clients": clients,
+	})
+}
@@ -0,0 +1,260 @@
+// =============================================================================
+// ORDER RECEIPTS
+// =============================================================================
+// Emails a receipt through the notification service when an order is
+// created and when it is delivered. Receipts are rendered from the
+// text/template files in templates/receipts, embedded in the binary:
+//
+// - created.tmpl    Order confirmation
+// - delivered.tmpl  Delivery receipt
+//
+// Each template defines a "subject" and a "body". Gift wrapping is listed
+// as a line of its own, the gift message is quoted, and orders with
+// hide_prices get a gift receipt listing the items without any amounts
+// (see gifts.go). Prices include tax at
+// RECEIPT_TAX_RATE, so the tax line shows the tax contained in the total
+// rather than adding to it. Text goes through the message catalogs and
+// amounts and dates are formatted for DEFAULT_LOCALE (see locale.go).
+//
+// Receipts are sent in the background, after the response, so a slow or
+// unavailable notification service never fails an order. Failed sends are
+// retried by the delivery retry queue (see deliveries.go). Canary orders
+// (see heartbeat.go) don't get receipts.
+//
+// ENDPOINTS:
+// - GET /admin/receipts/:id/preview?kind=created&locale=de  Render without sending
+// =============================================================================
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"net/http"
+	"text/template"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+//go:embed templates/receipts/*.tmpl
+var receiptFiles embed.FS
+
+// receiptTemplates are the parsed receipt templates by kind
+var receiptTemplates = map[string]*template.Template{}
+
+var (
+	// receiptsEnabled turns sending receipts on or off
+	receiptsEnabled = true
+
+	// receiptTaxRate is the tax rate included in prices
+	receiptTaxRate = 0.0
+
+	// Counter: Receipts sent, by kind and result
+	receiptsSentTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "receipts_sent_total",
+			Help: "Total number of order receipts submitted to the notification service, by result (success, queued, failure)",
+		},
+		[]string{"kind", "result"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(receiptsSentTotal)
+
+	// Placeholders: the real functions are bound to a locale when rendering
+	funcs := localeFuncs(defaultLocale)
+	for _, kind := range []string{"created", "delivered"} {
+		receiptTemplates[kind] = template.Must(template.New(kind).Funcs(funcs).
+			ParseFS(receiptFiles, "templates/receipts/"+kind+".tmpl"))
+	}
+}
+
+// Receipt is the data a receipt template is rendered with
+type Receipt struct {
+	Order    *Order
+	ShortID  string
+	Subtotal float64
+	Tax      float64
+	TaxLabel string
+
+	// Gift options (see gifts.go)
+	GiftWrapFee float64
+	GiftMessage string
+	HidePrices  bool
+}
+
+// RenderedReceipt is a receipt ready to send
+type RenderedReceipt struct {
+	Kind      string `json:"kind"`
+	Locale    string `json:"locale"`
+	Recipient string `json:"recipient"`
+	Subject   string `json:"subject"`
+	Body      string `json:"body"`
+}
+
+// localeFuncs are the template functions of a locale
+func localeFuncs(l *locale) template.FuncMap {
+	return template.FuncMap{
+		"t":        l.T,
+		"money":    l.Money,
+		"date":     l.FormatDate,
+		"datetime": l.FormatDateTime,
+	}
+}
+
+// newReceipt computes the figures shown on an order's receipt
+func newReceipt(o *Order, l *locale) Receipt {
+	// Rounded to the order's currency, so subtotal and tax add up to the
+	// total (see currency.go)
+	subtotal := roundMoney(o.TotalAmount/(1+receiptTaxRate), o.Currency)
+	r := Receipt{
+		Order:    o,
+		ShortID:  o.ID,
+		Subtotal: subtotal,
+		Tax:      roundMoney(o.TotalAmount-subtotal, o.Currency),
+		TaxLabel: l.T("Tax included (%s%%)", l.Number(receiptTaxRate*100, -1)),
+	}
+	if len(o.ID) > 8 {
+		r.ShortID = o.ID[:8]
+	}
+	if o.Gift != nil {
+		r.GiftWrapFee = o.Gift.WrapFee
+		r.GiftMessage = o.Gift.Message
+		r.HidePrices = o.Gift.HidePrices
+	}
+	return r
+}
+
+// renderReceipt renders the receipt of the given kind for an order in a
+// locale
+func renderReceipt(kind string, o *Order, l *locale) (*RenderedReceipt, error) {
+	parsed, ok := receiptTemplates[kind]
+	if !ok {
+		return nil, fmt.Errorf("unknown receipt kind %q", kind)
+	}
+	tmpl, err := parsed.Clone()
+	if err != nil {
+		return nil, err
+	}
+	tmpl.Funcs(localeFuncs(l))
+
+	data := newReceipt(o, l)
+	var subject, body bytes.Buffer
+	if err := tmpl.ExecuteTemplate(&subject, "subject", data); err != nil {
+		return nil, err
+	}
+	if err := tmpl.ExecuteTemplate(&body, "body", data); err != nil {
+		return nil, err
+	}
+
+	return &RenderedReceipt{
+		Kind:      kind,
+		Locale:    l.Tag,
+		Recipient: o.CustomerEmail,
+		Subject:   subject.String(),
+		Body:      body.String(),
+	}, nil
+}
+
+// queueReceipt sends an order's receipt in the background
+func (a *App) queueReceipt(kind, orderID string) {
+	if !receiptsEnabled {
+		return
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(backgroundCtx, 30*time.Second)
+		defer cancel()
+
+		if err := a.sendReceipt(ctx, kind, orderID); err != nil {
+			receiptsSentTotal.WithLabelValues(kind, "failure").Inc()
+			logWarn("Failed to send order receipt", map[string]interface{}{
+				"order_id": orderID,
+				"kind":     kind,
+				"error":    err.Error(),
+			})
+		}
+	}()
+}
+
+// sendReceipt renders an order's receipt and submits it to the
+// notification service
+func (a *App) sendReceipt(ctx context.Context, kind, orderID string) error {
+	order, err := a.fetchOrder(ctx, orderID)
+	if err != nil {
+		return fmt.Errorf("failed to load order: %w", err)
+	}
+	if order.CustomerID == canaryCustomerID {
+		return nil
+	}
+
+	receipt, err := renderReceipt(kind, order, defaultLocale)
+	if err != nil {
+		return fmt.Errorf("failed to render receipt: %w", err)
+	}
+
+	queued, err := a.notifyWithRetry(ctx, Notification{
+		Type:      "email",
+		Recipient: receipt.Recipient,
+		Subject:   receipt.Subject,
+		Body:      receipt.Body,
+		OrderID:   orderID,
+	})
+	if err != nil {
+		return err
+	}
+	if queued {
+		receiptsSentTotal.WithLabelValues(kind, "queued").Inc()
+		return nil
+	}
+
+	receiptsSentTotal.WithLabelValues(kind, "success").Inc()
+	logInfoContext(ctx, "Order receipt sent", map[string]interface{}{
+		"order_id": orderID,
+		"kind":     kind,
+	})
+	return nil
+}
+
+// =============================================================================
+// RECEIPT ADMIN HANDLERS
+// =============================================================================
+
+// previewReceipt renders an order's receipt without sending it
+func (a *App) previewReceipt(c *gin.Context) {
+	kind := c.DefaultQuery("kind", "created")
+	if _, ok := receiptTemplates[kind]; !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "kind must be created or delivered"})
+		return
+	}
+
+	l := negotiateLocale(c.GetHeader("Accept-Language"))
+	if tag := c.Query("locale"); tag != "" {
+		if l = lookupLocale(tag); l == nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown locale " + tag})
+			return
+		}
+	}
+
+	order, err := a.fetchOrder(c.Request.Context(), c.Param("id"))
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Order not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	receipt, err := renderReceipt(kind, order, l)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, receipt)
+}
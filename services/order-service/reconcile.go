@@ -0,0 +1,416 @@
+// =============================================================================
+// PAYMENT RECONCILIATION
+// =============================================================================
+// The payment-reconciliation job (RECONCILIATION_SCHEDULE, nightly by
+// default) cross-checks the orders updated within RECONCILIATION_WINDOW
+// against their payments in the payment service
+// (GET /api/v1/payments/order/:id) and flags mismatches:
+//
+//   Kind               Order status                  Payments
+//   paid_but_pending   pending                       completed
+//   unpaid_fulfilment  processing/shipped/delivered  none or failed
+//   refunded_active    processing/shipped/delivered  refunded
+//   paid_but_cancelled cancelled                     completed (no refund)
+//   amount_mismatch    any but cancelled             completed != total
+//
+// Every run is recorded in reconciliation_runs, with its mismatches in
+// reconciliation_mismatches, and sets the payment_reconciliation_mismatches
+// gauge. Seeded demo and canary orders never have payments, so they are
+// skipped.
+//
+// AUTO-CORRECTION (RECONCILIATION_AUTO_CORRECT=true):
+// Only unambiguous cases are corrected: a paid pending order moves to
+// processing, and a processing order whose payment was refunded is
+// cancelled. Everything else needs a human.
+//
+// ENDPOINTS:
+// - GET /admin/reconciliation  Mismatches of the latest run
+// =============================================================================
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// reconcileBatchSize caps the orders checked by one run
+const reconcileBatchSize = 5000
+
+// reconcileMismatchKinds are the kinds of mismatch the job reports
+var reconcileMismatchKinds = []string{
+	"paid_but_pending", "unpaid_fulfilment", "refunded_active", "paid_but_cancelled", "amount_mismatch",
+}
+
+var (
+	// reconcileWindow is how far back updated orders are checked
+	reconcileWindow = 48 * time.Hour
+
+	// reconcileAutoCorrect enables correcting unambiguous mismatches
+	reconcileAutoCorrect bool
+
+	// Gauge: Mismatches found by the latest run, by kind
+	reconcileMismatches = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "payment_reconciliation_mismatches",
+			Help: "Order/payment mismatches found by the latest reconciliation run, by kind",
+		},
+		[]string{"kind"},
+	)
+
+	// Gauge: Orders checked by the latest run
+	reconcileOrdersChecked = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "payment_reconciliation_orders_checked",
+			Help: "Orders checked by the latest payment reconciliation run",
+		},
+	)
+
+	// Counter: Orders corrected automatically, by kind
+	reconcileCorrectionsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "payment_reconciliation_corrections_total",
+			Help: "Total number of orders corrected by payment reconciliation, by mismatch kind",
+		},
+		[]string{"kind"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(reconcileMismatches)
+	prometheus.MustRegister(reconcileOrdersChecked)
+	prometheus.MustRegister(reconcileCorrectionsTotal)
+}
+
+// paymentAmount decodes amounts sent either as JSON numbers or as decimal
+// strings (the payment service serializes Decimal as a string)
+type paymentAmount float64
+
+func (a *paymentAmount) UnmarshalJSON(data []byte) error {
+	s := string(bytes.Trim(data, `"`))
+	if s == "null" || s == "" {
+		*a = 0
+		return nil
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return fmt.Errorf("invalid amount %s", data)
+	}
+	*a = paymentAmount(f)
+	return nil
+}
+
+// PaymentRecord is a payment as returned by the payment service
+type PaymentRecord struct {
+	ID     string        `json:"id"`
+	Amount paymentAmount `json:"amount"`
+	Status string        `json:"status"`
+}
+
+// ReconciliationMismatch is one order whose payments don't match its status
+type ReconciliationMismatch struct {
+	RunAt         time.Time `json:"run_at"`
+	OrderID       string    `json:"order_id"`
+	Kind          string    `json:"kind"`
+	OrderStatus   string    `json:"order_status"`
+	PaymentStatus string    `json:"payment_status"`
+	OrderAmount   float64   `json:"order_amount"`
+	PaidAmount    float64   `json:"paid_amount"`
+	Correction    string    `json:"correction,omitempty"`
+}
+
+// fetchOrderPayments returns the payments of an order from the payment service
+func (a *App) fetchOrderPayments(ctx context.Context, orderID string) ([]PaymentRecord, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet,
+		a.paymentServiceURL+"/api/v1/payments/order/"+url.PathEscape(orderID), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := a.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("payment service unreachable: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("payment service returned status %d", resp.StatusCode)
+	}
+
+	var payments []PaymentRecord
+	if err := json.NewDecoder(resp.Body).Decode(&payments); err != nil {
+		return nil, fmt.Errorf("invalid payment service response: %w", err)
+	}
+	return payments, nil
+}
+
+// classifyPayments compares an order with its payments and returns the
+// mismatch kind, or "" if they agree
+func classifyPayments(status string, total float64, currency string, payments []PaymentRecord) (kind, paymentStatus string, paid float64) {
+	var completed, refunded bool
+	for _, p := range payments {
+		switch p.Status {
+		case "completed":
+			completed = true
+			paid += float64(p.Amount)
+		case "refunded":
+			refunded = true
+		}
+	}
+
+	switch {
+	case completed:
+		paymentStatus = "completed"
+	case refunded:
+		paymentStatus = "refunded"
+	case len(payments) > 0:
+		paymentStatus = "failed"
+	default:
+		paymentStatus = "none"
+	}
+
+	switch status {
+	case "pending":
+		if completed {
+			kind = "paid_but_pending"
+		}
+	case "processing", "shipped", "delivered":
+		if paymentStatus == "refunded" {
+			kind = "refunded_active"
+		} else if !completed {
+			kind = "unpaid_fulfilment"
+		}
+	case "cancelled":
+		if completed {
+			kind = "paid_but_cancelled"
+		}
+	}
+
+	// Amounts are compared to the minor unit of the order's currency
+	if kind == "" && completed && status != "cancelled" && formatMoney(paid, currency) != formatMoney(total, currency) {
+		kind = "amount_mismatch"
+	}
+	return kind, paymentStatus, paid
+}
+
+// reconcilePayments is the payment-reconciliation job
+func (a *App) reconcilePayments(ctx context.Context) error {
+	runAt := time.Now().UTC()
+
+	rows, err := a.db.QueryContext(ctx, `
+		SELECT id, status, total_amount, currency
+		FROM orders
+		WHERE updated_at >= $1 AND COALESCE(notes, '') NOT IN ($2, $3)
+		ORDER BY updated_at
+		LIMIT $4
+	`, runAt.Add(-reconcileWindow), seedNote, canaryNote, reconcileBatchSize)
+	if err != nil {
+		return err
+	}
+	type orderState struct {
+		id       string
+		status   string
+		total    float64
+		currency string
+	}
+	var orders []orderState
+	for rows.Next() {
+		var o orderState
+		if err := rows.Scan(&o.id, &o.status, &o.total, &o.currency); err != nil {
+			rows.Close()
+			return err
+		}
+		orders = append(orders, o)
+	}
+	rows.Close()
+
+	counts := map[string]int{}
+	var mismatches []ReconciliationMismatch
+	for _, o := range orders {
+		payments, err := a.fetchOrderPayments(ctx, o.id)
+		if err != nil {
+			return fmt.Errorf("order %s: %w", o.id, err)
+		}
+
+		kind, paymentStatus, paid := classifyPayments(o.status, o.total, o.currency, payments)
+		if kind == "" {
+			continue
+		}
+		counts[kind]++
+
+		m := ReconciliationMismatch{
+			RunAt:         runAt,
+			OrderID:       o.id,
+			Kind:          kind,
+			OrderStatus:   o.status,
+			PaymentStatus: paymentStatus,
+			OrderAmount:   o.total,
+			PaidAmount:    paid,
+		}
+		if reconcileAutoCorrect {
+			m.Correction = a.correctMismatch(ctx, m)
+		}
+		mismatches = append(mismatches, m)
+	}
+
+	if err := a.saveReconciliation(ctx, runAt, len(orders), mismatches); err != nil {
+		return err
+	}
+
+	reconcileOrdersChecked.Set(float64(len(orders)))
+	for _, kind := range reconcileMismatchKinds {
+		reconcileMismatches.WithLabelValues(kind).Set(float64(counts[kind]))
+	}
+
+	logInfoContext(ctx, "Payment reconciliation finished", map[string]interface{}{
+		"orders_checked": len(orders),
+		"mismatches":     len(mismatches),
+		"auto_correct":   reconcileAutoCorrect,
+		"duration_ms":    time.Since(runAt).Milliseconds(),
+	})
+	return nil
+}
+
+// correctMismatch fixes the order status for unambiguous mismatches and
+// returns the correction made, if any
+func (a *App) correctMismatch(ctx context.Context, m ReconciliationMismatch) string {
+	var from, to string
+	switch {
+	case m.Kind == "paid_but_pending":
+		from, to = "pending", "processing"
+	case m.Kind == "refunded_active" && m.OrderStatus == "processing":
+		from, to = "processing", "cancelled"
+	default:
+		return ""
+	}
+
+	// Only correct the order if nobody changed it in the meantime
+	routingKey := "order.status." + to
+	if to == "cancelled" {
+		routingKey = "order.cancelled"
+	}
+	events := []orderEvent{newOrderEvent(routingKey, m.OrderID)}
+	var corrected bool
+	var err error
+	if eventSourced() {
+		corrected, err = a.changeOrderStatus(withOutboxEvents(ctx, events...), m.OrderID, to, from)
+	} else {
+		corrected, err = a.execOrderWrite(ctx, "reconcile_order_status", events, `
+			UPDATE orders SET status = $1, updated_at = NOW() WHERE id = $2 AND status = $3
+		`, to, m.OrderID, from)
+	}
+	if err != nil {
+		logWarnContext(ctx, "Failed to correct order status", map[string]interface{}{
+			"order_id": m.OrderID,
+			"kind":     m.Kind,
+			"error":    err.Error(),
+		})
+		return ""
+	}
+	if !corrected {
+		return ""
+	}
+
+	reconcileCorrectionsTotal.WithLabelValues(m.Kind).Inc()
+	logWarnContext(ctx, "Order status corrected by payment reconciliation", map[string]interface{}{
+		"order_id": m.OrderID,
+		"kind":     m.Kind,
+		"from":     from,
+		"to":       to,
+	})
+	return from + " -> " + to
+}
+
+// saveReconciliation records a run and its mismatches in the report tables
+func (a *App) saveReconciliation(ctx context.Context, runAt time.Time, checked int, mismatches []ReconciliationMismatch) error {
+	tx, err := a.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO reconciliation_runs (run_at, orders_checked, mismatches) VALUES ($1, $2, $3)
+	`, runAt, checked, len(mismatches)); err != nil {
+		return err
+	}
+
+	for _, m := range mismatches {
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO reconciliation_mismatches
+				(run_at, order_id, kind, order_status, payment_status, order_amount, paid_amount, correction)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, NULLIF($8, ''))
+		`, m.RunAt, m.OrderID, m.Kind, m.OrderStatus, m.PaymentStatus, m.OrderAmount, m.PaidAmount, m.Correction)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// =============================================================================
+// RECONCILIATION ADMIN HANDLERS
+// =============================================================================
+
+// getReconciliation returns the mismatches of the latest run
+func (a *App) getReconciliation(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	var run struct {
+		RunAt         time.Time `json:"run_at"`
+		OrdersChecked int       `json:"orders_checked"`
+		Mismatches    int       `json:"mismatches"`
+	}
+	err := a.db.QueryRowContext(ctx, `
+		SELECT run_at, orders_checked, mismatches
+		FROM reconciliation_runs ORDER BY run_at DESC LIMIT 1
+	`).Scan(&run.RunAt, &run.OrdersChecked, &run.Mismatches)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Reconciliation has not run yet"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	rows, err := a.db.QueryContext(ctx, `
+		SELECT run_at, order_id, kind, order_status, payment_status,
+		       order_amount, paid_amount, COALESCE(correction, '')
+		FROM reconciliation_mismatches
+		WHERE run_at = $1
+		ORDER BY kind, order_id
+	`, run.RunAt)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	defer rows.Close()
+
+	mismatches := []ReconciliationMismatch{}
+	for rows.Next() {
+		var m ReconciliationMismatch
+		if err := rows.Scan(&m.RunAt, &m.OrderID, &m.Kind, &m.OrderStatus, &m.PaymentStatus,
+			&m.OrderAmount, &m.PaidAmount, &m.Correction); err != nil {
+			continue
+		}
+		mismatches = append(mismatches, m)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"run":          run,
+		"auto_correct": reconcileAutoCorrect,
+		"window":       reconcileWindow.String(),
+		"mismatches":   mismatches,
+	})
+}
@@ -0,0 +1,260 @@
+// =============================================================================
+// REQUEST RECORDER
+// =============================================================================
+// An opt-in recorder for public API requests, so traffic that produced an
+// interesting dashboard shape can be replayed later with cmd/replay (see
+// package recording for the format and sanitizing rules).
+//
+// - RECORD_REQUESTS        Start recording on startup (default false)
+// - RECORD_SINK            "file" (newline-delimited JSON) or "redis" (stream)
+// - RECORD_FILE            File appended to by the file sink
+// - RECORD_STREAM          Redis stream written to by the redis sink, capped
+//                          at about RECORD_STREAM_MAX_LEN entries
+// - RECORD_MAX_BODY_BYTES  Larger bodies are recorded without the body
+//
+// Recording can be switched on and off at runtime with PUT /admin/recording.
+// Records are written by a background worker; when it falls behind,
+// records are dropped rather than slowing requests down.
+// =============================================================================
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/v8"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"order-service/recording"
+)
+
+// recorderState holds the recorder settings and its open file
+type recorderState struct {
+	mu           sync.RWMutex
+	enabled      bool
+	sink         string
+	file         string
+	stream       string
+	streamMaxLen int64
+	maxBodyBytes int64
+	out          *os.File
+	redis        *redis.Client
+}
+
+var (
+	// recorder is the process-wide request recorder
+	recorder = &recorderState{}
+
+	// recordQueue buffers records for the background writer
+	recordQueue = make(chan recording.Record, 1000)
+
+	// Counter: Recorded requests, by sink and result
+	requestsRecordedTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "requests_recorded_total",
+			Help: "Total number of API requests recorded for replay",
+		},
+		[]string{"sink", "result"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(requestsRecordedTotal)
+}
+
+// set switches recording on or off with the given sink
+func (r *recorderState) set(enabled bool, sink string) error {
+	if sink != "file" && sink != "redis" {
+		return fmt.Errorf("unknown record sink %q (want file or redis)", sink)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// Reopen the file when switching to or re-enabling the file sink
+	if r.out != nil {
+		r.out.Close()
+		r.out = nil
+	}
+	if enabled && sink == "file" {
+		if err := os.MkdirAll(filepath.Dir(r.file), 0o755); err != nil {
+			return err
+		}
+		f, err := os.OpenFile(r.file, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return err
+		}
+		r.out = f
+	}
+
+	r.enabled = enabled
+	r.sink = sink
+	return nil
+}
+
+// useRedis sets the client the redis sink writes to
+func (r *recorderState) useRedis(client *redis.Client) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.redis = client
+}
+
+// active reports whether requests are being recorded
+func (r *recorderState) active() (bool, int64) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.enabled, r.maxBodyBytes
+}
+
+// write stores one record in the configured sink
+func (r *recorderState) write(rec recording.Record) (string, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return r.sink, err
+	}
+
+	switch {
+	case !r.enabled:
+		return r.sink, nil
+	case r.sink == "redis":
+		if r.redis == nil {
+			return r.sink, fmt.Errorf("redis is not connected")
+		}
+		return r.sink, r.redis.XAdd(backgroundCtx, &redis.XAddArgs{
+			Stream: r.stream,
+			MaxLen: r.streamMaxLen,
+			Approx: true,
+			Values: map[string]interface{}{"record": string(data)},
+		}).Err()
+	case r.out == nil:
+		return r.sink, fmt.Errorf("recording file is not open")
+	default:
+		_, err := r.out.Write(append(data, '\n'))
+		return r.sink, err
+	}
+}
+
+// close closes the recording file
+func (r *recorderState) close() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.out != nil {
+		r.out.Close()
+		r.out = nil
+	}
+}
+
+// startRecorder starts the background writer, which stops on shutdown
+func startRecorder() {
+	go func() {
+		for {
+			select {
+			case <-backgroundCtx.Done():
+				recorder.close()
+				return
+			case rec := <-recordQueue:
+				sink, err := recorder.write(rec)
+				if err != nil {
+					requestsRecordedTotal.WithLabelValues(sink, "error").Inc()
+					logWarn("Failed to record request", map[string]interface{}{
+						"sink":  sink,
+						"error": err.Error(),
+					})
+					continue
+				}
+				requestsRecordedTotal.WithLabelValues(sink, "success").Inc()
+			}
+		}
+	}()
+}
+
+// recorderMiddleware queues a sanitized record of every API request while
+// recording is on. Replayed requests and canary orders are not recorded.
+func recorderMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		enabled, maxBody := recorder.active()
+		if !enabled || c.GetHeader(recording.ReplayHeader) != "" || c.GetHeader(canaryHeader) != "" {
+			c.Next()
+			return
+		}
+
+		// Read up to the limit and put the body back for the handler
+		var body []byte
+		if c.Request.Body != nil {
+			buf, err := io.ReadAll(io.LimitReader(c.Request.Body, maxBody+1))
+			if err == nil {
+				c.Request.Body = io.NopCloser(io.MultiReader(bytes.NewReader(buf), c.Request.Body))
+				if int64(len(buf)) <= maxBody {
+					body = buf
+				}
+			}
+		}
+
+		select {
+		case recordQueue <- recording.New(c.Request, body):
+		default:
+			requestsRecordedTotal.WithLabelValues("queue", "dropped").Inc()
+		}
+
+		c.Next()
+	}
+}
+
+// =============================================================================
+// RECORDER ADMIN HANDLERS
+// =============================================================================
+
+// getRecording returns the recorder settings
+func getRecording(c *gin.Context) {
+	recorder.mu.RLock()
+	defer recorder.mu.RUnlock()
+
+	c.JSON(http.StatusOK, gin.H{
+		"enabled":        recorder.enabled,
+		"sink":           recorder.sink,
+		"file":           recorder.file,
+		"stream":         recorder.stream,
+		"max_body_bytes": recorder.maxBodyBytes,
+		"queued":         len(recordQueue),
+	})
+}
+
+// setRecording switches recording on or off
+func setRecording(c *gin.Context) {
+	var req struct {
+		Enabled bool   `json:"enabled"`
+		Sink    string `json:"sink"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Sink == "" {
+		recorder.mu.RLock()
+		req.Sink = recorder.sink
+		recorder.mu.RUnlock()
+	}
+
+	if err := recorder.set(req.Enabled, req.Sink); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	logWarnContext(c.Request.Context(), "Request recording changed", map[string]interface{}{
+		"enabled": req.Enabled,
+		"sink":    req.Sink,
+	})
+	getRecording(c)
+}
@@ -0,0 +1,253 @@
+// =============================================================================
+// REQUEST RECORDING AND REPLAY
+// =============================================================================
+// Recorded requests let the lab reproduce the traffic that produced an
+// interesting dashboard shape. The service records sanitized requests (see
+// recorder.go in the service) as Records, one JSON object per line in a
+// file or one entry per request in a Redis stream, and Replay sends them
+// again against any instance, at the original pace or faster. Replayed
+// requests carry an X-Replayed header and are never recorded again.
+//
+// Sanitizing happens before a request is recorded: credentials and
+// cookies are never kept, and personal data in JSON bodies (names, emails,
+// addresses) is replaced with placeholders, so recordings can be shared.
+//
+// Replay them with cmd/replay:
+//
+//   go run ./cmd/replay -file requests.ndjson -target http://localhost:8001 -speed 4
+// =============================================================================
+
+package recording
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// ReplayHeader marks replayed requests, so they aren't recorded again
+const ReplayHeader = "X-Replayed"
+
+// Record is one recorded inbound request
+type Record struct {
+	Time   time.Time         `json:"time"`
+	Method string            `json:"method"`
+	URL    string            `json:"url"`
+	Header map[string]string `json:"header,omitempty"`
+	Body   string            `json:"body,omitempty"`
+}
+
+// recordedHeaders are the request headers kept in a recording
+var recordedHeaders = []string{"Content-Type", "Accept", "Accept-Encoding", "User-Agent"}
+
+// sensitiveFields are JSON body fields whose values are replaced
+var sensitiveFields = map[string]string{
+	"customer_name":    "Recorded Customer",
+	"customer_email":   "recorded@example.com",
+	"shipping_address": "1 Recorded Street",
+	"email":            "recorded@example.com",
+	"phone":            "+10000000000",
+	"card_number":      "4111111111111111",
+	"password":         "redacted",
+}
+
+// New builds a sanitized record of a request with the given body
+func New(req *http.Request, body []byte) Record {
+	rec := Record{
+		Time:   time.Now().UTC(),
+		Method: req.Method,
+		URL:    req.URL.RequestURI(),
+		Header: map[string]string{},
+	}
+	for _, h := range recordedHeaders {
+		if v := req.Header.Get(h); v != "" {
+			rec.Header[h] = v
+		}
+	}
+	if len(body) > 0 {
+		rec.Body = string(SanitizeBody(body))
+	}
+	return rec
+}
+
+// SanitizeBody replaces personal data in a JSON body. Bodies that aren't
+// JSON are dropped entirely, since they can't be checked.
+func SanitizeBody(body []byte) []byte {
+	var v interface{}
+	if err := json.Unmarshal(body, &v); err != nil {
+		return nil
+	}
+	sanitized, _ := json.Marshal(sanitizeValue(v))
+	return sanitized
+}
+
+func sanitizeValue(v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		for k, val := range t {
+			if placeholder, ok := sensitiveFields[strings.ToLower(k)]; ok {
+				t[k] = placeholder
+				continue
+			}
+			t[k] = sanitizeValue(val)
+		}
+	case []interface{}:
+		for i := range t {
+			t[i] = sanitizeValue(t[i])
+		}
+	}
+	return v
+}
+
+// ReadFile reads records from a newline-delimited JSON file
+func ReadFile(path string) ([]Record, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Read(f)
+}
+
+// Read reads newline-delimited JSON records
+func Read(r io.Reader) ([]Record, error) {
+	var records []Record
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var rec Record
+		if err := json.Unmarshal(line, &rec); err != nil {
+			return records, err
+		}
+		records = append(records, rec)
+	}
+	return records, scanner.Err()
+}
+
+// ReadStream reads up to count records from a Redis stream, oldest first.
+// Each entry stores the JSON record in its "record" field.
+func ReadStream(ctx context.Context, client *redis.Client, stream string, count int64) ([]Record, error) {
+	entries, err := client.XRangeN(ctx, stream, "-", "+", count).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	records := make([]Record, 0, len(entries))
+	for _, e := range entries {
+		data, ok := e.Values["record"].(string)
+		if !ok {
+			continue
+		}
+		var rec Record
+		if err := json.Unmarshal([]byte(data), &rec); err != nil {
+			return records, err
+		}
+		records = append(records, rec)
+	}
+	return records, nil
+}
+
+// Options configures a replay
+type Options struct {
+	// BaseURL of the instance to replay against, e.g. http://localhost:8001
+	BaseURL string
+	// Speed scales the original pace: 1 replays in real time, 4 four
+	// times faster, 0 sends every request as fast as possible
+	Speed float64
+	// MaxInFlight limits concurrent requests (default 64)
+	MaxInFlight int
+}
+
+// Result summarizes a replay
+type Result struct {
+	Sent     int         `json:"sent"`
+	Errors   int         `json:"errors"`
+	Statuses map[int]int `json:"statuses"`
+	Elapsed  string      `json:"elapsed"`
+}
+
+// Replay sends the records to opts.BaseURL, keeping their relative timing
+// scaled by opts.Speed
+func Replay(ctx context.Context, records []Record, opts Options) Result {
+	if opts.MaxInFlight <= 0 {
+		opts.MaxInFlight = 64
+	}
+	client := &http.Client{Timeout: 30 * time.Second}
+	sem := make(chan struct{}, opts.MaxInFlight)
+
+	var mu sync.Mutex
+	result := Result{Statuses: map[int]int{}}
+
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i, rec := range records {
+		if opts.Speed > 0 && i > 0 {
+			offset := time.Duration(float64(rec.Time.Sub(records[0].Time)) / opts.Speed)
+			select {
+			case <-ctx.Done():
+			case <-time.After(time.Until(start.Add(offset))):
+			}
+		}
+		if ctx.Err() != nil {
+			break
+		}
+
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(rec Record) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			status, err := send(ctx, client, opts.BaseURL, rec)
+			mu.Lock()
+			defer mu.Unlock()
+			result.Sent++
+			if err != nil {
+				result.Errors++
+				return
+			}
+			result.Statuses[status]++
+		}(rec)
+	}
+	wg.Wait()
+
+	result.Elapsed = time.Since(start).Round(time.Millisecond).String()
+	return result
+}
+
+// send replays one record and returns the response status
+func send(ctx context.Context, client *http.Client, baseURL string, rec Record) (int, error) {
+	var body io.Reader
+	if rec.Body != "" {
+		body = strings.NewReader(rec.Body)
+	}
+	req, err := http.NewRequestWithContext(ctx, rec.Method, strings.TrimRight(baseURL, "/")+rec.URL, body)
+	if err != nil {
+		return 0, err
+	}
+	for k, v := range rec.Header {
+		req.Header.Set(k, v)
+	}
+	req.Header.Set(ReplayHeader, "true")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	return resp.StatusCode, nil
+}
@@ -0,0 +1,97 @@
+// =============================================================================
+// PANIC RECOVERY
+// =============================================================================
+// Gin's default recovery middleware prints the panic to stderr and moves on,
+// which makes panics invisible on dashboards. This middleware instead:
+// - Increments panics_total{method, endpoint}
+// - Logs the panic value and stack trace as a structured JSON entry
+// - Optionally notifies PANIC_NOTIFY_RECIPIENT via the notification service
+// =============================================================================
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"runtime/debug"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	// panicNotifyRecipient receives a notification for every panic (optional)
+	panicNotifyRecipient string
+
+	// Counter: Recovered panics
+	panicsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "panics_total",
+			Help: "Total number of panics recovered in HTTP handlers",
+		},
+		[]string{"method", "endpoint"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(panicsTotal)
+}
+
+// recoveryMiddleware recovers from panics, records them and returns a 500
+func (a *App) recoveryMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		defer func() {
+			recovered := recover()
+			if recovered == nil {
+				return
+			}
+
+			// http.ErrAbortHandler is used to abort a response on purpose
+			if recovered == http.ErrAbortHandler {
+				panic(recovered)
+			}
+
+			path := c.FullPath()
+			if path == "" {
+				path = c.Request.URL.Path
+			}
+			stack := string(debug.Stack())
+
+			panicsTotal.WithLabelValues(c.Request.Method, path).Inc()
+			logError("Panic recovered", map[string]interface{}{
+				"panic":    fmt.Sprint(recovered),
+				"method":   c.Request.Method,
+				"endpoint": path,
+				"stack":    stack,
+			})
+
+			if panicNotifyRecipient != "" {
+				go a.notifyPanic(c.Request.Method, path, recovered)
+			}
+
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": tr(c, "Internal server error")})
+		}()
+
+		c.Next()
+	}
+}
+
+// notifyPanic sends a panic alert through the notification service
+func (a *App) notifyPanic(method, path string, recovered interface{}) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err := a.notifyWithRetry(ctx, Notification{
+		Type:      "alert",
+		Recipient: panicNotifyRecipient,
+		Subject:   fmt.Sprintf("order-service panic in %s %s", method, path),
+		Body:      fmt.Sprintf("Recovered panic: %v", recovered),
+	})
+	if err != nil {
+		logWarn("Failed to send panic notification", map[string]interface{}{
+			"error": err.Error(),
+		})
+	}
+}
@@ -0,0 +1,285 @@
+// =============================================================================
+// DAILY BUSINESS SUMMARIES
+// =============================================================================
+// The daily-summary job (DAILY_SUMMARY_SCHEDULE, shortly after midnight UTC
+// by default) computes the key figures of the previous day in
+// REPORT_TIMEZONE and stores them in daily_order_summaries:
+//
+// - order_count        Orders created that day
+// - cancelled_count    ...of which are now cancelled
+// - cancellation_rate  cancelled_count / order_count
+// - revenue            Total of the orders that aren't cancelled
+// - avg_basket         revenue / orders that aren't cancelled
+//
+// The figures come from the hourly stats rollups (see stats.go), which the
+// job refreshes first. Days missed while no worker was running are filled
+// in, up to a week back. Each computed day is published as an
+// order.daily_summary event; a re-run publishes the day again, so
+// consumers should treat the event as an upsert keyed by date.
+//
+// Every summary carries its timezone and the exact period it covers, with
+// explicit offsets. With a REPORT_TIMEZONE other than UTC, schedule the job
+// after midnight in that timezone.
+//
+// ENDPOINTS:
+// - GET /api/v1/reports/daily?days=30&tz=  Daily summaries, oldest first
+//
+// Requests in REPORT_TIMEZONE read the stored summaries; requests in any
+// other timezone (tz or customer_id, see timezone.go) get the complete
+// days computed live from the rollups.
+// =============================================================================
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// summaryBackfillDays is how far back missing summaries are filled in
+const summaryBackfillDays = 7
+
+// DailySummary is the business summary of one day
+type DailySummary struct {
+	Date             string    `json:"date"`
+	Timezone         string    `json:"timezone"`
+	PeriodStart      time.Time `json:"period_start"`
+	PeriodEnd        time.Time `json:"period_end"`
+	OrderCount       int64     `json:"order_count"`
+	CancelledCount   int64     `json:"cancelled_count"`
+	CancellationRate float64   `json:"cancellation_rate"`
+	Revenue          float64   `json:"revenue"`
+	AvgBasket        float64   `json:"avg_basket"`
+	ComputedAt       time.Time `json:"computed_at"`
+}
+
+// summarizeDays is the daily-summary job: it computes yesterday's summary
+// and any missing days before it
+func (a *App) summarizeDays(ctx context.Context) error {
+	if err := a.refreshStatsRollups(ctx); err != nil {
+		return err
+	}
+
+	today := startOfDay(time.Now(), reportTimezone)
+	yesterday := today.AddDate(0, 0, -1)
+
+	start := yesterday.AddDate(0, 0, -(summaryBackfillDays - 1))
+	var last sql.NullString
+	if err := a.db.QueryRowContext(ctx, `
+		SELECT to_char(MAX(day), 'YYYY-MM-DD') FROM daily_order_summaries WHERE timezone = $1
+	`, reportTimezone.String()).Scan(&last); err != nil {
+		return err
+	}
+	if last.Valid {
+		if lastDay, err := parseDay(last.String, reportTimezone); err == nil && lastDay.AddDate(0, 0, 1).After(start) {
+			start = lastDay.AddDate(0, 0, 1)
+		}
+	}
+	if start.After(yesterday) {
+		start = yesterday
+	}
+
+	for day := start; !day.After(yesterday); day = day.AddDate(0, 0, 1) {
+		summary, err := a.summarizeDay(ctx, day)
+		if err != nil {
+			return err
+		}
+		a.publishDailySummary(summary)
+
+		logInfoContext(ctx, "Daily order summary computed", map[string]interface{}{
+			"date":              summary.Date,
+			"order_count":       summary.OrderCount,
+			"cancellation_rate": summary.CancellationRate,
+			"revenue":           summary.Revenue,
+		})
+	}
+	return nil
+}
+
+// newDailySummary starts the summary of the day starting at midnight day
+func newDailySummary(day time.Time) *DailySummary {
+	return &DailySummary{
+		Date:        day.Format("2006-01-02"),
+		Timezone:    day.Location().String(),
+		PeriodStart: day,
+		PeriodEnd:   day.AddDate(0, 0, 1),
+		ComputedAt:  time.Now().UTC(),
+	}
+}
+
+// derive computes the rates and averages from the counts
+func (s *DailySummary) derive() {
+	if s.OrderCount > 0 {
+		s.CancellationRate = float64(s.CancelledCount) / float64(s.OrderCount)
+	}
+	if kept := s.OrderCount - s.CancelledCount; kept > 0 {
+		s.AvgBasket = s.Revenue / float64(kept)
+	}
+}
+
+// summarizeDay computes and stores the summary of the day starting at
+// midnight day, in day's timezone
+func (a *App) summarizeDay(ctx context.Context, day time.Time) (*DailySummary, error) {
+	s := newDailySummary(day)
+
+	err := a.db.QueryRowContext(ctx, `
+		SELECT COALESCE(SUM(order_count), 0),
+		       COALESCE(SUM(order_count) FILTER (WHERE status = 'cancelled'), 0),
+		       COALESCE(SUM(revenue) FILTER (WHERE status <> 'cancelled'), 0)
+		FROM order_stats_hourly
+		WHERE bucket >= $1 AND bucket < $2
+	`, s.PeriodStart, s.PeriodEnd).Scan(&s.OrderCount, &s.CancelledCount, &s.Revenue)
+	if err != nil {
+		return nil, err
+	}
+	s.derive()
+
+	_, err = a.db.ExecContext(ctx, `
+		INSERT INTO daily_order_summaries
+			(day, timezone, period_start, period_end, order_count, cancelled_count,
+			 cancellation_rate, revenue, avg_basket, computed_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		ON CONFLICT (day) DO UPDATE
+		SET timezone = EXCLUDED.timezone,
+		    period_start = EXCLUDED.period_start,
+		    period_end = EXCLUDED.period_end,
+		    order_count = EXCLUDED.order_count,
+		    cancelled_count = EXCLUDED.cancelled_count,
+		    cancellation_rate = EXCLUDED.cancellation_rate,
+		    revenue = EXCLUDED.revenue,
+		    avg_basket = EXCLUDED.avg_basket,
+		    computed_at = EXCLUDED.computed_at
+	`, s.Date, s.Timezone, s.PeriodStart, s.PeriodEnd, s.OrderCount, s.CancelledCount,
+		s.CancellationRate, s.Revenue, s.AvgBasket, s.ComputedAt)
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// publishDailySummary publishes an order.daily_summary event
+func (a *App) publishDailySummary(s *DailySummary) {
+	body, err := json.Marshal(struct {
+		Event     string `json:"event"`
+		Timestamp string `json:"timestamp"`
+		*DailySummary
+	}{"order.daily_summary", time.Now().Format(time.RFC3339), s})
+	if err != nil {
+		return
+	}
+	a.enqueueEvent(orderEvent{RoutingKey: "order.daily_summary", Body: body})
+}
+
+// =============================================================================
+// REPORT HANDLERS
+// =============================================================================
+
+// getDailyReports returns the daily summaries of the last days, stored
+// ones in REPORT_TIMEZONE and live ones in any other timezone
+func (a *App) getDailyReports(c *gin.Context) {
+	days := 30
+	if d, err := strconv.Atoi(c.Query("days")); err == nil && d > 0 && d <= 366 {
+		days = d
+	}
+	loc, err := a.resolveTimezone(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var summaries []DailySummary
+	source := "stored"
+	if loc.String() == reportTimezone.String() {
+		summaries, err = a.storedDailySummaries(c.Request.Context(), loc, days)
+	} else {
+		source = "live"
+		summaries, err = a.liveDailySummaries(c.Request.Context(), loc, days)
+	}
+	if err != nil {
+		logErrorContext(c.Request.Context(), "Failed to query daily summaries", map[string]interface{}{
+			"error":    err.Error(),
+			"timezone": loc.String(),
+		})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": tr(c, "Database error")})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"days":      days,
+		"timezone":  loc.String(),
+		"source":    source,
+		"summaries": summaries,
+	})
+}
+
+// storedDailySummaries reads the summaries stored by the daily-summary job
+func (a *App) storedDailySummaries(ctx context.Context, loc *time.Location, days int) ([]DailySummary, error) {
+	rows, err := a.db.QueryContext(ctx, `
+		SELECT to_char(day, 'YYYY-MM-DD'), timezone, period_start, period_end,
+		       order_count, cancelled_count, cancellation_rate, revenue, avg_basket, computed_at
+		FROM daily_order_summaries
+		WHERE day >= $1 AND timezone = $2
+		ORDER BY day
+	`, startOfDay(time.Now(), loc).AddDate(0, 0, -days).Format("2006-01-02"), loc.String())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	summaries := []DailySummary{}
+	for rows.Next() {
+		var s DailySummary
+		if err := rows.Scan(&s.Date, &s.Timezone, &s.PeriodStart, &s.PeriodEnd, &s.OrderCount,
+			&s.CancelledCount, &s.CancellationRate, &s.Revenue, &s.AvgBasket, &s.ComputedAt); err != nil {
+			continue
+		}
+		s.PeriodStart, s.PeriodEnd = s.PeriodStart.In(loc), s.PeriodEnd.In(loc)
+		summaries = append(summaries, s)
+	}
+	return summaries, rows.Err()
+}
+
+// liveDailySummaries computes the summaries of the last complete days in
+// loc from the hourly rollups
+func (a *App) liveDailySummaries(ctx context.Context, loc *time.Location, days int) ([]DailySummary, error) {
+	today := startOfDay(time.Now(), loc)
+	rows, err := a.db.QueryContext(ctx, `
+		SELECT to_char(bucket AT TIME ZONE $3, 'YYYY-MM-DD'),
+		       SUM(order_count),
+		       COALESCE(SUM(order_count) FILTER (WHERE status = 'cancelled'), 0),
+		       COALESCE(SUM(revenue) FILTER (WHERE status <> 'cancelled'), 0)
+		FROM order_stats_hourly
+		WHERE bucket >= $1 AND bucket < $2
+		GROUP BY 1
+		ORDER BY 1
+	`, today.AddDate(0, 0, -days), today, loc.String())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	summaries := []DailySummary{}
+	for rows.Next() {
+		var date string
+		var count, cancelled int64
+		var revenue float64
+		if err := rows.Scan(&date, &count, &cancelled, &revenue); err != nil {
+			return nil, err
+		}
+		day, err := parseDay(date, loc)
+		if err != nil {
+			return nil, err
+		}
+		s := newDailySummary(day)
+		s.OrderCount, s.CancelledCount, s.Revenue = count, cancelled, revenue
+		s.derive()
+		summaries = append(summaries, *s)
+	}
+	return summaries, rows.Err()
+}
@@ -0,0 +1,544 @@
+// =============================================================================
+// BULK PRICE RECALCULATION
+// =============================================================================
+// After a pricing change (new catalog prices, a new GIFT_WRAP_FEE or
+// DEFAULT_CURRENCY rounding), operators can recalculate the orders that
+// haven't been processed yet:
+//
+//   POST /admin/orders/recalculate
+//   {
+//     "statuses": ["pending", "preorder"],   // non-terminal only (default)
+//     "customer_id": "...", "sku": "SKU-1",  // optional filters
+//     "order_ids": ["..."],
+//     "from": "2026-01-01T00:00:00Z", "to": "...",
+//     "prices": "catalog",                   // or "keep"
+//     "dry_run": true,                       // default
+//     "limit": 500                           // at most 5000
+//   }
+//
+// Every matched order is priced again: unit prices from the inventory
+// service's catalog (read fresh, bypassing the SKU cache; SKUs the catalog
+// doesn't know keep their price, "prices": "keep" keeps them all), the
+// current gift wrap fee, and line-by-line rounding to the order's currency
+// (see currency.go). Orders carry no discounts, so there are none to
+// recompute. The tax shown is the tax included in the total at
+// RECEIPT_TAX_RATE.
+//
+// The response lists every order whose figures would change, old and new,
+// with the difference per currency. A dry run (the default) stops there;
+// "dry_run": false applies the changes, each order on its own and only if
+// it wasn't modified in the meantime (skipped orders are reported with an
+// error), and publishes order.repriced for each. delivered and cancelled
+// orders are never touched.
+//
+// METRICS:
+// - price_recalculation_runs_total{mode}          dry_run, apply
+// - price_recalculated_orders_total{result}       repriced, conflict, failed
+// =============================================================================
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// recalculationDefaultLimit is the number of orders recalculated per
+	// request unless asked otherwise
+	recalculationDefaultLimit = 500
+
+	// recalculationMaxLimit is the most orders recalculated per request
+	recalculationMaxLimit = 5000
+)
+
+// repricableStatuses are the statuses whose orders can be recalculated
+var repricableStatuses = map[string]bool{
+	"preorder": true, "pending": true, "processing": true, "shipped": true,
+}
+
+// RecalculationRequest selects the orders of a price recalculation
+type RecalculationRequest struct {
+	Statuses   []string   `json:"statuses"`
+	CustomerID string     `json:"customer_id"`
+	SKU        string     `json:"sku"`
+	OrderIDs   []string   `json:"order_ids"`
+	From       *time.Time `json:"from"`
+	To         *time.Time `json:"to"`
+	Prices     string     `json:"prices"`
+	DryRun     *bool      `json:"dry_run"`
+	Limit      int        `json:"limit"`
+}
+
+// ItemRepricing is the change of an order item's price
+type ItemRepricing struct {
+	ItemID       string  `json:"item_id"`
+	SKU          string  `json:"sku"`
+	Quantity     int     `json:"quantity"`
+	OldUnitPrice float64 `json:"old_unit_price"`
+	NewUnitPrice float64 `json:"new_unit_price"`
+	OldTotal     float64 `json:"old_total"`
+	NewTotal     float64 `json:"new_total"`
+}
+
+// OrderRepricing is the change of an order's figures
+type OrderRepricing struct {
+	OrderID        string          `json:"order_id"`
+	Status         string          `json:"status"`
+	Currency       string          `json:"currency"`
+	OldTotal       float64         `json:"old_total"`
+	NewTotal       float64         `json:"new_total"`
+	Difference     float64         `json:"difference"`
+	OldTax         float64         `json:"old_tax"`
+	NewTax         float64         `json:"new_tax"`
+	OldGiftWrapFee float64         `json:"old_gift_wrap_fee,omitempty"`
+	NewGiftWrapFee float64         `json:"new_gift_wrap_fee,omitempty"`
+	Items          []ItemRepricing `json:"items,omitempty"`
+	Applied        bool            `json:"applied"`
+	Error          string          `json:"error,omitempty"`
+
+	version int
+	lines   []OrderItem
+}
+
+// RecalculationResult is the outcome of a price recalculation
+type RecalculationResult struct {
+	DryRun          bool               `json:"dry_run"`
+	Prices          string             `json:"prices"`
+	Matched         int                `json:"matched"`
+	Changed         int                `json:"changed"`
+	Applied         int                `json:"applied"`
+	TotalDifference map[string]float64 `json:"total_difference"`
+	Orders          []OrderRepricing   `json:"orders"`
+	UnknownSKUs     []string           `json:"unknown_skus,omitempty"`
+}
+
+// orderRepricedData is the payload of OrderRepriced
+type orderRepricedData struct {
+	Items       []OrderItem `json:"items"`
+	GiftWrapFee float64     `json:"gift_wrap_fee"`
+	TotalAmount float64     `json:"total_amount"`
+}
+
+var (
+	// Counter: Price recalculations by mode
+	priceRecalculationRunsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "price_recalculation_runs_total",
+			Help: "Total number of bulk price recalculations, by mode (dry_run, apply)",
+		},
+		[]string{"mode"},
+	)
+
+	// Counter: Orders changed by price recalculations, by result
+	priceRecalculatedOrdersTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "price_recalculated_orders_total",
+			Help: "Total number of orders whose price recalculation was applied, by result (repriced, conflict, failed)",
+		},
+		[]string{"result"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(priceRecalculationRunsTotal)
+	prometheus.MustRegister(priceRecalculatedOrdersTotal)
+}
+
+// recalculatePrices handles POST /admin/orders/recalculate
+func (a *App) recalculatePrices(c *gin.Context) {
+	var req RecalculationRequest
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if len(req.Statuses) == 0 {
+		req.Statuses = []string{"preorder", "pending"}
+	}
+	for _, status := range req.Statuses {
+		if !repricableStatuses[status] {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "statuses must be preorder, pending, processing or shipped"})
+			return
+		}
+	}
+	if req.Prices == "" {
+		req.Prices = "catalog"
+	}
+	if req.Prices != "catalog" && req.Prices != "keep" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "prices must be catalog or keep"})
+		return
+	}
+	for _, id := range req.OrderIDs {
+		if !uuidPattern.MatchString(id) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid order ID " + id})
+			return
+		}
+	}
+	if req.Limit <= 0 {
+		req.Limit = recalculationDefaultLimit
+	}
+	if req.Limit > recalculationMaxLimit {
+		req.Limit = recalculationMaxLimit
+	}
+	dryRun := req.DryRun == nil || *req.DryRun
+
+	result, err := a.recalculateOrders(c.Request.Context(), req, dryRun)
+	if errors.Is(err, errCatalogUnavailable) {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		logErrorContext(c.Request.Context(), "Price recalculation failed", map[string]interface{}{"error": err.Error()})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	mode := "apply"
+	if dryRun {
+		mode = "dry_run"
+	}
+	priceRecalculationRunsTotal.WithLabelValues(mode).Inc()
+	logInfoContext(c.Request.Context(), "Prices recalculated", map[string]interface{}{
+		"dry_run":   dryRun,
+		"prices":    req.Prices,
+		"matched":   result.Matched,
+		"changed":   result.Changed,
+		"applied":   result.Applied,
+		"client_ip": c.ClientIP(),
+	})
+	c.JSON(http.StatusOK, result)
+}
+
+// errCatalogUnavailable is returned when catalog prices can't be read
+var errCatalogUnavailable = errors.New("inventory service is unavailable, catalog prices can't be read")
+
+// recalculateOrders prices the selected orders again and applies the
+// changes unless dryRun is set
+func (a *App) recalculateOrders(ctx context.Context, req RecalculationRequest, dryRun bool) (*RecalculationResult, error) {
+	orders, err := a.loadRepricingOrders(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	var catalog map[string]float64
+	var unknown []string
+	if req.Prices == "catalog" {
+		if catalog, unknown, err = a.catalogPrices(ctx, orders); err != nil {
+			return nil, err
+		}
+	}
+
+	result := &RecalculationResult{
+		DryRun:          dryRun,
+		Prices:          req.Prices,
+		Matched:         len(orders),
+		TotalDifference: map[string]float64{},
+		Orders:          []OrderRepricing{},
+		UnknownSKUs:     unknown,
+	}
+	for _, o := range orders {
+		r, changed := repriceOrder(o, catalog)
+		if !changed {
+			continue
+		}
+		result.Changed++
+		result.TotalDifference[r.Currency] = roundMoney(result.TotalDifference[r.Currency]+r.Difference, r.Currency)
+
+		if !dryRun {
+			a.applyRepricing(ctx, &r, req.Statuses)
+			if r.Applied {
+				result.Applied++
+			}
+		}
+		result.Orders = append(result.Orders, r)
+	}
+	return result, nil
+}
+
+// loadRepricingOrders loads the orders matching a recalculation request,
+// with their items
+func (a *App) loadRepricingOrders(ctx context.Context, req RecalculationRequest) ([]*Order, error) {
+	where := []string{"status = ANY($1)"}
+	args := []interface{}{pq.Array(req.Statuses)}
+	add := func(cond string, arg interface{}) {
+		args = append(args, arg)
+		where = append(where, fmt.Sprintf(cond, len(args)))
+	}
+	if req.CustomerID != "" {
+		add("customer_id = $%d", req.CustomerID)
+	}
+	if len(req.OrderIDs) > 0 {
+		add("id = ANY($%d::uuid[])", pq.Array(req.OrderIDs))
+	}
+	if req.SKU != "" {
+		add("id IN (SELECT order_id FROM order_items WHERE sku = $%d)", req.SKU)
+	}
+	if req.From != nil {
+		add("created_at >= $%d", *req.From)
+	}
+	if req.To != nil {
+		add("created_at < $%d", *req.To)
+	}
+	args = append(args, req.Limit)
+
+	rows, err := a.db.QueryContext(ctx, `
+		SELECT id, status, currency, total_amount, gift_wrap, gift_wrap_fee, version
+		FROM orders
+		WHERE `+strings.Join(where, " AND ")+fmt.Sprintf(`
+		ORDER BY created_at
+		LIMIT $%d`, len(args)), args...)
+	if err != nil {
+		return nil, err
+	}
+	var orders []*Order
+	byID := map[string]*Order{}
+	for rows.Next() {
+		o := &Order{}
+		var giftWrap bool
+		var giftWrapFee float64
+		if err := rows.Scan(&o.ID, &o.Status, &o.Currency, &o.TotalAmount, &giftWrap, &giftWrapFee, &o.Version); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if giftWrap {
+			o.Gift = &GiftOptions{Wrap: true, WrapFee: giftWrapFee}
+		}
+		orders = append(orders, o)
+		byID[o.ID] = o
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil || len(orders) == 0 {
+		return orders, err
+	}
+
+	ids := make([]string, 0, len(orders))
+	for _, o := range orders {
+		ids = append(ids, o.ID)
+	}
+	items, err := a.db.QueryContext(ctx, `
+		SELECT id, order_id, sku, name, quantity, unit_price, total_price
+		FROM order_items WHERE order_id = ANY($1::uuid[]) ORDER BY created_at
+	`, pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer items.Close()
+	for items.Next() {
+		var item OrderItem
+		if err := items.Scan(&item.ID, &item.OrderID, &item.SKU, &item.Name,
+			&item.Quantity, &item.UnitPrice, &item.TotalPrice); err != nil {
+			return nil, err
+		}
+		if o := byID[item.OrderID]; o != nil {
+			o.Items = append(o.Items, item)
+		}
+	}
+	return orders, items.Err()
+}
+
+// catalogPrices reads the current catalog price of every SKU of the
+// orders, bypassing the SKU cache. It also returns the SKUs the catalog
+// doesn't know.
+func (a *App) catalogPrices(ctx context.Context, orders []*Order) (map[string]float64, []string, error) {
+	prices := map[string]float64{}
+	seen := map[string]bool{}
+	var unknown []string
+	for _, o := range orders {
+		for _, item := range o.Items {
+			if seen[item.SKU] {
+				continue
+			}
+			seen[item.SKU] = true
+
+			lookupCtx, cancel := context.WithTimeout(ctx, skuLookupTimeout)
+			info, err := a.fetchSKU(lookupCtx, item.SKU)
+			cancel()
+			switch {
+			case errors.Is(err, errUnknownSKU):
+				unknown = append(unknown, item.SKU)
+			case err != nil:
+				logWarnContext(ctx, "SKU lookup failed during price recalculation", map[string]interface{}{
+					"sku":   item.SKU,
+					"error": err.Error(),
+				})
+				return nil, nil, errCatalogUnavailable
+			case info.Price != nil:
+				prices[item.SKU] = *info.Price
+			}
+		}
+	}
+	return prices, unknown, nil
+}
+
+// repriceOrder computes an order's figures under the current pricing
+// rules. It reports whether any of them changed.
+func repriceOrder(o *Order, catalog map[string]float64) (OrderRepricing, bool) {
+	r := OrderRepricing{
+		OrderID:  o.ID,
+		Status:   o.Status,
+		Currency: o.Currency,
+		OldTotal: o.TotalAmount,
+		OldTax:   includedTax(o.TotalAmount, o.Currency),
+		version:  o.Version,
+	}
+
+	var total float64
+	changed := false
+	for _, item := range o.Items {
+		price := item.UnitPrice
+		if p, ok := catalog[item.SKU]; ok {
+			price = p
+		}
+		lineAmount := lineTotal(item.Quantity, price, o.Currency)
+		if price != item.UnitPrice || lineAmount != item.TotalPrice {
+			changed = true
+			r.Items = append(r.Items, ItemRepricing{
+				ItemID:       item.ID,
+				SKU:          item.SKU,
+				Quantity:     item.Quantity,
+				OldUnitPrice: item.UnitPrice,
+				NewUnitPrice: price,
+				OldTotal:     item.TotalPrice,
+				NewTotal:     lineAmount,
+			})
+		}
+		item.UnitPrice, item.TotalPrice = price, lineAmount
+		r.lines = append(r.lines, item)
+		total += lineAmount
+	}
+
+	if o.Gift != nil && o.Gift.Wrap {
+		r.OldGiftWrapFee = o.Gift.WrapFee
+		r.NewGiftWrapFee = roundMoney(giftWrapFee, o.Currency)
+		changed = changed || r.NewGiftWrapFee != r.OldGiftWrapFee
+		total += r.NewGiftWrapFee
+	}
+
+	r.NewTotal = roundMoney(total, o.Currency)
+	r.NewTax = includedTax(r.NewTotal, o.Currency)
+	r.Difference = roundMoney(r.NewTotal-r.OldTotal, o.Currency)
+	return r, changed || r.NewTotal != r.OldTotal
+}
+
+// includedTax is the tax contained in a total at RECEIPT_TAX_RATE
+func includedTax(total float64, currency string) float64 {
+	return roundMoney(total-roundMoney(total/(1+receiptTaxRate), currency), currency)
+}
+
+// applyRepricing writes a recalculated order, unless it changed since it
+// was priced or left the recalculated statuses
+func (a *App) applyRepricing(ctx context.Context, r *OrderRepricing, statuses []string) {
+	var ok bool
+	var err error
+	if eventSourced() {
+		ok, err = a.appendOrderEvent(ctx, r.OrderID, eventOrderRepriced, func(o *Order) (interface{}, bool) {
+			allowed := false
+			for _, status := range statuses {
+				allowed = allowed || o.Status == status
+			}
+			if !allowed || o.TotalAmount != r.OldTotal {
+				return nil, false
+			}
+			return orderRepricedData{Items: r.lines, GiftWrapFee: r.NewGiftWrapFee, TotalAmount: r.NewTotal}, true
+		})
+	} else {
+		ok, err = a.writeRepricing(ctx, r, statuses)
+	}
+
+	switch {
+	case err != nil:
+		priceRecalculatedOrdersTotal.WithLabelValues("failed").Inc()
+		r.Error = "Database error"
+		logErrorContext(ctx, "Failed to apply price recalculation", map[string]interface{}{
+			"order_id": r.OrderID,
+			"error":    err.Error(),
+		})
+	case !ok:
+		priceRecalculatedOrdersTotal.WithLabelValues("conflict").Inc()
+		r.Error = "Order changed during the recalculation, skipped"
+	default:
+		priceRecalculatedOrdersTotal.WithLabelValues("repriced").Inc()
+		r.Applied = true
+		a.publishRepriced(r)
+	}
+}
+
+// writeRepricing applies a recalculation in crud mode. The version check
+// makes it a no-op for orders modified since they were read.
+func (a *App) writeRepricing(ctx context.Context, r *OrderRepricing, statuses []string) (bool, error) {
+	tx, err := a.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, `
+		UPDATE orders SET total_amount = $1, gift_wrap_fee = $2, updated_at = NOW()
+		WHERE id = $3 AND version = $4 AND status = ANY($5)
+	`, r.NewTotal, r.NewGiftWrapFee, r.OrderID, r.version, pq.Array(statuses))
+	if err != nil {
+		return false, err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return false, nil
+	}
+	for _, item := range r.Items {
+		if _, err := tx.ExecContext(ctx, `
+			UPDATE order_items SET unit_price = $1, total_price = $2 WHERE id = $3
+		`, item.NewUnitPrice, item.NewTotal, item.ItemID); err != nil {
+			return false, err
+		}
+	}
+	return true, tx.Commit()
+}
+
+// applyRepricedEvent applies an OrderRepriced event to an order's state
+func applyRepricedEvent(o *Order, data []byte) error {
+	var d orderRepricedData
+	if err := json.Unmarshal(data, &d); err != nil {
+		return err
+	}
+	prices := make(map[string]OrderItem, len(d.Items))
+	for _, item := range d.Items {
+		prices[item.ID] = item
+	}
+	for i, item := range o.Items {
+		if repriced, ok := prices[item.ID]; ok {
+			o.Items[i].UnitPrice, o.Items[i].TotalPrice = repriced.UnitPrice, repriced.TotalPrice
+		}
+	}
+	if o.Gift != nil && o.Gift.Wrap {
+		o.Gift.WrapFee = d.GiftWrapFee
+	}
+	o.TotalAmount = d.TotalAmount
+	return nil
+}
+
+// publishRepriced tells other services about an applied recalculation
+func (a *App) publishRepriced(r *OrderRepricing) {
+	body, err := json.Marshal(struct {
+		Event      string          `json:"event"`
+		OrderID    string          `json:"order_id"`
+		Timestamp  string          `json:"timestamp"`
+		Currency   string          `json:"currency"`
+		OldTotal   float64         `json:"old_total"`
+		NewTotal   float64         `json:"new_total"`
+		Difference float64         `json:"difference"`
+		Items      []ItemRepricing `json:"items,omitempty"`
+	}{"order.repriced", r.OrderID, time.Now().Format(time.RFC3339), r.Currency,
+		r.OldTotal, r.NewTotal, r.Difference, r.Items})
+	if err != nil {
+		return
+	}
+	a.enqueueEvent(orderEvent{RoutingKey: "order.repriced", OrderID: r.OrderID, Body: body, Tier: cachedOrderTier(r.OrderID)})
+}
@@ -0,0 +1,210 @@
+// =============================================================================
+// DEMO ENVIRONMENT RESET
+// =============================================================================
+// POST /admin/reset puts the lab back into a known state between workshop
+// sessions, without re-deploying the stack:
+//
+//   1. Stops the incident scenario and removes chaos rules, simulated
+//      outages and slowdowns, so they don't interfere with the reset
+//   2. Truncates the order tables, the outbox, the stats rollups, the
+//      export jobs and customer data requests (and deletes their files)
+//   3. Deletes the service's Redis keys
+//   4. Purges the watched RabbitMQ queues (WATCHED_QUEUES)
+//   5. Empties the search index, if search is configured
+//   6. Reseeds demo data if the request asks for it
+//
+// PROTECTION:
+// This destroys every order, so on top of the admin token it requires
+// DEMO_RESET_ENABLED (only allowed in the dev profile) and the
+// exact confirmation phrase in the body:
+//
+//   {"confirm": "reset order-service", "reseed": true}
+//
+// Only one reset runs at a time.
+// =============================================================================
+
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// resetConfirmation must be sent in the body of a reset request
+const resetConfirmation = "reset order-service"
+
+// resetTables are truncated by a reset
+const resetTables = "order_items, orders, outbox_events, order_stats_hourly, rollup_watermarks, export_jobs, daily_order_summaries, reconciliation_mismatches, reconciliation_runs, outbound_deliveries, archive_runs, order_events, order_snapshots, customer_timezones, privacy_requests, order_reviews, order_item_tracking, order_tombstones, processed_commands"
+
+var (
+	// demoResetEnabled allows POST /admin/reset
+	demoResetEnabled bool
+
+	// resetRunning prevents two resets from overlapping
+	resetRunning atomic.Bool
+)
+
+// resetDemoEnvironment wipes the service's data and optionally reseeds it
+func (a *App) resetDemoEnvironment(c *gin.Context) {
+	if !demoResetEnabled {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Demo reset is disabled (DEMO_RESET_ENABLED=false)"})
+		return
+	}
+
+	var req struct {
+		Confirm string `json:"confirm"`
+		Reseed  bool   `json:"reseed"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Confirm != resetConfirmation {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Reset deletes every order: confirm with {\"confirm\": \"" + resetConfirmation + "\"}",
+		})
+		return
+	}
+
+	if !resetRunning.CompareAndSwap(false, true) {
+		c.JSON(http.StatusConflict, gin.H{"error": "A reset is already running"})
+		return
+	}
+	defer resetRunning.Store(false)
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Minute)
+	defer cancel()
+
+	start := time.Now()
+	logWarnContext(c.Request.Context(), "Demo environment reset started", map[string]interface{}{
+		"reseed": req.Reseed,
+		"client": c.ClientIP(),
+	})
+
+	// 1. Fault injection
+	activeScenario.stop()
+	rules := chaos.clear()
+	ended := outages.clear()
+	slowDependencies.clear()
+
+	// 2. Database
+	exportFiles, err := a.truncateOrderData(ctx)
+	if err != nil {
+		logErrorContext(c.Request.Context(), "Demo reset failed to truncate order data", map[string]interface{}{
+			"error": err.Error(),
+		})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to truncate order data: " + err.Error()})
+		return
+	}
+
+	result := gin.H{
+		"chaos_rules_removed": rules,
+		"outages_ended":       ended,
+		"tables_truncated":    resetTables,
+		"export_files":        exportFiles,
+	}
+
+	// 3. Redis
+	if deleted, err := a.deleteCacheKeys(ctx); err != nil {
+		result["redis_error"] = err.Error()
+	} else {
+		result["redis_keys_deleted"] = deleted
+	}
+
+	// 4. RabbitMQ
+	result["queues_purged"] = a.purgeWatchedQueues()
+
+	// 5. Search index
+	if searchEnabled() {
+		if err := clearSearchIndex(ctx); err != nil {
+			result["search_error"] = err.Error()
+		} else {
+			result["search_index_cleared"] = true
+		}
+	}
+
+	// 6. Demo data
+	if req.Reseed {
+		imported, err := a.seedDemoData(ctx, appSeedOptions)
+		result["reseeded"] = imported
+		if err != nil {
+			result["reseed_error"] = err.Error()
+		}
+	}
+
+	result["message"] = "Demo environment reset"
+	result["duration_ms"] = time.Since(start).Milliseconds()
+
+	logWarnContext(c.Request.Context(), "Demo environment reset", result)
+	annotate("reset", "Demo environment reset (reseeded: %t)", req.Reseed)
+	c.JSON(http.StatusOK, result)
+}
+
+// truncateOrderData empties the order tables and deletes the export files,
+// returning how many files were deleted
+func (a *App) truncateOrderData(ctx context.Context) (int, error) {
+	rows, err := a.db.QueryContext(ctx, `
+		SELECT file_path FROM export_jobs WHERE file_path IS NOT NULL
+		UNION ALL
+		SELECT file_path FROM privacy_requests WHERE file_path IS NOT NULL
+	`)
+	if err != nil {
+		return 0, err
+	}
+	var files []string
+	for rows.Next() {
+		var path string
+		if err := rows.Scan(&path); err == nil {
+			files = append(files, path)
+		}
+	}
+	rows.Close()
+
+	if _, err := a.db.ExecContext(ctx, `TRUNCATE `+resetTables+` RESTART IDENTITY`); err != nil {
+		return 0, err
+	}
+
+	deleted := 0
+	for _, path := range files {
+		if err := os.Remove(path); err == nil {
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+// purgeWatchedQueues purges every watched queue and reports the number of
+// messages purged (or the error) per queue
+func (a *App) purgeWatchedQueues() gin.H {
+	purged := gin.H{}
+	if !a.rabbitConnected() {
+		for _, name := range watchedQueues {
+			purged[name] = "RabbitMQ is not connected"
+		}
+		return purged
+	}
+
+	for _, name := range watchedQueues {
+		// A failed purge closes the channel, so every queue gets its own
+		ch, err := a.rabbitConnection().Channel()
+		if err != nil {
+			purged[name] = err.Error()
+			continue
+		}
+		n, err := ch.QueuePurge(name, false)
+		if err != nil {
+			purged[name] = err.Error()
+		} else {
+			purged[name] = n
+		}
+		ch.Close()
+	}
+	return purged
+}
@@ -0,0 +1,39 @@
+// =============================================================================
+// GO RUNTIME AND PROCESS METRICS
+// =============================================================================
+// The Go and process collectors are registered explicitly rather than
+// relying on the defaults of the Prometheus client, and the Go collector
+// also exports the GC and scheduler metrics of runtime/metrics, so the
+// runtime dashboards get latency histograms instead of summaries:
+//
+// METRICS:
+// - go_goroutines, go_threads                    Goroutines and OS threads
+// - go_memstats_*                                Heap, stack and GC memory
+// - go_gc_duration_seconds                       GC pause summary
+// - go_gc_*                                      GC cycles, heap goals and
+//                                                allocations (runtime/metrics)
+// - go_sched_pauses_total_gc_seconds             Stop-the-world GC pauses
+// - go_sched_latencies_seconds                   Time goroutines wait to run
+// - go_sched_goroutines_goroutines               Live goroutines
+// - process_cpu_seconds_total                    CPU time
+// - process_resident_memory_bytes                RSS
+// - process_open_fds, process_max_fds            File descriptors
+// - process_start_time_seconds                   Start time (restarts)
+// =============================================================================
+
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+)
+
+func init() {
+	prometheus.Unregister(collectors.NewGoCollector())
+	prometheus.Unregister(collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+
+	prometheus.MustRegister(collectors.NewGoCollector(
+		collectors.WithGoCollectorRuntimeMetrics(collectors.MetricsGC, collectors.MetricsScheduler),
+	))
+	prometheus.MustRegister(collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+}
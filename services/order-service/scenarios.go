@@ -0,0 +1,378 @@
+// =============================================================================
+// INCIDENT SCENARIOS
+// =============================================================================
+// A scenario is a scripted timeline of chaos rules (see chaos.go) and
+// simulated dependency outages (see outages.go), so a workshop can replay
+// exactly the same incident for every cohort:
+//
+//   t=0m    latency ramp starts on the order API
+//   t=4m    error burst on order creation
+//   t=6m    dependency outage
+//   t=9m    recovery
+//
+// Each step becomes a chaos rule or an outage whose TTL is the step's
+// duration, so a step ends by itself even if the runner dies. Only one
+// scenario runs at a time; stopping it removes the rules and outages it
+// created. speed > 1 compresses the timeline for rehearsals.
+//
+// ENDPOINTS:
+// - GET  /admin/scenarios              List scenarios and the active run
+// - POST /admin/scenarios/:name/start  Start a scenario ({"speed": 1})
+// - POST /admin/scenarios/stop         Stop the active scenario
+// =============================================================================
+
+package main
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// ScenarioStep is one injected condition of a scenario timeline
+type ScenarioStep struct {
+	At          time.Duration `json:"-"`
+	Duration    time.Duration `json:"-"`
+	Description string        `json:"description"`
+	Rule        *ChaosRule    `json:"rule,omitempty"`
+	Outage      string        `json:"outage,omitempty"`
+
+	AtSeconds       float64 `json:"at_seconds"`
+	DurationSeconds float64 `json:"duration_seconds"`
+}
+
+// Scenario is a named incident timeline
+type Scenario struct {
+	Name        string         `json:"name"`
+	Description string         `json:"description"`
+	Steps       []ScenarioStep `json:"steps"`
+}
+
+// length returns the time until the last step ends
+func (s *Scenario) length() time.Duration {
+	var end time.Duration
+	for _, step := range s.Steps {
+		if e := step.At + step.Duration; e > end {
+			end = e
+		}
+	}
+	return end
+}
+
+// latencyStep builds a step adding latency to every order API route
+func latencyStep(at, duration time.Duration, latencyMs int, description string) ScenarioStep {
+	return ScenarioStep{At: at, Duration: duration, Description: description,
+		Rule: &ChaosRule{Method: "*", Route: "*", LatencyMs: latencyMs, LatencyJitterMs: latencyMs / 2}}
+}
+
+// errorStep builds a step failing a share of requests to a route
+func errorStep(at, duration time.Duration, method, route string, rate float64, status int, description string) ScenarioStep {
+	return ScenarioStep{At: at, Duration: duration, Description: description,
+		Rule: &ChaosRule{Method: method, Route: route, ErrorRate: rate, ErrorStatus: status}}
+}
+
+// outageStep builds a step simulating an outage of a dependency
+func outageStep(at, duration time.Duration, dependency, description string) ScenarioStep {
+	return ScenarioStep{At: at, Duration: duration, Description: description, Outage: dependency}
+}
+
+// scenarios are the built-in incident timelines
+var scenarios = map[string]Scenario{
+	"latency-ramp": {
+		Name:        "latency-ramp",
+		Description: "Latency creeps up over five minutes, then recovers",
+		Steps: []ScenarioStep{
+			latencyStep(0, time.Minute, 100, "Latency +100ms"),
+			latencyStep(time.Minute, time.Minute, 300, "Latency +300ms"),
+			latencyStep(2*time.Minute, time.Minute, 800, "Latency +800ms"),
+			latencyStep(3*time.Minute, 2*time.Minute, 2000, "Latency +2s, SLO burning"),
+		},
+	},
+	"error-burst": {
+		Name:        "error-burst",
+		Description: "A third of order creations fail for three minutes",
+		Steps: []ScenarioStep{
+			errorStep(0, 3*time.Minute, "POST", "/api/v1/orders", 0.33, http.StatusInternalServerError, "33% of order creations fail"),
+		},
+	},
+	"dependency-outage": {
+		Name:        "dependency-outage",
+		Description: "Redis goes down for two minutes, then PostgreSQL for three",
+		Steps: []ScenarioStep{
+			outageStep(0, 2*time.Minute, "redis", "Redis outage, cache misses everywhere"),
+			outageStep(2*time.Minute, 3*time.Minute, "postgres", "PostgreSQL outage, order API fails"),
+		},
+	},
+	"full-incident": {
+		Name:        "full-incident",
+		Description: "Latency ramp, error burst, outage and recovery over nine minutes",
+		Steps: []ScenarioStep{
+			latencyStep(0, 2*time.Minute, 200, "Latency ramp: +200ms"),
+			latencyStep(2*time.Minute, 2*time.Minute, 1000, "Latency ramp: +1s"),
+			errorStep(4*time.Minute, 2*time.Minute, "POST", "/api/v1/orders", 0.25, http.StatusInternalServerError, "Error burst on order creation"),
+			latencyStep(4*time.Minute, 2*time.Minute, 1000, "Latency stays high during the error burst"),
+			outageStep(6*time.Minute, 3*time.Minute, "postgres", "PostgreSQL outage"),
+		},
+	},
+}
+
+// ScenarioRun is the JSON view of a running scenario
+type ScenarioRun struct {
+	Scenario    string    `json:"scenario"`
+	Speed       float64   `json:"speed"`
+	StartedAt   time.Time `json:"started_at"`
+	EndsAt      time.Time `json:"ends_at"`
+	CurrentStep int       `json:"current_step"`
+	Description string    `json:"current_description,omitempty"`
+}
+
+// scenarioRunner runs at most one scenario at a time
+type scenarioRunner struct {
+	mu      sync.Mutex
+	run     *ScenarioRun
+	cancel  context.CancelFunc
+	ruleIDs []int
+	outages []string
+}
+
+var (
+	// activeScenario is the process-wide scenario runner
+	activeScenario = &scenarioRunner{}
+
+	// Gauge: 1 while a scenario is running
+	scenarioActive = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "chaos_scenario_active",
+			Help: "Whether an incident scenario is running (1) or not (0)",
+		},
+		[]string{"scenario"},
+	)
+
+	// Gauge: Number of the scenario step that started last
+	scenarioStep = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "chaos_scenario_step",
+			Help: "Number of the incident scenario step that started last (0 when idle)",
+		},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(scenarioActive)
+	prometheus.MustRegister(scenarioStep)
+
+	// Expose step timings in seconds for the JSON listing
+	for name, sc := range scenarios {
+		for i := range sc.Steps {
+			sc.Steps[i].AtSeconds = sc.Steps[i].At.Seconds()
+			sc.Steps[i].DurationSeconds = sc.Steps[i].Duration.Seconds()
+		}
+		scenarios[name] = sc
+	}
+}
+
+// start begins running a scenario, failing if one is already running
+func (r *scenarioRunner) start(sc Scenario, speed float64) (*ScenarioRun, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.run != nil {
+		return r.snapshotLocked(), false
+	}
+
+	now := time.Now().UTC()
+	ctx, cancel := context.WithCancel(backgroundCtx)
+	r.cancel = cancel
+	r.ruleIDs = nil
+	r.outages = nil
+	r.run = &ScenarioRun{
+		Scenario:  sc.Name,
+		Speed:     speed,
+		StartedAt: now,
+		EndsAt:    now.Add(time.Duration(float64(sc.length()) / speed)),
+	}
+	scenarioActive.WithLabelValues(sc.Name).Set(1)
+
+	go r.execute(ctx, sc, speed)
+	return r.snapshotLocked(), true
+}
+
+// execute activates each step at its offset until done or cancelled
+func (r *scenarioRunner) execute(ctx context.Context, sc Scenario, speed float64) {
+	steps := append([]ScenarioStep(nil), sc.Steps...)
+	sort.SliceStable(steps, func(i, j int) bool { return steps[i].At < steps[j].At })
+	start := time.Now()
+
+	scale := func(d time.Duration) time.Duration { return time.Duration(float64(d) / speed) }
+
+	for i, step := range steps {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Until(start.Add(scale(step.At)))):
+		}
+
+		now := time.Now().UTC()
+		until := now.Add(scale(step.Duration))
+
+		r.mu.Lock()
+		if step.Outage != "" {
+			outages.start(step.Outage, until)
+			r.outages = append(r.outages, step.Outage)
+		} else {
+			rule := *step.Rule
+			rule.CreatedAt = now
+			rule.ExpiresAt = until
+			rule = chaos.add(rule)
+			r.ruleIDs = append(r.ruleIDs, rule.ID)
+		}
+		if r.run != nil {
+			r.run.CurrentStep = i + 1
+			r.run.Description = step.Description
+		}
+		r.mu.Unlock()
+		scenarioStep.Set(float64(i + 1))
+
+		logWarnContext(ctx, "Scenario step started", map[string]interface{}{
+			"scenario":    sc.Name,
+			"step":        i + 1,
+			"description": step.Description,
+			"outage":      step.Outage,
+			"duration":    scale(step.Duration).String(),
+		})
+	}
+
+	// Wait for the last rules to expire, then mark the run as finished
+	select {
+	case <-ctx.Done():
+		return
+	case <-time.After(time.Until(start.Add(scale(sc.length())))):
+	}
+	r.finish(sc.Name, "completed")
+}
+
+// stop cancels the running scenario and removes its rules
+func (r *scenarioRunner) stop() (string, bool) {
+	r.mu.Lock()
+	if r.run == nil {
+		r.mu.Unlock()
+		return "", false
+	}
+	name := r.run.Scenario
+	r.cancel()
+	for _, id := range r.ruleIDs {
+		chaos.remove(id)
+	}
+	for _, dep := range r.outages {
+		outages.end(dep)
+	}
+	r.mu.Unlock()
+
+	r.finish(name, "stopped")
+	return name, true
+}
+
+// finish clears the run state
+func (r *scenarioRunner) finish(name, outcome string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.run == nil || r.run.Scenario != name {
+		return
+	}
+	r.run = nil
+	r.ruleIDs = nil
+	r.outages = nil
+	scenarioActive.WithLabelValues(name).Set(0)
+	scenarioStep.Set(0)
+
+	logInfo("Scenario finished", map[string]interface{}{
+		"scenario": name,
+		"outcome":  outcome,
+	})
+	annotate("scenario", "Incident scenario %s %s", name, outcome)
+}
+
+// snapshot returns a copy of the running scenario, or nil
+func (r *scenarioRunner) snapshot() *ScenarioRun {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.snapshotLocked()
+}
+
+func (r *scenarioRunner) snapshotLocked() *ScenarioRun {
+	if r.run == nil {
+		return nil
+	}
+	run := *r.run
+	return &run
+}
+
+// =============================================================================
+// SCENARIO ADMIN HANDLERS
+// =============================================================================
+
+// listScenarios returns the built-in scenarios and the active run
+func listScenarios(c *gin.Context) {
+	list := make([]Scenario, 0, len(scenarios))
+	for _, sc := range scenarios {
+		list = append(list, sc)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+
+	c.JSON(http.StatusOK, gin.H{"scenarios": list, "active": activeScenario.snapshot()})
+}
+
+// startScenario starts a built-in scenario
+func startScenario(c *gin.Context) {
+	if !chaosEnabled {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Chaos features are disabled (CHAOS_ENABLED=false)"})
+		return
+	}
+
+	sc, ok := scenarios[c.Param("name")]
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Scenario not found"})
+		return
+	}
+
+	var req struct {
+		Speed float64 `json:"speed"`
+	}
+	c.ShouldBindJSON(&req)
+	if req.Speed <= 0 {
+		req.Speed = 1
+	}
+	if req.Speed > 60 {
+		req.Speed = 60
+	}
+
+	run, started := activeScenario.start(sc, req.Speed)
+	if !started {
+		c.JSON(http.StatusConflict, gin.H{"error": "Another scenario is running", "active": run})
+		return
+	}
+
+	logWarnContext(c.Request.Context(), "Scenario started", map[string]interface{}{
+		"scenario": run.Scenario,
+		"speed":    run.Speed,
+		"ends_at":  run.EndsAt,
+	})
+	annotate("scenario", "Incident scenario %s started (speed %gx)", run.Scenario, run.Speed)
+	c.JSON(http.StatusAccepted, run)
+}
+
+// stopScenario stops the active scenario
+func stopScenario(c *gin.Context) {
+	name, ok := activeScenario.stop()
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No scenario is running"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Scenario stopped", "scenario": name})
+}
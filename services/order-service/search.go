@@ -0,0 +1,514 @@
+// =============================================================================
+// ORDER SEARCH (OPENSEARCH)
+// =============================================================================
+// Optional full-text and faceted order search. When SEARCH_URL points at an
+// OpenSearch (or Elasticsearch) cluster, the search-index job mirrors every
+// order into SEARCH_INDEX as one denormalized document with its items:
+//
+//   {"id": "...", "status": "shipped", "customer_name": "...",
+//    "total_amount": 42.5, "items": [{"sku": "SKU-0001", "name": "..."}]}
+//
+// INDEXING:
+// The job follows the same change feed as the stats rollups: orders whose
+// updated_at moved past the search_index watermark (in rollup_watermarks)
+// are bulk indexed, so API changes, imports and seeded data all reach the
+// index within SEARCH_INDEX_INTERVAL. Documents are keyed by order ID, so
+// reindexing is idempotent; a reset empties the index.
+//
+// ENDPOINTS:
+// - GET /api/v1/orders/search  Search orders, with facets
+//
+//   q          Free text over customer, email, address, notes, item names,
+//              or an order number
+//   status     Exact status (repeatable)
+//   sku        Orders containing the SKU (repeatable)
+//   min_total, max_total, from, to (RFC 3339 or YYYY-MM-DD, in tz), page, per_page
+//
+// Facets: counts by status and currency, the top SKUs and total ranges.
+// Without SEARCH_URL the endpoint returns 503.
+// =============================================================================
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// searchWatermarkName is the rollup_watermarks key of the indexer
+	searchWatermarkName = "search_index"
+
+	// searchBulkSize is the number of orders per bulk request
+	searchBulkSize = 500
+
+	// searchIndexLag keeps the indexer behind transactions that are still
+	// committing, so their rows aren't skipped
+	searchIndexLag = 5 * time.Second
+)
+
+var (
+	// searchURL is the OpenSearch base URL (empty = search disabled)
+	searchURL string
+
+	// searchIndex is the index orders are mirrored into
+	searchIndex = "orders"
+
+	// searchUsername and searchPassword are optional basic auth credentials
+	searchUsername string
+	searchPassword string
+
+	// searchClient talks to OpenSearch
+	searchClient = &http.Client{Timeout: 30 * time.Second}
+
+	// Counter: Orders indexed, by result
+	searchIndexedTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "search_orders_indexed_total",
+			Help: "Total number of order documents sent to the search index, by result",
+		},
+		[]string{"result"},
+	)
+
+	// Gauge: How far the search index lags behind the orders table
+	searchIndexLagSeconds = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "search_index_lag_seconds",
+			Help: "Age of the search index watermark",
+		},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(searchIndexedTotal)
+	prometheus.MustRegister(searchIndexLagSeconds)
+}
+
+// searchEnabled reports whether search is configured
+func searchEnabled() bool {
+	return searchURL != ""
+}
+
+// searchMapping is the index mapping of order documents
+const searchMapping = `{
+  "mappings": {
+    "properties": {
+      "id":               {"type": "keyword"},
+      "order_number":     {"type": "keyword"},
+      "customer_id":      {"type": "keyword"},
+      "customer_name":    {"type": "text", "fields": {"raw": {"type": "keyword"}}},
+      "customer_email":   {"type": "keyword"},
+      "status":           {"type": "keyword"},
+      "total_amount":     {"type": "double"},
+      "currency":         {"type": "keyword"},
+      "shipping_address": {"type": "text"},
+      "notes":            {"type": "text"},
+      "created_at":       {"type": "date"},
+      "updated_at":       {"type": "date"},
+      "items": {
+        "type": "nested",
+        "properties": {
+          "sku":        {"type": "keyword"},
+          "name":       {"type": "text"},
+          "quantity":   {"type": "integer"},
+          "unit_price": {"type": "double"}
+        }
+      }
+    }
+  }
+}`
+
+// searchRequest sends a request to OpenSearch and decodes the JSON
+// response into out if given
+func searchRequest(ctx context.Context, method, path, contentType string, body []byte, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimRight(searchURL, "/")+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if searchUsername != "" {
+		req.SetBasicAuth(searchUsername, searchPassword)
+	}
+
+	resp, err := searchClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("search cluster unreachable: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("search cluster returned status %d: %s", resp.StatusCode, msg)
+	}
+	if out != nil {
+		return json.NewDecoder(resp.Body).Decode(out)
+	}
+	io.Copy(io.Discard, resp.Body)
+	return nil
+}
+
+// ensureSearchIndex creates the order index if it doesn't exist
+func ensureSearchIndex(ctx context.Context) error {
+	err := searchRequest(ctx, http.MethodHead, "/"+searchIndex, "", nil, nil)
+	if err == nil {
+		return nil
+	}
+	return searchRequest(ctx, http.MethodPut, "/"+searchIndex, "application/json", []byte(searchMapping), nil)
+}
+
+// indexOrders is the search-index job: it bulk indexes the orders changed
+// since the watermark
+func (a *App) indexOrders(ctx context.Context) error {
+	if err := ensureSearchIndex(ctx); err != nil {
+		return err
+	}
+
+	var watermark time.Time
+	err := a.db.QueryRowContext(ctx, `
+		SELECT COALESCE((SELECT refreshed_until FROM rollup_watermarks WHERE name = $1), 'epoch'::timestamptz)
+	`, searchWatermarkName).Scan(&watermark)
+	if err != nil {
+		return err
+	}
+	cutoff := time.Now().Add(-searchIndexLag)
+
+	// Page through the changes with a (updated_at, id) keyset, so large
+	// imports sharing one timestamp are indexed in several bulks
+	lastUpdated, lastID := watermark, ""
+	indexed := 0
+	for {
+		orders, err := a.changedOrders(ctx, lastUpdated, lastID, cutoff)
+		if err != nil {
+			return err
+		}
+		if len(orders) == 0 {
+			break
+		}
+		if err := bulkIndex(ctx, orders); err != nil {
+			return err
+		}
+		indexed += len(orders)
+		last := orders[len(orders)-1]
+		lastUpdated, lastID = last.UpdatedAt, last.ID
+	}
+
+	if _, err := a.db.ExecContext(ctx, `
+		INSERT INTO rollup_watermarks (name, refreshed_until) VALUES ($1, $2)
+		ON CONFLICT (name) DO UPDATE SET refreshed_until = EXCLUDED.refreshed_until
+	`, searchWatermarkName, cutoff); err != nil {
+		return err
+	}
+	searchIndexLagSeconds.Set(time.Since(cutoff).Seconds())
+
+	if indexed > 0 {
+		logInfoContext(ctx, "Orders indexed for search", map[string]interface{}{
+			"orders": indexed,
+		})
+	}
+	return nil
+}
+
+// changedOrders returns the next page of orders updated after
+// (afterUpdated, afterID) and at or before cutoff, with their items
+func (a *App) changedOrders(ctx context.Context, afterUpdated time.Time, afterID string, cutoff time.Time) ([]Order, error) {
+	rows, err := a.db.QueryContext(ctx, `
+		SELECT id, order_number, customer_id, customer_name, customer_email, status,
+		       total_amount, currency, COALESCE(shipping_address, ''), COALESCE(notes, ''),
+		       created_at, updated_at
+		FROM orders
+		WHERE (updated_at, id::text) > ($1, $2) AND updated_at <= $3
+		ORDER BY updated_at, id::text
+		LIMIT $4
+	`, afterUpdated, afterID, cutoff, searchBulkSize)
+	if err != nil {
+		return nil, err
+	}
+
+	var orders []Order
+	byID := map[string]int{}
+	ids := make([]string, 0, searchBulkSize)
+	for rows.Next() {
+		var o Order
+		if err := rows.Scan(&o.ID, &o.Number, &o.CustomerID, &o.CustomerName, &o.CustomerEmail, &o.Status,
+			&o.TotalAmount, &o.Currency, &o.ShippingAddress, &o.Notes, &o.CreatedAt, &o.UpdatedAt); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		byID[o.ID] = len(orders)
+		ids = append(ids, o.ID)
+		orders = append(orders, o)
+	}
+	rows.Close()
+	if len(orders) == 0 {
+		return nil, nil
+	}
+
+	itemRows, err := a.db.QueryContext(ctx, `
+		SELECT id, order_id, sku, name, quantity, unit_price, total_price
+		FROM order_items WHERE order_id::text = ANY(string_to_array($1, ','))
+	`, strings.Join(ids, ","))
+	if err != nil {
+		return nil, err
+	}
+	defer itemRows.Close()
+	for itemRows.Next() {
+		var item OrderItem
+		if err := itemRows.Scan(&item.ID, &item.OrderID, &item.SKU, &item.Name,
+			&item.Quantity, &item.UnitPrice, &item.TotalPrice); err != nil {
+			return nil, err
+		}
+		if i, ok := byID[item.OrderID]; ok {
+			orders[i].Items = append(orders[i].Items, item)
+		}
+	}
+	return orders, itemRows.Err()
+}
+
+// bulkIndex sends orders to the index in one _bulk request
+func bulkIndex(ctx context.Context, orders []Order) error {
+	var body bytes.Buffer
+	enc := json.NewEncoder(&body)
+	for _, o := range orders {
+		enc.Encode(map[string]interface{}{"index": map[string]string{"_index": searchIndex, "_id": o.ID}})
+		enc.Encode(o)
+	}
+
+	var resp struct {
+		Errors bool `json:"errors"`
+		Items  []map[string]struct {
+			Status int `json:"status"`
+		} `json:"items"`
+	}
+	if err := searchRequest(ctx, http.MethodPost, "/_bulk", "application/x-ndjson", body.Bytes(), &resp); err != nil {
+		searchIndexedTotal.WithLabelValues("failure").Add(float64(len(orders)))
+		return err
+	}
+
+	failed := 0
+	for _, item := range resp.Items {
+		for _, result := range item {
+			if result.Status >= 300 {
+				failed++
+			}
+		}
+	}
+	searchIndexedTotal.WithLabelValues("success").Add(float64(len(orders) - failed))
+	if failed > 0 {
+		searchIndexedTotal.WithLabelValues("failure").Add(float64(failed))
+		return fmt.Errorf("%d of %d order documents failed to index", failed, len(orders))
+	}
+	return nil
+}
+
+// clearSearchIndex deletes every document, for demo resets
+func clearSearchIndex(ctx context.Context) error {
+	return searchRequest(ctx, http.MethodPost, "/"+searchIndex+"/_delete_by_query?refresh=true",
+		"application/json", []byte(`{"query": {"match_all": {}}}`), nil)
+}
+
+// =============================================================================
+// SEARCH HANDLER
+// =============================================================================
+
+// parseSearchTime accepts RFC 3339 timestamps and plain dates
+func parseSearchTime(s string) (string, error) {
+	if _, err := time.Parse(time.RFC3339, s); err == nil {
+		return s, nil
+	}
+	if _, err := time.Parse("2006-01-02", s); err == nil {
+		return s, nil
+	}
+	return "", fmt.Errorf("invalid time %q (expected RFC 3339 or YYYY-MM-DD)", s)
+}
+
+// buildSearchQuery turns the search parameters into an OpenSearch query
+func (a *App) buildSearchQuery(c *gin.Context) (map[string]interface{}, error) {
+	must, filter := []interface{}{}, []interface{}{}
+
+	if q := strings.TrimSpace(c.Query("q")); q != "" {
+		should := []interface{}{
+			map[string]interface{}{"multi_match": map[string]interface{}{
+				"query":  q,
+				"fields": []string{"customer_name^3", "customer_email^2", "shipping_address", "notes", "id"},
+			}},
+			map[string]interface{}{"nested": map[string]interface{}{
+				"path":  "items",
+				"query": map[string]interface{}{"match": map[string]interface{}{"items.name": q}},
+			}},
+		}
+		// Order numbers as read out by customers (see ordernumbers.go)
+		if number := normalizeOrderNumber(q); number != "" {
+			should = append(should, map[string]interface{}{"term": map[string]interface{}{
+				"order_number": map[string]interface{}{"value": number, "boost": 10},
+			}})
+		}
+		must = append(must, map[string]interface{}{
+			"bool": map[string]interface{}{
+				"should":               should,
+				"minimum_should_match": 1,
+			},
+		})
+	}
+	if statuses := c.QueryArray("status"); len(statuses) > 0 {
+		filter = append(filter, map[string]interface{}{"terms": map[string]interface{}{"status": statuses}})
+	}
+	for _, sku := range c.QueryArray("sku") {
+		filter = append(filter, map[string]interface{}{"nested": map[string]interface{}{
+			"path":  "items",
+			"query": map[string]interface{}{"term": map[string]interface{}{"items.sku": sku}},
+		}})
+	}
+
+	total := map[string]interface{}{}
+	for param, op := range map[string]string{"min_total": "gte", "max_total": "lte"} {
+		if v := c.Query(param); v != "" {
+			f, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s", param)
+			}
+			total[op] = f
+		}
+	}
+	if len(total) > 0 {
+		filter = append(filter, map[string]interface{}{"range": map[string]interface{}{"total_amount": total}})
+	}
+
+	created := map[string]interface{}{}
+	for param, op := range map[string]string{"from": "gte", "to": "lte"} {
+		if v := c.Query(param); v != "" {
+			t, err := parseSearchTime(v)
+			if err != nil {
+				return nil, err
+			}
+			created[op] = t
+		}
+	}
+	if len(created) > 0 {
+		// Plain dates are days in the caller's timezone (see timezone.go)
+		loc, err := a.resolveTimezone(c)
+		if err != nil {
+			return nil, err
+		}
+		created["time_zone"] = loc.String()
+		filter = append(filter, map[string]interface{}{"range": map[string]interface{}{"created_at": created}})
+	}
+
+	return map[string]interface{}{
+		"bool": map[string]interface{}{"must": must, "filter": filter},
+	}, nil
+}
+
+// searchFacets are the aggregations returned with every search
+var searchFacets = map[string]interface{}{
+	"status":   map[string]interface{}{"terms": map[string]interface{}{"field": "status"}},
+	"currency": map[string]interface{}{"terms": map[string]interface{}{"field": "currency"}},
+	"skus": map[string]interface{}{
+		"nested": map[string]interface{}{"path": "items"},
+		"aggs": map[string]interface{}{
+			"top": map[string]interface{}{"terms": map[string]interface{}{"field": "items.sku", "size": 10}},
+		},
+	},
+	"total_ranges": map[string]interface{}{"range": map[string]interface{}{
+		"field": "total_amount",
+		"ranges": []map[string]interface{}{
+			{"key": "under_25", "to": 25},
+			{"key": "25_to_100", "from": 25, "to": 100},
+			{"key": "100_to_500", "from": 100, "to": 500},
+			{"key": "over_500", "from": 500},
+		},
+	}},
+}
+
+// searchBucket is one facet value and its count
+type searchBucket struct {
+	Key   interface{} `json:"key"`
+	Count int64       `json:"doc_count"`
+}
+
+// searchOrders searches the order index
+func (a *App) searchOrders(c *gin.Context) {
+	if !searchEnabled() {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": tr(c, "Search is not configured (SEARCH_URL is empty)")})
+		return
+	}
+
+	query, err := a.buildSearchQuery(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	perPage, _ := strconv.Atoi(c.DefaultQuery("per_page", "20"))
+	if page < 1 {
+		page = 1
+	}
+	if perPage < 1 || perPage > 100 {
+		perPage = 20
+	}
+
+	body, _ := json.Marshal(map[string]interface{}{
+		"query":            query,
+		"from":             (page - 1) * perPage,
+		"size":             perPage,
+		"sort":             []interface{}{"_score", map[string]string{"created_at": "desc"}},
+		"aggs":             searchFacets,
+		"track_total_hits": true,
+	})
+
+	var resp struct {
+		Hits struct {
+			Total struct {
+				Value int64 `json:"value"`
+			} `json:"total"`
+			Hits []struct {
+				Source Order `json:"_source"`
+			} `json:"hits"`
+		} `json:"hits"`
+		Aggregations struct {
+			Status      struct{ Buckets []searchBucket } `json:"status"`
+			Currency    struct{ Buckets []searchBucket } `json:"currency"`
+			TotalRanges struct{ Buckets []searchBucket } `json:"total_ranges"`
+			SKUs        struct {
+				Top struct{ Buckets []searchBucket } `json:"top"`
+			} `json:"skus"`
+		} `json:"aggregations"`
+	}
+	if err := searchRequest(c.Request.Context(), http.MethodPost, "/"+searchIndex+"/_search",
+		"application/json", body, &resp); err != nil {
+		logErrorContext(c.Request.Context(), "Order search failed", map[string]interface{}{
+			"error": err.Error(),
+		})
+		c.JSON(http.StatusBadGateway, gin.H{"error": tr(c, "Search cluster error")})
+		return
+	}
+
+	orders := make([]Order, 0, len(resp.Hits.Hits))
+	for _, hit := range resp.Hits.Hits {
+		orders = append(orders, hit.Source)
+	}
+
+	writeJSON(c, http.StatusOK, gin.H{
+		"orders":   orders,
+		"total":    resp.Hits.Total.Value,
+		"page":     page,
+		"per_page": perPage,
+		"facets": gin.H{
+			"status":       resp.Aggregations.Status.Buckets,
+			"currency":     resp.Aggregations.Currency.Buckets,
+			"skus":         resp.Aggregations.SKUs.Top.Buckets,
+			"total_ranges": resp.Aggregations.TotalRanges.Buckets,
+		},
+	})
+}
@@ -0,0 +1,349 @@
+// =============================================================================
+// DEMO DATA SEEDER
+// =============================================================================
+// Empty dashboards teach nothing. When SEED_DEMO_DATA is true (the default
+// in the dev profile, and only allowed there) and the orders table is
+// empty, the service fills it with SEED_ORDERS orders spread over the last
+// SEED_DAYS days. POST /admin/seed runs the seeder on demand, also only in
+// the dev profile.
+//
+// DISTRIBUTIONS:
+// - Customers   Pareto: a few customers place most of the orders
+// - SKUs        Zipf: a handful of best sellers, a long tail
+// - Time        Weekly seasonality (weekend peak), an evening peak during
+//               the day, gentle growth over the window and a few promo
+//               days with 3-4x the usual volume
+// - Status      A realistic funnel by order age: recent orders are pending
+//               or processing, older ones shipped or delivered, ~6% are
+//               cancelled
+//
+// The data is generated from SEED_RANDOM_SEED, so every lab install looks
+// the same. Orders are loaded with COPY (see import.go).
+// =============================================================================
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// seedNote marks orders created by the seeder
+const seedNote = "demo-seed"
+
+// SeedOptions controls the size and shape of the demo data
+type SeedOptions struct {
+	Orders    int   `json:"orders"`
+	Customers int   `json:"customers"`
+	SKUs      int   `json:"skus"`
+	Days      int   `json:"days"`
+	Seed      int64 `json:"seed"`
+}
+
+var (
+	// appSeedOptions are the seeding options from the configuration
+	appSeedOptions SeedOptions
+
+	// seedRunning prevents two seeding runs from overlapping
+	seedRunning atomic.Bool
+)
+
+var (
+	seedFirstNames = []string{"Olivia", "Liam", "Emma", "Noah", "Ava", "Mateo", "Sofia", "Lucas",
+		"Mia", "Hiroshi", "Amara", "Elena", "Kwame", "Priya", "Jonas", "Chloe", "Omar", "Ingrid"}
+	seedLastNames = []string{"Smith", "Garcia", "Mueller", "Rossi", "Kowalski", "Tanaka", "Okafor",
+		"Silva", "Johansson", "Patel", "Dubois", "Nguyen", "Kim", "Cohen", "Brown", "Novak"}
+	seedAdjectives = []string{"Classic", "Ultra", "Eco", "Pro", "Compact", "Deluxe", "Smart", "Travel"}
+	seedProducts   = []string{"Backpack", "Headphones", "Water Bottle", "Desk Lamp", "Keyboard",
+		"Running Shoes", "Coffee Grinder", "Yoga Mat", "Phone Case", "Notebook", "Sunglasses", "Charger"}
+	seedCities = []string{"Berlin, Germany", "Austin, USA", "Lisbon, Portugal", "Osaka, Japan",
+		"Toronto, Canada", "Lagos, Nigeria", "Melbourne, Australia", "Lyon, France"}
+)
+
+// seedCustomer is a generated customer
+type seedCustomer struct {
+	id, name, email, address string
+}
+
+// seedSKU is a generated catalog item
+type seedSKU struct {
+	sku, name string
+	price     float64
+}
+
+// seedOnStartup seeds demo data in the background if the orders table is
+// empty, so startup isn't delayed
+func (a *App) seedOnStartup(opts SeedOptions) {
+	go func() {
+		var count int
+		if err := a.db.QueryRowContext(backgroundCtx, `SELECT COUNT(*) FROM (SELECT 1 FROM orders LIMIT 1) t`).Scan(&count); err != nil {
+			logError("Failed to check for existing orders before seeding", map[string]interface{}{
+				"error": err.Error(),
+			})
+			return
+		}
+		if count > 0 {
+			logInfo("Orders table is not empty, skipping demo data seeding", nil)
+			return
+		}
+		if _, err := a.seedDemoData(backgroundCtx, opts); err != nil {
+			logError("Demo data seeding failed", map[string]interface{}{
+				"error": err.Error(),
+			})
+		}
+	}()
+}
+
+// seedDemoData generates and copies the demo orders, returning how many
+// were imported
+func (a *App) seedDemoData(ctx context.Context, opts SeedOptions) (int, error) {
+	if !seedRunning.CompareAndSwap(false, true) {
+		return 0, fmt.Errorf("seeding is already running")
+	}
+	defer seedRunning.Store(false)
+
+	start := time.Now()
+	rng := rand.New(rand.NewSource(opts.Seed))
+	customers := seedCustomers(rng, opts.Customers)
+	skus := seedSKUs(rng, opts.SKUs)
+
+	// Pareto-distributed customer weights (alpha ~1.16 gives the 80/20 rule)
+	customerWeights := make([]float64, len(customers))
+	for i := range customerWeights {
+		customerWeights[i] = math.Pow(1-rng.Float64(), -1/1.16)
+	}
+	pickCustomer := weightedPicker(rng, customerWeights)
+	pickSKU := rand.NewZipf(rng, 1.2, 1, uint64(len(skus)-1))
+
+	now := time.Now().UTC()
+	days := seedDayWeights(rng, opts.Days)
+	pickDay := weightedPicker(rng, days)
+
+	imported := 0
+	batch := make([]importRecord, 0, importBatchSize)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		result := a.copyOrderBatch(ctx, imported/importBatchSize+1, batch)
+		if result.Error != "" {
+			return fmt.Errorf("batch %d: %s", result.Batch, result.Error)
+		}
+		imported += result.Orders
+		batch = batch[:0]
+		return nil
+	}
+
+	for i := 0; i < opts.Orders; i++ {
+		// Day in the window (0 = today), then a time of day
+		dayAgo := opts.Days - 1 - pickDay()
+		createdAt := now.Truncate(24*time.Hour).AddDate(0, 0, -dayAgo).Add(seedTimeOfDay(rng))
+		if createdAt.After(now) {
+			createdAt = now.Add(-time.Duration(rng.Intn(3600)) * time.Second)
+		}
+
+		customer := customers[pickCustomer()]
+		items := make([]OrderItemRequest, 1+int(math.Min(4, rng.ExpFloat64())))
+		var total float64
+		for j := range items {
+			s := skus[pickSKU.Uint64()]
+			items[j] = OrderItemRequest{SKU: s.sku, Name: s.name, Quantity: 1 + int(rng.ExpFloat64()*0.7), UnitPrice: s.price}
+			total += lineTotal(items[j].Quantity, s.price, defaultCurrency)
+		}
+
+		batch = append(batch, importRecord{
+			ID:              uuid.NewString(),
+			CustomerID:      customer.id,
+			CustomerName:    customer.name,
+			CustomerEmail:   customer.email,
+			Status:          seedStatus(rng, now.Sub(createdAt)),
+			TotalAmount:     &total,
+			Currency:        defaultCurrency,
+			ShippingAddress: customer.address,
+			Notes:           seedNote,
+			CreatedAt:       &createdAt,
+			Items:           items,
+		})
+		if len(batch) >= importBatchSize {
+			if err := flush(); err != nil {
+				return imported, err
+			}
+		}
+	}
+	if err := flush(); err != nil {
+		return imported, err
+	}
+
+	logInfoContext(ctx, "Demo data seeded", map[string]interface{}{
+		"orders":      imported,
+		"customers":   len(customers),
+		"skus":        len(skus),
+		"days":        opts.Days,
+		"duration_ms": time.Since(start).Milliseconds(),
+	})
+	return imported, nil
+}
+
+// seedCustomers generates customers with stable IDs
+func seedCustomers(rng *rand.Rand, n int) []seedCustomer {
+	customers := make([]seedCustomer, n)
+	for i := range customers {
+		first := seedFirstNames[rng.Intn(len(seedFirstNames))]
+		last := seedLastNames[rng.Intn(len(seedLastNames))]
+		customers[i] = seedCustomer{
+			id:      fmt.Sprintf("5eed0000-0000-4000-8000-%012d", i),
+			name:    first + " " + last,
+			email:   fmt.Sprintf("%s.%s%d@example.com", strings.ToLower(first), strings.ToLower(last), i),
+			address: fmt.Sprintf("%d Market Street, %s", 1+rng.Intn(300), seedCities[rng.Intn(len(seedCities))]),
+		}
+	}
+	return customers
+}
+
+// seedSKUs generates a catalog with log-normally distributed prices
+func seedSKUs(rng *rand.Rand, n int) []seedSKU {
+	skus := make([]seedSKU, n)
+	for i := range skus {
+		price := math.Round(math.Exp(3.2+rng.NormFloat64()*0.8)*100) / 100
+		skus[i] = seedSKU{
+			sku:   fmt.Sprintf("SKU-%05d", 10000+i),
+			name:  seedAdjectives[rng.Intn(len(seedAdjectives))] + " " + seedProducts[rng.Intn(len(seedProducts))],
+			price: math.Max(price, 1.99),
+		}
+	}
+	return skus
+}
+
+// seedDayWeights returns the relative order volume of each day in the
+// window, oldest first
+func seedDayWeights(rng *rand.Rand, days int) []float64 {
+	// A few promo days with a large spike
+	promos := map[int]bool{}
+	for i := 0; i < days/30+1; i++ {
+		promos[rng.Intn(days)] = true
+	}
+
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	weights := make([]float64, days)
+	for i := range weights {
+		day := today.AddDate(0, 0, i-(days-1))
+		w := 1.0 + 0.5*float64(i)/float64(days) // growth over the window
+		switch day.Weekday() {
+		case time.Saturday, time.Sunday:
+			w *= 1.4
+		case time.Monday:
+			w *= 1.1
+		}
+		if promos[i] {
+			w *= 3 + rng.Float64()
+		}
+		weights[i] = w * (0.9 + 0.2*rng.Float64())
+	}
+	return weights
+}
+
+// seedTimeOfDay picks a time of day, with most orders in the evening
+func seedTimeOfDay(rng *rand.Rand) time.Duration {
+	hour := math.Mod(19+rng.NormFloat64()*4+24, 24)
+	return time.Duration(hour * float64(time.Hour))
+}
+
+// seedStatus picks a status from the fulfilment funnel for an order age
+func seedStatus(rng *rand.Rand, age time.Duration) string {
+	r := rng.Float64()
+	if r < 0.06 {
+		return "cancelled"
+	}
+	switch {
+	case age < 2*time.Hour:
+		if r < 0.7 {
+			return "pending"
+		}
+		return "processing"
+	case age < 24*time.Hour:
+		if r < 0.2 {
+			return "pending"
+		}
+		if r < 0.75 {
+			return "processing"
+		}
+		return "shipped"
+	case age < 5*24*time.Hour:
+		if r < 0.15 {
+			return "processing"
+		}
+		if r < 0.7 {
+			return "shipped"
+		}
+		return "delivered"
+	default:
+		if r < 0.08 {
+			return "shipped"
+		}
+		return "delivered"
+	}
+}
+
+// weightedPicker returns a function picking indexes proportionally to weights
+func weightedPicker(rng *rand.Rand, weights []float64) func() int {
+	cumulative := make([]float64, len(weights))
+	total := 0.0
+	for i, w := range weights {
+		total += w
+		cumulative[i] = total
+	}
+	return func() int {
+		target := rng.Float64() * total
+		lo, hi := 0, len(cumulative)-1
+		for lo < hi {
+			mid := (lo + hi) / 2
+			if cumulative[mid] < target {
+				lo = mid + 1
+			} else {
+				hi = mid
+			}
+		}
+		return lo
+	}
+}
+
+// seedDemoDataHandler runs the seeder on demand
+func (a *App) seedDemoDataHandler(c *gin.Context) {
+	if appConfig.AppEnv != "dev" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Demo seeding is only available in the dev profile (APP_ENV=dev)"})
+		return
+	}
+	opts := appSeedOptions
+	if err := c.ShouldBindJSON(&opts); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if opts.Orders <= 0 || opts.Orders > 5000000 || opts.Customers <= 0 || opts.SKUs < 2 || opts.Days <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "orders, customers, days must be positive and skus at least 2"})
+		return
+	}
+
+	start := time.Now()
+	imported, err := a.seedDemoData(c.Request.Context(), opts)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "imported": imported})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message":     "Demo data seeded",
+		"imported":    imported,
+		"options":     opts,
+		"duration_ms": time.Since(start).Milliseconds(),
+	})
+}
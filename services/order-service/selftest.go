@@ -0,0 +1,142 @@
+// =============================================================================
+// LATENCY SELF-TEST
+// =============================================================================
+// GET /admin/selftest runs the service's hot paths in process a number of
+// times and reports their latency percentiles. It answers "is this
+// instance slow, or is it the network/load balancer?" without an external
+// load generator. For release-to-release comparisons use cmd/bench.
+//
+// PROBES:
+// - database   SELECT 1 round trip
+// - redis      PING round trip
+// - list       The order list query (20 rows)
+// - serialize  Encoding those rows as an order list response
+// =============================================================================
+
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"order-service/jsonenc"
+)
+
+// Gauge: p99 latency of the last self-test, by probe
+var selftestLatency = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "selftest_latency_p99_seconds",
+		Help: "p99 latency measured by the last latency self-test",
+	},
+	[]string{"probe"},
+)
+
+func init() {
+	prometheus.MustRegister(selftestLatency)
+}
+
+// SelfTestProbe reports the latency of one probe
+type SelfTestProbe struct {
+	Name   string  `json:"name"`
+	Errors int     `json:"errors"`
+	P50Ms  float64 `json:"p50_ms"`
+	P95Ms  float64 `json:"p95_ms"`
+	P99Ms  float64 `json:"p99_ms"`
+	MaxMs  float64 `json:"max_ms"`
+	Error  string  `json:"last_error,omitempty"`
+}
+
+// runSelfTestProbe calls fn the given number of times and summarizes
+// the latencies of the successful calls
+func runSelfTestProbe(name string, iterations int, fn func() error) SelfTestProbe {
+	probe := SelfTestProbe{Name: name}
+	latencies := make([]time.Duration, 0, iterations)
+
+	for i := 0; i < iterations; i++ {
+		start := time.Now()
+		if err := fn(); err != nil {
+			probe.Errors++
+			probe.Error = err.Error()
+			continue
+		}
+		latencies = append(latencies, time.Since(start))
+	}
+	if len(latencies) == 0 {
+		return probe
+	}
+
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	ms := func(p int) float64 {
+		return float64(latencies[(len(latencies)-1)*p/100]) / float64(time.Millisecond)
+	}
+	probe.P50Ms = ms(50)
+	probe.P95Ms = ms(95)
+	probe.P99Ms = ms(99)
+	probe.MaxMs = ms(100)
+
+	selftestLatency.WithLabelValues(name).Set(probe.P99Ms / 1000)
+	return probe
+}
+
+// latencySelfTest runs every probe and reports their latencies
+func (a *App) latencySelfTest(c *gin.Context) {
+	iterations := 20
+	if n, err := strconv.Atoi(c.Query("iterations")); err == nil && n > 0 && n <= 1000 {
+		iterations = n
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 30*time.Second)
+	defer cancel()
+	start := time.Now()
+
+	var orders []Order
+	probes := []SelfTestProbe{
+		runSelfTestProbe("database", iterations, func() error {
+			var one int
+			return a.db.QueryRowContext(ctx, "SELECT 1").Scan(&one)
+		}),
+		runSelfTestProbe("redis", iterations, func() error {
+			return a.redisClient.Ping(ctx).Err()
+		}),
+		runSelfTestProbe("list", iterations, func() error {
+			rows, err := a.db.QueryContext(ctx, `
+				SELECT id, customer_id, customer_name, customer_email, status,
+				       total_amount, currency, created_at, updated_at
+				FROM orders
+				ORDER BY created_at DESC
+				LIMIT 20
+			`)
+			if err != nil {
+				return err
+			}
+			defer rows.Close()
+
+			orders = orders[:0]
+			for rows.Next() {
+				var o Order
+				if err := rows.Scan(&o.ID, &o.CustomerID, &o.CustomerName, &o.CustomerEmail,
+					&o.Status, &o.TotalAmount, &o.Currency, &o.CreatedAt, &o.UpdatedAt); err != nil {
+					return err
+				}
+				orders = append(orders, o)
+			}
+			return rows.Err()
+		}),
+	}
+	probes = append(probes, runSelfTestProbe("serialize", iterations, func() error {
+		return jsonenc.Write(io.Discard, gin.H{"orders": orders, "total": len(orders)})
+	}))
+
+	c.JSON(http.StatusOK, gin.H{
+		"iterations":  iterations,
+		"probes":      probes,
+		"duration_ms": time.Since(start).Milliseconds(),
+	})
+}
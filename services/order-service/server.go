@@ -0,0 +1,45 @@
+// =============================================================================
+// HTTP SERVER SETTINGS
+// =============================================================================
+// A bare http.Server has no header timeout, no idle timeout and accepts
+// 1 MB of headers, so a handful of slow or idle clients can hold
+// connections open forever. Both listeners are built from the same
+// configurable settings instead:
+//
+// - HTTP_READ_HEADER_TIMEOUT  Time allowed to send the request headers
+// - HTTP_IDLE_TIMEOUT         How long keep-alive connections may sit idle
+// - HTTP_MAX_HEADER_BYTES     Largest accepted request header
+// - HTTP2_ENABLED             Serve HTTP/2 over cleartext (h2c), for
+//                             proxies like Traefik that speak it upstream
+// - HTTP2_MAX_CONCURRENT_STREAMS  Streams per HTTP/2 connection
+//
+// Body read and response write timeouts are deliberately left unset:
+// imports and export downloads stream for longer than any sane fixed limit.
+// =============================================================================
+
+package main
+
+import (
+	"net/http"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+// newHTTPServer creates an HTTP server for addr with the configured limits
+func newHTTPServer(addr string, handler http.Handler, config *Config) *http.Server {
+	if config.HTTP2Enabled {
+		handler = h2c.NewHandler(handler, &http2.Server{
+			MaxConcurrentStreams: config.HTTP2MaxConcurrentStreams,
+			IdleTimeout:          config.HTTPIdleTimeout,
+		})
+	}
+
+	return &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: config.HTTPReadHeaderTimeout,
+		IdleTimeout:       config.HTTPIdleTimeout,
+		MaxHeaderBytes:    config.HTTPMaxHeaderBytes,
+	}
+}
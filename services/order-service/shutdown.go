@@ -0,0 +1,91 @@
+// =============================================================================
+// GRACEFUL SHUTDOWN
+// =============================================================================
+// Shutting down only the HTTP server leaves background work half done.
+// Instead, every subsystem registers a shutdown hook in a phase, and the
+// phases run in dependency order:
+//
+//   1. HTTP       - stop accepting requests, finish in-flight ones
+//   2. Jobs       - stop background jobs and tickers
+//   3. Consumers  - stop AMQP consumers after in-flight messages finish
+//   4. Publishers - flush outbox/publisher buffers
+//   5. Connections - close RabbitMQ, Redis and PostgreSQL
+//
+// Hooks in the same phase run in registration order. A failing hook is
+// logged but never stops the remaining hooks from running.
+// =============================================================================
+
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// shutdownPhase orders shutdown hooks
+type shutdownPhase int
+
+const (
+	phaseHTTP shutdownPhase = iota
+	phaseJobs
+	phaseConsumers
+	phasePublishers
+	phaseConnections
+	numShutdownPhases
+)
+
+var shutdownPhaseNames = [numShutdownPhases]string{"http", "jobs", "consumers", "publishers", "connections"}
+
+// shutdownHook is a named cleanup function
+type shutdownHook struct {
+	name string
+	fn   func(ctx context.Context) error
+}
+
+var (
+	shutdownMu    sync.Mutex
+	shutdownHooks [numShutdownPhases][]shutdownHook
+
+	// backgroundCtx is cancelled when the jobs phase starts.
+	// Long-running goroutines should stop when it is done.
+	backgroundCtx, stopBackground = context.WithCancel(context.Background())
+)
+
+// onShutdown registers a hook to run during the given shutdown phase
+func onShutdown(phase shutdownPhase, name string, fn func(ctx context.Context) error) {
+	shutdownMu.Lock()
+	defer shutdownMu.Unlock()
+	shutdownHooks[phase] = append(shutdownHooks[phase], shutdownHook{name: name, fn: fn})
+}
+
+// runShutdown runs all registered hooks phase by phase.
+// The context bounds the total time spent shutting down.
+func runShutdown(ctx context.Context) {
+	shutdownMu.Lock()
+	hooks := shutdownHooks
+	shutdownMu.Unlock()
+
+	for phase := shutdownPhase(0); phase < numShutdownPhases; phase++ {
+		if phase == phaseJobs {
+			stopBackground()
+		}
+
+		for _, hook := range hooks[phase] {
+			start := time.Now()
+			err := hook.fn(ctx)
+
+			fields := map[string]interface{}{
+				"phase":       shutdownPhaseNames[phase],
+				"hook":        hook.name,
+				"duration_ms": time.Since(start).Milliseconds(),
+			}
+			if err != nil {
+				fields["error"] = err.Error()
+				logErrorContext(ctx, "Shutdown step failed", fields)
+				continue
+			}
+			logInfoContext(ctx, "Shutdown step completed", fields)
+		}
+	}
+}
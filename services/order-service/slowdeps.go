@@ -0,0 +1,276 @@
+// =============================================================================
+// SLOW DEPENDENCY SIMULATION
+// =============================================================================
+// Adds artificial latency to calls to a dependency, so students can watch
+// histogram buckets, Apdex and trace waterfalls shift in real time while the
+// real dependency stays healthy.
+//
+// The delay is injected at the same client wrappers as simulated outages
+// (see outages.go), so every query, command, publish or downstream request
+// is slowed. Each delay is drawn from a configurable distribution:
+//
+// - fixed        latency_ms every time
+// - uniform      latency_ms plus up to jitter_ms
+// - normal       mean latency_ms, standard deviation jitter_ms
+// - exponential  mean latency_ms, with an occasional very slow call
+//
+// probability (default 1) is the share of calls that are delayed. Like
+// outages, a slowdown has a TTL (default 5m) and needs CHAOS_ENABLED.
+//
+// ENDPOINTS:
+// - GET    /admin/slow-dependencies              Active slowdowns
+// - PUT    /admin/slow-dependencies/:dependency  Set a slowdown
+// - DELETE /admin/slow-dependencies/:dependency  Remove a slowdown
+// - DELETE /admin/slow-dependencies              Remove every slowdown
+// =============================================================================
+
+package main
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// DependencyDelay is an artificial latency added to calls to a dependency
+type DependencyDelay struct {
+	Dependency   string    `json:"dependency"`
+	Distribution string    `json:"distribution"`
+	LatencyMs    int       `json:"latency_ms"`
+	JitterMs     int       `json:"jitter_ms,omitempty"`
+	Probability  float64   `json:"probability"`
+	ExpiresAt    time.Time `json:"expires_at"`
+}
+
+// sample draws one delay from the distribution
+func (d *DependencyDelay) sample() time.Duration {
+	latency := float64(d.LatencyMs)
+	jitter := float64(d.JitterMs)
+
+	var ms float64
+	switch d.Distribution {
+	case "uniform":
+		ms = latency + rand.Float64()*jitter
+	case "normal":
+		ms = latency + rand.NormFloat64()*jitter
+	case "exponential":
+		ms = rand.ExpFloat64() * latency
+	default:
+		ms = latency
+	}
+	return time.Duration(math.Max(ms, 0) * float64(time.Millisecond))
+}
+
+// delayDistributions are the supported delay distributions
+var delayDistributions = map[string]bool{"fixed": true, "uniform": true, "normal": true, "exponential": true}
+
+// slowDependencyState holds the active slowdowns by dependency
+type slowDependencyState struct {
+	mu     sync.RWMutex
+	delays map[string]*DependencyDelay
+}
+
+var (
+	// slowDependencies holds the process-wide slowdowns
+	slowDependencies = &slowDependencyState{delays: map[string]*DependencyDelay{}}
+
+	// Histogram: Artificial delay added to dependency calls
+	simulatedDependencyDelay = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "simulated_dependency_delay_seconds",
+			Help:    "Artificial delay added to calls to a dependency",
+			Buckets: prometheus.DefBuckets,
+		},
+		[]string{"dependency"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(simulatedDependencyDelay)
+}
+
+// set replaces the slowdown of a dependency
+func (s *slowDependencyState) set(d DependencyDelay) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.delays[d.Dependency] = &d
+}
+
+// remove deletes the slowdown of a dependency, reporting whether it existed
+func (s *slowDependencyState) remove(dependency string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, ok := s.delays[dependency]
+	delete(s.delays, dependency)
+	return ok
+}
+
+// clear deletes every slowdown and returns how many there were
+func (s *slowDependencyState) clear() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n := len(s.delays)
+	s.delays = map[string]*DependencyDelay{}
+	return n
+}
+
+// list returns the active slowdowns ordered by dependency
+func (s *slowDependencyState) list() []DependencyDelay {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	delays := make([]DependencyDelay, 0, len(s.delays))
+	for dep, d := range s.delays {
+		if !now.Before(d.ExpiresAt) {
+			delete(s.delays, dep)
+			continue
+		}
+		delays = append(delays, *d)
+	}
+	sort.Slice(delays, func(i, j int) bool { return delays[i].Dependency < delays[j].Dependency })
+	return delays
+}
+
+// wait sleeps for the dependency's artificial delay, if any. It returns
+// early with the context's error if ctx is done first.
+func (s *slowDependencyState) wait(ctx context.Context, dependency string) error {
+	s.mu.RLock()
+	d, ok := s.delays[dependency]
+	s.mu.RUnlock()
+
+	if !ok || !time.Now().Before(d.ExpiresAt) {
+		return nil
+	}
+	if d.Probability < 1 && rand.Float64() >= d.Probability {
+		return nil
+	}
+
+	delay := d.sample()
+	simulatedDependencyDelay.WithLabelValues(dependency).Observe(delay.Seconds())
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// dependencyFault applies the simulated slowdown and outage of a
+// dependency, returning an error if the call must fail
+func dependencyFault(ctx context.Context, dependency string) error {
+	if err := slowDependencies.wait(ctx, dependency); err != nil {
+		return err
+	}
+	return outages.check(dependency)
+}
+
+// =============================================================================
+// SLOW DEPENDENCY ADMIN HANDLERS
+// =============================================================================
+
+// listSlowDependencies returns the active slowdowns
+func listSlowDependencies(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"enabled":      chaosEnabled,
+		"dependencies": simulatedDependencies,
+		"delays":       slowDependencies.list(),
+	})
+}
+
+// setSlowDependency slows down calls to one dependency
+func setSlowDependency(c *gin.Context) {
+	if !chaosEnabled {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Chaos features are disabled (CHAOS_ENABLED=false)"})
+		return
+	}
+
+	dep := c.Param("dependency")
+	if !isSimulatedDependency(dep) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Unknown dependency", "dependencies": simulatedDependencies})
+		return
+	}
+
+	var req struct {
+		Distribution string   `json:"distribution"`
+		LatencyMs    int      `json:"latency_ms" binding:"required,min=1,max=60000"`
+		JitterMs     int      `json:"jitter_ms" binding:"min=0,max=60000"`
+		Probability  *float64 `json:"probability" binding:"omitempty,min=0,max=1"`
+		TTLSeconds   int      `json:"ttl_seconds" binding:"min=0"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Distribution == "" {
+		req.Distribution = "fixed"
+	}
+	if !delayDistributions[req.Distribution] {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "distribution must be fixed, uniform, normal or exponential"})
+		return
+	}
+	probability := 1.0
+	if req.Probability != nil {
+		probability = *req.Probability
+	}
+
+	ttl := 5 * time.Minute
+	if req.TTLSeconds > 0 {
+		ttl = time.Duration(req.TTLSeconds) * time.Second
+	}
+	if ttl > maxChaosTTL {
+		ttl = maxChaosTTL
+	}
+
+	delay := DependencyDelay{
+		Dependency:   dep,
+		Distribution: req.Distribution,
+		LatencyMs:    req.LatencyMs,
+		JitterMs:     req.JitterMs,
+		Probability:  probability,
+		ExpiresAt:    time.Now().UTC().Add(ttl),
+	}
+	slowDependencies.set(delay)
+
+	logWarnContext(c.Request.Context(), "Dependency slowdown set", map[string]interface{}{
+		"dependency":   dep,
+		"distribution": delay.Distribution,
+		"latency_ms":   delay.LatencyMs,
+		"jitter_ms":    delay.JitterMs,
+		"probability":  delay.Probability,
+		"expires_at":   delay.ExpiresAt,
+	})
+	annotate("slowdown", "Simulated %s slowdown: %s %dms for %s", dep, delay.Distribution, delay.LatencyMs, ttl)
+	c.JSON(http.StatusOK, delay)
+}
+
+// deleteSlowDependency removes the slowdown of one dependency
+func deleteSlowDependency(c *gin.Context) {
+	dep := c.Param("dependency")
+	if !slowDependencies.remove(dep) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Dependency is not slowed down"})
+		return
+	}
+
+	logInfoContext(c.Request.Context(), "Dependency slowdown removed", map[string]interface{}{"dependency": dep})
+	c.JSON(http.StatusOK, gin.H{"message": "Dependency slowdown removed", "dependency": dep})
+}
+
+// clearSlowDependencies removes every slowdown
+func clearSlowDependencies(c *gin.Context) {
+	removed := slowDependencies.clear()
+	logInfoContext(c.Request.Context(), "Dependency slowdowns cleared", map[string]interface{}{"removed": removed})
+	c.JSON(http.StatusOK, gin.H{"message": "Dependency slowdowns cleared", "removed": removed})
+}
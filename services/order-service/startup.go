@@ -0,0 +1,77 @@
+// =============================================================================
+// STARTUP DEPENDENCY RETRY
+// =============================================================================
+// In docker-compose the service can start before PostgreSQL, Redis or
+// RabbitMQ accept connections. Crashing immediately causes a crash-loop,
+// so instead each dependency is retried with exponential backoff for a
+// configurable window.
+//
+// While dependencies are still being connected:
+// - /health answers 200 with status "starting" (the process is alive)
+// - /ready answers 503 (don't route traffic here yet)
+// - API and admin requests get 503 + Retry-After
+// =============================================================================
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// startupComplete is set once every dependency is connected
+var startupComplete atomic.Bool
+
+// retryWithBackoff calls fn until it succeeds, the retry window elapses,
+// or ctx is cancelled. The delay between attempts doubles up to maxBackoff.
+func retryWithBackoff(ctx context.Context, name string, window, maxBackoff time.Duration, fn func() error) error {
+	deadline := time.Now().Add(window)
+	backoff := 500 * time.Millisecond
+
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil {
+			return nil
+		}
+
+		if time.Now().Add(backoff).After(deadline) {
+			return fmt.Errorf("%s not available after %d attempts: %w", name, attempt, err)
+		}
+
+		logWarnContext(ctx, "Dependency not available, retrying", map[string]interface{}{
+			"dependency": name,
+			"attempt":    attempt,
+			"retry_in":   backoff.String(),
+			"error":      err.Error(),
+		})
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%s: startup cancelled: %w", name, ctx.Err())
+		case <-time.After(backoff):
+		}
+
+		backoff *= 2
+		if backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+// startupGateMiddleware rejects requests until startup has completed,
+// because handlers can't work without their dependencies.
+func startupGateMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !startupComplete.Load() {
+			respondDegraded(c, degradedStarting, 5*time.Second, gin.H{
+				"error": tr(c, "Service is starting"),
+			})
+			return
+		}
+		c.Next()
+	}
+}
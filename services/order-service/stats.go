@@ -0,0 +1,244 @@
+// =============================================================================
+// ORDER STATISTICS
+// =============================================================================
+// GET /api/v1/orders/stats must stay fast as the orders table grows, so it
+// never scans orders directly. Instead it reads order_stats_hourly, a
+// summary table with one row per (hour, status) holding the order count
+// and revenue. Canary orders (see heartbeat.go) are left out, so neither
+// the stats nor the daily summaries built from them count synthetic
+// traffic.
+//
+// INCREMENTAL REFRESH:
+// Every STATS_REFRESH_INTERVAL the stats-rollup job (see jobs.go) finds
+// the hours touched by orders created or updated since the last refresh
+// (using updated_at) and recomputes only those buckets. The watermark lives in the database, so
+// every instance can refresh without redoing the others' work.
+//
+// Daily figures are sums of the hourly rows, grouped into days of the
+// caller's timezone (see timezone.go).
+// =============================================================================
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// statsRollupName is the watermark key of the hourly rollup
+const statsRollupName = "order_stats_hourly"
+
+// refreshStatsRollups recomputes the hourly buckets touched since the last
+// refresh and advances the watermark
+func (a *App) refreshStatsRollups(ctx context.Context) error {
+	start := time.Now()
+
+	tx, err := a.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Lock the watermark row so concurrent refreshes don't interleave
+	var watermark time.Time
+	err = tx.QueryRowContext(ctx, `
+		SELECT refreshed_until FROM rollup_watermarks WHERE name = $1 FOR UPDATE
+	`, statsRollupName).Scan(&watermark)
+	if err == sql.ErrNoRows {
+		watermark = time.Unix(0, 0).UTC()
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO rollup_watermarks (name, refreshed_until) VALUES ($1, $2)
+			ON CONFLICT (name) DO NOTHING
+		`, statsRollupName, watermark); err != nil {
+			return err
+		}
+	} else if err != nil {
+		return err
+	}
+
+	// Take the new watermark before reading, so rows updated during the
+	// refresh are picked up by the next run
+	var now time.Time
+	if err := tx.QueryRowContext(ctx, `SELECT NOW()`).Scan(&now); err != nil {
+		return err
+	}
+
+	// Recompute every bucket touched since the watermark
+	const touchedBuckets = `
+		SELECT DISTINCT date_trunc('hour', created_at)
+		FROM orders
+		WHERE updated_at >= $1
+	`
+	if _, err := tx.ExecContext(ctx, `
+		DELETE FROM order_stats_hourly WHERE bucket IN (`+touchedBuckets+`)
+	`, watermark); err != nil {
+		return err
+	}
+
+	result, err := tx.ExecContext(ctx, `
+		INSERT INTO order_stats_hourly (bucket, status, order_count, revenue)
+		SELECT date_trunc('hour', created_at), status, COUNT(*), SUM(total_amount)
+		FROM orders
+		WHERE date_trunc('hour', created_at) IN (`+touchedBuckets+`)
+		  AND customer_id <> $2
+		GROUP BY 1, 2
+		ON CONFLICT (bucket, status) DO UPDATE
+		SET order_count = EXCLUDED.order_count, revenue = EXCLUDED.revenue
+	`, watermark, canaryCustomerID)
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE rollup_watermarks SET refreshed_until = $1 WHERE name = $2
+	`, now, statsRollupName); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	rows, _ := result.RowsAffected()
+	logDebugContext(ctx, "Order stats rollups refreshed", map[string]interface{}{
+		"rows":        rows,
+		"since":       watermark,
+		"duration_ms": time.Since(start).Milliseconds(),
+	})
+
+	a.updateOrdersByStatusGauge(ctx)
+	return nil
+}
+
+// updateOrdersByStatusGauge sets orders_by_status from the rollups
+func (a *App) updateOrdersByStatusGauge(ctx context.Context) {
+	rows, err := a.db.QueryContext(ctx, `
+		SELECT status, SUM(order_count) FROM order_stats_hourly GROUP BY status
+	`)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var status string
+		var count float64
+		if rows.Scan(&status, &count) == nil {
+			ordersByStatus.WithLabelValues(status).Set(count)
+		}
+	}
+}
+
+// DailyStats is one day of aggregated order figures
+type DailyStats struct {
+	Date       string    `json:"date"`
+	Start      time.Time `json:"start"`
+	End        time.Time `json:"end"`
+	OrderCount int64     `json:"order_count"`
+	Revenue    float64   `json:"revenue"`
+	Cancelled  int64     `json:"cancelled"`
+}
+
+// getOrderStats returns order totals and a daily series from the rollups
+func (a *App) getOrderStats(c *gin.Context) {
+	days := 30
+	if d, err := strconv.Atoi(c.Query("days")); err == nil && d > 0 && d <= 366 {
+		days = d
+	}
+
+	loc, err := a.resolveTimezone(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	windowStart := startOfDay(time.Now(), loc).AddDate(0, 0, -(days - 1))
+
+	ctx := c.Request.Context()
+
+	// Totals by status (revenue excludes cancelled orders)
+	byStatus := map[string]int64{}
+	var totalOrders int64
+	var totalRevenue float64
+	rows, err := a.db.QueryContext(ctx, `
+		SELECT status, SUM(order_count), SUM(revenue)
+		FROM order_stats_hourly
+		GROUP BY status
+	`)
+	if err != nil {
+		logErrorContext(c.Request.Context(), "Failed to query order stats", map[string]interface{}{
+			"error": err.Error(),
+		})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": tr(c, "Database error")})
+		return
+	}
+	for rows.Next() {
+		var status string
+		var count int64
+		var revenue float64
+		if err := rows.Scan(&status, &count, &revenue); err != nil {
+			continue
+		}
+		byStatus[status] = count
+		totalOrders += count
+		if status != "cancelled" {
+			totalRevenue += revenue
+		}
+	}
+	rows.Close()
+
+	// Daily series for the requested window
+	daily := []DailyStats{}
+	rows, err = a.db.QueryContext(ctx, `
+		SELECT to_char(bucket AT TIME ZONE $2, 'YYYY-MM-DD'),
+		       SUM(order_count),
+		       COALESCE(SUM(revenue) FILTER (WHERE status <> 'cancelled'), 0),
+		       COALESCE(SUM(order_count) FILTER (WHERE status = 'cancelled'), 0)
+		FROM order_stats_hourly
+		WHERE bucket >= $1
+		GROUP BY 1
+		ORDER BY 1
+	`, windowStart, loc.String())
+	if err != nil {
+		logErrorContext(c.Request.Context(), "Failed to query daily order stats", map[string]interface{}{
+			"error": err.Error(),
+		})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": tr(c, "Database error")})
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var d DailyStats
+		if err := rows.Scan(&d.Date, &d.OrderCount, &d.Revenue, &d.Cancelled); err != nil {
+			continue
+		}
+		if d.Start, err = parseDay(d.Date, loc); err == nil {
+			d.End = d.Start.AddDate(0, 0, 1)
+		}
+		daily = append(daily, d)
+	}
+
+	var refreshedUntil time.Time
+	a.db.QueryRowContext(ctx, `
+		SELECT refreshed_until FROM rollup_watermarks WHERE name = $1
+	`, statsRollupName).Scan(&refreshedUntil)
+
+	averageOrderValue := 0.0
+	if nonCancelled := totalOrders - byStatus["cancelled"]; nonCancelled > 0 {
+		averageOrderValue = totalRevenue / float64(nonCancelled)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"total_orders":        totalOrders,
+		"total_revenue":       totalRevenue,
+		"average_order_value": averageOrderValue,
+		"by_status":           byStatus,
+		"timezone":            loc.String(),
+		"daily":               daily,
+		"refreshed_until":     refreshedUntil,
+	})
+}
@@ -0,0 +1,308 @@
+// =============================================================================
+// STORAGE BACKENDS
+// =============================================================================
+// The order tables can live in different databases, so the lab can show
+// what each one looks like on the dashboards. STORAGE_BACKEND selects one;
+// DATABASE_URL points at it:
+//
+//   Backend      Driver                  Notes
+//   postgres     lib/pq                  Default, supports everything
+//   cockroachdb  lib/pq (pgwire)         Same SQL and schema as postgres
+//   mysql        go-sql-driver/mysql     Only with -tags mysql (see
+//                                        storage_mysql.go), order API and
+//                                        outbox only
+//
+//   go build -tags mysql .
+//
+// A backend owns what is dialect-specific: opening connections, the schema
+// migrations, inserting an order (generating its ID and order number),
+// upserts, bulk inserts and recognising errors after which a transaction
+// is retried, like CockroachDB's serialization failures. Everything else
+// is written in SQL the backends share. Subsystems whose SQL only PostgreSQL understands (the
+// event store, scheduled jobs and reports, exports, privacy requests) are
+// features a backend reports: PERSISTENCE_MODE=eventsourced on a backend
+// without the event store fails at startup, and workers leave out the
+// jobs, exports and privacy requests the backend can't run.
+//
+// Spans of statements carry the backend's db.system, and the
+// storage_backend_info metric lets dashboards pick their panels.
+//
+// METRICS:
+// - storage_backend_info{backend,system}    Always 1
+// - storage_tx_retries_total{backend}       Order write transactions retried
+// =============================================================================
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/lib/pq"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// storageTxAttempts bounds the attempts of a retried transaction
+const storageTxAttempts = 3
+
+// storageFeature is a subsystem that needs PostgreSQL-specific SQL
+type storageFeature string
+
+const (
+	featureEventSourcing storageFeature = "event sourcing (PERSISTENCE_MODE=eventsourced)"
+	featureJobs          storageFeature = "scheduled jobs, exports and privacy requests"
+)
+
+// storageBackend is a database the order tables are stored in
+type storageBackend interface {
+	// Name is the STORAGE_BACKEND value
+	Name() string
+	// System is the OpenTelemetry db.system of the backend
+	System() string
+	// Connector opens connections to the database at dsn
+	Connector(dsn string) (driver.Connector, error)
+	// RedactDSN hides the password of a dsn (see config.go)
+	RedactDSN(dsn string) string
+	// Migrate creates or upgrades the schema
+	Migrate(a *App) error
+	// InsertOrder inserts an order row in tx and returns its ID and number
+	InsertOrder(ctx context.Context, tx *sql.Tx, row orderRow) (id, number string, err error)
+	// NextOrderNumber allocates an order number in tx
+	NextOrderNumber(ctx context.Context, tx *sql.Tx) (string, error)
+	// Upsert returns an INSERT of columns ($1, $2, ... in order) into
+	// table that replaces the other columns of the row with the same key
+	Upsert(table string, key []string, columns ...string) string
+	// CopyRows bulk-inserts rows of columns into table in tx
+	CopyRows(ctx context.Context, tx *sql.Tx, table string, columns []string, rows [][]interface{}) error
+	// Retryable reports whether a transaction that failed with err may be
+	// run again
+	Retryable(err error) bool
+	// Supports reports whether the backend can run a feature
+	Supports(feature storageFeature) bool
+}
+
+// orderRow is the order insertOrder writes, without its items
+type orderRow struct {
+	CustomerID      string
+	CustomerName    string
+	CustomerEmail   string
+	CustomerTier    string
+	ShippingAddress string
+	AddressID       string
+	Notes           string
+	TotalAmount     float64
+	Currency        string
+	Status          string
+	ReleaseDate     string
+	GiftWrap        bool
+	GiftMessage     string
+	GiftHidePrices  bool
+	GiftWrapFee     float64
+}
+
+// storageBackends are the backends compiled in, by name
+var storageBackends = map[string]storageBackend{
+	"postgres":    postgresBackend{},
+	"cockroachdb": cockroachBackend{},
+}
+
+// storage is the backend of this process
+var storage storageBackend = postgresBackend{}
+
+var (
+	// Gauge: Backend of this process
+	storageBackendInfo = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "storage_backend_info",
+			Help: "Database backend the orders are stored in, always 1",
+		},
+		[]string{"backend", "system"},
+	)
+
+	// Counter: Retried transactions
+	storageTxRetriesTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "storage_tx_retries_total",
+			Help: "Order write transactions retried after a retryable database error",
+		},
+		[]string{"backend"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(storageBackendInfo)
+	prometheus.MustRegister(storageTxRetriesTotal)
+}
+
+// setStorageBackend validates and applies STORAGE_BACKEND. It runs after
+// the persistence mode is set, to check that the backend supports it.
+func setStorageBackend(name string) error {
+	backend, ok := storageBackends[name]
+	if !ok {
+		if name == "mysql" {
+			return errors.New("STORAGE_BACKEND mysql is not compiled in (build with -tags mysql)")
+		}
+		names := make([]string, 0, len(storageBackends))
+		for n := range storageBackends {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return fmt.Errorf("unknown STORAGE_BACKEND %q (expected %s)", name, strings.Join(names, ", "))
+	}
+	if eventSourced() && !backend.Supports(featureEventSourcing) {
+		return fmt.Errorf("STORAGE_BACKEND %s does not support %s", name, featureEventSourcing)
+	}
+	storage = backend
+	storageBackendInfo.WithLabelValues(backend.Name(), backend.System()).Set(1)
+	return nil
+}
+
+// retryTx runs fn, a whole transaction, again while it fails with an error
+// the backend can retry
+func retryTx(ctx context.Context, fn func() error) error {
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || attempt == storageTxAttempts || ctx.Err() != nil || !storage.Retryable(err) {
+			return err
+		}
+		storageTxRetriesTotal.WithLabelValues(storage.Name()).Inc()
+		logDebugContext(ctx, "Retrying transaction", map[string]interface{}{
+			"attempt": attempt,
+			"error":   err.Error(),
+		})
+	}
+}
+
+// placeholders returns $1, ..., $n
+func placeholders(n int) string {
+	list := make([]string, n)
+	for i := range list {
+		list[i] = "$" + strconv.Itoa(i+1)
+	}
+	return strings.Join(list, ", ")
+}
+
+// containsString reports whether list contains s
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// =============================================================================
+// POSTGRESQL
+// =============================================================================
+
+// postgresBackend is the default backend
+type postgresBackend struct{}
+
+func (postgresBackend) Name() string   { return "postgres" }
+func (postgresBackend) System() string { return "postgresql" }
+
+// Connector lets the admin API simulate an outage (see outages.go)
+func (postgresBackend) Connector(dsn string) (driver.Connector, error) {
+	return newOutageConnector(dsn)
+}
+
+func (postgresBackend) RedactDSN(dsn string) string {
+	return redactURL(dsn)
+}
+
+func (postgresBackend) Migrate(a *App) error {
+	return a.runMigrations()
+}
+
+// InsertOrder lets the column defaults generate the ID and, with
+// next_order_number, the order number (see ordernumbers.go)
+func (postgresBackend) InsertOrder(ctx context.Context, tx *sql.Tx, row orderRow) (string, string, error) {
+	var id, number string
+	err := tx.QueryRowContext(ctx, `
+		INSERT INTO orders (customer_id, customer_name, customer_email,
+		                    shipping_address, notes, total_amount, status, customer_tier,
+		                    release_date, gift_wrap, gift_message, gift_hide_prices, gift_wrap_fee,
+		                    shipping_address_id, currency)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, NULLIF($9, '')::date, $10, NULLIF($11, ''), $12, $13,
+		        NULLIF($14, ''), $15)
+		RETURNING id, order_number
+	`, row.CustomerID, row.CustomerName, row.CustomerEmail,
+		row.ShippingAddress, row.Notes, row.TotalAmount, row.Status, row.CustomerTier,
+		row.ReleaseDate, row.GiftWrap, row.GiftMessage, row.GiftHidePrices, row.GiftWrapFee,
+		row.AddressID, row.Currency).Scan(&id, &number)
+	return id, number, err
+}
+
+// NextOrderNumber uses next_order_number (see ordernumbers.go)
+func (postgresBackend) NextOrderNumber(ctx context.Context, tx *sql.Tx) (string, error) {
+	var number string
+	err := tx.QueryRowContext(ctx, `SELECT next_order_number($1, $2)`, orderNumberPrefix, orderNumberDigits).Scan(&number)
+	return number, err
+}
+
+func (postgresBackend) Upsert(table string, key []string, columns ...string) string {
+	var updates []string
+	for _, column := range columns {
+		if !containsString(key, column) {
+			updates = append(updates, column+" = EXCLUDED."+column)
+		}
+	}
+	return fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s) ON CONFLICT (%s) DO UPDATE SET %s",
+		table, strings.Join(columns, ", "), placeholders(len(columns)),
+		strings.Join(key, ", "), strings.Join(updates, ", "))
+}
+
+// CopyRows uses COPY FROM STDIN
+func (postgresBackend) CopyRows(ctx context.Context, tx *sql.Tx, table string, columns []string, rows [][]interface{}) error {
+	stmt, err := tx.PrepareContext(ctx, pq.CopyIn(table, columns...))
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, row := range rows {
+		if _, err := stmt.ExecContext(ctx, row...); err != nil {
+			return err
+		}
+	}
+	// An Exec without arguments flushes the buffered rows
+	_, err = stmt.ExecContext(ctx)
+	return err
+}
+
+// Retryable covers serialization failures and deadlocks, both rare at
+// PostgreSQL's default isolation level
+func (postgresBackend) Retryable(err error) bool {
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) {
+		return false
+	}
+	return pqErr.Code == "40001" || pqErr.Code == "40P01"
+}
+
+func (postgresBackend) Supports(feature storageFeature) bool {
+	return true
+}
+
+// =============================================================================
+// COCKROACHDB
+// =============================================================================
+
+// cockroachBackend talks to CockroachDB over its PostgreSQL wire protocol.
+// The schema and queries are PostgreSQL's; the migrations need a release
+// with PL/pgSQL and trigger support. CockroachDB runs transactions
+// SERIALIZABLE, so contended writes fail with 40001 (restart transaction)
+// far more often than on PostgreSQL and are retried.
+type cockroachBackend struct {
+	postgresBackend
+}
+
+func (cockroachBackend) Name() string   { return "cockroachdb" }
+func (cockroachBackend) System() string { return "cockroachdb" }
@@ -0,0 +1,541 @@
+//go:build mysql
+
+// =============================================================================
+// MYSQL STORAGE BACKEND
+// =============================================================================
+// STORAGE_BACKEND=mysql stores the orders in MySQL 8.0.29 or later. It is
+// only compiled in with the mysql build tag, so the default binary doesn't
+// carry a second driver:
+//
+//   go build -tags mysql .
+//   STORAGE_BACKEND=mysql DATABASE_URL='webapp:webapp@tcp(mysql:3306)/orderdb'
+//
+// The shared SQL of the service uses $1, $2 placeholders and the odd
+// ::type cast, which the connections below adapt on the way to the
+// driver: placeholders become ?, with the arguments reordered and repeated
+// to match, and casts are dropped (MySQL compares UUIDs, stored as
+// CHAR(36), and dates as strings). Nothing else is rewritten.
+//
+// SQL that differs between dialects is the backend's own, through the
+// storageBackend methods: the schema (below), inserting an order and
+// allocating its number (from an AUTO_INCREMENT counter instead of a
+// sequence), upserts (ON DUPLICATE KEY UPDATE) and bulk inserts
+// (multi-row INSERTs instead of COPY). Times are stored as UTC
+// DATETIME(6).
+//
+// The schema covers the tables of the order API, the outbox and
+// differential sync: orders, items, the serial and lot tracking used by
+// list filters, review flags, customer timezones, processed commands and
+// the tombstones of deleted orders. The order API's queries need to stick
+// to SQL both dialects run (the fulfillment board's LATERAL join, for
+// one, see board.go); a query that can't must move behind a backend
+// method. The event store, scheduled jobs, exports and privacy requests
+// stay PostgreSQL-only (see storage.go).
+// =============================================================================
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/google/uuid"
+)
+
+// storageLog is the logger of the MySQL backend
+var storageLog = newLogger("storage")
+
+func init() {
+	storageBackends["mysql"] = mysqlBackend{}
+}
+
+// mysqlBackend stores the orders in MySQL
+type mysqlBackend struct{}
+
+func (mysqlBackend) Name() string   { return "mysql" }
+func (mysqlBackend) System() string { return "mysql" }
+
+// Connector parses times as UTC and lets the admin API simulate an
+// outage (see outages.go)
+func (mysqlBackend) Connector(dsn string) (driver.Connector, error) {
+	cfg, err := mysql.ParseDSN(dsn)
+	if err != nil {
+		return nil, err
+	}
+	cfg.ParseTime = true
+	cfg.Loc = time.UTC
+	if cfg.Params == nil {
+		cfg.Params = map[string]string{}
+	}
+	cfg.Params["time_zone"] = "'+00:00'"
+	connector, err := mysql.NewConnector(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return outageConnector{mysqlConnector{connector}}, nil
+}
+
+// mysqlMigrations create the schema of the tables the backend supports
+var mysqlMigrations = []struct {
+	name  string
+	query string
+}{
+	{"orders table", `
+		CREATE TABLE IF NOT EXISTS orders (
+			id CHAR(36) PRIMARY KEY,
+			order_number VARCHAR(32) NOT NULL,
+			customer_id CHAR(36) NOT NULL,
+			customer_name VARCHAR(255) NOT NULL,
+			customer_email VARCHAR(255) NOT NULL,
+			customer_tier VARCHAR(20) NOT NULL DEFAULT 'standard',
+			status VARCHAR(50) NOT NULL DEFAULT 'pending',
+			total_amount DECIMAL(13, 3) NOT NULL DEFAULT 0,
+			currency VARCHAR(3) NOT NULL DEFAULT 'USD',
+			shipping_address TEXT,
+			shipping_address_id VARCHAR(100),
+			notes TEXT,
+			release_date DATE,
+			gift_wrap BOOLEAN NOT NULL DEFAULT false,
+			gift_message TEXT,
+			gift_hide_prices BOOLEAN NOT NULL DEFAULT false,
+			gift_wrap_fee DECIMAL(13, 3) NOT NULL DEFAULT 0,
+			version INTEGER NOT NULL DEFAULT 1,
+			created_at DATETIME(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+			updated_at DATETIME(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+			UNIQUE INDEX idx_orders_order_number (order_number),
+			INDEX idx_orders_customer_id (customer_id),
+			INDEX idx_orders_status (status),
+			INDEX idx_orders_status_created (status, created_at),
+			INDEX idx_orders_updated_at (updated_at),
+			INDEX idx_orders_created_at (created_at)
+		)
+	`},
+	// Version of orders for ETags, bumped on every update (see etag.go)
+	{"order version trigger", `
+		CREATE TRIGGER IF NOT EXISTS orders_bump_version
+		BEFORE UPDATE ON orders
+		FOR EACH ROW SET NEW.version = OLD.version + 1
+	`},
+	{"order_items table", `
+		CREATE TABLE IF NOT EXISTS order_items (
+			id CHAR(36) PRIMARY KEY DEFAULT (UUID()),
+			order_id CHAR(36) NOT NULL,
+			sku VARCHAR(50) NOT NULL,
+			name VARCHAR(255) NOT NULL,
+			quantity INTEGER NOT NULL,
+			unit_price DECIMAL(13, 3) NOT NULL,
+			total_price DECIMAL(13, 3) NOT NULL,
+			created_at DATETIME(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+			FOREIGN KEY (order_id) REFERENCES orders(id) ON DELETE CASCADE
+		)
+	`},
+	// Counter of the order numbers (see InsertOrder)
+	{"order_number_seq table", `
+		CREATE TABLE IF NOT EXISTS order_number_seq (
+			n BIGINT AUTO_INCREMENT PRIMARY KEY
+		)
+	`},
+	{"outbox_events table", `
+		CREATE TABLE IF NOT EXISTS outbox_events (
+			id BIGINT AUTO_INCREMENT PRIMARY KEY,
+			routing_key VARCHAR(100) NOT NULL,
+			order_id CHAR(36),
+			payload MEDIUMTEXT NOT NULL,
+			status VARCHAR(20) NOT NULL DEFAULT 'pending',
+			attempts INTEGER NOT NULL DEFAULT 0,
+			last_error TEXT,
+			created_at DATETIME(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+			published_at DATETIME(6),
+			claimed_until DATETIME(6),
+			next_attempt_at DATETIME(6),
+			order_snapshot MEDIUMTEXT,
+			INDEX idx_outbox_events_status (status, id),
+			INDEX idx_outbox_events_published (published_at)
+		)
+	`},
+	{"order_item_tracking table", `
+		CREATE TABLE IF NOT EXISTS order_item_tracking (
+			id BIGINT AUTO_INCREMENT PRIMARY KEY,
+			order_id CHAR(36) NOT NULL,
+			item_id CHAR(36) NOT NULL,
+			kind VARCHAR(10) NOT NULL,
+			code VARCHAR(100) NOT NULL,
+			quantity INTEGER NOT NULL,
+			created_at DATETIME(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+			UNIQUE INDEX idx_order_item_tracking_item (item_id, kind, code),
+			INDEX idx_order_item_tracking_code (kind, code),
+			FOREIGN KEY (order_id) REFERENCES orders(id) ON DELETE CASCADE
+		)
+	`},
+	{"order_reviews table", `
+		CREATE TABLE IF NOT EXISTS order_reviews (
+			id BIGINT AUTO_INCREMENT PRIMARY KEY,
+			order_id CHAR(36) NOT NULL,
+			reason VARCHAR(40) NOT NULL,
+			duplicate_of CHAR(36),
+			created_at DATETIME(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+			resolved_at DATETIME(6),
+			resolution_note TEXT,
+			INDEX idx_order_reviews_created (created_at)
+		)
+	`},
+	// Idempotency keys of executed order commands (see commands.go)
+	{"processed_commands table", `
+		CREATE TABLE IF NOT EXISTS processed_commands (
+			message_id VARCHAR(255) PRIMARY KEY,
+			command VARCHAR(20) NOT NULL,
+			reply TEXT,
+			processed_at DATETIME(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+			INDEX idx_processed_commands_processed_at (processed_at)
+		)
+	`},
+	// Tombstones of deleted orders, for differential sync (see sync.go)
+	{"order_tombstones table", `
+		CREATE TABLE IF NOT EXISTS order_tombstones (
+			order_id CHAR(36) PRIMARY KEY,
+			version INTEGER NOT NULL,
+			deleted_at DATETIME(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+			INDEX idx_order_tombstones_deleted_at (deleted_at)
+		)
+	`},
+	{"order tombstone trigger", `
+		CREATE TRIGGER IF NOT EXISTS orders_record_tombstone
+		AFTER DELETE ON orders
+		FOR EACH ROW REPLACE INTO order_tombstones (order_id, version) VALUES (OLD.id, OLD.version)
+	`},
+	{"customer_timezones table", `
+		CREATE TABLE IF NOT EXISTS customer_timezones (
+			customer_id CHAR(36) PRIMARY KEY,
+			timezone VARCHAR(64) NOT NULL,
+			updated_at DATETIME(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6)
+		)
+	`},
+}
+
+// RedactDSN hides the password of a go-sql-driver DSN, which isn't a URL;
+// a DSN that doesn't parse is hidden entirely
+func (mysqlBackend) RedactDSN(dsn string) string {
+	cfg, err := mysql.ParseDSN(dsn)
+	if err != nil {
+		return "[REDACTED]"
+	}
+	if cfg.Passwd != "" {
+		cfg.Passwd = "REDACTED"
+	}
+	return cfg.FormatDSN()
+}
+
+func (mysqlBackend) Migrate(a *App) error {
+	for _, m := range mysqlMigrations {
+		if _, err := a.db.Exec(m.query); err != nil {
+			return fmt.Errorf("failed to create %s: %w", m.name, err)
+		}
+	}
+	storageLog.Info("Database migrations completed", "backend", "mysql", "migrations", len(mysqlMigrations))
+	return nil
+}
+
+// InsertOrder generates the order's ID and number
+func (b mysqlBackend) InsertOrder(ctx context.Context, tx *sql.Tx, row orderRow) (string, string, error) {
+	number, err := b.NextOrderNumber(ctx, tx)
+	if err != nil {
+		return "", "", err
+	}
+
+	id := uuid.NewString()
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO orders (id, order_number, customer_id, customer_name, customer_email,
+		                    shipping_address, notes, total_amount, status, customer_tier,
+		                    release_date, gift_wrap, gift_message, gift_hide_prices, gift_wrap_fee,
+		                    shipping_address_id, currency)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, NULLIF($11, ''), $12, NULLIF($13, ''), $14, $15,
+		        NULLIF($16, ''), $17)
+	`, id, number, row.CustomerID, row.CustomerName, row.CustomerEmail,
+		row.ShippingAddress, row.Notes, row.TotalAmount, row.Status, row.CustomerTier,
+		row.ReleaseDate, row.GiftWrap, row.GiftMessage, row.GiftHidePrices, row.GiftWrapFee,
+		row.AddressID, row.Currency)
+	if err != nil {
+		return "", "", err
+	}
+	return id, number, nil
+}
+
+// NextOrderNumber takes the next value of the order_number_seq counter
+func (mysqlBackend) NextOrderNumber(ctx context.Context, tx *sql.Tx) (string, error) {
+	result, err := tx.ExecContext(ctx, `INSERT INTO order_number_seq () VALUES ()`)
+	if err != nil {
+		return "", err
+	}
+	n, err := result.LastInsertId()
+	if err != nil {
+		return "", err
+	}
+	return formatOrderNumber(orderNumberPrefix, n), nil
+}
+
+// Upsert uses ON DUPLICATE KEY UPDATE with a row alias. MySQL matches any
+// unique key, which for the tables upserted into is the primary key.
+func (mysqlBackend) Upsert(table string, key []string, columns ...string) string {
+	var updates []string
+	for _, column := range columns {
+		if !containsString(key, column) {
+			updates = append(updates, column+" = new."+column)
+		}
+	}
+	return fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s) AS new ON DUPLICATE KEY UPDATE %s",
+		table, strings.Join(columns, ", "), placeholders(len(columns)), strings.Join(updates, ", "))
+}
+
+// mysqlCopyBatch is the number of rows per INSERT of CopyRows, well below
+// the 65535 placeholders of a prepared statement
+const mysqlCopyBatch = 500
+
+// CopyRows inserts the rows with multi-row INSERTs
+func (mysqlBackend) CopyRows(ctx context.Context, tx *sql.Tx, table string, columns []string, rows [][]interface{}) error {
+	row := "(" + strings.TrimSuffix(strings.Repeat("?, ", len(columns)), ", ") + ")"
+	for start := 0; start < len(rows); start += mysqlCopyBatch {
+		end := start + mysqlCopyBatch
+		if end > len(rows) {
+			end = len(rows)
+		}
+		values := make([]string, 0, end-start)
+		args := make([]interface{}, 0, (end-start)*len(columns))
+		for _, r := range rows[start:end] {
+			values = append(values, row)
+			args = append(args, r...)
+		}
+		query := fmt.Sprintf("INSERT INTO %s (%s) VALUES %s", table, strings.Join(columns, ", "), strings.Join(values, ", "))
+		if _, err := tx.ExecContext(ctx, query, args...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Retryable covers deadlocks (1213) and lock wait timeouts (1205)
+func (mysqlBackend) Retryable(err error) bool {
+	var myErr *mysql.MySQLError
+	if !errors.As(err, &myErr) {
+		return false
+	}
+	return myErr.Number == 1213 || myErr.Number == 1205
+}
+
+func (mysqlBackend) Supports(feature storageFeature) bool {
+	return false
+}
+
+// =============================================================================
+// QUERY TRANSLATION
+// =============================================================================
+
+// rebindMySQL rewrites the $n placeholders of a query to ?, dropping ::type
+// casts. It returns the argument ordinal of every ? in order, or nil if the
+// query has no placeholders.
+func rebindMySQL(query string) (string, []int) {
+	if !strings.ContainsAny(query, "$:") {
+		return query, nil
+	}
+	var b strings.Builder
+	b.Grow(len(query))
+	var order []int
+	inString := false
+	for i := 0; i < len(query); i++ {
+		ch := query[i]
+		switch {
+		case ch == '\'':
+			inString = !inString
+		case inString:
+		case ch == '$' && i+1 < len(query) && isDigit(query[i+1]):
+			j := i + 1
+			for j < len(query) && isDigit(query[j]) {
+				j++
+			}
+			n, _ := strconv.Atoi(query[i+1 : j])
+			order = append(order, n)
+			b.WriteByte('?')
+			i = j - 1
+			continue
+		case ch == ':' && i+1 < len(query) && query[i+1] == ':':
+			j := i + 2
+			for j < len(query) && (isDigit(query[j]) || query[j] == '_' || (query[j]|0x20 >= 'a' && query[j]|0x20 <= 'z')) {
+				j++
+			}
+			i = j - 1
+			continue
+		}
+		b.WriteByte(ch)
+	}
+	return b.String(), order
+}
+
+func isDigit(ch byte) bool {
+	return ch >= '0' && ch <= '9'
+}
+
+// rebindArgs orders the arguments of a query by its placeholders
+func rebindArgs(args []driver.NamedValue, order []int) ([]driver.NamedValue, error) {
+	if order == nil {
+		return args, nil
+	}
+	bound := make([]driver.NamedValue, len(order))
+	for i, n := range order {
+		if n < 1 || n > len(args) {
+			return nil, fmt.Errorf("placeholder $%d without an argument", n)
+		}
+		bound[i] = driver.NamedValue{Ordinal: i + 1, Value: args[n-1].Value}
+	}
+	return bound, nil
+}
+
+// mysqlConnector opens connections that translate queries
+type mysqlConnector struct {
+	driver.Connector
+}
+
+func (c mysqlConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.Connector.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &mysqlConn{conn}, nil
+}
+
+// mysqlConn translates the queries of a MySQL connection
+type mysqlConn struct {
+	driver.Conn
+}
+
+func (c *mysqlConn) Prepare(query string) (driver.Stmt, error) {
+	return c.PrepareContext(context.Background(), query)
+}
+
+func (c *mysqlConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	rebound, order := rebindMySQL(query)
+	var stmt driver.Stmt
+	var err error
+	if p, ok := c.Conn.(driver.ConnPrepareContext); ok {
+		stmt, err = p.PrepareContext(ctx, rebound)
+	} else {
+		stmt, err = c.Conn.Prepare(rebound)
+	}
+	if err != nil {
+		return nil, err
+	}
+	inputs := -1
+	if order != nil {
+		inputs = 0
+		for _, n := range order {
+			if n > inputs {
+				inputs = n
+			}
+		}
+	}
+	return &mysqlStmt{Stmt: stmt, order: order, inputs: inputs}, nil
+}
+
+func (c *mysqlConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	q, ok := c.Conn.(driver.QueryerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	rebound, order := rebindMySQL(query)
+	bound, err := rebindArgs(args, order)
+	if err != nil {
+		return nil, err
+	}
+	return q.QueryContext(ctx, rebound, bound)
+}
+
+func (c *mysqlConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	e, ok := c.Conn.(driver.ExecerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	rebound, order := rebindMySQL(query)
+	bound, err := rebindArgs(args, order)
+	if err != nil {
+		return nil, err
+	}
+	return e.ExecContext(ctx, rebound, bound)
+}
+
+func (c *mysqlConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if b, ok := c.Conn.(driver.ConnBeginTx); ok {
+		return b.BeginTx(ctx, opts)
+	}
+	return c.Conn.Begin()
+}
+
+func (c *mysqlConn) Ping(ctx context.Context) error {
+	if p, ok := c.Conn.(driver.Pinger); ok {
+		return p.Ping(ctx)
+	}
+	return nil
+}
+
+func (c *mysqlConn) ResetSession(ctx context.Context) error {
+	if r, ok := c.Conn.(driver.SessionResetter); ok {
+		return r.ResetSession(ctx)
+	}
+	return nil
+}
+
+func (c *mysqlConn) IsValid() bool {
+	if v, ok := c.Conn.(driver.Validator); ok {
+		return v.IsValid()
+	}
+	return true
+}
+
+// mysqlStmt binds the arguments of a translated prepared statement
+type mysqlStmt struct {
+	driver.Stmt
+	order  []int
+	inputs int
+}
+
+func (s *mysqlStmt) NumInput() int {
+	if s.order == nil {
+		return s.Stmt.NumInput()
+	}
+	return s.inputs
+}
+
+func (s *mysqlStmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	bound, err := rebindArgs(args, s.order)
+	if err != nil {
+		return nil, err
+	}
+	if e, ok := s.Stmt.(driver.StmtExecContext); ok {
+		return e.ExecContext(ctx, bound)
+	}
+	return s.Stmt.Exec(namedValues(bound))
+}
+
+func (s *mysqlStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	bound, err := rebindArgs(args, s.order)
+	if err != nil {
+		return nil, err
+	}
+	if q, ok := s.Stmt.(driver.StmtQueryContext); ok {
+		return q.QueryContext(ctx, bound)
+	}
+	return s.Stmt.Query(namedValues(bound))
+}
+
+// namedValues converts arguments for drivers without context support
+func namedValues(args []driver.NamedValue) []driver.Value {
+	values := make([]driver.Value, len(args))
+	for i, arg := range args {
+		values[i] = arg.Value
+	}
+	return values
+}
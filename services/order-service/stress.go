@@ -0,0 +1,324 @@
+// =============================================================================
+// CPU AND MEMORY STRESS
+// =============================================================================
+// Burns CPU or holds memory for a bounded time, so resource dashboards,
+// container limits, throttling and OOM alerts can be demonstrated on demand.
+//
+// - CPU     "cores" goroutines each busy for "load" (0-1) of every 100ms
+// - Memory  Allocates "megabytes" (touching every page so it counts in
+//           RSS), optionally growing over "ramp_seconds" like a leak, and
+//           holds it until the run ends
+//
+// Every run ends after duration_seconds (at most STRESS_MAX_DURATION).
+// Starting a run replaces the running one of the same kind. Memory runs are
+// capped at STRESS_MAX_MEMORY_MB; set it above the container limit to
+// demonstrate an OOM kill. Stress runs need CHAOS_ENABLED.
+//
+// ENDPOINTS:
+// - GET    /admin/stress          Current runs
+// - POST   /admin/stress/cpu      {"cores": 2, "load": 0.8, "duration_seconds": 60}
+// - POST   /admin/stress/memory   {"megabytes": 512, "ramp_seconds": 120, "duration_seconds": 300}
+// - DELETE /admin/stress          Stop every run
+// =============================================================================
+
+package main
+
+import (
+	"context"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// StressRun describes a running stress test
+type StressRun struct {
+	Kind        string    `json:"kind"`
+	Cores       int       `json:"cores,omitempty"`
+	Load        float64   `json:"load,omitempty"`
+	Megabytes   int       `json:"megabytes,omitempty"`
+	RampSeconds int       `json:"ramp_seconds,omitempty"`
+	StartedAt   time.Time `json:"started_at"`
+	EndsAt      time.Time `json:"ends_at"`
+
+	cancel context.CancelFunc
+}
+
+// stressState holds the running CPU and memory runs
+type stressState struct {
+	mu   sync.Mutex
+	runs map[string]*StressRun
+}
+
+var (
+	// stress holds the process-wide stress runs
+	stress = &stressState{runs: map[string]*StressRun{}}
+
+	// stressMaxDuration bounds every run
+	stressMaxDuration = 10 * time.Minute
+
+	// stressMaxMemoryMB bounds memory runs
+	stressMaxMemoryMB = 1024
+
+	// Gauge: 1 while a stress run of the kind is active
+	stressActive = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "stress_active",
+			Help: "Whether a stress run is active (1) or not (0), by kind",
+		},
+		[]string{"kind"},
+	)
+
+	// Gauge: Memory currently held by the memory stress run
+	stressMemoryBytes = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "stress_memory_bytes",
+			Help: "Bytes currently held by the memory stress run",
+		},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(stressActive)
+	prometheus.MustRegister(stressMemoryBytes)
+}
+
+// start registers a run, replacing the running one of the same kind, and
+// returns the context it runs under
+func (s *stressState) start(run *StressRun, duration time.Duration) context.Context {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if old := s.runs[run.Kind]; old != nil {
+		old.cancel()
+	}
+
+	ctx, cancel := context.WithTimeout(backgroundCtx, duration)
+	run.cancel = cancel
+	run.StartedAt = time.Now().UTC()
+	run.EndsAt = run.StartedAt.Add(duration)
+	s.runs[run.Kind] = run
+	stressActive.WithLabelValues(run.Kind).Set(1)
+	return ctx
+}
+
+// done unregisters a run once it has ended
+func (s *stressState) done(run *StressRun) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	run.cancel()
+	if s.runs[run.Kind] == run {
+		delete(s.runs, run.Kind)
+		stressActive.WithLabelValues(run.Kind).Set(0)
+	}
+	logInfo("Stress run ended", map[string]interface{}{"kind": run.Kind})
+}
+
+// stopAll cancels every run and returns how many there were
+func (s *stressState) stopAll() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, run := range s.runs {
+		run.cancel()
+	}
+	return len(s.runs)
+}
+
+// list returns copies of the running runs
+func (s *stressState) list() []StressRun {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	runs := make([]StressRun, 0, len(s.runs))
+	for _, run := range s.runs {
+		runs = append(runs, *run)
+	}
+	return runs
+}
+
+// burnCPU keeps a core busy for load of every 100ms until ctx is done
+func burnCPU(ctx context.Context, load float64) {
+	const window = 100 * time.Millisecond
+	busy := time.Duration(float64(window) * load)
+
+	for ctx.Err() == nil {
+		// Spin for the busy part of the window, then sleep
+		start := time.Now()
+		for time.Since(start) < busy {
+		}
+		select {
+		case <-ctx.Done():
+		case <-time.After(window - busy):
+		}
+	}
+}
+
+// holdMemory allocates megabytes, spread over ramp, and holds them until
+// ctx is done
+func holdMemory(ctx context.Context, megabytes int, ramp time.Duration) {
+	const chunk = 1 << 20
+	var held [][]byte
+	defer func() {
+		held = nil
+		stressMemoryBytes.Set(0)
+		debug.FreeOSMemory()
+	}()
+
+	interval := time.Duration(0)
+	if ramp > 0 {
+		interval = ramp / time.Duration(megabytes)
+	}
+
+	for i := 0; i < megabytes; i++ {
+		if ctx.Err() != nil {
+			return
+		}
+		b := make([]byte, chunk)
+		// Touch every page so the memory is resident, not just reserved
+		for j := 0; j < len(b); j += 4096 {
+			b[j] = 1
+		}
+		held = append(held, b)
+		stressMemoryBytes.Set(float64(len(held) * chunk))
+
+		if interval > 0 {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(interval):
+			}
+		}
+	}
+	<-ctx.Done()
+}
+
+// stressDuration validates a requested duration in seconds
+func stressDuration(seconds int) time.Duration {
+	d := time.Duration(seconds) * time.Second
+	if d <= 0 {
+		d = time.Minute
+	}
+	if d > stressMaxDuration {
+		d = stressMaxDuration
+	}
+	return d
+}
+
+// =============================================================================
+// STRESS ADMIN HANDLERS
+// =============================================================================
+
+// listStress returns the running stress runs
+func listStress(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"runs":                 stress.list(),
+		"max_duration_seconds": stressMaxDuration.Seconds(),
+		"max_memory_mb":        stressMaxMemoryMB,
+		"cpus":                 runtime.GOMAXPROCS(0),
+	})
+}
+
+// startCPUStress burns CPU for a bounded duration
+func startCPUStress(c *gin.Context) {
+	if !chaosEnabled {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Chaos features are disabled (CHAOS_ENABLED=false)"})
+		return
+	}
+
+	var req struct {
+		Cores           int     `json:"cores" binding:"min=0"`
+		Load            float64 `json:"load" binding:"min=0,max=1"`
+		DurationSeconds int     `json:"duration_seconds" binding:"min=0"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Cores == 0 {
+		req.Cores = 1
+	}
+	if max := runtime.NumCPU(); req.Cores > max {
+		req.Cores = max
+	}
+	if req.Load == 0 {
+		req.Load = 1
+	}
+
+	run := &StressRun{Kind: "cpu", Cores: req.Cores, Load: req.Load}
+	ctx := stress.start(run, stressDuration(req.DurationSeconds))
+
+	var wg sync.WaitGroup
+	for i := 0; i < req.Cores; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			burnCPU(ctx, req.Load)
+		}()
+	}
+	go func() {
+		wg.Wait()
+		stress.done(run)
+	}()
+
+	logWarn("CPU stress started", map[string]interface{}{
+		"cores":   run.Cores,
+		"load":    run.Load,
+		"ends_at": run.EndsAt,
+	})
+	c.JSON(http.StatusAccepted, run)
+}
+
+// startMemoryStress holds memory for a bounded duration
+func startMemoryStress(c *gin.Context) {
+	if !chaosEnabled {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Chaos features are disabled (CHAOS_ENABLED=false)"})
+		return
+	}
+
+	var req struct {
+		Megabytes       int `json:"megabytes" binding:"required,min=1"`
+		RampSeconds     int `json:"ramp_seconds" binding:"min=0"`
+		DurationSeconds int `json:"duration_seconds" binding:"min=0"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Megabytes > stressMaxMemoryMB {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "megabytes exceeds STRESS_MAX_MEMORY_MB", "max": stressMaxMemoryMB})
+		return
+	}
+
+	duration := stressDuration(req.DurationSeconds)
+	ramp := time.Duration(req.RampSeconds) * time.Second
+	if ramp > duration {
+		ramp = duration
+	}
+
+	run := &StressRun{Kind: "memory", Megabytes: req.Megabytes, RampSeconds: int(ramp.Seconds())}
+	ctx := stress.start(run, duration)
+	go func() {
+		holdMemory(ctx, req.Megabytes, ramp)
+		stress.done(run)
+	}()
+
+	logWarn("Memory stress started", map[string]interface{}{
+		"megabytes":    run.Megabytes,
+		"ramp_seconds": run.RampSeconds,
+		"ends_at":      run.EndsAt,
+	})
+	c.JSON(http.StatusAccepted, run)
+}
+
+// stopStress stops every stress run
+func stopStress(c *gin.Context) {
+	stopped := stress.stopAll()
+	logInfoContext(c.Request.Context(), "Stress runs stopped", map[string]interface{}{"stopped": stopped})
+	c.JSON(http.StatusOK, gin.H{"message": "Stress runs stopped", "stopped": stopped})
+}
@@ -0,0 +1,172 @@
+// =============================================================================
+// DIFFERENTIAL SYNC
+// =============================================================================
+// Offline clients (mobile apps, POS terminals) keep a local copy of the
+// orders and sync it incrementally instead of downloading every order
+// again:
+//
+//   GET /api/v1/orders/changes                   Everything, from the start
+//   GET /api/v1/orders/changes?since=<cursor>    What changed since then
+//
+//   {"changes": [
+//     {"id": "...", "change": "created", "version": 1, "changed_at": "..."},
+//     {"id": "...", "change": "updated", "version": 4, "changed_at": "..."},
+//     {"id": "...", "change": "deleted", "version": 4, "changed_at": "..."}],
+//    "cursor": "djE6MTcx...", "has_more": false}
+//
+// A client stores the cursor of each response and sends it as since on
+// its next sync; while has_more is true it asks again straight away.
+// Changes are in the order they happened, at most limit per page (default
+// 500, at most 1000). An order changed several times shows up once, with
+// its latest version; the client fetches the orders whose version differs
+// from its copy (GET /api/v1/orders/:id, whose ETag is the version).
+//
+// Orders are only ever cancelled through the API, which is an update;
+// "deleted" covers orders removed from the database directly, recorded in
+// order_tombstones by a trigger. A demo reset (see reset.go) truncates
+// everything without tombstones, so clients must resync from scratch
+// after one.
+//
+// Cursors are opaque. Changes younger than syncSafetyLag are held back
+// until the next sync: a transaction that started earlier but commits
+// later would otherwise get a changed_at before a cursor already handed
+// out, and be missed. A change may therefore be returned twice, never
+// skipped; the version makes applying it twice harmless.
+// =============================================================================
+
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// syncSafetyLag holds back changes that may still be overtaken by slower
+// transactions
+const syncSafetyLag = 5 * time.Second
+
+const (
+	defaultSyncLimit = 500
+	maxSyncLimit     = 1000
+)
+
+// OrderChange is a change of an order since a sync cursor
+type OrderChange struct {
+	ID        string    `json:"id"`
+	Change    string    `json:"change"`
+	Version   int       `json:"version"`
+	ChangedAt time.Time `json:"changed_at"`
+}
+
+// syncCursor is the position of a client in the change feed: the time and
+// ID of the last change it received
+type syncCursor struct {
+	at time.Time
+	id string
+}
+
+// encode returns the opaque form of the cursor
+func (c syncCursor) encode() string {
+	return base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf("v1:%d:%s", c.at.UnixNano(), c.id)))
+}
+
+// parseSyncCursor decodes a cursor from since
+func parseSyncCursor(s string) (syncCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return syncCursor{}, err
+	}
+	parts := strings.SplitN(string(raw), ":", 3)
+	if len(parts) != 3 || parts[0] != "v1" {
+		return syncCursor{}, fmt.Errorf("unknown cursor format")
+	}
+	nanos, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return syncCursor{}, err
+	}
+	return syncCursor{at: time.Unix(0, nanos).UTC(), id: parts[2]}, nil
+}
+
+// getOrderChanges serves GET /api/v1/orders/changes
+func (a *App) getOrderChanges(c *gin.Context) {
+	cursor := syncCursor{at: time.Unix(0, 0).UTC()}
+	if since := c.Query("since"); since != "" {
+		parsed, err := parseSyncCursor(since)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": tr(c, "Invalid sync cursor")})
+			return
+		}
+		cursor = parsed
+	}
+	limit := defaultSyncLimit
+	if l := c.Query("limit"); l != "" {
+		n, err := strconv.Atoi(l)
+		if err != nil || n <= 0 || n > maxSyncLimit {
+			c.JSON(http.StatusBadRequest, gin.H{"error": tr(c, "limit must be between 1 and %d", maxSyncLimit)})
+			return
+		}
+		limit = n
+	}
+	until := time.Now().Add(-syncSafetyLag).UTC()
+
+	ctx := c.Request.Context()
+	rows, err := a.db.QueryContext(dbOperation(ctx, "order_changes"), `
+		SELECT id, change, version, changed_at FROM (
+			SELECT id::text AS id,
+			       CASE WHEN created_at > $1 THEN 'created' ELSE 'updated' END AS change,
+			       version, updated_at AS changed_at
+			FROM orders
+			UNION ALL
+			SELECT order_id::text, 'deleted', version, deleted_at
+			FROM order_tombstones
+		) changes
+		WHERE (changed_at > $1 OR (changed_at = $1 AND id > $2))
+		  AND changed_at <= $3
+		ORDER BY changed_at, id
+		LIMIT $4
+	`, cursor.at, cursor.id, until, limit+1)
+	if err != nil {
+		logErrorContext(ctx, "Failed to load order changes", map[string]interface{}{"error": err.Error()})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": tr(c, "Database error")})
+		return
+	}
+	defer rows.Close()
+
+	changes := []OrderChange{}
+	for rows.Next() {
+		var ch OrderChange
+		if err := rows.Scan(&ch.ID, &ch.Change, &ch.Version, &ch.ChangedAt); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": tr(c, "Database error")})
+			return
+		}
+		changes = append(changes, ch)
+	}
+	if err := rows.Err(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": tr(c, "Database error")})
+		return
+	}
+
+	hasMore := len(changes) > limit
+	next := cursor
+	switch {
+	case hasMore:
+		changes = changes[:limit]
+		last := changes[limit-1]
+		next = syncCursor{at: last.ChangedAt, id: last.ID}
+	case until.After(cursor.at):
+		// Everything up to until was returned; "~" sorts after every ID
+		next = syncCursor{at: until, id: "~"}
+	}
+
+	writeJSON(c, http.StatusOK, gin.H{
+		"changes":  changes,
+		"cursor":   next.encode(),
+		"has_more": hasMore,
+	})
+}
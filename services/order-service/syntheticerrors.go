@@ -0,0 +1,155 @@
+// =============================================================================
+// SYNTHETIC ERROR RATE
+// =============================================================================
+// A standing, low error rate for alert-tuning exercises: with
+// SYNTHETIC_ERROR_RATE=0.02 about 2% of requests to the selected routes
+// fail with a 500, for as long as the service runs.
+//
+// - SYNTHETIC_ERROR_RATE    Probability (0-1) of failing a request
+// - SYNTHETIC_ERROR_ROUTES  "METHOD /route" entries, e.g.
+//                           "POST /api/v1/orders,GET /api/v1/orders/:id"
+//                           (empty = every API route)
+//
+// Both can be changed at runtime with PUT /admin/synthetic-errors.
+// Synthetic failures carry "X-Chaos-Injected: synthetic-error" and are
+// counted in synthetic_errors_total. Like all fault injection they only
+// take effect when CHAOS_ENABLED is true.
+// =============================================================================
+
+package main
+
+import (
+	"math/rand"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// syntheticErrorSettings is the current synthetic error configuration
+type syntheticErrorSettings struct {
+	mu     sync.RWMutex
+	rate   float64
+	routes map[string]bool
+}
+
+var (
+	// syntheticErrors is the process-wide synthetic error setting
+	syntheticErrors = &syntheticErrorSettings{}
+
+	// Counter: Synthetic errors returned, by endpoint
+	syntheticErrorsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "synthetic_errors_total",
+			Help: "Total number of synthetic 500 errors returned",
+		},
+		[]string{"method", "endpoint"},
+	)
+
+	// Gauge: Configured synthetic error rate
+	syntheticErrorRate = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "synthetic_error_rate",
+			Help: "Configured probability of returning a synthetic error",
+		},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(syntheticErrorsTotal)
+	prometheus.MustRegister(syntheticErrorRate)
+}
+
+// set replaces the rate and the targeted routes
+func (s *syntheticErrorSettings) set(rate float64, routes []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.rate = rate
+	s.routes = map[string]bool{}
+	for _, r := range routes {
+		if r = strings.TrimSpace(r); r != "" {
+			s.routes[r] = true
+		}
+	}
+	syntheticErrorRate.Set(rate)
+}
+
+// get returns the rate and the targeted routes
+func (s *syntheticErrorSettings) get() (float64, []string) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	routes := make([]string, 0, len(s.routes))
+	for r := range s.routes {
+		routes = append(routes, r)
+	}
+	return s.rate, routes
+}
+
+// shouldFail decides whether a request gets a synthetic error
+func (s *syntheticErrorSettings) shouldFail(method, route string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.rate <= 0 || !chaosEnabled {
+		return false
+	}
+	if len(s.routes) > 0 && !s.routes[method+" "+route] {
+		return false
+	}
+	return rand.Float64() < s.rate
+}
+
+// syntheticErrorMiddleware fails the configured share of requests
+func syntheticErrorMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		route := c.FullPath()
+		if syntheticErrors.shouldFail(c.Request.Method, route) {
+			syntheticErrorsTotal.WithLabelValues(c.Request.Method, route).Inc()
+			c.Header(chaosHeader, "synthetic-error")
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+				"error": "Synthetic error (SYNTHETIC_ERROR_RATE)",
+			})
+			return
+		}
+		c.Next()
+	}
+}
+
+// getSyntheticErrors returns the synthetic error settings
+func getSyntheticErrors(c *gin.Context) {
+	rate, routes := syntheticErrors.get()
+	c.JSON(http.StatusOK, gin.H{
+		"rate":          rate,
+		"routes":        routes,
+		"chaos_enabled": chaosEnabled,
+	})
+}
+
+// setSyntheticErrors changes the synthetic error settings
+func setSyntheticErrors(c *gin.Context) {
+	var req struct {
+		Rate   float64  `json:"rate" binding:"min=0,max=1"`
+		Routes []string `json:"routes"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Rate > 0 && !chaosEnabled {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Chaos features are disabled (CHAOS_ENABLED=false)"})
+		return
+	}
+
+	syntheticErrors.set(req.Rate, req.Routes)
+	logWarnContext(c.Request.Context(), "Synthetic error rate changed", map[string]interface{}{
+		"rate":   req.Rate,
+		"routes": req.Routes,
+	})
+	annotate("config", "Synthetic error rate set to %g (routes: %s)", req.Rate, strings.Join(req.Routes, ", "))
+
+	getSyntheticErrors(c)
+}
@@ -0,0 +1,219 @@
+// =============================================================================
+// CUSTOMER TIERS
+// =============================================================================
+// Orders belong to a customer tier (standard, gold or platinum) that
+// decides how urgently they are handled:
+//
+//   1. The customer_tier of the create request, if given
+//   2. Otherwise, with CUSTOMER_TIER_LOOKUP=true, the tier field of the
+//      customer in the user service (GET /api/v1/users/:id), cached in
+//      Redis for CUSTOMER_TIER_CACHE_TTL
+//   3. Otherwise standard
+//
+// The tier is stored on the order (orders.customer_tier, filterable with
+// GET /api/v1/orders?tier=gold) and is part of the order's event stream.
+//
+// PRIORITIES:
+// - Events of gold and platinum orders go through a priority lane of the
+//   publish buffer that the publisher workers drain first, so a backlog of
+//   standard events doesn't delay them (see publisher.go)
+// - Published events carry the AMQP priority (standard 0, gold 5,
+//   platinum 9) and a customer_tier header; consumers get tier ordering by
+//   declaring their queues with x-max-priority. Routing keys are unchanged,
+//   so existing bindings (order.*) keep working.
+//
+// METRICS (latency SLIs per tier):
+// - order_create_duration_by_tier_seconds{tier}  Create latency
+// - event_publish_delay_seconds{tier}           Buffer wait before publishing
+// - orders_created_by_tier_total{tier}
+// =============================================================================
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Customer tiers
+const (
+	tierStandard = "standard"
+	tierGold     = "gold"
+	tierPlatinum = "platinum"
+)
+
+// customerTiers maps each tier to its AMQP message priority
+var customerTiers = map[string]uint8{
+	tierStandard: 0,
+	tierGold:     5,
+	tierPlatinum: 9,
+}
+
+// orderTierCacheSize bounds the in-memory cache of order tiers
+const orderTierCacheSize = 10000
+
+var (
+	// tierLookup enables fetching tiers from the user service
+	tierLookup bool
+
+	// tierCacheTTL is how long fetched customer tiers are cached in Redis
+	tierCacheTTL = 10 * time.Minute
+
+	// orderTiers caches the tier of recent orders, so events about them
+	// can be prioritized without a query
+	orderTiers   = map[string]string{}
+	orderTiersMu sync.Mutex
+
+	// Histogram: Create latency per tier
+	orderCreateDurationByTier = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "order_create_duration_by_tier_seconds",
+			Help:    "Time taken to create an order, by customer tier",
+			Buckets: prometheus.DefBuckets,
+		},
+		[]string{"tier"},
+	)
+
+	// Histogram: Time events wait in the publish buffer per tier
+	eventPublishDelay = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "event_publish_delay_seconds",
+			Help:    "Time order events waited in the publish buffer before being published, by customer tier",
+			Buckets: []float64{.001, .005, .01, .05, .1, .5, 1, 5, 10, 30},
+		},
+		[]string{"tier"},
+	)
+
+	// Counter: Orders created per tier
+	ordersCreatedByTier = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "orders_created_by_tier_total",
+			Help: "Total number of orders created, by customer tier",
+		},
+		[]string{"tier"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(orderCreateDurationByTier)
+	prometheus.MustRegister(eventPublishDelay)
+	prometheus.MustRegister(ordersCreatedByTier)
+}
+
+// validTier reports whether tier is a known customer tier
+func validTier(tier string) bool {
+	_, ok := customerTiers[tier]
+	return ok
+}
+
+// tierPriority returns the AMQP priority of a tier (0 for unknown tiers)
+func tierPriority(tier string) uint8 {
+	return customerTiers[tier]
+}
+
+// rememberOrderTier caches the tier of an order
+func rememberOrderTier(orderID, tier string) {
+	orderTiersMu.Lock()
+	defer orderTiersMu.Unlock()
+	if len(orderTiers) >= orderTierCacheSize {
+		orderTiers = map[string]string{}
+	}
+	orderTiers[orderID] = tier
+}
+
+// cachedOrderTier returns the cached tier of an order, "" if unknown
+func cachedOrderTier(orderID string) string {
+	orderTiersMu.Lock()
+	defer orderTiersMu.Unlock()
+	return orderTiers[orderID]
+}
+
+// orderTier returns the tier of an order, standard if it can't be read
+func (a *App) orderTier(ctx context.Context, orderID string) string {
+	if tier := cachedOrderTier(orderID); tier != "" {
+		return tier
+	}
+	if a.db == nil || !uuidPattern.MatchString(orderID) {
+		return tierStandard
+	}
+
+	var tier string
+	if err := a.db.QueryRowContext(ctx, `SELECT customer_tier FROM orders WHERE id = $1`, orderID).Scan(&tier); err != nil {
+		return tierStandard
+	}
+	rememberOrderTier(orderID, tier)
+	return tier
+}
+
+// resolveCustomerTier returns the tier of a new order's customer
+func (a *App) resolveCustomerTier(ctx context.Context, requested, customerID string) string {
+	if requested != "" {
+		return requested
+	}
+	if !tierLookup {
+		return tierStandard
+	}
+
+	key := cacheKeyPrefix + "customer-tier:" + customerID
+	if tier, err := a.redisClient.Get(ctx, key).Result(); err == nil && validTier(tier) {
+		return tier
+	} else if err != nil && !errors.Is(err, redis.Nil) {
+		logDebugContext(ctx, "Customer tier cache unavailable", map[string]interface{}{"error": err.Error()})
+	}
+
+	tier, err := a.fetchCustomerTier(ctx, customerID)
+	if err != nil {
+		// An unknown tier must not fail the order
+		logWarnContext(ctx, "Customer tier lookup failed, using standard", map[string]interface{}{
+			"customer_id": customerID,
+			"error":       err.Error(),
+		})
+		return tierStandard
+	}
+	a.redisClient.Set(ctx, key, tier, tierCacheTTL)
+	return tier
+}
+
+// fetchCustomerTier reads a customer's tier from the user service
+func (a *App) fetchCustomerTier(ctx context.Context, customerID string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet,
+		a.userServiceURL+"/api/v1/users/"+url.PathEscape(customerID), nil)
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := a.httpClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("user service unreachable: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		// Guests and customers unknown to the user service
+		return tierStandard, nil
+	default:
+		return "", fmt.Errorf("user service returned status %d", resp.StatusCode)
+	}
+
+	var user struct {
+		Tier string `json:"tier"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&user); err != nil {
+		return "", fmt.Errorf("invalid user service response: %w", err)
+	}
+	if !validTier(user.Tier) {
+		return tierStandard, nil
+	}
+	return user.Tier, nil
+}
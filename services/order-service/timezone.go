@@ -0,0 +1,201 @@
+// =============================================================================
+// TIMEZONES
+// =============================================================================
+// "Today's orders" should mean the caller's today, not UTC's. Date filters
+// and daily figures are therefore computed in the caller's timezone:
+//
+//   1. The tz query parameter (an IANA name: tz=Europe/Berlin)
+//   2. Otherwise the stored timezone of the customer_id query parameter
+//      (set with PUT /api/v1/customers/:id/timezone)
+//   3. Otherwise UTC
+//
+// WHERE IT APPLIES:
+// - GET /api/v1/orders             created_after, created_before and
+//                                  date (today, yesterday or YYYY-MM-DD)
+// - GET /api/v1/orders/search      Plain-date from/to
+// - GET /api/v1/orders/stats       Days of the daily series
+// - GET /api/v1/reports/daily      Days of the summaries
+//
+// Plain dates (YYYY-MM-DD) mean midnight in the timezone; RFC 3339
+// timestamps carry their own offset and are used as given. Responses
+// report the timezone and return day boundaries with explicit offsets
+// ("2026-03-29T00:00:00+01:00" to "2026-03-30T00:00:00+02:00"), so DST
+// days are visibly 23 or 25 hours long.
+//
+// The stats rollups are hourly, so days in timezones with a half-hour
+// offset (e.g. Asia/Kolkata) are approximated to the hour.
+//
+// ENDPOINTS:
+// - GET /api/v1/customers/:id/timezone  A customer's timezone
+// - PUT /api/v1/customers/:id/timezone  Set it: {"timezone": "America/New_York"}
+// =============================================================================
+
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"time"
+	_ "time/tzdata" // Timezone database for images without /usr/share/zoneinfo
+
+	"github.com/gin-gonic/gin"
+)
+
+// reportTimezone is the timezone the daily-summary job computes days in
+var reportTimezone = time.UTC
+
+// setReportTimezone validates and applies REPORT_TIMEZONE
+func setReportTimezone(name string) error {
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return fmt.Errorf("invalid REPORT_TIMEZONE %q: %w", name, err)
+	}
+	reportTimezone = loc
+	return nil
+}
+
+// resolveTimezone returns the timezone of a request
+func (a *App) resolveTimezone(c *gin.Context) (*time.Location, error) {
+	if name := c.Query("tz"); name != "" {
+		loc, err := time.LoadLocation(name)
+		if err != nil {
+			return nil, fmt.Errorf("unknown timezone %q (expected an IANA name such as Europe/Berlin)", name)
+		}
+		return loc, nil
+	}
+
+	if customerID := c.Query("customer_id"); uuidPattern.MatchString(customerID) {
+		var name string
+		err := a.db.QueryRowContext(c.Request.Context(), `
+			SELECT timezone FROM customer_timezones WHERE customer_id = $1
+		`, customerID).Scan(&name)
+		if err == nil {
+			if loc, err := time.LoadLocation(name); err == nil {
+				return loc, nil
+			}
+		} else if err != sql.ErrNoRows {
+			return nil, err
+		}
+	}
+	return time.UTC, nil
+}
+
+// startOfDay returns midnight of t's day in loc
+func startOfDay(t time.Time, loc *time.Location) time.Time {
+	t = t.In(loc)
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, loc)
+}
+
+// parseDay parses a plain date as midnight in loc
+func parseDay(s string, loc *time.Location) (time.Time, error) {
+	return time.ParseInLocation("2006-01-02", s, loc)
+}
+
+// parseTimeBound accepts an RFC 3339 timestamp or a plain date, which
+// means midnight in loc
+func parseTimeBound(s string, loc *time.Location) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	if t, err := parseDay(s, loc); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q (expected RFC 3339 or YYYY-MM-DD)", s)
+}
+
+// createdRange parses the created_after, created_before and date
+// parameters. Nil bounds are open.
+func createdRange(c *gin.Context, loc *time.Location) (from, to *time.Time, err error) {
+	if date := c.Query("date"); date != "" {
+		var day time.Time
+		switch date {
+		case "today":
+			day = startOfDay(time.Now(), loc)
+		case "yesterday":
+			day = startOfDay(time.Now(), loc).AddDate(0, 0, -1)
+		default:
+			if day, err = parseDay(date, loc); err != nil {
+				return nil, nil, fmt.Errorf("invalid date %q (expected today, yesterday or YYYY-MM-DD)", date)
+			}
+		}
+		next := day.AddDate(0, 0, 1)
+		return &day, &next, nil
+	}
+
+	if v := c.Query("created_after"); v != "" {
+		t, err := parseTimeBound(v, loc)
+		if err != nil {
+			return nil, nil, err
+		}
+		from = &t
+	}
+	if v := c.Query("created_before"); v != "" {
+		t, err := parseTimeBound(v, loc)
+		if err != nil {
+			return nil, nil, err
+		}
+		to = &t
+	}
+	return from, to, nil
+}
+
+// =============================================================================
+// CUSTOMER TIMEZONE HANDLERS
+// =============================================================================
+
+// getCustomerTimezone returns a customer's stored timezone
+func (a *App) getCustomerTimezone(c *gin.Context) {
+	id := c.Param("id")
+	if !uuidPattern.MatchString(id) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": tr(c, "Invalid customer ID")})
+		return
+	}
+
+	var name string
+	var updatedAt time.Time
+	err := a.db.QueryRowContext(c.Request.Context(), `
+		SELECT timezone, updated_at FROM customer_timezones WHERE customer_id = $1
+	`, id).Scan(&name, &updatedAt)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusOK, gin.H{"customer_id": id, "timezone": "UTC", "default": true})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": tr(c, "Database error")})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"customer_id": id, "timezone": name, "updated_at": updatedAt})
+}
+
+// setCustomerTimezone stores a customer's timezone
+func (a *App) setCustomerTimezone(c *gin.Context) {
+	id := c.Param("id")
+	if !uuidPattern.MatchString(id) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": tr(c, "Invalid customer ID")})
+		return
+	}
+
+	var req struct {
+		Timezone string `json:"timezone" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	loc, err := time.LoadLocation(req.Timezone)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown timezone " + req.Timezone})
+		return
+	}
+
+	_, err = a.db.ExecContext(c.Request.Context(),
+		storage.Upsert("customer_timezones", []string{"customer_id"}, "customer_id", "timezone", "updated_at"),
+		id, loc.String(), time.Now().UTC())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": tr(c, "Database error")})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"customer_id": id, "timezone": loc.String()})
+}
@@ -0,0 +1,134 @@
+// =============================================================================
+// ORDER CREATION TIMING BREAKDOWN
+// =============================================================================
+// Where does the time of POST /api/v1/orders go? Every order creation
+// records how long each of its phases took, so workshop participants can
+// see it in the response instead of digging through traces:
+//
+//   curl -H 'X-Debug-Timing: 1' -X POST .../api/v1/orders -d @order.json
+//   {"id": "...", "timing": {"validation_ms": 0.4, "address_ms": 1.2,
+//    "inventory_ms": 38.1, "tier_ms": 12.5, "duplicate_check_ms": 0.9,
+//    "db_ms": 6.3, "publish_ms": 0.8, "other_ms": 0.3, "total_ms": 60.5}}
+//
+// PHASES:
+// - validation        Binding the body and checking the request limits
+// - address           Resolving a saved shipping address
+// - inventory         Checking the items against the inventory catalog
+// - tier              Looking up the customer's tier with the user service
+// - duplicate_check   Looking for a recent identical order
+// - db                Inserting the order and its items
+// - publish           Storing the order events in the outbox (they are
+//                     published to RabbitMQ by the relay, after the response)
+//
+// Phases that didn't run (e.g. no saved address) are left out. Payment is
+// not part of order creation, so it has no phase. In event-sourced mode the
+// outbox write is part of db.
+//
+// The breakdown is returned with ?debug=timing or an X-Debug-Timing header,
+// only while TIMING_BREAKDOWN_ENABLED is on (default in the dev and staging
+// profiles). It is always added to the request's span as
+// order.timing.<phase>_ms attributes.
+// =============================================================================
+
+package main
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// timingBreakdownEnabled allows clients to request the breakdown
+var timingBreakdownEnabled bool
+
+// timingBreakdown collects the durations of the phases of one request
+type timingBreakdown struct {
+	mu     sync.Mutex
+	start  time.Time
+	order  []string
+	phases map[string]time.Duration
+}
+
+// timingBreakdownKey stores the breakdown of a request in its context
+type timingBreakdownKey struct{}
+
+// withTimingBreakdown starts a breakdown for a request
+func withTimingBreakdown(ctx context.Context) (context.Context, *timingBreakdown) {
+	b := &timingBreakdown{start: time.Now(), phases: map[string]time.Duration{}}
+	return context.WithValue(ctx, timingBreakdownKey{}, b), b
+}
+
+// timePhase starts timing a phase of the context's breakdown and returns
+// the function that stops it. Without a breakdown it does nothing.
+func timePhase(ctx context.Context, phase string) func() {
+	b, _ := ctx.Value(timingBreakdownKey{}).(*timingBreakdown)
+	if b == nil {
+		return func() {}
+	}
+	start := time.Now()
+	return func() { b.add(phase, time.Since(start)) }
+}
+
+// add adds time to a phase; phases that run more than once accumulate
+func (b *timingBreakdown) add(phase string, d time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.phases[phase]; !ok {
+		b.order = append(b.order, phase)
+	}
+	b.phases[phase] += d
+}
+
+// fields returns the phases in milliseconds, with the time outside of them
+// as other_ms and the total so far
+func (b *timingBreakdown) fields() map[string]float64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	total := time.Since(b.start)
+	other := total
+	fields := make(map[string]float64, len(b.phases)+2)
+	for _, phase := range b.order {
+		fields[phase+"_ms"] = timingMillis(b.phases[phase])
+		other -= b.phases[phase]
+	}
+	if other < 0 {
+		other = 0
+	}
+	fields["other_ms"] = timingMillis(other)
+	fields["total_ms"] = timingMillis(total)
+	return fields
+}
+
+// annotate adds the breakdown to a span
+func (b *timingBreakdown) annotate(span *Span) {
+	if span == nil {
+		return
+	}
+	for name, ms := range b.fields() {
+		span.SetAttr("order.timing."+name, ms)
+	}
+}
+
+// timingMillis converts a duration to milliseconds with one decimal
+func timingMillis(d time.Duration) float64 {
+	return math.Round(float64(d.Microseconds())/100) / 10
+}
+
+// timingRequested reports whether a request asked for the breakdown
+func timingRequested(c *gin.Context) bool {
+	if !timingBreakdownEnabled {
+		return false
+	}
+	if c.Query("debug") == "timing" {
+		return true
+	}
+	switch c.GetHeader("X-Debug-Timing") {
+	case "", "0", "false":
+		return false
+	}
+	return true
+}
@@ -0,0 +1,308 @@
+// =============================================================================
+// DEPENDENCY TOPOLOGY
+// =============================================================================
+// GET /health/topology actively probes every dependency in parallel and
+// reports what it found, for a service map of the order service and its
+// neighbours:
+//
+// - postgres      SELECT of the server version
+// - redis         INFO server
+// - rabbitmq      Opens and closes a channel; version from the connection
+// - search        GET / of the search cluster (only when SEARCH_URL is set)
+// - inventory, payment, user, notification
+//                 GET /health of each service, which reports its version
+//
+// Each dependency gets a status:
+// - up        Answered within TOPOLOGY_DEGRADED_LATENCY (default 500ms)
+// - degraded  Answered slower, or a service reported a status other than ok
+// - down      Failed or didn't answer within TOPOLOGY_PROBE_TIMEOUT (default 2s)
+//
+// The document lists the dependencies and also carries "nodes" and "edges"
+// in the field layout of Grafana's Node Graph panel (id, title, subtitle,
+// mainstat, secondarystat, arc__*), so the Infinity data source can draw
+// it directly. The overall status is degraded when anything is not up; the
+// endpoint always answers 200, since it describes the neighbourhood rather
+// than this instance (that's /ready).
+//
+// Probes go through the same clients as real traffic, so simulated outages
+// and slowdowns (see outages.go and slowdeps.go) show up on the map. Every
+// probe also sets dependency_up and dependency_probe_duration_seconds.
+// =============================================================================
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	// topologyProbeTimeout bounds each dependency probe
+	topologyProbeTimeout = 2 * time.Second
+
+	// topologyDegradedLatency is the probe latency above which a
+	// dependency counts as degraded
+	topologyDegradedLatency = 500 * time.Millisecond
+
+	// Gauge: Whether a dependency answered its last topology probe
+	dependencyUp = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "dependency_up",
+			Help: "Whether the dependency answered the last topology probe (1) or not (0)",
+		},
+		[]string{"dependency", "kind"},
+	)
+
+	// Gauge: Latency of the last topology probe
+	dependencyProbeDuration = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "dependency_probe_duration_seconds",
+			Help: "Duration of the last topology probe of a dependency",
+		},
+		[]string{"dependency", "kind"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(dependencyUp)
+	prometheus.MustRegister(dependencyProbeDuration)
+}
+
+// DependencyHealth is the probe result of one dependency
+type DependencyHealth struct {
+	Name      string  `json:"name"`
+	Kind      string  `json:"kind"`
+	Status    string  `json:"status"`
+	LatencyMs float64 `json:"latency_ms"`
+	Version   string  `json:"version,omitempty"`
+	Error     string  `json:"error,omitempty"`
+}
+
+// topologyProbe checks one dependency and returns its version
+type topologyProbe struct {
+	name  string
+	kind  string
+	probe func(ctx context.Context) (version string, err error)
+}
+
+// errDegraded marks a probe that got an answer reporting a problem
+var errDegraded = errors.New("degraded")
+
+// topologyProbes lists the dependencies of this service
+func (a *App) topologyProbes() []topologyProbe {
+	probes := []topologyProbe{
+		{"postgres", "database", func(ctx context.Context) (string, error) {
+			var version string
+			err := a.db.QueryRowContext(ctx, `SELECT current_setting('server_version')`).Scan(&version)
+			return version, err
+		}},
+		{"redis", "cache", func(ctx context.Context) (string, error) {
+			info, err := a.redisClient.Info(ctx, "server").Result()
+			if err != nil {
+				return "", err
+			}
+			for _, line := range strings.Split(info, "\n") {
+				if v := strings.TrimPrefix(line, "redis_version:"); v != line {
+					return strings.TrimSpace(v), nil
+				}
+			}
+			return "", nil
+		}},
+		{"rabbitmq", "broker", func(ctx context.Context) (string, error) {
+			if err := outages.check("rabbitmq"); err != nil {
+				return "", err
+			}
+			if !a.rabbitConnected() {
+				return "", errors.New("not connected")
+			}
+			ch, err := a.rabbitConnection().Channel()
+			if err != nil {
+				return "", err
+			}
+			ch.Close()
+			version, _ := a.rabbitConnection().Properties["version"].(string)
+			return version, nil
+		}},
+	}
+
+	if searchEnabled() {
+		probes = append(probes, topologyProbe{"search", "search", func(ctx context.Context) (string, error) {
+			var info struct {
+				Version struct {
+					Number string `json:"number"`
+				} `json:"version"`
+			}
+			err := searchRequest(ctx, http.MethodGet, "/", "", nil, &info)
+			return info.Version.Number, err
+		}})
+	}
+
+	for _, svc := range []struct{ name, url string }{
+		{"inventory", a.inventoryServiceURL},
+		{"payment", a.paymentServiceURL},
+		{"user", a.userServiceURL},
+		{"notification", a.notificationServiceURL},
+	} {
+		url := svc.url
+		probes = append(probes, topologyProbe{svc.name, "service", func(ctx context.Context) (string, error) {
+			return a.probeServiceHealth(ctx, url)
+		}})
+	}
+	return probes
+}
+
+// probeServiceHealth calls a service's /health endpoint and returns the
+// version it reports
+func (a *App) probeServiceHealth(ctx context.Context, baseURL string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, baseURL+"/health", nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := a.httpClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var health struct {
+		Status  string `json:"status"`
+		Version string `json:"version"`
+	}
+	json.NewDecoder(resp.Body).Decode(&health)
+	if resp.StatusCode != http.StatusOK {
+		return health.Version, fmt.Errorf("health check returned %d", resp.StatusCode)
+	}
+	if health.Status != "" && !strings.EqualFold(health.Status, "ok") &&
+		!strings.EqualFold(health.Status, "up") && !strings.EqualFold(health.Status, "healthy") {
+		return health.Version, fmt.Errorf("%w: service reports %q", errDegraded, health.Status)
+	}
+	return health.Version, nil
+}
+
+// runTopologyProbe probes a dependency and records the result
+func runTopologyProbe(ctx context.Context, p topologyProbe) DependencyHealth {
+	ctx, cancel := context.WithTimeout(ctx, topologyProbeTimeout)
+	defer cancel()
+
+	start := time.Now()
+	version, err := p.probe(ctx)
+	elapsed := time.Since(start)
+
+	dep := DependencyHealth{
+		Name:      p.name,
+		Kind:      p.kind,
+		Status:    "up",
+		LatencyMs: float64(elapsed.Microseconds()) / 1000,
+		Version:   version,
+	}
+	switch {
+	case errors.Is(err, errDegraded):
+		dep.Status = "degraded"
+		dep.Error = err.Error()
+	case err != nil:
+		dep.Status = "down"
+		dep.Error = err.Error()
+	case elapsed > topologyDegradedLatency:
+		dep.Status = "degraded"
+	}
+
+	up := 1.0
+	if dep.Status == "down" {
+		up = 0
+	}
+	dependencyUp.WithLabelValues(p.name, p.kind).Set(up)
+	dependencyProbeDuration.WithLabelValues(p.name, p.kind).Set(elapsed.Seconds())
+	return dep
+}
+
+// topologyHealth probes every dependency and returns the topology document
+func (a *App) topologyHealth(c *gin.Context) {
+	if !startupComplete.Load() {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"status": "starting"})
+		return
+	}
+
+	start := time.Now()
+	probes := a.topologyProbes()
+	deps := make([]DependencyHealth, len(probes))
+
+	var wg sync.WaitGroup
+	for i, p := range probes {
+		wg.Add(1)
+		go func(i int, p topologyProbe) {
+			defer wg.Done()
+			deps[i] = runTopologyProbe(c.Request.Context(), p)
+		}(i, p)
+	}
+	wg.Wait()
+
+	status := "ok"
+	for _, dep := range deps {
+		if dep.Status != "up" {
+			status = "degraded"
+		}
+	}
+
+	nodes, edges := topologyGraph(status, deps)
+	c.JSON(http.StatusOK, gin.H{
+		"service":      "order-service",
+		"version":      serviceVersion,
+		"status":       status,
+		"checked_at":   start.UTC(),
+		"duration_ms":  time.Since(start).Milliseconds(),
+		"dependencies": deps,
+		"nodes":        nodes,
+		"edges":        edges,
+	})
+}
+
+// topologyGraph lays out the probe results as Node Graph nodes and edges
+func topologyGraph(status string, deps []DependencyHealth) ([]gin.H, []gin.H) {
+	arcs := func(s string) gin.H {
+		node := gin.H{"arc__up": 0.0, "arc__degraded": 0.0, "arc__down": 0.0}
+		switch s {
+		case "up", "ok":
+			node["arc__up"] = 1.0
+		case "degraded":
+			node["arc__degraded"] = 1.0
+		default:
+			node["arc__down"] = 1.0
+		}
+		return node
+	}
+
+	self := arcs(status)
+	self["id"] = "order-service"
+	self["title"] = "order-service"
+	self["subtitle"] = serviceVersion
+	self["mainstat"] = status
+	nodes := []gin.H{self}
+	edges := make([]gin.H, 0, len(deps))
+
+	for _, dep := range deps {
+		node := arcs(dep.Status)
+		node["id"] = dep.Name
+		node["title"] = dep.Name
+		node["subtitle"] = strings.TrimSpace(dep.Kind + " " + dep.Version)
+		node["mainstat"] = dep.Status
+		node["secondarystat"] = fmt.Sprintf("%.1f ms", dep.LatencyMs)
+		nodes = append(nodes, node)
+
+		edges = append(edges, gin.H{
+			"id":       "order-service->" + dep.Name,
+			"source":   "order-service",
+			"target":   dep.Name,
+			"mainstat": fmt.Sprintf("%.1f ms", dep.LatencyMs),
+		})
+	}
+	return nodes, edges
+}
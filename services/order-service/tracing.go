@@ -0,0 +1,742 @@
+//go:build !otel
+
+// =============================================================================
+// DISTRIBUTED TRACING
+// =============================================================================
+// Spans of every request and of the calls it makes, exported with OTLP/HTTP
+// (JSON encoding) to Tempo, Jaeger or an OpenTelemetry Collector. By
+// default the service speaks OTLP itself; spans follow the OpenTelemetry
+// semantic conventions, so they look the same in Tempo as spans of
+// SDK-instrumented services. Built with -tags otel, the OpenTelemetry SDK
+// and its contrib instrumentation are used instead (see tracing_otel.go),
+// with the same settings.
+//
+//   OTEL_EXPORTER_OTLP_ENDPOINT   Collector base URL, e.g. http://tempo:4318
+//                                 (spans go to <endpoint>/v1/traces).
+//                                 Empty disables tracing.
+//   OTEL_EXPORTER_OTLP_HEADERS    Extra export headers, "key=value,key=value"
+//   OTEL_SERVICE_NAME             service.name of the spans
+//   OTEL_TRACES_SAMPLER_ARG       Share (0-1) of traces kept (default 1)
+//
+// SPANS:
+// - server     Every Gin handler, named "METHOD /route" (health checks and
+//              /metrics excluded)
+// - client     PostgreSQL queries and exec statements, Redis commands and
+//              pipelines, HTTP calls to the other services
+// - producer   RabbitMQ publishes
+//
+// Client spans are only recorded inside a trace, i.e. for calls made with
+// the context of a request (or another span): connection checks and
+// background polling don't start traces of their own. Publishes do, since
+// events are sent by the publish workers after the request has returned.
+// Requests from traced callers continue their trace, and calls to other
+// services carry it on (see propagation.go).
+//
+// EXEMPLARS:
+// http_request_duration_seconds and order_processing_duration_seconds
+// observations made in a sampled trace carry its ID as a trace_id exemplar,
+// so Grafana can jump from a latency spike to one of the traces behind it.
+// /metrics serves exemplars to scrapers asking for OpenMetrics; Prometheus
+// needs --enable-feature=exemplar-storage to keep them.
+//
+// Spans are exported in batches every 5s or every 512 spans. When the
+// collector can't keep up, spans are dropped rather than slowing requests
+// down. Remaining spans are flushed on shutdown.
+//
+// METRICS:
+// - tracing_spans_exported_total{result}   exported, failed
+// - tracing_spans_dropped_total            Spans dropped on a full queue
+// =============================================================================
+
+package main
+
+import (
+	"bytes"
+	"context"
+	crand "crypto/rand"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/v8"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// tracingLog is the logger of the tracer
+var tracingLog = newLogger("tracing")
+
+const (
+	// traceQueueSize is the number of finished spans waiting for export
+	traceQueueSize = 4096
+
+	// traceBatchSize is the largest number of spans per export request
+	traceBatchSize = 512
+
+	// traceExportInterval is how often queued spans are exported
+	traceExportInterval = 5 * time.Second
+
+	// traceStatementLimit caps the length of recorded SQL statements
+	traceStatementLimit = 2000
+)
+
+// spanKind is the OTLP kind of a span
+type spanKind int
+
+const (
+	spanKindServer   spanKind = 2
+	spanKindClient   spanKind = 3
+	spanKindProducer spanKind = 4
+	spanKindConsumer spanKind = 5
+)
+
+// untracedPaths are endpoints polled too often to be worth a trace
+var untracedPaths = map[string]bool{
+	"/health":  true,
+	"/ready":   true,
+	"/startup": true,
+	"/live":    true,
+	"/metrics": true,
+}
+
+var (
+	// tracer exports finished spans, nil while tracing is disabled
+	tracer *spanExporter
+
+	// Counter: Exported spans by result
+	tracingSpansExportedTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "tracing_spans_exported_total",
+			Help: "Total number of spans sent to the OTLP endpoint, by result (exported, failed)",
+		},
+		[]string{"result"},
+	)
+
+	// Counter: Spans dropped because the export queue was full
+	tracingSpansDroppedTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "tracing_spans_dropped_total",
+			Help: "Total number of finished spans dropped because the export queue was full",
+		},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(tracingSpansExportedTotal)
+	prometheus.MustRegister(tracingSpansDroppedTotal)
+}
+
+// =============================================================================
+// SPANS
+// =============================================================================
+
+// spanContext identifies a span within its trace
+type spanContext struct {
+	traceID [16]byte
+	spanID  [8]byte
+	sampled bool
+
+	// state is the W3C tracestate, handed on untouched
+	state string
+
+	// deferred is set on B3 callers that left sampling to this service
+	deferred bool
+}
+
+// Span is an operation within a trace. All methods are safe on a nil span,
+// which is what startSpan returns while tracing is disabled.
+type Span struct {
+	mu sync.Mutex
+
+	sc       spanContext
+	parentID [8]byte
+	name     string
+	kind     spanKind
+	start    time.Time
+	end      time.Time
+	attrs    map[string]interface{}
+	failed   bool
+	message  string
+	ended    bool
+}
+
+// spanContextKey stores the current span in a context
+type spanContextKey struct{}
+
+// spanFromContext returns the current span of a context, or nil
+func spanFromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(spanContextKey{}).(*Span)
+	return span
+}
+
+// startSpan starts a span as a child of the context's span, as a child of
+// the caller's span (see propagation.go), or as the root of a new trace
+func startSpan(ctx context.Context, name string, kind spanKind) (context.Context, *Span) {
+	if tracer == nil {
+		return ctx, nil
+	}
+	span := &Span{name: name, kind: kind, start: time.Now(), attrs: map[string]interface{}{}}
+	crand.Read(span.sc.spanID[:])
+	if parent := spanFromContext(ctx); parent != nil {
+		span.sc.traceID = parent.sc.traceID
+		span.sc.sampled = parent.sc.sampled
+		span.sc.state = parent.sc.state
+		span.parentID = parent.sc.spanID
+	} else if remote, ok := remoteSpanFromContext(ctx); ok {
+		span.sc.traceID = remote.traceID
+		span.sc.sampled = remote.sampled
+		if remote.deferred {
+			span.sc.sampled = tracer.sample(remote.traceID)
+		}
+		span.sc.state = remote.state
+		span.parentID = remote.spanID
+	} else {
+		crand.Read(span.sc.traceID[:])
+		span.sc.sampled = tracer.sample(span.sc.traceID)
+	}
+	return context.WithValue(ctx, spanContextKey{}, span), span
+}
+
+// startChildSpan starts a span only if the context is part of a trace
+func startChildSpan(ctx context.Context, name string, kind spanKind) (context.Context, *Span) {
+	if spanFromContext(ctx) == nil {
+		return ctx, nil
+	}
+	return startSpan(ctx, name, kind)
+}
+
+// SetAttr records an attribute of the span
+func (s *Span) SetAttr(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.attrs[key] = value
+	s.mu.Unlock()
+}
+
+// SetError marks the span as failed
+func (s *Span) SetError(message string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.failed = true
+	s.message = message
+	s.mu.Unlock()
+}
+
+// End finishes the span and queues it for export if its trace is sampled
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.end = time.Now()
+	s.mu.Unlock()
+
+	if s.sc.sampled {
+		tracer.enqueue(s)
+	}
+}
+
+// =============================================================================
+// OTLP EXPORT
+// =============================================================================
+
+// spanExporter batches finished spans and posts them to the OTLP endpoint
+type spanExporter struct {
+	url         string
+	headers     map[string]string
+	serviceName string
+	ratio       float64
+	client      *http.Client
+
+	mu     sync.RWMutex
+	closed bool
+	queue  chan *Span
+	done   chan struct{}
+}
+
+// startTracing validates the tracing settings and starts the exporter.
+// An empty endpoint leaves tracing disabled.
+func startTracing(endpoint, headers, serviceName string, ratio float64) error {
+	if endpoint == "" {
+		return nil
+	}
+	if !strings.HasPrefix(endpoint, "http://") && !strings.HasPrefix(endpoint, "https://") {
+		return fmt.Errorf("invalid OTEL_EXPORTER_OTLP_ENDPOINT %q, expected an http(s) URL", endpoint)
+	}
+	if ratio < 0 || ratio > 1 {
+		return fmt.Errorf("invalid OTEL_TRACES_SAMPLER_ARG %v, must be between 0 and 1", ratio)
+	}
+	exportHeaders := map[string]string{}
+	for _, pair := range strings.Split(headers, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(pair, "=")
+		if !ok {
+			return fmt.Errorf("invalid OTEL_EXPORTER_OTLP_HEADERS entry %q, expected key=value", pair)
+		}
+		exportHeaders[strings.TrimSpace(key)] = strings.TrimSpace(value)
+	}
+
+	tracer = &spanExporter{
+		url:         strings.TrimSuffix(endpoint, "/") + "/v1/traces",
+		headers:     exportHeaders,
+		serviceName: serviceName,
+		ratio:       ratio,
+		client:      &http.Client{Timeout: 10 * time.Second},
+		queue:       make(chan *Span, traceQueueSize),
+		done:        make(chan struct{}),
+	}
+	go tracer.run()
+	onShutdown(phaseConnections, "tracing", tracer.shutdown)
+
+	tracingLog.Info("Tracing enabled", "endpoint", tracer.url, "service_name", serviceName, "sample_ratio", ratio)
+	return nil
+}
+
+// sample decides from the trace ID whether a new trace is kept, so every
+// service sampling with the same ratio keeps the same traces
+func (e *spanExporter) sample(traceID [16]byte) bool {
+	if e.ratio >= 1 {
+		return true
+	}
+	bound := uint64(e.ratio * (1 << 63))
+	return binary.BigEndian.Uint64(traceID[8:])>>1 < bound
+}
+
+// enqueue hands a finished span to the export loop, dropping it if the
+// queue is full or the exporter has shut down
+func (e *spanExporter) enqueue(span *Span) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	if e.closed {
+		return
+	}
+	select {
+	case e.queue <- span:
+	default:
+		tracingSpansDroppedTotal.Inc()
+	}
+}
+
+// run exports queued spans in batches until the queue is closed
+func (e *spanExporter) run() {
+	defer close(e.done)
+	ticker := time.NewTicker(traceExportInterval)
+	defer ticker.Stop()
+
+	batch := make([]*Span, 0, traceBatchSize)
+	for {
+		select {
+		case span, ok := <-e.queue:
+			if !ok {
+				e.export(batch)
+				return
+			}
+			batch = append(batch, span)
+			if len(batch) >= traceBatchSize {
+				e.export(batch)
+				batch = batch[:0]
+			}
+		case <-ticker.C:
+			e.export(batch)
+			batch = batch[:0]
+		}
+	}
+}
+
+// shutdown stops accepting spans and waits for the last export
+func (e *spanExporter) shutdown(ctx context.Context) error {
+	e.mu.Lock()
+	if !e.closed {
+		e.closed = true
+		close(e.queue)
+	}
+	e.mu.Unlock()
+
+	select {
+	case <-e.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// export posts a batch of spans to the OTLP endpoint
+func (e *spanExporter) export(batch []*Span) {
+	if len(batch) == 0 {
+		return
+	}
+	body, err := json.Marshal(e.payload(batch))
+	if err != nil {
+		tracingSpansExportedTotal.WithLabelValues("failed").Add(float64(len(batch)))
+		return
+	}
+
+	err = func() error {
+		req, err := http.NewRequest(http.MethodPost, e.url, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		for key, value := range e.headers {
+			req.Header.Set(key, value)
+		}
+		resp, err := e.client.Do(req)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 300 {
+			return fmt.Errorf("OTLP endpoint returned status %d", resp.StatusCode)
+		}
+		return nil
+	}()
+	if err != nil {
+		tracingSpansExportedTotal.WithLabelValues("failed").Add(float64(len(batch)))
+		tracingLog.Warn("Failed to export spans", "spans", len(batch), "error", err.Error())
+		return
+	}
+	tracingSpansExportedTotal.WithLabelValues("exported").Add(float64(len(batch)))
+}
+
+// payload builds the OTLP/JSON ExportTraceServiceRequest of a batch
+func (e *spanExporter) payload(batch []*Span) map[string]interface{} {
+	spans := make([]map[string]interface{}, 0, len(batch))
+	for _, s := range batch {
+		s.mu.Lock()
+		span := map[string]interface{}{
+			"traceId":           hex.EncodeToString(s.sc.traceID[:]),
+			"spanId":            hex.EncodeToString(s.sc.spanID[:]),
+			"name":              s.name,
+			"kind":              int(s.kind),
+			"startTimeUnixNano": strconv.FormatInt(s.start.UnixNano(), 10),
+			"endTimeUnixNano":   strconv.FormatInt(s.end.UnixNano(), 10),
+			"attributes":        otlpAttributes(s.attrs),
+		}
+		if s.parentID != [8]byte{} {
+			span["parentSpanId"] = hex.EncodeToString(s.parentID[:])
+		}
+		if s.sc.state != "" {
+			span["traceState"] = s.sc.state
+		}
+		if s.failed {
+			span["status"] = map[string]interface{}{"code": 2, "message": s.message}
+		}
+		s.mu.Unlock()
+		spans = append(spans, span)
+	}
+
+	resource := otlpAttributes(map[string]interface{}{
+		"service.name":    e.serviceName,
+		"service.version": serviceVersion,
+	})
+	return map[string]interface{}{
+		"resourceSpans": []interface{}{map[string]interface{}{
+			"resource": map[string]interface{}{"attributes": resource},
+			"scopeSpans": []interface{}{map[string]interface{}{
+				"scope": map[string]interface{}{"name": "order-service", "version": serviceVersion},
+				"spans": spans,
+			}},
+		}},
+	}
+}
+
+// otlpAttributes converts attributes to OTLP KeyValues
+func otlpAttributes(attrs map[string]interface{}) []interface{} {
+	out := make([]interface{}, 0, len(attrs))
+	for key, value := range attrs {
+		var v map[string]interface{}
+		switch t := value.(type) {
+		case string:
+			v = map[string]interface{}{"stringValue": t}
+		case bool:
+			v = map[string]interface{}{"boolValue": t}
+		case int:
+			v = map[string]interface{}{"intValue": strconv.Itoa(t)}
+		case int64:
+			v = map[string]interface{}{"intValue": strconv.FormatInt(t, 10)}
+		case float64:
+			v = map[string]interface{}{"doubleValue": t}
+		default:
+			v = map[string]interface{}{"stringValue": fmt.Sprint(t)}
+		}
+		out = append(out, map[string]interface{}{"key": key, "value": v})
+	}
+	return out
+}
+
+// =============================================================================
+// INSTRUMENTATION
+// =============================================================================
+
+// tracingMiddleware starts a server span for every request, continuing
+// the caller's trace
+func tracingMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if untracedPaths[c.Request.URL.Path] {
+			c.Next()
+			return
+		}
+		if remote, ok := extractTraceContext(c.Request.Header.Get); ok {
+			c.Request = c.Request.WithContext(contextWithRemoteSpan(c.Request.Context(), remote))
+		}
+		if tracer == nil {
+			c.Next()
+			return
+		}
+
+		route := c.FullPath()
+		name := c.Request.Method + " " + route
+		if route == "" {
+			name = c.Request.Method
+		}
+		ctx, span := startSpan(c.Request.Context(), name, spanKindServer)
+		c.Request = c.Request.WithContext(ctx)
+		span.SetAttr("http.request.method", c.Request.Method)
+		span.SetAttr("http.route", route)
+		span.SetAttr("url.path", c.Request.URL.Path)
+		span.SetAttr("client.address", c.ClientIP())
+		span.SetAttr("user_agent.original", c.Request.UserAgent())
+
+		c.Next()
+
+		status := responseStatus(c)
+		span.SetAttr("http.response.status_code", status)
+		if status >= http.StatusInternalServerError {
+			span.SetError(http.StatusText(status))
+		}
+		span.End()
+	}
+}
+
+// observeWithExemplar records an observation, with the ID of the context's
+// trace as exemplar if the trace is sampled
+func observeWithExemplar(ctx context.Context, observer prometheus.Observer, value float64) {
+	if sc, ok := propagatedSpan(ctx); ok && sc.sampled {
+		if eo, ok := observer.(prometheus.ExemplarObserver); ok {
+			eo.ObserveWithExemplar(value, prometheus.Labels{"trace_id": hex.EncodeToString(sc.traceID[:])})
+			return
+		}
+	}
+	observer.Observe(value)
+}
+
+// openTracedDB opens a connection pool whose statements are traced
+func openTracedDB(connector driver.Connector) *sql.DB {
+	return sql.OpenDB(tracingConnector{connector})
+}
+
+// tracingConnector adds client spans to the queries of its connections
+type tracingConnector struct {
+	driver.Connector
+}
+
+func (c tracingConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.Connector.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &tracingConn{conn}, nil
+}
+
+// tracingConn records a span for every query and exec statement made
+// inside a trace
+type tracingConn struct {
+	driver.Conn
+}
+
+// startQuerySpan starts the span of an SQL statement
+func startQuerySpan(ctx context.Context, query string) *Span {
+	if spanFromContext(ctx) == nil {
+		return nil
+	}
+	statement := strings.Join(strings.Fields(query), " ")
+	operation, _, _ := strings.Cut(statement, " ")
+	operation = strings.ToUpper(operation)
+
+	_, span := startChildSpan(ctx, storage.Name()+" "+operation, spanKindClient)
+	if len(statement) > traceStatementLimit {
+		statement = statement[:traceStatementLimit]
+	}
+	span.SetAttr("db.system", storage.System())
+	span.SetAttr("db.operation", operation)
+	span.SetAttr("db.statement", statement)
+	return span
+}
+
+// endQuerySpan ends the span of an SQL statement with its error
+func endQuerySpan(span *Span, err error) {
+	if err != nil && err != driver.ErrSkip {
+		span.SetError(err.Error())
+	}
+	span.End()
+}
+
+func (c *tracingConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	if p, ok := c.Conn.(driver.ConnPrepareContext); ok {
+		return p.PrepareContext(ctx, query)
+	}
+	return c.Conn.Prepare(query)
+}
+
+func (c *tracingConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if b, ok := c.Conn.(driver.ConnBeginTx); ok {
+		return b.BeginTx(ctx, opts)
+	}
+	return c.Conn.Begin()
+}
+
+func (c *tracingConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	q, ok := c.Conn.(driver.QueryerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	span := startQuerySpan(ctx, query)
+	rows, err := q.QueryContext(ctx, query, args)
+	endQuerySpan(span, err)
+	return rows, err
+}
+
+func (c *tracingConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	e, ok := c.Conn.(driver.ExecerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	span := startQuerySpan(ctx, query)
+	result, err := e.ExecContext(ctx, query, args)
+	endQuerySpan(span, err)
+	return result, err
+}
+
+func (c *tracingConn) Ping(ctx context.Context) error {
+	if p, ok := c.Conn.(driver.Pinger); ok {
+		return p.Ping(ctx)
+	}
+	return nil
+}
+
+func (c *tracingConn) ResetSession(ctx context.Context) error {
+	if r, ok := c.Conn.(driver.SessionResetter); ok {
+		return r.ResetSession(ctx)
+	}
+	return nil
+}
+
+func (c *tracingConn) IsValid() bool {
+	if v, ok := c.Conn.(driver.Validator); ok {
+		return v.IsValid()
+	}
+	return true
+}
+
+// redisTracingHook returns the hook tracing the commands of a Redis client
+func redisTracingHook() redis.Hook {
+	return tracingHook{}
+}
+
+// redisSpanKey stores the span of a Redis command between the hook calls
+type redisSpanKey struct{}
+
+// tracingHook records a span for every Redis command made inside a trace
+type tracingHook struct{}
+
+func (tracingHook) BeforeProcess(ctx context.Context, cmd redis.Cmder) (context.Context, error) {
+	_, span := startChildSpan(ctx, "redis "+cmd.Name(), spanKindClient)
+	if span == nil {
+		return ctx, nil
+	}
+	span.SetAttr("db.system", "redis")
+	span.SetAttr("db.operation", cmd.Name())
+	return context.WithValue(ctx, redisSpanKey{}, span), nil
+}
+
+func (tracingHook) AfterProcess(ctx context.Context, cmd redis.Cmder) error {
+	if span, ok := ctx.Value(redisSpanKey{}).(*Span); ok {
+		if err := cmd.Err(); err != nil && err != redis.Nil {
+			span.SetError(err.Error())
+		}
+		span.End()
+	}
+	return nil
+}
+
+func (tracingHook) BeforeProcessPipeline(ctx context.Context, cmds []redis.Cmder) (context.Context, error) {
+	_, span := startChildSpan(ctx, "redis pipeline", spanKindClient)
+	if span == nil {
+		return ctx, nil
+	}
+	names := make([]string, 0, len(cmds))
+	for _, cmd := range cmds {
+		names = append(names, cmd.Name())
+	}
+	span.SetAttr("db.system", "redis")
+	span.SetAttr("db.operation", "pipeline")
+	span.SetAttr("db.redis.commands", strings.Join(names, " "))
+	return context.WithValue(ctx, redisSpanKey{}, span), nil
+}
+
+func (tracingHook) AfterProcessPipeline(ctx context.Context, cmds []redis.Cmder) error {
+	if span, ok := ctx.Value(redisSpanKey{}).(*Span); ok {
+		for _, cmd := range cmds {
+			if err := cmd.Err(); err != nil && err != redis.Nil {
+				span.SetError(err.Error())
+				break
+			}
+		}
+		span.End()
+	}
+	return nil
+}
+
+// newTracingTransport returns a transport tracing the calls made with next
+func newTracingTransport(next http.RoundTripper) http.RoundTripper {
+	return tracingTransport{next: next}
+}
+
+// tracingTransport records a span for every HTTP call made inside a trace
+// and propagates the trace to the called service
+type tracingTransport struct {
+	next http.RoundTripper
+}
+
+func (t tracingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx, span := startChildSpan(req.Context(), req.Method, spanKindClient)
+	if span == nil {
+		return t.next.RoundTrip(injectRequest(req))
+	}
+	req = injectRequest(req.WithContext(ctx))
+	span.SetAttr("http.request.method", req.Method)
+	span.SetAttr("server.address", req.URL.Hostname())
+	span.SetAttr("url.full", req.URL.Redacted())
+
+	resp, err := t.next.RoundTrip(req)
+	switch {
+	case err != nil:
+		span.SetError(err.Error())
+	default:
+		span.SetAttr("http.response.status_code", resp.StatusCode)
+		if resp.StatusCode >= http.StatusInternalServerError {
+			span.SetError(http.StatusText(resp.StatusCode))
+		}
+	}
+	span.End()
+	return resp, err
+}
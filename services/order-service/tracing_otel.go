@@ -0,0 +1,467 @@
+//go:build otel
+
+// =============================================================================
+// DISTRIBUTED TRACING (OPENTELEMETRY SDK)
+// =============================================================================
+// Built with -tags otel, tracing uses the OpenTelemetry SDK and the contrib
+// instrumentation instead of the built-in OTLP exporter of tracing.go and
+// propagation.go. The default build (and image, see GO_BUILD_TAGS in the
+// Dockerfile) uses the built-in exporter: the SDK modules aren't pinned in
+// go.mod, so an otel build resolves them when it is made. The settings,
+// span names and attributes the rest of the service relies on stay the
+// same:
+//
+//   OTEL_EXPORTER_OTLP_ENDPOINT   Collector base URL (spans go to
+//                                 <endpoint>/v1/traces through
+//                                 otlptracehttp). Empty disables tracing.
+//   OTEL_EXPORTER_OTLP_HEADERS    Extra export headers, "key=value,key=value"
+//   OTEL_SERVICE_NAME             service.name of the spans
+//   OTEL_TRACES_SAMPLER_ARG       Share (0-1) of new traces kept; callers'
+//                                 sampling decisions are kept as they are
+//   OTEL_PROPAGATORS              tracecontext, b3, b3multi or none
+//
+// INSTRUMENTATION:
+// - server     otelgin, health checks and /metrics excluded
+// - client     otelsql for the storage backend's statements, redisotel for
+//              Redis commands, otelhttp for calls to the other services
+// - producer   RabbitMQ publishes (publisher.go), through startSpan
+//
+// As with the built-in tracer, client spans are only recorded inside a
+// trace, requests abandoned by their client are recorded as 499 (see
+// cancellation.go), and sampled traces are attached as exemplars.
+//
+// Spans are batched by the SDK (every 5s or 512 spans, 4096 queued at
+// most) and flushed on shutdown.
+//
+// METRICS:
+// - tracing_spans_exported_total{result}   exported, failed
+// =============================================================================
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/XSAM/otelsql"
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/extra/redisotel/v8"
+	"github.com/go-redis/redis/v8"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/contrib/propagators/b3"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracingLog is the logger of the tracer
+var tracingLog = newLogger("tracing")
+
+const (
+	// traceQueueSize is the number of finished spans waiting for export
+	traceQueueSize = 4096
+
+	// traceBatchSize is the largest number of spans per export request
+	traceBatchSize = 512
+
+	// traceExportInterval is how often queued spans are exported
+	traceExportInterval = 5 * time.Second
+)
+
+// spanKind is the OpenTelemetry kind of a span
+type spanKind = trace.SpanKind
+
+const (
+	spanKindServer   = trace.SpanKindServer
+	spanKindClient   = trace.SpanKindClient
+	spanKindProducer = trace.SpanKindProducer
+	spanKindConsumer = trace.SpanKindConsumer
+)
+
+// untracedPaths are endpoints polled too often to be worth a trace
+var untracedPaths = map[string]bool{
+	"/health":  true,
+	"/ready":   true,
+	"/startup": true,
+	"/live":    true,
+	"/metrics": true,
+}
+
+var (
+	// tracer starts the spans of the service, nil while tracing is
+	// disabled
+	tracer trace.Tracer
+
+	// tracingServiceName is the service.name of the spans
+	tracingServiceName = "order-service"
+
+	// Counter: Exported spans by result
+	tracingSpansExportedTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "tracing_spans_exported_total",
+			Help: "Total number of spans sent to the OTLP endpoint, by result (exported, failed)",
+		},
+		[]string{"result"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(tracingSpansExportedTotal)
+	otel.SetTextMapPropagator(newPropagator([]string{"tracecontext", "b3multi"}))
+}
+
+// =============================================================================
+// SPANS
+// =============================================================================
+
+// spanContext identifies a span within its trace
+type spanContext struct {
+	traceID [16]byte
+	spanID  [8]byte
+	sampled bool
+
+	// otel is the context the IDs were taken from
+	otel trace.SpanContext
+}
+
+// newSpanContext converts an OpenTelemetry span context
+func newSpanContext(sc trace.SpanContext) spanContext {
+	return spanContext{
+		traceID: [16]byte(sc.TraceID()),
+		spanID:  [8]byte(sc.SpanID()),
+		sampled: sc.IsSampled(),
+		otel:    sc,
+	}
+}
+
+// Span is an operation within a trace. All methods are safe on a nil span,
+// which is what startSpan returns while tracing is disabled.
+type Span struct {
+	span trace.Span
+}
+
+// spanFromContext returns the current recording span of a context, or nil
+func spanFromContext(ctx context.Context) *Span {
+	span := trace.SpanFromContext(ctx)
+	if !span.IsRecording() {
+		return nil
+	}
+	return &Span{span: span}
+}
+
+// startSpan starts a span as a child of the context's span or of the
+// caller's span, or as the root of a new trace
+func startSpan(ctx context.Context, name string, kind spanKind) (context.Context, *Span) {
+	if tracer == nil {
+		return ctx, nil
+	}
+	ctx, span := tracer.Start(ctx, name, trace.WithSpanKind(kind))
+	return ctx, &Span{span: span}
+}
+
+// startChildSpan starts a span only if the context is part of a trace
+func startChildSpan(ctx context.Context, name string, kind spanKind) (context.Context, *Span) {
+	if !trace.SpanContextFromContext(ctx).IsValid() {
+		return ctx, nil
+	}
+	return startSpan(ctx, name, kind)
+}
+
+// SetAttr records an attribute of the span
+func (s *Span) SetAttr(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+	var kv attribute.KeyValue
+	switch t := value.(type) {
+	case string:
+		kv = attribute.String(key, t)
+	case bool:
+		kv = attribute.Bool(key, t)
+	case int:
+		kv = attribute.Int(key, t)
+	case int64:
+		kv = attribute.Int64(key, t)
+	case float64:
+		kv = attribute.Float64(key, t)
+	default:
+		kv = attribute.String(key, fmt.Sprint(t))
+	}
+	s.span.SetAttributes(kv)
+}
+
+// SetError marks the span as failed
+func (s *Span) SetError(message string) {
+	if s == nil {
+		return
+	}
+	s.span.SetStatus(codes.Error, message)
+}
+
+// End finishes the span
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.span.End()
+}
+
+// =============================================================================
+// SDK SETUP
+// =============================================================================
+
+// startTracing validates the tracing settings and starts the tracer
+// provider. An empty endpoint leaves tracing disabled; trace context is
+// still propagated.
+func startTracing(endpoint, headers, serviceName string, ratio float64) error {
+	if endpoint == "" {
+		return nil
+	}
+	if !strings.HasPrefix(endpoint, "http://") && !strings.HasPrefix(endpoint, "https://") {
+		return fmt.Errorf("invalid OTEL_EXPORTER_OTLP_ENDPOINT %q, expected an http(s) URL", endpoint)
+	}
+	if ratio < 0 || ratio > 1 {
+		return fmt.Errorf("invalid OTEL_TRACES_SAMPLER_ARG %v, must be between 0 and 1", ratio)
+	}
+	exportHeaders := map[string]string{}
+	for _, pair := range strings.Split(headers, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(pair, "=")
+		if !ok {
+			return fmt.Errorf("invalid OTEL_EXPORTER_OTLP_HEADERS entry %q, expected key=value", pair)
+		}
+		exportHeaders[strings.TrimSpace(key)] = strings.TrimSpace(value)
+	}
+
+	url := strings.TrimSuffix(endpoint, "/") + "/v1/traces"
+	exporter, err := otlptracehttp.New(context.Background(),
+		otlptracehttp.WithEndpointURL(url),
+		otlptracehttp.WithHeaders(exportHeaders),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create OTLP exporter: %w", err)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(countingExporter{exporter},
+			sdktrace.WithMaxQueueSize(traceQueueSize),
+			sdktrace.WithMaxExportBatchSize(traceBatchSize),
+			sdktrace.WithBatchTimeout(traceExportInterval),
+		),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(ratio))),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL,
+			semconv.ServiceName(serviceName),
+			semconv.ServiceVersion(serviceVersion),
+		)),
+	)
+	otel.SetTracerProvider(provider)
+	tracer = provider.Tracer("order-service", trace.WithInstrumentationVersion(serviceVersion))
+	tracingServiceName = serviceName
+	onShutdown(phaseConnections, "tracing", provider.Shutdown)
+
+	tracingLog.Info("Tracing enabled", "endpoint", url, "service_name", serviceName, "sample_ratio", ratio)
+	return nil
+}
+
+// countingExporter counts the spans exported by the SDK
+type countingExporter struct {
+	sdktrace.SpanExporter
+}
+
+func (e countingExporter) ExportSpans(ctx context.Context, spans []sdktrace.ReadOnlySpan) error {
+	err := e.SpanExporter.ExportSpans(ctx, spans)
+	if err != nil {
+		tracingSpansExportedTotal.WithLabelValues("failed").Add(float64(len(spans)))
+		tracingLog.Warn("Failed to export spans", "spans", len(spans), "error", err.Error())
+		return err
+	}
+	tracingSpansExportedTotal.WithLabelValues("exported").Add(float64(len(spans)))
+	return nil
+}
+
+// =============================================================================
+// PROPAGATION
+// =============================================================================
+
+// setPropagators validates and applies OTEL_PROPAGATORS
+func setPropagators(list string) error {
+	var names []string
+	for _, name := range strings.Split(list, ",") {
+		switch name = strings.TrimSpace(strings.ToLower(name)); name {
+		case "", "none":
+		case "tracecontext", "b3", "b3multi":
+			names = append(names, name)
+		default:
+			return fmt.Errorf("unknown OTEL_PROPAGATORS entry %q (expected tracecontext, b3, b3multi or none)", name)
+		}
+	}
+	otel.SetTextMapPropagator(newPropagator(names))
+	return nil
+}
+
+// newPropagator returns a propagator writing every listed format. A
+// composite propagator extracts in order, later formats overriding earlier
+// ones, so they are added in reverse for the first listed to win.
+func newPropagator(names []string) propagation.TextMapPropagator {
+	var list []propagation.TextMapPropagator
+	for i := len(names) - 1; i >= 0; i-- {
+		switch names[i] {
+		case "tracecontext":
+			list = append(list, propagation.TraceContext{})
+		case "b3":
+			list = append(list, b3.New(b3.WithInjectEncoding(b3.B3SingleHeader)))
+		case "b3multi":
+			list = append(list, b3.New(b3.WithInjectEncoding(b3.B3MultipleHeader)))
+		}
+	}
+	return propagation.NewCompositeTextMapPropagator(list...)
+}
+
+// funcCarrier adapts header accessors to a propagation carrier
+type funcCarrier struct {
+	get func(key string) string
+	set func(key, value string)
+}
+
+func (c funcCarrier) Get(key string) string {
+	if c.get == nil {
+		return ""
+	}
+	return c.get(key)
+}
+
+func (c funcCarrier) Set(key, value string) {
+	if c.set != nil {
+		c.set(key, value)
+	}
+}
+
+func (c funcCarrier) Keys() []string { return nil }
+
+// contextWithRemoteSpan returns a context continuing the trace of a caller
+func contextWithRemoteSpan(ctx context.Context, sc spanContext) context.Context {
+	return trace.ContextWithRemoteSpanContext(ctx, sc.otel)
+}
+
+// propagatedSpan returns the span context to hand on to the services called
+// with a context
+func propagatedSpan(ctx context.Context) (spanContext, bool) {
+	sc := trace.SpanContextFromContext(ctx)
+	if !sc.IsValid() {
+		return spanContext{}, false
+	}
+	return newSpanContext(sc), true
+}
+
+// extractTraceContext reads the caller's span context from headers
+func extractTraceContext(get func(key string) string) (spanContext, bool) {
+	ctx := otel.GetTextMapPropagator().Extract(context.Background(), funcCarrier{get: get})
+	return propagatedSpan(ctx)
+}
+
+// injectTraceContext writes the span context of a context to headers
+func injectTraceContext(ctx context.Context, set func(key, value string)) {
+	otel.GetTextMapPropagator().Inject(ctx, funcCarrier{set: set})
+}
+
+// =============================================================================
+// INSTRUMENTATION
+// =============================================================================
+
+// tracingMiddleware starts a server span for every request with otelgin,
+// continuing the caller's trace
+func tracingMiddleware() gin.HandlerFunc {
+	middleware := otelgin.Middleware(tracingServiceName, otelgin.WithFilter(func(r *http.Request) bool {
+		return !untracedPaths[r.URL.Path]
+	}))
+	return func(c *gin.Context) {
+		// otelgin records the status of the writer
+		writer := c.Writer
+		c.Writer = cancelAwareWriter{ResponseWriter: writer, req: c.Request}
+		middleware(c)
+		c.Writer = writer
+	}
+}
+
+// cancelAwareWriter reports statusClientClosedRequest as the status of
+// requests abandoned by their client
+type cancelAwareWriter struct {
+	gin.ResponseWriter
+	req *http.Request
+}
+
+func (w cancelAwareWriter) Status() int {
+	if cancelled(w.req.Context()) {
+		return statusClientClosedRequest
+	}
+	return w.ResponseWriter.Status()
+}
+
+// observeWithExemplar records an observation, with the ID of the context's
+// trace as exemplar if the trace is sampled
+func observeWithExemplar(ctx context.Context, observer prometheus.Observer, value float64) {
+	if sc, ok := propagatedSpan(ctx); ok && sc.sampled {
+		if eo, ok := observer.(prometheus.ExemplarObserver); ok {
+			eo.ObserveWithExemplar(value, prometheus.Labels{"trace_id": hex.EncodeToString(sc.traceID[:])})
+			return
+		}
+	}
+	observer.Observe(value)
+}
+
+// openTracedDB opens a connection pool whose statements are traced by
+// otelsql, inside a trace only
+func openTracedDB(connector driver.Connector) *sql.DB {
+	return otelsql.OpenDB(connector,
+		otelsql.WithAttributes(attribute.String("db.system", storage.System())),
+		otelsql.WithSpanNameFormatter(func(_ context.Context, method otelsql.Method, query string) string {
+			operation, _, _ := strings.Cut(strings.TrimSpace(query), " ")
+			if operation == "" {
+				return storage.Name() + " " + string(method)
+			}
+			return storage.Name() + " " + strings.ToUpper(operation)
+		}),
+		otelsql.WithSpanOptions(otelsql.SpanOptions{
+			OmitConnResetSession: true,
+			OmitConnPrepare:      true,
+			OmitRows:             true,
+			SpanFilter: func(ctx context.Context, _ otelsql.Method, _ string, _ []driver.NamedValue) bool {
+				return trace.SpanContextFromContext(ctx).IsValid()
+			},
+		}),
+	)
+}
+
+// redisTracingHook returns the hook tracing the commands of a Redis client
+func redisTracingHook() redis.Hook {
+	return redisotel.NewTracingHook(redisotel.WithAttributes(attribute.String("db.system", "redis")))
+}
+
+// newTracingTransport returns a transport tracing the calls made with next
+// inside a trace, and propagating the trace to the called service
+func newTracingTransport(next http.RoundTripper) http.RoundTripper {
+	return otelhttp.NewTransport(next,
+		otelhttp.WithFilter(func(r *http.Request) bool {
+			return trace.SpanContextFromContext(r.Context()).IsValid()
+		}),
+		otelhttp.WithSpanNameFormatter(func(_ string, r *http.Request) string {
+			return r.Method
+		}),
+	)
+}
@@ -0,0 +1,260 @@
+// =============================================================================
+// SERIAL AND LOT TRACKING
+// =============================================================================
+// For recalls, the warehouse records which units went into an order: serial
+// numbers for individually tracked items, lot (batch) codes with a quantity
+// for everything else. Tracking is kept per order item (the shipment line)
+// in order_item_tracking, and replaced as a whole on every PUT, so the
+// warehouse can correct a scan by sending the line again.
+//
+// Serials count as one unit each; serials and lot quantities together may
+// not exceed the item's quantity (a line can be shipped partially tracked,
+// but not over-tracked), and a code can appear only once per item. Items
+// can be tracked once the order is processing, shipped or delivered.
+//
+// Recall lookups go through the order list:
+//
+//   GET /api/v1/orders?serial=SN-00042
+//   GET /api/v1/orders?lot=LOT-2026-41
+//
+// Tracking isn't part of the order's event stream (see eventstore.go); the
+// rows are keyed by item ID, which projection rebuilds keep, and go away
+// with their order.
+//
+// ENDPOINTS:
+// - GET /api/v1/orders/:id/items/:item_id/tracking   Serials and lots of an item
+// - PUT /api/v1/orders/:id/items/:item_id/tracking   {"serials": ["SN-1"],
+//                                                      "lots": [{"code": "L7", "quantity": 3}]}
+//
+// METRICS:
+// - order_item_tracking_codes_total{kind}   serial, lot
+// =============================================================================
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// trackableStatuses are the order statuses whose items can be tracked
+var trackableStatuses = map[string]bool{
+	"processing": true, "shipped": true, "delivered": true,
+}
+
+// LotTracking is a lot code with the number of units taken from it
+type LotTracking struct {
+	Code     string `json:"code" binding:"required,max=100"`
+	Quantity int    `json:"quantity" binding:"required,min=1"`
+}
+
+// ItemTracking is the serial and lot tracking of an order item
+type ItemTracking struct {
+	OrderID  string        `json:"order_id"`
+	ItemID   string        `json:"item_id"`
+	SKU      string        `json:"sku"`
+	Quantity int           `json:"quantity"`
+	Tracked  int           `json:"tracked"`
+	Serials  []string      `json:"serials"`
+	Lots     []LotTracking `json:"lots"`
+}
+
+// Counter: Serial and lot codes recorded
+var trackingCodesTotal = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "order_item_tracking_codes_total",
+		Help: "Total number of serial numbers and lot codes recorded on order items",
+	},
+	[]string{"kind"},
+)
+
+func init() {
+	prometheus.MustRegister(trackingCodesTotal)
+}
+
+// loadItemTracking returns the tracking of an order item, or sql.ErrNoRows
+// if the order has no such item
+func (a *App) loadItemTracking(ctx context.Context, orderID, itemID string) (*ItemTracking, error) {
+	t := &ItemTracking{OrderID: orderID, ItemID: itemID, Serials: []string{}, Lots: []LotTracking{}}
+	err := a.db.QueryRowContext(ctx, `
+		SELECT sku, quantity FROM order_items WHERE id = $1 AND order_id = $2
+	`, itemID, orderID).Scan(&t.SKU, &t.Quantity)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := a.db.QueryContext(ctx, `
+		SELECT kind, code, quantity FROM order_item_tracking
+		WHERE item_id = $1 ORDER BY kind, code
+	`, itemID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var kind string
+		var lot LotTracking
+		if err := rows.Scan(&kind, &lot.Code, &lot.Quantity); err != nil {
+			return nil, err
+		}
+		if kind == "serial" {
+			t.Serials = append(t.Serials, lot.Code)
+		} else {
+			t.Lots = append(t.Lots, lot)
+		}
+		t.Tracked += lot.Quantity
+	}
+	return t, rows.Err()
+}
+
+// getItemTracking returns the serials and lots of an order item
+func (a *App) getItemTracking(c *gin.Context) {
+	orderID, itemID := c.Param("id"), c.Param("item_id")
+	if !uuidPattern.MatchString(orderID) || !uuidPattern.MatchString(itemID) {
+		c.JSON(http.StatusNotFound, gin.H{"error": tr(c, "Order item not found")})
+		return
+	}
+
+	t, err := a.loadItemTracking(c.Request.Context(), orderID, itemID)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": tr(c, "Order item not found")})
+		return
+	}
+	if err != nil {
+		logErrorContext(c.Request.Context(), "Failed to load item tracking", map[string]interface{}{
+			"order_id": orderID,
+			"item_id":  itemID,
+			"error":    err.Error(),
+		})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": tr(c, "Database error")})
+		return
+	}
+	c.JSON(http.StatusOK, t)
+}
+
+// setItemTracking replaces the serials and lots of an order item
+func (a *App) setItemTracking(c *gin.Context) {
+	orderID, itemID := c.Param("id"), c.Param("item_id")
+	if !uuidPattern.MatchString(orderID) || !uuidPattern.MatchString(itemID) {
+		c.JSON(http.StatusNotFound, gin.H{"error": tr(c, "Order item not found")})
+		return
+	}
+
+	var req struct {
+		Serials []string      `json:"serials" binding:"dive,required,max=100"`
+		Lots    []LotTracking `json:"lots" binding:"dive"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Codes are unique per item, serials count one unit each
+	tracked := len(req.Serials)
+	seen := map[string]bool{}
+	for _, serial := range req.Serials {
+		if seen["serial "+serial] {
+			c.JSON(http.StatusBadRequest, gin.H{"error": tr(c, "Duplicate code %q", serial)})
+			return
+		}
+		seen["serial "+serial] = true
+	}
+	for _, lot := range req.Lots {
+		if seen["lot "+lot.Code] {
+			c.JSON(http.StatusBadRequest, gin.H{"error": tr(c, "Duplicate code %q", lot.Code)})
+			return
+		}
+		seen["lot "+lot.Code] = true
+		tracked += lot.Quantity
+	}
+
+	ctx := c.Request.Context()
+	tx, err := a.db.BeginTx(ctx, nil)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": tr(c, "Database error")})
+		return
+	}
+	defer tx.Rollback()
+
+	// Lock the item so concurrent scans of the same line don't interleave
+	var quantity int
+	var status string
+	err = tx.QueryRowContext(ctx, `
+		SELECT i.quantity, o.status
+		FROM order_items i JOIN orders o ON o.id = i.order_id
+		WHERE i.id = $1 AND i.order_id = $2
+		FOR UPDATE OF i
+	`, itemID, orderID).Scan(&quantity, &status)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": tr(c, "Order item not found")})
+		return
+	}
+	if err != nil {
+		logErrorContext(c.Request.Context(), "Failed to load order item for tracking", map[string]interface{}{
+			"order_id": orderID,
+			"item_id":  itemID,
+			"error":    err.Error(),
+		})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": tr(c, "Database error")})
+		return
+	}
+	if !trackableStatuses[status] {
+		c.JSON(http.StatusConflict, gin.H{
+			"error":  tr(c, "Items can only be tracked once the order is processing, shipped or delivered"),
+			"status": status,
+		})
+		return
+	}
+	if tracked > quantity {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{
+			"error":    tr(c, "%d tracked units exceed the item quantity of %d", tracked, quantity),
+			"tracked":  tracked,
+			"quantity": quantity,
+		})
+		return
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM order_item_tracking WHERE item_id = $1`, itemID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": tr(c, "Database error")})
+		return
+	}
+	insert := `INSERT INTO order_item_tracking (order_id, item_id, kind, code, quantity) VALUES ($1, $2, $3, $4, $5)`
+	for _, serial := range req.Serials {
+		if _, err := tx.ExecContext(ctx, insert, orderID, itemID, "serial", serial, 1); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": tr(c, "Database error")})
+			return
+		}
+	}
+	for _, lot := range req.Lots {
+		if _, err := tx.ExecContext(ctx, insert, orderID, itemID, "lot", lot.Code, lot.Quantity); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": tr(c, "Database error")})
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		logErrorContext(c.Request.Context(), "Failed to save item tracking", map[string]interface{}{
+			"order_id": orderID,
+			"item_id":  itemID,
+			"error":    err.Error(),
+		})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": tr(c, "Database error")})
+		return
+	}
+
+	trackingCodesTotal.WithLabelValues("serial").Add(float64(len(req.Serials)))
+	trackingCodesTotal.WithLabelValues("lot").Add(float64(len(req.Lots)))
+	logInfoContext(c.Request.Context(), "Item tracking updated", map[string]interface{}{
+		"order_id": orderID,
+		"item_id":  itemID,
+		"serials":  len(req.Serials),
+		"lots":     len(req.Lots),
+		"tracked":  tracked,
+		"quantity": quantity,
+	})
+
+	a.getItemTracking(c)
+}